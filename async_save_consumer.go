@@ -0,0 +1,164 @@
+package savior
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// AsyncSaveConsumer wraps a SaveConsumer whose Save is slow (persisting
+// checkpoints over the network, say), and calls it from a goroutine of
+// its own, so extraction goes on in the meantime.
+//
+// Since extractors keep updating their checkpoint after Save returns, each
+// one is copied first, and checkpoints with large windows add up quickly.
+// At most `maxPending` of them are held at once (queued, or being saved):
+// past that, ShouldSave returns false, so no new checkpoints are made until
+// one is saved, and if one is made anyway, Save blocks until there's room.
+//
+// The inner consumer's Save is called from another goroutine than its
+// ShouldSave, which must be safe. If its Save returns AfterSaveStop or an
+// error, the next Save does (and ShouldSave returns true so that there's
+// a next Save soon). Close must be called once extraction is done.
+type AsyncSaveConsumer struct {
+	inner SaveConsumer
+
+	// one slot per pending checkpoint
+	slots chan struct{}
+	queue chan *ExtractorCheckpoint
+	done  chan struct{}
+	// one count per pending checkpoint, for Flush
+	pending sync.WaitGroup
+
+	mu sync.Mutex
+	// bytes passed to ShouldSave while we couldn't take more checkpoints
+	pendingBytes int64
+	stop         bool
+	err          error
+}
+
+var _ SaveConsumer = (*AsyncSaveConsumer)(nil)
+
+// NewAsyncSaveConsumer returns a consumer that saves checkpoints with
+// `inner` in the background, holding at most `maxPending` of them
+// (at least 1).
+func NewAsyncSaveConsumer(inner SaveConsumer, maxPending int) *AsyncSaveConsumer {
+	if maxPending < 1 {
+		maxPending = 1
+	}
+
+	asc := &AsyncSaveConsumer{
+		inner: inner,
+		slots: make(chan struct{}, maxPending),
+		queue: make(chan *ExtractorCheckpoint, maxPending),
+		done:  make(chan struct{}),
+	}
+	go asc.work()
+	return asc
+}
+
+func (asc *AsyncSaveConsumer) work() {
+	defer close(asc.done)
+
+	for checkpoint := range asc.queue {
+		action, err := asc.inner.Save(checkpoint)
+
+		asc.mu.Lock()
+		if err != nil && asc.err == nil {
+			asc.err = err
+		}
+		if action == AfterSaveStop {
+			asc.stop = true
+		}
+		asc.mu.Unlock()
+
+		<-asc.slots
+		asc.pending.Done()
+	}
+}
+
+func (asc *AsyncSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	asc.mu.Lock()
+	defer asc.mu.Unlock()
+
+	if asc.stop || asc.err != nil {
+		return true
+	}
+
+	asc.pendingBytes += copiedBytes
+	if len(asc.slots) == cap(asc.slots) {
+		// too many checkpoints in flight already
+		return false
+	}
+
+	copiedBytes = asc.pendingBytes
+	asc.pendingBytes = 0
+	return asc.inner.ShouldSave(copiedBytes)
+}
+
+// Save queues a copy of the checkpoint, blocking if `maxPending` checkpoints
+// are pending already. It returns what the inner consumer's Save returned
+// for a previous checkpoint, if that was AfterSaveStop or an error.
+func (asc *AsyncSaveConsumer) Save(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error) {
+	asc.mu.Lock()
+	stop, err := asc.stop, asc.err
+	asc.mu.Unlock()
+	if err != nil {
+		return AfterSaveStop, err
+	}
+	if stop {
+		return AfterSaveStop, nil
+	}
+
+	c, err := copyCheckpoint(checkpoint)
+	if err != nil {
+		return AfterSaveContinue, err
+	}
+
+	asc.slots <- struct{}{}
+	asc.pending.Add(1)
+	asc.queue <- c
+	return AfterSaveContinue, nil
+}
+
+// Flush waits for pending checkpoints to be saved, after which Save
+// returns whatever they made the inner consumer say. It must be called
+// from the same goroutine as Save.
+func (asc *AsyncSaveConsumer) Flush() {
+	asc.pending.Wait()
+}
+
+// Pending returns how many checkpoints are queued or being saved
+func (asc *AsyncSaveConsumer) Pending() int {
+	return len(asc.slots)
+}
+
+// Close waits for pending checkpoints to be saved, and returns the
+// first error the inner consumer's Save returned, if any. The consumer
+// can't be used afterwards.
+func (asc *AsyncSaveConsumer) Close() error {
+	close(asc.queue)
+	<-asc.done
+
+	asc.mu.Lock()
+	defer asc.mu.Unlock()
+	return asc.err
+}
+
+// copyCheckpoint makes a deep copy of a checkpoint, through encoding/gob
+func copyCheckpoint(c *ExtractorCheckpoint) (*ExtractorCheckpoint, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "copying checkpoint")
+	}
+
+	res := &ExtractorCheckpoint{}
+	err = gob.NewDecoder(buf).Decode(res)
+	if err != nil {
+		return nil, errors.Wrap(err, "copying checkpoint")
+	}
+	return res, nil
+}
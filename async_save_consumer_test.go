@@ -0,0 +1,155 @@
+package savior_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowSaveConsumer takes its time saving checkpoints. ShouldSave and
+// Save don't share any state, as AsyncSaveConsumer requires.
+type slowSaveConsumer struct {
+	counter   int64
+	threshold int64
+	delay     time.Duration
+	onSave    func(checkpoint *savior.ExtractorCheckpoint) savior.AfterSaveAction
+
+	mu    sync.Mutex
+	saved []*savior.ExtractorCheckpoint
+}
+
+func (ssc *slowSaveConsumer) ShouldSave(n int64) bool {
+	ssc.counter += n
+	if ssc.counter > ssc.threshold {
+		ssc.counter = 0
+		return true
+	}
+	return false
+}
+
+func (ssc *slowSaveConsumer) Save(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	time.Sleep(ssc.delay)
+
+	ssc.mu.Lock()
+	ssc.saved = append(ssc.saved, checkpoint)
+	ssc.mu.Unlock()
+
+	if ssc.onSave != nil {
+		return ssc.onSave(checkpoint), nil
+	}
+	return savior.AfterSaveContinue, nil
+}
+
+// pendingSampler records how many checkpoints an AsyncSaveConsumer
+// holds after each Save
+type pendingSampler struct {
+	*savior.AsyncSaveConsumer
+	maxPending int
+}
+
+func (ps *pendingSampler) Save(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	action, err := ps.AsyncSaveConsumer.Save(checkpoint)
+	if p := ps.Pending(); p > ps.maxPending {
+		ps.maxPending = p
+	}
+	return action, err
+}
+
+func Test_AsyncSaveConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+
+	inner := &slowSaveConsumer{
+		threshold: 64 * 1024,
+		delay:     20 * time.Millisecond,
+	}
+	asc := savior.NewAsyncSaveConsumer(inner, 2)
+	sampler := &pendingSampler{AsyncSaveConsumer: asc}
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	tmust(t, err)
+	ex.SetSaveConsumer(sampler)
+	_, err = ex.Resume(nil, sink)
+	tmust(t, err)
+	tmust(t, asc.Close())
+	tmust(t, sink.Validate())
+
+	assert.NotEmpty(inner.saved)
+	assert.True(sampler.maxPending <= 2, "at most 2 checkpoints should be pending, got %d", sampler.maxPending)
+	assert.True(sampler.maxPending > 0)
+
+	// checkpoints were copied: they don't all end up
+	// looking like the extractor's last state
+	for i := 1; i < len(inner.saved); i++ {
+		assert.True(inner.saved[i].Progress >= inner.saved[i-1].Progress)
+	}
+	assert.True(inner.saved[0].Progress < inner.saved[len(inner.saved)-1].Progress)
+}
+
+// drainingSaveConsumer waits for each checkpoint to be saved, so that
+// the extraction can't be over before the inner consumer says to stop
+type drainingSaveConsumer struct {
+	*savior.AsyncSaveConsumer
+}
+
+func (dsc *drainingSaveConsumer) Save(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	action, err := dsc.AsyncSaveConsumer.Save(checkpoint)
+	dsc.Flush()
+	return action, err
+}
+
+func Test_AsyncSaveConsumerStop(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+
+	var c *savior.ExtractorCheckpoint
+	numStops := 0
+	for {
+		inner := &slowSaveConsumer{
+			threshold: 256 * 1024,
+			delay:     5 * time.Millisecond,
+			onSave: func(checkpoint *savior.ExtractorCheckpoint) savior.AfterSaveAction {
+				if c != nil {
+					// only stop once
+					return savior.AfterSaveContinue
+				}
+				return savior.AfterSaveStop
+			},
+		}
+		asc := savior.NewAsyncSaveConsumer(inner, 1)
+
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		ex.SetSaveConsumer(&drainingSaveConsumer{asc})
+		_, err = ex.Resume(c, sink)
+		tmust(t, asc.Close())
+		if errors.Cause(err) == savior.ErrStop {
+			numStops++
+			if numStops > 1 {
+				t.Fatal("stopped more than once")
+			}
+			// resume from what the inner consumer stopped at, not from
+			// wherever the extractor noticed it had to stop
+			if !assert.Len(inner.saved, 1) {
+				return
+			}
+			c = inner.saved[0]
+			continue
+		}
+		tmust(t, err)
+		break
+	}
+	assert.EqualValues(1, numStops)
+	tmust(t, sink.Validate())
+}
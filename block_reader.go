@@ -0,0 +1,124 @@
+package savior
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// A Block is a fixed-size part of a source's output, see BlockReader
+type Block struct {
+	// Offset is the position of the block in the source's output
+	Offset int64
+	// Data belongs to whoever got the block, the reader doesn't reuse it.
+	// It's BlockSize bytes long, except for the last block.
+	Data []byte
+
+	// Checkpoint is the latest checkpoint the source emitted before
+	// the block, nil if there wasn't any. Resuming a BlockReader with it
+	// starts reading at this block (or an earlier one).
+	Checkpoint *SourceCheckpoint
+}
+
+// BlockReader reads a source in fixed-size blocks, tagged with their
+// offset, so that they can be processed out of order, by several
+// goroutines at once (hashing, writing to different parts of a file...).
+// Since blocks carry a checkpoint, once every block up to a given one
+// is processed, reading can be resumed from that block.
+type BlockReader struct {
+	source    Source
+	blockSize int64
+
+	// output offset of the next block
+	offset int64
+
+	// latest checkpoint emitted by the source
+	checkpoint *SourceCheckpoint
+}
+
+// NewBlockReader returns a reader for blocks of `blockSize` bytes. It
+// becomes the source's SourceSaveConsumer, and asks it for a checkpoint
+// at the start of each block. Sources that can only save on their own
+// boundaries (like decompressors) save whenever they get to one.
+func NewBlockReader(source Source, blockSize int64) *BlockReader {
+	br := &BlockReader{
+		source:    source,
+		blockSize: blockSize,
+	}
+	source.SetSourceSaveConsumer(&CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *SourceCheckpoint) error {
+			br.checkpoint = checkpoint
+			return nil
+		},
+	})
+	return br
+}
+
+// BlockSize returns the size of blocks, in bytes
+func (br *BlockReader) BlockSize() int64 {
+	return br.blockSize
+}
+
+// Resume resumes the source from a checkpoint (usually that of a Block),
+// and returns the offset of the first block Next will return. That's the
+// first block that starts at or after the checkpoint.
+func (br *BlockReader) Resume(checkpoint *SourceCheckpoint) (int64, error) {
+	if br.blockSize <= 0 {
+		return 0, errors.Errorf("savior: invalid block size %d", br.blockSize)
+	}
+
+	offset, err := br.source.Resume(checkpoint)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	br.checkpoint = nil
+	if checkpoint != nil && checkpoint.OutputOffset == offset {
+		br.checkpoint = checkpoint
+	}
+
+	// blocks always start at a multiple of the block size
+	br.offset = (offset + br.blockSize - 1) / br.blockSize * br.blockSize
+	if br.offset > offset {
+		err = DiscardByRead(br.source, br.offset-offset)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+	return br.offset, nil
+}
+
+// Next returns the next block, or io.EOF once the source is exhausted
+func (br *BlockReader) Next() (*Block, error) {
+	block := &Block{
+		Offset: br.offset,
+	}
+	previous := br.checkpoint
+	br.source.WantSave()
+
+	buf := make([]byte, br.blockSize)
+	n, err := io.ReadFull(br.source, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if n == 0 && err == nil {
+		err = io.EOF
+	}
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	// sources that can save anywhere did so before reading the block,
+	// others may have saved somewhere in the middle, which is only good
+	// for the blocks after this one
+	block.Checkpoint = previous
+	if c := br.checkpoint; c != nil && c.OutputOffset <= block.Offset {
+		block.Checkpoint = c
+	}
+
+	block.Data = buf[:n]
+	br.offset += int64(n)
+	return block, nil
+}
@@ -0,0 +1,104 @@
+package savior_test
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/flatesource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BlockReader(t *testing.T) {
+	reference := semirandom.Bytes(3*1024*1024 + 1234)
+	compressed, err := checker.FlateCompress(reference)
+	tmust(t, err)
+
+	sources := map[string]func() savior.Source{
+		"seeksource": func() savior.Source {
+			return seeksource.FromBytes(reference)
+		},
+		"flatesource": func() savior.Source {
+			return flatesource.New(seeksource.FromBytes(compressed))
+		},
+	}
+
+	const blockSize = 64 * 1024
+	for name, makeSource := range sources {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			br := savior.NewBlockReader(makeSource(), blockSize)
+			offset, err := br.Resume(nil)
+			tmust(t, err)
+			assert.EqualValues(0, offset)
+
+			// blocks are processed out of order, by several goroutines
+			output := make([]byte, len(reference))
+			blocks := make(chan *savior.Block)
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for block := range blocks {
+						copy(output[block.Offset:], block.Data)
+					}
+				}()
+			}
+
+			var checkpointed []*savior.Block
+			numBlocks := 0
+			for {
+				block, err := br.Next()
+				if err == io.EOF {
+					break
+				}
+				tmust(t, err)
+
+				assert.EqualValues(numBlocks*blockSize, block.Offset)
+				if block.Checkpoint != nil {
+					assert.True(block.Checkpoint.OutputOffset <= block.Offset)
+					checkpointed = append(checkpointed, block)
+				}
+				numBlocks++
+				blocks <- block
+			}
+			close(blocks)
+			wg.Wait()
+
+			assert.Equal((len(reference)+blockSize-1)/blockSize, numBlocks)
+			assert.True(bytes.Equal(reference, output), "blocks should reassemble into the stream")
+			if !assert.NotEmpty(checkpointed) {
+				return
+			}
+
+			// resuming from a block's checkpoint reads that block (or an earlier one) again
+			block := checkpointed[len(checkpointed)/2]
+			br = savior.NewBlockReader(makeSource(), blockSize)
+			offset, err = br.Resume(block.Checkpoint)
+			tmust(t, err)
+			assert.True(offset <= block.Offset)
+			if name == "seeksource" {
+				assert.EqualValues(block.Offset, offset, "sources that can save anywhere save at block boundaries")
+			}
+			assert.EqualValues(0, offset%blockSize)
+
+			rest := new(bytes.Buffer)
+			for {
+				b, err := br.Next()
+				if err == io.EOF {
+					break
+				}
+				tmust(t, err)
+				rest.Write(b.Data)
+			}
+			assert.True(bytes.Equal(reference[offset:], rest.Bytes()))
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package brotlisource
 
 import (
-	"encoding/gob"
 	"fmt"
 
 	"github.com/itchio/dskompress/brotli"
@@ -27,6 +26,13 @@ type BrotliSourceCheckpoint struct {
 	BrotliCheckpoint *brotli.Checkpoint
 }
 
+var _ savior.PortableChecker = (*BrotliSourceCheckpoint)(nil)
+
+// Portable returns true if the wrapped source checkpoint is portable
+func (bsc *BrotliSourceCheckpoint) Portable() bool {
+	return bsc.SourceCheckpoint.Portable()
+}
+
 var _ savior.Source = (*brotliSource)(nil)
 
 func New(source savior.Source) *brotliSource {
@@ -146,7 +152,8 @@ func (bs *brotliSource) Read(buf []byte) (int, error) {
 			savior.Debugf("brotlisource: saving, brotli InputOffset = %d, sourceCheckpoint.Offset = %d", brotliCheckpoint.InputOffset, bs.sourceCheckpoint.Offset)
 
 			checkpoint := &savior.SourceCheckpoint{
-				Offset: bs.offset,
+				Offset:       brotliCheckpoint.InputOffset,
+				OutputOffset: bs.offset,
 				Data: &BrotliSourceCheckpoint{
 					BrotliCheckpoint: brotliCheckpoint,
 					SourceCheckpoint: bs.sourceCheckpoint,
@@ -185,6 +192,20 @@ func (bs *brotliSource) Progress() float64 {
 	return bs.source.Progress()
 }
 
+// Close releases the decompressor and closes the underlying source
+func (bs *brotliSource) Close() error {
+	var err error
+	if bs.br != nil {
+		err = bs.br.Close()
+	}
+
+	sourceErr := bs.source.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return sourceErr
+}
+
 func init() {
-	gob.Register(&BrotliSourceCheckpoint{})
+	savior.RegisterCheckpointData("brotlisource.BrotliSourceCheckpoint", &BrotliSourceCheckpoint{})
 }
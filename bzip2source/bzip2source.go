@@ -1,7 +1,6 @@
 package bzip2source
 
 import (
-	"encoding/gob"
 	"fmt"
 
 	"github.com/itchio/kompress/bzip2"
@@ -29,6 +28,13 @@ type Bzip2SourceCheckpoint struct {
 	Bzip2Checkpoint  *bzip2.Checkpoint
 }
 
+var _ savior.PortableChecker = (*Bzip2SourceCheckpoint)(nil)
+
+// Portable returns true if the wrapped source checkpoint is portable
+func (bsc *Bzip2SourceCheckpoint) Portable() bool {
+	return bsc.SourceCheckpoint.Portable()
+}
+
 var _ savior.Source = (*bzip2Source)(nil)
 
 func New(source savior.Source) *bzip2Source {
@@ -144,7 +150,8 @@ func (bs *bzip2Source) Read(buf []byte) (int, error) {
 			savior.Debugf("bzip2source: saving, bzip2 rOffset = %d, sourceCheckpoint.Offset = %d", bzip2Checkpoint.Roffset, bs.sourceCheckpoint.Offset)
 
 			checkpoint := &savior.SourceCheckpoint{
-				Offset: bs.offset,
+				Offset:       bzip2Checkpoint.Roffset,
+				OutputOffset: bs.offset,
 				Data: &Bzip2SourceCheckpoint{
 					Offset:           bs.offset,
 					Bzip2Checkpoint:  bzip2Checkpoint,
@@ -183,6 +190,20 @@ func (bs *bzip2Source) Progress() float64 {
 	return bs.source.Progress()
 }
 
+// Close releases the decompressor and closes the underlying source
+func (bs *bzip2Source) Close() error {
+	var err error
+	if bs.sr != nil {
+		err = bs.sr.Close()
+	}
+
+	sourceErr := bs.source.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return sourceErr
+}
+
 func init() {
-	gob.Register(&Bzip2SourceCheckpoint{})
+	savior.RegisterCheckpointData("bzip2source.Bzip2SourceCheckpoint", &Bzip2SourceCheckpoint{})
 }
@@ -1,6 +1,10 @@
 package bzip2source_test
 
 import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
 	"log"
 	"testing"
 
@@ -14,6 +18,13 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
 func Test_Uninitialized(t *testing.T) {
 	{
 		ss := seeksource.FromBytes(nil)
@@ -43,3 +54,56 @@ func Test_Checkpoints(t *testing.T) {
 
 	checker.RunSourceTest(t, bs, reference)
 }
+
+func Test_CheckpointEveryBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+	compressed, err := checker.Bzip2Compress(reference)
+	must(t, err)
+
+	var checkpoints []*savior.SourceCheckpoint
+	bs := bzip2source.New(seeksource.FromBytes(compressed))
+	bs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			buf := new(bytes.Buffer)
+			must(t, gob.NewEncoder(buf).Encode(c))
+			var c2 savior.SourceCheckpoint
+			must(t, gob.NewDecoder(buf).Decode(&c2))
+			checkpoints = append(checkpoints, &c2)
+			return nil
+		},
+	})
+	_, err = bs.Resume(nil)
+	must(t, err)
+
+	// always wanting to save gets us a checkpoint at every block boundary
+	buf := make([]byte, 16*1024)
+	for {
+		bs.WantSave()
+		_, err := bs.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		must(t, err)
+	}
+
+	// blocks hold at most 900k bytes, before run-length encoding
+	minBlocks := len(reference) / (900 * 1000)
+	assert.True(len(checkpoints) >= minBlocks, "expected at least %d checkpoints, got %d", minBlocks, len(checkpoints))
+
+	var lastOffset int64 = -1
+	for _, c := range checkpoints {
+		assert.True(c.OutputOffset > lastOffset, "checkpoints should move forward")
+		lastOffset = c.OutputOffset
+
+		bs := bzip2source.New(seeksource.FromBytes(compressed))
+		offset, err := bs.Resume(c)
+		must(t, err)
+		assert.EqualValues(c.OutputOffset, offset)
+
+		rest, err := ioutil.ReadAll(bs)
+		must(t, err)
+		assert.True(bytes.Equal(reference[offset:], rest), "resuming at %d should read the rest of the stream", offset)
+	}
+}
@@ -0,0 +1,360 @@
+package cabextractor
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// CabExtractor extracts Microsoft cabinet files. Only folders stored
+// without compression or with MSZIP can be extracted, see ErrUnsupportedMethod.
+//
+// Files of a folder are stored one after the other in a single
+// compressed stream, so they're extracted in folder order, and
+// checkpoints can only be made between CFDATA blocks (every 32KiB of
+// uncompressed data for MSZIP).
+type CabExtractor struct {
+	reader io.ReaderAt
+	cab    *cabinet
+
+	saveConsumer        savior.SaveConsumer
+	checkpointFilter    savior.CheckpointFilter
+	memoryLowWatermark  int64
+	memoryHighWatermark int64
+	entryFilter         savior.EntryFilter
+	consumer            *state.Consumer
+	events              *savior.EventWriter
+}
+
+var _ savior.Extractor = (*CabExtractor)(nil)
+
+func New(reader io.ReaderAt, readerSize int64) (*CabExtractor, error) {
+	cab, err := readCabinet(reader, readerSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &CabExtractor{
+		reader: reader,
+		cab:    cab,
+
+		saveConsumer: savior.NopSaveConsumer(),
+		consumer:     savior.NopConsumer(),
+	}, nil
+}
+
+func (ce *CabExtractor) SetSaveConsumer(saveConsumer savior.SaveConsumer) {
+	ce.saveConsumer = saveConsumer
+}
+
+func (ce *CabExtractor) SetConsumer(consumer *state.Consumer) {
+	ce.consumer = consumer
+}
+
+func (ce *CabExtractor) SetEventWriter(w io.Writer) {
+	ce.events = savior.NewEventWriter(w)
+}
+
+func (ce *CabExtractor) SetCheckpointFilter(filter savior.CheckpointFilter) {
+	ce.checkpointFilter = filter
+}
+
+func (ce *CabExtractor) SetMemoryGovernor(lowWatermark int64, highWatermark int64) {
+	ce.memoryLowWatermark = lowWatermark
+	ce.memoryHighWatermark = highWatermark
+}
+
+func (ce *CabExtractor) SetEntryFilter(filter savior.EntryFilter) {
+	ce.entryFilter = filter
+}
+
+func (ce *CabExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	ce.events.Start("cab", checkpoint)
+	res, err := ce.resume(checkpoint, sink)
+	if err != nil {
+		ce.events.Error(err)
+		return nil, err
+	}
+	ce.events.Done(res)
+	return res, nil
+}
+
+func (ce *CabExtractor) resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	cab := ce.cab
+	isFresh := false
+
+	if checkpoint == nil {
+		isFresh = true
+		ce.consumer.Infof("→ Starting fresh extraction")
+		checkpoint = &savior.ExtractorCheckpoint{
+			EntryIndex: 0,
+		}
+	} else {
+		ce.consumer.Infof("↻ Resuming @ %.1f%%", checkpoint.Progress*100)
+	}
+
+	numEntries := int64(len(cab.files))
+
+	selected := ce.selectEntries()
+
+	// find out about unsupported folders before writing anything
+	for i, f := range cab.files {
+		if !selected[i] {
+			continue
+		}
+		fo := cab.folders[f.folder]
+		if fo.method != MethodNone && fo.method != MethodMSZIP {
+			return nil, errors.Wrapf(ErrUnsupportedMethod, "%s is in folder %d, which uses %s", f.name, fo.index, methodName(fo.method))
+		}
+	}
+
+	var doneBytes int64
+	var totalBytes int64
+	for i, f := range cab.files {
+		if !selected[i] {
+			continue
+		}
+		totalBytes += f.size
+		if int64(i) < checkpoint.EntryIndex {
+			doneBytes += f.size
+		}
+	}
+
+	if isFresh {
+		ce.consumer.Infof("⇓ Pre-allocating %s on disk", united.FormatBytes(totalBytes))
+		preallocateStart := time.Now()
+		for i := range cab.files {
+			if !selected[i] {
+				continue
+			}
+			err := sink.Preallocate(ce.entryAt(int64(i)))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		preallocateDuration := time.Since(preallocateStart)
+		ce.consumer.Infof("⇒ Pre-allocated in %s, nothing can stop us now", preallocateDuration)
+	}
+
+	var stopError error
+
+	saveConsumer := savior.FilterSaveConsumer(ce.events.WrapSaveConsumer(ce.saveConsumer), ce.checkpointFilter)
+	saveConsumer = savior.GovernSaveConsumer(saveConsumer, ce.memoryLowWatermark, ce.memoryHighWatermark)
+	copier := savior.NewCopier(saveConsumer)
+
+	// files of the same folder share a source, as long as
+	// they're stored in order
+	var src *folderSource
+	defer func() {
+		if src != nil {
+			src.Close()
+		}
+	}()
+
+	for entryIndex := checkpoint.EntryIndex; entryIndex < numEntries && stopError == nil; entryIndex++ {
+		savior.Debugf(`doing entryIndex %d`, entryIndex)
+		f := cab.files[entryIndex]
+		if !selected[entryIndex] {
+			// the folder source skips its data, if need be
+			continue
+		}
+
+		err := func() error {
+			checkpoint.EntryIndex = entryIndex
+
+			if checkpoint.Entry == nil {
+				checkpoint.Entry = ce.entryAt(entryIndex)
+			}
+			entry := checkpoint.Entry
+
+			ce.consumer.Debugf("→ %s", entry)
+			ce.events.EntryStart(entry)
+
+			if entry.WriteOffset > 0 {
+				if er, ok := sink.(savior.EntryRestarter); ok && er.NeedsRestart(entry) {
+					savior.Debugf(`%s: sink can't resume this entry, starting it over`, entry.CanonicalPath)
+					checkpoint.SourceCheckpoint = nil
+					entry.WriteOffset = 0
+				}
+			}
+
+			lowered, err := savior.ReconcileWriteOffset(sink, entry)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			// where the entry's data starts in the folder, and where we resume writing it
+			targetOffset := f.offset + entry.WriteOffset
+			if lowered && checkpoint.SourceCheckpoint != nil && checkpoint.SourceCheckpoint.OutputOffset > targetOffset {
+				// the folder would resume past what the sink holds
+				checkpoint.SourceCheckpoint = nil
+			}
+
+			if src == nil || src.folder.index != f.folder || src.offset > targetOffset || checkpoint.SourceCheckpoint != nil {
+				if src != nil {
+					src.Close()
+				}
+
+				var err error
+				src, err = newFolderSource(ce.reader, cab.folders[f.folder], cab.dataReserve)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				_, err = src.Resume(checkpoint.SourceCheckpoint)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
+
+			if src.offset < targetOffset {
+				delta := targetOffset - src.offset
+				savior.Debugf(`%s: discarding %d bytes to align source and writer`, entry.CanonicalPath, delta)
+				savior.Debugf(`%s: (source at %d, entry starts at %d in folder, writer was at %d)`, entry.CanonicalPath, src.offset, f.offset, entry.WriteOffset)
+				err := savior.DiscardByRead(src, delta)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
+			savior.Debugf(`%s: cabextractor resuming from %s`, entry.CanonicalPath, united.FormatBytes(entry.WriteOffset))
+
+			writer, err := sink.GetWriter(entry)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			defer writer.Close()
+
+			computeProgress := func() float64 {
+				actualDoneBytes := doneBytes + entry.WriteOffset
+				return float64(actualDoneBytes) / float64(totalBytes)
+			}
+
+			src.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+				OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
+					savior.Debugf(`%s: saving, source checkpoint at block %d`, entry.CanonicalPath, src.block)
+					checkpoint.SourceCheckpoint = sourceCheckpoint
+
+					err := writer.Sync()
+					if err != nil {
+						return errors.WithStack(err)
+					}
+
+					checkpoint.Progress = computeProgress()
+
+					action, err := saveConsumer.Save(checkpoint)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					if action == savior.AfterSaveStop {
+						copier.Stop()
+						stopError = savior.ErrStop
+					}
+
+					return nil
+				},
+			})
+
+			err = copier.Do(&savior.CopyParams{
+				Src:   io.LimitReader(src, f.size-entry.WriteOffset),
+				Dst:   writer,
+				Entry: entry,
+
+				Savable: src,
+
+				EmitProgress: func() {
+					ce.consumer.Progress(computeProgress())
+					ce.events.Progress(computeProgress())
+				},
+			})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			doneBytes += f.size
+			ce.consumer.Progress(float64(doneBytes) / float64(totalBytes))
+			ce.events.Progress(float64(doneBytes) / float64(totalBytes))
+			return nil
+		}()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if stopError == nil {
+			ce.events.EntryDone(checkpoint.Entry)
+		}
+
+		checkpoint.SourceCheckpoint = nil
+		checkpoint.Entry = nil
+	}
+
+	if stopError != nil {
+		return nil, savior.ErrStop
+	}
+
+	res := &savior.ExtractorResult{}
+	for i, entry := range ce.Entries() {
+		if selected[i] {
+			res.Entries = append(res.Entries, entry)
+		}
+	}
+	return res, nil
+}
+
+// selectEntries returns which files of the cabinet the entry filter lets
+// through, all of them if there's none
+func (ce *CabExtractor) selectEntries() []bool {
+	selected := make([]bool, len(ce.cab.files))
+	for i := range ce.cab.files {
+		if ce.entryFilter != nil && !ce.entryFilter(ce.entryAt(int64(i))) {
+			savior.Debugf(`%s: skipping, excluded by entry filter`, ce.cab.files[i].name)
+			continue
+		}
+		selected[i] = true
+	}
+	return selected
+}
+
+func (ce *CabExtractor) Features() savior.ExtractorFeatures {
+	return savior.ExtractorFeatures{
+		Name:          "cab",
+		ResumeSupport: savior.ResumeSupportBlock,
+		Preallocate:   true,
+		RandomAccess:  false,
+	}
+}
+
+// Entries returns the files of the cabinet. Cabinets only store
+// files, directories are implied by their paths.
+func (ce *CabExtractor) Entries() []*savior.Entry {
+	var entries []*savior.Entry
+	for i := range ce.cab.files {
+		entries = append(entries, ce.entryAt(int64(i)))
+	}
+	return entries
+}
+
+// ListEntries is Entries, to implement savior.Extractor. It never fails.
+func (ce *CabExtractor) ListEntries() ([]*savior.Entry, error) {
+	return ce.Entries(), nil
+}
+
+func (ce *CabExtractor) entryAt(index int64) *savior.Entry {
+	f := ce.cab.files[index]
+
+	var mode os.FileMode = 0644
+	if f.exec {
+		mode = 0755
+	}
+
+	return &savior.Entry{
+		CanonicalPath:    f.name,
+		Kind:             savior.EntryKindFile,
+		Mode:             mode,
+		UncompressedSize: f.size,
+	}
+}
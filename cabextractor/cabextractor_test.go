@@ -0,0 +1,81 @@
+package cabextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"testing"
+
+	"github.com/itchio/headway/united"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/cabextractor"
+	"github.com/itchio/savior/checker"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// makeFileSink returns a test sink with only files in it,
+// since that's all cabinets can hold
+func makeFileSink() *checker.Sink {
+	sink := checker.MakeTestSink()
+	for name, item := range sink.Items {
+		if item.Entry.Kind != savior.EntryKindFile {
+			delete(sink.Items, name)
+		}
+	}
+	return sink
+}
+
+func TestCab(t *testing.T) {
+	sink := makeFileSink()
+
+	log.Printf("Making cab from checker.Sink...")
+	cabBytes := checker.MakeCab(t, sink)
+
+	makeExtractor := func() savior.Extractor {
+		ce, err := cabextractor.New(bytes.NewReader(cabBytes), int64(len(cabBytes)))
+		must(t, err)
+		return ce
+	}
+
+	log.Printf("Testing .cab (%s), no resumes", united.FormatBytes(int64(len(cabBytes))))
+	checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+		return false
+	})
+
+	log.Printf("Testing .cab (%s), all resumes", united.FormatBytes(int64(len(cabBytes))))
+	checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+		return true
+	})
+}
+
+func TestCabUnsupportedMethod(t *testing.T) {
+	sink := makeFileSink()
+	cabBytes := checker.MakeCab(t, sink)
+
+	// switch the second folder's typeCompress to LZX
+	coffFolder := 36 + 8
+	binary.LittleEndian.PutUint16(cabBytes[coffFolder+6:], cabextractor.MethodLZX)
+
+	ce, err := cabextractor.New(bytes.NewReader(cabBytes), int64(len(cabBytes)))
+	must(t, err)
+
+	sink.Reset()
+	_, err = ce.Resume(nil, sink)
+	assert.Error(t, err)
+	assert.Equal(t, cabextractor.ErrUnsupportedMethod, errors.Cause(err))
+}
+
+func TestNotCabinet(t *testing.T) {
+	data := []byte("PK\x03\x04 definitely not a cabinet, sorry")
+	_, err := cabextractor.New(bytes.NewReader(data), int64(len(data)))
+	assert.Error(t, err)
+	assert.Equal(t, cabextractor.ErrNotCabinet, errors.Cause(err))
+}
@@ -0,0 +1,246 @@
+package cabextractor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Compression methods of a folder (the low 4 bits of typeCompress)
+const (
+	MethodNone    = 0
+	MethodMSZIP   = 1
+	MethodQuantum = 2
+	MethodLZX     = 3
+)
+
+const (
+	flagPrevCabinet    = 0x1
+	flagNextCabinet    = 0x2
+	flagReservePresent = 0x4
+
+	attribExec = 0x40
+	methodMask = 0x000f
+	cfDataSize = 8
+
+	// files whose data continues from, or into, another cabinet
+	firstContinuedFolder = 0xfffd
+)
+
+var cabSignature = []byte("MSCF")
+
+// ErrNotCabinet is returned when the data doesn't start with a cabinet header
+var ErrNotCabinet = errors.New("not a cabinet file")
+
+// ErrUnsupportedMethod is returned when extracting a folder compressed
+// with a method we can't decompress (Quantum, LZX)
+var ErrUnsupportedMethod = errors.New("unsupported compression method")
+
+// ErrCabinetSet is returned for cabinets that are part of a set spanning
+// several files, which aren't supported.
+var ErrCabinetSet = errors.New("cabinets spanning several files are not supported")
+
+func methodName(method uint16) string {
+	switch method {
+	case MethodNone:
+		return "none"
+	case MethodMSZIP:
+		return "MSZIP"
+	case MethodQuantum:
+		return "Quantum"
+	case MethodLZX:
+		return "LZX"
+	default:
+		return fmt.Sprintf("method %d", method)
+	}
+}
+
+type cfHeader struct {
+	Signature    [4]byte
+	Reserved1    uint32
+	CbCabinet    uint32
+	Reserved2    uint32
+	CoffFiles    uint32
+	Reserved3    uint32
+	VersionMinor uint8
+	VersionMajor uint8
+	CFolders     uint16
+	CFiles       uint16
+	Flags        uint16
+	SetID        uint16
+	ICabinet     uint16
+}
+
+type cfFolder struct {
+	CoffCabStart uint32
+	CCFData      uint16
+	TypeCompress uint16
+}
+
+type cfFile struct {
+	CbFile          uint32
+	UoffFolderStart uint32
+	IFolder         uint16
+	Date            uint16
+	Time            uint16
+	Attribs         uint16
+}
+
+// folder is a compressed stream several files can be in
+type folder struct {
+	index int
+	// offset of the first CFDATA block
+	dataOffset int64
+	numBlocks  int
+	method     uint16
+	// size of the compressed data, including CFDATA headers
+	compressedSize int64
+}
+
+// file is an entry of the cabinet
+type file struct {
+	name   string
+	size   int64
+	offset int64
+	folder int
+	exec   bool
+}
+
+type cabinet struct {
+	folders []*folder
+	files   []*file
+	// number of reserved bytes after each CFDATA header
+	dataReserve int
+}
+
+func readCabinet(r io.ReaderAt, size int64) (*cabinet, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, size))
+
+	var hdr cfHeader
+	err := binary.Read(br, binary.LittleEndian, &hdr)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errors.WithStack(ErrNotCabinet)
+		}
+		return nil, errors.WithStack(err)
+	}
+	if string(hdr.Signature[:]) != string(cabSignature) {
+		return nil, errors.WithStack(ErrNotCabinet)
+	}
+	if hdr.Flags&(flagPrevCabinet|flagNextCabinet) != 0 {
+		return nil, errors.WithStack(ErrCabinetSet)
+	}
+
+	cab := &cabinet{}
+	folderReserve := 0
+	if hdr.Flags&flagReservePresent != 0 {
+		var reserve struct {
+			CbCFHeader uint16
+			CbCFFolder uint8
+			CbCFData   uint8
+		}
+		err = binary.Read(br, binary.LittleEndian, &reserve)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		_, err = br.Discard(int(reserve.CbCFHeader))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		folderReserve = int(reserve.CbCFFolder)
+		cab.dataReserve = int(reserve.CbCFData)
+	}
+
+	for i := 0; i < int(hdr.CFolders); i++ {
+		var cf cfFolder
+		err = binary.Read(br, binary.LittleEndian, &cf)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		_, err = br.Discard(folderReserve)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		cab.folders = append(cab.folders, &folder{
+			index:      i,
+			dataOffset: int64(cf.CoffCabStart),
+			numBlocks:  int(cf.CCFData),
+			method:     cf.TypeCompress & methodMask,
+		})
+	}
+
+	br = bufio.NewReader(io.NewSectionReader(r, int64(hdr.CoffFiles), size-int64(hdr.CoffFiles)))
+	for i := 0; i < int(hdr.CFiles); i++ {
+		var cf cfFile
+		err = binary.Read(br, binary.LittleEndian, &cf)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name, err := br.ReadString(0)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name = strings.TrimSuffix(name, "\x00")
+
+		if cf.IFolder >= firstContinuedFolder {
+			return nil, errors.Wrapf(ErrCabinetSet, "%s", name)
+		}
+		if int(cf.IFolder) >= len(cab.folders) {
+			return nil, errors.Errorf("cabextractor: %s is in folder %d, but there are only %d", name, cf.IFolder, len(cab.folders))
+		}
+
+		// names without the UTF-8 attribute are in the
+		// creator's codepage, which is ASCII more often than not
+		cab.files = append(cab.files, &file{
+			name:   strings.Replace(name, "\\", "/", -1),
+			size:   int64(cf.CbFile),
+			offset: int64(cf.UoffFolderStart),
+			folder: int(cf.IFolder),
+			exec:   cf.Attribs&attribExec != 0,
+		})
+	}
+
+	// walk the CFDATA headers, so we know how large folders are
+	// (for progress) and find out about truncated cabinets early
+	for _, f := range cab.folders {
+		offset := f.dataOffset
+		for i := 0; i < f.numBlocks; i++ {
+			bh, err := readDataHeader(r, offset)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading block %d of folder %d", i, f.index)
+			}
+			offset += int64(cfDataSize + cab.dataReserve + int(bh.CbData))
+		}
+		if offset > size {
+			return nil, errors.Errorf("cabextractor: folder %d ends at %d, past the end of the cabinet (%d)", f.index, offset, size)
+		}
+		f.compressedSize = offset - f.dataOffset
+	}
+
+	return cab, nil
+}
+
+type cfData struct {
+	Csum     uint32
+	CbData   uint16
+	CbUncomp uint16
+}
+
+func readDataHeader(r io.ReaderAt, offset int64) (*cfData, error) {
+	buf := make([]byte, cfDataSize)
+	_, err := r.ReadAt(buf, offset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &cfData{
+		Csum:     binary.LittleEndian.Uint32(buf[0:4]),
+		CbData:   binary.LittleEndian.Uint16(buf[4:6]),
+		CbUncomp: binary.LittleEndian.Uint16(buf[6:8]),
+	}, nil
+}
@@ -0,0 +1,238 @@
+package cabextractor
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/itchio/kompress/flate"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// MSZIP blocks are deflate streams that use the previous 32KiB of
+// the folder as their dictionary, and decompress to at most 32KiB
+const mszipWindowSize = 32 * 1024
+
+var mszipSignature = []byte("CK")
+
+// folderSource reads the decompressed contents of a folder. It can
+// only save on CFDATA block boundaries.
+type folderSource struct {
+	r           io.ReaderAt
+	folder      *folder
+	dataReserve int
+
+	// index of the next block to decompress
+	block int
+	// offset of the next block's CFDATA header
+	blockOffset int64
+	// last 32KiB of decompressed data (MSZIP only)
+	window []byte
+
+	// decompressed data of the current block, and how much of it was read
+	out    []byte
+	outPos int
+
+	offset int64
+
+	ssc      savior.SourceSaveConsumer
+	wantSave bool
+	started  bool
+}
+
+// FolderSourceCheckpoint is the state of a folderSource at a block boundary
+type FolderSourceCheckpoint struct {
+	Block       int
+	BlockOffset int64
+	Window      []byte
+}
+
+var _ savior.Source = (*folderSource)(nil)
+
+func newFolderSource(r io.ReaderAt, f *folder, dataReserve int) (*folderSource, error) {
+	switch f.method {
+	case MethodNone, MethodMSZIP:
+		// good
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedMethod, "folder %d uses %s", f.index, methodName(f.method))
+	}
+
+	return &folderSource{
+		r:           r,
+		folder:      f,
+		dataReserve: dataReserve,
+	}, nil
+}
+
+func (fs *folderSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "cabfolder",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (fs *folderSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	fs.ssc = ssc
+}
+
+func (fs *folderSource) WantSave() {
+	fs.wantSave = true
+}
+
+func (fs *folderSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	fs.started = true
+	fs.out = nil
+	fs.outPos = 0
+	fs.wantSave = false
+
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*FolderSourceCheckpoint); ok {
+			fs.block = ourCheckpoint.Block
+			fs.blockOffset = ourCheckpoint.BlockOffset
+			fs.window = append([]byte(nil), ourCheckpoint.Window...)
+			fs.offset = checkpoint.OutputOffset
+			return fs.offset, nil
+		}
+	}
+
+	fs.block = 0
+	fs.blockOffset = fs.folder.dataOffset
+	fs.window = nil
+	fs.offset = 0
+	return 0, nil
+}
+
+func (fs *folderSource) Read(buf []byte) (int, error) {
+	if !fs.started {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	if fs.outPos == len(fs.out) {
+		if fs.wantSave && fs.ssc != nil {
+			fs.wantSave = false
+			err := fs.save()
+			if err != nil {
+				return 0, err
+			}
+			// let the caller notice the checkpoint before
+			// any data from the next block is returned
+			return 0, nil
+		}
+
+		if fs.block >= fs.folder.numBlocks {
+			return 0, io.EOF
+		}
+
+		err := fs.nextBlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(buf, fs.out[fs.outPos:])
+	fs.outPos += n
+	fs.offset += int64(n)
+	return n, nil
+}
+
+func (fs *folderSource) ReadByte() (byte, error) {
+	var buf [1]byte
+	for {
+		n, err := fs.Read(buf[:])
+		if n == 1 {
+			return buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (fs *folderSource) save() error {
+	checkpoint := &savior.SourceCheckpoint{
+		Offset:       fs.blockOffset - fs.folder.dataOffset,
+		OutputOffset: fs.offset,
+		Data: &FolderSourceCheckpoint{
+			Block:       fs.block,
+			BlockOffset: fs.blockOffset,
+			Window:      append([]byte(nil), fs.window...),
+		},
+	}
+	savior.Debugf("cabfolder: saving at block %d, output offset %d", fs.block, fs.offset)
+	return fs.ssc.Save(checkpoint)
+}
+
+func (fs *folderSource) nextBlock() error {
+	hdr, err := readDataHeader(fs.r, fs.blockOffset)
+	if err != nil {
+		return errors.Wrapf(err, "reading block %d of folder %d", fs.block, fs.folder.index)
+	}
+
+	data := make([]byte, hdr.CbData)
+	_, err = fs.r.ReadAt(data, fs.blockOffset+int64(cfDataSize+fs.dataReserve))
+	if err != nil {
+		return errors.Wrapf(err, "reading block %d of folder %d", fs.block, fs.folder.index)
+	}
+
+	switch fs.folder.method {
+	case MethodNone:
+		fs.out = data
+	case MethodMSZIP:
+		fs.out, err = fs.inflate(data, int(hdr.CbUncomp))
+		if err != nil {
+			return errors.Wrapf(err, "decompressing block %d of folder %d", fs.block, fs.folder.index)
+		}
+	}
+	if len(fs.out) != int(hdr.CbUncomp) {
+		return errors.Errorf("cabextractor: block %d of folder %d decompressed to %d bytes, expected %d", fs.block, fs.folder.index, len(fs.out), hdr.CbUncomp)
+	}
+	fs.outPos = 0
+
+	fs.block++
+	fs.blockOffset += int64(cfDataSize + fs.dataReserve + len(data))
+	return nil
+}
+
+func (fs *folderSource) inflate(data []byte, uncompressedSize int) ([]byte, error) {
+	if !bytes.HasPrefix(data, mszipSignature) {
+		return nil, errors.New("cabextractor: MSZIP block doesn't start with CK")
+	}
+
+	fr := flate.NewReaderDict(bytes.NewReader(data[len(mszipSignature):]), fs.window)
+	defer fr.Close()
+
+	out := make([]byte, 0, uncompressedSize)
+	buf := bytes.NewBuffer(out)
+	_, err := io.Copy(buf, fr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	out = buf.Bytes()
+
+	fs.window = append(fs.window, out...)
+	if len(fs.window) > mszipWindowSize {
+		fs.window = fs.window[len(fs.window)-mszipWindowSize:]
+	}
+	return out, nil
+}
+
+// Close frees the current block, the cabinet itself
+// belongs to whoever passed it to New.
+func (fs *folderSource) Close() error {
+	fs.out = nil
+	fs.outPos = 0
+	fs.window = nil
+	fs.started = false
+	return nil
+}
+
+func (fs *folderSource) Progress() float64 {
+	if fs.folder.compressedSize == 0 {
+		return 1
+	}
+	return float64(fs.blockOffset-fs.folder.dataOffset) / float64(fs.folder.compressedSize)
+}
+
+func init() {
+	savior.RegisterCheckpointData("cabextractor.FolderSourceCheckpoint", &FolderSourceCheckpoint{})
+}
@@ -0,0 +1,132 @@
+// Package cachereaderat keeps recently read blocks of an io.ReaderAt in
+// memory, so that reading the same ranges again (a zip's central directory,
+// or entries next to each other) doesn't hit a slow reader twice.
+package cachereaderat
+
+import (
+	"container/list"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBlockSize = 64 * 1024
+	defaultMaxBlocks = 64
+)
+
+type block struct {
+	index int64
+	data  []byte
+	// set if the reader ended within (or right at the end of) this block
+	eof bool
+}
+
+// ReaderAt reads from an underlying io.ReaderAt one block at a time,
+// keeping the last `maxBlocks` blocks used in memory. It's safe to use
+// from several goroutines, but blocks that are missing when two reads
+// need them at once are read twice.
+type ReaderAt struct {
+	r         io.ReaderAt
+	blockSize int64
+	maxBlocks int
+
+	mu sync.Mutex
+	// most recently used first
+	lru    *list.List
+	blocks map[int64]*list.Element
+}
+
+var _ io.ReaderAt = (*ReaderAt)(nil)
+
+// New returns a ReaderAt that reads from r in blocks of `blockSize` bytes
+// (64KiB if <= 0), and caches at most `maxBlocks` of them (64 if <= 0).
+func New(r io.ReaderAt, blockSize int, maxBlocks int) *ReaderAt {
+	if blockSize <= 0 {
+		blockSize = defaultBlockSize
+	}
+	if maxBlocks <= 0 {
+		maxBlocks = defaultMaxBlocks
+	}
+
+	return &ReaderAt{
+		r:         r,
+		blockSize: int64(blockSize),
+		maxBlocks: maxBlocks,
+		lru:       list.New(),
+		blocks:    make(map[int64]*list.Element),
+	}
+}
+
+func (cra *ReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("cachereaderat: negative offset %d", off)
+	}
+
+	read := 0
+	for read < len(buf) {
+		pos := off + int64(read)
+		b, err := cra.getBlock(pos / cra.blockSize)
+		if err != nil {
+			return read, err
+		}
+
+		start := pos - b.index*cra.blockSize
+		if start >= int64(len(b.data)) {
+			return read, io.EOF
+		}
+		read += copy(buf[read:], b.data[start:])
+
+		if b.eof && read < len(buf) {
+			return read, io.EOF
+		}
+	}
+	return read, nil
+}
+
+// getBlock returns the block at `index`, from the cache if it's there
+func (cra *ReaderAt) getBlock(index int64) (*block, error) {
+	cra.mu.Lock()
+	if el, ok := cra.blocks[index]; ok {
+		cra.lru.MoveToFront(el)
+		cra.mu.Unlock()
+		return el.Value.(*block), nil
+	}
+	cra.mu.Unlock()
+
+	b := &block{
+		index: index,
+		data:  make([]byte, cra.blockSize),
+	}
+	n, err := cra.r.ReadAt(b.data, index*cra.blockSize)
+	b.data = b.data[:n]
+	if err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+		b.eof = true
+	}
+
+	cra.mu.Lock()
+	defer cra.mu.Unlock()
+	if el, ok := cra.blocks[index]; ok {
+		// read concurrently, keep the one we had
+		cra.lru.MoveToFront(el)
+		return el.Value.(*block), nil
+	}
+	cra.blocks[index] = cra.lru.PushFront(b)
+	for cra.lru.Len() > cra.maxBlocks {
+		el := cra.lru.Back()
+		cra.lru.Remove(el)
+		delete(cra.blocks, el.Value.(*block).index)
+	}
+	return b, nil
+}
+
+// Len returns how many blocks are cached
+func (cra *ReaderAt) Len() int {
+	cra.mu.Lock()
+	defer cra.mu.Unlock()
+	return cra.lru.Len()
+}
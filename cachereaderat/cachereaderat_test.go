@@ -0,0 +1,113 @@
+package cachereaderat_test
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/itchio/savior/cachereaderat"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t testing.TB, err error) {
+	if err != nil {
+		t.Helper()
+		t.Fatalf("%+v", err)
+	}
+}
+
+type countingReaderAt struct {
+	r     io.ReaderAt
+	calls int64
+}
+
+func (cra *countingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	atomic.AddInt64(&cra.calls, 1)
+	return cra.r.ReadAt(buf, off)
+}
+
+func Test_ReadAt(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make([]byte, 10*1024+17)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	inner := &countingReaderAt{r: bytes.NewReader(data)}
+	r := cachereaderat.New(inner, 1024, 4)
+
+	read := func(off int64, size int) ([]byte, error) {
+		buf := make([]byte, size)
+		n, err := r.ReadAt(buf, off)
+		return buf[:n], err
+	}
+
+	// spans three blocks
+	buf, err := read(1000, 1100)
+	must(t, err)
+	assert.EqualValues(data[1000:2100], buf)
+	assert.EqualValues(3, inner.calls)
+
+	// overlapping reads hit the cache
+	buf, err = read(1500, 500)
+	must(t, err)
+	assert.EqualValues(data[1500:2000], buf)
+	buf, err = read(0, 2048)
+	must(t, err)
+	assert.EqualValues(data[0:2048], buf)
+	assert.EqualValues(3, inner.calls)
+
+	// reading past the end, the last block is short
+	buf, err = read(int64(len(data))-10, 200)
+	assert.Equal(io.EOF, err)
+	assert.EqualValues(data[len(data)-10:], buf)
+	assert.EqualValues(4, inner.calls)
+
+	buf, err = read(int64(len(data))-10, 10)
+	must(t, err)
+	assert.EqualValues(data[len(data)-10:], buf)
+	_, err = read(int64(len(data))+10, 1)
+	assert.Equal(io.EOF, err)
+	assert.EqualValues(4, inner.calls)
+
+	// only 4 blocks are kept, the least recently used go first
+	assert.EqualValues(4, r.Len())
+	_, err = read(5*1024, 1)
+	must(t, err)
+	assert.EqualValues(4, r.Len())
+	assert.EqualValues(5, inner.calls)
+	_, err = read(0, 1)
+	must(t, err)
+	assert.EqualValues(5, inner.calls)
+	_, err = read(2*1024, 1)
+	must(t, err)
+	assert.EqualValues(6, inner.calls)
+}
+
+func Test_Zip(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	extract := func(r io.ReaderAt) {
+		ex, err := zipextractor.New(r, int64(len(zipBytes)))
+		must(t, err)
+		_, err = ex.Resume(nil, sink)
+		must(t, err)
+		must(t, sink.Validate())
+		sink.Reset()
+	}
+
+	direct := &countingReaderAt{r: bytes.NewReader(zipBytes)}
+	extract(direct)
+
+	cached := &countingReaderAt{r: bytes.NewReader(zipBytes)}
+	extract(cachereaderat.New(cached, 64*1024, 256))
+
+	t.Logf("%d reads direct, %d reads cached", direct.calls, cached.calls)
+	assert.True(cached.calls < direct.calls)
+}
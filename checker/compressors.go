@@ -2,6 +2,7 @@ package checker
 
 import (
 	"bytes"
+	"compress/zlib"
 	"os/exec"
 
 	"github.com/itchio/go-brotli/enc"
@@ -51,6 +52,26 @@ func FlateCompress(input []byte) ([]byte, error) {
 	return compressedBuf.Bytes(), nil
 }
 
+func ZlibCompress(input []byte) ([]byte, error) {
+	compressedBuf := new(bytes.Buffer)
+	w, err := zlib.NewWriterLevel(compressedBuf, 9)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	_, err = w.Write(input)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return compressedBuf.Bytes(), nil
+}
+
 func Bzip2Compress(input []byte) ([]byte, error) {
 	cmd := exec.Command("bzip2")
 	outbuf := new(bytes.Buffer)
@@ -84,3 +105,18 @@ func BrotliCompress(input []byte, level int) ([]byte, error) {
 
 	return compressedBuf.Bytes(), nil
 }
+
+// LzmaCompress returns input in the .lzma format, as written by xz
+func LzmaCompress(input []byte) ([]byte, error) {
+	cmd := exec.Command("xz", "--format=lzma", "--stdout")
+	outbuf := new(bytes.Buffer)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = outbuf
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return outbuf.Bytes(), nil
+}
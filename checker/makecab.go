@@ -0,0 +1,139 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/itchio/kompress/flate"
+
+	"github.com/itchio/savior"
+)
+
+// MakeCab returns a cabinet containing the files of sink (cabinets
+// can't store directories or symlinks), split into two MSZIP folders.
+func MakeCab(t *testing.T, sink *Sink) []byte {
+	var names []string
+	for name, item := range sink.Items {
+		if item.Entry.Kind == savior.EntryKindFile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	numFolders := 2
+	if len(names) < 2 {
+		numFolders = 1
+	}
+	folderOf := func(i int) int {
+		return i * numFolders / len(names)
+	}
+
+	// compress each folder: files one after the other, in blocks of
+	// 32KiB, each block using the previous one as its dictionary
+	const blockSize = 32 * 1024
+	type block struct {
+		data   []byte
+		uncomp int
+	}
+	folderBlocks := make([][]block, numFolders)
+	folderOffsets := make([]int64, len(names))
+	for fi := 0; fi < numFolders; fi++ {
+		var contents []byte
+		for i, name := range names {
+			if folderOf(i) != fi {
+				continue
+			}
+			folderOffsets[i] = int64(len(contents))
+			contents = append(contents, sink.Items[name].Data...)
+		}
+
+		var dict []byte
+		for len(contents) > 0 {
+			chunk := contents
+			if len(chunk) > blockSize {
+				chunk = chunk[:blockSize]
+			}
+			contents = contents[len(chunk):]
+
+			buf := new(bytes.Buffer)
+			buf.WriteString("CK")
+			fw, err := flate.NewWriterDict(buf, flate.DefaultCompression, dict)
+			must(t, err)
+			_, err = fw.Write(chunk)
+			must(t, err)
+			must(t, fw.Close())
+
+			folderBlocks[fi] = append(folderBlocks[fi], block{data: buf.Bytes(), uncomp: len(chunk)})
+			dict = chunk
+		}
+	}
+
+	const headerSize = 36
+	const folderSize = 8
+	const fileSize = 16
+	const dataSize = 8
+
+	filesOffset := headerSize + folderSize*numFolders
+	dataOffset := filesOffset
+	for _, name := range names {
+		dataOffset += fileSize + len(name) + 1
+	}
+
+	le := binary.LittleEndian
+	buf := new(bytes.Buffer)
+	write := func(v interface{}) {
+		must(t, binary.Write(buf, le, v))
+	}
+
+	var folders bytes.Buffer
+	var data bytes.Buffer
+	for _, blocks := range folderBlocks {
+		must(t, binary.Write(&folders, le, uint32(dataOffset+data.Len())))
+		must(t, binary.Write(&folders, le, uint16(len(blocks))))
+		must(t, binary.Write(&folders, le, uint16(1))) // MSZIP
+		for _, b := range blocks {
+			must(t, binary.Write(&data, le, uint32(0))) // no checksum
+			must(t, binary.Write(&data, le, uint16(len(b.data))))
+			must(t, binary.Write(&data, le, uint16(b.uncomp)))
+			data.Write(b.data)
+		}
+	}
+
+	// CFHEADER
+	buf.WriteString("MSCF")
+	write(uint32(0))
+	write(uint32(dataOffset + data.Len()))
+	write(uint32(0))
+	write(uint32(filesOffset))
+	write(uint32(0))
+	write([]uint8{3, 1})
+	write(uint16(numFolders))
+	write(uint16(len(names)))
+	write(uint16(0)) // flags
+	write(uint16(0)) // set ID
+	write(uint16(0)) // cabinet index
+
+	buf.Write(folders.Bytes())
+
+	// CFFILE
+	for i, name := range names {
+		write(uint32(len(sink.Items[name].Data)))
+		write(uint32(folderOffsets[i]))
+		write(uint16(folderOf(i)))
+		write(uint16(0))    // date
+		write(uint16(0))    // time
+		write(uint16(0x20)) // archive
+		buf.WriteString(strings.Replace(name, "/", "\\", -1))
+		buf.WriteByte(0)
+	}
+
+	buf.Write(data.Bytes())
+
+	log.Printf("Made cab with %d files in %d MSZIP folders", len(names), numFolders)
+
+	return buf.Bytes()
+}
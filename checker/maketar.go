@@ -41,6 +41,18 @@ func MakeTar(t *testing.T, sink *Sink) []byte {
 		}
 	}
 
+	// hardlinks go last, so that their targets come before them
+	for _, item := range sink.Items {
+		if item.Entry.Kind == savior.EntryKindHardlink {
+			must(t, tw.WriteHeader(&tar.Header{
+				Name:     item.Entry.CanonicalPath,
+				Typeflag: tar.TypeLink,
+				Mode:     0644,
+				Linkname: item.Entry.Linkname,
+			}))
+		}
+	}
+
 	err := tw.Close()
 	must(t, err)
 
@@ -11,7 +11,7 @@ import (
 	"github.com/itchio/savior"
 )
 
-func MakeZip(t *testing.T, sink *Sink) []byte {
+func MakeZip(t testing.TB, sink *Sink) []byte {
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
 
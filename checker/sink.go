@@ -20,6 +20,8 @@ type Sink struct {
 }
 
 var _ savior.Sink = (*Sink)(nil)
+var _ savior.ResumeOffsetter = (*Sink)(nil)
+var _ savior.HardlinkSink = (*Sink)(nil)
 
 // Item represents a savior.Entry + bytes pair
 type Item struct {
@@ -66,6 +68,11 @@ func (cs *Sink) Validate() error {
 				if di.Linkname != e.Linkname {
 					return fmt.Errorf("checker.Sink: symlink points at '%s' instead of '%s': %s", di.Linkname, e.Linkname, e)
 				}
+
+			case savior.EntryKindHardlink:
+				if di.Linkname != e.Linkname {
+					return fmt.Errorf("checker.Sink: hardlink points at '%s' instead of '%s': %s", di.Linkname, e.Linkname, e)
+				}
 			}
 		} else {
 			return fmt.Errorf("checker.Sink: entry neglected: %s", e)
@@ -97,6 +104,32 @@ func (cs *Sink) Symlink(entry *savior.Entry, linkname string) error {
 	})
 }
 
+func (cs *Sink) Hardlink(entry *savior.Entry, target string) error {
+	return cs.withItem(entry, savior.EntryKindHardlink, func(item *Item, di *DoneItem) error {
+		if item.Entry.Linkname != target {
+			err := fmt.Errorf("%s: expected hardlink to '%s', got '%s'", entry.CanonicalPath, item.Entry.Linkname, target)
+			return errors.WithStack(err)
+		}
+
+		// the target must be complete by now
+		targetItem := cs.Items[target]
+		if targetItem == nil {
+			err := fmt.Errorf("%s: hardlink target '%s' isn't an item", entry.CanonicalPath, target)
+			return errors.WithStack(err)
+		}
+		tdi := cs.DoneItems[target]
+		size := int64(len(targetItem.Data))
+		if tdi == nil || (size > 0 && (tdi.MinWrite != 0 || tdi.MaxWrite != size)) {
+			err := fmt.Errorf("%s: hardlink target '%s' isn't fully written yet", entry.CanonicalPath, target)
+			return errors.WithStack(err)
+		}
+
+		di.Linkname = target
+
+		return nil
+	})
+}
+
 func (cs *Sink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
 	var ew savior.EntryWriter
 
@@ -131,6 +164,18 @@ func (cs *Sink) Preallocate(entry *savior.Entry) error {
 	})
 }
 
+// ResumeOffset returns how far the entry was written since the last Reset
+func (cs *Sink) ResumeOffset(entry *savior.Entry) (int64, error) {
+	di, ok := cs.DoneItems[entry.CanonicalPath]
+	if !ok || di.MinWrite != 0 {
+		return 0, nil
+	}
+	if di.MaxWrite < entry.WriteOffset {
+		return di.MaxWrite, nil
+	}
+	return entry.WriteOffset, nil
+}
+
 func (cs *Sink) Nuke() error {
 	cs.Reset()
 	return nil
@@ -13,7 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func must(t *testing.T, err error) {
+func must(t testing.TB, err error) {
 	if err != nil {
 		assert.NoError(t, err)
 		t.FailNow()
@@ -53,10 +53,11 @@ func RunSourceTest(t *testing.T, source savior.Source, reference []byte) {
 			c2, checkpointSize := roundtripThroughGob(t, c)
 
 			totalCheckpoints++
-			log.Printf("%s ↓ made %s checkpoint @ %.2f%% (byte %d)", united.FormatBytes(c2.Offset), united.FormatBytes(checkpointSize), source.Progress()*100, c2.Offset)
+			log.Printf("%s ↓ made %s checkpoint @ %.2f%% (byte %d, output byte %d)", united.FormatBytes(c2.OutputOffset), united.FormatBytes(checkpointSize), source.Progress()*100, c2.Offset, c2.OutputOffset)
 
 			newOffset, err := source.Resume(c2)
 			must(t, err)
+			assert.EqualValues(t, c2.OutputOffset, newOffset, "source should resume at the checkpoint's output offset")
 
 			log.Printf("%s ↻ resumed", united.FormatBytes(newOffset))
 			_, err = output.Seek(newOffset, io.SeekStart)
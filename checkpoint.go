@@ -0,0 +1,179 @@
+package savior
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNonPortableCheckpoint is returned when trying to serialize a
+// checkpoint that could not be resumed on another machine.
+var ErrNonPortableCheckpoint = errors.New("checkpoint contains machine-specific state")
+
+// A PortableChecker is implemented by checkpoint payloads (the `Data` field
+// of SourceCheckpoint and ExtractorCheckpoint) that nest other checkpoints
+// or entries, so they can be audited by `Portable()`.
+type PortableChecker interface {
+	Portable() bool
+}
+
+// Portable returns true if the checkpoint only contains state that
+// makes sense on another machine: offsets, entry indices, relative
+// paths, and codec state. Absolute paths or file descriptors make a
+// checkpoint non-portable.
+func (c *ExtractorCheckpoint) Portable() bool {
+	if c == nil {
+		return true
+	}
+
+	if c.EntryIndex < 0 {
+		return false
+	}
+
+	if !c.SourceCheckpoint.Portable() {
+		return false
+	}
+
+	if !c.Entry.Portable() {
+		return false
+	}
+
+	return dataPortable(c.Data)
+}
+
+// Portable returns true if the source checkpoint (and any checkpoint
+// it wraps) only contains relative state.
+func (c *SourceCheckpoint) Portable() bool {
+	if c == nil {
+		return true
+	}
+
+	if c.Offset < 0 {
+		return false
+	}
+
+	return dataPortable(c.Data)
+}
+
+// Portable returns true if the entry's path is relative to the
+// root of the archive, and doesn't escape it.
+func (entry *Entry) Portable() bool {
+	if entry == nil {
+		return true
+	}
+
+	return IsRelativeCanonicalPath(entry.CanonicalPath)
+}
+
+// IsRelativeCanonicalPath returns false for paths that are absolute
+// (on any platform we support) or that climb above the archive root.
+func IsRelativeCanonicalPath(p string) bool {
+	p = strings.Replace(p, "\\", "/", -1)
+	if strings.HasPrefix(p, "/") {
+		return false
+	}
+	if len(p) >= 2 && p[1] == ':' {
+		// windows drive letter
+		return false
+	}
+
+	cleaned := path.Clean(p)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return false
+	}
+
+	return true
+}
+
+func dataPortable(data interface{}) bool {
+	if pc, ok := data.(PortableChecker); ok {
+		return pc.Portable()
+	}
+	return true
+}
+
+type marshalOptions struct {
+	compression bool
+}
+
+// A MarshalOption changes how MarshalCheckpoint serializes checkpoints
+type MarshalOption func(opts *marshalOptions)
+
+// WithCompression gzips serialized checkpoints. Checkpoints taken in the
+// middle of a deflate stream contain its 32KiB window, which usually
+// compresses well, at the cost of a little CPU.
+func WithCompression(compression bool) MarshalOption {
+	return func(opts *marshalOptions) {
+		opts.compression = compression
+	}
+}
+
+// gzip streams start with these, gob streams for checkpoints never do,
+// since their first message (the type definition) is longer than 0x1f bytes.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// MarshalCheckpoint serializes an extractor checkpoint with encoding/gob.
+// It refuses to serialize checkpoints that aren't portable, see `Portable()`.
+func MarshalCheckpoint(c *ExtractorCheckpoint, options ...MarshalOption) ([]byte, error) {
+	var opts marshalOptions
+	for _, o := range options {
+		o(&opts)
+	}
+
+	if !c.Portable() {
+		return nil, errors.WithStack(ErrNonPortableCheckpoint)
+	}
+
+	buf := new(bytes.Buffer)
+	var w io.Writer = buf
+	var gw *gzip.Writer
+	if opts.compression {
+		gw = gzip.NewWriter(buf)
+		w = gw
+	}
+
+	err := gob.NewEncoder(w).Encode(c)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if gw != nil {
+		err = gw.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalCheckpoint deserializes an extractor checkpoint previously
+// serialized by MarshalCheckpoint, compressed or not.
+func UnmarshalCheckpoint(buf []byte) (*ExtractorCheckpoint, error) {
+	var r io.Reader = bytes.NewReader(buf)
+	if bytes.HasPrefix(buf, gzipMagic) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	c := &ExtractorCheckpoint{}
+	err := gob.NewDecoder(r).Decode(c)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !c.Portable() {
+		return nil, errors.WithStack(ErrNonPortableCheckpoint)
+	}
+
+	return c, nil
+}
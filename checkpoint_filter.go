@@ -0,0 +1,35 @@
+package savior
+
+// A CheckpointFilter is called with every checkpoint an extractor makes,
+// before it's handed to the SaveConsumer. Returning false drops it:
+// extraction goes on, but nothing is saved. See Extractor.SetCheckpointFilter.
+type CheckpointFilter func(checkpoint *ExtractorCheckpoint) bool
+
+// FilterSaveConsumer returns a SaveConsumer that only passes checkpoints
+// that `filter` accepts to `inner`, and tells extractors to continue after
+// the others. A nil filter accepts everything.
+func FilterSaveConsumer(inner SaveConsumer, filter CheckpointFilter) SaveConsumer {
+	if filter == nil {
+		return inner
+	}
+	return &filterSaveConsumer{inner: inner, filter: filter}
+}
+
+type filterSaveConsumer struct {
+	inner  SaveConsumer
+	filter CheckpointFilter
+}
+
+var _ SaveConsumer = (*filterSaveConsumer)(nil)
+
+func (fsc *filterSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	return fsc.inner.ShouldSave(copiedBytes)
+}
+
+func (fsc *filterSaveConsumer) Save(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error) {
+	if !fsc.filter(checkpoint) {
+		Debugf("savior: checkpoint at entry %d dropped by filter", checkpoint.EntryIndex)
+		return AfterSaveContinue, nil
+	}
+	return fsc.inner.Save(checkpoint)
+}
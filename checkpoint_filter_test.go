@@ -0,0 +1,75 @@
+package savior_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckpointFilter(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+	tarBytes := checker.MakeTar(t, sink)
+
+	makeExtractors := map[string]func() savior.Extractor{
+		"zip": func() savior.Extractor {
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			tmust(t, err)
+			return ex
+		},
+		"tar": func() savior.Extractor {
+			return tarextractor.New(seeksource.FromBytes(tarBytes))
+		},
+	}
+
+	for name, makeExtractor := range makeExtractors {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sink.Reset()
+
+			var saved []*savior.ExtractorCheckpoint
+			sc := checker.NewTestSaveConsumer(64*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				buf, err := savior.MarshalCheckpoint(checkpoint)
+				if err != nil {
+					return savior.AfterSaveContinue, err
+				}
+				c, err := savior.UnmarshalCheckpoint(buf)
+				if err != nil {
+					return savior.AfterSaveContinue, err
+				}
+				saved = append(saved, c)
+				return savior.AfterSaveContinue, nil
+			})
+
+			// only let the 1st, 3rd and 5th checkpoints through
+			offered := 0
+			ex := makeExtractor()
+			ex.SetSaveConsumer(sc)
+			ex.SetCheckpointFilter(func(checkpoint *savior.ExtractorCheckpoint) bool {
+				offered++
+				return offered%2 == 1 && offered <= 5
+			})
+
+			_, err := ex.Resume(nil, sink)
+			tmust(t, err)
+			tmust(t, sink.Validate())
+
+			assert.True(offered > 5, "should have made more than 5 checkpoints, made %d", offered)
+			assert.EqualValues(3, len(saved))
+
+			// extraction picks up at the last checkpoint that got through
+			last := saved[len(saved)-1]
+			assert.True(last.Progress > 0)
+			ex = makeExtractor()
+			_, err = ex.Resume(last, sink)
+			tmust(t, err)
+			tmust(t, sink.Validate())
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package savior_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// set when the test binary is re-run by Test_CheckpointGob, to resume
+// from a checkpoint in a process that never saw it being made
+const (
+	gobHelperKindEnv = "SAVIOR_GOB_HELPER_KIND"
+	gobHelperDirEnv  = "SAVIOR_GOB_HELPER_DIR"
+)
+
+func makeGobTestExtractor(t *testing.T, kind string, archive []byte) savior.Extractor {
+	switch kind {
+	case "zip":
+		ex, err := zipextractor.New(bytes.NewReader(archive), int64(len(archive)))
+		tmust(t, err)
+		return ex
+	case "tar.gz":
+		return tarextractor.New(gzipsource.New(seeksource.FromBytes(archive)))
+	}
+	t.Fatalf("unknown archive kind %s", kind)
+	return nil
+}
+
+// makeTextArchives returns `size` bytes of text, and a zip and a tar.gz
+// with a single data.txt file containing it, by kind
+func makeTextArchives(t *testing.T, size int) ([]byte, map[string][]byte) {
+	text := new(bytes.Buffer)
+	for i := 0; text.Len() < size; i++ {
+		fmt.Fprintf(text, "%08d: the quick brown fox jumps over the lazy dog\n", i)
+	}
+	data := text.Bytes()
+
+	zipBuf := new(bytes.Buffer)
+	zw := zip.NewWriter(zipBuf)
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   "data.txt",
+		Method: zip.Deflate,
+	})
+	tmust(t, err)
+	_, err = w.Write(data)
+	tmust(t, err)
+	tmust(t, zw.Close())
+
+	tarBuf := new(bytes.Buffer)
+	tw := tar.NewWriter(tarBuf)
+	tmust(t, tw.WriteHeader(&tar.Header{
+		Name:     "data.txt",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+	}))
+	_, err = tw.Write(data)
+	tmust(t, err)
+	tmust(t, tw.Close())
+	tarGzBytes, err := checker.GzipCompress(tarBuf.Bytes())
+	tmust(t, err)
+
+	return data, map[string][]byte{
+		"zip":    zipBuf.Bytes(),
+		"tar.gz": tarGzBytes,
+	}
+}
+
+func Test_CheckpointGob(t *testing.T) {
+	data, archives := makeTextArchives(t, 2*1024*1024)
+
+	for kind, archive := range archives {
+		t.Run(kind, func(t *testing.T) {
+			assert := assert.New(t)
+
+			dir, err := ioutil.TempDir("", "checkpoint-gob-test")
+			tmust(t, err)
+			defer os.RemoveAll(dir)
+			tmust(t, ioutil.WriteFile(filepath.Join(dir, "archive"), archive, 0644))
+
+			sink := &savior.FolderSink{
+				Directory: filepath.Join(dir, "out"),
+				Consumer:  savior.NopConsumer(),
+			}
+
+			// stop at the first checkpoint made in the middle of the file,
+			// and of the deflate stream
+			saved := false
+			ex := makeGobTestExtractor(t, kind, archive)
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				if c.Entry == nil || c.Entry.WriteOffset == 0 || c.SourceCheckpoint == nil || c.SourceCheckpoint.Data == nil {
+					return savior.AfterSaveContinue, nil
+				}
+
+				f, err := os.Create(filepath.Join(dir, "checkpoint"))
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				defer f.Close()
+
+				err = gob.NewEncoder(f).Encode(c)
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				saved = true
+				return savior.AfterSaveStop, nil
+			}))
+			_, err = ex.Resume(nil, sink)
+			assert.Equal(savior.ErrStop, err)
+			tmust(t, sink.Close())
+			assert.True(saved, "should have saved a checkpoint mid-file")
+
+			cmd := exec.Command(os.Args[0], "-test.run=^Test_CheckpointGobHelper$", "-test.v")
+			cmd.Env = append(os.Environ(), gobHelperKindEnv+"="+kind, gobHelperDirEnv+"="+dir)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Logf("helper output:\n%s", out)
+			}
+			tmust(t, err)
+
+			actual, err := ioutil.ReadFile(filepath.Join(dir, "out", "data.txt"))
+			tmust(t, err)
+			assert.True(bytes.Equal(data, actual), "resuming from a gob-decoded checkpoint should work")
+		})
+	}
+}
+
+// Test_CheckpointGobHelper resumes extraction from a gob-encoded checkpoint,
+// in a process of its own, see Test_CheckpointGob.
+func Test_CheckpointGobHelper(t *testing.T) {
+	kind := os.Getenv(gobHelperKindEnv)
+	dir := os.Getenv(gobHelperDirEnv)
+	if kind == "" || dir == "" {
+		t.Skip("only runs as a helper process for Test_CheckpointGob")
+	}
+
+	archive, err := ioutil.ReadFile(filepath.Join(dir, "archive"))
+	tmust(t, err)
+
+	f, err := os.Open(filepath.Join(dir, "checkpoint"))
+	tmust(t, err)
+	defer f.Close()
+
+	c := &savior.ExtractorCheckpoint{}
+	tmust(t, gob.NewDecoder(f).Decode(c))
+	assert.True(t, c.Entry.WriteOffset > 0)
+
+	sink := &savior.FolderSink{
+		Directory: filepath.Join(dir, "out"),
+		Consumer:  savior.NopConsumer(),
+	}
+	_, err = makeGobTestExtractor(t, kind, archive).Resume(c, sink)
+	tmust(t, err)
+	tmust(t, sink.Close())
+}
+
+// Test_CheckpointGobSafe makes sure every registered checkpoint type only
+// has exported fields, since encoding/gob silently skips the others, and
+// that the only interfaces in them are checkpoint data.
+func Test_CheckpointGobSafe(t *testing.T) {
+	types := savior.RegisteredCheckpointData()
+	assert.NotEmpty(t, types)
+	types["savior.ExtractorCheckpoint"] = reflect.TypeOf(&savior.ExtractorCheckpoint{})
+
+	gobEncoder := reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	seen := make(map[reflect.Type]bool)
+
+	var check func(path string, typ reflect.Type)
+	check = func(path string, typ reflect.Type) {
+		if typ.Kind() == reflect.Interface {
+			if !strings.HasSuffix(path, ".Data") {
+				t.Errorf("%s is an interface, only checkpoint data should be", path)
+			}
+			return
+		}
+		if seen[typ] || typ.Implements(gobEncoder) {
+			return
+		}
+		seen[typ] = true
+
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			check(path, typ.Elem())
+		case reflect.Struct:
+			for i := 0; i < typ.NumField(); i++ {
+				field := typ.Field(i)
+				fieldPath := path + "." + field.Name
+				if field.PkgPath != "" {
+					t.Errorf("%s is unexported, and won't survive encoding/gob", fieldPath)
+					continue
+				}
+				check(fieldPath, field.Type)
+			}
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			t.Errorf("%s is a %s, which encoding/gob can't encode", path, typ.Kind())
+		}
+	}
+
+	for name, typ := range types {
+		check(name, typ)
+	}
+}
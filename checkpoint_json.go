@@ -0,0 +1,243 @@
+package savior
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnregisteredCheckpointData is returned when serializing (or
+// deserializing) a checkpoint to JSON, if the type of its `Data` field
+// wasn't registered with RegisterCheckpointData.
+var ErrUnregisteredCheckpointData = errors.New("checkpoint data type isn't registered")
+
+var checkpointDataRegistry = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+// RegisterCheckpointData registers the type of `prototype` as a payload
+// for the `Data` field of SourceCheckpoint and ExtractorCheckpoint, so
+// they can be serialized, both with encoding/gob and to JSON.
+//
+// The type is passed to gob.Register, so gob uses its usual name for it.
+// In JSON, the payload is encoded with encoding/json, along with `name`,
+// which must be unique and stable across versions, since it's what tells
+// decoders which type to decode it as.
+//
+// Sources and extractors in this module register their checkpoint types
+// in init(), and so should others. It panics if `name` or the type is
+// already registered with another name or type.
+func RegisterCheckpointData(name string, prototype interface{}) {
+	gob.Register(prototype)
+
+	t := reflect.TypeOf(prototype)
+
+	checkpointDataRegistry.Lock()
+	defer checkpointDataRegistry.Unlock()
+
+	if previous, ok := checkpointDataRegistry.byName[name]; ok && previous != t {
+		panic("savior: checkpoint data name " + name + " registered twice, for " + previous.String() + " and " + t.String())
+	}
+	if previous, ok := checkpointDataRegistry.byType[t]; ok && previous != name {
+		panic("savior: checkpoint data type " + t.String() + " registered twice, as " + previous + " and " + name)
+	}
+
+	checkpointDataRegistry.byName[name] = t
+	checkpointDataRegistry.byType[t] = name
+}
+
+// checkpointData is how checkpoint payloads are laid out in JSON
+type checkpointData struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func encodeCheckpointData(data interface{}) (*checkpointData, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	t := reflect.TypeOf(data)
+	checkpointDataRegistry.RLock()
+	name, ok := checkpointDataRegistry.byType[t]
+	checkpointDataRegistry.RUnlock()
+	if !ok {
+		return nil, errors.Wrapf(ErrUnregisteredCheckpointData, "encoding %s", t)
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encoding %s", name)
+	}
+
+	return &checkpointData{
+		Type:  name,
+		Value: value,
+	}, nil
+}
+
+func decodeCheckpointData(cd *checkpointData) (interface{}, error) {
+	if cd == nil {
+		return nil, nil
+	}
+
+	checkpointDataRegistry.RLock()
+	t, ok := checkpointDataRegistry.byName[cd.Type]
+	checkpointDataRegistry.RUnlock()
+	if !ok {
+		return nil, errors.Wrapf(ErrUnregisteredCheckpointData, "decoding %q", cd.Type)
+	}
+
+	var ptr reflect.Value
+	if t.Kind() == reflect.Ptr {
+		ptr = reflect.New(t.Elem())
+	} else {
+		ptr = reflect.New(t)
+	}
+
+	err := json.Unmarshal(cd.Value, ptr.Interface())
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding %s", cd.Type)
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return ptr.Interface(), nil
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+type extractorCheckpointJSON struct {
+	SourceCheckpoint *SourceCheckpoint `json:",omitempty"`
+	EntryIndex       int64
+	Entry            *Entry `json:",omitempty"`
+	Progress         float64
+	Data             *checkpointData `json:",omitempty"`
+}
+
+var _ json.Marshaler = (*ExtractorCheckpoint)(nil)
+var _ json.Unmarshaler = (*ExtractorCheckpoint)(nil)
+
+// MarshalJSON encodes the checkpoint, along with its source checkpoint
+// and data, whose type must be registered, see RegisterCheckpointData.
+func (c *ExtractorCheckpoint) MarshalJSON() ([]byte, error) {
+	data, err := encodeCheckpointData(c.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&extractorCheckpointJSON{
+		SourceCheckpoint: c.SourceCheckpoint,
+		EntryIndex:       c.EntryIndex,
+		Entry:            c.Entry,
+		Progress:         c.Progress,
+		Data:             data,
+	})
+}
+
+// UnmarshalJSON decodes a checkpoint encoded by MarshalJSON
+func (c *ExtractorCheckpoint) UnmarshalJSON(buf []byte) error {
+	var cj extractorCheckpointJSON
+	err := json.Unmarshal(buf, &cj)
+	if err != nil {
+		return err
+	}
+
+	data, err := decodeCheckpointData(cj.Data)
+	if err != nil {
+		return err
+	}
+
+	*c = ExtractorCheckpoint{
+		SourceCheckpoint: cj.SourceCheckpoint,
+		EntryIndex:       cj.EntryIndex,
+		Entry:            cj.Entry,
+		Progress:         cj.Progress,
+		Data:             data,
+	}
+	return nil
+}
+
+type sourceCheckpointJSON struct {
+	Offset       int64
+	OutputOffset int64
+	Data         *checkpointData `json:",omitempty"`
+}
+
+var _ json.Marshaler = (*SourceCheckpoint)(nil)
+var _ json.Unmarshaler = (*SourceCheckpoint)(nil)
+
+// MarshalJSON encodes the source checkpoint, along with its data, whose
+// type must be registered, see RegisterCheckpointData.
+func (c *SourceCheckpoint) MarshalJSON() ([]byte, error) {
+	data, err := encodeCheckpointData(c.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&sourceCheckpointJSON{
+		Offset:       c.Offset,
+		OutputOffset: c.OutputOffset,
+		Data:         data,
+	})
+}
+
+// UnmarshalJSON decodes a source checkpoint encoded by MarshalJSON
+func (c *SourceCheckpoint) UnmarshalJSON(buf []byte) error {
+	var scj sourceCheckpointJSON
+	err := json.Unmarshal(buf, &scj)
+	if err != nil {
+		return err
+	}
+
+	data, err := decodeCheckpointData(scj.Data)
+	if err != nil {
+		return err
+	}
+
+	*c = SourceCheckpoint{
+		Offset:       scj.Offset,
+		OutputOffset: scj.OutputOffset,
+		Data:         data,
+	}
+	return nil
+}
+
+// MarshalCheckpointJSON serializes an extractor checkpoint to JSON. Like
+// MarshalCheckpoint, it refuses checkpoints that aren't portable. The types
+// of all the checkpoint payloads it contains must be registered, see
+// RegisterCheckpointData.
+func MarshalCheckpointJSON(c *ExtractorCheckpoint) ([]byte, error) {
+	if !c.Portable() {
+		return nil, errors.WithStack(ErrNonPortableCheckpoint)
+	}
+
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf, nil
+}
+
+// UnmarshalCheckpointJSON deserializes an extractor checkpoint previously
+// serialized by MarshalCheckpointJSON.
+func UnmarshalCheckpointJSON(buf []byte) (*ExtractorCheckpoint, error) {
+	c := &ExtractorCheckpoint{}
+	err := json.Unmarshal(buf, c)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !c.Portable() {
+		return nil, errors.WithStack(ErrNonPortableCheckpoint)
+	}
+
+	return c, nil
+}
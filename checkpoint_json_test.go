@@ -0,0 +1,125 @@
+package savior_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/flatesource"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckpointJSON(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+	tarBytes := checker.MakeTar(t, sink)
+	tarGzBytes, err := checker.GzipCompress(tarBytes)
+	tmust(t, err)
+
+	makeExtractors := map[string]func() savior.Extractor{
+		"zip": func() savior.Extractor {
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			tmust(t, err)
+			return ex
+		},
+		"tar.gz": func() savior.Extractor {
+			return tarextractor.New(gzipsource.New(seeksource.FromBytes(tarGzBytes)))
+		},
+	}
+
+	for name, makeExtractor := range makeExtractors {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sink.Reset()
+
+			// stop at the first checkpoint taken in the middle of a
+			// deflate stream (and of an entry)
+			var buf []byte
+			sc := checker.NewTestSaveConsumer(64*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				sc := checkpoint.SourceCheckpoint
+				if sc == nil || checkpoint.Entry == nil || checkpoint.Entry.WriteOffset == 0 {
+					return savior.AfterSaveContinue, nil
+				}
+				data := sc.Data
+				if csc, ok := data.(*zipextractor.CRCSourceCheckpoint); ok {
+					// wrapped along with the entry's running CRC-32
+					data = csc.SourceCheckpoint.Data
+				}
+				switch data.(type) {
+				case *flatesource.FlateSourceCheckpoint, *gzipsource.GzipSourceCheckpoint:
+				default:
+					return savior.AfterSaveContinue, nil
+				}
+
+				var err error
+				buf, err = savior.MarshalCheckpointJSON(checkpoint)
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				return savior.AfterSaveStop, nil
+			})
+
+			ex := makeExtractor()
+			ex.SetSaveConsumer(sc)
+			_, err := ex.Resume(nil, sink)
+			assert.Equal(savior.ErrStop, err)
+			assert.NotEmpty(buf, "should have saved a checkpoint mid-deflate")
+			assert.True(json.Valid(buf))
+
+			c, err := savior.UnmarshalCheckpointJSON(buf)
+			tmust(t, err)
+			assert.NotNil(c.SourceCheckpoint.Data)
+
+			// encoding it again gives the same thing
+			buf2, err := savior.MarshalCheckpointJSON(c)
+			tmust(t, err)
+			assert.Equal(string(buf), string(buf2))
+
+			ex = makeExtractor()
+			_, err = ex.Resume(c, sink)
+			tmust(t, err)
+			tmust(t, sink.Validate())
+		})
+	}
+}
+
+type unregisteredData struct {
+	Foo int
+}
+
+func Test_CheckpointJSONRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &savior.ExtractorCheckpoint{
+		EntryIndex: 1,
+		SourceCheckpoint: &savior.SourceCheckpoint{
+			Offset: 12,
+			Data:   &unregisteredData{Foo: 3},
+		},
+	}
+	_, err := savior.MarshalCheckpointJSON(c)
+	assert.Error(err)
+	assert.Contains(err.Error(), savior.ErrUnregisteredCheckpointData.Error())
+
+	savior.RegisterCheckpointData("savior_test.unregisteredData", &unregisteredData{})
+	buf, err := savior.MarshalCheckpointJSON(c)
+	tmust(t, err)
+
+	c2, err := savior.UnmarshalCheckpointJSON(buf)
+	tmust(t, err)
+	assert.EqualValues(&unregisteredData{Foo: 3}, c2.SourceCheckpoint.Data)
+
+	_, err = savior.UnmarshalCheckpointJSON([]byte(`{"EntryIndex":1,"Data":{"type":"nope.Nope","value":{}}}`))
+	assert.Error(err)
+	assert.Contains(err.Error(), savior.ErrUnregisteredCheckpointData.Error())
+
+	assert.Panics(func() {
+		savior.RegisterCheckpointData("savior_test.unregisteredData", &savior.SourceCheckpoint{})
+	})
+}
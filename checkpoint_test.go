@@ -0,0 +1,132 @@
+package savior_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/flatesource"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckpointPortable(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &savior.ExtractorCheckpoint{
+		EntryIndex: 3,
+		Entry: &savior.Entry{
+			CanonicalPath: "data/level1.pak",
+			WriteOffset:   1024,
+		},
+		SourceCheckpoint: &savior.SourceCheckpoint{
+			Offset: 512,
+			Data: &flatesource.FlateSourceCheckpoint{
+				SourceCheckpoint: &savior.SourceCheckpoint{
+					Offset: 256,
+				},
+			},
+		},
+	}
+	assert.True(c.Portable())
+
+	buf, err := savior.MarshalCheckpoint(c)
+	tmust(t, err)
+
+	c2, err := savior.UnmarshalCheckpoint(buf)
+	tmust(t, err)
+	assert.EqualValues(c.EntryIndex, c2.EntryIndex)
+	assert.EqualValues(c.Entry.CanonicalPath, c2.Entry.CanonicalPath)
+	assert.EqualValues(c.Entry.WriteOffset, c2.Entry.WriteOffset)
+	assert.EqualValues(c.SourceCheckpoint.Offset, c2.SourceCheckpoint.Offset)
+
+	for _, p := range []string{"/tmp/extract/data/level1.pak", "C:\\extract\\level1.pak", "../outside"} {
+		c.Entry.CanonicalPath = p
+		assert.False(c.Portable(), "%s should not be portable", p)
+
+		_, err = savior.MarshalCheckpoint(c)
+		assert.Error(err)
+		assert.True(errors.Cause(err) == savior.ErrNonPortableCheckpoint)
+	}
+	c.Entry.CanonicalPath = "data/level1.pak"
+
+	// nested source checkpoints are audited too
+	c.SourceCheckpoint.Data.(*flatesource.FlateSourceCheckpoint).SourceCheckpoint.Offset = -1
+	assert.False(c.Portable())
+}
+
+func Test_CheckpointCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	// the checkpoint contains the last 32KiB of output, which
+	// compresses about as well as the data itself (unlike, say, random data)
+	text := new(bytes.Buffer)
+	for i := 0; text.Len() < 4*1024*1024; i++ {
+		fmt.Fprintf(text, "%08d: the quick brown fox jumps over the lazy dog\n", i)
+	}
+	data := text.Bytes()
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   "data.bin",
+		Method: zip.Deflate,
+	})
+	tmust(t, err)
+	_, err = w.Write(data)
+	tmust(t, err)
+	tmust(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	dir, err := ioutil.TempDir("", "checkpoint-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+
+	var plain, compressed []byte
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	tmust(t, err)
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		plain, err = savior.MarshalCheckpoint(c)
+		if err != nil {
+			return savior.AfterSaveStop, err
+		}
+		compressed, err = savior.MarshalCheckpoint(c, savior.WithCompression(true))
+		if err != nil {
+			return savior.AfterSaveStop, err
+		}
+		return savior.AfterSaveStop, nil
+	}))
+	_, err = ex.Resume(nil, sink)
+	assert.Equal(savior.ErrStop, err)
+
+	t.Logf("checkpoint: %d bytes, %d bytes compressed", len(plain), len(compressed))
+	assert.True(len(compressed) < len(plain)*3/4, "compressed checkpoint should be meaningfully smaller")
+
+	c, err := savior.UnmarshalCheckpoint(compressed)
+	tmust(t, err)
+	c2, err := savior.UnmarshalCheckpoint(plain)
+	tmust(t, err)
+	assert.EqualValues(c2.SourceCheckpoint.Offset, c.SourceCheckpoint.Offset)
+
+	ex, err = zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	tmust(t, err)
+	_, err = ex.Resume(c, sink)
+	tmust(t, err)
+	tmust(t, sink.Close())
+
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "data.bin"))
+	tmust(t, err)
+	assert.True(bytes.Equal(data, actual), "resuming from a compressed checkpoint should work")
+}
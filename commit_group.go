@@ -0,0 +1,96 @@
+package savior
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// groupStagingDir is the folder, in FolderSink.Directory, files are
+// written to until their group is committed, see GroupCommits
+const groupStagingDir = ".savior-staging"
+
+// isStagingPath returns true if a canonical path is in the staging folder
+// (or is the folder itself). Case is ignored, for filesystems that do.
+func isStagingPath(canonicalPath string) bool {
+	first := strings.SplitN(path.Clean(canonicalPath), "/", 2)[0]
+	return strings.EqualFold(first, groupStagingDir)
+}
+
+// filePath returns where a file entry is written: its destination,
+// or the staging folder when commits are grouped
+func (fs *FolderSink) filePath(entry *Entry) string {
+	if !fs.GroupCommits {
+		return fs.destPath(entry)
+	}
+	return filepath.Join(fs.Directory, groupStagingDir, filepath.FromSlash(entry.CanonicalPath))
+}
+
+// CommitGroup moves all staged files into place, see GroupCommits.
+// Files are moved one by one: if that's interrupted, the next call
+// moves the rest.
+func (fs *FolderSink) CommitGroup() error {
+	err := fs.Close()
+	if err != nil {
+		return errors.Wrap(err, "closing previous writer")
+	}
+
+	staging := filepath.Join(fs.Directory, groupStagingDir)
+	if _, err := os.Lstat(staging); err != nil {
+		if os.IsNotExist(err) {
+			// nothing was staged
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
+	err = filepath.Walk(staging, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(staging, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		entry := &Entry{CanonicalPath: filepath.ToSlash(rel)}
+
+		err = fs.checkDestPath(entry)
+		if err != nil {
+			return err
+		}
+		dstpath := fs.destPath(entry)
+		if stats, err := os.Lstat(dstpath); err == nil && stats.IsDir() {
+			err = fs.replaceDir(entry, dstpath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = os.MkdirAll(filepath.Dir(dstpath), fs.parentMode(entry))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = os.Rename(p, dstpath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "committing group")
+	}
+
+	err = os.RemoveAll(staging)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
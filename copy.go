@@ -10,6 +10,10 @@ import (
 // AfterActionStop.
 var ErrStop = errors.New("copy was stopped after save!")
 
+// ErrTruncatedEntry is returned when an entry's data ends before
+// its declared (uncompressed) size has been produced.
+var ErrTruncatedEntry = errors.New("entry data is shorter than its declared size")
+
 type EmitProgressFunc func()
 
 type Savable interface {
@@ -53,6 +57,20 @@ func (c *Copier) Do(params *CopyParams) error {
 
 	var progressCounter int64
 
+	// entries are resumed at WriteOffset, which sinks may or may not
+	// update as they go, so keep our own count
+	var written int64
+	var startOffset int64
+	emitProgress := params.EmitProgress
+	if params.Entry != nil {
+		startOffset = params.Entry.WriteOffset
+		if !params.Entry.SizeKnown() {
+			// there's no telling how far along we are, progress
+			// stays put until the entry is done
+			emitProgress = nil
+		}
+	}
+
 	for !c.stop {
 		n, readErr := params.Src.Read(c.buf)
 
@@ -61,18 +79,19 @@ func (c *Copier) Do(params *CopyParams) error {
 			return errors.WithStack(err)
 		}
 
+		written += int64(m)
 		progressCounter += int64(m)
 		if progressCounter > progressThreshold {
 			progressCounter = 0
-			if params.EmitProgress != nil {
-				params.EmitProgress()
+			if emitProgress != nil {
+				emitProgress()
 			}
 		}
 
 		if readErr != nil {
 			if readErr == io.EOF {
-				// cool, we're done!
-				return nil
+				// cool, we're done! (unless we're not)
+				return c.checkSize(params.Entry, startOffset+written)
 			}
 			return errors.WithStack(readErr)
 		}
@@ -85,6 +104,21 @@ func (c *Copier) Do(params *CopyParams) error {
 	return nil
 }
 
+// checkSize returns ErrTruncatedEntry if fewer than entry.UncompressedSize
+// bytes were produced. Entries with an unknown (zero or negative) size
+// aren't checked.
+func (c *Copier) checkSize(entry *Entry, produced int64) error {
+	if entry == nil || entry.UncompressedSize <= 0 {
+		return nil
+	}
+
+	if produced < entry.UncompressedSize {
+		return errors.Wrapf(ErrTruncatedEntry, "%s: got %d bytes, expected %d (%d short)",
+			entry.CanonicalPath, produced, entry.UncompressedSize, entry.UncompressedSize-produced)
+	}
+	return nil
+}
+
 func (c *Copier) Stop() {
 	c.stop = true
 }
@@ -0,0 +1,71 @@
+package savior_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type nopSavable struct{}
+
+func (ns nopSavable) WantSave() {}
+
+func Test_CopierTruncated(t *testing.T) {
+	assert := assert.New(t)
+
+	doCopy := func(entry *savior.Entry, data []byte) error {
+		return savior.NewCopier(savior.NopSaveConsumer()).Do(&savior.CopyParams{
+			Src:     bytes.NewReader(data),
+			Dst:     ioutil.Discard,
+			Entry:   entry,
+			Savable: nopSavable{},
+		})
+	}
+
+	data := make([]byte, 100*1024)
+
+	// exactly the declared size
+	assert.NoError(doCopy(&savior.Entry{CanonicalPath: "a", UncompressedSize: int64(len(data))}, data))
+
+	// resumed half-way
+	assert.NoError(doCopy(&savior.Entry{CanonicalPath: "a", UncompressedSize: int64(len(data)), WriteOffset: 1024}, data[1024:]))
+
+	// unknown size
+	assert.NoError(doCopy(&savior.Entry{CanonicalPath: "a"}, data))
+
+	err := doCopy(&savior.Entry{CanonicalPath: "a", UncompressedSize: int64(len(data)) + 1}, data)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrTruncatedEntry)
+}
+
+func Test_CopierUnknownSize(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make([]byte, 4*1024*1024)
+	doCopy := func(entry *savior.Entry) (int, error) {
+		progressCalls := 0
+		err := savior.NewCopier(savior.NopSaveConsumer()).Do(&savior.CopyParams{
+			Src:     bytes.NewReader(data),
+			Dst:     ioutil.Discard,
+			Entry:   entry,
+			Savable: nopSavable{},
+			EmitProgress: func() {
+				progressCalls++
+			},
+		})
+		return progressCalls, err
+	}
+
+	progressCalls, err := doCopy(&savior.Entry{CanonicalPath: "a", UncompressedSize: int64(len(data))})
+	tmust(t, err)
+	assert.True(progressCalls > 0)
+
+	// no false truncation, and no telling how far along we are
+	progressCalls, err = doCopy(&savior.Entry{CanonicalPath: "a", UncompressedSize: savior.UnknownSize})
+	tmust(t, err)
+	assert.EqualValues(0, progressCalls)
+}
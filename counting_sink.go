@@ -0,0 +1,218 @@
+package savior
+
+import "sync"
+
+// SinkStats holds totals for what went through a CountingSink
+type SinkStats struct {
+	// Dirs is the number of directories created
+	Dirs int64
+	// Files is the number of files written (or opened for writing)
+	Files int64
+	// Symlinks is the number of symlinks made
+	Symlinks int64
+	// Hardlinks is the number of hardlinks made
+	Hardlinks int64
+	// Bytes is the total size of files written
+	Bytes int64
+}
+
+// CountingSink wraps another sink and counts directories, files, symlinks
+// and bytes written to it, see Stats. All the actual work is delegated
+// to the inner sink. Preallocated files aren't counted until they're
+// written.
+//
+// Entries are counted once, no matter how many times they're written,
+// and bytes are counted as the size of each file rather than a running
+// total, so resumes (which reopen writers at WriteOffset, and may rewrite
+// part of a file) don't count anything twice.
+type CountingSink struct {
+	forwardingSink
+
+	mu sync.Mutex
+	// kinds and sizes of everything that went through, by canonical path
+	entries map[string]*countedEntry
+}
+
+type countedEntry struct {
+	kind EntryKind
+	size int64
+}
+
+var _ Sink = (*CountingSink)(nil)
+var _ ResumeOffsetter = (*CountingSink)(nil)
+var _ HardlinkSink = (*CountingSink)(nil)
+var _ EntryRestarter = (*CountingSink)(nil)
+var _ PathSink = (*CountingSink)(nil)
+var _ SparseSink = (*CountingSink)(nil)
+var _ ReflinkSink = (*CountingSink)(nil)
+var _ GroupCommitter = (*CountingSink)(nil)
+var _ EntryRemover = (*CountingSink)(nil)
+
+// NewCountingSink returns a new CountingSink that delegates to inner
+func NewCountingSink(inner Sink) *CountingSink {
+	return &CountingSink{
+		forwardingSink: forwardingSink{inner: inner},
+		entries:        make(map[string]*countedEntry),
+	}
+}
+
+// Stats returns totals for everything that went through the sink so far
+func (cs *CountingSink) Stats() SinkStats {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var stats SinkStats
+	for _, ce := range cs.entries {
+		switch ce.kind {
+		case EntryKindDir:
+			stats.Dirs++
+		case EntryKindSymlink:
+			stats.Symlinks++
+		case EntryKindHardlink:
+			stats.Hardlinks++
+		default:
+			stats.Files++
+			stats.Bytes += ce.size
+		}
+	}
+	return stats
+}
+
+func (cs *CountingSink) record(entry *Entry, kind EntryKind, size int64) *countedEntry {
+	ce := &countedEntry{
+		kind: kind,
+		size: size,
+	}
+	cs.mu.Lock()
+	cs.entries[entry.CanonicalPath] = ce
+	cs.mu.Unlock()
+	return ce
+}
+
+func (cs *CountingSink) Mkdir(entry *Entry) error {
+	err := cs.forwardingSink.Mkdir(entry)
+	if err != nil {
+		return err
+	}
+	cs.record(entry, EntryKindDir, 0)
+	return nil
+}
+
+func (cs *CountingSink) Symlink(entry *Entry, linkname string) error {
+	err := cs.forwardingSink.Symlink(entry, linkname)
+	if err != nil {
+		return err
+	}
+	cs.record(entry, EntryKindSymlink, 0)
+	return nil
+}
+
+// Hardlink is passed to the inner sink, if it can make hardlinks.
+// Hardlinks don't count towards Bytes, since they share their
+// target's data.
+func (cs *CountingSink) Hardlink(entry *Entry, target string) error {
+	err := cs.forwardingSink.Hardlink(entry, target)
+	if err != nil {
+		return err
+	}
+	cs.record(entry, EntryKindHardlink, 0)
+	return nil
+}
+
+func (cs *CountingSink) GetWriter(entry *Entry) (EntryWriter, error) {
+	w, err := cs.forwardingSink.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is (re)opened at WriteOffset, anything after that is gone
+	ce := cs.record(entry, EntryKindFile, entry.WriteOffset)
+
+	return &countingEntryWriter{
+		cs: cs,
+		w:  w,
+		ce: ce,
+	}, nil
+}
+
+// WriteSparse is passed to the inner sink, if it's a SparseSink,
+// otherwise holes are written out by its GetWriter. Holes count
+// towards Bytes either way.
+func (cs *CountingSink) WriteSparse(entry *Entry, segments []SparseSegment) (EntryWriter, error) {
+	w, err := cs.forwardingSink.WriteSparse(entry, segments)
+	if err != nil {
+		return nil, err
+	}
+	ce := cs.record(entry, EntryKindFile, entry.WriteOffset)
+
+	return &countingEntryWriter{
+		cs: cs,
+		w:  w,
+		ce: ce,
+	}, nil
+}
+
+// Reflink is passed to the inner sink, if it's a ReflinkSink.
+// Reflinked files are counted like written ones.
+func (cs *CountingSink) Reflink(entry *Entry, source string) (bool, error) {
+	reflinked, err := cs.forwardingSink.Reflink(entry, source)
+	if err != nil || !reflinked {
+		return reflinked, err
+	}
+	cs.record(entry, EntryKindFile, entry.UncompressedSize)
+	return true, nil
+}
+
+// RemoveEntry is passed to the inner sink, if it can remove entries,
+// and the entry stops being counted
+func (cs *CountingSink) RemoveEntry(entry *Entry) error {
+	if _, ok := cs.inner.(EntryRemover); !ok {
+		return nil
+	}
+
+	err := cs.forwardingSink.RemoveEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	if ce, ok := cs.entries[entry.CanonicalPath]; ok && ce.kind != EntryKindDir {
+		delete(cs.entries, entry.CanonicalPath)
+	}
+	cs.mu.Unlock()
+	return nil
+}
+
+// Nuke resets the counts, and nukes the inner sink
+func (cs *CountingSink) Nuke() error {
+	cs.mu.Lock()
+	cs.entries = make(map[string]*countedEntry)
+	cs.mu.Unlock()
+
+	return cs.forwardingSink.Nuke()
+}
+
+type countingEntryWriter struct {
+	cs *CountingSink
+	w  EntryWriter
+	ce *countedEntry
+}
+
+var _ EntryWriter = (*countingEntryWriter)(nil)
+
+func (cew *countingEntryWriter) Write(buf []byte) (int, error) {
+	n, err := cew.w.Write(buf)
+
+	cew.cs.mu.Lock()
+	cew.ce.size += int64(n)
+	cew.cs.mu.Unlock()
+	return n, err
+}
+
+func (cew *countingEntryWriter) Close() error {
+	return cew.w.Close()
+}
+
+func (cew *countingEntryWriter) Sync() error {
+	return cew.w.Sync()
+}
@@ -0,0 +1,177 @@
+package savior_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CountingSink(t *testing.T) {
+	assert := assert.New(t)
+
+	cs := savior.NewCountingSink(savior.NewMemorySink())
+
+	tmust(t, cs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "dir",
+	}))
+	tmust(t, cs.Symlink(&savior.Entry{
+		Kind:          savior.EntryKindSymlink,
+		CanonicalPath: "link",
+	}, "dir/file"))
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "dir/file",
+		UncompressedSize: 11,
+	}
+	tmust(t, cs.Preallocate(entry))
+	assert.EqualValues(savior.SinkStats{Dirs: 1, Symlinks: 1}, cs.Stats())
+
+	w, err := cs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("hello th"))
+	tmust(t, err)
+	assert.EqualValues(8, cs.Stats().Bytes)
+
+	// resumed from a checkpoint taken a bit earlier: the last
+	// two bytes are written again, and only counted once
+	entry.WriteOffset = 6
+	w, err = cs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("there"))
+	tmust(t, err)
+	tmust(t, w.Close())
+
+	tmust(t, cs.Hardlink(&savior.Entry{
+		Kind:          savior.EntryKindHardlink,
+		CanonicalPath: "hardlink",
+	}, "dir/file"))
+
+	assert.EqualValues(savior.SinkStats{
+		Dirs:      1,
+		Files:     1,
+		Symlinks:  1,
+		Hardlinks: 1,
+		Bytes:     11,
+	}, cs.Stats())
+
+	tmust(t, cs.Nuke())
+	assert.EqualValues(savior.SinkStats{}, cs.Stats())
+}
+
+func Test_CountingSinkExtractWithResumes(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	var expected savior.SinkStats
+	for _, item := range sink.Items {
+		switch item.Entry.Kind {
+		case savior.EntryKindDir:
+			expected.Dirs++
+		case savior.EntryKindSymlink:
+			expected.Symlinks++
+		case savior.EntryKindFile:
+			expected.Files++
+			expected.Bytes += int64(len(item.Data))
+		}
+	}
+
+	cs := savior.NewCountingSink(savior.NewMemorySink())
+	var c *savior.ExtractorCheckpoint
+	sc := checker.NewTestSaveConsumer(512*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		if c != nil && checkpoint.Progress <= c.Progress {
+			return savior.AfterSaveContinue, nil
+		}
+		buf, err := savior.MarshalCheckpoint(checkpoint)
+		if err != nil {
+			return savior.AfterSaveContinue, err
+		}
+		c, err = savior.UnmarshalCheckpoint(buf)
+		if err != nil {
+			return savior.AfterSaveContinue, err
+		}
+		return savior.AfterSaveStop, nil
+	})
+
+	numResumes := 0
+	for {
+		if numResumes > 128 {
+			t.Fatal("too many resumes, something must be wrong")
+		}
+
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		ex.SetSaveConsumer(sc)
+
+		_, err = ex.Resume(c, cs)
+		if errors.Cause(err) == savior.ErrStop {
+			numResumes++
+			continue
+		}
+		tmust(t, err)
+		break
+	}
+	assert.True(numResumes > 0)
+	assert.EqualValues(expected, cs.Stats())
+}
+
+func Test_CountingSinkForwarding(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "countingsink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+	cs := savior.NewCountingSink(fs)
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "data/file",
+		UncompressedSize: 4,
+	}
+	destPath, ok := savior.DestPathOf(cs, entry)
+	assert.True(ok)
+	assert.EqualValues(fs.DestPath(entry), destPath)
+
+	w, err := cs.WriteSparse(entry, []savior.SparseSegment{{Offset: 0, Size: 4}})
+	tmust(t, err)
+	_, err = w.Write([]byte("data"))
+	tmust(t, err)
+	tmust(t, w.Close())
+	tmust(t, cs.CommitGroup())
+	assert.EqualValues(savior.SinkStats{Files: 1, Bytes: 4}, cs.Stats())
+
+	tmust(t, cs.RemoveEntry(entry))
+	_, err = os.Stat(destPath)
+	assert.True(os.IsNotExist(err))
+	assert.EqualValues(savior.SinkStats{}, cs.Stats())
+
+	// what the inner sink can't do
+	entry.WriteOffset = 0
+	cs = savior.NewCountingSink(savior.NewMemorySink())
+	_, ok = savior.DestPathOf(cs, entry)
+	assert.False(ok)
+	_, ok = cs.ReflinkSource(entry)
+	assert.False(ok)
+	assert.EqualValues(savior.ErrGroupsUnsupported, errors.Cause(cs.CommitGroup()))
+
+	w, err = cs.WriteSparse(entry, nil)
+	tmust(t, err)
+	_, err = w.Write([]byte("data"))
+	tmust(t, err)
+	tmust(t, w.Close())
+	assert.EqualValues(savior.SinkStats{Files: 1, Bytes: 4}, cs.Stats())
+}
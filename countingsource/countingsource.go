@@ -66,6 +66,10 @@ func (cs *countingSource) Progress() float64 {
 	return cs.source.Progress()
 }
 
+func (cs *countingSource) Close() error {
+	return cs.source.Close()
+}
+
 func (cs *countingSource) WantSave() {
 	cs.source.WantSave()
 }
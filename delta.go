@@ -0,0 +1,74 @@
+package savior
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoBase is returned when extracting a delta entry without
+// a base to apply it against
+var ErrNoBase = errors.New("delta entry needs a base, but none was set")
+
+// A Base gives read access to the files of a previous version of
+// what's being extracted, so that delta entries can be applied against it.
+type Base interface {
+	// Open returns a reader for the file at canonicalPath
+	Open(canonicalPath string) (io.ReadCloser, error)
+}
+
+// A DeltaExtractor is an Extractor that supports delta entries
+type DeltaExtractor interface {
+	Extractor
+
+	// SetBase sets the base that delta entries are applied against
+	SetBase(base Base)
+}
+
+// A DeltaFunc reconstructs a delta entry: it reads the patch, and
+// writes the resulting file to w, reading from base as needed.
+type DeltaFunc func(base Base, entry *Entry, patch io.Reader, w io.Writer) error
+
+// CopyFromBase is the simplest delta method: the patch is the
+// canonical path of a file in the base, which is copied as-is.
+func CopyFromBase(base Base, entry *Entry, patch io.Reader, w io.Writer) error {
+	pathBytes, err := ioutil.ReadAll(patch)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	r, err := base.Open(strings.TrimSpace(string(pathBytes)))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// FolderBase is a Base backed by a folder on disk
+type FolderBase struct {
+	Directory string
+}
+
+var _ Base = (*FolderBase)(nil)
+
+func (fb *FolderBase) Open(canonicalPath string) (io.ReadCloser, error) {
+	if !IsRelativeCanonicalPath(canonicalPath) {
+		return nil, errors.Errorf("base path %q escapes the base folder", canonicalPath)
+	}
+
+	f, err := os.Open(filepath.Join(fb.Directory, filepath.FromSlash(canonicalPath)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
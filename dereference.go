@@ -0,0 +1,133 @@
+package savior
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDanglingSymlink is returned when dereferencing a symlink whose
+// target wasn't extracted (yet), see `FolderSink.DereferenceSymlinks`
+var ErrDanglingSymlink = errors.New("symlink target doesn't exist")
+
+// dereferenceSymlink writes a copy of what the symlink entry points to
+// in its place, see DereferenceSymlinks
+func (fs *FolderSink) dereferenceSymlink(entry *Entry, linkname string) error {
+	target := strings.Replace(linkname, "\\", "/", -1)
+	if path.IsAbs(target) || (len(target) >= 2 && target[1] == ':') {
+		return errors.Wrapf(ErrUnsafePath, "%s: can't dereference absolute symlink target %s", entry.CanonicalPath, linkname)
+	}
+	target = path.Join(path.Dir(entry.CanonicalPath), target)
+	if !IsRelativeCanonicalPath(target) {
+		return errors.Wrapf(ErrUnsafePath, "%s: can't dereference symlink target %s, it's outside the destination", entry.CanonicalPath, linkname)
+	}
+
+	linkPath := path.Clean(entry.CanonicalPath)
+	if target == "." || target == linkPath || strings.HasPrefix(linkPath, target+"/") {
+		return errors.Wrapf(ErrUnsafePath, "%s: can't dereference symlink to %s, it contains the symlink", entry.CanonicalPath, linkname)
+	}
+
+	err := fs.checkDestPath(entry)
+	if err != nil {
+		return err
+	}
+	err = fs.checkDestPath(&Entry{CanonicalPath: target})
+	if err != nil {
+		return err
+	}
+
+	// the target may be the file we're writing, it must be complete
+	err = fs.Close()
+	if err != nil {
+		return errors.Wrap(err, "closing previous writer")
+	}
+
+	srcpath := filepath.Join(fs.Directory, filepath.FromSlash(target))
+	resolved, err := filepath.EvalSymlinks(srcpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if fs.SkipDanglingSymlinks {
+				fs.Consumer.Warnf("folder_sink: skipping symlink (%s), its target %s doesn't exist", entry.CanonicalPath, linkname)
+				return nil
+			}
+			return errors.Wrapf(ErrDanglingSymlink, "%s: %s", entry.CanonicalPath, linkname)
+		}
+		return errors.WithStack(err)
+	}
+	root, err := filepath.EvalSymlinks(fs.Directory)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !isWithin(root, resolved) {
+		return errors.Wrapf(ErrUnsafePath, "%s: symlink target %s resolves to %s", entry.CanonicalPath, linkname, resolved)
+	}
+
+	srcstats, err := os.Stat(resolved)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dstpath := fs.destPath(entry)
+	if stats, err := os.Lstat(dstpath); err == nil {
+		if stats.IsDir() && !srcstats.IsDir() && fs.KeepDirectories {
+			return errors.Wrapf(ErrPathConflict, "%s", entry.CanonicalPath)
+		}
+		// it may be a copy we made before we got interrupted
+		err = os.RemoveAll(dstpath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(dstpath), fs.parentMode(entry))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if srcstats.IsDir() {
+		return fs.copyTree(resolved, dstpath)
+	}
+	return copyFile(resolved, dstpath, fs.copyMode(srcstats))
+}
+
+// copyMode returns the mode copies of a file are created with
+func (fs *FolderSink) copyMode(stats os.FileInfo) os.FileMode {
+	if fs.enforcesModes() {
+		// the original has the right mode already
+		return stats.Mode().Perm()
+	}
+	return stats.Mode() | ModeMask
+}
+
+// copyTree copies the directories and regular files under `srcpath`
+// to `dstpath`, which must not exist. Anything else is skipped.
+func (fs *FolderSink) copyTree(srcpath string, dstpath string) error {
+	return filepath.Walk(srcpath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		rel, err := filepath.Rel(srcpath, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dst := filepath.Join(dstpath, rel)
+
+		switch {
+		case info.IsDir():
+			err = os.Mkdir(dst, info.Mode().Perm())
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			return nil
+		case info.Mode().IsRegular():
+			return copyFile(p, dst, fs.copyMode(info))
+		default:
+			fs.Consumer.Warnf("folder_sink: not copying %s while dereferencing, it's not a regular file", p)
+			return nil
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package savior
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrSizeMismatch is returned by DiscardSink when an entry's data
+// isn't as long as its declared size
+var ErrSizeMismatch = errors.New("entry data doesn't match its declared size")
+
+// DiscardSink throws away everything written to it, but checks that files
+// are exactly as long as their declared size: extracting to it makes sure an
+// archive extracts cleanly (every entry decompresses, to the right size)
+// without writing anything anywhere.
+//
+// Writing past a file's UncompressedSize fails right away, with
+// ErrSizeMismatch. Files that end short fail when their writer is closed, or
+// when the next file is opened, or when the sink is closed - extractors don't
+// all close their writers. Since the sink can't tell a short file from an
+// extraction that was stopped mid-file, only close it once extraction is done.
+// Files of unknown size (see Entry.SizeKnown) aren't checked.
+type DiscardSink struct {
+	// last writer returned by GetWriter, until it's closed
+	writer *discardEntryWriter
+}
+
+var _ Sink = (*DiscardSink)(nil)
+var _ HardlinkSink = (*DiscardSink)(nil)
+
+func (ds *DiscardSink) Mkdir(entry *Entry) error {
+	return nil
+}
+
+func (ds *DiscardSink) Symlink(entry *Entry, linkname string) error {
+	return nil
+}
+
+func (ds *DiscardSink) Hardlink(entry *Entry, target string) error {
+	return nil
+}
+
+// GetWriter returns a writer that discards data, and advances
+// entry.WriteOffset. If another file was being written, it's checked first.
+func (ds *DiscardSink) GetWriter(entry *Entry) (EntryWriter, error) {
+	if ds.writer != nil && ds.writer.entry.CanonicalPath != entry.CanonicalPath {
+		err := ds.writer.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ds.writer = &discardEntryWriter{
+		ds:    ds,
+		entry: entry,
+	}
+	return ds.writer, nil
+}
+
+func (ds *DiscardSink) Preallocate(entry *Entry) error {
+	return nil
+}
+
+func (ds *DiscardSink) Nuke() error {
+	ds.writer = nil
+	return nil
+}
+
+// Close checks the last file written, if its writer wasn't closed
+func (ds *DiscardSink) Close() error {
+	if ds.writer != nil {
+		return ds.writer.Close()
+	}
+	return nil
+}
+
+type discardEntryWriter struct {
+	ds    *DiscardSink
+	entry *Entry
+}
+
+var _ EntryWriter = (*discardEntryWriter)(nil)
+
+func (dew *discardEntryWriter) Write(buf []byte) (int, error) {
+	remaining := dew.entry.UncompressedSize - dew.entry.WriteOffset
+	if dew.entry.SizeKnown() && int64(len(buf)) > remaining {
+		return 0, errors.Wrapf(ErrSizeMismatch, "%s: more than %d bytes", dew.entry.CanonicalPath, dew.entry.UncompressedSize)
+	}
+
+	dew.entry.WriteOffset += int64(len(buf))
+	return len(buf), nil
+}
+
+// Close fails with ErrSizeMismatch if fewer bytes than the
+// entry's declared size were written
+func (dew *discardEntryWriter) Close() error {
+	if dew.ds.writer == dew {
+		dew.ds.writer = nil
+	}
+
+	if dew.entry.SizeKnown() && dew.entry.WriteOffset != dew.entry.UncompressedSize {
+		return errors.Wrapf(ErrSizeMismatch, "%s: got %d bytes, expected %d", dew.entry.CanonicalPath, dew.entry.WriteOffset, dew.entry.UncompressedSize)
+	}
+	return nil
+}
+
+func (dew *discardEntryWriter) Sync() error {
+	return nil
+}
@@ -0,0 +1,102 @@
+package savior_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiscardSink(t *testing.T) {
+	assert := assert.New(t)
+
+	ds := &savior.DiscardSink{}
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "exact",
+		UncompressedSize: 11,
+	}
+	w, err := ds.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("hello "))
+	tmust(t, err)
+	_, err = w.Write([]byte("there"))
+	tmust(t, err)
+	assert.EqualValues(11, entry.WriteOffset)
+
+	_, err = w.Write([]byte("!"))
+	assert.EqualValues(savior.ErrSizeMismatch, errors.Cause(err))
+	tmust(t, w.Close())
+
+	// short files fail when the next one is opened
+	w, err = ds.GetWriter(&savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "short",
+		UncompressedSize: 11,
+	})
+	tmust(t, err)
+	_, err = w.Write([]byte("hello"))
+	tmust(t, err)
+
+	_, err = ds.GetWriter(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		CanonicalPath: "next",
+	})
+	assert.EqualValues(savior.ErrSizeMismatch, errors.Cause(err))
+	assert.Contains(err.Error(), "short")
+
+	// ...or when the sink is closed
+	_, err = ds.GetWriter(&savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "last",
+		UncompressedSize: 1,
+	})
+	tmust(t, err)
+	err = ds.Close()
+	assert.EqualValues(savior.ErrSizeMismatch, errors.Cause(err))
+	assert.Contains(err.Error(), "last")
+}
+
+func Test_DiscardSinkExtract(t *testing.T) {
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	tmust(t, err)
+
+	ds := &savior.DiscardSink{}
+	_, err = ex.Resume(nil, ds)
+	tmust(t, err)
+	tmust(t, ds.Close())
+}
+
+func Test_DiscardSinkUnknownSize(t *testing.T) {
+	assert := assert.New(t)
+
+	ds := &savior.DiscardSink{}
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "streamed",
+		UncompressedSize: savior.UnknownSize,
+	}
+	tmust(t, ds.Preallocate(entry))
+	w, err := ds.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("any length goes"))
+	tmust(t, err)
+	assert.EqualValues(15, entry.WriteOffset)
+	tmust(t, ds.Close())
+
+	res := &savior.ExtractorResult{Entries: []*savior.Entry{
+		entry,
+		{Kind: savior.EntryKindFile, CanonicalPath: "known", UncompressedSize: 10},
+	}}
+	assert.EqualValues(10, res.Size())
+	assert.Contains(entry.String(), "unknown size")
+}
@@ -0,0 +1,533 @@
+package savior
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEncryptedCorrupt is returned when reading back an encrypted file
+// whose contents or sidecar don't authenticate: it was tampered with,
+// truncated, or the key is wrong
+var ErrEncryptedCorrupt = errors.New("encrypted file is corrupt, truncated, or the key is wrong")
+
+// ErrEncryptedResume is returned by EncryptedFolderSink.GetWriter when
+// asked to resume a file somewhere else than at the end of its last
+// sealed chunk, see ResumeOffset
+var ErrEncryptedResume = errors.New("encrypted files can only be resumed at the end of their last sealed chunk")
+
+// EncryptedChunkSize is how many bytes of a file are sealed together
+// by EncryptedFolderSink
+const EncryptedChunkSize = 64 * 1024
+
+// encryptedSidecarDir is the folder, in the sink's directory, that
+// holds the nonce and tags of every encrypted file
+const encryptedSidecarDir = ".savior-encryption"
+
+const (
+	encryptedMagic      = "savior\x00\x01"
+	encryptedNonceSize  = 12
+	encryptedTagSize    = 16
+	encryptedHeaderSize = len(encryptedMagic) + 4 + encryptedNonceSize
+)
+
+// A KeyDeriver returns the AES key (16, 24 or 32 bytes) a file
+// is encrypted with, given its canonical path
+type KeyDeriver func(canonicalPath string) ([]byte, error)
+
+// EncryptedFolderSink extracts to a folder like FolderSink, except the
+// contents of files are encrypted before they ever touch the disk. Files
+// are sealed with AES-GCM, in chunks of EncryptedChunkSize bytes: each
+// file has its own random nonce, which is kept, along with the tag of
+// every chunk, in a sidecar file under `.savior-encryption/` in the
+// folder. Files can be read back with OpenSource.
+//
+// Chunks are only written once sealed, so a file resumes at the end of
+// its last sealed chunk (see ResumeOffset): whatever was decompressed
+// past it is decompressed again. Directories and symlinks are created
+// by the FolderSink as usual, so symlink targets aren't encrypted.
+// FolderSink options about the contents of files (TextLineEnding,
+// TransformContent, UseReflinks) don't apply, and GroupCommits isn't
+// supported. Hardlinks aren't supported either, see HardlinkSink.
+type EncryptedFolderSink struct {
+	folder    *FolderSink
+	deriveKey KeyDeriver
+
+	writer *encryptedWriter
+}
+
+var _ Sink = (*EncryptedFolderSink)(nil)
+var _ ResumeOffsetter = (*EncryptedFolderSink)(nil)
+
+// NewEncryptedFolderSink returns a sink that extracts to folder, and
+// encrypts files with the keys deriveKey returns.
+func NewEncryptedFolderSink(folder *FolderSink, deriveKey KeyDeriver) *EncryptedFolderSink {
+	return &EncryptedFolderSink{
+		folder:    folder,
+		deriveKey: deriveKey,
+	}
+}
+
+func (efs *EncryptedFolderSink) Mkdir(entry *Entry) error {
+	return efs.folder.Mkdir(entry)
+}
+
+func (efs *EncryptedFolderSink) Symlink(entry *Entry, linkname string) error {
+	return efs.folder.Symlink(entry, linkname)
+}
+
+// Preallocate does nothing: sizes on disk tell how much of a file was
+// sealed, see ResumeOffset
+func (efs *EncryptedFolderSink) Preallocate(entry *Entry) error {
+	return nil
+}
+
+func (efs *EncryptedFolderSink) sidecarPath(canonicalPath string) string {
+	return filepath.Join(efs.folder.Directory, encryptedSidecarDir, filepath.FromSlash(canonicalPath))
+}
+
+// newAEAD returns the cipher for the file at canonicalPath
+func newAEAD(deriveKey KeyDeriver, canonicalPath string) (cipher.AEAD, error) {
+	key, err := deriveKey(canonicalPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "deriving key for %s", canonicalPath)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "key for %s", canonicalPath)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return aead, nil
+}
+
+// chunkNonce returns the nonce chunk `index` of a file is sealed with
+func chunkNonce(fileNonce []byte, index int64) []byte {
+	nonce := append([]byte(nil), fileNonce...)
+	tail := nonce[encryptedNonceSize-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^uint64(index))
+	return nonce
+}
+
+// chunkData returns the additional data chunk `index` of a file is
+// sealed with: chunks can't be moved to another file, reordered, and
+// the last one is marked so files can't be truncated.
+func chunkData(canonicalPath string, index int64, last bool) []byte {
+	ad := make([]byte, 9, 9+len(canonicalPath))
+	binary.BigEndian.PutUint64(ad, uint64(index))
+	if last {
+		ad[8] = 1
+	}
+	return append(ad, canonicalPath...)
+}
+
+// readSidecarHeader returns the nonce of a file, and the size of its sidecar
+func readSidecarHeader(sidecar *os.File) ([]byte, int64, error) {
+	stats, err := sidecar.Stat()
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	header := make([]byte, encryptedHeaderSize)
+	_, err = sidecar.ReadAt(header, 0)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrEncryptedCorrupt
+		}
+		return nil, 0, errors.Wrap(err, "reading sidecar header")
+	}
+	if !bytes.Equal(header[:len(encryptedMagic)], []byte(encryptedMagic)) {
+		return nil, 0, errors.Wrap(ErrEncryptedCorrupt, "invalid sidecar header")
+	}
+	if binary.LittleEndian.Uint32(header[len(encryptedMagic):]) != EncryptedChunkSize {
+		return nil, 0, errors.Wrap(ErrEncryptedCorrupt, "unsupported chunk size")
+	}
+	return header[len(encryptedMagic)+4:], stats.Size(), nil
+}
+
+// sealedChunks returns how many chunks of a file were sealed, and how
+// many bytes of its contents are on disk, or -1 if it isn't there
+func (efs *EncryptedFolderSink) sealedChunks(entry *Entry) (int64, int64, error) {
+	stats, err := os.Lstat(efs.folder.destPath(entry))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, -1, nil
+		}
+		return 0, 0, errors.WithStack(err)
+	}
+	if !stats.Mode().IsRegular() {
+		return -1, -1, nil
+	}
+
+	sidecar, err := os.Open(efs.sidecarPath(entry.CanonicalPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, -1, nil
+		}
+		return 0, 0, errors.WithStack(err)
+	}
+	defer sidecar.Close()
+
+	_, sidecarSize, err := readSidecarHeader(sidecar)
+	if err != nil {
+		if errors.Cause(err) == ErrEncryptedCorrupt {
+			return -1, -1, nil
+		}
+		return 0, 0, err
+	}
+	return (sidecarSize - int64(encryptedHeaderSize)) / encryptedTagSize, stats.Size(), nil
+}
+
+// ResumeOffset returns the end of the last chunk of the entry that was
+// sealed, if it's where the checkpoint was taken: when the extraction
+// went on to seal more chunks after it, the entry is started over, since
+// chunks are never sealed twice with the same nonce.
+func (efs *EncryptedFolderSink) ResumeOffset(entry *Entry) (int64, error) {
+	if shouldIgnorePath(entry.CanonicalPath) {
+		return entry.WriteOffset, nil
+	}
+	if !entry.SizeKnown() {
+		// their last chunk is sealed whenever they're closed, since
+		// there's no telling if they're complete
+		return 0, nil
+	}
+
+	err := efs.folder.checkDestPath(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	chunks, size, err := efs.sealedChunks(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	offset := chunks * EncryptedChunkSize
+	if chunks != entry.WriteOffset/EncryptedChunkSize || size != offset {
+		return 0, nil
+	}
+	return offset, nil
+}
+
+func (efs *EncryptedFolderSink) GetWriter(entry *Entry) (EntryWriter, error) {
+	err := efs.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "closing previous writer")
+	}
+
+	if shouldIgnorePath(entry.CanonicalPath) || entry.Mode&specialModes != 0 {
+		return efs.folder.GetWriter(entry)
+	}
+	if efs.folder.GroupCommits {
+		return nil, errors.New("EncryptedFolderSink doesn't support GroupCommits")
+	}
+
+	aead, err := newAEAD(efs.deriveKey, entry.CanonicalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.WriteOffset > 0 {
+		if !entry.SizeKnown() {
+			return nil, errors.Wrapf(ErrEncryptedResume, "%s: unknown size", entry.CanonicalPath)
+		}
+		chunks, size, err := efs.sealedChunks(entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.WriteOffset != chunks*EncryptedChunkSize || size != entry.WriteOffset {
+			return nil, errors.Wrapf(ErrEncryptedResume, "%s: at %d", entry.CanonicalPath, entry.WriteOffset)
+		}
+	}
+
+	f, err := efs.folder.createFile(entry)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sidecarPath := efs.sidecarPath(entry.CanonicalPath)
+	err = os.MkdirAll(filepath.Dir(sidecarPath), 0755)
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	sidecar, err := os.OpenFile(sidecarPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	ew := &encryptedWriter{
+		f:         f,
+		sidecar:   sidecar,
+		folder:    efs.folder,
+		entry:     entry,
+		aead:      aead,
+		chunk:     entry.WriteOffset / EncryptedChunkSize,
+		plaintext: make([]byte, 0, EncryptedChunkSize),
+	}
+	err = ew.open()
+	if err != nil {
+		f.Close()
+		sidecar.Close()
+		return nil, err
+	}
+	efs.writer = ew
+
+	return ew, nil
+}
+
+// OpenSource returns a source that decrypts the file extracted at
+// canonicalPath, and checks it wasn't tampered with. It checkpoints
+// between chunks.
+func (efs *EncryptedFolderSink) OpenSource(canonicalPath string) (Source, error) {
+	entry := &Entry{CanonicalPath: canonicalPath}
+	err := efs.folder.checkDestPath(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(efs.deriveKey, canonicalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(efs.folder.destPath(entry))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sidecar, err := os.Open(efs.sidecarPath(canonicalPath))
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	defer sidecar.Close()
+
+	nonce, sidecarSize, err := readSidecarHeader(sidecar)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tags := make([]byte, sidecarSize-int64(encryptedHeaderSize))
+	_, err = sidecar.ReadAt(tags, int64(encryptedHeaderSize))
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	if len(tags) == 0 || len(tags)%encryptedTagSize != 0 {
+		f.Close()
+		return nil, errors.Wrapf(ErrEncryptedCorrupt, "%s: invalid sidecar size", canonicalPath)
+	}
+
+	stats, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	return &encryptedSource{
+		f:             f,
+		size:          stats.Size(),
+		canonicalPath: canonicalPath,
+		aead:          aead,
+		nonce:         nonce,
+		tags:          tags,
+		bytebuf:       []byte{0x00},
+	}, nil
+}
+
+func (efs *EncryptedFolderSink) Nuke() error {
+	err := efs.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return efs.folder.Nuke()
+}
+
+func (efs *EncryptedFolderSink) Close() error {
+	var err error
+	if efs.writer != nil {
+		err = efs.writer.Close()
+		efs.writer = nil
+	}
+
+	folderErr := efs.folder.Close()
+	if err == nil {
+		err = folderErr
+	}
+	return err
+}
+
+type encryptedWriter struct {
+	f       *os.File
+	sidecar *os.File
+	folder  *FolderSink
+	entry   *Entry
+
+	aead  cipher.AEAD
+	nonce []byte
+	// chunk is the index of the next chunk to seal
+	chunk int64
+	// plaintext is what was written since the last sealed chunk,
+	// it's only ever in memory
+	plaintext []byte
+	sealed    []byte
+
+	closeErr error
+	closed   bool
+}
+
+var _ EntryWriter = (*encryptedWriter)(nil)
+
+// open truncates both files to where the entry resumes, and writes a
+// fresh sidecar header for new entries
+func (ew *encryptedWriter) open() error {
+	sidecarSize := int64(encryptedHeaderSize) + ew.chunk*encryptedTagSize
+	if ew.entry.WriteOffset == 0 {
+		ew.nonce = make([]byte, encryptedNonceSize)
+		_, err := io.ReadFull(rand.Reader, ew.nonce)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		header := make([]byte, 0, encryptedHeaderSize)
+		header = append(header, encryptedMagic...)
+		header = append(header, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(header[len(encryptedMagic):], EncryptedChunkSize)
+		header = append(header, ew.nonce...)
+		_, err = ew.sidecar.WriteAt(header, 0)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	} else {
+		nonce, _, err := readSidecarHeader(ew.sidecar)
+		if err != nil {
+			return err
+		}
+		ew.nonce = nonce
+	}
+
+	err := ew.sidecar.Truncate(sidecarSize)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = ew.sidecar.Seek(sidecarSize, io.SeekStart)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = ew.f.Truncate(ew.entry.WriteOffset)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = ew.f.Seek(ew.entry.WriteOffset, io.SeekStart)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (ew *encryptedWriter) Write(buf []byte) (int, error) {
+	if ew.closed {
+		return 0, os.ErrClosed
+	}
+
+	written := 0
+	for len(buf) > 0 {
+		n := EncryptedChunkSize - len(ew.plaintext)
+		if n > len(buf) {
+			n = len(buf)
+		}
+		ew.plaintext = append(ew.plaintext, buf[:n]...)
+		buf = buf[n:]
+		written += n
+		ew.entry.WriteOffset += int64(n)
+
+		if len(ew.plaintext) == EncryptedChunkSize {
+			err := ew.seal(false)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// seal encrypts the pending plaintext as the next chunk. The tag is
+// written first: a chunk whose contents are on disk always has its tag
+// in the sidecar, so it's never sealed again, see ResumeOffset.
+func (ew *encryptedWriter) seal(last bool) error {
+	ad := chunkData(ew.entry.CanonicalPath, ew.chunk, last)
+	ew.sealed = ew.aead.Seal(ew.sealed[:0], chunkNonce(ew.nonce, ew.chunk), ew.plaintext, ad)
+
+	tag := ew.sealed[len(ew.plaintext):]
+	_, err := ew.sidecar.Write(tag)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = ew.f.Write(ew.sealed[:len(ew.plaintext)])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ew.chunk++
+	ew.plaintext = ew.plaintext[:0]
+	return nil
+}
+
+// Sync flushes sealed chunks to disk. The last, incomplete chunk stays
+// in memory, so a checkpoint taken now is resumed from the end of the
+// last sealed chunk.
+func (ew *encryptedWriter) Sync() error {
+	if ew.closed {
+		return os.ErrClosed
+	}
+
+	err := ew.sidecar.Sync()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return ew.f.Sync()
+}
+
+// Close seals the last chunk if the entry is complete (or of unknown
+// size), and drops it otherwise
+func (ew *encryptedWriter) Close() error {
+	if ew.closed {
+		return ew.closeErr
+	}
+	ew.closed = true
+
+	complete := !ew.entry.SizeKnown() || ew.entry.WriteOffset >= ew.entry.UncompressedSize
+	var err error
+	if complete {
+		err = ew.seal(true)
+	}
+
+	sidecarErr := closeFile(ew.sidecar)
+	if err == nil {
+		err = sidecarErr
+	}
+	fileErr := closeFile(ew.f)
+	if err == nil {
+		err = fileErr
+	}
+	if err == nil && complete {
+		err = ew.folder.setModTime(ew.entry, ew.f.Name())
+	}
+	if err != nil {
+		ew.closeErr = errors.Wrapf(err, "closing %s", ew.entry.CanonicalPath)
+	}
+	return ew.closeErr
+}
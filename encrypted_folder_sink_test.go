@@ -0,0 +1,285 @@
+package savior_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeyDeriver(secret string) savior.KeyDeriver {
+	return func(canonicalPath string) ([]byte, error) {
+		key := sha256.Sum256([]byte(secret + "/" + canonicalPath))
+		return key[:], nil
+	}
+}
+
+func readEncrypted(efs *savior.EncryptedFolderSink, canonicalPath string) ([]byte, error) {
+	src, err := efs.OpenSource(canonicalPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	_, err = src.Resume(nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(src)
+}
+
+func Test_EncryptedFolderSink(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSinkAdvanced(12)
+	zipBytes := checker.MakeZip(t, sink)
+
+	dir, err := ioutil.TempDir("", "encrypted-folder-sink")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	deriveKey := testKeyDeriver("hunter2")
+	newSink := func() *savior.EncryptedFolderSink {
+		return savior.NewEncryptedFolderSink(&savior.FolderSink{
+			Directory: dir,
+			Consumer:  savior.NopConsumer(),
+		}, deriveKey)
+	}
+
+	// stop at every few checkpoints, and resume with a fresh sink
+	var c *savior.ExtractorCheckpoint
+	checkpoints := 0
+	stops := 0
+	for {
+		if !assert.True(stops < 50, "too many stops") {
+			return
+		}
+
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(300*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			checkpoints++
+			if checkpoints%4 != 0 {
+				return savior.AfterSaveContinue, nil
+			}
+			bs, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(bs)
+			return savior.AfterSaveStop, err
+		}))
+
+		efs := newSink()
+		_, err = ex.Resume(c, efs)
+		tmust(t, efs.Close())
+		if err == savior.ErrStop {
+			stops++
+			continue
+		}
+		tmust(t, err)
+		break
+	}
+	assert.True(stops > 2, "should have stopped a few times")
+
+	efs := newSink()
+	for name, item := range sink.Items {
+		if item.Entry.Kind != savior.EntryKindFile {
+			continue
+		}
+		actual, err := readEncrypted(efs, name)
+		tmust(t, err)
+		assert.True(bytes.Equal(item.Data, actual), "%s should decrypt to its contents", name)
+	}
+
+	// no plaintext on disk, not even in sidecars
+	tmust(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		onDisk, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for name, item := range sink.Items {
+			data := item.Data
+			for _, i := range []int{0, len(data) / 2, len(data) - 64} {
+				if i < 0 || i+64 > len(data) {
+					continue
+				}
+				assert.False(bytes.Contains(onDisk, data[i:i+64]), "%s holds plaintext from %s", path, name)
+			}
+		}
+		return nil
+	}))
+}
+
+func Test_EncryptedFolderSinkTampering(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "encrypted-folder-sink")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	reference := semirandom.Bytes(1024 * 1024)
+	efs := savior.NewEncryptedFolderSink(&savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}, testKeyDeriver("hunter2"))
+
+	entry := &savior.Entry{
+		CanonicalPath:    "secret.bin",
+		Kind:             savior.EntryKindFile,
+		UncompressedSize: int64(len(reference)),
+	}
+	w, err := efs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write(reference)
+	tmust(t, err)
+	tmust(t, w.Close())
+
+	// the source checkpoints between chunks
+	src, err := efs.OpenSource("secret.bin")
+	tmust(t, err)
+	checker.RunSourceTest(t, src, reference)
+	tmust(t, src.Close())
+
+	expectCorrupt := func(efs *savior.EncryptedFolderSink, msg string) {
+		_, err := readEncrypted(efs, "secret.bin")
+		assert.Error(err, msg)
+		assert.True(errors.Cause(err) == savior.ErrEncryptedCorrupt, msg)
+	}
+
+	// wrong key
+	expectCorrupt(savior.NewEncryptedFolderSink(&savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}, testKeyDeriver("hunter3")), "wrong key")
+
+	path := filepath.Join(dir, "secret.bin")
+	sidecarPath := filepath.Join(dir, ".savior-encryption", "secret.bin")
+	original, err := ioutil.ReadFile(path)
+	tmust(t, err)
+	originalSidecar, err := ioutil.ReadFile(sidecarPath)
+	tmust(t, err)
+
+	// flipped bit
+	tampered := append([]byte(nil), original...)
+	tampered[len(tampered)/2] ^= 0x1
+	tmust(t, ioutil.WriteFile(path, tampered, 0644))
+	expectCorrupt(efs, "flipped bit")
+
+	// truncated by a whole chunk
+	tmust(t, ioutil.WriteFile(path, original[:len(original)-savior.EncryptedChunkSize], 0644))
+	tmust(t, ioutil.WriteFile(sidecarPath, originalSidecar[:len(originalSidecar)-16], 0644))
+	expectCorrupt(efs, "truncated")
+
+	tmust(t, ioutil.WriteFile(path, original, 0644))
+	tmust(t, ioutil.WriteFile(sidecarPath, originalSidecar, 0644))
+	actual, err := readEncrypted(efs, "secret.bin")
+	tmust(t, err)
+	assert.True(bytes.Equal(reference, actual))
+}
+
+func Test_EncryptedFolderSinkResumeOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "encrypted-folder-sink")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	efs := savior.NewEncryptedFolderSink(&savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}, testKeyDeriver("hunter2"))
+
+	reference := semirandom.Bytes(1024 * 1024)
+	entry := &savior.Entry{
+		CanonicalPath:    "big.bin",
+		Kind:             savior.EntryKindFile,
+		UncompressedSize: int64(len(reference)),
+	}
+	w, err := efs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write(reference[:200*1024])
+	tmust(t, err)
+	tmust(t, w.Sync())
+	tmust(t, efs.Close())
+
+	// three chunks were sealed, the rest was dropped
+	sealed := int64(3 * savior.EncryptedChunkSize)
+	offset, err := efs.ResumeOffset(&savior.Entry{CanonicalPath: "big.bin", WriteOffset: 200 * 1024})
+	tmust(t, err)
+	assert.EqualValues(sealed, offset)
+
+	// a checkpoint from before the last chunks were sealed starts over
+	offset, err = efs.ResumeOffset(&savior.Entry{CanonicalPath: "big.bin", WriteOffset: 100 * 1024})
+	tmust(t, err)
+	assert.EqualValues(0, offset)
+
+	entry.WriteOffset = sealed
+	w, err = efs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write(reference[sealed:])
+	tmust(t, err)
+	tmust(t, efs.Close())
+
+	actual, err := readEncrypted(efs, "big.bin")
+	tmust(t, err)
+	assert.True(bytes.Equal(reference, actual))
+
+	// resuming anywhere else fails
+	entry.WriteOffset = 100 * 1024
+	_, err = efs.GetWriter(entry)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrEncryptedResume)
+}
+
+func Test_EncryptedFolderSinkUnknownSize(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "encrypted-folder-sink")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	efs := savior.NewEncryptedFolderSink(&savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}, testKeyDeriver("hunter2"))
+
+	// the last chunk is sealed when the writer is closed
+	reference := semirandom.Bytes(200 * 1024)
+	entry := &savior.Entry{
+		CanonicalPath:    "streamed.bin",
+		Kind:             savior.EntryKindFile,
+		UncompressedSize: savior.UnknownSize,
+	}
+	tmust(t, efs.Preallocate(entry))
+	w, err := efs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write(reference)
+	tmust(t, err)
+	tmust(t, efs.Close())
+
+	actual, err := readEncrypted(efs, "streamed.bin")
+	tmust(t, err)
+	assert.True(bytes.Equal(reference, actual))
+
+	// ...so they're never resumed
+	offset, err := efs.ResumeOffset(&savior.Entry{
+		CanonicalPath:    "streamed.bin",
+		UncompressedSize: savior.UnknownSize,
+		WriteOffset:      3 * savior.EncryptedChunkSize,
+	})
+	tmust(t, err)
+	assert.EqualValues(0, offset)
+}
@@ -0,0 +1,176 @@
+package savior
+
+import (
+	"crypto/cipher"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// EncryptedSourceCheckpoint is the checkpoint data of sources returned
+// by EncryptedFolderSink.OpenSource
+type EncryptedSourceCheckpoint struct {
+	// Chunk is the index of the next chunk to decrypt
+	Chunk int64
+}
+
+type encryptedSource struct {
+	f             *os.File
+	size          int64
+	canonicalPath string
+
+	aead  cipher.AEAD
+	nonce []byte
+	// the tag of every chunk, one after the other
+	tags []byte
+
+	// chunk is the index of the next chunk to decrypt
+	chunk int64
+	// plaintext is what's left of the last decrypted chunk
+	plaintext []byte
+	sealed    []byte
+	offset    int64
+	started   bool
+	bytebuf   []byte
+
+	ssc      SourceSaveConsumer
+	wantSave bool
+}
+
+var _ Source = (*encryptedSource)(nil)
+
+func (es *encryptedSource) numChunks() int64 {
+	return int64(len(es.tags) / encryptedTagSize)
+}
+
+func (es *encryptedSource) Features() SourceFeatures {
+	return SourceFeatures{
+		Name:          "encrypted",
+		ResumeSupport: ResumeSupportBlock,
+	}
+}
+
+func (es *encryptedSource) SetSourceSaveConsumer(ssc SourceSaveConsumer) {
+	es.ssc = ssc
+}
+
+func (es *encryptedSource) WantSave() {
+	es.wantSave = true
+}
+
+func (es *encryptedSource) Resume(checkpoint *SourceCheckpoint) (int64, error) {
+	if es.f == nil {
+		return 0, errors.WithStack(os.ErrClosed)
+	}
+
+	es.chunk = 0
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*EncryptedSourceCheckpoint); ok && ourCheckpoint.Chunk <= es.numChunks() {
+			es.chunk = ourCheckpoint.Chunk
+		}
+	}
+	es.plaintext = nil
+	es.offset = es.chunk * EncryptedChunkSize
+	es.started = true
+	return es.offset, nil
+}
+
+// readChunk decrypts the next chunk, and checks it's where it should be
+func (es *encryptedSource) readChunk() error {
+	numChunks := es.numChunks()
+	if es.chunk >= numChunks {
+		return io.EOF
+	}
+
+	last := es.chunk == numChunks-1
+	size := int64(EncryptedChunkSize)
+	if last {
+		size = es.size - es.chunk*EncryptedChunkSize
+		if size < 0 || size > EncryptedChunkSize {
+			return errors.Wrapf(ErrEncryptedCorrupt, "%s: size doesn't match sidecar", es.canonicalPath)
+		}
+	}
+
+	tag := es.tags[es.chunk*encryptedTagSize : (es.chunk+1)*encryptedTagSize]
+	es.sealed = append(es.sealed[:0], make([]byte, size)...)
+	_, err := es.f.ReadAt(es.sealed, es.chunk*EncryptedChunkSize)
+	if err != nil {
+		if err == io.EOF {
+			return errors.Wrapf(ErrEncryptedCorrupt, "%s: size doesn't match sidecar", es.canonicalPath)
+		}
+		return errors.WithStack(err)
+	}
+	es.sealed = append(es.sealed, tag...)
+
+	ad := chunkData(es.canonicalPath, es.chunk, last)
+	es.plaintext, err = es.aead.Open(es.sealed[:0], chunkNonce(es.nonce, es.chunk), es.sealed, ad)
+	if err != nil {
+		return errors.Wrapf(ErrEncryptedCorrupt, "%s: chunk %d", es.canonicalPath, es.chunk)
+	}
+	es.chunk++
+	return nil
+}
+
+func (es *encryptedSource) Read(buf []byte) (int, error) {
+	if !es.started || es.f == nil {
+		return 0, errors.WithStack(ErrUninitializedSource)
+	}
+
+	if len(es.plaintext) == 0 {
+		if es.wantSave && es.ssc != nil {
+			// in between chunks
+			es.wantSave = false
+			err := es.ssc.Save(&SourceCheckpoint{
+				Offset:       es.offset,
+				OutputOffset: es.offset,
+				Data: &EncryptedSourceCheckpoint{
+					Chunk: es.chunk,
+				},
+			})
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+		}
+
+		err := es.readChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(buf, es.plaintext)
+	es.plaintext = es.plaintext[n:]
+	es.offset += int64(n)
+	return n, nil
+}
+
+func (es *encryptedSource) ReadByte() (byte, error) {
+	n, err := es.Read(es.bytebuf)
+	if n == 0 && err == nil {
+		// only the empty last chunk was read
+		n, err = es.Read(es.bytebuf)
+	}
+	return es.bytebuf[0], err
+}
+
+func (es *encryptedSource) Progress() float64 {
+	if es.size == 0 {
+		return 1
+	}
+	return float64(es.offset) / float64(es.size)
+}
+
+// Close closes the encrypted file
+func (es *encryptedSource) Close() error {
+	if es.f == nil {
+		return nil
+	}
+	err := es.f.Close()
+	es.f = nil
+	return errors.WithStack(err)
+}
+
+func init() {
+	RegisterCheckpointData("savior.EncryptedSourceCheckpoint", &EncryptedSourceCheckpoint{})
+}
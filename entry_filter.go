@@ -0,0 +1,6 @@
+package savior
+
+// An EntryFilter decides which entries of an archive get extracted:
+// entries it returns false for are skipped, without being decompressed
+// or handed to the sink. See Extractor.SetEntryFilter.
+type EntryFilter func(entry *Entry) bool
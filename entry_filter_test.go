@@ -0,0 +1,131 @@
+package savior_test
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/cabextractor"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EntryFilter(t *testing.T) {
+	// cabinets can only hold files
+	sink := checker.MakeTestSinkAdvanced(20)
+	var names []string
+	for name, item := range sink.Items {
+		if item.Entry.Kind != savior.EntryKindFile {
+			delete(sink.Items, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// every other file
+	selected := make(map[string]bool)
+	for i, name := range names {
+		if i%2 == 1 {
+			selected[name] = true
+		}
+	}
+	filter := func(entry *savior.Entry) bool {
+		return selected[entry.CanonicalPath]
+	}
+
+	zipBytes := checker.MakeZip(t, sink)
+	tarGzBytes, err := checker.GzipCompress(checker.MakeTar(t, sink))
+	tmust(t, err)
+	cabBytes := checker.MakeCab(t, sink)
+
+	makeExtractors := map[string]func() savior.Extractor{
+		"zip": func() savior.Extractor {
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			tmust(t, err)
+			return ex
+		},
+		"tar.gz": func() savior.Extractor {
+			return tarextractor.New(gzipsource.New(seeksource.FromBytes(tarGzBytes)))
+		},
+		"cab": func() savior.Extractor {
+			ex, err := cabextractor.New(bytes.NewReader(cabBytes), int64(len(cabBytes)))
+			tmust(t, err)
+			return ex
+		},
+	}
+
+	for kind, makeExtractor := range makeExtractors {
+		t.Run(kind, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ms := savior.NewMemorySink()
+			var c *savior.ExtractorCheckpoint
+			var lastProgress float64
+			var res *savior.ExtractorResult
+			for runs := 0; ; runs++ {
+				if !assert.True(runs < 2, "should only stop once") {
+					return
+				}
+
+				ex := makeExtractor()
+				ex.SetEntryFilter(filter)
+				consumer := savior.NopConsumer()
+				consumer.OnProgress = func(progress float64) {
+					lastProgress = progress
+				}
+				ex.SetConsumer(consumer)
+				ex.SetSaveConsumer(checker.NewTestSaveConsumer(512*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+					if c != nil {
+						return savior.AfterSaveContinue, nil
+					}
+					bs, err := savior.MarshalCheckpoint(checkpoint)
+					if err != nil {
+						return savior.AfterSaveStop, err
+					}
+					c, err = savior.UnmarshalCheckpoint(bs)
+					return savior.AfterSaveStop, err
+				}))
+
+				res, err = ex.Resume(c, ms)
+				if err == savior.ErrStop {
+					continue
+				}
+				tmust(t, err)
+				break
+			}
+			assert.NotNil(c, "should have stopped at a checkpoint")
+
+			paths := ms.Paths()
+			sort.Strings(paths)
+			var expected []string
+			for name := range selected {
+				expected = append(expected, name)
+			}
+			sort.Strings(expected)
+			assert.EqualValues(expected, paths, "only selected entries should be extracted")
+
+			for name := range selected {
+				actual, _, ok := ms.GetEntry(name)
+				if assert.True(ok, "%s should be extracted", name) {
+					assert.True(bytes.Equal(sink.Items[name].Data, actual), "%s should have the right contents", name)
+				}
+			}
+
+			assert.EqualValues(len(selected), len(res.Entries))
+			for _, entry := range res.Entries {
+				assert.True(selected[entry.CanonicalPath], "%s shouldn't be in the result", entry.CanonicalPath)
+			}
+
+			if kind != "tar.gz" {
+				// progress is over selected entries, tar's is over the source
+				assert.EqualValues(1, lastProgress)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package savior
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEntryNotFound is returned by OpenEntry when the archive
+// has no entry with the requested path.
+var ErrEntryNotFound = errors.New("no such entry in archive")
+
+// An EntryOpener gives access to the decompressed contents of any
+// single entry of an archive, without extracting the others.
+type EntryOpener interface {
+	// OpenEntry returns a reader for the contents of the entry whose
+	// CanonicalPath is entry.CanonicalPath. For symlinks, that's the
+	// link target. The reader must be closed by the caller.
+	OpenEntry(entry *Entry) (io.ReadCloser, error)
+}
@@ -0,0 +1,244 @@
+package savior
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of events written by an EventWriter
+const (
+	EventStart      = "start"
+	EventEntryStart = "entry_start"
+	EventEntryDone  = "entry_done"
+	EventProgress   = "progress"
+	EventCheckpoint = "checkpoint"
+	EventDone       = "done"
+	EventError      = "error"
+)
+
+// defaultEventProgressInterval is the minimum time between
+// two progress events
+const defaultEventProgressInterval = 250 * time.Millisecond
+
+// An Event is written as a single line of JSON by an EventWriter.
+// Only the fields relevant to its type are set.
+type Event struct {
+	Type string `json:"type"`
+
+	// Extractor is the name of the extractor (start)
+	Extractor string `json:"extractor,omitempty"`
+	// Resumed is true when resuming from a checkpoint (start)
+	Resumed bool `json:"resumed,omitempty"`
+
+	// Path is the entry's canonical path (entry_start, entry_done, checkpoint)
+	Path string `json:"path,omitempty"`
+	// Kind is "file", "dir" or "symlink" (entry_start, entry_done)
+	Kind string `json:"kind,omitempty"`
+	// Size is the entry's uncompressed size (entry_start, entry_done). For
+	// entries of unknown size, it's -1 in entry_start, and how much was
+	// written in entry_done.
+	Size int64 `json:"size,omitempty"`
+	// Offset is where writing the entry starts or stopped (entry_start, checkpoint)
+	Offset int64 `json:"offset,omitempty"`
+
+	// Progress is in the [0,1] range (start, progress, checkpoint)
+	Progress float64 `json:"progress,omitempty"`
+	// EntryIndex is the extractor-specific index in the checkpoint (checkpoint)
+	EntryIndex int64 `json:"entry_index,omitempty"`
+	// Stop is true if the save consumer asked to stop (checkpoint)
+	Stop bool `json:"stop,omitempty"`
+
+	// Entries is the number of entries extracted (done)
+	Entries int `json:"entries,omitempty"`
+	// Bytes is the total size of the entries extracted (done)
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// Error describes what went wrong (error)
+	Error string `json:"error,omitempty"`
+}
+
+// An EventWriter writes extraction events as newline-delimited JSON,
+// so other processes can follow along. All its methods can be called
+// on a nil *EventWriter, and do nothing, so extractors don't need to
+// check whether events were asked for.
+//
+// Failing to write an event doesn't fail the extraction, but no further
+// events are written.
+type EventWriter struct {
+	w   io.Writer
+	enc *json.Encoder
+
+	mu               sync.Mutex
+	err              error
+	progressInterval time.Duration
+	lastProgress     time.Time
+}
+
+// NewEventWriter returns an EventWriter that writes to w,
+// or nil if w is nil.
+func NewEventWriter(w io.Writer) *EventWriter {
+	if w == nil {
+		return nil
+	}
+
+	return &EventWriter{
+		w:                w,
+		enc:              json.NewEncoder(w),
+		progressInterval: defaultEventProgressInterval,
+	}
+}
+
+func (ew *EventWriter) write(ev *Event) {
+	if ew == nil {
+		return
+	}
+
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	if ew.err != nil {
+		return
+	}
+
+	// json.Encoder terminates each value with a newline
+	err := ew.enc.Encode(ev)
+	if err != nil {
+		Debugf("savior: could not write %s event, giving up on events: %+v", ev.Type, err)
+		ew.err = err
+	}
+}
+
+// Start is written when an extraction starts or resumes
+func (ew *EventWriter) Start(extractor string, checkpoint *ExtractorCheckpoint) {
+	ev := &Event{
+		Type:      EventStart,
+		Extractor: extractor,
+	}
+	if checkpoint != nil {
+		ev.Resumed = true
+		ev.Progress = checkpoint.Progress
+	}
+	ew.write(ev)
+}
+
+// EntryStart is written before an entry is extracted
+func (ew *EventWriter) EntryStart(entry *Entry) {
+	ew.write(&Event{
+		Type:   EventEntryStart,
+		Path:   entry.CanonicalPath,
+		Kind:   entry.Kind.String(),
+		Size:   entry.UncompressedSize,
+		Offset: entry.WriteOffset,
+	})
+}
+
+// EntryDone is written once an entry has been extracted completely
+func (ew *EventWriter) EntryDone(entry *Entry) {
+	size := entry.UncompressedSize
+	if !entry.SizeKnown() {
+		size = entry.WriteOffset
+	}
+	ew.write(&Event{
+		Type: EventEntryDone,
+		Path: entry.CanonicalPath,
+		Kind: entry.Kind.String(),
+		Size: size,
+	})
+}
+
+// Progress is written at most every so often, calls
+// in-between are ignored.
+func (ew *EventWriter) Progress(progress float64) {
+	if ew == nil {
+		return
+	}
+
+	ew.mu.Lock()
+	now := time.Now()
+	skip := now.Sub(ew.lastProgress) < ew.progressInterval
+	if !skip {
+		ew.lastProgress = now
+	}
+	ew.mu.Unlock()
+
+	if skip {
+		return
+	}
+
+	ew.write(&Event{
+		Type:     EventProgress,
+		Progress: progress,
+	})
+}
+
+// Checkpoint is written whenever a checkpoint was passed to the save consumer
+func (ew *EventWriter) Checkpoint(checkpoint *ExtractorCheckpoint, action AfterSaveAction) {
+	ev := &Event{
+		Type:       EventCheckpoint,
+		Progress:   checkpoint.Progress,
+		EntryIndex: checkpoint.EntryIndex,
+		Stop:       action == AfterSaveStop,
+	}
+	if checkpoint.Entry != nil {
+		ev.Path = checkpoint.Entry.CanonicalPath
+		ev.Offset = checkpoint.Entry.WriteOffset
+	}
+	ew.write(ev)
+}
+
+// Done is written when an extraction completes successfully
+func (ew *EventWriter) Done(res *ExtractorResult) {
+	ev := &Event{
+		Type: EventDone,
+	}
+	if res != nil {
+		ev.Entries = len(res.Entries)
+		ev.Bytes = res.Size()
+	}
+	ew.write(ev)
+}
+
+// Error is written when an extraction fails. Stopping
+// because the save consumer asked to isn't an error.
+func (ew *EventWriter) Error(err error) {
+	if errors.Cause(err) == ErrStop {
+		return
+	}
+
+	ew.write(&Event{
+		Type:  EventError,
+		Error: err.Error(),
+	})
+}
+
+// WrapSaveConsumer returns a SaveConsumer that writes a checkpoint
+// event for every checkpoint saved through it.
+func (ew *EventWriter) WrapSaveConsumer(inner SaveConsumer) SaveConsumer {
+	if ew == nil {
+		return inner
+	}
+	return &eventSaveConsumer{inner: inner, ew: ew}
+}
+
+type eventSaveConsumer struct {
+	inner SaveConsumer
+	ew    *EventWriter
+}
+
+var _ SaveConsumer = (*eventSaveConsumer)(nil)
+
+func (esc *eventSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	return esc.inner.ShouldSave(copiedBytes)
+}
+
+func (esc *eventSaveConsumer) Save(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error) {
+	action, err := esc.inner.Save(checkpoint)
+	if err == nil {
+		esc.ew.Checkpoint(checkpoint, action)
+	}
+	return action, err
+}
@@ -0,0 +1,92 @@
+package savior
+
+import (
+	"os"
+	"reflect"
+	"time"
+)
+
+// SetPreallocateFunc replaces the function used by FolderSink to
+// preallocate files, and returns a function that restores it.
+func SetPreallocateFunc(f func(f *os.File, size int64) error) func() {
+	previous := preallocate
+	preallocate = f
+	return func() {
+		preallocate = previous
+	}
+}
+
+// SetFreeSpaceFunc replaces the function used by FolderSink to
+// check available disk space, and returns a function that restores it.
+func SetFreeSpaceFunc(f func(path string) (int64, error)) func() {
+	previous := freeSpace
+	freeSpace = f
+	return func() {
+		freeSpace = previous
+	}
+}
+
+// SetCloseFileFunc replaces the function used by FolderSink to close
+// files, and returns a function that restores it.
+func SetCloseFileFunc(f func(f *os.File) error) func() {
+	previous := closeFile
+	closeFile = f
+	return func() {
+		closeFile = previous
+	}
+}
+
+// SetMkdirLstatFunc replaces the function FolderSink.Mkdir uses to check
+// what's at the destination, and returns a function that restores it.
+func SetMkdirLstatFunc(f func(path string) (os.FileInfo, error)) func() {
+	previous := mkdirLstat
+	mkdirLstat = f
+	return func() {
+		mkdirLstat = previous
+	}
+}
+
+// RegisteredCheckpointData returns the types registered with
+// RegisterCheckpointData so far, by name.
+func RegisteredCheckpointData() map[string]reflect.Type {
+	checkpointDataRegistry.RLock()
+	defer checkpointDataRegistry.RUnlock()
+
+	res := make(map[string]reflect.Type, len(checkpointDataRegistry.byName))
+	for name, t := range checkpointDataRegistry.byName {
+		res[name] = t
+	}
+	return res
+}
+
+// SetAvailableMemoryFunc replaces the function used by memory governors
+// to check available memory, along with how often they check it while
+// paused, and returns a function that restores them.
+func SetAvailableMemoryFunc(f func() (int64, error), pollInterval time.Duration) func() {
+	previous, previousInterval := availableMemory, memoryPollInterval
+	availableMemory, memoryPollInterval = f, pollInterval
+	return func() {
+		availableMemory, memoryPollInterval = previous, previousInterval
+	}
+}
+
+// SetThrottleClock replaces the function ThrottleSaveConsumer uses to
+// tell the time, and returns a function that restores it.
+func SetThrottleClock(f func() time.Time) func() {
+	previous := throttleNow
+	throttleNow = f
+	return func() {
+		throttleNow = previous
+	}
+}
+
+// SetNukeFuncs replaces the function FolderSink.Nuke uses to remove its
+// directory, along with how long it waits before trying again, and
+// returns a function that restores them.
+func SetNukeFuncs(f func(path string) error, delay time.Duration) func() {
+	previous, previousDelay := removeAll, nukeDelay
+	removeAll, nukeDelay = f, delay
+	return func() {
+		removeAll, nukeDelay = previous, previousDelay
+	}
+}
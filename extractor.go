@@ -3,6 +3,7 @@ package savior
 import (
 	"encoding/gob"
 	"fmt"
+	"io"
 
 	"github.com/itchio/headway/united"
 	"github.com/itchio/headway/state"
@@ -34,17 +35,22 @@ func (er *ExtractorResult) Stats() string {
 		case EntryKindSymlink:
 			numSymlinks++
 		}
-		totalBytes += entry.UncompressedSize
+		if entry.SizeKnown() {
+			totalBytes += entry.UncompressedSize
+		}
 	}
 
 	return fmt.Sprintf("%s (in %d files, %d dirs, %d symlinks)", united.FormatBytes(totalBytes), numFiles, numDirs, numSymlinks)
 }
 
-// Returns the total size of all listed entries, in bytes
+// Returns the total size of all listed entries, in bytes. Entries
+// of unknown size don't count.
 func (er *ExtractorResult) Size() int64 {
 	var totalBytes int64
 	for _, entry := range er.Entries {
-		totalBytes += entry.UncompressedSize
+		if entry.SizeKnown() {
+			totalBytes += entry.UncompressedSize
+		}
 	}
 
 	return totalBytes
@@ -141,7 +147,9 @@ func NopConsumer() *state.Consumer {
 type Extractor interface {
 	// Set save consumer for determining checkpoint frequency and persisting them.
 	SetSaveConsumer(saveConsumer SaveConsumer)
-	// Set *state.Consumer for logging
+	// Set *state.Consumer for logging and progress: its Progress is called
+	// with the fraction of the archive extracted so far (including what was
+	// extracted before resuming), up to 1 once extraction completes.
 	SetConsumer(consumer *state.Consumer)
 	// Perform extraction, optionally resuming from a checkpoint (if non-nil)
 	// Sink is not closed, it should be closed by the caller, see simple_extract
@@ -149,6 +157,25 @@ type Extractor interface {
 	Resume(checkpoint *ExtractorCheckpoint, sink Sink) (*ExtractorResult, error)
 	// Returns the supported features for this extractor
 	Features() ExtractorFeatures
+	// Set a writer extraction events are written to as newline-delimited
+	// JSON, see EventWriter. nil disables events.
+	SetEventWriter(w io.Writer)
+	// Set a filter every checkpoint goes through before it's handed to the
+	// save consumer, see CheckpointFilter. nil lets everything through.
+	SetCheckpointFilter(filter CheckpointFilter)
+	// Pause extraction at the next checkpoint whenever available system
+	// memory drops below `lowWatermark` bytes, until it's back above
+	// `highWatermark`, see GovernSaveConsumer. 0 disables it.
+	SetMemoryGovernor(lowWatermark int64, highWatermark int64)
+	// Only extract entries `filter` returns true for, see EntryFilter.
+	// Filters aren't saved in checkpoints, resuming requires the same one.
+	// nil extracts everything.
+	SetEntryFilter(filter EntryFilter)
+	// List the entries of the archive (regardless of the entry filter),
+	// without extracting anything, to show a listing or compute a total
+	// size beforehand. Formats without an index (like tar) read through
+	// the whole archive to do that.
+	ListEntries() ([]*Entry, error)
 }
 
 func init() {
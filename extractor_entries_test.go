@@ -0,0 +1,92 @@
+package savior_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExtractorEntries(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(20)
+	sink.Items["link"] = &checker.Item{
+		Entry: &savior.Entry{
+			CanonicalPath: "link",
+			Kind:          savior.EntryKindSymlink,
+			Linkname:      "target",
+		},
+	}
+	zipBytes := checker.MakeZip(t, sink)
+	tarBytes := checker.MakeTar(t, sink)
+	tarGzBytes, err := checker.GzipCompress(tarBytes)
+	tmust(t, err)
+
+	makeExtractors := map[string]func() savior.Extractor{
+		"zip": func() savior.Extractor {
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			tmust(t, err)
+			return ex
+		},
+		"tar": func() savior.Extractor {
+			return tarextractor.New(seeksource.FromBytes(tarBytes))
+		},
+		"tar.gz": func() savior.Extractor {
+			return tarextractor.New(gzipsource.New(seeksource.FromBytes(tarGzBytes)))
+		},
+	}
+
+	for name, makeExtractor := range makeExtractors {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			sink.Reset()
+
+			ex := makeExtractor()
+			listed, err := ex.ListEntries()
+			tmust(t, err)
+			assert.EqualValues(len(sink.Items), len(listed))
+
+			var numDirs, numSymlinks int
+			for _, entry := range listed {
+				item, ok := sink.Items[entry.CanonicalPath]
+				if !assert.True(ok, "unexpected entry %s", entry.CanonicalPath) {
+					continue
+				}
+				assert.EqualValues(item.Entry.Kind, entry.Kind, "kind of %s", entry.CanonicalPath)
+				switch entry.Kind {
+				case savior.EntryKindDir:
+					numDirs++
+				case savior.EntryKindSymlink:
+					numSymlinks++
+				case savior.EntryKindFile:
+					assert.EqualValues(len(item.Data), entry.UncompressedSize, "size of %s", entry.CanonicalPath)
+				}
+			}
+			assert.True(numDirs > 0, "should list directories")
+			assert.True(numSymlinks > 0, "should list symlinks")
+
+			// the same extractor can extract afterwards, and the
+			// entries it returns are as listed
+			res, err := ex.Resume(nil, sink)
+			tmust(t, err)
+			tmust(t, sink.Validate())
+
+			byPath := make(map[string]*savior.Entry)
+			for _, entry := range listed {
+				byPath[entry.CanonicalPath] = entry
+			}
+			for _, entry := range res.Entries {
+				if e, ok := byPath[entry.CanonicalPath]; assert.True(ok, "%s should have been listed", entry.CanonicalPath) {
+					assert.EqualValues(entry.Kind, e.Kind)
+					assert.EqualValues(entry.UncompressedSize, e.UncompressedSize)
+					assert.EqualValues(entry.Mode, e.Mode)
+				}
+			}
+		})
+	}
+}
@@ -13,12 +13,8 @@ func OpenPaused(name string, opts ...option.Option) (savior.FileSource, error) {
 		return nil, err
 	}
 
-	ss := seeksource.FromFile(f)
-	fs := &fileSource{
-		SeekSource: ss,
-		f:          f,
-	}
-	return fs, nil
+	// closing the seeksource closes the file
+	return seeksource.FromFile(f), nil
 }
 
 func Open(name string, opts ...option.Option) (savior.FileSource, error) {
@@ -34,13 +30,3 @@ func Open(name string, opts ...option.Option) (savior.FileSource, error) {
 
 	return s, nil
 }
-
-type fileSource struct {
-	savior.SeekSource
-
-	f eos.File
-}
-
-func (fs *fileSource) Close() error {
-	return fs.f.Close()
-}
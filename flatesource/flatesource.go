@@ -1,19 +1,45 @@
 package flatesource
 
 import (
-	"encoding/gob"
+	"encoding"
+	"encoding/binary"
 	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
 
 	"github.com/itchio/kompress/flate"
 	"github.com/itchio/savior"
 	"github.com/pkg/errors"
 )
 
+// ErrChecksum is returned by Read when the decompressed data doesn't match
+// the Adler-32 in a zlib trailer. Since the checksum computed so far is part
+// of checkpoints, it's verified even when resuming mid-stream.
+var ErrChecksum = errors.New("flatesource: invalid zlib checksum")
+
+// ErrDictionary is returned when a zlib stream needs a preset dictionary,
+// which isn't supported
+var ErrDictionary = errors.New("flatesource: zlib preset dictionaries are not supported")
+
+// zlibHeaderSize is the size of the CMF and FLG bytes, there's no
+// dictionary ID since those aren't supported
+const zlibHeaderSize = 2
+
 type flateSource struct {
 	// input
 	source savior.Source
 
+	// params
+	auto bool
+
 	// internal
+	// set when the stream is zlib-wrapped, only ever the case with auto
+	zlib bool
+	// Adler-32 of what was decompressed so far, in zlib mode
+	adler         hash.Hash32
+	trailerParsed bool
+
 	sr      flate.SaverReader
 	offset  int64
 	counter int64
@@ -26,6 +52,19 @@ type flateSource struct {
 type FlateSourceCheckpoint struct {
 	SourceCheckpoint *savior.SourceCheckpoint
 	FlateCheckpoint  *flate.Checkpoint
+	// Zlib is set if the stream has a zlib header (and trailer), in which
+	// case FlateCheckpoint.Roffset is relative to the end of the header
+	Zlib bool
+	// Adler32State is the state of the checksum of everything decompressed
+	// so far, for zlib streams, as marshaled by hash/adler32
+	Adler32State []byte
+}
+
+var _ savior.PortableChecker = (*FlateSourceCheckpoint)(nil)
+
+// Portable returns true if the wrapped source checkpoint is portable
+func (fsc *FlateSourceCheckpoint) Portable() bool {
+	return fsc.SourceCheckpoint.Portable()
 }
 
 var _ savior.Source = (*flateSource)(nil)
@@ -37,6 +76,16 @@ func New(source savior.Source) *flateSource {
 	}
 }
 
+// NewAuto returns a source that decompresses either raw DEFLATE data, or
+// a zlib stream (RFC 1950), depending on whether the stream starts with
+// a valid zlib header. zlib streams have their Adler-32 trailer checked,
+// see ErrChecksum.
+func NewAuto(source savior.Source) *flateSource {
+	fs := New(source)
+	fs.auto = true
+	return fs
+}
+
 func (fs *flateSource) Features() savior.SourceFeatures {
 	return savior.SourceFeatures{
 		Name:          "flate",
@@ -61,17 +110,22 @@ func (fs *flateSource) WantSave() {
 
 func (fs *flateSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
 	savior.Debugf(`flate: asked to resume`)
+	fs.trailerParsed = false
 
 	if checkpoint != nil {
-		if ourCheckpoint, ok := checkpoint.Data.(*FlateSourceCheckpoint); ok {
+		if ourCheckpoint, ok := checkpoint.Data.(*FlateSourceCheckpoint); ok && (fs.auto || !ourCheckpoint.Zlib) {
 			sourceOffset, err := fs.source.Resume(ourCheckpoint.SourceCheckpoint)
 			if err != nil {
 				return 0, errors.WithStack(err)
 			}
 
 			fc := ourCheckpoint.FlateCheckpoint
-			if sourceOffset < fc.Roffset {
-				delta := fc.Roffset - sourceOffset
+			roffset := fc.Roffset
+			if ourCheckpoint.Zlib {
+				roffset += zlibHeaderSize
+			}
+			if sourceOffset < roffset {
+				delta := roffset - sourceOffset
 				savior.Debugf(`flatesource: discarding %d bytes to align source with decompressor`, delta)
 				err = savior.DiscardByRead(fs.source, delta)
 				if err != nil {
@@ -80,7 +134,7 @@ func (fs *flateSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error
 				sourceOffset += delta
 			}
 
-			if sourceOffset == fc.Roffset {
+			if sourceOffset == roffset {
 				fs.sr, err = fc.Resume(fs.source)
 				if err != nil {
 					savior.Debugf(`flatesource: could not use flate checkpoint at R=%d / W=%d`, fc.Roffset, fc.Woffset)
@@ -90,11 +144,16 @@ func (fs *flateSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error
 						return 0, errors.WithStack(err)
 					}
 				} else {
+					fs.zlib = ourCheckpoint.Zlib
+					fs.adler, err = newAdler32(ourCheckpoint.Adler32State)
+					if err != nil {
+						return 0, errors.Wrap(err, "restoring zlib checksum")
+					}
 					fs.offset = fc.Woffset
 					return fc.Woffset, nil
 				}
 			} else {
-				savior.Debugf(`flatesource: expected source to resume at %d but got %d`, fc.Roffset, sourceOffset)
+				savior.Debugf(`flatesource: expected source to resume at %d but got %d`, roffset, sourceOffset)
 			}
 		}
 	}
@@ -110,11 +169,60 @@ func (fs *flateSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error
 		return 0, errors.New(msg)
 	}
 
-	fs.sr = flate.NewSaverReader(fs.source)
+	fs.zlib = false
+	var r flate.Reader = fs.source
+	if fs.auto {
+		r, err = fs.detect()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	fs.sr = flate.NewSaverReader(r)
+	fs.adler = adler32.New()
 	fs.offset = 0
 	return 0, nil
 }
 
+// detect reads the first two bytes of the stream, and looks for a
+// zlib header. If there's none, it returns a reader that gives them
+// back before the rest of the source.
+func (fs *flateSource) detect() (flate.Reader, error) {
+	var header []byte
+	for len(header) < zlibHeaderSize {
+		b, err := fs.source.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				// too short to tell, let the decompressor complain
+				break
+			}
+			return nil, errors.WithStack(err)
+		}
+		header = append(header, b)
+	}
+
+	if len(header) == zlibHeaderSize && isZlibHeader(header[0], header[1]) {
+		if header[1]&0x20 != 0 {
+			return nil, errors.WithStack(ErrDictionary)
+		}
+		savior.Debugf("flatesource: found zlib header")
+		fs.zlib = true
+		return fs.source, nil
+	}
+
+	savior.Debugf("flatesource: no zlib header, assuming raw deflate")
+	return &prefixReader{prefix: header, source: fs.source}, nil
+}
+
+// isZlibHeader returns true if `cmf` and `flg` make a valid zlib header:
+// deflate compression with a window of at most 32KiB, and a correct check
+func isZlibHeader(cmf byte, flg byte) bool {
+	if cmf&0x0f != 8 || cmf>>4 > 7 {
+		return false
+	}
+	return (uint16(cmf)<<8|uint16(flg))%31 == 0
+}
+
 func (fs *flateSource) Read(buf []byte) (int, error) {
 	if fs.sr == nil {
 		return 0, errors.WithStack(savior.ErrUninitializedSource)
@@ -123,6 +231,16 @@ func (fs *flateSource) Read(buf []byte) (int, error) {
 	n, err := fs.sr.Read(buf)
 	fs.offset += int64(n)
 
+	if fs.zlib {
+		fs.adler.Write(buf[:n])
+		if err == io.EOF {
+			trailerErr := fs.parseTrailer()
+			if trailerErr != nil {
+				return n, trailerErr
+			}
+		}
+	}
+
 	if err == flate.ReadyToSaveError {
 		err = nil
 
@@ -138,11 +256,24 @@ func (fs *flateSource) Read(buf []byte) (int, error) {
 
 			savior.Debugf("flatesource: saving, flate rOffset = %d, sourceCheckpoint.Offset = %d", flateCheckpoint.Roffset, fs.sourceCheckpoint.Offset)
 
+			offset := flateCheckpoint.Roffset
+			var adlerState []byte
+			if fs.zlib {
+				offset += zlibHeaderSize
+				adlerState, saveErr = fs.adler.(encoding.BinaryMarshaler).MarshalBinary()
+				if saveErr != nil {
+					return n, errors.WithStack(saveErr)
+				}
+			}
+
 			checkpoint := &savior.SourceCheckpoint{
-				Offset: fs.offset,
+				Offset:       offset,
+				OutputOffset: fs.offset,
 				Data: &FlateSourceCheckpoint{
 					FlateCheckpoint:  flateCheckpoint,
 					SourceCheckpoint: fs.sourceCheckpoint,
+					Zlib:             fs.zlib,
+					Adler32State:     adlerState,
 				},
 			}
 			fs.sourceCheckpoint = nil
@@ -155,6 +286,29 @@ func (fs *flateSource) Read(buf []byte) (int, error) {
 	return n, err
 }
 
+// parseTrailer reads the Adler-32 that follows zlib streams, and
+// compares it with what was decompressed
+func (fs *flateSource) parseTrailer() error {
+	if fs.trailerParsed {
+		return nil
+	}
+
+	trailer := make([]byte, 4)
+	_, err := io.ReadFull(fs.source, trailer)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.WithStack(err)
+	}
+	fs.trailerParsed = true
+
+	if binary.BigEndian.Uint32(trailer) != fs.adler.Sum32() {
+		return errors.WithStack(ErrChecksum)
+	}
+	return nil
+}
+
 func (fs *flateSource) ReadByte() (byte, error) {
 	if fs.sr == nil {
 		return 0, errors.WithStack(savior.ErrUninitializedSource)
@@ -178,6 +332,62 @@ func (fs *flateSource) Progress() float64 {
 	return fs.source.Progress()
 }
 
+// Close releases the decompressor and closes the underlying source
+func (fs *flateSource) Close() error {
+	var err error
+	if fs.sr != nil {
+		err = fs.sr.Close()
+	}
+
+	sourceErr := fs.source.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return sourceErr
+}
+
 func init() {
-	gob.Register(&FlateSourceCheckpoint{})
+	savior.RegisterCheckpointData("flatesource.FlateSourceCheckpoint", &FlateSourceCheckpoint{})
+}
+
+// prefixReader reads `prefix`, then the rest of `source`. It implements
+// io.ByteReader, so the decompressor doesn't read ahead.
+type prefixReader struct {
+	prefix []byte
+	source savior.Source
+}
+
+var _ flate.Reader = (*prefixReader)(nil)
+
+func (pr *prefixReader) Read(buf []byte) (int, error) {
+	if len(pr.prefix) > 0 {
+		n := copy(buf, pr.prefix)
+		pr.prefix = pr.prefix[n:]
+		return n, nil
+	}
+	return pr.source.Read(buf)
+}
+
+func (pr *prefixReader) ReadByte() (byte, error) {
+	if len(pr.prefix) > 0 {
+		b := pr.prefix[0]
+		pr.prefix = pr.prefix[1:]
+		return b, nil
+	}
+	return pr.source.ReadByte()
+}
+
+// newAdler32 returns an Adler-32 hash in the state it was marshaled in,
+// or a fresh one if state is nil
+func newAdler32(state []byte) (hash.Hash32, error) {
+	h := adler32.New()
+	if state == nil {
+		return h, nil
+	}
+
+	err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return h, nil
 }
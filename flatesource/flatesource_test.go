@@ -1,6 +1,8 @@
 package flatesource_test
 
 import (
+	"io"
+	"io/ioutil"
 	"log"
 	"testing"
 
@@ -44,3 +46,164 @@ func Test_Checkpoints(t *testing.T) {
 
 	checker.RunSourceTest(t, fs, reference)
 }
+
+func Test_CheckpointOffsets(t *testing.T) {
+	assert := assert.New(t)
+
+	// compressible, so the two offsets drift apart
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+	for i := 0; i < len(reference); i += 4 * 1024 {
+		copy(reference[i:i+1024], make([]byte, 1024))
+	}
+	compressed, err := checker.FlateCompress(reference)
+	assert.NoError(err)
+
+	fs := flatesource.New(seeksource.FromBytes(compressed))
+	_, err = fs.Resume(nil)
+	assert.NoError(err)
+
+	var checkpoints []*savior.SourceCheckpoint
+	fs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			checkpoints = append(checkpoints, c)
+			return nil
+		},
+	})
+
+	var outputOffset int64
+	var counter int64
+	buf := make([]byte, 16*1024)
+	for {
+		numCheckpoints := len(checkpoints)
+		n, err := fs.Read(buf)
+		outputOffset += int64(n)
+		counter += int64(n)
+		if counter > 256*1024 {
+			counter = 0
+			fs.WantSave()
+		}
+
+		if len(checkpoints) > numCheckpoints {
+			c := checkpoints[len(checkpoints)-1]
+			assert.EqualValues(outputOffset, c.OutputOffset, "output offset is the decompressed position")
+
+			fc := c.Data.(*flatesource.FlateSourceCheckpoint)
+			assert.EqualValues(fc.FlateCheckpoint.Roffset, c.Offset, "offset is the compressed position")
+			assert.True(fc.SourceCheckpoint.Offset <= c.Offset)
+			assert.True(c.Offset < c.OutputOffset)
+		}
+
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(err)
+	}
+	assert.True(len(checkpoints) >= 3, "should have made several checkpoints, got %d", len(checkpoints))
+
+	for i := 1; i < len(checkpoints); i++ {
+		assert.True(checkpoints[i].Offset > checkpoints[i-1].Offset)
+		assert.True(checkpoints[i].OutputOffset > checkpoints[i-1].OutputOffset)
+	}
+	last := checkpoints[len(checkpoints)-1]
+	assert.True(last.Offset <= int64(len(compressed)))
+
+	// resuming from any of them picks up at the decompressed position
+	for _, c := range checkpoints {
+		offset, err := fs.Resume(c)
+		assert.NoError(err)
+		assert.EqualValues(c.OutputOffset, offset)
+
+		n, err := io.ReadFull(fs, buf)
+		if err != io.ErrUnexpectedEOF && err != io.EOF {
+			assert.NoError(err)
+		}
+		assert.EqualValues(reference[offset:offset+int64(n)], buf[:n])
+	}
+}
+
+func Test_Auto(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+
+	t.Run("raw", func(t *testing.T) {
+		compressed, err := checker.FlateCompress(reference)
+		assert.NoError(t, err)
+
+		checker.RunSourceTest(t, flatesource.NewAuto(seeksource.FromBytes(compressed)), reference)
+	})
+
+	t.Run("zlib", func(t *testing.T) {
+		compressed, err := checker.ZlibCompress(reference)
+		assert.NoError(t, err)
+
+		checker.RunSourceTest(t, flatesource.NewAuto(seeksource.FromBytes(compressed)), reference)
+	})
+
+	t.Run("short", func(t *testing.T) {
+		// the bytes peeked at must make it to the decompressor
+		for _, ref := range [][]byte{nil, []byte("a"), []byte("hello")} {
+			for _, compress := range []func([]byte) ([]byte, error){checker.FlateCompress, checker.ZlibCompress} {
+				compressed, err := compress(ref)
+				assert.NoError(t, err)
+
+				fs := flatesource.NewAuto(seeksource.FromBytes(compressed))
+				_, err = fs.Resume(nil)
+				assert.NoError(t, err)
+				output, err := ioutil.ReadAll(fs)
+				assert.NoError(t, err)
+				assert.EqualValues(t, string(ref), string(output))
+			}
+		}
+	})
+}
+
+func Test_AutoZlibChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(1024 * 1024)
+	compressed, err := checker.ZlibCompress(reference)
+	assert.NoError(err)
+
+	// a raw source doesn't expect the header
+	fs := flatesource.New(seeksource.FromBytes(compressed))
+	_, err = fs.Resume(nil)
+	assert.NoError(err)
+	_, err = ioutil.ReadAll(fs)
+	assert.Error(err)
+
+	compressed[len(compressed)-1] ^= 0xff
+	fs = flatesource.NewAuto(seeksource.FromBytes(compressed))
+	_, err = fs.Resume(nil)
+	assert.NoError(err)
+	output, err := ioutil.ReadAll(fs)
+	assert.EqualValues(flatesource.ErrChecksum, errors.Cause(err))
+	assert.EqualValues(len(reference), len(output))
+
+	// the checksum is carried over in checkpoints
+	fs = flatesource.NewAuto(seeksource.FromBytes(compressed))
+	_, err = fs.Resume(nil)
+	assert.NoError(err)
+	var checkpoint *savior.SourceCheckpoint
+	fs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			checkpoint = c
+			return nil
+		},
+	})
+	buf := make([]byte, 16*1024)
+	for checkpoint == nil {
+		_, err = fs.Read(buf)
+		assert.NoError(err)
+		fs.WantSave()
+	}
+	fc := checkpoint.Data.(*flatesource.FlateSourceCheckpoint)
+	assert.True(fc.Zlib)
+	assert.NotEmpty(fc.Adler32State)
+	assert.EqualValues(fc.FlateCheckpoint.Roffset+2, checkpoint.Offset)
+
+	offset, err := fs.Resume(checkpoint)
+	assert.NoError(err)
+	assert.EqualValues(checkpoint.OutputOffset, offset)
+	output, err = ioutil.ReadAll(fs)
+	assert.EqualValues(flatesource.ErrChecksum, errors.Cause(err))
+	assert.EqualValues(reference[offset:], output)
+}
@@ -6,14 +6,52 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/itchio/headway/state"
+	"github.com/itchio/headway/united"
 	"github.com/itchio/ox"
 	"github.com/pkg/errors"
 )
 
 var EnableLegacyPreallocate = os.Getenv("SAVIOR_LEGACY_PREALLOCATE") == "1"
 
+// preallocate is a variable so tests can simulate filesystems
+// that don't support it.
+var preallocate = ox.Preallocate
+
+// freeSpace is a variable so tests can simulate a filling disk
+var freeSpace = diskFreeSpace
+
+// closeFile is a variable so tests can simulate failed flushes
+var closeFile = func(f *os.File) error {
+	return f.Close()
+}
+
+// mkdirLstat is a variable so tests can change the destination
+// behind Mkdir's back
+var mkdirLstat = os.Lstat
+
+// mkdirAttempts is how many times Mkdir checks a path and tries to make
+// it a directory, when something else keeps changing it
+const mkdirAttempts = 8
+
+// removeAll is a variable so tests can simulate folders
+// that can't be removed right away
+var removeAll = os.RemoveAll
+
+// nukeAttempts is how many times Nuke tries to remove the directory
+const nukeAttempts = 5
+
+// nukeDelay is how long Nuke waits before trying again the first time,
+// it waits twice as long every time after that
+var nukeDelay = 100 * time.Millisecond
+
+// freeSpaceCheckInterval is how many bytes can be written between
+// two checks of the available disk space, see `FolderSink.MinFreeSpace`
+const freeSpaceCheckInterval = 4 * 1024 * 1024
+
 const (
 	// ModeMask is or'd with files walked by butler
 	ModeMask = 0666
@@ -27,14 +65,146 @@ const (
 
 var onWindows = runtime.GOOS == "windows"
 
+// specialModes are the type bits of things that are neither regular
+// files, directories nor symlinks
+const specialModes = os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe | os.ModeSocket
+
 type FolderSink struct {
 	Directory string
 	Consumer  *state.Consumer
 
+	// SkipEmptySymlinks makes Symlink skip (with a warning) symlinks
+	// that have an empty target, instead of returning ErrEmptySymlinkTarget.
+	SkipEmptySymlinks bool
+
+	// OnOpen, if set, is called whenever a writer is opened for an entry,
+	// with the path of the file on disk.
+	OnOpen func(entry *Entry, path string)
+	// OnClose, if set, is called exactly once for each writer OnOpen was
+	// called for, with the number of bytes written through that writer
+	// (not counting anything written before a resume), and the error
+	// closing the file, if any.
+	OnClose func(entry *Entry, path string, bytesWritten int64, err error)
+
+	// MinFreeSpace, if non-zero, is the number of bytes that must be left
+	// free on the destination disk. Free space is checked when preallocating
+	// and every few megabytes written, and ErrNotEnoughSpace is returned
+	// before the margin is eaten into. It's not checked on systems where
+	// free space can't be queried.
+	MinFreeSpace int64
+
+	// TextLineEnding, if not LineKeep, converts line endings of text entries
+	// (marked as such by the archive, or with a well-known text extension).
+	// Those entries can't be resumed mid-way: they're restarted from scratch
+	// (see EntryRestarter), or fail with ErrLineEndingResume.
+	TextLineEnding LineEnding
+
+	// TransformContent, if set, is called for every file entry with the
+	// writer for the file on disk, and returns a writer everything goes
+	// through instead (to strip BOMs, decrypt, etc.), which is closed
+	// before the file is. Since transforms can change the number of bytes
+	// written, entries can't be resumed mid-way and are restarted from
+	// scratch instead (see EntryRestarter), or fail with ErrTransformResume.
+	TransformContent func(entry *Entry, w io.Writer) (io.WriteCloser, error)
+
+	// WindowsSymlinks makes Symlink create actual symlinks on Windows,
+	// instead of text files. Directory symlinks are created for entries
+	// with LinkToDir set, or whose target already is a directory on disk.
+	// That requires a privilege (running elevated, or in developer mode),
+	// when the process doesn't have it, symlinks are written as text files.
+	WindowsSymlinks bool
+
+	// StrictSizes makes GetWriter fail with ErrSizeChanged when an entry's
+	// UncompressedSize isn't the size it was preallocated with (if the
+	// archive was repaired in-between, for example), instead of warning.
+	// Either way, files end up the size of what's actually written.
+	StrictSizes bool
+
+	// AllowUnsafeSymlinks lets Symlink create symlinks whose target is
+	// absolute, or outside of Directory. Without it, they fail with
+	// ErrUnsafePath. Entries are never written through symlinks that point
+	// outside of Directory, either way.
+	AllowUnsafeSymlinks bool
+
+	// DereferenceSymlinks makes Symlink write a copy of the symlink's target
+	// instead, for destinations that can't have symlinks. The target must be
+	// within Directory, and must have been extracted already: if it wasn't,
+	// Symlink fails with ErrDanglingSymlink. Directories are copied with the
+	// files extracted to them so far.
+	DereferenceSymlinks bool
+	// SkipDanglingSymlinks makes dereferencing skip (with a warning) symlinks
+	// whose target doesn't exist, instead of failing, see DereferenceSymlinks.
+	SkipDanglingSymlinks bool
+
+	// UseReflinks makes file entries that are identical to the file at the
+	// same path in ReflinkReference (a previous version, or a base layer)
+	// reflinks of it, sharing its extents instead of being decompressed and
+	// written. That needs a filesystem that supports it (btrfs, XFS), with
+	// both folders on the same volume, otherwise entries are written as
+	// usual. See ReflinkSink.
+	UseReflinks bool
+	// ReflinkReference is the folder entries are reflinked from,
+	// see UseReflinks
+	ReflinkReference string
+
+	// GroupCommits makes files and hardlinks go to a staging folder in
+	// Directory until CommitGroup is called, which moves everything written
+	// since the previous call into place. Extractors call it between groups
+	// of entries (see zipextractor's SetCommitGroups), so that an interrupted
+	// group is nowhere to be seen, but completed ones are. Directories and
+	// symlinks are created right away, and files aren't preallocated.
+	// OnOpen and OnClose get staged paths. Entries in the staging folder
+	// (`.savior-staging`) fail with ErrUnsafePath.
+	GroupCommits bool
+
+	// AllowSpecialFiles makes file entries with named pipe type bits
+	// create named pipes (on platforms that have them). Without it, they're
+	// skipped with a warning, as are devices and sockets, which archives
+	// don't carry enough information to recreate.
+	AllowSpecialFiles bool
+
+	// KeepDirectories makes file and symlink entries fail with
+	// ErrPathConflict when there's a directory in their place on disk,
+	// instead of replacing it (and everything in it).
+	KeepDirectories bool
+
+	// ModePolicy, if set, decides the permissions of files and directories,
+	// instead of DefaultModePolicy. Parent directories that aren't in the
+	// archive are created with the policy's mode for a directory too (minus
+	// the process' umask), instead of LuckyMode. KindModePolicy assigns
+	// modes by kind alone, ignoring the archive's.
+	ModePolicy ModePolicy
+	// Umask is cleared from the permissions of everything created. When
+	// either ModePolicy or Umask is set, permissions are set exactly (the
+	// process' umask doesn't apply), on existing files and directories too.
+	Umask os.FileMode
+
 	writer *entryWriter
+
+	// sizes files were preallocated with, by canonical path,
+	// until they're written
+	preallocated map[string]int64
+
+	// set when creating a symlink failed for lack of privilege,
+	// see WindowsSymlinks
+	symlinksUnprivileged bool
+
+	// set when preallocate failed once, we then stick to legacyPreallocate
+	preallocateUnsupported bool
+
+	// set when reflinking failed once, see UseReflinks
+	reflinksUnsupported bool
 }
 
 var _ Sink = (*FolderSink)(nil)
+var _ PathSink = (*FolderSink)(nil)
+var _ EntryRestarter = (*FolderSink)(nil)
+var _ ResumeOffsetter = (*FolderSink)(nil)
+var _ SparseSink = (*FolderSink)(nil)
+var _ HardlinkSink = (*FolderSink)(nil)
+var _ ReflinkSink = (*FolderSink)(nil)
+var _ GroupCommitter = (*FolderSink)(nil)
+var _ EntryRemover = (*FolderSink)(nil)
 
 var ignoredNames = map[string]struct{}{
 	// the path for folder icons on macOS (yes, really).
@@ -48,56 +218,199 @@ func shouldIgnorePath(s string) bool {
 	return ok
 }
 
+// ErrRootEntry is returned when trying to write a file or symlink
+// over the root of the destination (for entries named "." or "")
+var ErrRootEntry = errors.New("entry refers to the root of the destination")
+
+// IsRootPath returns true if a canonical path refers to the root
+// of the archive itself, like ".", "" or "./"
+func IsRootPath(canonicalPath string) bool {
+	return path.Clean("/"+canonicalPath) == "/"
+}
+
+// IsDirectoryPath returns true if a canonical path has a trailing slash,
+// which always means the entry is a directory, whatever the archive
+// says its type is.
+func IsDirectoryPath(canonicalPath string) bool {
+	return strings.HasSuffix(canonicalPath, "/")
+}
+
+// ErrPathConflict is returned when a file or symlink entry would replace
+// a directory: either one the archive implies (with a `dir/` entry, or
+// entries under `dir/`), or one that's already on disk, see
+// `FolderSink.KeepDirectories`.
+var ErrPathConflict = errors.New("file entry conflicts with a directory of the same name")
+
+// ErrEmptySymlinkTarget is returned when trying to create a symlink
+// whose target is empty (which no OS accepts), see `FolderSink.SkipEmptySymlinks`
+var ErrEmptySymlinkTarget = errors.New("symlink has an empty target")
+
+// ErrNotEnoughSpace is returned when writing would leave less than
+// `FolderSink.MinFreeSpace` bytes free on the destination disk
+var ErrNotEnoughSpace = errors.New("not enough free space left on destination")
+
+// ErrTransformResume is returned when trying to resume an entry whose
+// contents are transformed, see `FolderSink.TransformContent`.
+var ErrTransformResume = errors.New("can't resume writing an entry whose contents are transformed")
+
+// ErrSizeChanged is returned when an entry is written with another size
+// than it was preallocated with, see `FolderSink.StrictSizes`.
+var ErrSizeChanged = errors.New("entry size changed since it was preallocated")
+
+// checkFreeSpace returns ErrNotEnoughSpace if writing `needed` more
+// bytes would eat into MinFreeSpace
+func (fs *FolderSink) checkFreeSpace(needed int64) error {
+	free, err := freeSpace(fs.Directory)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if free-needed < fs.MinFreeSpace {
+		return errors.Wrapf(ErrNotEnoughSpace, "%d bytes free, need %d plus a margin of %d", free, needed, fs.MinFreeSpace)
+	}
+	return nil
+}
+
 func (fs *FolderSink) destPath(entry *Entry) string {
 	return filepath.Join(fs.Directory, filepath.FromSlash(entry.CanonicalPath))
 }
 
+// DestPath returns the path on disk the entry is written to
+func (fs *FolderSink) DestPath(entry *Entry) string {
+	return fs.destPath(entry)
+}
+
 func (fs *FolderSink) Mkdir(entry *Entry) error {
 	if shouldIgnorePath(entry.CanonicalPath) {
 		return nil
 	}
 
-	dstpath := fs.destPath(entry)
+	if IsRootPath(entry.CanonicalPath) {
+		// the destination itself is not ours to chmod or replace
+		return nil
+	}
 
-	dirstat, err := os.Lstat(dstpath)
+	err := fs.checkDestPath(entry)
 	if err != nil {
-		// main case - dir doesn't exist yet
-		err = os.MkdirAll(dstpath, DirMode)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		return nil
+		return err
 	}
 
-	if dirstat.IsDir() {
-		// is already a dir, good!
-	} else {
-		// is a file or symlink for example, turn into a dir
-		err = os.Remove(dstpath)
+	dstpath := fs.destPath(entry)
+
+	// something else (another entry being extracted concurrently, another
+	// installer) may change the path between each of our steps, so we check
+	// it again after each of them, until it's a directory.
+	for attempt := 0; attempt < mkdirAttempts; attempt++ {
+		dirstat, err := mkdirLstat(dstpath)
 		if err != nil {
-			return errors.WithStack(err)
+			if !os.IsNotExist(err) {
+				return errors.WithStack(err)
+			}
+
+			// main case - dir doesn't exist yet
+			err = os.MkdirAll(dstpath, fs.mode(entry))
+			if err != nil {
+				if _, statErr := os.Lstat(dstpath); statErr == nil {
+					// someone created it in the meantime (EEXIST, or
+					// ENOTDIR if it's a file), see what it is now
+					continue
+				}
+				return errors.WithStack(err)
+			}
+			continue
 		}
-		err = os.MkdirAll(dstpath, DirMode)
-		if err != nil {
+
+		if dirstat.IsDir() {
+			// is a dir, good!
+			if fs.enforcesModes() && !onWindows {
+				err = os.Chmod(dstpath, fs.mode(entry))
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
+			return fs.setModTime(entry, dstpath)
+		}
+
+		// is a file or symlink for example, remove it, it'll be
+		// turned into a dir on the next attempt
+		err = os.Remove(dstpath)
+		if err != nil && !os.IsNotExist(err) {
 			return errors.WithStack(err)
 		}
 	}
 
+	return errors.Errorf("%s: destination kept changing, gave up making it a directory after %d attempts", entry.CanonicalPath, mkdirAttempts)
+}
+
+// setModTime sets the modification time of path to the entry's ModTime,
+// if it has one, and its access time to the entry's AccessTime (or ModTime).
+// Filesystems with a coarser resolution than the archive's truncate them.
+func (fs *FolderSink) setModTime(entry *Entry, path string) error {
+	if entry.ModTime.IsZero() {
+		return nil
+	}
+
+	atime := entry.AccessTime
+	if atime.IsZero() {
+		atime = entry.ModTime
+	}
+
+	err := os.Chtimes(path, atime, entry.ModTime)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// replaceDir removes the directory at dstpath to make way for a file or
+// symlink entry, unless KeepDirectories is set
+func (fs *FolderSink) replaceDir(entry *Entry, dstpath string) error {
+	if fs.KeepDirectories {
+		return errors.Wrapf(ErrPathConflict, "%s", entry.CanonicalPath)
+	}
+
+	Debugf("folder_sink: replacing directory %s with a %s", entry.CanonicalPath, entry.Kind)
+	err := os.RemoveAll(dstpath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	return nil
 }
 
 func (fs *FolderSink) createFile(entry *Entry) (*os.File, error) {
-	dstpath := fs.destPath(entry)
+	if IsRootPath(entry.CanonicalPath) {
+		return nil, errors.WithStack(ErrRootEntry)
+	}
+
+	err := fs.checkDestPath(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.GroupCommits && fs.KeepDirectories {
+		// fail now rather than when committing
+		if stats, err := os.Lstat(fs.destPath(entry)); err == nil && stats.IsDir() {
+			return nil, errors.Wrapf(ErrPathConflict, "%s", entry.CanonicalPath)
+		}
+	}
+
+	dstpath := fs.filePath(entry)
 
 	dirname := filepath.Dir(dstpath)
-	err := os.MkdirAll(dirname, LuckyMode)
+	err = os.MkdirAll(dirname, fs.parentMode(entry))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	stats, err := os.Lstat(dstpath)
 	if err == nil {
-		if stats.Mode()&os.ModeSymlink > 0 {
+		if stats.IsDir() {
+			err = fs.replaceDir(entry, dstpath)
+			if err != nil {
+				return nil, err
+			}
+			stats = nil
+		} else if stats.Mode()&os.ModeSymlink > 0 {
 			// if it used to be a symlink, remove it
 			err = os.RemoveAll(dstpath)
 			if err != nil {
@@ -106,15 +419,27 @@ func (fs *FolderSink) createFile(entry *Entry) (*os.File, error) {
 		}
 	}
 
+	mode := fs.mode(entry)
 	flag := os.O_CREATE | os.O_WRONLY
-	f, err := os.OpenFile(dstpath, flag, entry.Mode|ModeMask)
+	f, err := os.OpenFile(dstpath, flag, mode)
+	if err != nil && stats != nil && os.IsPermission(err) {
+		// a read-only file from a previous extraction, make it writable
+		// and try again. on windows, this clears the read-only attribute.
+		openErr := err
+		err = os.Chmod(dstpath, mode|0200)
+		if err != nil {
+			return nil, errors.WithStack(openErr)
+		}
+		f, err = os.OpenFile(dstpath, flag, mode)
+	}
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	if stats != nil && !onWindows {
-		// if file already existed, chmod it, just in case
-		err = f.Chmod(entry.Mode | ModeMask)
+	if (stats != nil || fs.enforcesModes()) && !onWindows {
+		// if file already existed, chmod it, just in case. with a
+		// policy, the process' umask mustn't change the mode either
+		err = f.Chmod(mode)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -123,11 +448,92 @@ func (fs *FolderSink) createFile(entry *Entry) (*os.File, error) {
 	return f, nil
 }
 
+// createSpecialFile creates a named pipe for the entry if AllowSpecialFiles
+// is set, and warns about skipping it otherwise, see AllowSpecialFiles
+func (fs *FolderSink) createSpecialFile(entry *Entry) error {
+	if !fs.AllowSpecialFiles || onWindows || entry.Mode&os.ModeNamedPipe == 0 {
+		fs.Consumer.Warnf("folder_sink: skipping special file %s (mode %s)", entry.CanonicalPath, entry.Mode)
+		return nil
+	}
+
+	if IsRootPath(entry.CanonicalPath) {
+		return errors.WithStack(ErrRootEntry)
+	}
+
+	err := fs.checkDestPath(entry)
+	if err != nil {
+		return err
+	}
+
+	dstpath := fs.destPath(entry)
+
+	err = os.MkdirAll(filepath.Dir(dstpath), fs.parentMode(entry))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if stats, err := os.Lstat(dstpath); err == nil {
+		if stats.Mode()&os.ModeNamedPipe != 0 {
+			// created by a previous extraction
+			return nil
+		}
+		if stats.IsDir() {
+			err = fs.replaceDir(entry, dstpath)
+		} else {
+			err = os.Remove(dstpath)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	err = mkfifo(dstpath, entry.Mode.Perm())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// mkfifo is subject to umask
+	err = os.Chmod(dstpath, entry.Mode.Perm())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 func (fs *FolderSink) GetWriter(entry *Entry) (EntryWriter, error) {
 	if shouldIgnorePath(entry.CanonicalPath) {
 		return &nopEntryWriter{}, nil
 	}
 
+	if entry.Mode&specialModes != 0 {
+		err := fs.createSpecialFile(entry)
+		if err != nil {
+			return nil, err
+		}
+		return &nopEntryWriter{}, nil
+	}
+
+	convertLineEndings := fs.TextLineEnding != LineKeep && isTextEntry(entry)
+	if convertLineEndings && entry.WriteOffset > 0 {
+		return nil, errors.Wrapf(ErrLineEndingResume, "%s", entry.CanonicalPath)
+	}
+	if fs.TransformContent != nil && entry.WriteOffset > 0 {
+		return nil, errors.Wrapf(ErrTransformResume, "%s", entry.CanonicalPath)
+	}
+
+	// close the previous writer first, so we never have more than one
+	// file open, and so that a failed close (which might mean its data
+	// never made it to disk) stops the extraction.
+	err := fs.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "closing previous writer")
+	}
+
+	err = fs.checkPreallocatedSize(entry)
+	if err != nil {
+		return nil, err
+	}
+
 	f, err := fs.createFile(entry)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -140,34 +546,134 @@ func (fs *FolderSink) GetWriter(entry *Entry) (EntryWriter, error) {
 	if entry.WriteOffset > 0 {
 		_, err = f.Seek(entry.WriteOffset, io.SeekStart)
 		if err != nil {
+			f.Close()
 			return nil, errors.WithStack(err)
 		}
 	}
 
 	err = f.Truncate(entry.WriteOffset)
 	if err != nil {
+		f.Close()
 		return nil, errors.WithStack(err)
 	}
 
-	err = fs.Close()
-	if err != nil {
-		fs.Consumer.Warnf("folder_sink could not close last writer: %s", err.Error())
-	}
-
 	ew := &entryWriter{
 		fs:    fs,
 		f:     f,
 		entry: entry,
+		path:  f.Name(),
+
+		uncheckedBytes: -1,
+	}
+	var inner io.Writer = f
+	if convertLineEndings {
+		ew.conv = &lineEndingWriter{
+			w:    f,
+			mode: fs.TextLineEnding,
+		}
+		inner = ew.conv
+	}
+	if fs.TransformContent != nil {
+		ew.transform, err = fs.TransformContent(entry, inner)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "transforming %s", entry.CanonicalPath)
+		}
 	}
 	fs.writer = ew
 
+	if fs.OnOpen != nil {
+		fs.OnOpen(entry, ew.path)
+	}
+
 	return ew, nil
 }
 
+// WriteSparse is like GetWriter, but skips over the holes between
+// segments instead of writing zeros, so the file ends up sparse on
+// filesystems that support it. The file is sized to the entry's
+// UncompressedSize, or if it's unknown, to what was written when the
+// writer is closed. Entries whose line endings are converted or
+// contents transformed are written in full.
+func (fs *FolderSink) WriteSparse(entry *Entry, segments []SparseSegment) (EntryWriter, error) {
+	if shouldIgnorePath(entry.CanonicalPath) || fs.NeedsRestart(entry) {
+		return fs.GetWriter(entry)
+	}
+
+	w, err := fs.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// GetWriter truncated the file at WriteOffset, which also
+	// clears anything written past it before we were interrupted,
+	// this extends it back with a hole.
+	if entry.SizeKnown() {
+		err = fs.writer.f.Truncate(entry.UncompressedSize)
+		if err != nil {
+			fs.Close()
+			return nil, errors.WithStack(err)
+		}
+	}
+	fs.writer.segments = segments
+
+	return w, nil
+}
+
+// NeedsRestart returns true for entries that can't be resumed mid-way,
+// because their line endings are converted or their contents transformed.
+func (fs *FolderSink) NeedsRestart(entry *Entry) bool {
+	if fs.TransformContent != nil {
+		return true
+	}
+	return fs.TextLineEnding != LineKeep && isTextEntry(entry)
+}
+
+// ResumeOffset returns how much of the entry is on disk. Only the size of
+// the file is checked, not its contents: a file that's long enough is
+// assumed to be the one the checkpoint was taken with. A missing file
+// (or something else than a file) holds nothing.
+func (fs *FolderSink) ResumeOffset(entry *Entry) (int64, error) {
+	if shouldIgnorePath(entry.CanonicalPath) {
+		return entry.WriteOffset, nil
+	}
+
+	err := fs.checkDestPath(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	stats, err := os.Lstat(fs.filePath(entry))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.WithStack(err)
+	}
+
+	if !stats.Mode().IsRegular() {
+		return 0, nil
+	}
+	if stats.Size() < entry.WriteOffset {
+		return stats.Size(), nil
+	}
+	return entry.WriteOffset, nil
+}
+
+// Preallocate creates the entry's file, and reserves its UncompressedSize
+// on disk. Files of unknown size (see Entry.SizeKnown) are only created.
 func (fs *FolderSink) Preallocate(entry *Entry) error {
 	if shouldIgnorePath(entry.CanonicalPath) {
 		return nil
 	}
+	if entry.Mode&specialModes != 0 {
+		// created (or skipped) by GetWriter
+		return nil
+	}
+	if fs.GroupCommits {
+		// everything that's staged is committed with the current group
+		return nil
+	}
 
 	f, err := fs.createFile(entry)
 	if err != nil {
@@ -176,23 +682,88 @@ func (fs *FolderSink) Preallocate(entry *Entry) error {
 
 	defer f.Close()
 
+	if entry.UncompressedSize > 0 && fs.MinFreeSpace > 0 {
+		stats, err := f.Stat()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if needed := entry.UncompressedSize - stats.Size(); needed > 0 {
+			err = fs.checkFreeSpace(needed)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	if entry.UncompressedSize > 0 {
-		if EnableLegacyPreallocate {
+		// the file may have been preallocated (or written) with a bigger
+		// size before, don't leave that lying around
+		stats, err := f.Stat()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if stats.Size() > entry.UncompressedSize {
+			err = f.Truncate(entry.UncompressedSize)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		if EnableLegacyPreallocate || fs.preallocateUnsupported {
 			err := legacyPreallocate(f, entry.UncompressedSize)
 			if err != nil {
 				return err
 			}
 		} else {
-			err := ox.Preallocate(f, entry.UncompressedSize)
+			err := preallocate(f, entry.UncompressedSize)
 			if err != nil {
-				return err
+				if isNoSpace(err) {
+					return err
+				}
+
+				// some filesystems just don't support it (network shares,
+				// FUSE, etc.), but that shouldn't stop the extraction.
+				fs.Consumer.Warnf("folder_sink: preallocate unsupported (%s), falling back to writing zeroes", err.Error())
+				fs.preallocateUnsupported = true
+
+				err = legacyPreallocate(f, entry.UncompressedSize)
+				if err != nil {
+					return err
+				}
 			}
 		}
+
+		if fs.preallocated == nil {
+			fs.preallocated = make(map[string]int64)
+		}
+		fs.preallocated[entry.CanonicalPath] = entry.UncompressedSize
 	}
 
 	return nil
 }
 
+// checkPreallocatedSize warns (or fails, see StrictSizes) if the entry
+// was preallocated with another size than it's now written with. GetWriter
+// truncates the file at WriteOffset anyway, so the stale size doesn't stick.
+func (fs *FolderSink) checkPreallocatedSize(entry *Entry) error {
+	size, ok := fs.preallocated[entry.CanonicalPath]
+	if !ok {
+		return nil
+	}
+	delete(fs.preallocated, entry.CanonicalPath)
+
+	if size == entry.UncompressedSize {
+		return nil
+	}
+
+	if fs.StrictSizes {
+		return errors.Wrapf(ErrSizeChanged, "%s: preallocated %d bytes, now %d", entry.CanonicalPath, size, entry.UncompressedSize)
+	}
+	fs.Consumer.Warnf("folder_sink: %s was preallocated with %s, but is now %s", entry.CanonicalPath, united.FormatBytes(size), united.FormatBytes(entry.UncompressedSize))
+	return nil
+}
+
 func legacyPreallocate(f *os.File, size int64) error {
 	endOffset, err := f.Seek(0, io.SeekEnd)
 	if err != nil {
@@ -215,41 +786,194 @@ func (fs *FolderSink) Symlink(entry *Entry, linkname string) error {
 		return nil
 	}
 
+	if IsRootPath(entry.CanonicalPath) {
+		return errors.WithStack(ErrRootEntry)
+	}
+
+	if linkname == "" {
+		if fs.SkipEmptySymlinks {
+			fs.Consumer.Warnf("folder_sink: skipping symlink (%s) with empty target", entry.CanonicalPath)
+			return nil
+		}
+		return errors.Wrapf(ErrEmptySymlinkTarget, "%s", entry.CanonicalPath)
+	}
+
+	if fs.symlinksAsFiles() {
+		return fs.writeSymlinkAsFile(entry, linkname)
+	}
+
+	if fs.DereferenceSymlinks {
+		return fs.dereferenceSymlink(entry, linkname)
+	}
+
+	err := fs.checkSymlinkTarget(entry, linkname)
+	if err != nil {
+		return err
+	}
+
 	if onWindows {
+		if fs.WindowsSymlinks && !fs.symlinksUnprivileged {
+			err := fs.createSymlink(entry, linkname)
+			if err == nil || !isSymlinkPrivilegeError(err) {
+				return err
+			}
+			fs.Consumer.Warnf("folder_sink: can't create symlinks (%s), writing them as files", err.Error())
+			fs.symlinksUnprivileged = true
+		}
+
 		// on windows, write symlinks as regular files
-		w, err := fs.GetWriter(entry)
-		if err != nil {
-			return errors.WithStack(err)
+		return fs.writeSymlinkAsFile(entry, linkname)
+	}
+
+	return fs.createSymlink(entry, linkname)
+}
+
+// writeSymlinkAsFile writes a regular file holding the symlink's target
+func (fs *FolderSink) writeSymlinkAsFile(entry *Entry, linkname string) error {
+	w, err := fs.GetWriter(entry)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer w.Close()
+
+	_, err = w.Write([]byte(linkname))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (fs *FolderSink) createSymlink(entry *Entry, linkname string) error {
+	err := fs.checkDestPath(entry)
+	if err != nil {
+		return err
+	}
+
+	dstpath := fs.destPath(entry)
+
+	if stats, err := os.Lstat(dstpath); err == nil && stats.IsDir() && fs.KeepDirectories {
+		return errors.Wrapf(ErrPathConflict, "%s", entry.CanonicalPath)
+	}
+
+	err = os.RemoveAll(dstpath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dirname := filepath.Dir(dstpath)
+	err = os.MkdirAll(dirname, fs.parentMode(entry))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = symlink(linkname, dstpath, entry.LinkToDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Hardlink makes the entry a hard link to the file at `target`. When
+// the filesystem can't do hard links, the file is copied instead.
+func (fs *FolderSink) Hardlink(entry *Entry, target string) error {
+	if shouldIgnorePath(entry.CanonicalPath) {
+		return nil
+	}
+
+	if IsRootPath(entry.CanonicalPath) {
+		return errors.WithStack(ErrRootEntry)
+	}
+
+	cleanTarget := path.Clean(target)
+	if path.IsAbs(cleanTarget) || cleanTarget == ".." || strings.HasPrefix(cleanTarget, "../") {
+		return errors.Wrapf(ErrUnsafePath, "%s: hardlink target %s is outside the destination", entry.CanonicalPath, target)
+	}
+
+	err := fs.checkDestPath(entry)
+	if err != nil {
+		return err
+	}
+	err = fs.checkDestPath(&Entry{CanonicalPath: cleanTarget})
+	if err != nil {
+		return err
+	}
+
+	// the target may be the file we're writing, it must be complete
+	err = fs.Close()
+	if err != nil {
+		return errors.Wrap(err, "closing previous writer")
+	}
+
+	srcpath := filepath.Join(fs.Directory, filepath.FromSlash(cleanTarget))
+	if fs.GroupCommits {
+		// the target may not be committed yet
+		staged := fs.filePath(&Entry{CanonicalPath: cleanTarget})
+		if _, err := os.Lstat(staged); err == nil {
+			srcpath = staged
 		}
-		defer w.Close()
+	}
+	srcstats, err := os.Stat(srcpath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		_, err = w.Write([]byte(linkname))
+	dstpath := fs.filePath(entry)
+	if stats, err := os.Lstat(dstpath); err == nil {
+		if os.SameFile(stats, srcstats) {
+			// already linked, before we got interrupted
+			return nil
+		}
+		if stats.IsDir() {
+			err = fs.replaceDir(entry, dstpath)
+		} else {
+			err = os.Remove(dstpath)
+		}
 		if err != nil {
 			return errors.WithStack(err)
 		}
+	}
 
+	dirname := filepath.Dir(dstpath)
+	err = os.MkdirAll(dirname, fs.parentMode(entry))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.Link(srcpath, dstpath)
+	if err == nil {
 		return nil
 	}
+	fs.Consumer.Debugf("folder_sink: can't hardlink %s (%s), copying it", entry.CanonicalPath, err.Error())
 
-	// actual symlink code
-	dstpath := fs.destPath(entry)
+	return copyFile(srcpath, dstpath, fs.copyMode(srcstats))
+}
 
-	err := os.RemoveAll(dstpath)
+// copyFile copies a regular file, for hardlinks on
+// filesystems that don't have them
+func copyFile(srcpath string, dstpath string, mode os.FileMode) error {
+	src, err := os.Open(srcpath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	defer src.Close()
 
-	dirname := filepath.Dir(dstpath)
-	err = os.MkdirAll(dirname, LuckyMode)
+	dst, err := os.OpenFile(dstpath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = os.Symlink(linkname, dstpath)
+	_, err = io.Copy(dst, src)
 	if err != nil {
+		dst.Close()
 		return errors.WithStack(err)
 	}
 
+	err = dst.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	return nil
 }
 
@@ -258,9 +982,103 @@ func (fs *FolderSink) Nuke() error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	fs.preallocated = nil
+
+	// on Windows, antivirus and indexing services keep files open for a
+	// little while after they're written, which makes removal fail with
+	// a transient error, see IsTransientError. Other errors go straight
+	// to the last attempt, which makes everything writable first.
+	delay := nukeDelay
+	for attempt := 1; ; attempt++ {
+		if attempt == nukeAttempts {
+			fs.Consumer.Warnf("folder_sink: making everything in %s writable before trying to remove it one last time", fs.Directory)
+			chmodAll(fs.Directory, LuckyMode)
+		}
+
+		err = removeAll(fs.Directory)
+		if err == nil {
+			return nil
+		}
+		if attempt >= nukeAttempts {
+			return errors.Wrapf(err, "removing %s (giving up after %d attempts)", fs.Directory, attempt)
+		}
+
+		if !IsTransientError(err) {
+			fs.Consumer.Warnf("folder_sink: could not remove %s (%s)", fs.Directory, err.Error())
+			attempt = nukeAttempts - 1
+			continue
+		}
+		fs.Consumer.Warnf("folder_sink: could not remove %s (%s), retrying in %s (attempt %d/%d)", fs.Directory, err.Error(), delay, attempt+1, nukeAttempts)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// chmodAll sets the mode of path and everything in it, as far as
+// it can, without following symlinks
+func chmodAll(path string, mode os.FileMode) {
+	stats, err := os.Lstat(path)
+	if err != nil || stats.Mode()&os.ModeSymlink != 0 {
+		return
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		Debugf("folder_sink: chmod %s: %v", path, err)
+	}
+	if !stats.IsDir() {
+		return
+	}
 
-	// TODO: retry logic, a-la butler
-	return os.RemoveAll(fs.Directory)
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		chmodAll(filepath.Join(path, name), mode)
+	}
+}
+
+// RemoveEntry removes the file (staged, when commits are grouped)
+// or symlink written for an entry, closing its writer first
+func (fs *FolderSink) RemoveEntry(entry *Entry) error {
+	if shouldIgnorePath(entry.CanonicalPath) {
+		return nil
+	}
+
+	var path string
+	switch entry.Kind {
+	case EntryKindFile, EntryKindHardlink:
+		path = fs.filePath(entry)
+	case EntryKindSymlink:
+		path = fs.destPath(entry)
+	default:
+		return nil
+	}
+
+	err := fs.checkDestPath(entry)
+	if err != nil {
+		return err
+	}
+
+	if fs.writer != nil && fs.writer.entry.CanonicalPath == entry.CanonicalPath {
+		err := fs.Close()
+		if err != nil {
+			// it's going away anyway
+			Debugf("%s: while closing before removal: %+v", entry.CanonicalPath, err)
+		}
+	}
+	delete(fs.preallocated, entry.CanonicalPath)
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
 }
 
 func (fs *FolderSink) Close() error {
@@ -277,6 +1095,26 @@ type entryWriter struct {
 	fs    *FolderSink
 	f     *os.File
 	entry *Entry
+	path  string
+
+	written int64
+
+	// bytes written since we last checked the free space,
+	// -1 if we never checked
+	uncheckedBytes int64
+
+	// conv converts line endings, if enabled for this entry
+	conv *lineEndingWriter
+
+	// transform is returned by FolderSink.TransformContent, if set
+	transform io.WriteCloser
+
+	// segments is set for sparse entries, see FolderSink.WriteSparse
+	segments []SparseSegment
+
+	// closeErr is returned by subsequent calls to Close, so that the
+	// sink gets to see it even if whoever closed us first ignored it
+	closeErr error
 }
 
 var _ EntryWriter = (*entryWriter)(nil)
@@ -286,21 +1124,119 @@ func (ew *entryWriter) Write(buf []byte) (int, error) {
 		return 0, os.ErrClosed
 	}
 
-	n, err := ew.f.Write(buf)
+	if ew.fs.MinFreeSpace > 0 {
+		err := ew.checkFreeSpace(int64(len(buf)))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if ew.transform != nil {
+		n, err = ew.transform.Write(buf)
+	} else if ew.conv != nil {
+		n, err = ew.conv.Write(buf)
+	} else if ew.segments != nil {
+		n, err = ew.writeSparse(buf)
+	} else {
+		n, err = ew.f.Write(buf)
+	}
+	// for converted or transformed entries, that's the number of bytes consumed
 	ew.entry.WriteOffset += int64(n)
+	ew.written += int64(n)
 	return n, err
 }
 
+// writeSparse writes the parts of buf that fall within segments,
+// at the current offset, and skips over the rest.
+func (ew *entryWriter) writeSparse(buf []byte) (int, error) {
+	start := ew.entry.WriteOffset
+	end := start + int64(len(buf))
+
+	for _, seg := range ew.segments {
+		lo, hi := seg.Offset, seg.Offset+seg.Size
+		if lo < start {
+			lo = start
+		}
+		if hi > end {
+			hi = end
+		}
+		if lo >= hi {
+			continue
+		}
+
+		_, err := ew.f.WriteAt(buf[lo-start:hi-start], lo)
+		if err != nil {
+			return int(lo - start), errors.WithStack(err)
+		}
+	}
+	return len(buf), nil
+}
+
+// checkFreeSpace checks the available disk space every
+// freeSpaceCheckInterval bytes. Writes that land within the current
+// size of the file (preallocated, or resumed) don't need more space.
+func (ew *entryWriter) checkFreeSpace(size int64) error {
+	if ew.uncheckedBytes >= 0 && ew.uncheckedBytes+size < freeSpaceCheckInterval {
+		ew.uncheckedBytes += size
+		return nil
+	}
+	ew.uncheckedBytes = 0
+
+	stats, err := ew.f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// leave room for everything we'll write until the next check
+	needed := ew.entry.WriteOffset + size + freeSpaceCheckInterval - stats.Size()
+	if needed <= 0 {
+		return nil
+	}
+	return ew.fs.checkFreeSpace(needed)
+}
+
 func (ew *entryWriter) Close() error {
 	if ew.f == nil {
 		// already closed
-		return nil
+		return ew.closeErr
+	}
+
+	var flushErr error
+	if ew.transform != nil {
+		flushErr = ew.transform.Close()
+	}
+	if ew.conv != nil {
+		err := ew.conv.Flush()
+		if flushErr == nil {
+			flushErr = err
+		}
+	}
+
+	if flushErr == nil && !ew.entry.SizeKnown() && ew.transform == nil && ew.conv == nil {
+		// nothing was preallocated, but sparse entries skip over their
+		// holes: make the file exactly as long as what was written
+		flushErr = errors.WithStack(ew.f.Truncate(ew.entry.WriteOffset))
 	}
 
-	err := ew.f.Close()
+	err := closeFile(ew.f)
+	if err == nil {
+		err = flushErr
+	}
 	ew.f = nil
+	if err == nil && (!ew.entry.SizeKnown() || ew.entry.WriteOffset >= ew.entry.UncompressedSize) {
+		// only once the entry is complete: closing between sessions
+		// shouldn't make a partial file look older than it is. There's
+		// no telling for entries of unknown size.
+		err = ew.fs.setModTime(ew.entry, ew.path)
+	}
+	if ew.fs.OnClose != nil {
+		ew.fs.OnClose(ew.entry, ew.path, ew.written, err)
+	}
 	if err != nil {
-		return errors.WithStack(err)
+		ew.closeErr = errors.Wrapf(err, "closing %s", ew.entry.CanonicalPath)
+		return ew.closeErr
 	}
 
 	return nil
@@ -0,0 +1,63 @@
+package savior_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FolderSinkAccessTime(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+	defer fs.Close()
+
+	modTime := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	accessTime := time.Date(2010, time.November, 10, 23, 0, 0, 0, time.UTC)
+	times := func(name string) (time.Time, time.Time) {
+		stats, err := os.Stat(filepath.Join(dir, name))
+		tmust(t, err)
+		st := stats.Sys().(*syscall.Stat_t)
+		return stats.ModTime(), time.Unix(st.Atim.Unix())
+	}
+
+	tmust(t, fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		Mode:          0755,
+		CanonicalPath: "dir",
+		ModTime:       modTime,
+		AccessTime:    accessTime,
+	}))
+	mtime, atime := times("dir")
+	assert.True(modTime.Equal(mtime), "mtime %s", mtime)
+	assert.True(accessTime.Equal(atime), "atime %s", atime)
+
+	// without an access time, it's the same as the modification time
+	w, err := fs.GetWriter(&savior.Entry{
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		CanonicalPath:    "file",
+		UncompressedSize: 2,
+		ModTime:          modTime,
+	})
+	tmust(t, err)
+	_, err = w.Write([]byte("hi"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+	mtime, atime = times("file")
+	assert.True(modTime.Equal(mtime), "mtime %s", mtime)
+	assert.True(modTime.Equal(atime), "atime %s", atime)
+}
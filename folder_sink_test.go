@@ -1,11 +1,20 @@
 package savior_test
 
 import (
+	"bytes"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/itchio/headway/state"
 	"github.com/itchio/savior"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -102,3 +111,1322 @@ func tmust(t *testing.T, err error) {
 		t.FailNow()
 	}
 }
+
+func Test_FolderSinkPreallocateFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	var numPreallocateCalls int
+	restore := savior.SetPreallocateFunc(func(f *os.File, size int64) error {
+		numPreallocateCalls++
+		return errors.WithStack(syscall.EOPNOTSUPP)
+	})
+	defer restore()
+
+	var warnings []string
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				if lvl == "warning" {
+					warnings = append(warnings, msg)
+				}
+			},
+		},
+	}
+
+	for _, name := range []string{"a", "b"} {
+		err = fs.Preallocate(&savior.Entry{
+			Kind:             savior.EntryKindFile,
+			Mode:             0644,
+			CanonicalPath:    name,
+			UncompressedSize: 4096,
+		})
+		tmust(t, err)
+
+		stats, err := os.Stat(filepath.Join(dir, name))
+		tmust(t, err)
+		assert.EqualValues(4096, stats.Size())
+	}
+	assert.EqualValues(1, numPreallocateCalls, "should stop trying after the first failure")
+	assert.EqualValues(1, len(warnings), "should only warn once")
+
+	restore2 := savior.SetPreallocateFunc(func(f *os.File, size int64) error {
+		return errors.WithStack(&os.PathError{Op: "fallocate", Path: f.Name(), Err: syscall.ENOSPC})
+	})
+	defer restore2()
+
+	fs2 := &savior.FolderSink{
+		Directory: dir,
+	}
+	err = fs2.Preallocate(&savior.Entry{
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		CanonicalPath:    "c",
+		UncompressedSize: 4096,
+	})
+	assert.Error(err, "running out of space should abort")
+
+	// however deep it's wrapped
+	restore3 := savior.SetPreallocateFunc(func(f *os.File, size int64) error {
+		return errors.Wrap(&os.PathError{Op: "fallocate", Path: f.Name(), Err: os.NewSyscallError("fallocate", syscall.ENOSPC)}, "preallocating")
+	})
+	defer restore3()
+
+	err = fs2.Preallocate(&savior.Entry{
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		CanonicalPath:    "d",
+		UncompressedSize: 4096,
+	})
+	assert.Error(err, "running out of space should abort")
+}
+
+func Test_FolderSinkPreallocateSizeChange(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	var warnings []string
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				if lvl == "warning" {
+					warnings = append(warnings, msg)
+				}
+			},
+		},
+	}
+	defer fs.Close()
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		CanonicalPath:    "file",
+		UncompressedSize: 64 * 1024,
+	}
+	fileSize := func() int64 {
+		stats, err := os.Stat(filepath.Join(dir, "file"))
+		tmust(t, err)
+		return stats.Size()
+	}
+
+	tmust(t, fs.Preallocate(entry))
+	assert.EqualValues(64*1024, fileSize())
+
+	// preallocating again with a smaller size doesn't leave the old one
+	entry.UncompressedSize = 32 * 1024
+	tmust(t, fs.Preallocate(entry))
+	assert.EqualValues(32*1024, fileSize())
+
+	// then the entry turns out to be even smaller when it's written
+	entry.UncompressedSize = 5
+	w, err := fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("hello"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+	assert.EqualValues(5, fileSize())
+	assert.Len(warnings, 1, "should warn about the size change")
+
+	// unless it's not allowed
+	fs.StrictSizes = true
+	entry.UncompressedSize = 1024
+	entry.WriteOffset = 0
+	tmust(t, fs.Preallocate(entry))
+	entry.UncompressedSize = 2048
+	_, err = fs.GetWriter(entry)
+	assert.Equal(savior.ErrSizeChanged, errors.Cause(err))
+
+	// the same size is fine
+	entry.UncompressedSize = 1024
+	tmust(t, fs.Preallocate(entry))
+	_, err = fs.GetWriter(entry)
+	tmust(t, err)
+	assert.Len(warnings, 1)
+}
+
+func Test_FolderSinkRootEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	tmust(t, os.Chmod(dir, 0700))
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+
+	for _, name := range []string{".", "", "./"} {
+		err = fs.Mkdir(&savior.Entry{
+			Kind:          savior.EntryKindDir,
+			Mode:          0777,
+			CanonicalPath: name,
+		})
+		assert.NoError(err)
+
+		_, err = fs.GetWriter(&savior.Entry{
+			Kind:          savior.EntryKindFile,
+			Mode:          0644,
+			CanonicalPath: name,
+		})
+		assert.Error(err)
+		assert.True(errors.Cause(err) == savior.ErrRootEntry)
+
+		err = fs.Symlink(&savior.Entry{
+			Kind:          savior.EntryKindSymlink,
+			CanonicalPath: name,
+		}, "elsewhere")
+		assert.Error(err)
+	}
+
+	stats, err := os.Stat(dir)
+	tmust(t, err)
+	assert.True(stats.IsDir())
+	assert.EqualValues(0700, stats.Mode()&os.ModePerm)
+}
+
+func Test_FolderSinkEmptySymlink(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	entry := &savior.Entry{
+		Kind:          savior.EntryKindSymlink,
+		CanonicalPath: "empty-link",
+	}
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+	err = fs.Symlink(entry, "")
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrEmptySymlinkTarget)
+
+	fs.SkipEmptySymlinks = true
+	err = fs.Symlink(entry, "")
+	assert.NoError(err)
+
+	_, err = os.Lstat(filepath.Join(dir, "empty-link"))
+	assert.True(os.IsNotExist(err), "skipped symlink should not be created")
+}
+
+func Test_FolderSinkOpenCloseCallbacks(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	type closeCall struct {
+		name         string
+		bytesWritten int64
+	}
+	var opens []string
+	var closes []closeCall
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+		OnOpen: func(entry *savior.Entry, path string) {
+			assert.EqualValues(filepath.Join(dir, entry.CanonicalPath), path)
+			opens = append(opens, entry.CanonicalPath)
+		},
+		OnClose: func(entry *savior.Entry, path string, bytesWritten int64, err error) {
+			assert.NoError(err)
+			closes = append(closes, closeCall{entry.CanonicalPath, bytesWritten})
+		},
+	}
+
+	a := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "a",
+	}
+	b := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "sub/b",
+	}
+
+	w, err := fs.GetWriter(a)
+	tmust(t, err)
+	_, err = w.Write([]byte("foobar"))
+	tmust(t, err)
+	tmust(t, w.Close())
+	// closing twice shouldn't fire twice
+	tmust(t, w.Close())
+
+	// resume halfway through
+	a.WriteOffset = 3
+	w, err = fs.GetWriter(a)
+	tmust(t, err)
+	_, err = w.Write([]byte("baz"))
+	tmust(t, err)
+
+	// opening another writer closes the previous one
+	w, err = fs.GetWriter(b)
+	tmust(t, err)
+	_, err = w.Write([]byte("hello"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+	tmust(t, w.Close())
+
+	assert.EqualValues([]string{"a", "a", "sub/b"}, opens)
+	assert.EqualValues([]closeCall{
+		{"a", 6},
+		{"a", 3},
+		{"sub/b", 5},
+	}, closes)
+}
+
+func Test_FolderSinkMinFreeSpace(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	// simulate a 32MiB disk, of which only our files take space
+	const diskSize = 32 * 1024 * 1024
+	const margin = 8 * 1024 * 1024
+	var used int64
+	restore := savior.SetFreeSpaceFunc(func(path string) (int64, error) {
+		return diskSize - used, nil
+	})
+	defer restore()
+
+	fs := &savior.FolderSink{
+		Directory:    dir,
+		MinFreeSpace: margin,
+	}
+
+	entry := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "big",
+	}
+	w, err := fs.GetWriter(entry)
+	tmust(t, err)
+
+	buf := make([]byte, 256*1024)
+	for i := 0; i < 256; i++ {
+		_, err = w.Write(buf)
+		if err != nil {
+			break
+		}
+		used += int64(len(buf))
+	}
+	tmust(t, w.Close())
+
+	assert.Error(err, "should have aborted mid-extraction")
+	assert.True(errors.Cause(err) == savior.ErrNotEnoughSpace)
+	assert.True(diskSize-used >= margin, "should have left the margin free")
+	assert.True(diskSize-used < margin+8*1024*1024, "shouldn't have stopped too early")
+
+	// preallocating a file that doesn't fit should fail right away
+	err = fs.Preallocate(&savior.Entry{
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		CanonicalPath:    "other",
+		UncompressedSize: diskSize - used,
+	})
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrNotEnoughSpace)
+
+	// without a margin, nothing is checked
+	fs.MinFreeSpace = 0
+	w, err = fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write(buf)
+	assert.NoError(err)
+	tmust(t, w.Close())
+}
+
+func Test_FolderSinkCloseError(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	restore := savior.SetCloseFileFunc(func(f *os.File) error {
+		err := f.Close()
+		if err != nil {
+			return err
+		}
+		if filepath.Base(f.Name()) == "a" {
+			return errors.WithStack(syscall.ENOSPC)
+		}
+		return nil
+	})
+	defer restore()
+
+	var warnings []string
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				if lvl == "warning" {
+					warnings = append(warnings, msg)
+				}
+			},
+		},
+	}
+
+	newEntry := func(name string) *savior.Entry {
+		return &savior.Entry{
+			Kind:          savior.EntryKindFile,
+			Mode:          0644,
+			CanonicalPath: name,
+		}
+	}
+
+	w, err := fs.GetWriter(newEntry("a"))
+	tmust(t, err)
+	_, err = w.Write([]byte("data"))
+	tmust(t, err)
+
+	// the previous writer is closed by the sink
+	_, err = fs.GetWriter(newEntry("b"))
+	assert.Error(err)
+	assert.True(errors.Cause(err) == syscall.ENOSPC)
+	assert.Contains(err.Error(), "closing a")
+	assert.Empty(warnings, "close errors should be reported, not just warned about")
+
+	// the previous writer was closed by someone who ignored the error
+	w, err = fs.GetWriter(newEntry("a"))
+	tmust(t, err)
+	w.Close()
+	_, err = fs.GetWriter(newEntry("b"))
+	assert.Error(err)
+	assert.True(errors.Cause(err) == syscall.ENOSPC)
+
+	// once reported, the error doesn't stick around
+	w, err = fs.GetWriter(newEntry("b"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+}
+
+func Test_FolderSinkLineEndings(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(fs *savior.FolderSink, entry *savior.Entry, chunks ...string) string {
+		w, err := fs.GetWriter(entry)
+		tmust(t, err)
+		for _, chunk := range chunks {
+			n, err := w.Write([]byte(chunk))
+			tmust(t, err)
+			assert.EqualValues(len(chunk), n)
+		}
+		tmust(t, fs.Close())
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, entry.CanonicalPath))
+		tmust(t, err)
+		return string(bs)
+	}
+
+	textEntry := func(name string, isText bool) *savior.Entry {
+		return &savior.Entry{
+			Kind:          savior.EntryKindFile,
+			Mode:          0644,
+			CanonicalPath: name,
+			IsText:        isText,
+		}
+	}
+
+	fs := &savior.FolderSink{
+		Directory:      dir,
+		TextLineEnding: savior.LineLF,
+	}
+	// marked as text by the archive, with a CRLF split across writes
+	assert.EqualValues("a\nb\nc\rd\r", write(fs, textEntry("README", true), "a\r\nb\r", "\nc\rd\r"))
+	// recognized by extension
+	assert.EqualValues("one\ntwo\n", write(fs, textEntry("notes.TXT", false), "one\r\ntwo\r\n"))
+	// binaries are left alone
+	assert.EqualValues("\x00\r\n\xff\r\n", write(fs, textEntry("data.bin", false), "\x00\r\n\xff\r\n"))
+
+	fs.TextLineEnding = savior.LineCRLF
+	assert.EqualValues("a\r\nb\r\nc\r\n", write(fs, textEntry("README", true), "a\nb\r", "\nc\n"))
+	assert.EqualValues("\x00\n\xff\n", write(fs, textEntry("data.bin", false), "\x00\n\xff\n"))
+
+	fs.TextLineEnding = savior.LineKeep
+	assert.EqualValues("a\r\nb\n", write(fs, textEntry("README", true), "a\r\nb\n"))
+
+	// converted entries can't be resumed
+	fs.TextLineEnding = savior.LineLF
+	entry := textEntry("README", true)
+	entry.WriteOffset = 2
+	_, err = fs.GetWriter(entry)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrLineEndingResume)
+}
+
+type upperCaser struct {
+	w io.Writer
+}
+
+func (uc *upperCaser) Write(buf []byte) (int, error) {
+	return uc.w.Write(bytes.ToUpper(buf))
+}
+
+func (uc *upperCaser) Close() error {
+	return nil
+}
+
+func Test_FolderSinkTransformContent(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	var transformed []string
+	fs := &savior.FolderSink{
+		Directory: dir,
+		TransformContent: func(entry *savior.Entry, w io.Writer) (io.WriteCloser, error) {
+			transformed = append(transformed, entry.CanonicalPath)
+			return &upperCaser{w}, nil
+		},
+	}
+
+	entry := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "hello.txt",
+	}
+	assert.True(fs.NeedsRestart(entry))
+
+	w, err := fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("hello "))
+	tmust(t, err)
+	_, err = w.Write([]byte("world"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "hello.txt"))
+	tmust(t, err)
+	assert.EqualValues("HELLO WORLD", string(bs))
+	assert.EqualValues([]string{"hello.txt"}, transformed)
+	assert.EqualValues(11, entry.WriteOffset)
+
+	// transformed entries can't be resumed
+	_, err = fs.GetWriter(entry)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrTransformResume)
+
+	fs.TransformContent = nil
+	assert.False(fs.NeedsRestart(entry))
+}
+
+func Test_FolderSinkPathConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory:       dir,
+		KeepDirectories: true,
+	}
+	defer fs.Close()
+
+	tmust(t, fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "dir/",
+	}))
+	tmust(t, ioutil.WriteFile(filepath.Join(dir, "dir", "keep.txt"), []byte("keep"), 0644))
+
+	_, err = fs.GetWriter(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "dir",
+	})
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrPathConflict)
+
+	err = fs.Symlink(&savior.Entry{
+		Kind:          savior.EntryKindSymlink,
+		Mode:          0644,
+		CanonicalPath: "dir",
+	}, "elsewhere")
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrPathConflict)
+
+	_, err = os.Stat(filepath.Join(dir, "dir", "keep.txt"))
+	assert.NoError(err, "directory contents should be left alone")
+
+	// by default, directories on disk are replaced
+	fs.KeepDirectories = false
+	w, err := fs.GetWriter(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "dir",
+	})
+	tmust(t, err)
+	_, err = w.Write([]byte("file"))
+	tmust(t, err)
+	tmust(t, w.Close())
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "dir"))
+	tmust(t, err)
+	assert.EqualValues("file", string(bs))
+
+	tmust(t, fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "other/",
+	}))
+	tmust(t, fs.Symlink(&savior.Entry{
+		Kind:          savior.EntryKindSymlink,
+		Mode:          0644,
+		CanonicalPath: "other",
+	}, "dir"))
+	stats, err := os.Lstat(filepath.Join(dir, "other"))
+	tmust(t, err)
+	assert.True(stats.Mode()&os.ModeSymlink != 0)
+
+	assert.True(savior.IsDirectoryPath("dir/"))
+	assert.False(savior.IsDirectoryPath("dir"))
+}
+
+func Test_FolderSinkMkdirRaces(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+	defer fs.Close()
+
+	entry := &savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "dir",
+	}
+	dstpath := filepath.Join(dir, "dir")
+
+	assertIsDir := func() {
+		stats, err := os.Lstat(dstpath)
+		tmust(t, err)
+		assert.True(stats.IsDir())
+	}
+
+	// a file shows up right after we found nothing there
+	calls := 0
+	restore := savior.SetMkdirLstatFunc(func(path string) (os.FileInfo, error) {
+		stats, err := os.Lstat(path)
+		calls++
+		if calls == 1 {
+			tmust(t, ioutil.WriteFile(path, []byte("sneaky"), 0644))
+		}
+		return stats, err
+	})
+	tmust(t, fs.Mkdir(entry))
+	restore()
+	assertIsDir()
+
+	// the file we found is removed before we get to it
+	tmust(t, os.Remove(dstpath))
+	tmust(t, ioutil.WriteFile(dstpath, []byte("file"), 0644))
+	calls = 0
+	restore = savior.SetMkdirLstatFunc(func(path string) (os.FileInfo, error) {
+		stats, err := os.Lstat(path)
+		calls++
+		if calls == 1 {
+			tmust(t, os.Remove(path))
+		}
+		return stats, err
+	})
+	tmust(t, fs.Mkdir(entry))
+	restore()
+	assertIsDir()
+
+	// a file keeps coming back, we eventually give up
+	tmust(t, os.Remove(dstpath))
+	calls = 0
+	restore = savior.SetMkdirLstatFunc(func(path string) (os.FileInfo, error) {
+		calls++
+		tmust(t, ioutil.WriteFile(path, []byte("stubborn"), 0644))
+		return os.Lstat(path)
+	})
+	err = fs.Mkdir(entry)
+	restore()
+	assert.Error(err)
+	assert.True(calls > 1, "should have tried more than once")
+
+	// several sinks creating the same directories at once all succeed
+	tmust(t, os.RemoveAll(dstpath))
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fs := &savior.FolderSink{
+				Directory: dir,
+			}
+			errs <- fs.Mkdir(&savior.Entry{
+				Kind:          savior.EntryKindDir,
+				CanonicalPath: "dir/sub/subsub",
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(err)
+	}
+	assertIsDir()
+}
+
+func Test_FolderSinkWriteSparse(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+
+	const size = 1024 * 1024
+	segments := []savior.SparseSegment{
+		{Offset: 4096, Size: 4096},
+		{Offset: 512 * 1024, Size: 8192},
+	}
+	expected := make([]byte, size)
+	for _, seg := range segments {
+		for i := seg.Offset; i < seg.Offset+seg.Size; i++ {
+			expected[i] = byte(i)
+		}
+	}
+
+	entry := &savior.Entry{
+		CanonicalPath:    "sparse.bin",
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		UncompressedSize: size,
+	}
+
+	// write the first segment and a bit of the second, then resume
+	half := int64(512*1024 + 100)
+	w, err := fs.WriteSparse(entry, segments)
+	tmust(t, err)
+	_, err = w.Write(expected[:half])
+	tmust(t, err)
+	_, err = w.Write([]byte("garbage past the checkpoint"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+
+	entry.WriteOffset = half
+	w, err = fs.WriteSparse(entry, segments)
+	tmust(t, err)
+	_, err = w.Write(expected[half:])
+	tmust(t, err)
+	tmust(t, fs.Close())
+
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "sparse.bin"))
+	tmust(t, err)
+	assert.EqualValues(size, len(actual))
+	assert.True(bytes.Equal(expected, actual), "sparse file should have the same contents")
+}
+
+func Test_FolderSinkUnknownSize(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory:    dir,
+		Consumer:     savior.NopConsumer(),
+		StrictSizes:  true,
+		MinFreeSpace: 1,
+	}
+
+	entry := &savior.Entry{
+		CanonicalPath:    "streamed.txt",
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		UncompressedSize: savior.UnknownSize,
+	}
+
+	// the file is created, but nothing is reserved
+	tmust(t, fs.Preallocate(entry))
+	stats, err := os.Stat(filepath.Join(dir, "streamed.txt"))
+	tmust(t, err)
+	assert.EqualValues(0, stats.Size())
+
+	data := []byte("only known once it's all been read")
+	w, err := fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write(data)
+	tmust(t, err)
+	tmust(t, fs.Close())
+
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "streamed.txt"))
+	tmust(t, err)
+	assert.EqualValues(data, actual)
+
+	// sparse files can't be sized up front, they're
+	// truncated to what was written when closed
+	sparse := &savior.Entry{
+		CanonicalPath:    "sparse.bin",
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		UncompressedSize: savior.UnknownSize,
+	}
+	expected := make([]byte, 64*1024)
+	copy(expected, data)
+	w, err = fs.WriteSparse(sparse, []savior.SparseSegment{{Offset: 0, Size: int64(len(data))}})
+	tmust(t, err)
+	_, err = w.Write(expected)
+	tmust(t, err)
+	tmust(t, fs.Close())
+
+	actual, err = ioutil.ReadFile(filepath.Join(dir, "sparse.bin"))
+	tmust(t, err)
+	assert.EqualValues(len(expected), len(actual))
+	assert.True(bytes.Equal(expected, actual), "sparse file should have the same contents")
+}
+
+func Test_FolderSinkReadOnlyFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	// left over from a previous extraction, or made read-only by the user
+	dest := filepath.Join(dir, "readonly.txt")
+	tmust(t, ioutil.WriteFile(dest, []byte("old contents"), 0444))
+	tmust(t, os.Chmod(dest, 0444))
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+
+	entry := &savior.Entry{
+		CanonicalPath: "readonly.txt",
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+	}
+	tmust(t, fs.Preallocate(entry))
+
+	w, err := fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("new"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+
+	bs, err := ioutil.ReadFile(dest)
+	tmust(t, err)
+	assert.EqualValues("new", string(bs))
+
+	stats, err := os.Stat(dest)
+	tmust(t, err)
+	assert.True(stats.Mode()&0200 != 0, "file should be writable again")
+}
+
+func Test_FolderSinkModTime(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+	defer fs.Close()
+
+	modTime := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	modTimeOf := func(name string) time.Time {
+		stats, err := os.Stat(filepath.Join(dir, name))
+		tmust(t, err)
+		return stats.ModTime()
+	}
+
+	tmust(t, fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		Mode:          0755,
+		CanonicalPath: "dir",
+		ModTime:       modTime,
+	}))
+	assert.True(modTime.Equal(modTimeOf("dir")))
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		Mode:             0644,
+		CanonicalPath:    "file",
+		UncompressedSize: 10,
+		ModTime:          modTime,
+	}
+
+	// closed between sessions, halfway through
+	w, err := fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("hello"))
+	tmust(t, err)
+	tmust(t, w.Sync())
+	tmust(t, fs.Close())
+	assert.False(modTime.Equal(modTimeOf("file")), "partial files keep their actual mtime")
+
+	// resumed and completed
+	w, err = fs.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("world"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+	assert.True(modTime.Equal(modTimeOf("file")))
+
+	// entries without a ModTime are left alone
+	before := time.Now().Add(-time.Minute)
+	w, err = fs.GetWriter(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "other",
+	})
+	tmust(t, err)
+	_, err = w.Write([]byte("hi"))
+	tmust(t, err)
+	tmust(t, fs.Close())
+	assert.True(modTimeOf("other").After(before))
+}
+
+func Test_FolderSinkSpecialFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	var warnings []string
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				if lvl == "warning" {
+					warnings = append(warnings, msg)
+				}
+			},
+		},
+	}
+	defer fs.Close()
+
+	write := func(entry *savior.Entry) {
+		tmust(t, fs.Preallocate(entry))
+		w, err := fs.GetWriter(entry)
+		tmust(t, err)
+		tmust(t, w.Close())
+	}
+
+	fifo := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          os.ModeNamedPipe | 0640,
+		CanonicalPath: "fifo",
+	}
+	device := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          os.ModeDevice | os.ModeCharDevice | 0666,
+		CanonicalPath: "dev/null",
+	}
+	socket := &savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          os.ModeSocket | 0755,
+		CanonicalPath: "socket",
+	}
+
+	// skipped by default
+	for _, entry := range []*savior.Entry{fifo, device, socket} {
+		write(entry)
+		_, err = os.Lstat(filepath.Join(dir, entry.CanonicalPath))
+		assert.True(os.IsNotExist(err), "%s should be skipped", entry.CanonicalPath)
+	}
+	assert.Len(warnings, 3)
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	fs.AllowSpecialFiles = true
+	warnings = nil
+
+	// over a regular file, then again over the pipe itself, as if resuming
+	tmust(t, ioutil.WriteFile(filepath.Join(dir, "fifo"), []byte("stale"), 0644))
+	for i := 0; i < 2; i++ {
+		write(fifo)
+		stats, err := os.Lstat(filepath.Join(dir, "fifo"))
+		tmust(t, err)
+		assert.True(stats.Mode()&os.ModeNamedPipe != 0)
+		assert.EqualValues(0640, stats.Mode().Perm())
+	}
+
+	// devices and sockets are still skipped
+	write(device)
+	write(socket)
+	assert.Len(warnings, 2)
+	_, err = os.Lstat(filepath.Join(dir, "dev", "null"))
+	assert.True(os.IsNotExist(err))
+}
+
+func Test_FolderSinkModePolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions on windows")
+	}
+	assert := assert.New(t)
+
+	extract := func(fs *savior.FolderSink) {
+		tmust(t, fs.Mkdir(&savior.Entry{
+			Kind:          savior.EntryKindDir,
+			Mode:          os.ModeDir | 0777,
+			CanonicalPath: "dir",
+		}))
+		for _, name := range []string{"dir/sub/file", "dir/exec"} {
+			w, err := fs.GetWriter(&savior.Entry{
+				Kind:          savior.EntryKindFile,
+				Mode:          0755,
+				CanonicalPath: name,
+			})
+			tmust(t, err)
+			_, err = w.Write([]byte("hi"))
+			tmust(t, err)
+		}
+		tmust(t, fs.Close())
+	}
+
+	modeOf := func(dir string, name string) os.FileMode {
+		stats, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		tmust(t, err)
+		return stats.Mode().Perm()
+	}
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	// a previous extraction left things world-readable
+	extract(&savior.FolderSink{Directory: dir})
+	assert.NotZero(modeOf(dir, "dir/exec") & 0044)
+
+	extract(&savior.FolderSink{
+		Directory: dir,
+		ModePolicy: savior.ModePolicyFunc(func(entry *savior.Entry) os.FileMode {
+			if entry.Kind == savior.EntryKindDir {
+				return 0700
+			}
+			return 0600
+		}),
+	})
+	assert.EqualValues(0700, modeOf(dir, "dir"))
+	assert.EqualValues(0600, modeOf(dir, "dir/sub/file"))
+	assert.EqualValues(0600, modeOf(dir, "dir/exec"))
+
+	extract(&savior.FolderSink{
+		Directory: dir,
+		Umask:     0027,
+	})
+	assert.EqualValues(0750, modeOf(dir, "dir"))
+	assert.EqualValues(0750, modeOf(dir, "dir/sub/file"))
+	assert.EqualValues(0750, modeOf(dir, "dir/exec"))
+
+	// parent directories that aren't in the archive
+	other, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(other)
+	extract(&savior.FolderSink{
+		Directory: other,
+		Umask:     0077,
+	})
+	assert.EqualValues(0700, modeOf(other, "dir/sub"))
+	assert.EqualValues(0700, modeOf(other, "dir/sub/file"))
+}
+
+func Test_FolderSinkUnsafePaths(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "foldersink-test-outside")
+	tmust(t, err)
+	defer os.RemoveAll(outside)
+
+	dest := filepath.Join(dir, "dest")
+	fs := &savior.FolderSink{
+		Directory: dest,
+	}
+
+	file := func(name string) *savior.Entry {
+		return &savior.Entry{
+			Kind:          savior.EntryKindFile,
+			CanonicalPath: name,
+		}
+	}
+	symlink := func(name string) *savior.Entry {
+		return &savior.Entry{
+			Kind:          savior.EntryKindSymlink,
+			CanonicalPath: name,
+		}
+	}
+	assertUnsafe := func(err error) {
+		t.Helper()
+		assert.Error(err)
+		assert.EqualValues(savior.ErrUnsafePath, errors.Cause(err))
+	}
+
+	_, err = fs.GetWriter(file("../evil"))
+	assertUnsafe(err)
+	_, err = fs.GetWriter(file("dir/../../evil"))
+	assertUnsafe(err)
+	assertUnsafe(fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "../evil",
+	}))
+	assertUnsafe(fs.Symlink(symlink("../evil"), "dest"))
+	_, err = os.Lstat(filepath.Join(dir, "evil"))
+	assert.True(os.IsNotExist(err))
+
+	assertUnsafe(fs.Symlink(symlink("abs"), outside))
+	assertUnsafe(fs.Symlink(symlink("dir/climb"), "../../evil"))
+	tmust(t, fs.Symlink(symlink("dir/sibling"), "../other"))
+	tmust(t, fs.Symlink(symlink("self"), "."))
+
+	if runtime.GOOS == "windows" {
+		// symlinks are written as files
+		return
+	}
+
+	// targets are resolved from where the link really ends up
+	tmust(t, fs.Symlink(symlink("a/b/c/s"), "../../.."))
+	assertUnsafe(fs.Symlink(symlink("a/b/c/s/t"), "../../.."))
+	_, err = os.Lstat(filepath.Join(dest, "t"))
+	assert.True(os.IsNotExist(err))
+	tmust(t, fs.Symlink(symlink("a/b/c/s/u"), "a/b"))
+
+	// and so are symlinks on disk the target goes through
+	tmust(t, fs.Symlink(symlink("x/here"), "."))
+	assertUnsafe(fs.Symlink(symlink("x/y"), "here/../.."))
+
+	// symlinks out of the destination can be allowed, but entries
+	// are never written through them
+	fs.AllowUnsafeSymlinks = true
+	tmust(t, fs.Symlink(symlink("out"), outside))
+	_, err = fs.GetWriter(file("out/evil"))
+	assertUnsafe(err)
+	assertUnsafe(fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "out/evil",
+	}))
+	assertUnsafe(fs.Symlink(symlink("out/evil"), "target"))
+	_, err = fs.GetWriter(file("out/sub/dir/evil"))
+	assertUnsafe(err)
+
+	entries, err := ioutil.ReadDir(outside)
+	tmust(t, err)
+	assert.Empty(entries)
+
+	// writing over the symlink itself is fine
+	w, err := fs.GetWriter(file("out"))
+	tmust(t, err)
+	tmust(t, w.Close())
+}
+
+func Test_FolderSinkRemoveEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "folder-sink-remove")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+	defer fs.Close()
+
+	dirEntry := &savior.Entry{Kind: savior.EntryKindDir, CanonicalPath: "dir"}
+	tmust(t, fs.Mkdir(dirEntry))
+
+	// the writer is still open
+	file := &savior.Entry{Kind: savior.EntryKindFile, CanonicalPath: "dir/partial.bin", UncompressedSize: 1024}
+	w, err := fs.GetWriter(file)
+	tmust(t, err)
+	_, err = w.Write(make([]byte, 512))
+	tmust(t, err)
+	tmust(t, fs.RemoveEntry(file))
+	_, err = os.Lstat(filepath.Join(dir, "dir", "partial.bin"))
+	assert.True(os.IsNotExist(err))
+
+	// removing what was never written is fine
+	tmust(t, fs.RemoveEntry(&savior.Entry{Kind: savior.EntryKindFile, CanonicalPath: "dir/missing.bin"}))
+
+	// directories are left alone
+	tmust(t, fs.RemoveEntry(dirEntry))
+	stats, err := os.Stat(filepath.Join(dir, "dir"))
+	tmust(t, err)
+	assert.True(stats.IsDir())
+
+	assert.Error(fs.RemoveEntry(&savior.Entry{Kind: savior.EntryKindFile, CanonicalPath: "../evil"}))
+}
+
+func Test_FolderSinkNukeRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	transient := syscall.EBUSY
+	if runtime.GOOS == "windows" {
+		// ERROR_SHARING_VIOLATION
+		transient = syscall.Errno(32)
+	}
+
+	nuke := func(remove func(calls int, path string) error) (int, []string, error) {
+		dir, err := ioutil.TempDir("", "foldersink-nuke")
+		tmust(t, err)
+		defer os.RemoveAll(dir)
+
+		calls := 0
+		restore := savior.SetNukeFuncs(func(path string) error {
+			calls++
+			return remove(calls, path)
+		}, time.Millisecond)
+		defer restore()
+
+		var warnings []string
+		fs := &savior.FolderSink{
+			Directory: dir,
+			Consumer: &state.Consumer{
+				OnMessage: func(lvl string, msg string) {
+					if lvl == "warning" {
+						warnings = append(warnings, msg)
+					}
+				},
+			},
+		}
+		tmust(t, fs.Mkdir(&savior.Entry{Kind: savior.EntryKindDir, CanonicalPath: "sub", Mode: 0500}))
+		tmust(t, ioutil.WriteFile(filepath.Join(dir, "sub", "locked.txt"), []byte("locked"), 0400))
+
+		err = fs.Nuke()
+		if err == nil {
+			_, statErr := os.Stat(dir)
+			assert.True(os.IsNotExist(statErr), "directory should be gone")
+		}
+		return calls, warnings, err
+	}
+
+	// transient errors are waited out
+	calls, warnings, err := nuke(func(calls int, path string) error {
+		if calls <= 2 {
+			return &os.PathError{Op: "unlinkat", Path: path, Err: transient}
+		}
+		return os.RemoveAll(path)
+	})
+	tmust(t, err)
+	assert.EqualValues(3, calls)
+	assert.Len(warnings, 2)
+	assert.Contains(warnings[0], "retrying")
+
+	// other errors get a last chance right away, once everything
+	// is writable
+	calls, warnings, err = nuke(func(calls int, path string) error {
+		if runtime.GOOS != "windows" {
+			stats, err := os.Stat(filepath.Join(path, "sub", "locked.txt"))
+			if err != nil {
+				return err
+			}
+			if stats.Mode().Perm() != savior.LuckyMode {
+				return &os.PathError{Op: "unlinkat", Path: path, Err: syscall.EACCES}
+			}
+		} else if calls == 1 {
+			return &os.PathError{Op: "unlinkat", Path: path, Err: syscall.EACCES}
+		}
+		return os.RemoveAll(path)
+	})
+	tmust(t, err)
+	assert.EqualValues(2, calls)
+	assert.Len(warnings, 2)
+	assert.Contains(warnings[1], "writable")
+
+	// the last error comes out once there's no attempts left
+	calls, _, err = nuke(func(calls int, path string) error {
+		return &os.PathError{Op: "unlinkat", Path: path, Err: transient}
+	})
+	assert.Error(err)
+	assert.EqualValues(5, calls)
+	assert.EqualValues(transient, errors.Cause(err).(*os.PathError).Err)
+}
+
+func Test_FolderSinkStagingPath(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory:    dir,
+		GroupCommits: true,
+	}
+	defer fs.Close()
+
+	for _, name := range []string{".savior-staging/file", ".SAVIOR-Staging/file", "./.savior-staging/dir/file"} {
+		_, err = fs.GetWriter(&savior.Entry{
+			Kind:          savior.EntryKindFile,
+			Mode:          0644,
+			CanonicalPath: name,
+		})
+		assert.True(errors.Cause(err) == savior.ErrUnsafePath, name)
+	}
+
+	err = fs.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: ".savior-staging/",
+	})
+	assert.True(errors.Cause(err) == savior.ErrUnsafePath)
+
+	// nor through a symlink
+	tmust(t, fs.Symlink(&savior.Entry{
+		Kind:          savior.EntryKindSymlink,
+		Mode:          0644,
+		CanonicalPath: "link",
+	}, ".savior-staging"))
+	tmust(t, os.MkdirAll(filepath.Join(dir, ".savior-staging"), 0755))
+	_, err = fs.GetWriter(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: "link/file",
+	})
+	assert.True(errors.Cause(err) == savior.ErrUnsafePath)
+
+	// it's just a name without GroupCommits
+	fs.GroupCommits = false
+	tmust(t, os.RemoveAll(filepath.Join(dir, ".savior-staging")))
+	w, err := fs.GetWriter(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		Mode:          0644,
+		CanonicalPath: ".savior-staging/file",
+	})
+	tmust(t, err)
+	tmust(t, w.Close())
+}
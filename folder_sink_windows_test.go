@@ -0,0 +1,79 @@
+//+build windows
+
+package savior_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FolderSinkWindowsSymlinks(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "foldersink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory:       dir,
+		Consumer:        savior.NopConsumer(),
+		WindowsSymlinks: true,
+	}
+
+	// created before their targets, like archives sometimes do
+	tmust(t, fs.Symlink(&savior.Entry{
+		CanonicalPath: "file-link",
+		Kind:          savior.EntryKindSymlink,
+	}, "target.txt"))
+	tmust(t, fs.Symlink(&savior.Entry{
+		CanonicalPath: "dir-link",
+		Kind:          savior.EntryKindSymlink,
+		LinkToDir:     true,
+	}, "target"))
+
+	w, err := fs.GetWriter(&savior.Entry{
+		CanonicalPath: "target.txt",
+		Kind:          savior.EntryKindFile,
+	})
+	tmust(t, err)
+	_, err = w.Write([]byte("hello"))
+	tmust(t, err)
+	tmust(t, fs.Mkdir(&savior.Entry{
+		CanonicalPath: "target/",
+		Kind:          savior.EntryKindDir,
+	}))
+	tmust(t, ioutil.WriteFile(filepath.Join(dir, "target", "inside.txt"), []byte("inside"), 0644))
+	tmust(t, fs.Close())
+
+	stats, err := os.Lstat(filepath.Join(dir, "file-link"))
+	tmust(t, err)
+	if stats.Mode()&os.ModeSymlink == 0 {
+		// not allowed to create symlinks, they should be text files
+		t.Logf("process can't create symlinks, checking the fallback")
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "file-link"))
+		tmust(t, err)
+		assert.EqualValues("target.txt", string(bs))
+
+		bs, err = ioutil.ReadFile(filepath.Join(dir, "dir-link"))
+		tmust(t, err)
+		assert.EqualValues("target", string(bs))
+		return
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "file-link"))
+	tmust(t, err)
+	assert.EqualValues("hello", string(bs))
+
+	stats, err = os.Lstat(filepath.Join(dir, "dir-link"))
+	tmust(t, err)
+	assert.True(stats.Mode()&os.ModeSymlink != 0, "should be a symlink")
+
+	bs, err = ioutil.ReadFile(filepath.Join(dir, "dir-link", "inside.txt"))
+	tmust(t, err)
+	assert.EqualValues("inside", string(bs))
+}
@@ -0,0 +1,120 @@
+package savior
+
+import "github.com/pkg/errors"
+
+// forwardingSink passes everything to the inner sink, including the
+// optional sink interfaces, doing what a sink without them would when
+// the inner sink doesn't implement them. Sinks that wrap another one
+// embed it, and override the methods they need to hook into, calling
+// the forwardingSink's from theirs.
+type forwardingSink struct {
+	inner Sink
+}
+
+var _ Sink = (*forwardingSink)(nil)
+var _ ResumeOffsetter = (*forwardingSink)(nil)
+var _ HardlinkSink = (*forwardingSink)(nil)
+var _ EntryRestarter = (*forwardingSink)(nil)
+var _ PathSink = (*forwardingSink)(nil)
+var _ SparseSink = (*forwardingSink)(nil)
+var _ ReflinkSink = (*forwardingSink)(nil)
+var _ GroupCommitter = (*forwardingSink)(nil)
+var _ EntryRemover = (*forwardingSink)(nil)
+
+func (fs *forwardingSink) Mkdir(entry *Entry) error {
+	return fs.inner.Mkdir(entry)
+}
+
+func (fs *forwardingSink) Symlink(entry *Entry, linkname string) error {
+	return fs.inner.Symlink(entry, linkname)
+}
+
+func (fs *forwardingSink) GetWriter(entry *Entry) (EntryWriter, error) {
+	return fs.inner.GetWriter(entry)
+}
+
+// WriteSparse is passed to the inner sink, if it's a SparseSink,
+// otherwise holes are written out by its GetWriter
+func (fs *forwardingSink) WriteSparse(entry *Entry, segments []SparseSegment) (EntryWriter, error) {
+	if sps, ok := fs.inner.(SparseSink); ok {
+		return sps.WriteSparse(entry, segments)
+	}
+	return fs.inner.GetWriter(entry)
+}
+
+// Hardlink is passed to the inner sink, if it can make hardlinks
+func (fs *forwardingSink) Hardlink(entry *Entry, target string) error {
+	hs, ok := fs.inner.(HardlinkSink)
+	if !ok {
+		return errors.Wrapf(ErrHardlinkUnsupported, "%s", entry.CanonicalPath)
+	}
+	return hs.Hardlink(entry, target)
+}
+
+// ResumeOffset asks the inner sink, if it can tell
+func (fs *forwardingSink) ResumeOffset(entry *Entry) (int64, error) {
+	if ro, ok := fs.inner.(ResumeOffsetter); ok {
+		return ro.ResumeOffset(entry)
+	}
+	return entry.WriteOffset, nil
+}
+
+// NeedsRestart asks the inner sink, if it can tell
+func (fs *forwardingSink) NeedsRestart(entry *Entry) bool {
+	if er, ok := fs.inner.(EntryRestarter); ok {
+		return er.NeedsRestart(entry)
+	}
+	return false
+}
+
+// DestPath asks the inner sink, if it's a PathSink
+func (fs *forwardingSink) DestPath(entry *Entry) string {
+	p, _ := DestPathOf(fs.inner, entry)
+	return p
+}
+
+// ReflinkSource asks the inner sink, if it's a ReflinkSink
+func (fs *forwardingSink) ReflinkSource(entry *Entry) (string, bool) {
+	if rs, ok := fs.inner.(ReflinkSink); ok {
+		return rs.ReflinkSource(entry)
+	}
+	return "", false
+}
+
+// Reflink is passed to the inner sink, if it's a ReflinkSink
+func (fs *forwardingSink) Reflink(entry *Entry, source string) (bool, error) {
+	if rs, ok := fs.inner.(ReflinkSink); ok {
+		return rs.Reflink(entry, source)
+	}
+	return false, nil
+}
+
+// CommitGroup is passed to the inner sink, and fails with
+// ErrGroupsUnsupported if it's not a GroupCommitter
+func (fs *forwardingSink) CommitGroup() error {
+	gc, ok := fs.inner.(GroupCommitter)
+	if !ok {
+		return errors.WithStack(ErrGroupsUnsupported)
+	}
+	return gc.CommitGroup()
+}
+
+// RemoveEntry is passed to the inner sink, if it can remove entries
+func (fs *forwardingSink) RemoveEntry(entry *Entry) error {
+	if er, ok := fs.inner.(EntryRemover); ok {
+		return er.RemoveEntry(entry)
+	}
+	return nil
+}
+
+func (fs *forwardingSink) Preallocate(entry *Entry) error {
+	return fs.inner.Preallocate(entry)
+}
+
+func (fs *forwardingSink) Nuke() error {
+	return fs.inner.Nuke()
+}
+
+func (fs *forwardingSink) Close() error {
+	return fs.inner.Close()
+}
@@ -0,0 +1,16 @@
+//+build !linux,!darwin,!freebsd,!dragonfly,!openbsd,!netbsd,!solaris,!windows
+
+package savior
+
+import "math"
+
+func diskFreeSpace(path string) (int64, error) {
+	// can't tell, so there's always room
+	return math.MaxInt64, nil
+}
+
+// isNoSpace returns false: there's no telling a full disk apart
+// from other errors here
+func isNoSpace(err error) bool {
+	return false
+}
@@ -0,0 +1,27 @@
+//+build netbsd solaris
+
+package savior
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+func diskFreeSpace(path string) (int64, error) {
+	var st unix.Statvfs_t
+	err := unix.Statvfs(path, &st)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	// Bavail is what's available to unprivileged users,
+	// in fragments rather than blocks
+	return int64(st.Bavail) * int64(st.Frsize), nil
+}
+
+// isNoSpace returns true if err says the disk is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
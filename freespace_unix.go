@@ -0,0 +1,26 @@
+//+build linux darwin freebsd dragonfly
+
+package savior
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+func diskFreeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	err := unix.Statfs(path, &st)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	// Bavail is what's available to unprivileged users
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// isNoSpace returns true if err says the disk is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
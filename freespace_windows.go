@@ -0,0 +1,34 @@
+//+build windows
+
+package savior
+
+import (
+	"syscall"
+
+	"github.com/itchio/ox/syscallex"
+	"github.com/pkg/errors"
+)
+
+func diskFreeSpace(path string) (int64, error) {
+	path16, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	dfs, err := syscallex.GetDiskFreeSpaceEx(path16)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return int64(dfs.FreeBytesAvailable), nil
+}
+
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// isNoSpace returns true if err says the disk is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull) || errors.Is(err, syscall.ENOSPC)
+}
@@ -13,11 +13,11 @@ require (
 	github.com/itchio/kompress v0.0.0-20200301155538-5c2eecce9e51
 	github.com/itchio/ox v0.0.0-20200301160301-4e131878ba64
 	github.com/itchio/randsource v0.0.0-20190703104731-3f6d22f91927
-	github.com/klauspost/compress v1.10.9 // indirect
+	github.com/klauspost/compress v1.10.9
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/net v0.0.0-20200602114024-627f9648deb9 // indirect
-	golang.org/x/sys v0.0.0-20200615200032-f1bc736245b1 // indirect
-	golang.org/x/text v0.3.3 // indirect
+	golang.org/x/sys v0.0.0-20200615200032-f1bc736245b1
+	golang.org/x/text v0.3.3
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
 )
@@ -1,7 +1,6 @@
 package gzipsource
 
 import (
-	"encoding/gob"
 	"fmt"
 
 	"github.com/itchio/kompress/flate"
@@ -10,6 +9,11 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrChecksum is returned by Read when the decompressed data doesn't match
+// the CRC-32 or size in the gzip trailer. Since the CRC-32 computed so far
+// is part of checkpoints, it's verified even when resuming mid-stream.
+var ErrChecksum = gzip.ErrChecksum
+
 type gzipSource struct {
 	// input
 	source savior.Source
@@ -32,6 +36,13 @@ type GzipSourceCheckpoint struct {
 	GzipCheckpoint   *gzip.Checkpoint
 }
 
+var _ savior.PortableChecker = (*GzipSourceCheckpoint)(nil)
+
+// Portable returns true if the wrapped source checkpoint is portable
+func (gsc *GzipSourceCheckpoint) Portable() bool {
+	return gsc.SourceCheckpoint.Portable()
+}
+
 var _ savior.Source = (*gzipSource)(nil)
 
 func New(source savior.Source) *gzipSource {
@@ -145,7 +156,8 @@ func (gs *gzipSource) Read(buf []byte) (int, error) {
 			savior.Debugf("gzipsource: saving, gzip rOffset = %d, sourceCheckpoint.Offset = %d", gzipCheckpoint.Roffset, gs.sourceCheckpoint.Offset)
 
 			checkpoint := &savior.SourceCheckpoint{
-				Offset: gs.offset,
+				Offset:       gzipCheckpoint.Roffset,
+				OutputOffset: gs.offset,
 				Data: &GzipSourceCheckpoint{
 					Offset:           gs.offset,
 					GzipCheckpoint:   gzipCheckpoint,
@@ -185,10 +197,20 @@ func (gs *gzipSource) Progress() float64 {
 	return gs.source.Progress()
 }
 
+// Close releases the decompressor and closes the underlying source
 func (gs *gzipSource) Close() error {
-	return gs.sr.Close()
+	var err error
+	if gs.sr != nil {
+		err = gs.sr.Close()
+	}
+
+	sourceErr := gs.source.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return sourceErr
 }
 
 func init() {
-	gob.Register(&GzipSourceCheckpoint{})
+	savior.RegisterCheckpointData("gzipsource.GzipSourceCheckpoint", &GzipSourceCheckpoint{})
 }
@@ -1,6 +1,9 @@
 package gzipsource_test
 
 import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
 	"log"
 	"testing"
 
@@ -44,3 +47,71 @@ func Test_Checkpoints(t *testing.T) {
 
 	checker.RunSourceTest(t, gs, reference)
 }
+
+func Test_Checksum(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+	compressed, err := checker.GzipCompress(reference)
+	assert.NoError(t, err)
+
+	// the trailer is the CRC-32, then the size
+	corrupted := append([]byte{}, compressed...)
+	corrupted[len(corrupted)-8] ^= 0xff
+
+	readAll := func(gs savior.Source) error {
+		_, err := ioutil.ReadAll(gs)
+		return err
+	}
+
+	// resuming may modify the checkpoint, so each resume gets a copy,
+	// as if it had been saved to disk
+	roundtrip := func(c *savior.SourceCheckpoint) *savior.SourceCheckpoint {
+		buf := new(bytes.Buffer)
+		assert.NoError(t, gob.NewEncoder(buf).Encode(c))
+		var res savior.SourceCheckpoint
+		assert.NoError(t, gob.NewDecoder(buf).Decode(&res))
+		return &res
+	}
+
+	{
+		gs := gzipsource.New(seeksource.FromBytes(corrupted))
+		_, err := gs.Resume(nil)
+		assert.NoError(t, err)
+		err = readAll(gs)
+		assert.True(t, errors.Cause(err) == gzipsource.ErrChecksum)
+	}
+
+	{
+		// stop mid-stream...
+		var c *savior.SourceCheckpoint
+		gs := gzipsource.New(seeksource.FromBytes(corrupted))
+		gs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+			OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+				c = checkpoint
+				return nil
+			},
+		})
+		_, err := gs.Resume(nil)
+		assert.NoError(t, err)
+		buf := make([]byte, 16*1024)
+		for c == nil || c.OutputOffset == 0 {
+			gs.WantSave()
+			_, err = gs.Read(buf)
+			if !assert.NoError(t, err) {
+				return
+			}
+		}
+		assert.True(t, c.OutputOffset > 0)
+
+		// ...the rest of the stream is still checked against the trailer
+		gs = gzipsource.New(seeksource.FromBytes(corrupted))
+		_, err = gs.Resume(roundtrip(c))
+		assert.NoError(t, err)
+		err = readAll(gs)
+		assert.True(t, errors.Cause(err) == gzipsource.ErrChecksum)
+
+		gs = gzipsource.New(seeksource.FromBytes(compressed))
+		_, err = gs.Resume(roundtrip(c))
+		assert.NoError(t, err)
+		assert.NoError(t, readAll(gs))
+	}
+}
@@ -0,0 +1,220 @@
+package savior
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrHashMismatch is returned by HashValidatingSink when the contents of
+// a file don't match its expected hash
+var ErrHashMismatch = errors.New("entry contents don't match the expected hash")
+
+// ErrHashResume is returned by HashValidatingSink when asked to resume
+// writing a file it can't read back, see NeedsRestart
+var ErrHashResume = errors.New("can't resume hashing an entry whose start can't be read back")
+
+// HashValidatingSink wraps another sink and checks the SHA-256 of files
+// written to it against a manifest, as they're written: the write
+// that completes a file (or closing its writer) fails with ErrHashMismatch if
+// it doesn't match.
+// Files that aren't in the manifest (and directories, symlinks, hardlinks)
+// aren't checked. All the actual work is delegated to the inner sink.
+//
+// Files of unknown size (see Entry.SizeKnown) are only checked when their
+// writer is closed, since there's no telling when they're complete: like
+// with DiscardSink, only close the sink once extraction is done.
+//
+// When a file is resumed mid-way, the bytes already written are read back
+// and hashed again, if the inner sink is a PathSink. Otherwise, files with
+// an expected hash are written from the start (see NeedsRestart), or fail
+// with ErrHashResume for extractors that don't check.
+type HashValidatingSink struct {
+	inner Sink
+	// hex-encoded SHA-256, by canonical path
+	hashes map[string]string
+}
+
+var _ Sink = (*HashValidatingSink)(nil)
+var _ EntryRestarter = (*HashValidatingSink)(nil)
+var _ ResumeOffsetter = (*HashValidatingSink)(nil)
+var _ HardlinkSink = (*HashValidatingSink)(nil)
+
+// NewHashValidatingSink returns a sink that delegates to inner, and checks
+// files against `hashes`, which maps canonical paths to hex-encoded SHA-256
+func NewHashValidatingSink(inner Sink, hashes map[string]string) *HashValidatingSink {
+	hvs := &HashValidatingSink{
+		inner:  inner,
+		hashes: make(map[string]string, len(hashes)),
+	}
+	for p, h := range hashes {
+		hvs.hashes[p] = strings.ToLower(h)
+	}
+	return hvs
+}
+
+func (hvs *HashValidatingSink) Mkdir(entry *Entry) error {
+	return hvs.inner.Mkdir(entry)
+}
+
+func (hvs *HashValidatingSink) Symlink(entry *Entry, linkname string) error {
+	return hvs.inner.Symlink(entry, linkname)
+}
+
+// Hardlink is passed to the inner sink, if it can make hardlinks
+func (hvs *HashValidatingSink) Hardlink(entry *Entry, target string) error {
+	hs, ok := hvs.inner.(HardlinkSink)
+	if !ok {
+		return errors.Wrapf(ErrHardlinkUnsupported, "%s", entry.CanonicalPath)
+	}
+	return hs.Hardlink(entry, target)
+}
+
+// NeedsRestart returns true for files with an expected hash, unless the
+// inner sink is a PathSink (whose files can be read back), and for anything
+// the inner sink can't resume.
+func (hvs *HashValidatingSink) NeedsRestart(entry *Entry) bool {
+	if er, ok := hvs.inner.(EntryRestarter); ok && er.NeedsRestart(entry) {
+		return true
+	}
+	if _, ok := hvs.hashes[entry.CanonicalPath]; !ok {
+		return false
+	}
+	_, ok := DestPathOf(hvs.inner, entry)
+	return !ok
+}
+
+func (hvs *HashValidatingSink) GetWriter(entry *Entry) (EntryWriter, error) {
+	expected, ok := hvs.hashes[entry.CanonicalPath]
+	if !ok {
+		return hvs.inner.GetWriter(entry)
+	}
+
+	h := sha256.New()
+	if entry.WriteOffset > 0 {
+		err := hvs.hashWritten(entry, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := hvs.inner.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hashValidatingEntryWriter{
+		w:        w,
+		entry:    entry,
+		h:        h,
+		expected: expected,
+		offset:   entry.WriteOffset,
+	}, nil
+}
+
+// hashWritten hashes the first WriteOffset bytes of the entry, as
+// written by the inner sink
+func (hvs *HashValidatingSink) hashWritten(entry *Entry, h hash.Hash) error {
+	destPath, ok := DestPathOf(hvs.inner, entry)
+	if !ok {
+		return errors.Wrapf(ErrHashResume, "%s", entry.CanonicalPath)
+	}
+
+	f, err := os.Open(destPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(h, f, entry.WriteOffset)
+	if err != nil {
+		return errors.Wrapf(err, "%s: hashing the first %d bytes", entry.CanonicalPath, entry.WriteOffset)
+	}
+	return nil
+}
+
+// ResumeOffset asks the inner sink, if it can tell
+func (hvs *HashValidatingSink) ResumeOffset(entry *Entry) (int64, error) {
+	if ro, ok := hvs.inner.(ResumeOffsetter); ok {
+		return ro.ResumeOffset(entry)
+	}
+	return entry.WriteOffset, nil
+}
+
+func (hvs *HashValidatingSink) Preallocate(entry *Entry) error {
+	return hvs.inner.Preallocate(entry)
+}
+
+func (hvs *HashValidatingSink) Nuke() error {
+	return hvs.inner.Nuke()
+}
+
+func (hvs *HashValidatingSink) Close() error {
+	return hvs.inner.Close()
+}
+
+type hashValidatingEntryWriter struct {
+	w        EntryWriter
+	entry    *Entry
+	h        hash.Hash
+	expected string
+
+	// how much of the file was hashed
+	offset  int64
+	checked bool
+}
+
+var _ EntryWriter = (*hashValidatingEntryWriter)(nil)
+
+// Write checks the hash as soon as the file is complete, since
+// extractors don't necessarily close writers (or check the error)
+func (hvew *hashValidatingEntryWriter) Write(buf []byte) (int, error) {
+	n, err := hvew.w.Write(buf)
+	hvew.h.Write(buf[:n])
+	hvew.offset += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	return n, hvew.check(false)
+}
+
+// Close closes the inner writer, then checks the hash if the
+// file is complete (and not just closed between sessions)
+func (hvew *hashValidatingEntryWriter) Close() error {
+	err := hvew.w.Close()
+	if err != nil {
+		return err
+	}
+	return hvew.check(true)
+}
+
+// check compares hashes once the whole file was written
+func (hvew *hashValidatingEntryWriter) check(closing bool) error {
+	if hvew.checked {
+		return nil
+	}
+	if hvew.entry.SizeKnown() {
+		if hvew.offset < hvew.entry.UncompressedSize {
+			return nil
+		}
+	} else if !closing {
+		return nil
+	}
+	hvew.checked = true
+
+	actual := hex.EncodeToString(hvew.h.Sum(nil))
+	if actual != hvew.expected {
+		return errors.Wrapf(ErrHashMismatch, "%s: expected sha256 %s, got %s", hvew.entry.CanonicalPath, hvew.expected, actual)
+	}
+	return nil
+}
+
+func (hvew *hashValidatingEntryWriter) Sync() error {
+	return hvew.w.Sync()
+}
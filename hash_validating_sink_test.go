@@ -0,0 +1,182 @@
+package savior_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func Test_HashValidatingSink(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte("hello there")
+	hvs := savior.NewHashValidatingSink(savior.NewMemorySink(), map[string]string{
+		"good": sha256Hex(data),
+		"bad":  sha256Hex([]byte("general kenobi")),
+	})
+
+	good := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "good",
+		UncompressedSize: int64(len(data)),
+	}
+	// memory sinks can't be read back
+	assert.True(hvs.NeedsRestart(good))
+	assert.False(hvs.NeedsRestart(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		CanonicalPath: "unlisted",
+	}))
+
+	w, err := hvs.GetWriter(good)
+	tmust(t, err)
+	_, err = w.Write(data[:5])
+	tmust(t, err)
+	_, err = w.Write(data[5:])
+	tmust(t, err)
+	tmust(t, w.Close())
+
+	bad := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "bad",
+		UncompressedSize: int64(len(data)),
+	}
+	w, err = hvs.GetWriter(bad)
+	tmust(t, err)
+	_, err = w.Write(data)
+	assert.Error(err)
+	assert.EqualValues(savior.ErrHashMismatch, errors.Cause(err))
+	assert.Contains(err.Error(), "bad")
+
+	bad.WriteOffset = 5
+	_, err = hvs.GetWriter(bad)
+	assert.EqualValues(savior.ErrHashResume, errors.Cause(err))
+}
+
+func Test_HashValidatingSinkExtractWithResumes(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	hashes := make(map[string]string)
+	for _, item := range sink.Items {
+		if item.Entry.Kind == savior.EntryKindFile {
+			hashes[item.Entry.CanonicalPath] = sha256Hex(item.Data)
+		}
+	}
+
+	extract := func(hashes map[string]string) (int, error) {
+		dir, err := ioutil.TempDir("", "hashvalidatingsink-test")
+		tmust(t, err)
+		defer os.RemoveAll(dir)
+
+		hvs := savior.NewHashValidatingSink(&savior.FolderSink{
+			Directory: dir,
+		}, hashes)
+
+		var c *savior.ExtractorCheckpoint
+		sc := checker.NewTestSaveConsumer(512*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if c != nil && checkpoint.Progress <= c.Progress {
+				return savior.AfterSaveContinue, nil
+			}
+			buf, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveContinue, err
+			}
+			c, err = savior.UnmarshalCheckpoint(buf)
+			if err != nil {
+				return savior.AfterSaveContinue, err
+			}
+			return savior.AfterSaveStop, nil
+		})
+
+		numResumes := 0
+		for {
+			if numResumes > 128 {
+				t.Fatal("too many resumes, something must be wrong")
+			}
+
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			tmust(t, err)
+			ex.SetSaveConsumer(sc)
+
+			_, err = ex.Resume(c, hvs)
+			if errors.Cause(err) == savior.ErrStop {
+				numResumes++
+				continue
+			}
+			hvs.Close()
+			return numResumes, err
+		}
+	}
+
+	numResumes, err := extract(hashes)
+	tmust(t, err)
+	assert.True(numResumes > 0)
+
+	// corrupt the expected hash of the largest file, so it
+	// fails after a few resumes
+	var largest *checker.Item
+	for _, item := range sink.Items {
+		if item.Entry.Kind == savior.EntryKindFile && (largest == nil || len(item.Data) > len(largest.Data)) {
+			largest = item
+		}
+	}
+	hashes[largest.Entry.CanonicalPath] = sha256Hex([]byte("nope"))
+
+	_, err = extract(hashes)
+	assert.Error(err)
+	assert.EqualValues(savior.ErrHashMismatch, errors.Cause(err))
+	assert.Contains(err.Error(), largest.Entry.CanonicalPath)
+}
+
+func Test_HashValidatingSinkUnknownSize(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte("hello there")
+	hvs := savior.NewHashValidatingSink(savior.NewMemorySink(), map[string]string{
+		"good": sha256Hex(data),
+		"bad":  sha256Hex([]byte("general kenobi")),
+	})
+
+	// no telling when they're complete, so they're checked when closed
+	good := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "good",
+		UncompressedSize: savior.UnknownSize,
+	}
+	w, err := hvs.GetWriter(good)
+	tmust(t, err)
+	_, err = w.Write(data[:5])
+	tmust(t, err)
+	_, err = w.Write(data[5:])
+	tmust(t, err)
+	tmust(t, w.Close())
+
+	bad := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "bad",
+		UncompressedSize: savior.UnknownSize,
+	}
+	w, err = hvs.GetWriter(bad)
+	tmust(t, err)
+	_, err = w.Write(data)
+	tmust(t, err)
+	err = w.Close()
+	assert.Error(err)
+	assert.EqualValues(savior.ErrHashMismatch, errors.Cause(err))
+}
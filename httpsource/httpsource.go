@@ -0,0 +1,526 @@
+package httpsource
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/itchio/httpkit/neterr"
+	"github.com/itchio/httpkit/retrycontext"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrChanged is returned when the remote resource changed since the
+// source was opened (or since a checkpoint was made), according to its
+// ETag or Last-Modified headers.
+var ErrChanged = errors.New("httpsource: remote resource changed")
+
+// DefaultMaxTries is how many times requests are made before giving up
+// on transient errors, unless Settings say otherwise
+const DefaultMaxTries = 5
+
+// Settings control how requests are made
+type Settings struct {
+	// Client makes the requests, http.DefaultClient if nil
+	Client *http.Client
+	// Header is added to every request (for authentication, say)
+	Header http.Header
+	// Retry controls how network errors and 5xx responses are
+	// retried. MaxTries defaults to DefaultMaxTries.
+	Retry retrycontext.Settings
+}
+
+// HTTPSource is a Source that reads a resource over HTTP(S). Resuming
+// makes a `Range` request, so that extraction can pick up where it left
+// off after the connection (or the process) was lost. Servers that
+// ignore ranges are supported, but the start of the resource is then
+// downloaded again and discarded.
+//
+// It also implements io.ReaderAt (one range request per call, safe for
+// concurrent use), so it can be passed to zipextractor.New along with Size.
+type HTTPSource struct {
+	url      string
+	settings Settings
+
+	// -1 if unknown
+	size         int64
+	etag         string
+	lastModified string
+
+	body   io.ReadCloser
+	br     *bufio.Reader
+	offset int64
+	// read errors since something was last read
+	readFailures int
+
+	ssc      savior.SourceSaveConsumer
+	wantSave bool
+}
+
+// HTTPSourceCheckpoint holds the validators of the resource a checkpoint
+// was made for, so it's not resumed from if the resource changed
+type HTTPSourceCheckpoint struct {
+	ETag         string
+	LastModified string
+}
+
+var _ savior.Source = (*HTTPSource)(nil)
+var _ io.ReaderAt = (*HTTPSource)(nil)
+
+// Open makes a first request to find out the size of the resource at
+// `url`, and its ETag and Last-Modified headers, if any. The returned
+// source must be resumed before it's read from.
+func Open(url string, settings Settings) (*HTTPSource, error) {
+	if settings.Client == nil {
+		settings.Client = http.DefaultClient
+	}
+	if settings.Retry.MaxTries == 0 {
+		settings.Retry.MaxTries = DefaultMaxTries
+	}
+
+	hs := &HTTPSource{
+		url:      url,
+		settings: settings,
+		size:     -1,
+	}
+
+	res, err := hs.do(func() (*http.Response, error) {
+		return hs.get("bytes=0-0")
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	hs.etag = res.Header.Get("ETag")
+	hs.lastModified = res.Header.Get("Last-Modified")
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		_, _, size, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		hs.size = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		// that's what servers say about ranges of empty resources
+		hs.size = 0
+	default:
+		hs.size = res.ContentLength
+	}
+	savior.Debugf("httpsource: %s is %d bytes, etag %q, last modified %q", url, hs.size, hs.etag, hs.lastModified)
+
+	return hs, nil
+}
+
+func (hs *HTTPSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "http",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (hs *HTTPSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	hs.ssc = ssc
+}
+
+func (hs *HTTPSource) WantSave() {
+	hs.wantSave = true
+}
+
+// Resume makes a request for everything from the checkpoint's offset
+// on. It fails with ErrChanged if the checkpoint was made for another
+// version of the resource.
+func (hs *HTTPSource) Resume(c *savior.SourceCheckpoint) (int64, error) {
+	var offset int64
+	if c != nil {
+		if c.Offset < 0 {
+			return 0, errors.New("cannot resume from negative offset (corrupted checkpoint?)")
+		}
+		if hc, ok := c.Data.(*HTTPSourceCheckpoint); ok {
+			if !sameValidator(hc.ETag, hs.etag) || !sameValidator(hc.LastModified, hs.lastModified) {
+				return 0, errors.Wrapf(ErrChanged, "%s", hs.url)
+			}
+		}
+		offset = c.Offset
+	}
+
+	hs.closeBody()
+	err := hs.connect(offset)
+	if err != nil {
+		return 0, err
+	}
+	hs.readFailures = 0
+	return hs.offset, nil
+}
+
+// connect starts reading the resource at `offset`
+func (hs *HTTPSource) connect(offset int64) error {
+	if hs.size >= 0 && offset >= hs.size {
+		// nothing to ask for, and servers would say 416
+		hs.setBody(ioutil.NopCloser(strings.NewReader("")), offset)
+		return nil
+	}
+
+	res, err := hs.do(func() (*http.Response, error) {
+		return hs.get(fmt.Sprintf("bytes=%d-", offset))
+	})
+	if err != nil {
+		return err
+	}
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		start, _, _, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err == nil && start != offset {
+			err = errors.Errorf("httpsource: asked for range starting at %d, got %d", offset, start)
+		}
+		if err != nil {
+			res.Body.Close()
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// it used to be larger than that
+		res.Body.Close()
+		return errors.Wrapf(ErrChanged, "%s", hs.url)
+	default:
+		if offset > 0 {
+			savior.Debugf("httpsource: server ignored range, discarding %d bytes", offset)
+			_, err = io.CopyN(ioutil.Discard, res.Body, offset)
+			if err != nil {
+				res.Body.Close()
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	hs.setBody(res.Body, offset)
+	return nil
+}
+
+func (hs *HTTPSource) setBody(body io.ReadCloser, offset int64) {
+	hs.body = body
+	if hs.br == nil {
+		hs.br = bufio.NewReader(body)
+	} else {
+		hs.br.Reset(body)
+	}
+	hs.offset = offset
+}
+
+func (hs *HTTPSource) closeBody() {
+	if hs.body != nil {
+		hs.body.Close()
+		hs.body = nil
+	}
+}
+
+// reconnect picks up where we left off after a read error,
+// if it's a network error
+func (hs *HTTPSource) reconnect(readErr error) error {
+	hs.readFailures++
+	if !neterr.IsNetworkError(readErr) || hs.readFailures >= hs.settings.Retry.MaxTries {
+		return errors.WithStack(readErr)
+	}
+
+	savior.Debugf("httpsource: reconnecting at %d after: %v", hs.offset, readErr)
+	hs.closeBody()
+	return hs.connect(hs.offset)
+}
+
+// get makes a GET request for the given range, with preconditions
+// so that it fails if the resource changed
+func (hs *HTTPSource) get(byteRange string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", hs.url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for k, vs := range hs.settings.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Range", byteRange)
+	if hs.etag != "" && !strings.HasPrefix(hs.etag, "W/") {
+		req.Header.Set("If-Match", hs.etag)
+	} else if hs.lastModified != "" {
+		req.Header.Set("If-Unmodified-Since", hs.lastModified)
+	}
+
+	res, err := hs.settings.Client.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res, nil
+}
+
+// do makes requests until one of them succeeds, or fails with something
+// other than a network error or a server error, or we run out of tries.
+// Successful responses are checked against the validators we know of.
+func (hs *HTTPSource) do(request func() (*http.Response, error)) (*http.Response, error) {
+	rc := retrycontext.New(hs.settings.Retry)
+	for rc.ShouldTry() {
+		res, err := request()
+		if err != nil {
+			if neterr.IsNetworkError(err) {
+				rc.Retry(err)
+				continue
+			}
+			return nil, err
+		}
+
+		switch {
+		case res.StatusCode == http.StatusOK,
+			res.StatusCode == http.StatusPartialContent,
+			res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+			if !sameValidator(hs.etag, res.Header.Get("ETag")) || !sameValidator(hs.lastModified, res.Header.Get("Last-Modified")) {
+				res.Body.Close()
+				return nil, errors.Wrapf(ErrChanged, "%s", hs.url)
+			}
+			return res, nil
+		case res.StatusCode == http.StatusPreconditionFailed:
+			res.Body.Close()
+			return nil, errors.Wrapf(ErrChanged, "%s", hs.url)
+		case res.StatusCode >= 500, res.StatusCode == http.StatusTooManyRequests:
+			res.Body.Close()
+			rc.Retry(errors.Errorf("httpsource: %s: server error %s", hs.url, res.Status))
+			continue
+		default:
+			res.Body.Close()
+			return nil, errors.Errorf("httpsource: %s: unexpected status %s", hs.url, res.Status)
+		}
+	}
+	return nil, errors.Wrapf(rc.LastError, "httpsource: giving up after %d tries", rc.Tries)
+}
+
+func (hs *HTTPSource) Read(buf []byte) (int, error) {
+	if hs.br == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	if hs.size >= 0 {
+		remaining := hs.size - hs.offset
+		if remaining == 0 {
+			return 0, io.EOF
+		}
+		if int64(len(buf)) > remaining {
+			buf = buf[:remaining]
+		}
+	}
+
+	hs.handleSave()
+	n, err := hs.br.Read(buf)
+	hs.offset += int64(n)
+	if n > 0 {
+		hs.readFailures = 0
+	}
+
+	if err == io.EOF && hs.size >= 0 {
+		if hs.offset == hs.size {
+			return n, io.EOF
+		}
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		err = hs.reconnect(err)
+	}
+	return n, err
+}
+
+func (hs *HTTPSource) ReadByte() (byte, error) {
+	if hs.br == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	for {
+		if hs.offset == hs.size {
+			return 0, io.EOF
+		}
+
+		hs.handleSave()
+		b, err := hs.br.ReadByte()
+		if err == nil {
+			hs.offset++
+			hs.readFailures = 0
+			return b, nil
+		}
+
+		if err == io.EOF {
+			if hs.size < 0 {
+				return 0, io.EOF
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		err = hs.reconnect(err)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (hs *HTTPSource) handleSave() {
+	if hs.wantSave {
+		hs.wantSave = false
+		if hs.ssc != nil {
+			c := &savior.SourceCheckpoint{
+				Offset:       hs.offset,
+				OutputOffset: hs.offset,
+				Data: &HTTPSourceCheckpoint{
+					ETag:         hs.etag,
+					LastModified: hs.lastModified,
+				},
+			}
+			savior.Debugf("httpsource: emitting checkpoint at %d", c.Offset)
+			hs.ssc.Save(c)
+		}
+	}
+}
+
+// ReadAt makes a range request for len(buf) bytes at `off`, regardless
+// of the source's offset
+func (hs *HTTPSource) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("httpsource: negative offset")
+	}
+
+	want := int64(len(buf))
+	if hs.size >= 0 {
+		if off >= hs.size {
+			return 0, io.EOF
+		}
+		if off+want > hs.size {
+			want = hs.size - off
+		}
+	}
+	if want == 0 {
+		return 0, nil
+	}
+
+	// how much of buf was filled, connections can drop halfway
+	var read int64
+	rc := retrycontext.New(hs.settings.Retry)
+	for rc.ShouldTry() {
+		start := off + read
+		res, err := hs.do(func() (*http.Response, error) {
+			return hs.get(fmt.Sprintf("bytes=%d-%d", start, off+want-1))
+		})
+		if err != nil {
+			return int(read), err
+		}
+
+		body := res.Body
+		switch res.StatusCode {
+		case http.StatusOK:
+			_, err = io.CopyN(ioutil.Discard, body, start)
+		case http.StatusRequestedRangeNotSatisfiable:
+			body.Close()
+			return int(read), errors.Wrapf(ErrChanged, "%s", hs.url)
+		}
+		if err == nil {
+			var n int
+			n, err = io.ReadFull(body, buf[read:want])
+			read += int64(n)
+			if n > 0 {
+				rc.Tries = 0
+			}
+		}
+		body.Close()
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if hs.size < 0 {
+				return int(read), io.EOF
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			if neterr.IsNetworkError(err) {
+				rc.Retry(err)
+				continue
+			}
+			return int(read), errors.WithStack(err)
+		}
+
+		if read < int64(len(buf)) {
+			return int(read), io.EOF
+		}
+		return int(read), nil
+	}
+	return int(read), errors.Wrapf(rc.LastError, "httpsource: giving up after %d tries", rc.Tries)
+}
+
+// Size returns the size of the resource, or -1 if the server didn't say
+func (hs *HTTPSource) Size() int64 {
+	return hs.size
+}
+
+// Tell returns the current offset
+func (hs *HTTPSource) Tell() int64 {
+	return hs.offset
+}
+
+func (hs *HTTPSource) Progress() float64 {
+	if hs.size > 0 {
+		return float64(hs.offset) / float64(hs.size)
+	}
+	return -1
+}
+
+// Close closes the current connection, if any. The source can
+// be resumed again afterwards.
+func (hs *HTTPSource) Close() error {
+	hs.closeBody()
+	hs.br = nil
+	return nil
+}
+
+// sameValidator returns false if both validators are known, and differ
+func sameValidator(a string, b string) bool {
+	return a == "" || b == "" || a == b
+}
+
+// parseContentRange parses a `bytes start-end/size` header
+func parseContentRange(header string) (int64, int64, int64, error) {
+	invalid := errors.Errorf("httpsource: invalid Content-Range %q", header)
+
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	header = strings.TrimPrefix(header, "bytes ")
+
+	slash := strings.IndexByte(header, '/')
+	dash := strings.IndexByte(header, '-')
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, 0, invalid
+	}
+
+	start, err := strconv.ParseInt(header[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, invalid
+	}
+	end, err := strconv.ParseInt(header[dash+1:slash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, invalid
+	}
+
+	size := int64(-1)
+	if header[slash+1:] != "*" {
+		size, err = strconv.ParseInt(header[slash+1:], 10, 64)
+		if err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	return start, end, size, nil
+}
+
+func init() {
+	savior.RegisterCheckpointData("httpsource.HTTPSourceCheckpoint", &HTTPSourceCheckpoint{})
+}
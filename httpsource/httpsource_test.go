@@ -0,0 +1,224 @@
+package httpsource_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itchio/httpkit/retrycontext"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/httpsource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSettings = httpsource.Settings{
+	Retry: retrycontext.Settings{
+		MaxTries: 5,
+		NoSleep:  true,
+	},
+}
+
+// testServer serves `data` with http.ServeContent, which supports
+// ranges and preconditions
+type testServer struct {
+	mu   sync.Mutex
+	data []byte
+	etag string
+
+	// if set, ranges are ignored
+	noRanges bool
+	// if set, responses are cut short after that many bytes
+	cutAfter int
+	// how many requests fail with a 503 before one succeeds
+	failures int
+
+	requests int
+}
+
+func (ts *testServer) set(data []byte, etag string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.data = data
+	ts.etag = etag
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	ts.requests++
+	data, etag := ts.data, ts.etag
+	fail := ts.failures > 0
+	if fail {
+		ts.failures--
+	}
+	noRanges, cutAfter := ts.noRanges, ts.cutAfter
+	ts.mu.Unlock()
+
+	if fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if cutAfter > 0 {
+		w = &cuttingWriter{ResponseWriter: w, remaining: cutAfter}
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if noRanges {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+		return
+	}
+	http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(data))
+}
+
+// cuttingWriter drops the connection after writing `remaining` bytes
+type cuttingWriter struct {
+	http.ResponseWriter
+	remaining int
+}
+
+func (cw *cuttingWriter) Write(buf []byte) (int, error) {
+	if len(buf) > cw.remaining {
+		cw.ResponseWriter.Write(buf[:cw.remaining])
+		cw.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	cw.remaining -= len(buf)
+	return cw.ResponseWriter.Write(buf)
+}
+
+func serve(t *testing.T, ts *testServer) (*httptest.Server, *httpsource.HTTPSource) {
+	server := httptest.NewServer(ts)
+	hs, err := httpsource.Open(server.URL, testSettings)
+	must(t, err)
+	return server, hs
+}
+
+func Test_Checkpoints(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+
+	for _, noRanges := range []bool{false, true} {
+		server, hs := serve(t, &testServer{data: reference, etag: `"v1"`, noRanges: noRanges})
+		assert.EqualValues(t, len(reference), hs.Size())
+		checker.RunSourceTest(t, hs, reference)
+		must(t, hs.Close())
+		server.Close()
+	}
+}
+
+func Test_Zip(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+
+	server, hs := serve(t, &testServer{data: zipBytes})
+	defer server.Close()
+
+	ex, err := zipextractor.New(hs, hs.Size())
+	must(t, err)
+	_, err = ex.Resume(nil, sink)
+	must(t, err)
+	must(t, sink.Validate())
+}
+
+func Test_Changed(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(1024 * 1024)
+	ts := &testServer{data: reference, etag: `"v1"`}
+	server, hs := serve(t, ts)
+	defer server.Close()
+
+	_, err := hs.Resume(nil)
+	must(t, err)
+	var checkpoint *savior.SourceCheckpoint
+	hs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			checkpoint = c
+			return nil
+		},
+	})
+	buf := make([]byte, 64*1024)
+	_, err = io.ReadFull(hs, buf)
+	must(t, err)
+	hs.WantSave()
+	_, err = hs.ReadByte()
+	must(t, err)
+	assert.NotNil(checkpoint)
+
+	offset, err := hs.Resume(checkpoint)
+	must(t, err)
+	assert.EqualValues(64*1024, offset)
+
+	ts.set(semirandom.Bytes(1024*1024), `"v2"`)
+
+	// the server tells us
+	_, err = hs.Resume(checkpoint)
+	assert.EqualValues(httpsource.ErrChanged, errors.Cause(err))
+	_, err = hs.ReadAt(buf, 0)
+	assert.EqualValues(httpsource.ErrChanged, errors.Cause(err))
+
+	// the checkpoint tells us
+	hs2, err := httpsource.Open(server.URL, testSettings)
+	must(t, err)
+	_, err = hs2.Resume(checkpoint)
+	assert.EqualValues(httpsource.ErrChanged, errors.Cause(err))
+	_, err = hs2.Resume(nil)
+	must(t, err)
+}
+
+func Test_Flaky(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(1024 * 1024)
+	ts := &testServer{data: reference, etag: `"v1"`}
+	server, hs := serve(t, ts)
+	defer server.Close()
+
+	ts.mu.Lock()
+	ts.failures = 3
+	ts.cutAfter = 300 * 1024
+	ts.mu.Unlock()
+
+	_, err := hs.Resume(nil)
+	must(t, err)
+	output, err := ioutil.ReadAll(hs)
+	must(t, err)
+	assert.EqualValues(reference, output)
+
+	buf := make([]byte, 512*1024)
+	n, err := hs.ReadAt(buf, 256*1024)
+	must(t, err)
+	assert.EqualValues(len(buf), n)
+	assert.EqualValues(reference[256*1024:768*1024], buf)
+
+	// past the end
+	n, err = hs.ReadAt(buf, 768*1024)
+	assert.EqualValues(256*1024, n)
+	assert.EqualValues(io.EOF, err)
+
+	// giving up eventually
+	ts.mu.Lock()
+	ts.failures = 100
+	ts.requests = 0
+	ts.mu.Unlock()
+	_, err = hs.Resume(nil)
+	assert.Error(err)
+	assert.EqualValues(testSettings.Retry.MaxTries, ts.requests)
+}
+
+func must(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
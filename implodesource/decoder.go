@@ -0,0 +1,271 @@
+package implodesource
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// general purpose flags of imploded entries
+	flagLargeWindow = 1 << 1
+	flagLiteralTree = 1 << 2
+
+	// distances go up to 8K, and bytes before the start of
+	// the stream are zeroes, so the window starts out zeroed
+	windowSize = 8192
+	windowMask = windowSize - 1
+
+	maxCodeLength = 16
+)
+
+// tree is a Shannon-Fano tree, as described at the start of imploded
+// streams. Codes are read most significant bit first.
+type tree struct {
+	// first is the first code of each length
+	first [maxCodeLength + 1]uint32
+	// symbols holds the symbols of each length, in code order
+	symbols [maxCodeLength + 1][]uint16
+}
+
+// readTree reads the description of a tree of numSymbols symbols: a byte
+// holding the number of bytes that follow, minus one, then bytes whose low
+// 4 bits are a code length minus one, and high 4 bits the number of
+// consecutive symbols with that length, minus one.
+func readTree(r io.ByteReader, numSymbols int) (*tree, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	lengths := make([]int, 0, numSymbols)
+	for i := 0; i < int(b)+1; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		length := int(b&0xf) + 1
+		count := int(b>>4) + 1
+		if len(lengths)+count > numSymbols {
+			return nil, errors.Wrapf(ErrCorrupt, "tree describes more than %d symbols", numSymbols)
+		}
+		for j := 0; j < count; j++ {
+			lengths = append(lengths, length)
+		}
+	}
+	if len(lengths) != numSymbols {
+		return nil, errors.Wrapf(ErrCorrupt, "tree describes %d symbols instead of %d", len(lengths), numSymbols)
+	}
+
+	// codes are handed out starting from the longest ones, and from the
+	// last symbol among those of the same length. Each code is one more
+	// than the previous one, at the previous one's length.
+	t := &tree{}
+	var code, increment uint32
+	lastLength := 0
+	for length := maxCodeLength; length >= 1; length-- {
+		for symbol := numSymbols - 1; symbol >= 0; symbol-- {
+			if lengths[symbol] != length {
+				continue
+			}
+
+			code += increment
+			if length != lastLength {
+				lastLength = length
+				increment = 1 << (maxCodeLength - uint(length))
+				t.first[length] = code >> (maxCodeLength - uint(length))
+			}
+			if code >= 1<<maxCodeLength {
+				return nil, errors.Wrapf(ErrCorrupt, "tree is oversubscribed")
+			}
+			t.symbols[length] = append(t.symbols[length], uint16(symbol))
+		}
+	}
+	return t, nil
+}
+
+// decoder decompresses an imploded stream: Shannon-Fano trees, then
+// literals and matches, up until it's produced size bytes. There's no
+// end marker.
+type decoder struct {
+	r    io.ByteReader
+	size int64
+	err  error
+
+	literals  *tree
+	lengths   *tree
+	distances *tree
+	lowBits   uint
+	minMatch  int
+
+	bits  uint32
+	nbits uint
+
+	window    []byte
+	total     int64
+	matchLen  int
+	matchDist int
+}
+
+// newDecoder reads the trees at the start of an imploded stream, whose
+// parameters are given by the entry's general purpose flags
+func newDecoder(r io.ByteReader, size int64, flags uint16) (*decoder, error) {
+	d := &decoder{
+		r:        r,
+		size:     size,
+		lowBits:  6,
+		minMatch: 2,
+		window:   make([]byte, windowSize),
+	}
+	if flags&flagLargeWindow != 0 {
+		d.lowBits = 7
+	}
+
+	var err error
+	if flags&flagLiteralTree != 0 {
+		d.minMatch = 3
+		d.literals, err = readTree(r, 256)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading literal tree")
+		}
+	}
+	d.lengths, err = readTree(r, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading length tree")
+	}
+	d.distances, err = readTree(r, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading distance tree")
+	}
+	return d, nil
+}
+
+func (d *decoder) readBits(n uint) (uint32, error) {
+	for d.nbits < n {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		d.bits |= uint32(b) << d.nbits
+		d.nbits += 8
+	}
+	v := d.bits & (1<<n - 1)
+	d.bits >>= n
+	d.nbits -= n
+	return v, nil
+}
+
+func (d *decoder) readSymbol(t *tree) (int, error) {
+	var code uint32
+	for length := 1; length <= maxCodeLength; length++ {
+		bit, err := d.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | bit
+
+		symbols := t.symbols[length]
+		if code >= t.first[length] && code-t.first[length] < uint32(len(symbols)) {
+			return int(symbols[code-t.first[length]]), nil
+		}
+	}
+	return 0, errors.Wrap(ErrCorrupt, "invalid code")
+}
+
+func (d *decoder) Read(buf []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	n := 0
+	for n < len(buf) {
+		if d.total >= d.size {
+			d.err = io.EOF
+			break
+		}
+
+		if d.matchLen > 0 {
+			b := d.window[(d.total-int64(d.matchDist))&windowMask]
+			d.put(b)
+			buf[n] = b
+			n++
+			d.matchLen--
+			continue
+		}
+
+		err := d.next(buf[n:])
+		if err != nil {
+			d.err = err
+			break
+		}
+		if d.matchLen == 0 {
+			// a literal
+			n++
+		}
+	}
+	if n > 0 && d.err == io.EOF {
+		return n, nil
+	}
+	return n, d.err
+}
+
+// next decodes a literal into buf[0], or sets up a match
+func (d *decoder) next(buf []byte) error {
+	isLiteral, err := d.readBits(1)
+	if err != nil {
+		return err
+	}
+
+	if isLiteral == 1 {
+		var b uint32
+		if d.literals != nil {
+			var symbol int
+			symbol, err = d.readSymbol(d.literals)
+			b = uint32(symbol)
+		} else {
+			b, err = d.readBits(8)
+		}
+		if err != nil {
+			return err
+		}
+		d.put(byte(b))
+		buf[0] = byte(b)
+		return nil
+	}
+
+	low, err := d.readBits(d.lowBits)
+	if err != nil {
+		return err
+	}
+	high, err := d.readSymbol(d.distances)
+	if err != nil {
+		return err
+	}
+	length, err := d.readSymbol(d.lengths)
+	if err != nil {
+		return err
+	}
+	if length == 63 {
+		extra, err := d.readBits(8)
+		if err != nil {
+			return err
+		}
+		length += int(extra)
+	}
+
+	d.matchDist = (high<<d.lowBits | int(low)) + 1
+	d.matchLen = length + d.minMatch
+	return nil
+}
+
+func (d *decoder) put(b byte) {
+	d.window[d.total&windowMask] = b
+	d.total++
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return errors.WithStack(err)
+}
@@ -0,0 +1,227 @@
+// Package implodesource decompresses the data of zip entries compressed
+// with the implode method (compression method 6), as written by PKZIP 1.x.
+// Those are rare and small, so checkpoints don't hold the decoder's state:
+// resuming decompresses the entry again from its start, and throws away
+// everything up to where the checkpoint was taken.
+package implodesource
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrCorrupt is returned when the compressed data isn't a valid imploded stream
+var ErrCorrupt = errors.New("implodesource: corrupt stream")
+
+type implodeSource struct {
+	// input
+	source savior.Source
+
+	// params
+	size  int64
+	flags uint16
+
+	// internal
+	dec      *decoder
+	offset   int64
+	bytebuf  []byte
+	wantSave bool
+
+	ssc savior.SourceSaveConsumer
+}
+
+// ImplodeSourceCheckpoint is the checkpoint data of implode sources. It
+// only records which stream it's for, the position in the decompressed
+// stream is the source checkpoint's OutputOffset.
+type ImplodeSourceCheckpoint struct {
+	Size  int64
+	Flags uint16
+}
+
+var _ savior.Source = (*implodeSource)(nil)
+
+// New returns a source that decompresses the data of a zip entry
+// compressed with the implode method, which decompresses to `size` bytes.
+// flags are the entry's general purpose flags, which say how large the
+// sliding dictionary is (bit 1) and whether literals are compressed (bit 2).
+func New(source savior.Source, size int64, flags uint16) *implodeSource {
+	return &implodeSource{
+		source:  source,
+		size:    size,
+		flags:   flags,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (is *implodeSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "implode",
+		ResumeSupport: savior.ResumeSupportNone,
+	}
+}
+
+func (is *implodeSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	is.ssc = ssc
+}
+
+func (is *implodeSource) WantSave() {
+	is.wantSave = true
+}
+
+// Resume always decompresses the stream from its start, but skips
+// to the checkpoint's output offset
+func (is *implodeSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	savior.Debugf(`implode: asked to resume`)
+	is.wantSave = false
+
+	err := is.restart()
+	if err != nil {
+		return 0, err
+	}
+
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*ImplodeSourceCheckpoint); ok {
+			if ourCheckpoint.Size == is.size && ourCheckpoint.Flags == is.flags {
+				savior.Debugf(`implodesource: discarding %d bytes to get back to checkpoint`, checkpoint.OutputOffset)
+				err = savior.DiscardByRead(is, checkpoint.OutputOffset)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				return is.offset, nil
+			}
+			savior.Debugf(`implodesource: checkpoint is for another stream, starting over`)
+		}
+	}
+	return 0, nil
+}
+
+// restart gets ready to decompress the stream from its start
+func (is *implodeSource) restart() error {
+	sourceOffset, err := is.source.Resume(nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("implodesource: expected source to resume at start but got %d", sourceOffset)
+		return errors.New(msg)
+	}
+
+	is.dec, err = newDecoder(is.source, is.size, is.flags)
+	if err != nil {
+		return err
+	}
+	is.offset = 0
+	return nil
+}
+
+func (is *implodeSource) Read(buf []byte) (int, error) {
+	if is.dec == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	if is.wantSave {
+		err := is.save()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := is.dec.Read(buf)
+	is.offset += int64(n)
+	return n, err
+}
+
+// save emits a checkpoint, which only holds the current output offset
+func (is *implodeSource) save() error {
+	is.wantSave = false
+
+	if is.ssc == nil {
+		savior.Debugf("implodesource: can't save, ssc is nil!")
+		return nil
+	}
+
+	checkpoint := &savior.SourceCheckpoint{
+		Offset:       0,
+		OutputOffset: is.offset,
+		Data: &ImplodeSourceCheckpoint{
+			Size:  is.size,
+			Flags: is.flags,
+		},
+	}
+	err := is.ssc.Save(checkpoint)
+	savior.Debugf("implodesource: saved checkpoint at byte %d", checkpoint.OutputOffset)
+	return err
+}
+
+func (is *implodeSource) ReadByte() (byte, error) {
+	if is.dec == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := is.Read(is.bytebuf)
+	if n == 0 && err == nil {
+		n, err = is.Read(is.bytebuf)
+	}
+	if n == 0 && err == nil {
+		err = io.ErrNoProgress
+	}
+	return is.bytebuf[0], err
+}
+
+func (is *implodeSource) Progress() float64 {
+	return is.source.Progress()
+}
+
+// Close releases the decoder and closes the underlying source
+func (is *implodeSource) Close() error {
+	is.dec = nil
+	return is.source.Close()
+}
+
+// NewReader returns a reader that decompresses the data of a zip entry
+// compressed with the implode method, see New. It's suitable as a zip
+// decompressor.
+func NewReader(r io.Reader, size int64, flags uint16) io.ReadCloser {
+	return &reader{r: bufio.NewReader(r), size: size, flags: flags}
+}
+
+type reader struct {
+	r     *bufio.Reader
+	size  int64
+	flags uint16
+	dec   *decoder
+	err   error
+}
+
+func (rd *reader) Read(buf []byte) (int, error) {
+	if rd.err != nil {
+		return 0, rd.err
+	}
+	if rd.dec == nil {
+		rd.dec, rd.err = newDecoder(rd.r, rd.size, rd.flags)
+		if rd.err != nil {
+			return 0, rd.err
+		}
+	}
+
+	n, err := rd.dec.Read(buf)
+	if err != nil {
+		rd.err = err
+	}
+	return n, err
+}
+
+func (rd *reader) Close() error {
+	rd.dec = nil
+	rd.err = errors.New("implodesource: read from closed reader")
+	return nil
+}
+
+func init() {
+	savior.RegisterCheckpointData("implodesource.ImplodeSourceCheckpoint", &ImplodeSourceCheckpoint{})
+}
@@ -0,0 +1,151 @@
+package implodesource_test
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/implodesource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// testdata/imploded.zip holds the same text imploded with every
+// combination of dictionary size and number of trees. It was checked
+// with Info-ZIP's unzip.
+func fixtureEntries(t *testing.T) ([]*zip.File, []byte) {
+	zipBytes, err := ioutil.ReadFile("testdata/imploded.zip")
+	must(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	assert.Len(t, zr.File, 4)
+	return zr.File, zipBytes
+}
+
+func compressedData(t *testing.T, zf *zip.File, zipBytes []byte) []byte {
+	dataOff, err := zf.DataOffset()
+	must(t, err)
+	return zipBytes[dataOff : dataOff+int64(zf.CompressedSize64)]
+}
+
+func Test_Uninitialized(t *testing.T) {
+	ss := seeksource.FromBytes(nil)
+	_, err := ss.Resume(nil)
+	assert.NoError(t, err)
+
+	is := implodesource.New(ss, 0, 0)
+	_, err = is.Read([]byte{})
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+
+	_, err = is.ReadByte()
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+}
+
+func Test_Fixture(t *testing.T) {
+	assert := assert.New(t)
+
+	var reference []byte
+	entries, zipBytes := fixtureEntries(t)
+	for _, zf := range entries {
+		assert.EqualValues(6, zf.Method, "%s", zf.Name)
+		data := compressedData(t, zf, zipBytes)
+		size := int64(zf.UncompressedSize64)
+
+		is := implodesource.New(seeksource.FromBytes(data), size, zf.Flags)
+		offset, err := is.Resume(nil)
+		must(t, err)
+		assert.EqualValues(0, offset)
+		output, err := ioutil.ReadAll(is)
+		must(t, err)
+		assert.EqualValues(size, len(output), "%s", zf.Name)
+		assert.EqualValues(zf.CRC32, crc32.ChecksumIEEE(output), "%s", zf.Name)
+		if reference == nil {
+			reference = output
+		}
+		assert.True(bytes.Equal(reference, output), "%s", zf.Name)
+
+		// checkpoints resume at the same output offset, in a
+		// fresh source, by decompressing everything before it again
+		is = implodesource.New(seeksource.FromBytes(data), size, zf.Flags)
+		_, err = is.Resume(nil)
+		must(t, err)
+		var c *savior.SourceCheckpoint
+		is.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+			OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+				c = checkpoint
+				return nil
+			},
+		})
+		_, err = io.ReadFull(is, make([]byte, 5000))
+		must(t, err)
+		is.WantSave()
+		_, err = is.Read(make([]byte, 1))
+		must(t, err)
+		if !assert.NotNil(c, "%s", zf.Name) {
+			continue
+		}
+		assert.EqualValues(5000, c.OutputOffset)
+
+		is = implodesource.New(seeksource.FromBytes(data), size, zf.Flags)
+		offset, err = is.Resume(c)
+		must(t, err)
+		assert.EqualValues(5000, offset)
+		output, err = ioutil.ReadAll(is)
+		must(t, err)
+		assert.True(bytes.Equal(reference[5000:], output), "%s", zf.Name)
+
+		output, err = ioutil.ReadAll(implodesource.NewReader(bytes.NewReader(data), size, zf.Flags))
+		must(t, err)
+		assert.True(bytes.Equal(reference, output), "%s", zf.Name)
+	}
+}
+
+func Test_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	entries, zipBytes := fixtureEntries(t)
+	zf := entries[0]
+	data := compressedData(t, zf, zipBytes)
+	size := int64(zf.UncompressedSize64)
+
+	readAll := func(data []byte) error {
+		is := implodesource.New(seeksource.FromBytes(data), size, zf.Flags)
+		_, err := is.Resume(nil)
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.ReadAll(is)
+		return err
+	}
+
+	// truncated
+	err := readAll(data[:len(data)/2])
+	assert.Error(err)
+	assert.True(errors.Cause(err) == io.ErrUnexpectedEOF)
+
+	// the length tree only describes a few symbols
+	bad := append([]byte(nil), data...)
+	bad[0] = 0
+	err = readAll(bad)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == implodesource.ErrCorrupt)
+
+	// 64 codes of length 1 don't fit
+	err = readAll([]byte{3, 0xf0, 0xf0, 0xf0, 0xf0})
+	assert.Error(err)
+	assert.True(errors.Cause(err) == implodesource.ErrCorrupt)
+}
@@ -0,0 +1,111 @@
+package savior
+
+import (
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LineEnding decides how FolderSink treats line endings in text entries
+type LineEnding int
+
+const (
+	// LineKeep writes text entries as-is
+	LineKeep LineEnding = 0
+	// LineLF converts CRLF line endings to LF
+	LineLF LineEnding = 1
+	// LineCRLF converts LF line endings to CRLF
+	LineCRLF LineEnding = 2
+)
+
+// ErrLineEndingResume is returned when trying to resume an entry whose line
+// endings are being converted: since conversion changes the number of bytes
+// written, the file on disk can't be lined up with the archive anymore.
+var ErrLineEndingResume = errors.New("can't resume writing an entry whose line endings are converted")
+
+// textExtensions are used to recognize text entries, for archives
+// that don't mark them as such
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".log": true,
+	".csv": true, ".tsv": true, ".json": true, ".xml": true,
+	".html": true, ".htm": true, ".css": true, ".js": true, ".svg": true,
+	".ini": true, ".cfg": true, ".conf": true, ".yml": true, ".yaml": true, ".toml": true,
+	".sh": true, ".bat": true, ".cmd": true, ".ps1": true,
+	".py": true, ".lua": true, ".c": true, ".h": true, ".cpp": true, ".go": true,
+}
+
+// isTextEntry returns true for files the archive marks as text,
+// or that have a well-known text extension
+func isTextEntry(entry *Entry) bool {
+	if entry.Kind != EntryKindFile {
+		return false
+	}
+	if entry.IsText {
+		return true
+	}
+	return textExtensions[strings.ToLower(path.Ext(entry.CanonicalPath))]
+}
+
+// lineEndingWriter converts line endings on the fly. Its Write
+// method returns the number of bytes consumed, not written.
+type lineEndingWriter struct {
+	w    io.Writer
+	mode LineEnding
+
+	// LineLF: a '\r' we haven't written yet, in case it's followed by '\n'
+	pendingCR bool
+	// LineCRLF: the last byte written was '\r'
+	lastCR bool
+
+	out []byte
+}
+
+func (lw *lineEndingWriter) Write(buf []byte) (int, error) {
+	out := lw.out[:0]
+
+	switch lw.mode {
+	case LineLF:
+		for _, b := range buf {
+			if lw.pendingCR {
+				lw.pendingCR = false
+				if b != '\n' {
+					out = append(out, '\r')
+				}
+			}
+			if b == '\r' {
+				lw.pendingCR = true
+				continue
+			}
+			out = append(out, b)
+		}
+	case LineCRLF:
+		for _, b := range buf {
+			if b == '\n' && !lw.lastCR {
+				out = append(out, '\r')
+			}
+			out = append(out, b)
+			lw.lastCR = b == '\r'
+		}
+	default:
+		out = append(out, buf...)
+	}
+	lw.out = out
+
+	_, err := lw.w.Write(out)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Flush writes out anything held back
+func (lw *lineEndingWriter) Flush() error {
+	if lw.pendingCR {
+		lw.pendingCR = false
+		_, err := lw.w.Write([]byte{'\r'})
+		return err
+	}
+	return nil
+}
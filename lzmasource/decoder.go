@@ -0,0 +1,536 @@
+package lzmasource
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	numStates        = 12
+	posBitsMax       = 4
+	numLenToPosState = 4
+	numAlignBits     = 4
+	startPosModel    = 4
+	endPosModel      = 14
+	numFullDistances = 1 << (endPosModel >> 1)
+	matchMinLen      = 2
+	matchMaxLen      = matchMinLen + 8 + 8 + 256 - 1
+
+	minDictSize = 1 << 12
+	// initialWindowSize is how large the window is at first, it grows
+	// as more is decompressed, up to the dictionary size
+	initialWindowSize = 1 << 16
+	// propsSize is the size of the lc/lp/pb byte and the dictionary size
+	propsSize = 5
+
+	probBits = 11
+	probInit = 1 << (probBits - 1)
+	topValue = 1 << 24
+
+	// lenCoder layout: choice, choice2, low, mid, high
+	lenChoice  = 0
+	lenChoice2 = 1
+	lenLow     = 2
+	lenMid     = lenLow + (1<<posBitsMax)<<3
+	lenHigh    = lenMid + (1<<posBitsMax)<<3
+	lenProbs   = lenHigh + 1<<8
+
+	// offsets of each model in decoder.probs
+	probsIsMatch    = 0
+	probsIsRep      = probsIsMatch + numStates<<posBitsMax
+	probsIsRepG0    = probsIsRep + numStates
+	probsIsRepG1    = probsIsRepG0 + numStates
+	probsIsRepG2    = probsIsRepG1 + numStates
+	probsIsRep0Long = probsIsRepG2 + numStates
+	probsPosSlot    = probsIsRep0Long + numStates<<posBitsMax
+	probsPos        = probsPosSlot + numLenToPosState<<6
+	probsAlign      = probsPos + 1 + numFullDistances - endPosModel
+	probsLen        = probsAlign + 1<<numAlignBits
+	probsRepLen     = probsLen + lenProbs
+	probsLiteral    = probsRepLen + lenProbs
+)
+
+// props are the parameters of an LZMA stream
+type props struct {
+	lc, lp, pb uint
+	dictSize   uint32
+}
+
+func parseProps(buf []byte) (props, error) {
+	var p props
+	d := uint(buf[0])
+	if d >= 9*5*5 {
+		return p, errors.Wrapf(ErrCorrupt, "invalid properties byte 0x%x", buf[0])
+	}
+	p.lc = d % 9
+	d /= 9
+	p.lp = d % 5
+	p.pb = d / 5
+
+	p.dictSize = binary.LittleEndian.Uint32(buf[1:])
+	if p.dictSize < minDictSize {
+		p.dictSize = minDictSize
+	}
+	return p, nil
+}
+
+// decoder decompresses a raw LZMA stream, one symbol at a time. In
+// between symbols, its whole state can be saved and restored.
+type decoder struct {
+	r   io.ByteReader
+	err error
+	// roffset is how many bytes were read from r
+	roffset int64
+
+	props props
+	// size is the size of the decompressed stream, or -1 if it ends
+	// with an end marker
+	size int64
+
+	rng  uint32
+	code uint32
+
+	state uint32
+	reps  [4]uint32
+	probs []uint16
+
+	// win holds the last decompressed bytes: it grows until it's
+	// winSize bytes (at most as large as the dictionary), then it's
+	// a circular buffer
+	win     []byte
+	winSize int
+	pos     int
+	full    bool
+	// total is how many bytes were decompressed
+	total int64
+	// pending is how many of the last decompressed bytes weren't read yet
+	pending int
+	eos     bool
+}
+
+func newDecoder(r io.ByteReader, p props, size int64) *decoder {
+	d := &decoder{
+		r:       r,
+		props:   p,
+		size:    size,
+		probs:   make([]uint16, probsLiteral+0x300<<(p.lc+p.lp)),
+		winSize: windowSize(p, size),
+	}
+	// headers can declare dictionaries of up to 4GiB, whatever
+	// the stream actually needs
+	initial := d.winSize
+	if initial > initialWindowSize {
+		initial = initialWindowSize
+	}
+	d.win = make([]byte, initial)
+	for i := range d.probs {
+		d.probs[i] = probInit
+	}
+	return d
+}
+
+// windowSize returns how much of the dictionary can ever be referenced:
+// for small streams, that's less than the whole dictionary.
+func windowSize(p props, size int64) int {
+	ws := int64(p.dictSize)
+	if size >= 0 && size < ws {
+		ws = size
+		if ws < minDictSize {
+			ws = minDictSize
+		}
+	}
+	return int(ws)
+}
+
+func (d *decoder) readByte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+		return 0
+	}
+	d.roffset++
+	return b
+}
+
+// initRange reads the first bytes of the range coder
+func (d *decoder) initRange() error {
+	first := d.readByte()
+	d.rng = 0xFFFFFFFF
+	d.code = 0
+	for i := 0; i < 4; i++ {
+		d.code = d.code<<8 | uint32(d.readByte())
+	}
+	if d.err != nil {
+		return errors.WithStack(d.err)
+	}
+	if first != 0 || d.code == d.rng {
+		return errors.Wrap(ErrCorrupt, "invalid range coder header")
+	}
+	return nil
+}
+
+func (d *decoder) normalize() {
+	if d.rng < topValue {
+		d.rng <<= 8
+		d.code = d.code<<8 | uint32(d.readByte())
+	}
+}
+
+func (d *decoder) decodeBit(i int) uint32 {
+	v := uint32(d.probs[i])
+	bound := (d.rng >> probBits) * v
+	var bit uint32
+	if d.code < bound {
+		v += ((1 << probBits) - v) >> 5
+		d.rng = bound
+	} else {
+		v -= v >> 5
+		d.code -= bound
+		d.rng -= bound
+		bit = 1
+	}
+	d.probs[i] = uint16(v)
+	d.normalize()
+	return bit
+}
+
+func (d *decoder) decodeDirectBits(numBits uint) uint32 {
+	var res uint32
+	for ; numBits > 0; numBits-- {
+		d.rng >>= 1
+		d.code -= d.rng
+		t := 0 - (d.code >> 31)
+		d.code += d.rng & t
+		d.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func (d *decoder) decodeTree(base int, numBits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		m = m<<1 + d.decodeBit(base+int(m))
+	}
+	return m - 1<<numBits
+}
+
+func (d *decoder) decodeReverseTree(base int, numBits uint) uint32 {
+	m := uint32(1)
+	var sym uint32
+	for i := uint(0); i < numBits; i++ {
+		bit := d.decodeBit(base + int(m))
+		m = m<<1 + bit
+		sym |= bit << i
+	}
+	return sym
+}
+
+func (d *decoder) decodeLen(base int, posState uint32) uint32 {
+	if d.decodeBit(base+lenChoice) == 0 {
+		return d.decodeTree(base+lenLow+int(posState<<3), 3)
+	}
+	if d.decodeBit(base+lenChoice2) == 0 {
+		return 8 + d.decodeTree(base+lenMid+int(posState<<3), 3)
+	}
+	return 16 + d.decodeTree(base+lenHigh, 8)
+}
+
+func (d *decoder) decodeDistance(l uint32) uint32 {
+	lenState := l
+	if lenState > numLenToPosState-1 {
+		lenState = numLenToPosState - 1
+	}
+
+	posSlot := d.decodeTree(probsPosSlot+int(lenState<<6), 6)
+	if posSlot < startPosModel {
+		return posSlot
+	}
+
+	numDirectBits := uint(posSlot>>1) - 1
+	dist := (2 | posSlot&1) << numDirectBits
+	if posSlot < endPosModel {
+		return dist + d.decodeReverseTree(probsPos+int(dist-posSlot), numDirectBits)
+	}
+	dist += d.decodeDirectBits(numDirectBits-numAlignBits) << numAlignBits
+	return dist + d.decodeReverseTree(probsAlign, numAlignBits)
+}
+
+// available returns how many bytes back matches can reach
+func (d *decoder) available() uint32 {
+	if d.full {
+		return uint32(len(d.win))
+	}
+	return uint32(d.pos)
+}
+
+// getByte returns the byte `dist` bytes back, 1 being the last one
+func (d *decoder) getByte(dist uint32) byte {
+	i := d.pos - int(dist)
+	if i < 0 {
+		i += len(d.win)
+	}
+	return d.win[i]
+}
+
+func (d *decoder) putByte(b byte) {
+	d.win[d.pos] = b
+	d.pos++
+	if d.pos == len(d.win) {
+		if len(d.win) < d.winSize {
+			d.growWindow()
+		} else {
+			d.pos = 0
+			d.full = true
+		}
+	}
+	d.total++
+	d.pending++
+}
+
+// growWindow doubles the size of the window, up to winSize. It must
+// only be called before the window wraps around.
+func (d *decoder) growWindow() {
+	size := len(d.win) * 2
+	if size > d.winSize {
+		size = d.winSize
+	}
+	win := make([]byte, size)
+	copy(win, d.win)
+	d.win = win
+}
+
+func (d *decoder) decodeLiteral() {
+	var prevByte uint32
+	if d.total > 0 {
+		prevByte = uint32(d.getByte(1))
+	}
+	lc, lp := d.props.lc, d.props.lp
+	litState := (uint32(d.total)&(1<<lp-1))<<lc + prevByte>>(8-lc)
+	base := probsLiteral + int(0x300*litState)
+
+	symbol := uint32(1)
+	if d.state >= 7 {
+		matchByte := uint32(d.getByte(d.reps[0] + 1))
+		for symbol < 0x100 {
+			matchBit := (matchByte >> 7) & 1
+			matchByte <<= 1
+			bit := d.decodeBit(base + int((1+matchBit)<<8+symbol))
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | d.decodeBit(base+int(symbol))
+	}
+	d.putByte(byte(symbol - 0x100))
+}
+
+// done returns true once the whole stream was decompressed
+func (d *decoder) done() bool {
+	return d.eos || (d.size >= 0 && d.total == d.size)
+}
+
+// decodeSymbol decompresses one literal or match into the window
+func (d *decoder) decodeSymbol() error {
+	posState := uint32(d.total) & (1<<d.props.pb - 1)
+	state := d.state
+
+	if d.decodeBit(probsIsMatch+int(state<<posBitsMax+posState)) == 0 {
+		d.decodeLiteral()
+		switch {
+		case state < 4:
+			d.state = 0
+		case state < 10:
+			d.state = state - 3
+		default:
+			d.state = state - 6
+		}
+		return d.err
+	}
+
+	var l uint32
+	if d.decodeBit(probsIsRep+int(state)) != 0 {
+		if d.total == 0 {
+			return errors.Wrap(ErrCorrupt, "repeated match at start of stream")
+		}
+		if d.decodeBit(probsIsRepG0+int(state)) == 0 {
+			if d.decodeBit(probsIsRep0Long+int(state<<posBitsMax+posState)) == 0 {
+				// short rep: a single byte
+				if d.reps[0] >= d.available() {
+					return errors.Wrapf(ErrCorrupt, "match distance %d goes before start of stream", d.reps[0])
+				}
+				if state < 7 {
+					d.state = 9
+				} else {
+					d.state = 11
+				}
+				d.putByte(d.getByte(d.reps[0] + 1))
+				return d.err
+			}
+		} else {
+			var dist uint32
+			if d.decodeBit(probsIsRepG1+int(state)) == 0 {
+				dist = d.reps[1]
+			} else {
+				if d.decodeBit(probsIsRepG2+int(state)) == 0 {
+					dist = d.reps[2]
+				} else {
+					dist = d.reps[3]
+					d.reps[3] = d.reps[2]
+				}
+				d.reps[2] = d.reps[1]
+			}
+			d.reps[1] = d.reps[0]
+			d.reps[0] = dist
+		}
+		l = d.decodeLen(probsRepLen, posState)
+		if state < 7 {
+			d.state = 8
+		} else {
+			d.state = 11
+		}
+	} else {
+		d.reps[3] = d.reps[2]
+		d.reps[2] = d.reps[1]
+		d.reps[1] = d.reps[0]
+		l = d.decodeLen(probsLen, posState)
+		if state < 7 {
+			d.state = 7
+		} else {
+			d.state = 10
+		}
+
+		d.reps[0] = d.decodeDistance(l)
+		if d.err != nil {
+			return d.err
+		}
+		if d.reps[0] == 0xFFFFFFFF {
+			// end marker
+			if d.code != 0 {
+				return errors.Wrap(ErrCorrupt, "invalid end marker")
+			}
+			if d.size >= 0 && d.total != d.size {
+				return errors.Wrapf(ErrCorrupt, "end marker after %d bytes, expected %d", d.total, d.size)
+			}
+			d.eos = true
+			return nil
+		}
+		if d.reps[0] >= d.props.dictSize {
+			return errors.Wrapf(ErrCorrupt, "match distance %d is larger than the dictionary", d.reps[0])
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	if d.reps[0] >= d.available() {
+		return errors.Wrapf(ErrCorrupt, "match distance %d goes before start of stream", d.reps[0])
+	}
+	l += matchMinLen
+	if d.size >= 0 && int64(l) > d.size-d.total {
+		return errors.Wrapf(ErrCorrupt, "match goes past the end of the %d-byte stream", d.size)
+	}
+	dist := d.reps[0] + 1
+	for ; l > 0; l-- {
+		d.putByte(d.getByte(dist))
+	}
+	return nil
+}
+
+// Read decompresses up to len(buf) bytes. It only stops in between
+// symbols, where the decoder's state can be saved.
+func (d *decoder) Read(buf []byte) (int, error) {
+	for d.pending < len(buf) && d.pending <= d.winSize-matchMaxLen && !d.done() {
+		err := d.decodeSymbol()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	n := d.pending
+	if n > len(buf) {
+		n = len(buf)
+	}
+	// pending bytes are the last ones written to the window, which
+	// may have wrapped around
+	start := d.pos - d.pending
+	if start < 0 {
+		start += len(d.win)
+	}
+	copied := copy(buf[:n], d.win[start:])
+	copy(buf[copied:n], d.win)
+	d.pending -= n
+
+	if n == 0 && d.done() {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// save returns the decoder's state. It must be called in between Reads.
+func (d *decoder) save() *DecoderState {
+	// only save what can be referenced, oldest first
+	var window []byte
+	if d.full {
+		window = append(window, d.win[d.pos:]...)
+	}
+	window = append(window, d.win[:d.pos]...)
+
+	return &DecoderState{
+		Props:    [propsSize]byte{byte((d.props.pb*5+d.props.lp)*9 + d.props.lc)},
+		DictSize: d.props.dictSize,
+		Size:     d.size,
+		Range:    d.rng,
+		Code:     d.code,
+		State:    d.state,
+		Reps:     d.reps,
+		Probs:    append([]uint16(nil), d.probs...),
+		Window:   window,
+		Total:    d.total,
+		Pending:  d.pending,
+		EOS:      d.eos,
+	}
+}
+
+// restoreDecoder returns a decoder in the state `ds` was saved in,
+// reading from r, which must be positioned where it was.
+func restoreDecoder(r io.ByteReader, ds *DecoderState) (*decoder, error) {
+	p, err := parseProps(ds.Props[:])
+	if err != nil {
+		return nil, err
+	}
+	p.dictSize = ds.DictSize
+
+	d := newDecoder(r, p, ds.Size)
+	if len(ds.Probs) != len(d.probs) || len(ds.Window) > d.winSize || ds.Pending > len(ds.Window) {
+		return nil, errors.Wrap(ErrCorrupt, "invalid decoder state")
+	}
+	for len(d.win) < d.winSize && len(d.win) <= len(ds.Window) {
+		d.growWindow()
+	}
+	d.rng = ds.Range
+	d.code = ds.Code
+	d.state = ds.State
+	d.reps = ds.Reps
+	copy(d.probs, ds.Probs)
+	d.pos = copy(d.win, ds.Window)
+	if d.pos == d.winSize {
+		d.pos = 0
+		d.full = true
+	}
+	d.total = ds.Total
+	d.pending = ds.Pending
+	d.eos = ds.EOS
+	return d, nil
+}
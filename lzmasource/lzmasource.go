@@ -0,0 +1,344 @@
+// Package lzmasource decompresses LZMA streams, either in the .lzma
+// format (as written by `xz --format=lzma`), or as stored in zip files
+// (compression method 14). LZMA streams have no block boundaries, so
+// checkpoints hold the whole decoder state, including the part of the
+// dictionary that was filled so far: they can be as large as the
+// dictionary (a few megabytes, usually).
+package lzmasource
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrCorrupt is returned when the compressed data isn't a valid LZMA stream
+var ErrCorrupt = errors.New("lzmasource: corrupt stream")
+
+const (
+	// the .lzma header: properties, then the decompressed size
+	aloneHeaderSize = propsSize + 8
+	// the zip header: LZMA SDK version, size of the properties, then properties
+	zipHeaderSize = 4 + propsSize
+)
+
+type lzmaSource struct {
+	// input
+	source savior.Source
+
+	// params
+	zip     bool
+	zipSize int64
+
+	// internal
+	dec     *decoder
+	offset  int64
+	bytebuf []byte
+
+	ssc              savior.SourceSaveConsumer
+	sourceCheckpoint *savior.SourceCheckpoint
+}
+
+type LzmaSourceCheckpoint struct {
+	SourceCheckpoint *savior.SourceCheckpoint
+	// Roffset is how many bytes of the compressed stream were
+	// consumed, header included
+	Roffset int64
+	Decoder *DecoderState
+}
+
+// DecoderState is everything the LZMA decoder needs to pick up
+// in between two symbols
+type DecoderState struct {
+	Props    [propsSize]byte
+	DictSize uint32
+	Size     int64
+
+	Range uint32
+	Code  uint32
+	State uint32
+	Reps  [4]uint32
+	Probs []uint16
+
+	// Window holds the last decompressed bytes, oldest first
+	Window  []byte
+	Total   int64
+	Pending int
+	EOS     bool
+}
+
+var _ savior.PortableChecker = (*LzmaSourceCheckpoint)(nil)
+
+// Portable returns true if the wrapped source checkpoint is portable
+func (lsc *LzmaSourceCheckpoint) Portable() bool {
+	return lsc.SourceCheckpoint.Portable()
+}
+
+var _ savior.Source = (*lzmaSource)(nil)
+
+// New returns a source that decompresses a .lzma stream, whose header
+// holds the decompressed size, or says it ends with an end marker.
+func New(source savior.Source) *lzmaSource {
+	return &lzmaSource{
+		source:  source,
+		bytebuf: []byte{0x00},
+	}
+}
+
+// NewZip returns a source that decompresses the data of a zip entry
+// compressed with LZMA, which decompresses to `size` bytes. Whether or
+// not it ends with an end marker doesn't matter.
+func NewZip(source savior.Source, size int64) *lzmaSource {
+	ls := New(source)
+	ls.zip = true
+	ls.zipSize = size
+	return ls
+}
+
+func (ls *lzmaSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "lzma",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (ls *lzmaSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	ls.ssc = ssc
+	ls.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			ls.sourceCheckpoint = checkpoint
+			return nil
+		},
+	})
+}
+
+func (ls *lzmaSource) WantSave() {
+	ls.source.WantSave()
+}
+
+func (ls *lzmaSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	savior.Debugf(`lzma: asked to resume`)
+	ls.sourceCheckpoint = nil
+
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*LzmaSourceCheckpoint); ok {
+			sourceOffset, err := ls.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			if sourceOffset < ourCheckpoint.Roffset {
+				delta := ourCheckpoint.Roffset - sourceOffset
+				savior.Debugf(`lzmasource: discarding %d bytes to align source with decoder`, delta)
+				err = savior.DiscardByRead(ls.source, delta)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				sourceOffset += delta
+			}
+
+			if sourceOffset == ourCheckpoint.Roffset {
+				dec, err := restoreDecoder(ls.source, ourCheckpoint.Decoder)
+				if err != nil {
+					savior.Debugf(`lzmasource: could not use decoder state at R=%d: %+v`, ourCheckpoint.Roffset, err)
+				} else {
+					dec.roffset = ourCheckpoint.Roffset
+					ls.dec = dec
+					ls.offset = checkpoint.OutputOffset
+					return ls.offset, nil
+				}
+			} else {
+				savior.Debugf(`lzmasource: expected source to resume at %d but got %d`, ourCheckpoint.Roffset, sourceOffset)
+			}
+		}
+	}
+
+	// start from beginning
+	sourceOffset, err := ls.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("lzmasource: expected source to resume at start but got %d", sourceOffset)
+		return 0, errors.New(msg)
+	}
+
+	ls.dec, err = readHeader(ls.source, ls.zip, ls.zipSize)
+	if err != nil {
+		return 0, err
+	}
+	ls.offset = 0
+	return 0, nil
+}
+
+// readHeader parses the header that comes before the LZMA stream, and
+// returns a decoder for the rest of r
+func readHeader(r io.ByteReader, zip bool, zipSize int64) (*decoder, error) {
+	headerSize := aloneHeaderSize
+	if zip {
+		headerSize = zipHeaderSize
+	}
+
+	header := make([]byte, headerSize)
+	for i := range header {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, errors.Wrap(err, "reading lzma header")
+		}
+		header[i] = b
+	}
+
+	var propsBuf []byte
+	var size int64
+	if zip {
+		if binary.LittleEndian.Uint16(header[2:]) != propsSize {
+			return nil, errors.Wrapf(ErrCorrupt, "unexpected properties size %d", binary.LittleEndian.Uint16(header[2:]))
+		}
+		propsBuf = header[4:]
+		size = zipSize
+	} else {
+		propsBuf = header[:propsSize]
+		size = int64(binary.LittleEndian.Uint64(header[propsSize:]))
+		if size < 0 {
+			// all ones: the stream ends with an end marker
+			size = -1
+		}
+	}
+
+	p, err := parseProps(propsBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := newDecoder(r, p, size)
+	err = dec.initRange()
+	if err != nil {
+		return nil, err
+	}
+	dec.roffset += int64(headerSize)
+	return dec, nil
+}
+
+func (ls *lzmaSource) Read(buf []byte) (int, error) {
+	if ls.dec == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	if ls.sourceCheckpoint != nil {
+		err := ls.save()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := ls.dec.Read(buf)
+	ls.offset += int64(n)
+	return n, err
+}
+
+// save emits a checkpoint. The decoder is always in between two
+// symbols when Read isn't running, so that's any time.
+func (ls *lzmaSource) save() error {
+	sourceCheckpoint := ls.sourceCheckpoint
+	ls.sourceCheckpoint = nil
+
+	if ls.ssc == nil {
+		savior.Debugf("lzmasource: can't save, ssc is nil!")
+		return nil
+	}
+	if ls.dec.err != nil {
+		// a failed read leaves the decoder mid-symbol
+		return nil
+	}
+
+	savior.Debugf("lzmasource: saving, rOffset = %d, sourceCheckpoint.Offset = %d", ls.dec.roffset, sourceCheckpoint.Offset)
+	checkpoint := &savior.SourceCheckpoint{
+		Offset:       ls.dec.roffset,
+		OutputOffset: ls.offset,
+		Data: &LzmaSourceCheckpoint{
+			SourceCheckpoint: sourceCheckpoint,
+			Roffset:          ls.dec.roffset,
+			Decoder:          ls.dec.save(),
+		},
+	}
+	err := ls.ssc.Save(checkpoint)
+	savior.Debugf("lzmasource: saved checkpoint at byte %d", checkpoint.OutputOffset)
+	return err
+}
+
+func (ls *lzmaSource) ReadByte() (byte, error) {
+	if ls.dec == nil {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	n, err := ls.Read(ls.bytebuf)
+	if n == 0 && err == nil {
+		n, err = ls.Read(ls.bytebuf)
+	}
+	if n == 0 && err == nil {
+		err = io.ErrNoProgress
+	}
+	return ls.bytebuf[0], err
+}
+
+func (ls *lzmaSource) Progress() float64 {
+	// the decompressed size isn't always known, the underlying
+	// source's progress is a good enough approximation
+	return ls.source.Progress()
+}
+
+// Close releases the decoder and closes the underlying source
+func (ls *lzmaSource) Close() error {
+	ls.dec = nil
+	return ls.source.Close()
+}
+
+// NewZipReader returns a reader that decompresses the data of a zip
+// entry compressed with LZMA, without checkpoints. It's suitable as
+// a zip decompressor.
+func NewZipReader(r io.Reader, size int64) io.ReadCloser {
+	return &zipReader{r: bufio.NewReader(r), size: size}
+}
+
+type zipReader struct {
+	r    *bufio.Reader
+	size int64
+	dec  *decoder
+	err  error
+}
+
+func (zr *zipReader) Read(buf []byte) (int, error) {
+	if zr.err != nil {
+		return 0, zr.err
+	}
+	if zr.dec == nil {
+		zr.dec, zr.err = readHeader(zr.r, true, zr.size)
+		if zr.err != nil {
+			return 0, zr.err
+		}
+	}
+
+	n, err := zr.dec.Read(buf)
+	if err != nil {
+		zr.err = err
+	}
+	return n, err
+}
+
+func (zr *zipReader) Close() error {
+	zr.dec = nil
+	zr.err = errors.New("lzmasource: read from closed reader")
+	return nil
+}
+
+func init() {
+	savior.RegisterCheckpointData("lzmasource.LzmaSourceCheckpoint", &LzmaSourceCheckpoint{})
+}
@@ -0,0 +1,145 @@
+package lzmasource_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/lzmasource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+func Test_Uninitialized(t *testing.T) {
+	ss := seeksource.FromBytes(nil)
+	_, err := ss.Resume(nil)
+	assert.NoError(t, err)
+
+	ls := lzmasource.New(ss)
+	_, err = ls.Read([]byte{})
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+
+	_, err = ls.ReadByte()
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+}
+
+func Test_Checkpoints(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+	compressed, err := checker.LzmaCompress(reference)
+	must(t, err)
+
+	checker.RunSourceTest(t, lzmasource.New(seeksource.FromBytes(compressed)), reference)
+}
+
+func Test_Short(t *testing.T) {
+	for _, ref := range [][]byte{nil, []byte("a"), []byte("hello"), bytes.Repeat([]byte("abc"), 10000)} {
+		compressed, err := checker.LzmaCompress(ref)
+		must(t, err)
+
+		ls := lzmasource.New(seeksource.FromBytes(compressed))
+		_, err = ls.Resume(nil)
+		must(t, err)
+		output, err := ioutil.ReadAll(ls)
+		must(t, err)
+		assert.EqualValues(t, string(ref), string(output))
+	}
+}
+
+func Test_LargeDictionary(t *testing.T) {
+	reference := semirandom.Bytes(1024 * 1024)
+	compressed, err := checker.LzmaCompress(reference)
+	must(t, err)
+
+	// a 1GiB dictionary, and no size, so the window can't be
+	// sized after the stream
+	binary.LittleEndian.PutUint32(compressed[1:], 1<<30)
+	binary.LittleEndian.PutUint64(compressed[5:], ^uint64(0))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	ls := lzmasource.New(seeksource.FromBytes(compressed))
+	_, err = ls.Resume(nil)
+	must(t, err)
+	output, err := ioutil.ReadAll(ls)
+	must(t, err)
+	runtime.ReadMemStats(&after)
+	assert.True(t, bytes.Equal(reference, output))
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 64*1024*1024, "the window should grow with the stream")
+
+	checker.RunSourceTest(t, lzmasource.New(seeksource.FromBytes(compressed)), reference)
+}
+
+// toZip turns a .lzma stream into the data of a zip entry compressed
+// with LZMA: the LZMA SDK version, the size of the properties, then the
+// properties and the stream, without the decompressed size.
+func toZip(alone []byte) []byte {
+	data := []byte{9, 20, 5, 0}
+	data = append(data, alone[:5]...)
+	return append(data, alone[13:]...)
+}
+
+func Test_Zip(t *testing.T) {
+	reference := semirandom.Bytes(2 * 1024 * 1024)
+	compressed, err := checker.LzmaCompress(reference)
+	must(t, err)
+	data := toZip(compressed)
+
+	checker.RunSourceTest(t, lzmasource.NewZip(seeksource.FromBytes(data), int64(len(reference))), reference)
+
+	output, err := ioutil.ReadAll(lzmasource.NewZipReader(bytes.NewReader(data), int64(len(reference))))
+	must(t, err)
+	assert.True(t, bytes.Equal(reference, output))
+}
+
+func Test_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(256 * 1024)
+	compressed, err := checker.LzmaCompress(reference)
+	must(t, err)
+
+	readAll := func(compressed []byte) error {
+		ls := lzmasource.New(seeksource.FromBytes(compressed))
+		_, err := ls.Resume(nil)
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.ReadAll(ls)
+		return err
+	}
+
+	// truncated
+	err = readAll(compressed[:len(compressed)/2])
+	assert.Error(err)
+	assert.True(errors.Cause(err) == io.ErrUnexpectedEOF)
+
+	// invalid properties
+	bad := append([]byte(nil), compressed...)
+	bad[0] = 0xff
+	err = readAll(bad)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == lzmasource.ErrCorrupt)
+
+	// the end marker comes before the size in the header
+	bad = append([]byte(nil), compressed...)
+	binary.LittleEndian.PutUint64(bad[5:], uint64(len(reference)+1))
+	err = readAll(bad)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == lzmasource.ErrCorrupt)
+}
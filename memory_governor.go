@@ -0,0 +1,117 @@
+package savior
+
+import (
+	"time"
+
+	"github.com/itchio/headway/united"
+	"github.com/pkg/errors"
+)
+
+// ErrMemoryUnsupported is returned when querying available system memory
+// on platforms where we don't know how to.
+var ErrMemoryUnsupported = errors.New("can't query available memory on this platform")
+
+// availableMemory is a variable so tests can simulate memory pressure
+var availableMemory = systemAvailableMemory
+
+// memoryPollInterval is how often available memory is checked again
+// while extraction is paused
+var memoryPollInterval = 500 * time.Millisecond
+
+// memoryCheckInterval is how many bytes can be extracted between two
+// checks of available memory
+const memoryCheckInterval = 1024 * 1024
+
+// GovernSaveConsumer returns a SaveConsumer that pauses extraction while
+// available system memory is low: once it drops below `lowWatermark` bytes,
+// a checkpoint is requested, and after it's passed to `inner`, Save doesn't
+// return until available memory is back above `highWatermark` bytes.
+// Memory is checked every megabyte or so of extracted data.
+//
+// A `lowWatermark` of 0 or less disables the governor, and `inner` is
+// returned as-is. If available memory can't be queried on this platform,
+// the governor does nothing. See Extractor.SetMemoryGovernor.
+func GovernSaveConsumer(inner SaveConsumer, lowWatermark int64, highWatermark int64) SaveConsumer {
+	if lowWatermark <= 0 {
+		return inner
+	}
+	if highWatermark < lowWatermark {
+		highWatermark = lowWatermark
+	}
+	return &memoryGovernor{
+		inner:         inner,
+		lowWatermark:  lowWatermark,
+		highWatermark: highWatermark,
+	}
+}
+
+type memoryGovernor struct {
+	inner         SaveConsumer
+	lowWatermark  int64
+	highWatermark int64
+
+	// bytes extracted since memory was last checked
+	uncheckedBytes int64
+	// set when memory is low, until the next checkpoint
+	pausing bool
+	// set if available memory can't be queried
+	disabled bool
+}
+
+var _ SaveConsumer = (*memoryGovernor)(nil)
+
+func (mg *memoryGovernor) ShouldSave(copiedBytes int64) bool {
+	// always let the inner consumer know about copied bytes
+	shouldSave := mg.inner.ShouldSave(copiedBytes)
+	if mg.pausing {
+		return true
+	}
+	if mg.disabled {
+		return shouldSave
+	}
+
+	mg.uncheckedBytes += copiedBytes
+	if mg.uncheckedBytes < memoryCheckInterval {
+		return shouldSave
+	}
+	mg.uncheckedBytes = 0
+
+	available, ok := mg.available()
+	if ok && available < mg.lowWatermark {
+		Debugf("savior: only %s of memory available, pausing at the next checkpoint", united.FormatBytes(available))
+		mg.pausing = true
+		return true
+	}
+	return shouldSave
+}
+
+func (mg *memoryGovernor) Save(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error) {
+	action, err := mg.inner.Save(checkpoint)
+	if err != nil || action == AfterSaveStop || !mg.pausing {
+		return action, err
+	}
+	mg.pausing = false
+
+	start := time.Now()
+	for {
+		time.Sleep(memoryPollInterval)
+		available, ok := mg.available()
+		if !ok || available >= mg.highWatermark {
+			Debugf("savior: %s of memory available, resuming after a %s pause", united.FormatBytes(available), time.Since(start))
+			mg.uncheckedBytes = 0
+			return action, nil
+		}
+	}
+}
+
+// available returns available system memory, and false (disabling
+// the governor) if it can't be queried
+func (mg *memoryGovernor) available() (int64, bool) {
+	available, err := availableMemory()
+	if err != nil {
+		Debugf("savior: disabling memory governor: %+v", err)
+		mg.disabled = true
+		return 0, false
+	}
+	return available, true
+}
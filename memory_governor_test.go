@@ -0,0 +1,96 @@
+package savior_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MemoryGovernor(t *testing.T) {
+	data, archives := makeTextArchives(t, 8*1024*1024)
+
+	const (
+		lowWatermark  = 1000
+		highWatermark = 2000
+	)
+
+	for kind, archive := range archives {
+		t.Run(kind, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ms := savior.NewMemorySink()
+			sink := savior.NewCountingSink(ms)
+
+			var mu sync.Mutex
+			saves := 0
+			// the save consumer never asks for checkpoints itself
+			sc := checker.NewTestSaveConsumer(1<<40, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				saves++
+				return savior.AfterSaveContinue, nil
+			})
+
+			// memory drops below the low watermark at the third check,
+			// then goes between the two for a while, then recovers
+			checks := 0
+			polls := 0
+			savesAtPause := 0
+			var pausedBytes int64
+			restore := savior.SetAvailableMemoryFunc(func() (int64, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				checks++
+				written := sink.Stats().Bytes
+				switch {
+				case checks < 3:
+					return 10 * highWatermark, nil
+				case checks == 3:
+					return lowWatermark / 2, nil
+				case polls >= 6:
+					// recovered
+					return 10 * highWatermark, nil
+				}
+
+				// we're being polled while paused
+				polls++
+				if polls == 1 {
+					savesAtPause = saves
+					pausedBytes = written
+				} else {
+					assert.EqualValues(pausedBytes, written, "nothing should be extracted while paused")
+				}
+
+				switch {
+				case polls < 3:
+					return lowWatermark / 2, nil
+				case polls < 6:
+					return (lowWatermark + highWatermark) / 2, nil
+				default:
+					return 10 * highWatermark, nil
+				}
+			}, time.Millisecond)
+			defer restore()
+
+			ex := makeGobTestExtractor(t, kind, archive)
+			ex.SetSaveConsumer(sc)
+			ex.SetMemoryGovernor(lowWatermark, highWatermark)
+			_, err := ex.Resume(nil, sink)
+			tmust(t, err)
+
+			assert.EqualValues(6, polls, "should stay paused until memory is above the high watermark")
+			assert.EqualValues(1, savesAtPause, "should pause right after a checkpoint")
+			assert.True(pausedBytes > 0 && pausedBytes < int64(len(data)), "should pause mid-file")
+
+			actual, _, ok := ms.GetEntry("data.txt")
+			assert.True(ok)
+			assert.True(bytes.Equal(data, actual), "extraction should complete after resuming")
+		})
+	}
+}
@@ -0,0 +1,38 @@
+//+build linux
+
+package savior
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// systemAvailableMemory returns MemAvailable from /proc/meminfo, ie. how
+// much memory can be allocated without swapping, page cache included
+func systemAvailableMemory() (int64, error) {
+	contents, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(contents))
+	for s.Scan() {
+		fields := bytes.Fields(s.Bytes())
+		if len(fields) < 2 || string(fields[0]) != "MemAvailable:" {
+			continue
+		}
+
+		kib, err := strconv.ParseInt(string(fields[1]), 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "parsing MemAvailable")
+		}
+		return kib * 1024, nil
+	}
+
+	// kernels older than 3.14 don't have it
+	return 0, errors.WithStack(ErrMemoryUnsupported)
+}
@@ -0,0 +1,9 @@
+//+build !linux,!windows
+
+package savior
+
+import "github.com/pkg/errors"
+
+func systemAvailableMemory() (int64, error) {
+	return 0, errors.WithStack(ErrMemoryUnsupported)
+}
@@ -0,0 +1,266 @@
+package savior
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemorySink keeps everything extracted to it in memory, which is handy
+// for tests, and for pipelines that transform entries before storing them
+// elsewhere. Like FolderSink, it honors WriteOffset, so extractions into it
+// can be resumed. Its zero value is ready to use, and it's safe to use
+// from several goroutines.
+type MemorySink struct {
+	mu sync.Mutex
+
+	entries map[string]*memoryEntry
+	// sizes entries were preallocated with, by canonical path
+	preallocated map[string]int64
+}
+
+type memoryEntry struct {
+	entry Entry
+	data  []byte
+}
+
+var _ Sink = (*MemorySink)(nil)
+var _ ResumeOffsetter = (*MemorySink)(nil)
+var _ HardlinkSink = (*MemorySink)(nil)
+var _ EntryRemover = (*MemorySink)(nil)
+
+// NewMemorySink returns an empty MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// GetEntry returns a copy of what was extracted at `canonicalPath`:
+// the contents of a file (or hardlink), the target of a symlink, or nothing for
+// directories. The last return value is false if nothing was.
+func (ms *MemorySink) GetEntry(canonicalPath string) ([]byte, *Entry, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	me, ok := ms.entries[canonicalPath]
+	if !ok {
+		return nil, nil, false
+	}
+
+	entry := me.entry
+	var data []byte
+	switch entry.Kind {
+	case EntryKindFile, EntryKindHardlink:
+		data = append([]byte{}, me.data...)
+	case EntryKindSymlink:
+		data = []byte(entry.Linkname)
+	}
+	return data, &entry, true
+}
+
+// Paths returns the canonical paths of everything extracted, sorted
+func (ms *MemorySink) Paths() []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var paths []string
+	for p := range ms.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Preallocated returns the size the entry at `canonicalPath` was
+// preallocated with, and whether it was preallocated at all
+func (ms *MemorySink) Preallocated(canonicalPath string) (int64, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	size, ok := ms.preallocated[canonicalPath]
+	return size, ok
+}
+
+// put records an entry, replacing whatever was there. Must be
+// called with the lock held.
+func (ms *MemorySink) put(entry *Entry, data []byte) (*memoryEntry, error) {
+	if ms.entries == nil {
+		ms.entries = make(map[string]*memoryEntry)
+	}
+
+	if entry.Kind != EntryKindDir {
+		if previous, ok := ms.entries[entry.CanonicalPath]; ok && previous.entry.Kind == EntryKindDir {
+			return nil, errors.Wrapf(ErrPathConflict, "%s", entry.CanonicalPath)
+		}
+	}
+
+	me := &memoryEntry{
+		entry: *entry,
+		data:  data,
+	}
+	me.entry.WriteOffset = 0
+	ms.entries[entry.CanonicalPath] = me
+	return me, nil
+}
+
+func (ms *MemorySink) Mkdir(entry *Entry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if me, ok := ms.entries[entry.CanonicalPath]; ok && me.entry.Kind == EntryKindDir {
+		return nil
+	}
+	_, err := ms.put(entry, nil)
+	return err
+}
+
+func (ms *MemorySink) Symlink(entry *Entry, linkname string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if linkname == "" {
+		return errors.Wrapf(ErrEmptySymlinkTarget, "%s", entry.CanonicalPath)
+	}
+
+	me, err := ms.put(entry, nil)
+	if err != nil {
+		return err
+	}
+	me.entry.Linkname = linkname
+	return nil
+}
+
+// Hardlink copies the file at `target`, which must be in memory already
+func (ms *MemorySink) Hardlink(entry *Entry, target string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	te, ok := ms.entries[target]
+	if !ok || te.entry.Kind != EntryKindFile {
+		return errors.Errorf("%s: hardlink target %s isn't a file", entry.CanonicalPath, target)
+	}
+
+	me, err := ms.put(entry, append([]byte{}, te.data...))
+	if err != nil {
+		return err
+	}
+	me.entry.Linkname = target
+	return nil
+}
+
+// GetWriter returns a writer at entry.WriteOffset, anything after
+// it is discarded. Missing bytes before it (if the entry was never
+// written this far) read as zeros.
+func (ms *MemorySink) GetWriter(entry *Entry) (EntryWriter, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var data []byte
+	if me, ok := ms.entries[entry.CanonicalPath]; ok && me.entry.Kind == EntryKindFile {
+		data = me.data
+	}
+	if int64(len(data)) > entry.WriteOffset {
+		data = data[:entry.WriteOffset]
+	} else if int64(len(data)) < entry.WriteOffset {
+		data = append(data, make([]byte, entry.WriteOffset-int64(len(data)))...)
+	}
+
+	me, err := ms.put(entry, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryEntryWriter{
+		ms:    ms,
+		me:    me,
+		entry: entry,
+	}, nil
+}
+
+// Preallocate records the entry (empty) along with its size, unless
+// it's unknown (see Entry.SizeKnown)
+func (ms *MemorySink) Preallocate(entry *Entry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	_, err := ms.put(entry, nil)
+	if err != nil {
+		return err
+	}
+	if !entry.SizeKnown() {
+		return nil
+	}
+
+	if ms.preallocated == nil {
+		ms.preallocated = make(map[string]int64)
+	}
+	ms.preallocated[entry.CanonicalPath] = entry.UncompressedSize
+	return nil
+}
+
+// ResumeOffset returns how much of the entry is in memory
+func (ms *MemorySink) ResumeOffset(entry *Entry) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	me, ok := ms.entries[entry.CanonicalPath]
+	if !ok || me.entry.Kind != EntryKindFile {
+		return 0, nil
+	}
+	if size := int64(len(me.data)); size < entry.WriteOffset {
+		return size, nil
+	}
+	return entry.WriteOffset, nil
+}
+
+// RemoveEntry forgets the entry, unless it's a directory
+func (ms *MemorySink) RemoveEntry(entry *Entry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if me, ok := ms.entries[entry.CanonicalPath]; ok && me.entry.Kind != EntryKindDir {
+		delete(ms.entries, entry.CanonicalPath)
+		delete(ms.preallocated, entry.CanonicalPath)
+	}
+	return nil
+}
+
+// Nuke forgets everything
+func (ms *MemorySink) Nuke() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.entries = nil
+	ms.preallocated = nil
+	return nil
+}
+
+// Close does nothing, writes are never pending
+func (ms *MemorySink) Close() error {
+	return nil
+}
+
+type memoryEntryWriter struct {
+	ms    *MemorySink
+	me    *memoryEntry
+	entry *Entry
+}
+
+var _ EntryWriter = (*memoryEntryWriter)(nil)
+
+func (mew *memoryEntryWriter) Write(buf []byte) (int, error) {
+	mew.ms.mu.Lock()
+	defer mew.ms.mu.Unlock()
+
+	mew.me.data = append(mew.me.data, buf...)
+	mew.entry.WriteOffset += int64(len(buf))
+	return len(buf), nil
+}
+
+func (mew *memoryEntryWriter) Close() error {
+	return nil
+}
+
+func (mew *memoryEntryWriter) Sync() error {
+	return nil
+}
@@ -0,0 +1,391 @@
+//+build go1.16
+
+package savior
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errNotDir          = errors.New("not a directory")
+	errIsDir           = errors.New("is a directory")
+	errTooManySymlinks = errors.New("too many levels of symbolic links")
+)
+
+// FS returns a read-only view of everything extracted to the sink so far,
+// as an fs.FS that also implements fs.StatFS, fs.ReadDirFS and fs.ReadFileFS.
+// Parent directories of entries are there even if the extractor never
+// created them.
+//
+// Symlinks are listed as such by ReadDir and Lstat, and followed by Open,
+// Stat, ReadDir and ReadFile, as long as they point within the tree.
+// Symlinks to absolute paths, or outside of the tree, don't resolve.
+//
+// The view is a snapshot: entries extracted afterwards don't show up in it,
+// call FS again for that.
+func (ms *MemorySink) FS() fs.FS {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	mfs := &memoryFS{
+		nodes: map[string]*memoryNode{
+			".": {
+				mode: fs.ModeDir | DirMode,
+			},
+		},
+	}
+
+	var paths []string
+	for p := range ms.entries {
+		paths = append(paths, p)
+	}
+	// parents before children
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		me := ms.entries[p]
+		name := strings.TrimSuffix(p, "/")
+		if name == "" || name == "." || !fs.ValidPath(name) {
+			continue
+		}
+
+		node := &memoryNode{
+			modTime: me.entry.ModTime,
+		}
+		perm := me.entry.Mode.Perm()
+		switch me.entry.Kind {
+		case EntryKindDir:
+			if perm == 0 {
+				perm = DirMode
+			}
+			node.mode = fs.ModeDir | perm
+		case EntryKindSymlink:
+			node.mode = fs.ModeSymlink | 0777
+			node.linkname = me.entry.Linkname
+		default:
+			if perm == 0 {
+				perm = 0644
+			}
+			node.mode = perm
+			node.data = append([]byte{}, me.data...)
+		}
+
+		mfs.add(name, node)
+	}
+
+	for _, node := range mfs.nodes {
+		sort.Strings(node.children)
+	}
+	return mfs
+}
+
+type memoryFS struct {
+	nodes map[string]*memoryNode
+}
+
+type memoryNode struct {
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte
+	linkname string
+	// names of children, for directories
+	children []string
+}
+
+var _ fs.StatFS = (*memoryFS)(nil)
+var _ fs.ReadDirFS = (*memoryFS)(nil)
+var _ fs.ReadFileFS = (*memoryFS)(nil)
+
+// add adds a node, along with any missing parent directories. Nodes under
+// something that isn't a directory are left out.
+func (mfs *memoryFS) add(name string, node *memoryNode) {
+	dir := path.Dir(name)
+	parent, ok := mfs.nodes[dir]
+	if !ok {
+		parent = &memoryNode{
+			mode: fs.ModeDir | DirMode,
+		}
+		mfs.add(dir, parent)
+		if mfs.nodes[dir] != parent {
+			return
+		}
+	}
+	if !parent.mode.IsDir() {
+		return
+	}
+
+	if previous, ok := mfs.nodes[name]; ok {
+		if previous.mode.IsDir() && node.mode.IsDir() {
+			// an implicit directory turned out to be in the sink,
+			// keep the children we know of
+			node.children = previous.children
+		} else {
+			return
+		}
+	} else {
+		parent.children = append(parent.children, path.Base(name))
+	}
+	mfs.nodes[name] = node
+}
+
+// lookup finds the node for `name`, and the path it's at, following
+// symlinks along the way, and the last one too if `followLast` is set
+func (mfs *memoryFS) lookup(op string, name string, followLast bool) (string, *memoryNode, error) {
+	if !fs.ValidPath(name) {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	notExist := &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+
+	var parts []string
+	if name != "." {
+		parts = strings.Split(name, "/")
+	}
+	cur := "."
+	hops := 0
+	for i := 0; i < len(parts); i++ {
+		p := path.Join(cur, parts[i])
+		node, ok := mfs.nodes[p]
+		if !ok {
+			return "", nil, notExist
+		}
+
+		last := i == len(parts)-1
+		if node.mode&fs.ModeSymlink != 0 && (!last || followLast) {
+			hops++
+			if hops > maxSymlinkHops {
+				return "", nil, &fs.PathError{Op: op, Path: name, Err: errTooManySymlinks}
+			}
+
+			target := path.Join(path.Dir(p), node.linkname)
+			if path.IsAbs(node.linkname) || target == ".." || strings.HasPrefix(target, "../") {
+				return "", nil, notExist
+			}
+
+			// start over from the target
+			rest := parts[i+1:]
+			parts = nil
+			if target != "." {
+				parts = strings.Split(target, "/")
+			}
+			parts = append(parts, rest...)
+			i = -1
+			cur = "."
+			continue
+		}
+
+		if !last && !node.mode.IsDir() {
+			return "", nil, notExist
+		}
+		cur = p
+	}
+	return cur, mfs.nodes[cur], nil
+}
+
+func (mfs *memoryFS) Open(name string) (fs.File, error) {
+	_, node, err := mfs.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &memoryFileInfo{name: path.Base(name), node: node}
+	if node.mode.IsDir() {
+		entries, err := mfs.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &memoryDir{info: info, entries: entries}, nil
+	}
+	return &memoryFile{info: info, r: bytes.NewReader(node.data)}, nil
+}
+
+func (mfs *memoryFS) Stat(name string) (fs.FileInfo, error) {
+	_, node, err := mfs.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryFileInfo{name: path.Base(name), node: node}, nil
+}
+
+// Lstat is like Stat, but doesn't follow the symlink at `name`, if any
+func (mfs *memoryFS) Lstat(name string) (fs.FileInfo, error) {
+	_, node, err := mfs.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryFileInfo{name: path.Base(name), node: node}, nil
+}
+
+// ReadLink returns the target of the symlink at `name`
+func (mfs *memoryFS) ReadLink(name string) (string, error) {
+	_, node, err := mfs.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.linkname, nil
+}
+
+// ReadDir lists a directory, sorted by name. Symlinks in it are listed
+// as symlinks, not as what they point to.
+func (mfs *memoryFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, node, err := mfs.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(node.children))
+	for _, child := range node.children {
+		entries = append(entries, &memoryDirEntry{
+			info: &memoryFileInfo{
+				name: child,
+				node: mfs.nodes[path.Join(dir, child)],
+			},
+		})
+	}
+	return entries, nil
+}
+
+func (mfs *memoryFS) ReadFile(name string) ([]byte, error) {
+	_, node, err := mfs.lookup("readfile", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errIsDir}
+	}
+	return append([]byte{}, node.data...), nil
+}
+
+type memoryFileInfo struct {
+	name string
+	node *memoryNode
+}
+
+var _ fs.FileInfo = (*memoryFileInfo)(nil)
+
+func (mfi *memoryFileInfo) Name() string {
+	return mfi.name
+}
+
+func (mfi *memoryFileInfo) Size() int64 {
+	if mfi.node.mode&fs.ModeSymlink != 0 {
+		return int64(len(mfi.node.linkname))
+	}
+	return int64(len(mfi.node.data))
+}
+
+func (mfi *memoryFileInfo) Mode() fs.FileMode {
+	return mfi.node.mode
+}
+
+func (mfi *memoryFileInfo) ModTime() time.Time {
+	return mfi.node.modTime
+}
+
+func (mfi *memoryFileInfo) IsDir() bool {
+	return mfi.node.mode.IsDir()
+}
+
+func (mfi *memoryFileInfo) Sys() interface{} {
+	return nil
+}
+
+type memoryDirEntry struct {
+	info *memoryFileInfo
+}
+
+var _ fs.DirEntry = (*memoryDirEntry)(nil)
+
+func (mde *memoryDirEntry) Name() string {
+	return mde.info.Name()
+}
+
+func (mde *memoryDirEntry) IsDir() bool {
+	return mde.info.IsDir()
+}
+
+func (mde *memoryDirEntry) Type() fs.FileMode {
+	return mde.info.Mode().Type()
+}
+
+func (mde *memoryDirEntry) Info() (fs.FileInfo, error) {
+	return mde.info, nil
+}
+
+type memoryFile struct {
+	info *memoryFileInfo
+	r    *bytes.Reader
+}
+
+var _ io.ReadSeeker = (*memoryFile)(nil)
+var _ io.ReaderAt = (*memoryFile)(nil)
+
+func (mf *memoryFile) Stat() (fs.FileInfo, error) {
+	return mf.info, nil
+}
+
+func (mf *memoryFile) Read(buf []byte) (int, error) {
+	return mf.r.Read(buf)
+}
+
+func (mf *memoryFile) ReadAt(buf []byte, off int64) (int, error) {
+	return mf.r.ReadAt(buf, off)
+}
+
+func (mf *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	return mf.r.Seek(offset, whence)
+}
+
+func (mf *memoryFile) Close() error {
+	return nil
+}
+
+type memoryDir struct {
+	info    *memoryFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+var _ fs.ReadDirFile = (*memoryDir)(nil)
+
+func (md *memoryDir) Stat() (fs.FileInfo, error) {
+	return md.info, nil
+}
+
+func (md *memoryDir) Read(buf []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: md.info.name, Err: errIsDir}
+}
+
+func (md *memoryDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := md.entries[md.offset:]
+	if n <= 0 {
+		md.offset = len(md.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	md.offset += n
+	return remaining[:n], nil
+}
+
+func (md *memoryDir) Close() error {
+	return nil
+}
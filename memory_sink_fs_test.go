@@ -0,0 +1,107 @@
+//+build go1.16
+
+package savior_test
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MemorySinkFS(t *testing.T) {
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	tmust(t, err)
+	ms := savior.NewMemorySink()
+	_, err = ex.Resume(nil, ms)
+	tmust(t, err)
+
+	fsys := ms.FS()
+
+	var expected []string
+	for _, item := range sink.Items {
+		if item.Entry.Kind != savior.EntryKindFile {
+			continue
+		}
+		expected = append(expected, item.Entry.CanonicalPath)
+
+		data, err := fs.ReadFile(fsys, item.Entry.CanonicalPath)
+		tmust(t, err)
+		assert.True(t, bytes.Equal(item.Data, data), "%s should have the right contents", item.Entry.CanonicalPath)
+	}
+	tmust(t, fstest.TestFS(fsys, expected...))
+}
+
+func Test_MemorySinkFSSymlinks(t *testing.T) {
+	assert := assert.New(t)
+
+	ms := savior.NewMemorySink()
+	file := func(canonicalPath string, contents string) {
+		w, err := ms.GetWriter(&savior.Entry{
+			Kind:          savior.EntryKindFile,
+			CanonicalPath: canonicalPath,
+			Mode:          0644,
+		})
+		tmust(t, err)
+		_, err = w.Write([]byte(contents))
+		tmust(t, err)
+	}
+	symlink := func(canonicalPath string, target string) {
+		tmust(t, ms.Symlink(&savior.Entry{
+			Kind:          savior.EntryKindSymlink,
+			CanonicalPath: canonicalPath,
+		}, target))
+	}
+
+	// no Mkdir for "a" or "a/b", they're implied
+	file("a/b/file.txt", "hello")
+	file("top.txt", "top")
+	symlink("a/link-to-file", "b/file.txt")
+	symlink("a/link-to-dir", "b")
+	symlink("a/b/link-up", "../../top.txt")
+	symlink("link-chain", "a/link-to-dir/link-up")
+
+	fsys := ms.FS()
+	tmust(t, fstest.TestFS(fsys, "a/b/file.txt", "top.txt", "a/link-to-file", "link-chain"))
+
+	data, err := fs.ReadFile(fsys, "a/link-to-dir/file.txt")
+	tmust(t, err)
+	assert.Equal("hello", string(data))
+
+	data, err = fs.ReadFile(fsys, "link-chain")
+	tmust(t, err)
+	assert.Equal("top", string(data))
+
+	entries, err := fs.ReadDir(fsys, "a")
+	tmust(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+		if e.Name() == "link-to-dir" {
+			assert.True(e.Type()&fs.ModeSymlink != 0, "ReadDir lists symlinks as such")
+		}
+	}
+	assert.EqualValues([]string{"b", "link-to-dir", "link-to-file"}, names)
+
+	stats, err := fs.Stat(fsys, "a/link-to-dir")
+	tmust(t, err)
+	assert.True(stats.IsDir())
+
+	// links that escape the tree don't resolve
+	escaping := savior.NewMemorySink()
+	tmust(t, escaping.Symlink(&savior.Entry{Kind: savior.EntryKindSymlink, CanonicalPath: "up"}, "../outside"))
+	tmust(t, escaping.Symlink(&savior.Entry{Kind: savior.EntryKindSymlink, CanonicalPath: "abs"}, "/etc/passwd"))
+	tmust(t, escaping.Symlink(&savior.Entry{Kind: savior.EntryKindSymlink, CanonicalPath: "loop"}, "loop"))
+	for _, name := range []string{"up", "abs", "loop"} {
+		_, err = fs.Stat(escaping.FS(), name)
+		assert.Error(err, "%s shouldn't resolve", name)
+	}
+}
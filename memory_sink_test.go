@@ -0,0 +1,169 @@
+package savior_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MemorySink(t *testing.T) {
+	assert := assert.New(t)
+
+	ms := savior.NewMemorySink()
+
+	tmust(t, ms.Mkdir(&savior.Entry{
+		Kind:          savior.EntryKindDir,
+		CanonicalPath: "dir",
+		Mode:          0755,
+	}))
+	tmust(t, ms.Symlink(&savior.Entry{
+		Kind:          savior.EntryKindSymlink,
+		CanonicalPath: "link",
+	}, "dir/file"))
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "dir/file",
+		Mode:             0755,
+		UncompressedSize: 11,
+	}
+	tmust(t, ms.Preallocate(entry))
+	size, ok := ms.Preallocated("dir/file")
+	assert.True(ok)
+	assert.EqualValues(11, size)
+
+	w, err := ms.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("hello there"))
+	tmust(t, err)
+	assert.EqualValues(11, entry.WriteOffset)
+
+	// resuming overwrites everything past WriteOffset
+	entry.WriteOffset = 6
+	offset, err := ms.ResumeOffset(entry)
+	tmust(t, err)
+	assert.EqualValues(6, offset)
+	w, err = ms.GetWriter(entry)
+	tmust(t, err)
+	_, err = w.Write([]byte("world"))
+	tmust(t, err)
+
+	data, e, ok := ms.GetEntry("dir/file")
+	assert.True(ok)
+	assert.Equal("hello world", string(data))
+	assert.EqualValues(0755, e.Mode)
+
+	data, e, ok = ms.GetEntry("link")
+	assert.True(ok)
+	assert.EqualValues(savior.EntryKindSymlink, e.Kind)
+	assert.Equal("dir/file", string(data))
+
+	_, e, ok = ms.GetEntry("dir")
+	assert.True(ok)
+	assert.EqualValues(savior.EntryKindDir, e.Kind)
+
+	_, _, ok = ms.GetEntry("nope")
+	assert.False(ok)
+
+	assert.EqualValues([]string{"dir", "dir/file", "link"}, ms.Paths())
+
+	_, err = ms.GetWriter(&savior.Entry{
+		Kind:          savior.EntryKindFile,
+		CanonicalPath: "dir",
+	})
+	assert.Equal(savior.ErrPathConflict, errors.Cause(err))
+
+	tmust(t, ms.Nuke())
+	assert.Empty(ms.Paths())
+}
+
+func Test_MemorySinkConcurrentWriters(t *testing.T) {
+	ms := savior.NewMemorySink()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry := &savior.Entry{
+				Kind:          savior.EntryKindFile,
+				CanonicalPath: fmt.Sprintf("file-%d", i),
+			}
+			w, err := ms.GetWriter(entry)
+			tmust(t, err)
+			for j := 0; j < 100; j++ {
+				_, err = w.Write([]byte{byte(i)})
+				tmust(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 16; i++ {
+		data, _, ok := ms.GetEntry(fmt.Sprintf("file-%d", i))
+		assert.True(t, ok)
+		assert.True(t, bytes.Equal(bytes.Repeat([]byte{byte(i)}, 100), data))
+	}
+}
+
+func Test_MemorySinkExtractWithResumes(t *testing.T) {
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	ms := savior.NewMemorySink()
+	var c *savior.ExtractorCheckpoint
+	// the save consumer outlives extractors, so its byte counter does
+	// too, and we only stop at checkpoints that made progress
+	sc := checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		if c != nil && checkpoint.Progress <= c.Progress {
+			return savior.AfterSaveContinue, nil
+		}
+		buf, err := savior.MarshalCheckpoint(checkpoint)
+		if err != nil {
+			return savior.AfterSaveContinue, err
+		}
+		c, err = savior.UnmarshalCheckpoint(buf)
+		if err != nil {
+			return savior.AfterSaveContinue, err
+		}
+		return savior.AfterSaveStop, nil
+	})
+
+	numResumes := 0
+	for {
+		if numResumes > 128 {
+			t.Fatal("too many resumes, something must be wrong")
+		}
+
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		ex.SetSaveConsumer(sc)
+
+		_, err = ex.Resume(c, ms)
+		if errors.Cause(err) == savior.ErrStop {
+			numResumes++
+			continue
+		}
+		tmust(t, err)
+		break
+	}
+	assert.True(t, numResumes > 0)
+
+	for _, item := range sink.Items {
+		if item.Entry.Kind != savior.EntryKindFile {
+			continue
+		}
+		data, entry, ok := ms.GetEntry(item.Entry.CanonicalPath)
+		assert.True(t, ok, "%s should be extracted", item.Entry.CanonicalPath)
+		assert.True(t, bytes.Equal(item.Data, data), "%s should have the right contents", item.Entry.CanonicalPath)
+		assert.EqualValues(t, os.FileMode(0644), entry.Mode)
+	}
+}
@@ -0,0 +1,41 @@
+//+build windows
+
+package savior
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	modkernel32              = syscall.NewLazyDLL("kernel32.dll")
+	procGlobalMemoryStatusEx = modkernel32.NewProc("GlobalMemoryStatusEx")
+)
+
+// memoryStatusEx is MEMORYSTATUSEX
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// systemAvailableMemory returns the amount of physical memory that
+// can be used without writing anything to disk
+func systemAvailableMemory() (int64, error) {
+	var ms memoryStatusEx
+	ms.Length = uint32(unsafe.Sizeof(ms))
+
+	r1, _, e1 := syscall.Syscall(procGlobalMemoryStatusEx.Addr(), 1, uintptr(unsafe.Pointer(&ms)), 0, 0)
+	if r1 == 0 {
+		return 0, errors.WithStack(e1)
+	}
+	return int64(ms.AvailPhys), nil
+}
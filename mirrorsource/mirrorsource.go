@@ -0,0 +1,229 @@
+package mirrorsource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"sync"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/pkg/errors"
+)
+
+// ErrAllMirrorsFailed is returned when a read could not be served
+// by any of the mirrors.
+var ErrAllMirrorsFailed = errors.New("all mirrors failed")
+
+// ErrChecksumMismatch is returned (wrapped) when a mirror serves a chunk
+// whose hash doesn't match the one passed to SetChunkHashes.
+var ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+
+// A ReaderAtMirror is one copy of the data, for example the same
+// file served by another CDN.
+type ReaderAtMirror struct {
+	// Name is only used in error messages
+	Name     string
+	ReaderAt io.ReaderAt
+}
+
+type mirrorSource struct {
+	savior.SeekSource
+
+	// input
+	mirrors []ReaderAtMirror
+	size    int64
+
+	// verification
+	chunkSize   int64
+	chunkHashes [][]byte
+
+	// internal
+	mu      sync.Mutex
+	current int
+
+	// last verified chunk, so small reads don't hash the same chunk over and over
+	cachedIndex int64
+	cachedChunk []byte
+}
+
+var _ savior.SeekSource = (*mirrorSource)(nil)
+var _ io.ReaderAt = (*mirrorSource)(nil)
+
+// New returns a source that reads from the first of `mirrors`, and fails
+// over to the next ones whenever a read fails (or a chunk doesn't match its
+// hash, see SetChunkHashes). The mirror that served the last successful read
+// is tried first next time.
+//
+// All mirrors must hold the same `size` bytes, so offsets (and checkpoints)
+// are the same no matter which mirror ends up being used.
+func New(mirrors []ReaderAtMirror, size int64) *mirrorSource {
+	ms := &mirrorSource{
+		mirrors:     mirrors,
+		size:        size,
+		cachedIndex: -1,
+	}
+	ms.SeekSource = seeksource.NewWithSize(io.NewSectionReader(ms, 0, size), size)
+	return ms
+}
+
+// Close closes the mirrors that are io.Closers, and returns
+// the first error encountered, if any.
+func (ms *mirrorSource) Close() error {
+	var firstErr error
+	for _, mirror := range ms.mirrors {
+		if c, ok := mirror.ReaderAt.(io.Closer); ok {
+			err := c.Close()
+			if err != nil && firstErr == nil {
+				firstErr = errors.Wrapf(err, "closing mirror %s", mirror.Name)
+			}
+		}
+	}
+	return firstErr
+}
+
+// SetChunkHashes enables verification: the data is split into chunks of
+// `chunkSize` bytes (the last one may be shorter), and `hashes[i]` is the
+// SHA-256 of the i-th chunk. Reads are then done a chunk at a time, and a
+// mirror serving a chunk that doesn't match is treated as a failed mirror.
+func (ms *mirrorSource) SetChunkHashes(chunkSize int64, hashes [][]byte) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.chunkSize = chunkSize
+	ms.chunkHashes = hashes
+	ms.cachedIndex = -1
+	ms.cachedChunk = nil
+}
+
+func (ms *mirrorSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "mirror",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+// ReadAt reads from the current mirror, failing over to the others
+// as needed.
+func (ms *mirrorSource) ReadAt(buf []byte, off int64) (int, error) {
+	if off >= ms.size {
+		return 0, io.EOF
+	}
+
+	var eof error
+	if off+int64(len(buf)) > ms.size {
+		buf = buf[:ms.size-off]
+		eof = io.EOF
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.chunkSize <= 0 {
+		err := ms.readFull(buf, off)
+		if err != nil {
+			return 0, err
+		}
+		return len(buf), eof
+	}
+
+	n := 0
+	for n < len(buf) {
+		pos := off + int64(n)
+		index := pos / ms.chunkSize
+		chunk, err := ms.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(buf[n:], chunk[pos-index*ms.chunkSize:])
+	}
+	return n, eof
+}
+
+// readFull fills buf from offset off, trying every mirror in turn,
+// starting with the current one. It must be called with mu held.
+func (ms *mirrorSource) readFull(buf []byte, off int64) error {
+	return ms.tryMirrors(func(m ReaderAtMirror) error {
+		return readAtFull(m.ReaderAt, buf, off)
+	}, off)
+}
+
+// chunk returns the contents of the chunk at `index`, verified against
+// its hash. It must be called with mu held.
+func (ms *mirrorSource) chunk(index int64) ([]byte, error) {
+	if index == ms.cachedIndex {
+		return ms.cachedChunk, nil
+	}
+
+	if index >= int64(len(ms.chunkHashes)) {
+		return nil, errors.Errorf("mirrorsource: no hash for chunk %d", index)
+	}
+
+	start := index * ms.chunkSize
+	end := start + ms.chunkSize
+	if end > ms.size {
+		end = ms.size
+	}
+
+	chunk := make([]byte, end-start)
+	err := ms.tryMirrors(func(m ReaderAtMirror) error {
+		err := readAtFull(m.ReaderAt, chunk, start)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(chunk)
+		if !bytes.Equal(sum[:], ms.chunkHashes[index]) {
+			return errors.Wrapf(ErrChecksumMismatch, "chunk %d", index)
+		}
+		return nil
+	}, start)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.cachedIndex = index
+	ms.cachedChunk = chunk
+	return chunk, nil
+}
+
+// tryMirrors calls `read` with each mirror, starting from the current one,
+// until one succeeds, which becomes the current mirror.
+func (ms *mirrorSource) tryMirrors(read func(m ReaderAtMirror) error, off int64) error {
+	if len(ms.mirrors) == 0 {
+		return errors.WithStack(ErrAllMirrorsFailed)
+	}
+
+	var lastErr error
+	for i := 0; i < len(ms.mirrors); i++ {
+		index := (ms.current + i) % len(ms.mirrors)
+		m := ms.mirrors[index]
+
+		err := read(m)
+		if err == nil {
+			if index != ms.current {
+				savior.Debugf("mirrorsource: failing over to mirror %d (%s) at offset %d", index, m.Name, off)
+				ms.current = index
+			}
+			return nil
+		}
+
+		savior.Debugf("mirrorsource: mirror %d (%s) failed at offset %d: %+v", index, m.Name, off, err)
+		lastErr = errors.Wrapf(err, "mirror %d (%s)", index, m.Name)
+	}
+
+	return errors.Wrapf(ErrAllMirrorsFailed, "at offset %d, last error: %v", off, lastErr)
+}
+
+// readAtFull is like r.ReadAt, except that reading all of buf and
+// hitting the end of r at the same time isn't an error.
+func readAtFull(r io.ReaderAt, buf []byte, off int64) error {
+	n, err := r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
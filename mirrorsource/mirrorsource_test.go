@@ -0,0 +1,122 @@
+package mirrorsource_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/mirrorsource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingReaderAt serves `data` until `failAfter` bytes have been read,
+// then fails every read.
+type failingReaderAt struct {
+	data      []byte
+	failAfter int64
+	read      int64
+}
+
+func (fra *failingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	if fra.read >= fra.failAfter {
+		return 0, errors.New("mirror went away")
+	}
+	n, err := bytes.NewReader(fra.data).ReadAt(buf, off)
+	fra.read += int64(n)
+	return n, err
+}
+
+// corruptReaderAt serves `data` with a single byte flipped at `offset`.
+type corruptReaderAt struct {
+	data   []byte
+	offset int64
+}
+
+func (cra *corruptReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	n, err := bytes.NewReader(cra.data).ReadAt(buf, off)
+	if cra.offset >= off && cra.offset < off+int64(n) {
+		buf[cra.offset-off] ^= 0xff
+	}
+	return n, err
+}
+
+func chunkHashes(data []byte, chunkSize int) [][]byte {
+	var hashes [][]byte
+	for start := 0; start < len(data); start += chunkSize {
+		end := start + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha256.Sum256(data[start:end])
+		hashes = append(hashes, sum[:])
+	}
+	return hashes
+}
+
+func Test_FailoverOnError(t *testing.T) {
+	reference := semirandom.Bytes(4*1024*1024 + 17)
+	size := int64(len(reference))
+
+	primary := &failingReaderAt{data: reference, failAfter: size / 3}
+	ms := mirrorsource.New([]mirrorsource.ReaderAtMirror{
+		{Name: "primary", ReaderAt: primary},
+		{Name: "secondary", ReaderAt: bytes.NewReader(reference)},
+	}, size)
+
+	actual, err := ioutil.ReadAll(io.NewSectionReader(ms, 0, size))
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(reference, actual), "failover must recover the correct bytes")
+
+	// and as a resumable source
+	primary.read = 0
+	checker.RunSourceTest(t, mirrorsource.New([]mirrorsource.ReaderAtMirror{
+		{Name: "primary", ReaderAt: primary},
+		{Name: "secondary", ReaderAt: bytes.NewReader(reference)},
+	}, size), reference)
+}
+
+func Test_FailoverOnChecksumMismatch(t *testing.T) {
+	reference := semirandom.Bytes(1024*1024 + 3)
+	size := int64(len(reference))
+	chunkSize := 64 * 1024
+
+	ms := mirrorsource.New([]mirrorsource.ReaderAtMirror{
+		{Name: "primary", ReaderAt: &corruptReaderAt{data: reference, offset: size / 2}},
+		{Name: "secondary", ReaderAt: bytes.NewReader(reference)},
+	}, size)
+	ms.SetChunkHashes(int64(chunkSize), chunkHashes(reference, chunkSize))
+
+	actual, err := ioutil.ReadAll(io.NewSectionReader(ms, 0, size))
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(reference, actual), "verification must reject the corrupted chunk")
+
+	checker.RunSourceTest(t, ms, reference)
+}
+
+func Test_AllMirrorsFail(t *testing.T) {
+	reference := semirandom.Bytes(256 * 1024)
+	size := int64(len(reference))
+	chunkSize := 16 * 1024
+
+	ms := mirrorsource.New([]mirrorsource.ReaderAtMirror{
+		{Name: "corrupt", ReaderAt: &corruptReaderAt{data: reference, offset: 1000}},
+		{Name: "dead", ReaderAt: &failingReaderAt{data: reference}},
+	}, size)
+	ms.SetChunkHashes(int64(chunkSize), chunkHashes(reference, chunkSize))
+
+	buf := make([]byte, 4096)
+	_, err := ms.ReadAt(buf, 0)
+	assert.Error(t, err)
+	assert.Equal(t, mirrorsource.ErrAllMirrorsFailed, errors.Cause(err))
+
+	// chunks past the corruption are fine
+	n, err := ms.ReadAt(buf, int64(chunkSize))
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(buf), n)
+	assert.EqualValues(t, reference[chunkSize:chunkSize+len(buf)], buf)
+}
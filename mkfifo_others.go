@@ -0,0 +1,14 @@
+//+build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package savior
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// mkfifo fails, named pipes aren't supported on this system
+func mkfifo(path string, perm os.FileMode) error {
+	return errors.Errorf("can't create named pipe %s on this system", path)
+}
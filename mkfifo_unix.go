@@ -0,0 +1,14 @@
+//+build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package savior
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mkfifo creates a named pipe at path
+func mkfifo(path string, perm os.FileMode) error {
+	return unix.Mkfifo(path, uint32(perm))
+}
@@ -0,0 +1,14 @@
+//+build windows
+
+package savior
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// mkfifo fails, there are no named pipes in the filesystem on windows
+func mkfifo(path string, perm os.FileMode) error {
+	return errors.Errorf("can't create named pipe %s on windows", path)
+}
@@ -0,0 +1,124 @@
+package savior
+
+import (
+	"os"
+	"path"
+)
+
+// A ModePolicy decides the permissions of files and directories
+// FolderSink creates, see FolderSink.ModePolicy
+type ModePolicy interface {
+	// Mode returns the permission bits (and setuid, setgid, sticky bits) of
+	// what's created for the entry, a file or a directory (see entry.Kind).
+	// Type bits are ignored.
+	Mode(entry *Entry) os.FileMode
+}
+
+// ModePolicyFunc lets an ordinary function be used as a ModePolicy
+type ModePolicyFunc func(entry *Entry) os.FileMode
+
+// Mode returns f(entry)
+func (f ModePolicyFunc) Mode(entry *Entry) os.FileMode {
+	return f(entry)
+}
+
+// DefaultModePolicy is what FolderSink uses without a ModePolicy: files get
+// the entry's mode, plus ModeMask (before the process' umask applies),
+// and directories get DirMode.
+var DefaultModePolicy ModePolicy = ModePolicyFunc(func(entry *Entry) os.FileMode {
+	if entry.Kind == EntryKindDir {
+		return DirMode
+	}
+	return entry.Mode | ModeMask
+})
+
+// A SymlinkPolicy is a ModePolicy that can have FolderSink write symlinks
+// as regular files holding their target (as it does on Windows), with the
+// policy's mode for a file, see KindModePolicy.SymlinkAsFile
+type SymlinkPolicy interface {
+	SymlinksAsFiles() bool
+}
+
+// KindModePolicy gives everything the same permissions, depending only on
+// its kind and whether it's executable, regardless of the archive's modes,
+// for uniform and predictable permissions whatever the archive's
+// provenance. Zero modes stand for 0644 (FileMode) and 0755 (DirMode and
+// ExecFileMode). Like any ModePolicy, it composes with FolderSink.Umask.
+type KindModePolicy struct {
+	// FileMode is the mode of files that aren't executable
+	FileMode os.FileMode
+	// DirMode is the mode of directories, including parent directories
+	// that aren't in the archive
+	DirMode os.FileMode
+	// ExecFileMode is the mode of files that have any executable bit set
+	// in the archive
+	ExecFileMode os.FileMode
+	// SymlinkAsFile makes symlinks regular files holding their target,
+	// with FileMode, instead of actual symlinks (which have no permissions
+	// of their own on most systems). It takes precedence over
+	// FolderSink.DereferenceSymlinks.
+	SymlinkAsFile bool
+}
+
+var _ ModePolicy = (*KindModePolicy)(nil)
+var _ SymlinkPolicy = (*KindModePolicy)(nil)
+
+// Mode returns the policy's mode for the entry's kind
+func (kmp *KindModePolicy) Mode(entry *Entry) os.FileMode {
+	switch {
+	case entry.Kind == EntryKindDir:
+		return modeOr(kmp.DirMode, 0755)
+	case entry.Kind != EntryKindSymlink && entry.Mode&0111 != 0:
+		return modeOr(kmp.ExecFileMode, 0755)
+	default:
+		return modeOr(kmp.FileMode, 0644)
+	}
+}
+
+// SymlinksAsFiles returns SymlinkAsFile
+func (kmp *KindModePolicy) SymlinksAsFiles() bool {
+	return kmp.SymlinkAsFile
+}
+
+func modeOr(mode os.FileMode, fallback os.FileMode) os.FileMode {
+	if mode == 0 {
+		return fallback
+	}
+	return mode
+}
+
+// symlinksAsFiles returns true if the ModePolicy asks for symlinks
+// to be written as files, see SymlinkPolicy
+func (fs *FolderSink) symlinksAsFiles() bool {
+	sp, ok := fs.ModePolicy.(SymlinkPolicy)
+	return ok && sp.SymlinksAsFiles()
+}
+
+// enforcesModes returns true if a ModePolicy or Umask is set: modes are
+// then set exactly, instead of going through the process' umask
+func (fs *FolderSink) enforcesModes() bool {
+	return fs.ModePolicy != nil || fs.Umask != 0
+}
+
+// mode returns the permissions of what's created for the entry,
+// according to ModePolicy and Umask
+func (fs *FolderSink) mode(entry *Entry) os.FileMode {
+	policy := fs.ModePolicy
+	if policy == nil {
+		policy = DefaultModePolicy
+	}
+	return policy.Mode(entry) &^ os.ModeType &^ fs.Umask
+}
+
+// parentMode returns the permissions of parent directories created
+// for the entry, that aren't in the archive (or not yet)
+func (fs *FolderSink) parentMode(entry *Entry) os.FileMode {
+	if !fs.enforcesModes() {
+		return LuckyMode
+	}
+	return fs.mode(&Entry{
+		Kind:          EntryKindDir,
+		CanonicalPath: path.Dir(entry.CanonicalPath),
+		Mode:          os.ModeDir | DirMode,
+	})
+}
@@ -0,0 +1,147 @@
+package savior_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_KindModePolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions on windows")
+	}
+	assert := assert.New(t)
+
+	// all sorts of modes, none of which should matter
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, e := range []struct {
+		name string
+		mode os.FileMode
+		data string
+	}{
+		{"open/", os.ModeDir | 0777, ""},
+		{"closed/", os.ModeDir | 0700, ""},
+		{"open/private.txt", 0600, "private"},
+		{"open/public.txt", 0666, "public"},
+		{"closed/run.sh", 0700, "#!/bin/sh"},
+		{"closed/setuid", os.ModeSetuid | 0755, "setuid"},
+		{"implicit/parent/exec", 0711, "exec"},
+		{"link", os.ModeSymlink | 0777, "open/public.txt"},
+	} {
+		fh := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		fh.SetMode(e.mode)
+		w, err := zw.CreateHeader(fh)
+		tmust(t, err)
+		_, err = w.Write([]byte(e.data))
+		tmust(t, err)
+	}
+	tmust(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	extract := func(fs *savior.FolderSink) {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		_, err = ex.Resume(nil, fs)
+		tmust(t, err)
+		tmust(t, fs.Close())
+	}
+
+	// checks that everything has exactly the policy's modes
+	checkModes := func(dir string, fileMode, dirMode, execMode os.FileMode) {
+		tmust(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || path == dir {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			var expected os.FileMode
+			switch rel {
+			case "closed/run.sh", "closed/setuid", "implicit/parent/exec":
+				expected = execMode
+			default:
+				if info.IsDir() {
+					expected = os.ModeDir | dirMode
+				} else {
+					expected = fileMode
+				}
+			}
+			assert.EqualValues(expected, info.Mode(), "%s", rel)
+			return nil
+		}))
+	}
+
+	dir, err := ioutil.TempDir("", "kind-mode-policy")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	extract(&savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+		ModePolicy: &savior.KindModePolicy{
+			FileMode:      0640,
+			DirMode:       0750,
+			ExecFileMode:  0750,
+			SymlinkAsFile: true,
+		},
+	})
+	checkModes(dir, 0640, 0750, 0750)
+	target, err := ioutil.ReadFile(filepath.Join(dir, "link"))
+	tmust(t, err)
+	assert.EqualValues("open/public.txt", string(target))
+
+	// over a previous extraction, with a umask and defaults
+	extract(&savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+		ModePolicy: &savior.KindModePolicy{
+			SymlinkAsFile: true,
+		},
+		Umask: 0027,
+	})
+	checkModes(dir, 0640, 0750, 0750)
+
+	// parent directories that aren't in the archive get DirMode
+	// when they're created, existing ones are left alone
+	fresh, err := ioutil.TempDir("", "kind-mode-policy")
+	tmust(t, err)
+	defer os.RemoveAll(fresh)
+	extract(&savior.FolderSink{
+		Directory: fresh,
+		Consumer:  savior.NopConsumer(),
+		ModePolicy: &savior.KindModePolicy{
+			FileMode:      0600,
+			DirMode:       0711,
+			ExecFileMode:  0755,
+			SymlinkAsFile: true,
+		},
+		Umask: 0022,
+	})
+	checkModes(fresh, 0600, 0711, 0755)
+
+	// symlinks stay symlinks without SymlinkAsFile
+	other, err := ioutil.TempDir("", "kind-mode-policy")
+	tmust(t, err)
+	defer os.RemoveAll(other)
+	extract(&savior.FolderSink{
+		Directory:  other,
+		Consumer:   savior.NopConsumer(),
+		ModePolicy: &savior.KindModePolicy{},
+	})
+	stats, err := os.Lstat(filepath.Join(other, "link"))
+	tmust(t, err)
+	assert.True(stats.Mode()&os.ModeSymlink != 0)
+	tmust(t, os.Remove(filepath.Join(other, "link")))
+	checkModes(other, 0644, 0755, 0755)
+}
@@ -15,6 +15,7 @@ type NopSink struct {
 }
 
 var _ Sink = (*NopSink)(nil)
+var _ HardlinkSink = (*NopSink)(nil)
 
 func (ns *NopSink) destPath(entry *Entry) string {
 	return filepath.Join(ns.Directory, filepath.FromSlash(entry.CanonicalPath))
@@ -36,6 +37,10 @@ func (ns *NopSink) Symlink(entry *Entry, linkname string) error {
 	return nil
 }
 
+func (ns *NopSink) Hardlink(entry *Entry, target string) error {
+	return nil
+}
+
 func (ns *NopSink) Nuke() error {
 	return nil
 }
@@ -0,0 +1,245 @@
+// Package prefetchsource hides the latency of remote archives by
+// fetching the ranges an extractor is about to read ahead of time.
+package prefetchsource
+
+import (
+	"io"
+	"sync"
+
+	"github.com/itchio/savior"
+)
+
+const (
+	defaultMaxMemory   = 32 * 1024 * 1024
+	defaultConcurrency = 4
+)
+
+// Options control how much is fetched ahead of time
+type Options struct {
+	// MaxMemory is how many bytes can be fetched (or being fetched) ahead
+	// of the current read position, 32MiB by default. Ranges larger than
+	// that are never prefetched.
+	MaxMemory int64
+	// Concurrency is how many ranges can be fetched at once, 4 by default
+	Concurrency int
+}
+
+type spanState int
+
+const (
+	spanPending spanState = iota
+	spanFetching
+	spanDone
+	// already read past, or replaced by another call to Prefetch
+	spanEvicted
+)
+
+type span struct {
+	savior.ByteRange
+	index int
+
+	state spanState
+	data  []byte
+	err   error
+	// closed once the fetch is over, whatever its outcome
+	done chan struct{}
+}
+
+// ReaderAt reads from an underlying io.ReaderAt, serving reads from
+// prefetched ranges when it can. It never fetches ranges that weren't
+// passed to Prefetch, and only fetches them in order, as long as they
+// fit in the memory budget: reading from a range frees everything
+// before it, which makes room for the next ones.
+type ReaderAt struct {
+	r    io.ReaderAt
+	opts Options
+
+	mu    sync.Mutex
+	spans []*span
+	// index of the range that's being read
+	cursor int
+	// number of ranges being fetched
+	inFlight int
+	// bytes held by ranges that are fetched or being fetched
+	used   int64
+	closed bool
+}
+
+var _ savior.Prefetcher = (*ReaderAt)(nil)
+
+// New returns a ReaderAt that reads from r. Nothing is prefetched
+// until Prefetch is called.
+func New(r io.ReaderAt, opts Options) *ReaderAt {
+	if opts.MaxMemory <= 0 {
+		opts.MaxMemory = defaultMaxMemory
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+
+	return &ReaderAt{
+		r:    r,
+		opts: opts,
+	}
+}
+
+// Prefetch replaces the ranges that are expected to be read, in the
+// order they'll be read in, and starts fetching the first ones.
+func (ra *ReaderAt) Prefetch(ranges []savior.ByteRange) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	ra.evict(len(ra.spans))
+	ra.spans = nil
+	for i, br := range ranges {
+		if br.Size <= 0 {
+			continue
+		}
+		ra.spans = append(ra.spans, &span{
+			ByteRange: br,
+			index:     i,
+			done:      make(chan struct{}),
+		})
+	}
+	ra.cursor = 0
+	ra.schedule()
+}
+
+// Close stops prefetching and frees prefetched data. Reads still
+// work afterwards, they just go to the underlying io.ReaderAt.
+func (ra *ReaderAt) Close() error {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	ra.closed = true
+	ra.evict(len(ra.spans))
+	ra.spans = nil
+	return nil
+}
+
+// ReadAt implements io.ReaderAt
+func (ra *ReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	ra.mu.Lock()
+	s := ra.find(off)
+	if s == nil {
+		ra.mu.Unlock()
+		return ra.r.ReadAt(buf, off)
+	}
+
+	if s.state == spanFetching {
+		ra.mu.Unlock()
+		<-s.done
+		ra.mu.Lock()
+	}
+
+	if s.state != spanDone || s.err != nil {
+		// not prefetched (or failed to), let the caller
+		// find out what's wrong with a regular read
+		ra.mu.Unlock()
+		return ra.r.ReadAt(buf, off)
+	}
+
+	n := copy(buf, s.data[off-s.Offset:])
+	ra.mu.Unlock()
+
+	if n < len(buf) {
+		// spans a range boundary
+		m, err := ra.ReadAt(buf[n:], off+int64(n))
+		return n + m, err
+	}
+	return n, nil
+}
+
+// find returns the range at or after the cursor that contains off,
+// and moves the cursor there. Must be called with mu held.
+func (ra *ReaderAt) find(off int64) *span {
+	for i := ra.cursor; i < len(ra.spans); i++ {
+		s := ra.spans[i]
+		if off < s.Offset || off >= s.Offset+s.Size {
+			continue
+		}
+
+		if i > ra.cursor {
+			ra.evict(i)
+			ra.cursor = i
+			ra.schedule()
+		}
+		return s
+	}
+	return nil
+}
+
+// evict frees ranges from the cursor up to (but not including) end.
+// Must be called with mu held.
+func (ra *ReaderAt) evict(end int) {
+	for i := ra.cursor; i < end && i < len(ra.spans); i++ {
+		s := ra.spans[i]
+		switch s.state {
+		case spanDone:
+			ra.used -= s.Size
+		case spanFetching:
+			// still counts towards the budget until fetch() returns
+		}
+		s.state = spanEvicted
+		s.data = nil
+	}
+}
+
+// schedule starts fetching ranges after the cursor, for as long as
+// they fit in the budget. Must be called with mu held.
+func (ra *ReaderAt) schedule() {
+	if ra.closed {
+		return
+	}
+
+	for i := ra.cursor; i < len(ra.spans) && ra.inFlight < ra.opts.Concurrency; i++ {
+		s := ra.spans[i]
+		if s.state != spanPending {
+			continue
+		}
+		if s.Size > ra.opts.MaxMemory {
+			// will be read directly
+			continue
+		}
+		if ra.used+s.Size > ra.opts.MaxMemory {
+			// don't get ahead of ourselves
+			return
+		}
+
+		s.state = spanFetching
+		ra.used += s.Size
+		ra.inFlight++
+		go ra.fetch(s)
+	}
+}
+
+func (ra *ReaderAt) fetch(s *span) {
+	data := make([]byte, s.Size)
+	n, err := ra.r.ReadAt(data, s.Offset)
+	if err == io.EOF && int64(n) == s.Size {
+		err = nil
+	}
+	savior.Debugf("prefetchsource: fetched range %d (%d bytes @ %d), err = %v", s.index, s.Size, s.Offset, err)
+
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	ra.inFlight--
+	if s.state == spanEvicted {
+		ra.used -= s.Size
+	} else {
+		s.state = spanDone
+		s.data = data
+		s.err = err
+		if err != nil {
+			s.data = nil
+			ra.used -= s.Size
+		}
+	}
+	close(s.done)
+	ra.schedule()
+}
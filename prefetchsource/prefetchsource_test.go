@@ -0,0 +1,212 @@
+package prefetchsource_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/prefetchsource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// slowReaderAt takes a while to answer, and keeps track of what it was asked
+type slowReaderAt struct {
+	r     io.ReaderAt
+	delay time.Duration
+
+	bytesRead int64
+	mu        sync.Mutex
+	offsets   []int64
+}
+
+func (sra *slowReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	time.Sleep(sra.delay)
+	sra.mu.Lock()
+	sra.offsets = append(sra.offsets, off)
+	sra.mu.Unlock()
+	n, err := sra.r.ReadAt(buf, off)
+	atomic.AddInt64(&sra.bytesRead, int64(n))
+	return n, err
+}
+
+func makeRanges(count int, size int64) []savior.ByteRange {
+	var ranges []savior.ByteRange
+	for i := 0; i < count; i++ {
+		ranges = append(ranges, savior.ByteRange{
+			Offset: int64(i) * size,
+			Size:   size,
+		})
+	}
+	return ranges
+}
+
+func TestReadAt(t *testing.T) {
+	assert := assert.New(t)
+
+	data := semirandom.Bytes(1024 * 1024)
+	ra := prefetchsource.New(bytes.NewReader(data), prefetchsource.Options{
+		MaxMemory: 256 * 1024,
+	})
+	defer ra.Close()
+
+	// read the ranges out of their own order, and across boundaries
+	const rangeSize = 64 * 1024
+	ra.Prefetch(makeRanges(len(data)/rangeSize, rangeSize))
+
+	rng := rand.New(rand.NewSource(0xfeed))
+	buf := make([]byte, 100*1024)
+	for i := 0; i < 200; i++ {
+		off := rng.Int63n(int64(len(data)))
+		size := rng.Intn(len(buf))
+		if off+int64(size) > int64(len(data)) {
+			size = int(int64(len(data)) - off)
+		}
+
+		n, err := ra.ReadAt(buf[:size], off)
+		must(t, err)
+		assert.Equal(size, n)
+		assert.True(bytes.Equal(data[off:off+int64(size)], buf[:n]), "read %d bytes at %d", size, off)
+	}
+
+	// past the end still behaves like the underlying reader
+	_, err := ra.ReadAt(buf[:10], int64(len(data)))
+	assert.Equal(io.EOF, err)
+}
+
+func TestMemoryCap(t *testing.T) {
+	assert := assert.New(t)
+
+	const rangeSize = 64 * 1024
+	const maxMemory = 3 * rangeSize
+
+	data := semirandom.Bytes(16 * rangeSize)
+	sra := &slowReaderAt{
+		r:     bytes.NewReader(data),
+		delay: 5 * time.Millisecond,
+	}
+	ra := prefetchsource.New(sra, prefetchsource.Options{
+		MaxMemory:   maxMemory,
+		Concurrency: 8,
+	})
+	defer ra.Close()
+
+	ra.Prefetch(makeRanges(16, rangeSize))
+	time.Sleep(100 * time.Millisecond)
+	assert.EqualValues(maxMemory, atomic.LoadInt64(&sra.bytesRead), "should only fetch what fits")
+
+	// reading from the 5th range frees the first four (the 4th was never
+	// fetched), and allows fetching up to the 7th
+	buf := make([]byte, 1024)
+	_, err := ra.ReadAt(buf, 4*rangeSize)
+	must(t, err)
+	time.Sleep(100 * time.Millisecond)
+	assert.EqualValues(2*maxMemory, atomic.LoadInt64(&sra.bytesRead), "should fetch the 5th range, and two more")
+
+	sra.mu.Lock()
+	for _, off := range sra.offsets {
+		assert.True(off < 7*rangeSize, "shouldn't fetch past the 7th range (got offset %d)", off)
+	}
+	sra.mu.Unlock()
+}
+
+// httpReaderAt reads from a server that supports range requests
+type httpReaderAt struct {
+	url string
+}
+
+func (hra *httpReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	req, err := http.NewRequest("GET", hra.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(buf))-1))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return 0, io.EOF
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("expected HTTP 206, got %s", res.Status)
+	}
+
+	n, err := io.ReadFull(res.Body, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestSlowServer(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for i := 0; i < 40; i++ {
+		fh := &zip.FileHeader{
+			Name:   fmt.Sprintf("data/file-%d.bin", i),
+			Method: zip.Deflate,
+		}
+		w, err := zw.CreateHeader(fh)
+		must(t, err)
+		_, err = w.Write(semirandom.Bytes(16 * 1024))
+		must(t, err)
+	}
+	must(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	var numRequests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&numRequests, 1)
+		time.Sleep(10 * time.Millisecond)
+		http.ServeContent(w, r, "archive.zip", time.Time{}, bytes.NewReader(zipBytes))
+	}))
+	defer server.Close()
+
+	extract := func(reader io.ReaderAt) time.Duration {
+		startTime := time.Now()
+		ex, err := zipextractor.New(reader, int64(len(zipBytes)))
+		must(t, err)
+
+		_, err = ex.Resume(nil, &savior.NopSink{})
+		must(t, err)
+		return time.Since(startTime)
+	}
+
+	atomic.StoreInt64(&numRequests, 0)
+	direct := extract(&httpReaderAt{url: server.URL})
+	directRequests := atomic.LoadInt64(&numRequests)
+
+	atomic.StoreInt64(&numRequests, 0)
+	ra := prefetchsource.New(&httpReaderAt{url: server.URL}, prefetchsource.Options{
+		Concurrency: 8,
+	})
+	defer ra.Close()
+	prefetched := extract(ra)
+	prefetchedRequests := atomic.LoadInt64(&numRequests)
+
+	t.Logf("direct: %s (%d requests), prefetched: %s (%d requests)", direct, directRequests, prefetched, prefetchedRequests)
+	assert.True(prefetched < direct/2, "prefetching should at least halve extraction time")
+}
@@ -0,0 +1,87 @@
+package savior
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// errReflinkUnsupported is returned by reflinkFile when the platform
+// or filesystem can't share extents between files
+var errReflinkUnsupported = errors.New("reflinks unsupported")
+
+// ReflinkSource returns the file at the entry's path in ReflinkReference,
+// if UseReflinks is set and there's a regular file there
+func (fs *FolderSink) ReflinkSource(entry *Entry) (string, bool) {
+	if !fs.UseReflinks || fs.ReflinkReference == "" || fs.reflinksUnsupported {
+		return "", false
+	}
+	if entry.Kind != EntryKindFile || entry.Mode&specialModes != 0 {
+		return "", false
+	}
+	if fs.TransformContent != nil || (fs.TextLineEnding != LineKeep && isTextEntry(entry)) {
+		// what ends up on disk isn't the entry's contents
+		return "", false
+	}
+	if shouldIgnorePath(entry.CanonicalPath) || !IsRelativeCanonicalPath(entry.CanonicalPath) {
+		return "", false
+	}
+
+	source := filepath.Join(fs.ReflinkReference, filepath.FromSlash(entry.CanonicalPath))
+	stats, err := os.Lstat(source)
+	if err != nil || !stats.Mode().IsRegular() {
+		return "", false
+	}
+	return source, true
+}
+
+// Reflink creates the entry's file as a reflink of `source`. When the
+// filesystem can't do that, it returns false, and ReflinkSource doesn't
+// suggest any other source from then on.
+func (fs *FolderSink) Reflink(entry *Entry, source string) (bool, error) {
+	err := fs.Close()
+	if err != nil {
+		return false, errors.Wrap(err, "closing previous writer")
+	}
+
+	src, err := os.Open(source)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer src.Close()
+
+	f, err := fs.createFile(entry)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	// it may have been preallocated, the clone must replace all of it
+	err = f.Truncate(0)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	err = reflinkFile(src, f)
+	if err != nil {
+		if errors.Cause(err) == errReflinkUnsupported {
+			fs.Consumer.Debugf("folder_sink: can't reflink %s, writing it instead", entry.CanonicalPath)
+			fs.reflinksUnsupported = true
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "reflinking %s", entry.CanonicalPath)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	delete(fs.preallocated, entry.CanonicalPath)
+
+	err = fs.setModTime(entry, f.Name())
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
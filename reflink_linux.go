@@ -0,0 +1,28 @@
+//+build linux
+
+package savior
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// _FICLONE is the ioctl that makes a file share all the extents of another,
+// from linux/fs.h. btrfs and XFS (with reflink=1) support it.
+const _FICLONE = 0x40049409
+
+// reflinkFile makes dst a clone of src
+func reflinkFile(src *os.File, dst *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), _FICLONE, src.Fd())
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.ENOSYS, syscall.EXDEV, syscall.EINVAL:
+		// the filesystem doesn't support it, or the files are on different volumes
+		return errors.Wrap(errReflinkUnsupported, errno.Error())
+	default:
+		return errors.WithStack(errno)
+	}
+}
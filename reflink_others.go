@@ -0,0 +1,9 @@
+//+build !linux
+
+package savior
+
+import "os"
+
+func reflinkFile(src *os.File, dst *os.File) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,185 @@
+package savior
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRetryAttempts = 5
+	defaultRetryDelay    = 100 * time.Millisecond
+	defaultMaxRetryDelay = 5 * time.Second
+)
+
+// RetryingSink wraps another sink and retries Mkdir, GetWriter, WriteSparse,
+// Symlink, Reflink, RemoveEntry and Nuke when they fail with a transient error: a sharing
+// violation or access denied error on Windows (antivirus and indexing
+// services briefly hold files open), a busy resource elsewhere, see
+// IsTransientError. It waits in-between attempts, twice as long every
+// time, and returns the last error once it runs out of attempts. Errors
+// that aren't transient are returned right away. Writes aren't retried,
+// since they may have partially succeeded, and neither is CommitGroup,
+// since part of the group may be committed already. All the actual work
+// is delegated to the inner sink.
+type RetryingSink struct {
+	// MaxAttempts is how many times an operation is tried in total,
+	// 5 if zero
+	MaxAttempts int
+	// Delay is how long to wait before the first retry, 100ms if zero
+	Delay time.Duration
+	// MaxDelay caps how long to wait before a retry, 5s if zero
+	MaxDelay time.Duration
+	// IsTransient decides which errors are retried,
+	// IsTransientError if nil
+	IsTransient func(err error) bool
+	// Consumer is told about every retry
+	Consumer *state.Consumer
+
+	forwardingSink
+}
+
+var _ Sink = (*RetryingSink)(nil)
+var _ EntryRestarter = (*RetryingSink)(nil)
+var _ ResumeOffsetter = (*RetryingSink)(nil)
+var _ HardlinkSink = (*RetryingSink)(nil)
+var _ EntryRemover = (*RetryingSink)(nil)
+var _ PathSink = (*RetryingSink)(nil)
+var _ SparseSink = (*RetryingSink)(nil)
+var _ ReflinkSink = (*RetryingSink)(nil)
+var _ GroupCommitter = (*RetryingSink)(nil)
+
+// NewRetryingSink returns a sink that delegates to inner, retrying
+// operations that fail with transient errors
+func NewRetryingSink(inner Sink, consumer *state.Consumer) *RetryingSink {
+	return &RetryingSink{
+		Consumer:       consumer,
+		forwardingSink: forwardingSink{inner: inner},
+	}
+}
+
+// retry calls op until it succeeds, fails with an error that isn't
+// transient, or there's no attempts left
+func (rs *RetryingSink) retry(what string, entry *Entry, op func() error) error {
+	maxAttempts := rs.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultRetryAttempts
+	}
+	delay := rs.Delay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	maxDelay := rs.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxRetryDelay
+	}
+	isTransient := rs.IsTransient
+	if isTransient == nil {
+		isTransient = IsTransientError
+	}
+
+	name := what
+	if entry != nil {
+		name = what + " " + entry.CanonicalPath
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+		if attempt >= maxAttempts {
+			return errors.Wrapf(err, "%s: giving up after %d attempts", name, attempt)
+		}
+
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+		rs.Consumer.Warnf("retrying_sink: %s failed (%s), retrying in %s (attempt %d/%d)", name, err.Error(), delay, attempt+1, maxAttempts)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (rs *RetryingSink) Mkdir(entry *Entry) error {
+	return rs.retry("mkdir", entry, func() error {
+		return rs.forwardingSink.Mkdir(entry)
+	})
+}
+
+func (rs *RetryingSink) Symlink(entry *Entry, linkname string) error {
+	return rs.retry("symlink", entry, func() error {
+		return rs.forwardingSink.Symlink(entry, linkname)
+	})
+}
+
+func (rs *RetryingSink) GetWriter(entry *Entry) (EntryWriter, error) {
+	var w EntryWriter
+	err := rs.retry("open", entry, func() error {
+		var err error
+		w, err = rs.forwardingSink.GetWriter(entry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteSparse is passed to the inner sink, if it's a SparseSink,
+// otherwise holes are written out by its GetWriter
+func (rs *RetryingSink) WriteSparse(entry *Entry, segments []SparseSegment) (EntryWriter, error) {
+	var w EntryWriter
+	err := rs.retry("open", entry, func() error {
+		var err error
+		w, err = rs.forwardingSink.WriteSparse(entry, segments)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Reflink is passed to the inner sink, if it's a ReflinkSink
+func (rs *RetryingSink) Reflink(entry *Entry, source string) (bool, error) {
+	var reflinked bool
+	err := rs.retry("reflink", entry, func() error {
+		var err error
+		reflinked, err = rs.forwardingSink.Reflink(entry, source)
+		return err
+	})
+	return reflinked, err
+}
+
+// RemoveEntry is passed to the inner sink, if it can remove entries
+func (rs *RetryingSink) RemoveEntry(entry *Entry) error {
+	return rs.retry("remove", entry, func() error {
+		return rs.forwardingSink.RemoveEntry(entry)
+	})
+}
+
+func (rs *RetryingSink) Nuke() error {
+	return rs.retry("nuke", nil, rs.forwardingSink.Nuke)
+}
+
+// IsTransientError returns true for filesystem errors that may go away
+// if the operation is tried again a bit later, see RetryingSink
+func IsTransientError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	default:
+		err = e
+	}
+
+	errno, ok := err.(syscall.Errno)
+	return ok && isTransientErrno(errno)
+}
@@ -0,0 +1,9 @@
+//+build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package savior
+
+import "syscall"
+
+func isTransientErrno(errno syscall.Errno) bool {
+	return false
+}
@@ -0,0 +1,237 @@
+package savior_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakySink fails the first few calls of every operation
+type flakySink struct {
+	*savior.MemorySink
+	err      error
+	failures int
+	calls    map[string]int
+}
+
+func (fs *flakySink) fail(op string) error {
+	fs.calls[op]++
+	if fs.calls[op] <= fs.failures {
+		return &os.PathError{Op: op, Path: "flaky", Err: fs.err}
+	}
+	return nil
+}
+
+func (fs *flakySink) Mkdir(entry *savior.Entry) error {
+	if err := fs.fail("mkdir"); err != nil {
+		return err
+	}
+	return fs.MemorySink.Mkdir(entry)
+}
+
+func (fs *flakySink) Symlink(entry *savior.Entry, linkname string) error {
+	if err := fs.fail("symlink"); err != nil {
+		return err
+	}
+	return fs.MemorySink.Symlink(entry, linkname)
+}
+
+func (fs *flakySink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	if err := fs.fail("open"); err != nil {
+		return nil, err
+	}
+	return fs.MemorySink.GetWriter(entry)
+}
+
+func (fs *flakySink) Nuke() error {
+	if err := fs.fail("nuke"); err != nil {
+		return err
+	}
+	return fs.MemorySink.Nuke()
+}
+
+func Test_RetryingSink(t *testing.T) {
+	assert := assert.New(t)
+
+	transient := syscall.EBUSY
+	if savior.IsTransientError(syscall.ENOENT) {
+		t.Fatal("ENOENT shouldn't be transient")
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, e := range []struct {
+		name string
+		mode os.FileMode
+		data string
+	}{
+		{"dir/", os.ModeDir | 0755, ""},
+		{"dir/file.txt", 0644, "hello"},
+		{"dir/other.txt", 0644, "world"},
+		{"link", os.ModeSymlink | 0777, "dir/file.txt"},
+	} {
+		fh := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		fh.SetMode(e.mode)
+		w, err := zw.CreateHeader(fh)
+		tmust(t, err)
+		_, err = w.Write([]byte(e.data))
+		tmust(t, err)
+	}
+	tmust(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	newSink := func(err error, failures int, maxAttempts int) (*flakySink, *savior.RetryingSink, *[]string) {
+		fs := &flakySink{
+			MemorySink: savior.NewMemorySink(),
+			err:        err,
+			failures:   failures,
+			calls:      make(map[string]int),
+		}
+		var warnings []string
+		rs := savior.NewRetryingSink(fs, &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				if lvl == "warning" {
+					warnings = append(warnings, msg)
+				}
+			},
+		})
+		rs.MaxAttempts = maxAttempts
+		rs.Delay = time.Millisecond
+		rs.MaxDelay = 2 * time.Millisecond
+		return fs, rs, &warnings
+	}
+
+	extract := func(sink savior.Sink) error {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		_, err = ex.Resume(nil, sink)
+		return err
+	}
+
+	// transient errors are retried until they go away
+	{
+		fs, rs, warnings := newSink(transient, 2, 3)
+		tmust(t, extract(rs))
+		data, _, ok := fs.GetEntry("dir/other.txt")
+		assert.True(ok)
+		assert.EqualValues("world", string(data))
+		data, _, ok = fs.GetEntry("link")
+		assert.True(ok)
+		assert.EqualValues("dir/file.txt", string(data))
+		// every operation failed twice before succeeding
+		assert.EqualValues(3, fs.calls["mkdir"])
+		assert.EqualValues(3, fs.calls["symlink"])
+		assert.EqualValues(4, fs.calls["open"])
+		assert.Len(*warnings, 6)
+		assert.Contains((*warnings)[0], "retrying")
+		assert.Contains((*warnings)[0], "dir/")
+		assert.Contains((*warnings)[0], "attempt 2/3")
+
+		tmust(t, rs.Nuke())
+		assert.EqualValues(3, fs.calls["nuke"])
+		assert.Empty(fs.Paths())
+	}
+
+	// the last error comes out once there's no attempts left
+	{
+		fs, rs, warnings := newSink(transient, 5, 3)
+		err := extract(rs)
+		assert.Error(err)
+		assert.True(savior.IsTransientError(err))
+		assert.Contains(err.Error(), "giving up after 3 attempts")
+		assert.EqualValues(3, fs.calls["mkdir"])
+		assert.Len(*warnings, 2)
+
+		err = rs.Nuke()
+		assert.Error(err)
+		assert.EqualValues(3, fs.calls["nuke"])
+	}
+
+	// other errors aren't retried at all
+	{
+		fs, rs, warnings := newSink(syscall.ENOSPC, 1, 3)
+		err := extract(rs)
+		assert.Error(err)
+		assert.EqualValues(syscall.ENOSPC, errors.Cause(err).(*os.PathError).Err)
+		assert.EqualValues(1, fs.calls["mkdir"])
+		assert.Empty(*warnings)
+	}
+
+	// what counts as transient can be changed
+	{
+		fs, rs, _ := newSink(syscall.ENOSPC, 1, 3)
+		rs.IsTransient = func(err error) bool { return true }
+		tmust(t, extract(rs))
+		assert.EqualValues(2, fs.calls["mkdir"])
+	}
+}
+
+func Test_RetryingSinkForwarding(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "retryingsink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+	rs := savior.NewRetryingSink(fs, savior.NopConsumer())
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "data/file",
+		UncompressedSize: 4,
+	}
+	destPath, ok := savior.DestPathOf(rs, entry)
+	assert.True(ok)
+	assert.EqualValues(fs.DestPath(entry), destPath)
+
+	w, err := rs.WriteSparse(entry, []savior.SparseSegment{{Offset: 0, Size: 4}})
+	tmust(t, err)
+	_, err = w.Write([]byte("data"))
+	tmust(t, err)
+	tmust(t, w.Close())
+	tmust(t, rs.CommitGroup())
+
+	bs, err := ioutil.ReadFile(destPath)
+	tmust(t, err)
+	assert.EqualValues("data", string(bs))
+
+	// what the inner sink can't do, sparse files are
+	// still written through GetWriter, which is retried
+	entry.WriteOffset = 0
+	flaky := &flakySink{
+		MemorySink: savior.NewMemorySink(),
+		err:        syscall.EBUSY,
+		failures:   1,
+		calls:      make(map[string]int),
+	}
+	rs = savior.NewRetryingSink(flaky, savior.NopConsumer())
+	rs.Delay = time.Millisecond
+	_, ok = savior.DestPathOf(rs, entry)
+	assert.False(ok)
+	_, ok = rs.ReflinkSource(entry)
+	assert.False(ok)
+	assert.EqualValues(savior.ErrGroupsUnsupported, errors.Cause(rs.CommitGroup()))
+
+	w, err = rs.WriteSparse(entry, nil)
+	tmust(t, err)
+	_, err = w.Write([]byte("data"))
+	tmust(t, err)
+	tmust(t, w.Close())
+	assert.EqualValues(2, flaky.calls["open"])
+	bs, _, ok = flaky.GetEntry("data/file")
+	assert.True(ok)
+	assert.EqualValues("data", string(bs))
+}
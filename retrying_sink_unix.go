@@ -0,0 +1,13 @@
+//+build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package savior
+
+import "syscall"
+
+func isTransientErrno(errno syscall.Errno) bool {
+	switch errno {
+	case syscall.EAGAIN, syscall.EBUSY, syscall.EINTR, syscall.ETXTBSY:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+//+build windows
+
+package savior
+
+import "syscall"
+
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+func isTransientErrno(errno syscall.Errno) bool {
+	switch errno {
+	case syscall.ERROR_ACCESS_DENIED, errorSharingViolation, errorLockViolation:
+		return true
+	}
+	return false
+}
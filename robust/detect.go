@@ -0,0 +1,65 @@
+package robust
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownFormat is returned when an archive's format can't be detected
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+// Format is an archive format RobustExtract knows how to extract
+type Format int
+
+const (
+	FormatUnknown Format = 0
+	FormatZip     Format = 1
+	FormatTar     Format = 2
+	// FormatTarGz is any gzip stream, assumed to contain a tar archive
+	FormatTarGz Format = 3
+	// FormatTarBz2 is any bzip2 stream, assumed to contain a tar archive
+	FormatTarBz2 Format = 4
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatZip:
+		return "zip"
+	case FormatTar:
+		return "tar"
+	case FormatTarGz:
+		return "tar.gz"
+	case FormatTarBz2:
+		return "tar.bz2"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectFormat looks at the magic numbers at the start of r
+func DetectFormat(r io.ReaderAt, size int64) (Format, error) {
+	header := make([]byte, 512)
+	if size < int64(len(header)) {
+		header = header[:size]
+	}
+
+	_, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return FormatUnknown, errors.WithStack(err)
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return FormatTarBz2, nil
+	case len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")):
+		return FormatTar, nil
+	}
+
+	return FormatUnknown, errors.WithStack(ErrUnknownFormat)
+}
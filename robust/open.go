@@ -0,0 +1,40 @@
+package robust
+
+import (
+	"github.com/itchio/httpkit/eos"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// OpenArchive opens the archive at path (zip, tar, tar.gz or tar.bz2, see
+// DetectFormat), and returns an extractor for it, reading through the right
+// sources. The returned function closes the archive, it must be called once
+// done extracting. Unlike RobustExtract, it doesn't retry failed reads or
+// persist checkpoints: that's up to the caller. path can also be an HTTP
+// URL, see eos.Open.
+func OpenArchive(path string) (savior.Extractor, func() error, error) {
+	f, err := eos.Open(path)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	stats, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+	size := stats.Size()
+
+	format, err := DetectFormat(f, size)
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.Wrapf(err, "%s", path)
+	}
+
+	ex, err := makeExtractor(format, f, size)
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.WithStack(err)
+	}
+	return ex, f.Close, nil
+}
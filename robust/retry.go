@@ -0,0 +1,48 @@
+package robust
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/pkg/errors"
+)
+
+// retryingReaderAt retries failed reads, which is usually
+// enough to get past a flaky network or disk.
+type retryingReaderAt struct {
+	ctx      context.Context
+	r        io.ReaderAt
+	retries  int
+	delay    time.Duration
+	consumer *state.Consumer
+}
+
+func (rra *retryingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	var total int
+	var tries int
+	for total < len(buf) {
+		n, err := rra.r.ReadAt(buf[total:], off+int64(total))
+		total += n
+		if err == nil {
+			continue
+		}
+		if err == io.EOF {
+			return total, err
+		}
+
+		tries++
+		if tries > rra.retries {
+			return total, errors.Wrapf(err, "after %d retries", rra.retries)
+		}
+		rra.consumer.Warnf("robust: read at %d failed (%s), retrying", off+int64(total), err.Error())
+
+		select {
+		case <-rra.ctx.Done():
+			return total, errors.WithStack(rra.ctx.Err())
+		case <-time.After(rra.delay):
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,202 @@
+// Package robust ties sources, extractors, and checkpoint persistence
+// together, for callers who just want an archive extracted reliably.
+package robust
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/itchio/httpkit/eos"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/bzip2source"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSaveInterval = 4 * 1024 * 1024
+	defaultMaxRetries   = 5
+	defaultRetryDelay   = 1 * time.Second
+)
+
+// Options tweak RobustExtract. The zero value is fine.
+type Options struct {
+	// CheckpointPath is where checkpoints are persisted. Defaults to
+	// the destination path with ".checkpoint" appended.
+	CheckpointPath string
+
+	// SaveInterval is the number of bytes extracted between two checkpoints
+	SaveInterval int64
+
+	// MaxRetries is how many times a failed read is retried, and also how
+	// many times extraction is restarted from the last checkpoint when it
+	// fails anyway.
+	MaxRetries int
+
+	// RetryDelay is how long to wait between two retries
+	RetryDelay time.Duration
+
+	// Consumer receives log messages and progress
+	Consumer *state.Consumer
+
+	// Open is used to open the archive, defaults to eos.Open (which
+	// supports local paths and HTTP URLs)
+	Open func(name string) (eos.File, error)
+}
+
+func (opts *Options) withDefaults(dest string) Options {
+	res := Options{}
+	if opts != nil {
+		res = *opts
+	}
+
+	if res.CheckpointPath == "" {
+		res.CheckpointPath = dest + ".checkpoint"
+	}
+	if res.SaveInterval <= 0 {
+		res.SaveInterval = defaultSaveInterval
+	}
+	if res.MaxRetries <= 0 {
+		res.MaxRetries = defaultMaxRetries
+	}
+	if res.RetryDelay <= 0 {
+		res.RetryDelay = defaultRetryDelay
+	}
+	if res.Consumer == nil {
+		res.Consumer = savior.NopConsumer()
+	}
+	if res.Open == nil {
+		res.Open = func(name string) (eos.File, error) {
+			return eos.Open(name)
+		}
+	}
+	return res
+}
+
+// RobustExtract extracts the archive at src (zip, tar, tar.gz or tar.bz2)
+// into the dest folder. It regularly saves checkpoints to a sidecar file
+// (see `Options.CheckpointPath`), and picks up from there if it finds one
+// when starting. Failed reads are retried, and if extraction fails anyway,
+// it's resumed from the last checkpoint, up to `Options.MaxRetries` times.
+// The sidecar is removed once extraction completes.
+//
+// Cancelling ctx stops extraction after saving a checkpoint, and
+// RobustExtract returns the context's error.
+func RobustExtract(ctx context.Context, src string, dest string, opts *Options) (*savior.ExtractorResult, error) {
+	o := opts.withDefaults(dest)
+	consumer := o.Consumer
+
+	f, err := o.Open(src)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	stats, err := f.Stat()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	size := stats.Size()
+
+	ra := &retryingReaderAt{
+		ctx:      ctx,
+		r:        f,
+		retries:  o.MaxRetries,
+		delay:    o.RetryDelay,
+		consumer: consumer,
+	}
+
+	format, err := DetectFormat(ra, size)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	consumer.Infof("robust: extracting %s archive (%d bytes)", format, size)
+
+	sink := &savior.FolderSink{
+		Directory: dest,
+		Consumer:  consumer,
+	}
+	defer sink.Close()
+
+	sc := &fileSaveConsumer{
+		ctx:       ctx,
+		path:      o.CheckpointPath,
+		threshold: o.SaveInterval,
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= o.MaxRetries; attempt++ {
+		if ctx.Err() != nil {
+			return nil, errors.WithStack(ctx.Err())
+		}
+
+		if attempt > 0 {
+			consumer.Warnf("robust: extraction failed (%s), resuming from last checkpoint", lastErr.Error())
+			select {
+			case <-ctx.Done():
+				return nil, errors.WithStack(ctx.Err())
+			case <-time.After(o.RetryDelay):
+			}
+		}
+
+		checkpoint, err := loadCheckpoint(o.CheckpointPath)
+		if err != nil {
+			consumer.Warnf("robust: ignoring unusable checkpoint (%s)", err.Error())
+			checkpoint = nil
+		}
+
+		ex, err := makeExtractor(format, ra, size)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ex.SetConsumer(consumer)
+		ex.SetSaveConsumer(sc)
+
+		res, err := ex.Resume(checkpoint, sink)
+		if err == nil {
+			err = sink.Close()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			err = os.Remove(o.CheckpointPath)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, errors.WithStack(err)
+			}
+			return res, nil
+		}
+
+		if errors.Cause(err) == savior.ErrStop && ctx.Err() != nil {
+			return nil, errors.WithStack(ctx.Err())
+		}
+		lastErr = err
+	}
+
+	return nil, errors.Wrapf(lastErr, "giving up after %d attempts", o.MaxRetries+1)
+}
+
+func makeExtractor(format Format, ra io.ReaderAt, size int64) (savior.Extractor, error) {
+	switch format {
+	case FormatZip:
+		return zipextractor.New(ra, size)
+	}
+
+	var source savior.Source = seeksource.NewWithSize(io.NewSectionReader(ra, 0, size), size)
+	switch format {
+	case FormatTar:
+		// as-is
+	case FormatTarGz:
+		source = gzipsource.New(source)
+	case FormatTarBz2:
+		source = bzip2source.New(source)
+	default:
+		return nil, errors.WithStack(ErrUnknownFormat)
+	}
+	return tarextractor.New(source), nil
+}
@@ -0,0 +1,216 @@
+package robust_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itchio/httpkit/eos"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/robust"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	if err != nil {
+		t.Helper()
+		t.Errorf("%+v", err)
+		t.FailNow()
+	}
+}
+
+// faultyFile fails some reads: most failures are transient (they go away
+// on retry), but every so often a read fails several times in a row, more
+// than RobustExtract is willing to retry.
+type faultyFile struct {
+	eos.File
+
+	mu     sync.Mutex
+	reads  int
+	streak int
+
+	// called on every read, so tests can simulate the process being killed
+	onRead func(reads int)
+}
+
+var errInjected = errors.New("injected fault")
+
+func (ff *faultyFile) ReadAt(buf []byte, off int64) (int, error) {
+	ff.mu.Lock()
+	ff.reads++
+	reads := ff.reads
+	fail := false
+	if ff.streak > 0 {
+		ff.streak--
+		fail = true
+	} else if reads%97 == 0 {
+		// longer than MaxRetries
+		ff.streak = 3
+		fail = true
+	} else if reads%7 == 0 {
+		fail = true
+	}
+	ff.mu.Unlock()
+
+	if ff.onRead != nil {
+		ff.onRead(reads)
+	}
+	if fail {
+		return 0, errInjected
+	}
+	return ff.File.ReadAt(buf, off)
+}
+
+func TestRobustExtract(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(20)
+
+	zipBytes := checker.MakeZip(t, sink)
+	testRobustExtract(t, "zip", zipBytes, sink)
+
+	gzipBytes, err := checker.GzipCompress(checker.MakeTar(t, sink))
+	must(t, err)
+	testRobustExtract(t, "tar.gz", gzipBytes, sink)
+}
+
+func testRobustExtract(t *testing.T, name string, archive []byte, sink *checker.Sink) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "robust-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "archive."+name)
+	must(t, ioutil.WriteFile(src, archive, 0644))
+	dest := filepath.Join(dir, "dest")
+
+	opts := &robust.Options{
+		SaveInterval: 64 * 1024,
+		MaxRetries:   2,
+		RetryDelay:   time.Millisecond,
+	}
+
+	numRuns := 0
+	for {
+		numRuns++
+		if numRuns > 200 {
+			t.Fatalf("%s: too many runs, not making progress", name)
+		}
+
+		// kill extraction a little while into every run
+		ctx, cancel := context.WithCancel(context.Background())
+		opts.Open = func(path string) (eos.File, error) {
+			f, err := eos.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			return &faultyFile{File: f, onRead: func(reads int) {
+				if reads > 150 {
+					cancel()
+				}
+			}}, nil
+		}
+
+		_, err := robust.RobustExtract(ctx, src, dest, opts)
+		cancel()
+		if err != nil {
+			if errors.Cause(err) == context.Canceled {
+				_, statErr := os.Stat(dest + ".checkpoint")
+				assert.NoError(statErr, "should have left a checkpoint behind")
+				continue
+			}
+			must(t, err)
+		}
+		break
+	}
+	t.Logf("%s: extracted in %d runs", name, numRuns)
+	assert.True(numRuns > 1, "extraction should have been interrupted")
+
+	_, err = os.Stat(dest + ".checkpoint")
+	assert.True(os.IsNotExist(err), "checkpoint should be removed on success")
+
+	for _, item := range sink.Items {
+		p := filepath.Join(dest, filepath.FromSlash(item.Entry.CanonicalPath))
+		switch item.Entry.Kind {
+		case savior.EntryKindFile:
+			actual, err := ioutil.ReadFile(p)
+			must(t, err)
+			assert.True(bytes.Equal(item.Data, actual), "%s: %s should have the right contents", name, item.Entry.CanonicalPath)
+		case savior.EntryKindDir:
+			stats, err := os.Stat(p)
+			must(t, err)
+			assert.True(stats.IsDir())
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+	bzip2Bytes, err := checker.Bzip2Compress(tarBytes)
+	must(t, err)
+
+	for _, tc := range []struct {
+		data   []byte
+		format robust.Format
+	}{
+		{checker.MakeZip(t, sink), robust.FormatZip},
+		{tarBytes, robust.FormatTar},
+		{gzipBytes, robust.FormatTarGz},
+		{bzip2Bytes, robust.FormatTarBz2},
+	} {
+		format, err := robust.DetectFormat(bytes.NewReader(tc.data), int64(len(tc.data)))
+		must(t, err)
+		assert.EqualValues(t, tc.format, format)
+	}
+
+	_, err = robust.DetectFormat(bytes.NewReader([]byte("hello")), 5)
+	assert.True(t, errors.Cause(err) == robust.ErrUnknownFormat)
+}
+
+func TestOpenArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	dir, err := ioutil.TempDir("", "robust-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string][]byte{
+		"zip":    checker.MakeZip(t, sink),
+		"tar":    tarBytes,
+		"tar.gz": gzipBytes,
+	} {
+		src := filepath.Join(dir, "archive."+name)
+		must(t, ioutil.WriteFile(src, data, 0644))
+
+		ex, cleanup, err := robust.OpenArchive(src)
+		must(t, err)
+		sink.Reset()
+		_, err = ex.Resume(nil, sink)
+		must(t, err)
+		must(t, sink.Validate())
+		must(t, cleanup())
+	}
+
+	src := filepath.Join(dir, "archive.txt")
+	must(t, ioutil.WriteFile(src, []byte("not an archive"), 0644))
+	_, _, err = robust.OpenArchive(src)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == robust.ErrUnknownFormat)
+
+	_, _, err = robust.OpenArchive(filepath.Join(dir, "missing.zip"))
+	assert.Error(err)
+}
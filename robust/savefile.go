@@ -0,0 +1,70 @@
+package robust
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// fileSaveConsumer persists checkpoints to a sidecar file, and
+// asks the extractor to stop when the context is cancelled.
+type fileSaveConsumer struct {
+	ctx       context.Context
+	path      string
+	threshold int64
+
+	copied int64
+}
+
+var _ savior.SaveConsumer = (*fileSaveConsumer)(nil)
+
+func (fsc *fileSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	if fsc.ctx.Err() != nil {
+		// save now so we can stop as soon as possible
+		return true
+	}
+
+	fsc.copied += copiedBytes
+	return fsc.copied >= fsc.threshold
+}
+
+func (fsc *fileSaveConsumer) Save(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	fsc.copied = 0
+
+	buf, err := savior.MarshalCheckpoint(c, savior.WithCompression(true))
+	if err != nil {
+		return savior.AfterSaveStop, errors.WithStack(err)
+	}
+
+	// write then rename, so a crash never leaves a half-written checkpoint
+	tmpPath := fsc.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, buf, 0644)
+	if err != nil {
+		return savior.AfterSaveStop, errors.WithStack(err)
+	}
+	err = os.Rename(tmpPath, fsc.path)
+	if err != nil {
+		return savior.AfterSaveStop, errors.WithStack(err)
+	}
+
+	if fsc.ctx.Err() != nil {
+		return savior.AfterSaveStop, nil
+	}
+	return savior.AfterSaveContinue, nil
+}
+
+// loadCheckpoint returns nil if there's no usable checkpoint at path
+func loadCheckpoint(path string) (*savior.ExtractorCheckpoint, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	return savior.UnmarshalCheckpoint(buf)
+}
@@ -0,0 +1,172 @@
+package savior
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnsafePath is returned by FolderSink for entries that would end up
+// outside of its directory: because their path climbs out of it (like
+// `../../etc/passwd`), because it goes through a symlink on disk that
+// points outside of it, or for symlinks whose target is outside of it,
+// see `FolderSink.AllowUnsafeSymlinks`.
+var ErrUnsafePath = errors.New("entry would end up outside of the destination")
+
+// checkDestPath returns ErrUnsafePath if the entry's destination isn't
+// within the sink's directory, once symlinks already on disk are followed,
+// or if it's in the staging folder, see GroupCommits
+func (fs *FolderSink) checkDestPath(entry *Entry) error {
+	if !IsRelativeCanonicalPath(entry.CanonicalPath) {
+		return errors.Wrapf(ErrUnsafePath, "%s", entry.CanonicalPath)
+	}
+	if fs.GroupCommits && isStagingPath(entry.CanonicalPath) {
+		return errors.Wrapf(ErrUnsafePath, "%s: %s is reserved for staging", entry.CanonicalPath, groupStagingDir)
+	}
+
+	root, err := filepath.EvalSymlinks(fs.Directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// nothing's on disk yet, so no symlinks either
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
+	// the entry itself may be a symlink, which gets replaced,
+	// but the parents that exist are followed
+	parent := filepath.Dir(fs.destPath(entry))
+	for {
+		_, err := os.Lstat(parent)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+		parent = filepath.Dir(parent)
+	}
+
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !isWithin(root, resolved) {
+		return errors.Wrapf(ErrUnsafePath, "%s: goes through %s", entry.CanonicalPath, resolved)
+	}
+	if fs.GroupCommits {
+		// through a symlink that points in the staging folder
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && isStagingPath(filepath.ToSlash(rel)) {
+			return errors.Wrapf(ErrUnsafePath, "%s: goes through %s, which is reserved for staging", entry.CanonicalPath, resolved)
+		}
+	}
+	return nil
+}
+
+// checkSymlinkTarget returns ErrUnsafePath if the target of a
+// symlink entry is absolute, or climbs out of the destination
+func (fs *FolderSink) checkSymlinkTarget(entry *Entry, linkname string) error {
+	if fs.AllowUnsafeSymlinks {
+		return nil
+	}
+
+	target := strings.Replace(linkname, "\\", "/", -1)
+	if path.IsAbs(target) || (len(target) >= 2 && target[1] == ':') {
+		return errors.Wrapf(ErrUnsafePath, "%s: symlink target %s is absolute", entry.CanonicalPath, linkname)
+	}
+	if !IsRelativeCanonicalPath(path.Join(path.Dir(entry.CanonicalPath), target)) {
+		return errors.Wrapf(ErrUnsafePath, "%s: symlink target %s is outside the destination", entry.CanonicalPath, linkname)
+	}
+
+	// symlinks already on disk may put the link (or what its target
+	// goes through) somewhere else than its path says, so the target
+	// is resolved like the OS would
+	root, err := filepath.EvalSymlinks(fs.Directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// nothing's on disk yet, so no symlinks either
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	names := append(strings.Split(path.Dir(entry.CanonicalPath), "/"), strings.Split(target, "/")...)
+	resolved, err := resolvePath(root, names)
+	if err != nil {
+		return err
+	}
+	if !isWithin(root, resolved) {
+		return errors.Wrapf(ErrUnsafePath, "%s: symlink target %s resolves to %s", entry.CanonicalPath, linkname, resolved)
+	}
+	return nil
+}
+
+// maxSymlinkHops is how many symlinks are followed when resolving a
+// single path (by resolvePath, and MemorySink.FS), before giving up on it
+const maxSymlinkHops = 40
+
+// resolvePath walks `names` from `dir`, following symlinks on disk and
+// applying `..` to where they lead, the way the OS does. Names that
+// don't exist (yet) are taken as they are.
+func resolvePath(dir string, names []string) (string, error) {
+	hops := 0
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			dir = filepath.Dir(dir)
+			continue
+		}
+
+		next := filepath.Join(dir, name)
+		stats, err := os.Lstat(next)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", errors.WithStack(err)
+			}
+			dir = next
+			continue
+		}
+		if stats.Mode()&os.ModeSymlink == 0 {
+			dir = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", errors.Errorf("%s: too many levels of symbolic links", next)
+		}
+		linkname, err := os.Readlink(next)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		if filepath.IsAbs(linkname) {
+			volume := filepath.VolumeName(linkname)
+			dir = volume + string(filepath.Separator)
+			linkname = linkname[len(volume):]
+		}
+		names = append(strings.Split(filepath.ToSlash(linkname), "/"), names...)
+	}
+	return dir, nil
+}
+
+// isWithin returns true if `p` is `root` or somewhere under it
+func isWithin(root string, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,50 @@
+package seeksource
+
+import (
+	"io"
+	"os"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// FileSource is a SeekSource over a file on disk, see OpenFile. It also
+// implements io.ReaderAt, so it can be passed to zipextractor.New.
+type FileSource struct {
+	savior.SeekSource
+
+	file *os.File
+}
+
+var _ savior.SeekSource = (*FileSource)(nil)
+var _ io.ReaderAt = (*FileSource)(nil)
+
+// OpenFile opens the file at `path` for reading, and returns a source
+// for its whole contents. Unlike FromFile, it fails if the file's size
+// can't be determined. Closing the source closes the file.
+func OpenFile(path string) (*FileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stats, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	if stats.IsDir() {
+		f.Close()
+		return nil, errors.Errorf("seeksource: %s is a directory", path)
+	}
+
+	return &FileSource{
+		SeekSource: NewWithSize(f, stats.Size()),
+		file:       f,
+	}, nil
+}
+
+// ReadAt reads from the file, regardless of the source's offset
+func (fs *FileSource) ReadAt(buf []byte, off int64) (int, error) {
+	return fs.file.ReadAt(buf, off)
+}
@@ -22,6 +22,8 @@ type seekSource struct {
 	sectionStart int64
 	offset       int64
 	size         int64
+
+	closed bool
 }
 
 var _ savior.SeekSource = (*seekSource)(nil)
@@ -167,7 +169,8 @@ func (ss *seekSource) handleSave() {
 		ss.wantSave = false
 		if ss.ssc != nil {
 			c := &savior.SourceCheckpoint{
-				Offset: ss.offset,
+				Offset:       ss.offset,
+				OutputOffset: ss.offset,
 			}
 			savior.Debugf("seeksource: emitting checkpoint at %d!", c.Offset)
 			ss.ssc.Save(c)
@@ -175,6 +178,25 @@ func (ss *seekSource) handleSave() {
 	}
 }
 
+// Close closes the underlying io.ReadSeeker if it's an io.Closer (like
+// the file passed to FromFile), only once. Sources over memory (like
+// FromBytes) can be resumed afterwards.
+func (ss *seekSource) Close() error {
+	if ss.closed {
+		return nil
+	}
+
+	if c, ok := ss.rs.(io.Closer); ok {
+		ss.closed = true
+		ss.br = nil
+		err := c.Close()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
 func (ss *seekSource) Progress() float64 {
 	// avoid NaNs
 	if ss.size > 0 {
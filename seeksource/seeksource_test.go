@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/itchio/savior"
@@ -301,3 +302,64 @@ func must(t *testing.T, err error) {
 		t.FailNow()
 	}
 }
+
+func Test_OpenFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "seeksource-openfile")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = seeksource.OpenFile(filepath.Join(dir, "missing"))
+	assert.True(os.IsNotExist(errors.Cause(err)))
+	_, err = seeksource.OpenFile(dir)
+	assert.Error(err)
+
+	reference := semirandom.Bytes(256 * 1024)
+	name := filepath.Join(dir, "file")
+	must(t, ioutil.WriteFile(name, reference, 0644))
+
+	ss, err := seeksource.OpenFile(name)
+	must(t, err)
+	assert.EqualValues(len(reference), ss.Size())
+
+	_, err = ss.Resume(nil)
+	must(t, err)
+
+	var checkpoint *savior.SourceCheckpoint
+	ss.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			checkpoint = c
+			return nil
+		},
+	})
+	_, err = io.CopyN(ioutil.Discard, ss, 100*1024)
+	must(t, err)
+	ss.WantSave()
+	_, err = ss.ReadByte()
+	must(t, err)
+	assert.NotNil(checkpoint)
+	must(t, ss.Close())
+
+	// closing again is fine, reading isn't
+	must(t, ss.Close())
+	_, err = ss.ReadByte()
+	assert.EqualValues(savior.ErrUninitializedSource, errors.Cause(err))
+
+	ss, err = seeksource.OpenFile(name)
+	must(t, err)
+	defer ss.Close()
+
+	offset, err := ss.Resume(checkpoint)
+	must(t, err)
+	assert.EqualValues(100*1024, offset)
+
+	out, err := ioutil.ReadAll(ss)
+	must(t, err)
+	assert.EqualValues(reference[offset:], out)
+
+	buf := make([]byte, 1024)
+	_, err = ss.ReadAt(buf, 1024)
+	must(t, err)
+	assert.EqualValues(reference[1024:2048], buf)
+}
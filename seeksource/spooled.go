@@ -0,0 +1,111 @@
+package seeksource
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// SpooledSource is a SeekSource over data read from a plain io.Reader
+// (a pipe, standard input, etc.), which also implements io.ReaderAt, so it
+// can be passed to zipextractor.New. It must be closed to remove the
+// temporary file it may have spilled to.
+type SpooledSource struct {
+	savior.SeekSource
+
+	readerAt io.ReaderAt
+
+	// nil if everything fit in memory
+	file *os.File
+}
+
+var _ savior.SeekSource = (*SpooledSource)(nil)
+var _ io.ReaderAt = (*SpooledSource)(nil)
+
+// FromReaderSpooled reads all of r, keeping it in memory if it's at most
+// spillThreshold bytes, and spilling it to a temporary file otherwise.
+// Formats like zip need their end (the central directory) before anything
+// else, so there's no way around reading the whole stream first.
+func FromReaderSpooled(r io.Reader, spillThreshold int64) (*SpooledSource, error) {
+	buf := new(bytes.Buffer)
+	_, err := io.CopyN(buf, r, spillThreshold+1)
+	if err == io.EOF {
+		data := buf.Bytes()
+		br := bytes.NewReader(data)
+		return &SpooledSource{
+			SeekSource: NewWithSize(br, int64(len(data))),
+			readerAt:   br,
+		}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	f, err := ioutil.TempFile("", "savior-spool")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	size, err := spill(f, buf, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, errors.WithStack(err)
+	}
+	savior.Debugf("seeksource: spooled %d bytes to %s", size, f.Name())
+
+	return &SpooledSource{
+		SeekSource: NewWithSize(io.NewSectionReader(f, 0, size), size),
+		readerAt:   f,
+		file:       f,
+	}, nil
+}
+
+func spill(f *os.File, head *bytes.Buffer, rest io.Reader) (int64, error) {
+	n1, err := head.WriteTo(f)
+	if err != nil {
+		return 0, err
+	}
+
+	n2, err := io.Copy(f, rest)
+	if err != nil {
+		return 0, err
+	}
+
+	return n1 + n2, nil
+}
+
+// ReadAt reads from the spooled data, regardless of the source's offset
+func (ss *SpooledSource) ReadAt(buf []byte, off int64) (int, error) {
+	return ss.readerAt.ReadAt(buf, off)
+}
+
+// Spilled returns true if the data was written to a temporary file
+func (ss *SpooledSource) Spilled() bool {
+	return ss.file != nil
+}
+
+// Close removes the temporary file, if any. The source
+// can't be used afterwards.
+func (ss *SpooledSource) Close() error {
+	if ss.file == nil {
+		return nil
+	}
+
+	f := ss.file
+	ss.file = nil
+
+	err := f.Close()
+	removeErr := os.Remove(f.Name())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if removeErr != nil {
+		return errors.WithStack(removeErr)
+	}
+	return nil
+}
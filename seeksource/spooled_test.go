@@ -0,0 +1,85 @@
+package seeksource_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FromReaderSpooled(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+
+	{
+		ss, err := seeksource.FromReaderSpooled(bytes.NewReader(reference), int64(len(reference)))
+		must(t, err)
+		assert.False(t, ss.Spilled(), "should fit in memory")
+		assert.EqualValues(t, len(reference), ss.Size())
+		checker.RunSourceTest(t, ss, reference)
+		must(t, ss.Close())
+	}
+
+	{
+		ss, err := seeksource.FromReaderSpooled(bytes.NewReader(reference), int64(len(reference))-1)
+		must(t, err)
+		assert.True(t, ss.Spilled(), "should have spilled to disk")
+		assert.EqualValues(t, len(reference), ss.Size())
+		checker.RunSourceTest(t, ss, reference)
+
+		buf := make([]byte, 1024)
+		_, err = ss.ReadAt(buf, 4096)
+		must(t, err)
+		assert.EqualValues(t, reference[4096:4096+1024], buf)
+
+		must(t, ss.Close())
+	}
+}
+
+func Test_FromReaderSpooledZip(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(10)
+	zipBytes := checker.MakeZip(t, sink)
+	sink.Reset()
+
+	// so we can check the spool file gets cleaned up
+	tempDir, err := ioutil.TempDir("", "spooled-test")
+	must(t, err)
+	defer os.RemoveAll(tempDir)
+	oldTmpDir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", tempDir)
+	defer os.Setenv("TMPDIR", oldTmpDir)
+
+	// like `cat big.zip | tool`
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, bytes.NewReader(zipBytes))
+		pw.CloseWithError(err)
+	}()
+
+	ss, err := seeksource.FromReaderSpooled(pr, 64*1024)
+	must(t, err)
+	assert.True(t, ss.Spilled())
+
+	ex, err := zipextractor.New(ss, ss.Size())
+	must(t, err)
+	_, err = ex.Resume(nil, sink)
+	must(t, err)
+	assert.NoError(t, sink.Validate())
+
+	names, err := ioutil.ReadDir(tempDir)
+	must(t, err)
+	assert.Len(t, names, 1, "should have spooled to a temporary file")
+
+	must(t, ss.Close())
+	must(t, ss.Close())
+
+	names, err = ioutil.ReadDir(tempDir)
+	must(t, err)
+	assert.Len(t, names, 0, "temporary file should be removed on close")
+}
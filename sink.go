@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/itchio/headway/united"
+	"github.com/pkg/errors"
 )
 
 type EntryKind int
@@ -17,6 +19,9 @@ const (
 	EntryKindSymlink = 1
 	// EntryKindFile is the kind for a file
 	EntryKindFile = 2
+	// EntryKindHardlink is the kind for a hard link to a file
+	// extracted earlier, see HardlinkSink
+	EntryKindHardlink = 3
 )
 
 func (ek EntryKind) String() string {
@@ -27,6 +32,8 @@ func (ek EntryKind) String() string {
 		return "symlink"
 	case EntryKindFile:
 		return "file"
+	case EntryKindHardlink:
+		return "hardlink"
 	default:
 		return "<unknown entry kind>"
 	}
@@ -49,7 +56,10 @@ type Entry struct {
 	// CompressedSize may be 0, if the extractor doesn't have the information
 	CompressedSize int64
 
-	// UncompressedSize may be 0, if the extractor doesn't have the information
+	// UncompressedSize may be 0, if the extractor doesn't have the information.
+	// For files, it's UnknownSize (or any negative value) when the size is
+	// only known once the entry has been read completely: those aren't
+	// preallocated or checked against their size, see SizeKnown.
 	UncompressedSize int64
 
 	// WriteOffset is useful if this entry struct is included in an extractor
@@ -57,11 +67,50 @@ type Entry struct {
 	WriteOffset int64
 
 	// Linkname describes the target of a symlink if the entry is a symlink
-	// and the format we're extracting has symlinks in metadata rather than its contents
+	// and the format we're extracting has symlinks in metadata rather than its contents.
+	// For hardlinks, it's the canonical path of the file being linked to.
 	Linkname string
+
+	// IsDelta is true if the entry's contents are a patch against
+	// a file of a previous version, see `Base`
+	IsDelta bool
+
+	// IsText is true if the archive marks the entry as a text file
+	IsText bool
+
+	// LinkToDir is true if the entry is a symlink and the archive knows
+	// its target is a directory. Some platforms (Windows) need to know
+	// that when the symlink is created.
+	LinkToDir bool
+
+	// ModTime is when the entry was last modified, according to the
+	// archive. It's the zero time if the archive doesn't say. Its precision
+	// is whatever the archive's: seconds, 100ns, or nanoseconds.
+	ModTime time.Time
+
+	// AccessTime is when the entry was last accessed, if the archive
+	// records it (tar PAX or GNU headers, some zip extra fields)
+	AccessTime time.Time
+
+	// ChangeTime is when the entry's metadata last changed, if the archive
+	// records it (tar PAX or GNU headers). It's informational only, since
+	// there's no setting it on extraction.
+	ChangeTime time.Time
+}
+
+// UnknownSize is the UncompressedSize of entries whose size isn't known
+// until they've been read completely, in streaming formats
+const UnknownSize int64 = -1
+
+// SizeKnown returns false if the entry's UncompressedSize is unknown
+func (entry *Entry) SizeKnown() bool {
+	return entry.UncompressedSize >= 0
 }
 
 func (entry *Entry) String() string {
+	if !entry.SizeKnown() {
+		return fmt.Sprintf("%s (unknown size %s)", entry.CanonicalPath, entry.Kind)
+	}
 	return fmt.Sprintf("%s (%s %s)", entry.CanonicalPath, united.FormatBytes(entry.UncompressedSize), entry.Kind)
 }
 
@@ -108,3 +157,151 @@ type Sink interface {
 	// Close this sink, including all pending writers
 	Close() error
 }
+
+// A PathSink is a Sink that writes entries to the filesystem,
+// and can tell where. Sinks that wrap another one (like CountingSink)
+// are PathSinks whatever they wrap, and return an empty path when it
+// isn't one, see DestPathOf.
+type PathSink interface {
+	Sink
+
+	// DestPath returns the path the entry is (or would be) written to
+	DestPath(entry *Entry) string
+}
+
+// DestPathOf returns the path the entry is (or would be) written to,
+// if the sink is a PathSink that can tell
+func DestPathOf(sink Sink, entry *Entry) (string, bool) {
+	ps, ok := sink.(PathSink)
+	if !ok {
+		return "", false
+	}
+	p := ps.DestPath(entry)
+	return p, p != ""
+}
+
+// An EntryRestarter is a Sink that can't resume writing some entries
+// mid-way. Extractors that can go back to the start of an entry should
+// check it before resuming one, and start it over when asked to.
+type EntryRestarter interface {
+	// NeedsRestart returns true if the entry must be written from the start
+	NeedsRestart(entry *Entry) bool
+}
+
+// A ResumeOffsetter is a Sink that can tell how much of an entry it
+// actually holds. Checkpoints record how much of an entry was written
+// (see Entry.WriteOffset), but a checkpoint can be resumed with another
+// sink than the one it was taken with (another directory, another kind
+// of sink altogether), which may hold less, or nothing at all.
+type ResumeOffsetter interface {
+	// ResumeOffset returns how many bytes at the start of the entry
+	// are already written, up to entry.WriteOffset.
+	ResumeOffset(entry *Entry) (int64, error)
+}
+
+// ErrSinkMismatch is returned by extractors that can't go back to the
+// start of an entry, when the sink they're resuming with doesn't hold
+// everything the checkpoint says was written.
+var ErrSinkMismatch = errors.New("sink doesn't hold the data the checkpoint was taken with")
+
+// ReconcileWriteOffset lowers entry.WriteOffset to what the sink actually
+// holds, for sinks that implement ResumeOffsetter. Other sinks are assumed
+// to be the ones the checkpoint was taken with. It returns true if the
+// offset was lowered, in which case the extractor needs to resume reading
+// the entry from an earlier position (or from the start).
+func ReconcileWriteOffset(sink Sink, entry *Entry) (bool, error) {
+	if entry.WriteOffset == 0 {
+		return false, nil
+	}
+
+	ro, ok := sink.(ResumeOffsetter)
+	if !ok {
+		return false, nil
+	}
+
+	offset, err := ro.ResumeOffset(entry)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if offset >= entry.WriteOffset {
+		return false, nil
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	Debugf(`%s: sink only holds %d bytes out of %d, resuming from there`, entry.CanonicalPath, offset, entry.WriteOffset)
+	entry.WriteOffset = offset
+	return true, nil
+}
+
+// A HardlinkSink is a Sink that can create hard links. Extractors for
+// formats that have them (like tar) fail with ErrHardlinkUnsupported
+// when extracting to a sink that doesn't implement it.
+type HardlinkSink interface {
+	// Hardlink makes the entry a hard link to the file at the canonical
+	// path `target`, which was extracted before it.
+	Hardlink(entry *Entry, target string) error
+}
+
+// ErrHardlinkUnsupported is returned when extracting a hardlink to
+// a sink that isn't a HardlinkSink
+var ErrHardlinkUnsupported = errors.New("sink doesn't support hardlinks")
+
+// A SparseSegment is a region of a sparse file that holds data.
+// Everything between segments is a hole, which reads as zeros.
+type SparseSegment struct {
+	Offset int64
+	Size   int64
+}
+
+// A SparseSink is a Sink that can recreate holes in sparse files,
+// instead of writing them out in full. Extractors that know the
+// hole map of an entry should use it when the sink supports it,
+// and fall back to GetWriter (which writes zeros) otherwise.
+type SparseSink interface {
+	// WriteSparse returns a writer at entry.WriteOffset, like GetWriter.
+	// The entry's full contents are written to it, holes included, but
+	// only the bytes that fall within `segments` end up being written,
+	// the rest is skipped over.
+	WriteSparse(entry *Entry, segments []SparseSegment) (EntryWriter, error)
+}
+
+// A ReflinkSink is a Sink that can write a file entry by sharing the
+// extents of an identical file (a reflink, or clone), on filesystems like
+// btrfs or XFS, which saves both space and writes. Extractors that know
+// an entry's checksum up front should compare it to the file returned by
+// ReflinkSource before decompressing anything.
+type ReflinkSink interface {
+	// ReflinkSource returns the path of a file the entry could be reflinked
+	// from, if there's one. It may or may not have the entry's contents.
+	ReflinkSource(entry *Entry) (string, bool)
+	// Reflink writes the entry as a reflink of the file at `source`, which
+	// must have the entry's contents. It returns false if that's not
+	// possible, in which case the entry must be written as usual.
+	Reflink(entry *Entry, source string) (bool, error)
+}
+
+// A GroupCommitter is a Sink that can hold entries back until a whole
+// group of them is written, and then make them visible all at once, so
+// that an interrupted extraction never leaves part of a group in place,
+// see FolderSink.GroupCommits.
+type GroupCommitter interface {
+	// CommitGroup makes everything written since the last commit
+	// (including before a resume) visible
+	CommitGroup() error
+}
+
+// ErrGroupsUnsupported is returned by sinks that wrap another one
+// (like CountingSink) when asked to commit a group of entries, if
+// what they wrap isn't a GroupCommitter.
+var ErrGroupsUnsupported = errors.New("sink can't commit groups of entries")
+
+// An EntryRemover is a Sink that can remove a single entry, for extractors
+// that skip entries they failed to extract, instead of leaving them
+// half-written.
+type EntryRemover interface {
+	// RemoveEntry removes whatever was written for the entry so far, if
+	// anything. Directories are left alone, they may hold other entries.
+	RemoveEntry(entry *Entry) error
+}
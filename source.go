@@ -1,7 +1,6 @@
 package savior
 
 import (
-	"encoding/gob"
 	"io"
 
 	"github.com/pkg/errors"
@@ -10,10 +9,19 @@ import (
 // SourceCheckpoint contains all the information needed for a source
 // to resume from a given offset.
 type SourceCheckpoint struct {
-	// Offset is the position in the stream, in bytes
+	// Offset is how many bytes of the underlying stream were consumed,
+	// ie. the position in the compressed stream for decompressors, and the
+	// position in the file for seeksources. It's what progress should be
+	// computed from, as the size of the decompressed stream is usually unknown.
 	// It should be non-zero, as the checkpoint for offset 0 is simply nil
 	Offset int64
 
+	// OutputOffset is the position in the stream the source outputs, in bytes,
+	// ie. the decompressed position for decompressors. It's the offset `Resume`
+	// returns for this checkpoint. For sources that don't transform their
+	// input, it's the same as Offset.
+	OutputOffset int64
+
 	// Data is a source-specific pointer to a struct, which must be
 	// registered with `gob` so that it can be serialized and deserialized
 	Data interface{}
@@ -53,6 +61,13 @@ type Source interface {
 
 	Features() SourceFeatures
 
+	// Close releases whatever the source holds (files, connections,
+	// decompressor state), including the sources it wraps. Sources
+	// that only read from memory can still be resumed after Close.
+	// Extractors close their source when they're done with it,
+	// whether extraction completed, was stopped, or failed.
+	Close() error
+
 	io.Reader
 
 	// io.ByteReader is embedded in Source so it can be used by the `flate` package
@@ -81,11 +96,26 @@ type SeekSource interface {
 	Section(start int64, size int64) (SeekSource, error)
 }
 
-// FileSource is a SeekSource that can be closed (to release associated resources)
+// FileSource is a SeekSource that reads from a file, which Close closes
 type FileSource interface {
 	SeekSource
+}
 
-	Close() error
+// A ByteRange is a region of a file
+type ByteRange struct {
+	Offset int64
+	Size   int64
+}
+
+// A Prefetcher is an io.ReaderAt that can be told which ranges will be
+// read next, so it can fetch them ahead of time. Extractors that know
+// where their entries are call Prefetch once they've decided what to
+// extract, in the order they'll read them in.
+type Prefetcher interface {
+	io.ReaderAt
+
+	// Prefetch replaces the ranges that are expected to be read
+	Prefetch(ranges []ByteRange)
 }
 
 type SourceSaveConsumer interface {
@@ -128,5 +158,5 @@ func DiscardByRead(source Source, delta int64) error {
 }
 
 func init() {
-	gob.Register(&SourceCheckpoint{})
+	RegisterCheckpointData("savior.SourceCheckpoint", &SourceCheckpoint{})
 }
@@ -0,0 +1,246 @@
+package savior
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DirStat holds totals for a directory of the destination
+type DirStat struct {
+	// Files is the number of files written in that directory (and its subdirectories)
+	Files int64
+	// Bytes is the total size of those files
+	Bytes int64
+}
+
+// StatsSink wraps another sink and records how many files and bytes
+// end up in each directory, up to a certain depth. All the actual work
+// is delegated to the inner sink.
+//
+// Totals are kept in memory: when resuming with a new StatsSink (in
+// another process, say), call Rebuild first, so that entries extracted
+// before the checkpoint are counted too.
+type StatsSink struct {
+	// Depth is the number of path components used to group entries:
+	// with a depth of 1 (the default), `data/maps/1.bin` is counted
+	// towards `data`. Files at the root are counted towards ".".
+	Depth int
+
+	forwardingSink
+
+	mu sync.Mutex
+	// sizes contains the current size of each file written so far,
+	// keyed by canonical path. It's a size rather than a running total
+	// of bytes written so that resumes (which rewrite part of a file)
+	// don't get counted twice.
+	sizes map[string]int64
+}
+
+var _ Sink = (*StatsSink)(nil)
+var _ ResumeOffsetter = (*StatsSink)(nil)
+var _ HardlinkSink = (*StatsSink)(nil)
+var _ EntryRestarter = (*StatsSink)(nil)
+var _ PathSink = (*StatsSink)(nil)
+var _ SparseSink = (*StatsSink)(nil)
+var _ ReflinkSink = (*StatsSink)(nil)
+var _ GroupCommitter = (*StatsSink)(nil)
+var _ EntryRemover = (*StatsSink)(nil)
+
+// NewStatsSink returns a new StatsSink that delegates to inner
+func NewStatsSink(inner Sink) *StatsSink {
+	return &StatsSink{
+		Depth:          1,
+		forwardingSink: forwardingSink{inner: inner},
+		sizes:          make(map[string]int64),
+	}
+}
+
+// DirStats returns totals for each directory, see `Depth`
+func (ss *StatsSink) DirStats() map[string]DirStat {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	res := make(map[string]DirStat)
+	for p, size := range ss.sizes {
+		key := ss.dirKey(p)
+		ds := res[key]
+		ds.Files++
+		ds.Bytes += size
+		res[key] = ds
+	}
+	return res
+}
+
+// Rebuild counts the file entries among `entries` (usually all of the
+// archive's, see Extractor.ListEntries) that are already in the inner sink,
+// at the size they are there. Entries already counted are left alone.
+// It only knows where to look if the inner sink is a PathSink: other
+// sinks don't keep anything across processes anyway.
+//
+// Files that are there but not extracted yet (preallocated, or left from
+// a previous extraction) are counted at their current size, until the
+// extractor gets to them.
+func (ss *StatsSink) Rebuild(entries []*Entry) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Kind != EntryKindFile {
+			continue
+		}
+		if _, ok := ss.sizes[entry.CanonicalPath]; ok {
+			continue
+		}
+
+		destPath, ok := DestPathOf(ss.inner, entry)
+		if !ok {
+			continue
+		}
+		stats, err := os.Lstat(destPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		if !stats.Mode().IsRegular() {
+			continue
+		}
+		ss.sizes[entry.CanonicalPath] = stats.Size()
+	}
+	return nil
+}
+
+func (ss *StatsSink) dirKey(canonicalPath string) string {
+	depth := ss.Depth
+	if depth < 1 {
+		depth = 1
+	}
+
+	dir := path.Dir(path.Clean(canonicalPath))
+	if dir == "." || dir == "/" {
+		return "."
+	}
+
+	tokens := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	if len(tokens) > depth {
+		tokens = tokens[:depth]
+	}
+	return strings.Join(tokens, "/")
+}
+
+func (ss *StatsSink) setSize(entry *Entry, size int64) {
+	ss.mu.Lock()
+	ss.sizes[entry.CanonicalPath] = size
+	ss.mu.Unlock()
+}
+
+func (ss *StatsSink) GetWriter(entry *Entry) (EntryWriter, error) {
+	w, err := ss.forwardingSink.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is (re)opened at WriteOffset, anything after that is gone
+	ss.setSize(entry, entry.WriteOffset)
+
+	return &statsEntryWriter{
+		ss:    ss,
+		w:     w,
+		entry: entry,
+	}, nil
+}
+
+// WriteSparse is passed to the inner sink, if it's a SparseSink,
+// otherwise holes are written out by its GetWriter
+func (ss *StatsSink) WriteSparse(entry *Entry, segments []SparseSegment) (EntryWriter, error) {
+	w, err := ss.forwardingSink.WriteSparse(entry, segments)
+	if err != nil {
+		return nil, err
+	}
+	ss.setSize(entry, entry.WriteOffset)
+
+	return &statsEntryWriter{
+		ss:    ss,
+		w:     w,
+		entry: entry,
+	}, nil
+}
+
+// Hardlink is passed to the inner sink, if it can make hardlinks.
+// The link is counted as a file the size of its target.
+func (ss *StatsSink) Hardlink(entry *Entry, target string) error {
+	err := ss.forwardingSink.Hardlink(entry, target)
+	if err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	ss.sizes[entry.CanonicalPath] = ss.sizes[target]
+	ss.mu.Unlock()
+	return nil
+}
+
+// Reflink is passed to the inner sink, if it's a ReflinkSink.
+// Reflinked files are counted like written ones.
+func (ss *StatsSink) Reflink(entry *Entry, source string) (bool, error) {
+	reflinked, err := ss.forwardingSink.Reflink(entry, source)
+	if err != nil || !reflinked {
+		return reflinked, err
+	}
+	ss.setSize(entry, entry.UncompressedSize)
+	return true, nil
+}
+
+// RemoveEntry is passed to the inner sink, if it can remove entries,
+// and the entry stops being counted
+func (ss *StatsSink) RemoveEntry(entry *Entry) error {
+	if _, ok := ss.inner.(EntryRemover); !ok {
+		return nil
+	}
+
+	err := ss.forwardingSink.RemoveEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	delete(ss.sizes, entry.CanonicalPath)
+	ss.mu.Unlock()
+	return nil
+}
+
+func (ss *StatsSink) Nuke() error {
+	ss.mu.Lock()
+	ss.sizes = make(map[string]int64)
+	ss.mu.Unlock()
+
+	return ss.forwardingSink.Nuke()
+}
+
+type statsEntryWriter struct {
+	ss    *StatsSink
+	w     EntryWriter
+	entry *Entry
+}
+
+var _ EntryWriter = (*statsEntryWriter)(nil)
+
+func (sew *statsEntryWriter) Write(buf []byte) (int, error) {
+	n, err := sew.w.Write(buf)
+	// the inner writer keeps WriteOffset up-to-date
+	sew.ss.setSize(sew.entry, sew.entry.WriteOffset)
+	return n, err
+}
+
+func (sew *statsEntryWriter) Close() error {
+	return sew.w.Close()
+}
+
+func (sew *statsEntryWriter) Sync() error {
+	return sew.w.Sync()
+}
@@ -0,0 +1,152 @@
+package savior_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StatsSink(t *testing.T) {
+	assert := assert.New(t)
+
+	files := map[string]int64{
+		"root.txt":          100,
+		"data/a.bin":        1000,
+		"data/maps/b.bin":   3 * 1024 * 1024,
+		"data/maps/c.bin":   2 * 1024 * 1024,
+		"bin/game":          500,
+		"bin/lib/engine.so": 1024 * 1024,
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for name, size := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   name,
+			Method: zip.Deflate,
+		})
+		tmust(t, err)
+		_, err = w.Write(semirandom.Bytes(size))
+		tmust(t, err)
+	}
+	tmust(t, zw.Close())
+	zipBytes := buf.Bytes()
+
+	dir, err := ioutil.TempDir("", "statssink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	// stop at every checkpoint, to make sure resumes are accounted for
+	// properly, even with a new sink every time, like in a new process
+	var sink *savior.StatsSink
+	var c *savior.ExtractorCheckpoint
+	numResumes := 0
+	for {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		tmust(t, err)
+		sink = savior.NewStatsSink(&savior.FolderSink{
+			Directory: dir,
+		})
+		if c != nil {
+			tmust(t, sink.Rebuild(ex.Entries()))
+		}
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			// the extractor keeps mutating its checkpoint after it stops, so
+			// take a snapshot, like a real consumer would
+			buf, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(buf)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			return savior.AfterSaveStop, nil
+		}))
+
+		_, err = ex.Resume(c, sink)
+		if err == savior.ErrStop {
+			numResumes++
+			continue
+		}
+		tmust(t, err)
+		break
+	}
+	tmust(t, sink.Close())
+	assert.True(numResumes > 0)
+
+	assert.EqualValues(map[string]savior.DirStat{
+		".":    {Files: 1, Bytes: 100},
+		"data": {Files: 3, Bytes: 1000 + 5*1024*1024},
+		"bin":  {Files: 2, Bytes: 500 + 1024*1024},
+	}, sink.DirStats())
+
+	sink.Depth = 2
+	assert.EqualValues(map[string]savior.DirStat{
+		".":         {Files: 1, Bytes: 100},
+		"data":      {Files: 1, Bytes: 1000},
+		"data/maps": {Files: 2, Bytes: 5 * 1024 * 1024},
+		"bin":       {Files: 1, Bytes: 500},
+		"bin/lib":   {Files: 1, Bytes: 1024 * 1024},
+	}, sink.DirStats())
+}
+
+func Test_StatsSinkForwarding(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "statssink-test")
+	tmust(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+	}
+	ss := savior.NewStatsSink(fs)
+
+	entry := &savior.Entry{
+		Kind:             savior.EntryKindFile,
+		CanonicalPath:    "data/file",
+		UncompressedSize: 4,
+	}
+	destPath, ok := savior.DestPathOf(ss, entry)
+	assert.True(ok)
+	assert.EqualValues(fs.DestPath(entry), destPath)
+
+	w, err := ss.WriteSparse(entry, []savior.SparseSegment{{Offset: 0, Size: 4}})
+	tmust(t, err)
+	_, err = w.Write([]byte("data"))
+	tmust(t, err)
+	tmust(t, w.Close())
+	tmust(t, ss.CommitGroup())
+	assert.EqualValues(4, ss.DirStats()["data"].Bytes)
+
+	tmust(t, ss.RemoveEntry(entry))
+	_, err = os.Stat(destPath)
+	assert.True(os.IsNotExist(err))
+	assert.Empty(ss.DirStats())
+
+	// what the inner sink can't do
+	entry.WriteOffset = 0
+	ss = savior.NewStatsSink(savior.NewMemorySink())
+	_, ok = savior.DestPathOf(ss, entry)
+	assert.False(ok)
+	_, ok = ss.ReflinkSource(entry)
+	assert.False(ok)
+	assert.EqualValues(savior.ErrGroupsUnsupported, errors.Cause(ss.CommitGroup()))
+
+	w, err = ss.WriteSparse(entry, nil)
+	tmust(t, err)
+	_, err = w.Write([]byte("data"))
+	tmust(t, err)
+	tmust(t, w.Close())
+	assert.EqualValues(4, ss.DirStats()["data"].Bytes)
+}
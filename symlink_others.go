@@ -0,0 +1,15 @@
+//+build !windows
+
+package savior
+
+import "os"
+
+// symlink creates a symlink at newname pointing to oldname, whether
+// it's a directory or not doesn't matter here
+func symlink(oldname, newname string, isDir bool) error {
+	return os.Symlink(oldname, newname)
+}
+
+func isSymlinkPrivilegeError(err error) bool {
+	return false
+}
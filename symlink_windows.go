@@ -0,0 +1,71 @@
+//+build windows
+
+package savior
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	symbolicLinkFlagDirectory = 0x1
+	// lets non-elevated processes create symlinks in developer mode,
+	// on Windows 10 Creators Update and later.
+	symbolicLinkFlagAllowUnprivilegedCreate = 0x2
+
+	errorInvalidParameter = syscall.Errno(87)
+	errorPrivilegeNotHeld = syscall.Errno(1314)
+)
+
+// symlink creates a symlink at newname pointing to oldname. Windows needs
+// to know whether the target is a directory up front, which os.Symlink
+// only finds out by looking at the target, that might not exist yet.
+func symlink(oldname, newname string, isDir bool) error {
+	oldname = filepath.FromSlash(oldname)
+
+	if !isDir {
+		target := oldname
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(newname), target)
+		}
+		if stats, err := os.Stat(target); err == nil && stats.IsDir() {
+			isDir = true
+		}
+	}
+
+	n, err := syscall.UTF16PtrFromString(newname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	o, err := syscall.UTF16PtrFromString(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	var flags uint32
+	if isDir {
+		flags |= symbolicLinkFlagDirectory
+	}
+
+	err = syscall.CreateSymbolicLink(n, o, flags|symbolicLinkFlagAllowUnprivilegedCreate)
+	if err == errorInvalidParameter {
+		// older versions of Windows don't know about that flag
+		err = syscall.CreateSymbolicLink(n, o, flags)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// isSymlinkPrivilegeError returns true if a symlink couldn't be created
+// because the process isn't allowed to
+func isSymlinkPrivilegeError(err error) bool {
+	if le, ok := errors.Cause(err).(*os.LinkError); ok {
+		err = le.Err
+	}
+	return err == errorPrivilegeNotHeld
+}
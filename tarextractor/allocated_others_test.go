@@ -0,0 +1,21 @@
+//+build !windows
+
+package tarextractor_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// allocatedSize returns how many bytes a file takes up on disk
+func allocatedSize(t *testing.T, path string) (int64, bool) {
+	stats, err := os.Stat(path)
+	must(t, err)
+
+	st, ok := stats.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Blocks) * 512, true
+}
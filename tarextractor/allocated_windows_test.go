@@ -0,0 +1,10 @@
+//+build windows
+
+package tarextractor_test
+
+import "testing"
+
+// allocatedSize isn't available on windows
+func allocatedSize(t *testing.T, path string) (int64, bool) {
+	return 0, false
+}
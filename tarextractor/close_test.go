@@ -0,0 +1,83 @@
+package tarextractor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// closeTracker is a file that remembers being closed
+type closeTracker struct {
+	*os.File
+	closed int
+}
+
+func (ct *closeTracker) Close() error {
+	ct.closed++
+	return ct.File.Close()
+}
+
+func TestSourceClosed(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	// spooled sources spill to os.TempDir(), so give them one of their own
+	tmpDir, err := ioutil.TempDir("", "tarextractor-close")
+	must(t, err)
+	defer os.RemoveAll(tmpDir)
+	oldTmpDir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", tmpDir)
+	defer os.Setenv("TMPDIR", oldTmpDir)
+
+	assertNoTempFiles := func(when string) {
+		names, err := ioutil.ReadDir(tmpDir)
+		must(t, err)
+		assert.Empty(t, names, "spooled data should be removed after %s", when)
+	}
+
+	extract := func(stop bool) error {
+		source, err := seeksource.FromReaderSpooled(bytes.NewReader(gzipBytes), 1024)
+		must(t, err)
+		assert.True(t, source.Spilled())
+
+		ex := tarextractor.New(gzipsource.New(source))
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(512*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if stop {
+				return savior.AfterSaveStop, nil
+			}
+			return savior.AfterSaveContinue, nil
+		}))
+		sink.Reset()
+		_, err = ex.Resume(nil, sink)
+		return err
+	}
+
+	must(t, extract(false))
+	assertNoTempFiles("completing")
+
+	assert.Equal(t, savior.ErrStop, extract(true))
+	assertNoTempFiles("stopping")
+
+	// now with a file, and an archive that's cut short
+	truncatedPath := filepath.Join(tmpDir, "truncated.tar")
+	must(t, ioutil.WriteFile(truncatedPath, tarBytes[:len(tarBytes)/2], 0644))
+	f, err := os.Open(truncatedPath)
+	must(t, err)
+	ct := &closeTracker{File: f}
+
+	sink.Reset()
+	_, err = tarextractor.New(seeksource.FromFile(ct)).Resume(nil, sink)
+	assert.Error(t, err)
+	assert.Equal(t, 1, ct.closed, "file should be closed after failing")
+}
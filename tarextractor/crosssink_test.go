@@ -0,0 +1,44 @@
+package tarextractor_test
+
+import (
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeIntoEmptySink(t *testing.T) {
+	sink := checker.MakeTestSink()
+	tarBytes := checker.MakeTar(t, sink)
+
+	// extract until we're in the middle of an entry, then stop
+	var saved *savior.ExtractorCheckpoint
+	ex := tarextractor.New(seeksource.FromBytes(tarBytes))
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		if c.Entry == nil || c.Entry.WriteOffset == 0 {
+			return savior.AfterSaveContinue, nil
+		}
+		buf, err := savior.MarshalCheckpoint(c)
+		must(t, err)
+		saved, err = savior.UnmarshalCheckpoint(buf)
+		must(t, err)
+		return savior.AfterSaveStop, nil
+	}))
+	sink.Reset()
+	_, err := ex.Resume(nil, sink)
+	assert.Equal(t, savior.ErrStop, err)
+	if saved == nil {
+		t.Fatalf("never got a checkpoint in the middle of an entry")
+	}
+
+	// the tar stream can't go back to the start of the entry,
+	// so resuming into a sink that doesn't have it must fail
+	sink.Reset()
+	ex = tarextractor.New(seeksource.FromBytes(tarBytes))
+	_, err = ex.Resume(saved, sink)
+	assert.Equal(t, savior.ErrSinkMismatch, errors.Cause(err))
+}
@@ -0,0 +1,145 @@
+package tarextractor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/tar"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarDereferenceSymlinks(t *testing.T) {
+	assert := assert.New(t)
+
+	original := bytes.Repeat([]byte("original "), 1024)
+	inner := []byte("inner file")
+
+	makeTar := func(extra ...*tar.Header) []byte {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		writeFile := func(name string, contents []byte) {
+			must(t, tw.WriteHeader(&tar.Header{
+				Name:     name,
+				Typeflag: tar.TypeReg,
+				Size:     int64(len(contents)),
+				Mode:     0644,
+			}))
+			_, err := tw.Write(contents)
+			must(t, err)
+		}
+
+		writeFile("data/original.txt", original)
+		must(t, tw.WriteHeader(&tar.Header{
+			Name:     "data/dir/",
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+		}))
+		writeFile("data/dir/inner.txt", inner)
+		for _, hdr := range []*tar.Header{
+			{Name: "data/link.txt", Linkname: "original.txt"},
+			{Name: "other/link.txt", Linkname: "../data/original.txt"},
+			{Name: "other/chained.txt", Linkname: "link.txt"},
+			{Name: "other/dir", Linkname: "../data/dir"},
+		} {
+			hdr.Typeflag = tar.TypeSymlink
+			must(t, tw.WriteHeader(hdr))
+		}
+		for _, hdr := range extra {
+			must(t, tw.WriteHeader(hdr))
+		}
+		must(t, tw.Close())
+		return buf.Bytes()
+	}
+
+	extract := func(tarBytes []byte, configure func(fs *savior.FolderSink)) (string, error) {
+		dir, err := ioutil.TempDir("", "tarextractor-test")
+		must(t, err)
+
+		fs := &savior.FolderSink{
+			Directory:           dir,
+			Consumer:            savior.NopConsumer(),
+			DereferenceSymlinks: true,
+		}
+		if configure != nil {
+			configure(fs)
+		}
+		defer fs.Close()
+
+		_, err = tarextractor.New(seeksource.FromBytes(tarBytes)).Resume(nil, fs)
+		return dir, err
+	}
+
+	assertCopy := func(dir string, name string, expected []byte) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		stats, err := os.Lstat(p)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.True(stats.Mode().IsRegular(), "%s should be a regular file", name)
+
+		actual, err := ioutil.ReadFile(p)
+		must(t, err)
+		assert.True(bytes.Equal(expected, actual), "%s should have its target's contents", name)
+	}
+
+	dir, err := extract(makeTar(), nil)
+	defer os.RemoveAll(dir)
+	must(t, err)
+
+	assertCopy(dir, "data/link.txt", original)
+	assertCopy(dir, "other/link.txt", original)
+	assertCopy(dir, "other/chained.txt", original)
+	stats, err := os.Lstat(filepath.Join(dir, "other", "dir"))
+	must(t, err)
+	assert.True(stats.IsDir(), "directory symlinks should be copied as directories")
+	assertCopy(dir, "other/dir/inner.txt", inner)
+
+	// resuming overwrites previous copies
+	must(t, ioutil.WriteFile(filepath.Join(dir, "other", "link.txt"), []byte("stale"), 0644))
+	_, err = tarextractor.New(seeksource.FromBytes(makeTar())).Resume(nil, &savior.FolderSink{
+		Directory:           dir,
+		Consumer:            savior.NopConsumer(),
+		DereferenceSymlinks: true,
+	})
+	must(t, err)
+	assertCopy(dir, "other/link.txt", original)
+	assertCopy(dir, "other/dir/inner.txt", inner)
+
+	// dangling symlinks fail, or are skipped
+	dangling := &tar.Header{
+		Name:     "other/dangling.txt",
+		Typeflag: tar.TypeSymlink,
+		Linkname: "../data/nope.txt",
+	}
+	dir2, err := extract(makeTar(dangling), nil)
+	defer os.RemoveAll(dir2)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrDanglingSymlink)
+
+	dir3, err := extract(makeTar(dangling), func(fs *savior.FolderSink) {
+		fs.SkipDanglingSymlinks = true
+	})
+	defer os.RemoveAll(dir3)
+	must(t, err)
+	_, err = os.Lstat(filepath.Join(dir3, "other", "dangling.txt"))
+	assert.True(os.IsNotExist(err))
+	assertCopy(dir3, "other/link.txt", original)
+
+	// targets outside of the destination can't be dereferenced
+	dir4, err := extract(makeTar(&tar.Header{
+		Name:     "other/evil.txt",
+		Typeflag: tar.TypeSymlink,
+		Linkname: "../../evil.txt",
+	}), nil)
+	defer os.RemoveAll(dir4)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrUnsafePath)
+}
@@ -0,0 +1,100 @@
+package tarextractor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/tar"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarHardlinks(t *testing.T) {
+	sink := checker.MakeTestSink()
+
+	var targets []string
+	for _, item := range sink.Items {
+		if item.Entry.Kind == savior.EntryKindFile {
+			targets = append(targets, item.Entry.CanonicalPath)
+		}
+	}
+	if !assert.True(t, len(targets) >= 2) {
+		return
+	}
+	for _, target := range targets[:2] {
+		name := target + "-link"
+		sink.Items[name] = &checker.Item{
+			Entry: &savior.Entry{
+				CanonicalPath: name,
+				Kind:          savior.EntryKindHardlink,
+				Linkname:      target,
+			},
+		}
+	}
+
+	tarBytes := checker.MakeTar(t, sink)
+	testTarVariants(t, ".tar", int64(len(tarBytes)), seeksource.FromBytes(tarBytes), sink)
+}
+
+func TestTarHardlinksFolderSink(t *testing.T) {
+	assert := assert.New(t)
+
+	contents := bytes.Repeat([]byte("linked "), 1024)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "data/original.txt",
+		Typeflag: tar.TypeReg,
+		Size:     int64(len(contents)),
+		Mode:     0644,
+	}))
+	_, err := tw.Write(contents)
+	must(t, err)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "other/link.txt",
+		Typeflag: tar.TypeLink,
+		Linkname: "data/original.txt",
+	}))
+	must(t, tw.Close())
+	tarBytes := buf.Bytes()
+
+	dir, err := ioutil.TempDir("", "tarextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	fs := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+	defer fs.Close()
+
+	// twice, as if we were resuming after the link was made
+	for i := 0; i < 2; i++ {
+		res, err := tarextractor.New(seeksource.FromBytes(tarBytes)).Resume(nil, fs)
+		must(t, err)
+		if assert.Len(res.Entries, 2) {
+			assert.EqualValues(savior.EntryKindHardlink, res.Entries[1].Kind)
+		}
+	}
+
+	original, err := os.Stat(filepath.Join(dir, "data", "original.txt"))
+	must(t, err)
+	link, err := os.Stat(filepath.Join(dir, "other", "link.txt"))
+	must(t, err)
+	assert.True(os.SameFile(original, link))
+
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "other", "link.txt"))
+	must(t, err)
+	assert.True(bytes.Equal(contents, actual))
+
+	// sinks that can't make hardlinks
+	_, err = tarextractor.New(seeksource.FromBytes(tarBytes)).Resume(nil, &plainSink{fs})
+	assert.Equal(savior.ErrHardlinkUnsupported, errors.Cause(err))
+}
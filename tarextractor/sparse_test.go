@@ -0,0 +1,127 @@
+package tarextractor_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/arkive/tar"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeSparseTar returns a tar with a single GNU sparse file (PAX format
+// 0.1), with data only in `segments`, along with its full contents.
+func makeSparseTar(t *testing.T, name string, size int64, segments []savior.SparseSegment) ([]byte, []byte) {
+	contents := make([]byte, size)
+	var data []byte
+	var sparseMap []string
+	for _, seg := range segments {
+		for i := seg.Offset; i < seg.Offset+seg.Size; i++ {
+			contents[i] = byte(i%251) + 1
+		}
+		data = append(data, contents[seg.Offset:seg.Offset+seg.Size]...)
+		sparseMap = append(sparseMap, fmt.Sprintf("%d,%d", seg.Offset, seg.Size))
+	}
+
+	var records []byte
+	for _, kv := range [][2]string{
+		{"GNU.sparse.name", name},
+		{"GNU.sparse.size", fmt.Sprintf("%d", size)},
+		{"GNU.sparse.numblocks", fmt.Sprintf("%d", len(segments))},
+		{"GNU.sparse.map", strings.Join(sparseMap, ",")},
+	} {
+		records = append(records, paxRecord(kv[0], kv[1])...)
+	}
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "PaxHeaders/" + name,
+		Typeflag: tar.TypeXHeader,
+		Mode:     0644,
+		Size:     int64(len(records)),
+	}))
+	_, err := tw.Write(records)
+	must(t, err)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "GNUSparseFile/" + name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+	}))
+	_, err = tw.Write(data)
+	must(t, err)
+	must(t, tw.Close())
+
+	return buf.Bytes(), contents
+}
+
+// paxRecord formats a PAX record, whose length includes itself
+func paxRecord(k, v string) []byte {
+	size := len(k) + len(v) + 3
+	size += len(fmt.Sprintf("%d", size))
+	record := fmt.Sprintf("%d %s=%s\n", size, k, v)
+	if len(record) != size {
+		record = fmt.Sprintf("%d %s=%s\n", size+1, k, v)
+	}
+	return []byte(record)
+}
+
+// plainSink hides the SparseSink implementation of the sink it wraps
+type plainSink struct {
+	savior.Sink
+}
+
+func TestSparseFile(t *testing.T) {
+	assert := assert.New(t)
+
+	const size = 4 * 1024 * 1024
+	tarBytes, contents := makeSparseTar(t, "disk.img", size, []savior.SparseSegment{
+		{Offset: 0, Size: 4096},
+		{Offset: 1024 * 1024, Size: 64 * 1024},
+		{Offset: 3 * 1024 * 1024, Size: 100},
+	})
+
+	dir, err := ioutil.TempDir("", "tarextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, sparse := range []bool{true, false} {
+		dest := filepath.Join(dir, fmt.Sprintf("sparse-%v", sparse))
+		fs := &savior.FolderSink{
+			Directory: dest,
+			Consumer:  savior.NopConsumer(),
+		}
+		var sink savior.Sink = fs
+		if !sparse {
+			sink = &plainSink{fs}
+		}
+
+		ex := tarextractor.New(seeksource.FromBytes(tarBytes))
+		res, err := ex.Resume(nil, sink)
+		must(t, err)
+		must(t, sink.Close())
+		assert.Len(res.Entries, 1)
+
+		path := filepath.Join(dest, "disk.img")
+		actual, err := ioutil.ReadFile(path)
+		must(t, err)
+		assert.EqualValues(size, len(actual))
+		assert.True(bytes.Equal(contents, actual), "sparse: %v", sparse)
+
+		if allocated, ok := allocatedSize(t, path); ok {
+			if sparse {
+				assert.True(allocated < size/2, "should be sparse on disk, but %d bytes are allocated", allocated)
+			} else {
+				assert.True(allocated >= size, "should be written in full, but only %d bytes are allocated", allocated)
+			}
+		}
+	}
+}
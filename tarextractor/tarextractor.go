@@ -15,17 +15,40 @@ import (
 type tarExtractor struct {
 	source savior.Source
 
-	saveConsumer savior.SaveConsumer
-	consumer     *state.Consumer
+	saveConsumer        savior.SaveConsumer
+	checkpointFilter    savior.CheckpointFilter
+	memoryLowWatermark  int64
+	memoryHighWatermark int64
+	entryFilter         savior.EntryFilter
+	consumer            *state.Consumer
+	events              *savior.EventWriter
 }
 
 type TarExtractorState struct {
 	Result        *savior.ExtractorResult
 	TarCheckpoint *tar.Checkpoint
+
+	// SparseSegments is the hole map of the current entry, if it's
+	// sparse. The tar checkpoint only has what's left of it.
+	SparseSegments []savior.SparseSegment
 }
 
 var _ savior.Extractor = (*tarExtractor)(nil)
 
+var _ savior.PortableChecker = (*TarExtractorState)(nil)
+
+// Portable returns true if all entries extracted so far have relative paths
+func (tes *TarExtractorState) Portable() bool {
+	if tes.Result != nil {
+		for _, entry := range tes.Result.Entries {
+			if !entry.Portable() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func New(source savior.Source) savior.Extractor {
 	return &tarExtractor{
 		source:       source,
@@ -42,7 +65,42 @@ func (te *tarExtractor) SetConsumer(consumer *state.Consumer) {
 	te.consumer = consumer
 }
 
+func (te *tarExtractor) SetEventWriter(w io.Writer) {
+	te.events = savior.NewEventWriter(w)
+}
+
+func (te *tarExtractor) SetCheckpointFilter(filter savior.CheckpointFilter) {
+	te.checkpointFilter = filter
+}
+
+func (te *tarExtractor) SetMemoryGovernor(lowWatermark int64, highWatermark int64) {
+	te.memoryLowWatermark = lowWatermark
+	te.memoryHighWatermark = highWatermark
+}
+
+func (te *tarExtractor) SetEntryFilter(filter savior.EntryFilter) {
+	te.entryFilter = filter
+}
+
 func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
+	te.events.Start("tar", checkpoint)
+	res, err := te.resume(checkpoint, sink)
+
+	// done, stopped or failed, we won't read from the source anymore
+	closeErr := te.source.Close()
+	if err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "closing source")
+	}
+
+	if err != nil {
+		te.events.Error(err)
+		return nil, err
+	}
+	te.events.Done(res)
+	return res, nil
+}
+
+func (te *tarExtractor) resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
 	var sr tar.SaverReader
 	var state *TarExtractorState
 
@@ -59,7 +117,14 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					return nil, errors.WithStack(err)
 				}
 
+				// the tar checkpoint's offset is in decompressed space, and so
+				// is the offset the source resumed at - but the source may only
+				// be able to resume at block boundaries (for gzip, bzip2, etc.),
+				// so it's usually a little behind, never ahead.
 				tarCheckpoint := stateCheckpoint.TarCheckpoint
+				if offset > tarCheckpoint.Roffset {
+					return nil, errors.Errorf("tarextractor: source resumed at %d, past tar checkpoint at %d", offset, tarCheckpoint.Roffset)
+				}
 				if offset < tarCheckpoint.Roffset {
 					delta := tarCheckpoint.Roffset - offset
 					savior.Debugf("tarextractor: discarding %d bytes to align source and tar checkpoint", delta)
@@ -106,10 +171,14 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 
 	var stopError error
 
+	saveConsumer := savior.FilterSaveConsumer(te.events.WrapSaveConsumer(te.saveConsumer), te.checkpointFilter)
+	saveConsumer = savior.GovernSaveConsumer(saveConsumer, te.memoryLowWatermark, te.memoryHighWatermark)
+
 	// allocate a copy buffer once
-	copier := savior.NewCopier(te.saveConsumer)
+	copier := savior.NewCopier(saveConsumer)
 
 	var entry *savior.Entry
+	var writer savior.EntryWriter
 	te.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
 		OnSave: func(sourceCheckpoint *savior.SourceCheckpoint) error {
 			if entry == nil {
@@ -132,9 +201,14 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 			checkpoint.Data = state
 			checkpoint.Progress = te.source.Progress()
 
-			// FIXME: we're not syncing the writer here - but we should
+			if writer != nil {
+				err = writer.Sync()
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
 
-			action, err := te.saveConsumer.Save(checkpoint)
+			action, err := saveConsumer.Save(checkpoint)
 			if err != nil {
 				return errors.WithStack(err)
 			}
@@ -150,6 +224,7 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 	for stopError == nil {
 		err := func() error {
 			entry = nil
+			writer = nil
 
 			checkpoint.EntryIndex = entryIndex
 			entryIndex++
@@ -165,29 +240,30 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					return errors.WithStack(err)
 				}
 
-				entry := &savior.Entry{
-					CanonicalPath:    hdr.Name,
-					UncompressedSize: hdr.Size,
-					Mode:             os.FileMode(hdr.Mode),
+				entry := headerEntry(hdr)
+				if entry == nil {
+					return nil
 				}
-
-				switch hdr.Typeflag {
-				case tar.TypeDir:
-					entry.Kind = savior.EntryKindDir
-				case tar.TypeSymlink:
-					entry.Kind = savior.EntryKindSymlink
-					entry.Linkname = hdr.Linkname
-				case tar.TypeReg:
-					entry.Kind = savior.EntryKindFile
-				default:
-					// let's just ignore that one..
+				if te.entryFilter != nil && !te.entryFilter(entry) {
+					// its data is skipped by the next call to Next()
+					savior.Debugf(`tar: skipping %s, excluded by entry filter`, entry.CanonicalPath)
 					return nil
 				}
+
+				state.SparseSegments = nil
+				if entry.Kind == savior.EntryKindFile {
+					segments, err := sparseSegments(sr)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					state.SparseSegments = segments
+				}
 				checkpoint.Entry = entry
 			}
 			entry = checkpoint.Entry
 
 			te.consumer.Debugf("→ %s", entry)
+			te.events.EntryStart(entry)
 
 			switch entry.Kind {
 			case savior.EntryKindDir:
@@ -202,13 +278,40 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 				if err != nil {
 					return errors.WithStack(err)
 				}
+			case savior.EntryKindHardlink:
+				savior.Debugf(`tar: extracting hardlink %s => %s`, entry.CanonicalPath, entry.Linkname)
+				hs, ok := sink.(savior.HardlinkSink)
+				if !ok {
+					return errors.Wrapf(savior.ErrHardlinkUnsupported, "%s", entry.CanonicalPath)
+				}
+				err := hs.Hardlink(entry, entry.Linkname)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				state.Result.Entries = append(state.Result.Entries, entry)
 			case savior.EntryKindFile:
 				savior.Debugf(`tar: extracting file %s`, entry.CanonicalPath)
-				w, err := sink.GetWriter(entry)
+				// the tar stream can't be rewound to the start of the entry,
+				// so the sink must hold everything the checkpoint says it does
+				lowered, err := savior.ReconcileWriteOffset(sink, entry)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				if lowered {
+					return errors.Wrapf(savior.ErrSinkMismatch, "%s", entry.CanonicalPath)
+				}
+
+				var w savior.EntryWriter
+				if ss, ok := sink.(savior.SparseSink); ok && state.SparseSegments != nil {
+					w, err = ss.WriteSparse(entry, state.SparseSegments)
+				} else {
+					w, err = sink.GetWriter(entry)
+				}
 				if err != nil {
 					return errors.WithStack(err)
 				}
 				defer w.Close()
+				writer = w
 
 				err = copier.Do(&savior.CopyParams{
 					Dst:   w,
@@ -219,6 +322,7 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 
 					EmitProgress: func() {
 						te.consumer.Progress(te.source.Progress())
+						te.events.Progress(te.source.Progress())
 					},
 				})
 				if err != nil {
@@ -227,9 +331,14 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 
 				state.Result.Entries = append(state.Result.Entries, entry)
 				te.consumer.Progress(te.source.Progress())
+				te.events.Progress(te.source.Progress())
+			}
+			if stopError == nil {
+				te.events.EntryDone(entry)
 			}
 
 			checkpoint.Entry = nil
+			state.SparseSegments = nil
 			checkpoint.SourceCheckpoint = nil
 			checkpoint.Data = nil
 
@@ -251,6 +360,30 @@ func (te *tarExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 	return state.Result, nil
 }
 
+// sparseSegments returns the hole map of the entry the reader
+// was just positioned at, or nil if it's not a sparse file.
+func sparseSegments(sr tar.SaverReader) ([]savior.SparseSegment, error) {
+	c, err := sr.Save()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if c.CurrType != tar.CurrTypeSparse {
+		return nil, nil
+	}
+
+	segments := make([]savior.SparseSegment, 0, len(c.SparseSp))
+	for _, sp := range c.SparseSp {
+		if sp.NumBytes == 0 {
+			continue
+		}
+		segments = append(segments, savior.SparseSegment{
+			Offset: sp.Offset,
+			Size:   sp.NumBytes,
+		})
+	}
+	return segments, nil
+}
+
 func (te *tarExtractor) Features() savior.ExtractorFeatures {
 	sf := te.source.Features()
 
@@ -273,7 +406,74 @@ func (te *tarExtractor) Features() savior.ExtractorFeatures {
 	}
 }
 
+// ListEntries reads through the whole archive (decompressing it, if the source
+// does) to list the entries Resume would extract, without writing anything.
+func (te *tarExtractor) ListEntries() ([]*savior.Entry, error) {
+	_, err := te.source.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tr := tar.NewReader(te.source)
+	entries := []*savior.Entry{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		entry := headerEntry(hdr)
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// headerEntry returns the entry for a tar header, or nil for
+// headers that aren't extracted
+func headerEntry(hdr *tar.Header) *savior.Entry {
+	entry := &savior.Entry{
+		CanonicalPath:    hdr.Name,
+		UncompressedSize: hdr.Size,
+		Mode:             os.FileMode(hdr.Mode),
+		ModTime:          hdr.ModTime,
+		AccessTime:       hdr.AccessTime,
+		ChangeTime:       hdr.ChangeTime,
+	}
+
+	if savior.IsRootPath(entry.CanonicalPath) {
+		// typically "./", nothing to do for those
+		savior.Debugf(`tar: skipping %q, refers to the root`, entry.CanonicalPath)
+		return nil
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		entry.Kind = savior.EntryKindDir
+	case tar.TypeSymlink:
+		entry.Kind = savior.EntryKindSymlink
+		entry.Linkname = hdr.Linkname
+	case tar.TypeLink:
+		entry.Kind = savior.EntryKindHardlink
+		entry.Linkname = hdr.Linkname
+	case tar.TypeReg, tar.TypeGNUSparse:
+		entry.Kind = savior.EntryKindFile
+	default:
+		// let's just ignore that one..
+		return nil
+	}
+	if savior.IsDirectoryPath(entry.CanonicalPath) {
+		// a trailing slash always means directory
+		entry.Kind = savior.EntryKindDir
+		entry.Linkname = ""
+	}
+	return entry
+}
+
 func init() {
-	gob.Register(&TarExtractorState{})
+	savior.RegisterCheckpointData("tarextractor.TarExtractorState", &TarExtractorState{})
 	gob.Register(&tar.Checkpoint{})
 }
@@ -48,7 +48,10 @@ func testTarVariants(t *testing.T, ext string, size int64, source savior.Source,
 	makeExtractor := func() savior.Extractor {
 		return tarextractor.New(source)
 	}
+	runTarVariants(t, size, sink, makeExtractor)
+}
 
+func runTarVariants(t *testing.T, size int64, sink *checker.Sink, makeExtractor checker.MakeExtractorFunc) {
 	log.Printf("Testing .tar (%s), no resumes", united.FormatBytes(size))
 	checker.RunExtractorText(t, makeExtractor, sink, func() bool {
 		return false
@@ -66,3 +69,56 @@ func testTarVariants(t *testing.T, ext string, size int64, source savior.Source,
 		return i%2 == 0
 	})
 }
+
+func TestTarGzCheckpointLayers(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(30)
+
+	tarBytes := checker.MakeTar(t, sink)
+	gzipBytes, err := checker.GzipCompress(tarBytes)
+	must(t, err)
+
+	// every checkpoint should have a source checkpoint (in decompressed
+	// space) at or before the tar checkpoint, so that resuming only ever
+	// needs to discard forward.
+	var numCheckpoints int
+	makeExtractor := func() savior.Extractor {
+		ex := tarextractor.New(gzipsource.New(seeksource.FromBytes(gzipBytes)))
+		return &checkpointInspector{Extractor: ex, onSave: func(c *savior.ExtractorCheckpoint) {
+			numCheckpoints++
+			state, ok := c.Data.(*tarextractor.TarExtractorState)
+			if !assert.True(t, ok, "tar checkpoint should carry its state") {
+				return
+			}
+			if assert.NotNil(t, c.SourceCheckpoint) {
+				assert.True(t, c.SourceCheckpoint.Offset <= state.TarCheckpoint.Roffset,
+					"source checkpoint at %d is past tar checkpoint at %d", c.SourceCheckpoint.Offset, state.TarCheckpoint.Roffset)
+				_, isGzip := c.SourceCheckpoint.Data.(*gzipsource.GzipSourceCheckpoint)
+				assert.True(t, isGzip, "source checkpoint should capture gzip state")
+			}
+		}}
+	}
+
+	runTarVariants(t, int64(len(gzipBytes)), sink, makeExtractor)
+	assert.True(t, numCheckpoints > 0)
+}
+
+// checkpointInspector lets tests look at checkpoints before
+// they're handed to the actual save consumer
+type checkpointInspector struct {
+	savior.Extractor
+	onSave func(c *savior.ExtractorCheckpoint)
+}
+
+func (ci *checkpointInspector) SetSaveConsumer(sc savior.SaveConsumer) {
+	ci.Extractor.SetSaveConsumer(&inspectingSaveConsumer{sc, ci.onSave})
+}
+
+type inspectingSaveConsumer struct {
+	savior.SaveConsumer
+	onSave func(c *savior.ExtractorCheckpoint)
+}
+
+func (isc *inspectingSaveConsumer) Save(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	isc.onSave(c)
+	return isc.SaveConsumer.Save(c)
+}
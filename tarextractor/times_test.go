@@ -0,0 +1,103 @@
+package tarextractor_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// mtimeResolution finds out how precise modification times are in dir
+func mtimeResolution(t *testing.T, dir string) time.Duration {
+	probe := filepath.Join(dir, ".probe")
+	must(t, ioutil.WriteFile(probe, nil, 0644))
+	defer os.Remove(probe)
+
+	want := time.Date(2020, time.June, 18, 12, 30, 0, 123456789, time.UTC)
+	must(t, os.Chtimes(probe, want, want))
+	stats, err := os.Stat(probe)
+	must(t, err)
+
+	for _, res := range []time.Duration{time.Nanosecond, 100 * time.Nanosecond, time.Microsecond, time.Millisecond, time.Second} {
+		if stats.ModTime().Equal(want.Truncate(res)) {
+			return res
+		}
+	}
+	return 2 * time.Second
+}
+
+func TestTarTimes(t *testing.T) {
+	assert := assert.New(t)
+
+	mtime := time.Date(2019, time.March, 4, 5, 6, 7, 123456789, time.UTC)
+	atime := time.Date(2020, time.June, 18, 12, 30, 0, 987654321, time.UTC)
+	ctime := time.Date(2020, time.June, 18, 12, 31, 0, 555555555, time.UTC)
+	coarse := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	must(t, tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       "fine.txt",
+		Mode:       0644,
+		Size:       4,
+		ModTime:    mtime,
+		AccessTime: atime,
+		ChangeTime: ctime,
+		Format:     tar.FormatPAX,
+	}))
+	_, err := tw.Write([]byte("fine"))
+	must(t, err)
+	must(t, tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "coarse.txt",
+		Mode:     0644,
+		Size:     6,
+		ModTime:  coarse,
+		Format:   tar.FormatUSTAR,
+	}))
+	_, err = tw.Write([]byte("coarse"))
+	must(t, err)
+	must(t, tw.Close())
+
+	dir, err := ioutil.TempDir("", "tarextractor-times")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &savior.FolderSink{Directory: dir}
+	res, err := tarextractor.New(seeksource.FromBytes(buf.Bytes())).Resume(nil, sink)
+	must(t, err)
+	must(t, sink.Close())
+
+	fine := res.Entries[0]
+	assert.True(mtime.Equal(fine.ModTime), "mtime %s", fine.ModTime)
+	assert.True(atime.Equal(fine.AccessTime), "atime %s", fine.AccessTime)
+	assert.True(ctime.Equal(fine.ChangeTime), "ctime %s", fine.ChangeTime)
+	assert.True(coarse.Equal(res.Entries[1].ModTime))
+	assert.True(res.Entries[1].AccessTime.IsZero())
+
+	resolution := mtimeResolution(t, dir)
+	t.Logf("mtime resolution of %s: %s", dir, resolution)
+
+	stats, err := os.Stat(filepath.Join(dir, "fine.txt"))
+	must(t, err)
+	if resolution == time.Nanosecond {
+		assert.True(mtime.Equal(stats.ModTime()), "mtime on disk %s", stats.ModTime())
+	} else {
+		// truncated, but not off by more than that
+		assert.False(stats.ModTime().After(mtime))
+		assert.True(mtime.Sub(stats.ModTime()) < resolution)
+	}
+
+	stats, err = os.Stat(filepath.Join(dir, "coarse.txt"))
+	must(t, err)
+	assert.True(coarse.Equal(stats.ModTime()))
+}
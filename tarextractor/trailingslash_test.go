@@ -0,0 +1,76 @@
+package tarextractor_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// renameFirstHeader overwrites the name of the first header of a tar
+// archive with one of the same length, and fixes up its checksum.
+func renameFirstHeader(tarBytes []byte, name string) {
+	copy(tarBytes[0:len(name)], name)
+
+	chksum := tarBytes[148:156]
+	for i := range chksum {
+		chksum[i] = ' '
+	}
+	var sum int64
+	for _, b := range tarBytes[:512] {
+		sum += int64(b)
+	}
+	copy(chksum, fmt.Sprintf("%06o\x00", sum))
+}
+
+func TestTrailingSlashIsDirectory(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	// a regular file header, but the trailing slash wins
+	// (archive/tar refuses to write those, see renameFirstHeader)
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "dirX",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+	}))
+	must(t, tw.WriteHeader(&tar.Header{
+		Name:     "dir/file.txt",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     5,
+	}))
+	_, err := tw.Write([]byte("hello"))
+	must(t, err)
+	must(t, tw.Close())
+	renameFirstHeader(buf.Bytes(), "dir/")
+
+	dir, err := ioutil.TempDir("", "tarextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+	defer sink.Close()
+
+	ex := tarextractor.New(seeksource.FromBytes(buf.Bytes()))
+	_, err = ex.Resume(nil, sink)
+	must(t, err)
+
+	stats, err := os.Stat(filepath.Join(dir, "dir"))
+	must(t, err)
+	assert.True(t, stats.IsDir())
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "dir", "file.txt"))
+	must(t, err)
+	assert.EqualValues(t, "hello", string(bs))
+}
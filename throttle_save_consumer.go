@@ -0,0 +1,64 @@
+package savior
+
+import "time"
+
+// throttleNow is a variable so tests can simulate time passing
+var throttleNow = time.Now
+
+// ThrottleSaveConsumer asks for a checkpoint every `everyBytes` bytes
+// extracted, or every `everyDuration`, whichever comes first, and passes
+// them to a callback. Frequent checkpoints lose less work when extraction
+// is interrupted, sparse ones have less overhead.
+//
+// Both are counted from the last checkpoint (or the first call to
+// ShouldSave). Extractors can only save at some points (the end of a deflate
+// block, for example), so checkpoints are usually a little further apart.
+type ThrottleSaveConsumer struct {
+	everyBytes    int64
+	everyDuration time.Duration
+	onSave        func(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error)
+
+	// bytes extracted since the last checkpoint
+	bytes int64
+	// when the last checkpoint was saved
+	last time.Time
+}
+
+var _ SaveConsumer = (*ThrottleSaveConsumer)(nil)
+
+// NewThrottleSaveConsumer returns a consumer that asks for checkpoints
+// every `everyBytes` bytes or `everyDuration`, and calls `onSave` with them.
+// Either can be 0 to only use the other one. What `onSave` returns tells
+// the extractor whether to go on, a nil `onSave` always does.
+func NewThrottleSaveConsumer(everyBytes int64, everyDuration time.Duration, onSave func(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error)) *ThrottleSaveConsumer {
+	return &ThrottleSaveConsumer{
+		everyBytes:    everyBytes,
+		everyDuration: everyDuration,
+		onSave:        onSave,
+	}
+}
+
+func (tsc *ThrottleSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	if tsc.last.IsZero() {
+		tsc.last = throttleNow()
+	}
+	tsc.bytes += copiedBytes
+
+	if tsc.everyBytes > 0 && tsc.bytes >= tsc.everyBytes {
+		return true
+	}
+	if tsc.everyDuration > 0 && throttleNow().Sub(tsc.last) >= tsc.everyDuration {
+		return true
+	}
+	return false
+}
+
+func (tsc *ThrottleSaveConsumer) Save(checkpoint *ExtractorCheckpoint) (AfterSaveAction, error) {
+	tsc.bytes = 0
+	tsc.last = throttleNow()
+
+	if tsc.onSave == nil {
+		return AfterSaveContinue, nil
+	}
+	return tsc.onSave(checkpoint)
+}
@@ -0,0 +1,74 @@
+package savior_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_ThrottleSaveConsumer lives here rather than in checker: it needs
+// time to pass as the extractor writes, and only savior's own tests can
+// replace ThrottleSaveConsumer's clock, see SetThrottleClock.
+func Test_ThrottleSaveConsumer(t *testing.T) {
+	const size = 8 * 1024 * 1024
+	data, archives := makeTextArchives(t, size)
+
+	// the destination writes a megabyte per second, so checkpoints should
+	// be `spacing` bytes apart, plus however long it takes to get to a point
+	// the extractor can save at (the end of a deflate block, for example)
+	const slack = 512 * 1024
+	testCases := []struct {
+		name          string
+		everyBytes    int64
+		everyDuration time.Duration
+		spacing       int64
+	}{
+		{"bytes", 1024 * 1024, 0, 1024 * 1024},
+		{"duration", 0, 2 * time.Second, 2 * 1024 * 1024},
+		{"bytes first", 1024 * 1024, 10 * time.Second, 1024 * 1024},
+		{"duration first", 4 * 1024 * 1024, time.Second, 1024 * 1024},
+		{"sparse", 16 * 1024 * 1024, time.Hour, 16 * 1024 * 1024},
+	}
+
+	for kind, archive := range archives {
+		for _, tc := range testCases {
+			t.Run(kind+"/"+tc.name, func(t *testing.T) {
+				assert := assert.New(t)
+
+				ms := savior.NewMemorySink()
+				sink := savior.NewCountingSink(ms)
+
+				start := time.Now()
+				defer savior.SetThrottleClock(func() time.Time {
+					return start.Add(time.Duration(sink.Stats().Bytes) * time.Second / (1024 * 1024))
+				})()
+
+				var offsets []int64
+				sc := savior.NewThrottleSaveConsumer(tc.everyBytes, tc.everyDuration, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+					offsets = append(offsets, sink.Stats().Bytes)
+					return savior.AfterSaveContinue, nil
+				})
+
+				ex := makeGobTestExtractor(t, kind, archive)
+				ex.SetSaveConsumer(sc)
+				_, err := ex.Resume(nil, sink)
+				tmust(t, err)
+
+				actual, _, _ := ms.GetEntry("data.txt")
+				assert.EqualValues(len(data), len(actual))
+
+				t.Logf("checkpoints at %v", offsets)
+				assert.True(len(offsets) <= size/int(tc.spacing))
+				previous := int64(0)
+				for _, offset := range offsets {
+					assert.True(offset-previous >= tc.spacing, "checkpoint at %d is too early", offset)
+					assert.True(offset-previous < tc.spacing+slack, "checkpoint at %d is too late", offset)
+					previous = offset
+				}
+				assert.True(size-previous < tc.spacing+slack, "should have made all the checkpoints it could")
+			})
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tracesource
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/itchio/savior"
+)
+
+type traceSource struct {
+	// input
+	source savior.Source
+	w      io.Writer
+
+	// internal
+	offset int64
+
+	// consecutive ReadByte calls are coalesced into a single line
+	byteRunStart int64
+	byteRunCount int64
+}
+
+var _ savior.Source = (*traceSource)(nil)
+
+// New returns a source that forwards everything to `source`, and writes
+// a line to `w` for each Resume, Read, ReadByte (coalesced), WantSave and
+// checkpoint emitted, along with the offsets involved.
+//
+// Diffing the trace of a clean run with that of a resumed run is
+// a good way to find where they diverge.
+func New(source savior.Source, w io.Writer) *traceSource {
+	return &traceSource{
+		source: source,
+		w:      w,
+	}
+}
+
+func (ts *traceSource) logf(format string, args ...interface{}) {
+	ts.flushByteRun()
+	fmt.Fprintf(ts.w, format+"\n", args...)
+}
+
+// Flush writes out any pending (coalesced) ReadByte line.
+func (ts *traceSource) Flush() {
+	ts.flushByteRun()
+}
+
+func (ts *traceSource) flushByteRun() {
+	if ts.byteRunCount > 0 {
+		fmt.Fprintf(ts.w, "readbyte offset=%d count=%d\n", ts.byteRunStart, ts.byteRunCount)
+		ts.byteRunCount = 0
+	}
+}
+
+func (ts *traceSource) Features() savior.SourceFeatures {
+	return ts.source.Features()
+}
+
+func (ts *traceSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	offset, err := ts.source.Resume(checkpoint)
+	if checkpoint == nil {
+		ts.logf("resume checkpoint=nil offset=%d err=%v", offset, err)
+	} else {
+		ts.logf("resume checkpoint=%d offset=%d err=%v", checkpoint.Offset, offset, err)
+	}
+	ts.offset = offset
+	return offset, err
+}
+
+func (ts *traceSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	ts.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			if checkpoint == nil {
+				ts.logf("save checkpoint=nil offset=%d", ts.offset)
+			} else {
+				ts.logf("save checkpoint=%d offset=%d", checkpoint.Offset, ts.offset)
+			}
+			return ssc.Save(checkpoint)
+		},
+	})
+}
+
+func (ts *traceSource) WantSave() {
+	ts.logf("wantsave offset=%d", ts.offset)
+	ts.source.WantSave()
+}
+
+func (ts *traceSource) Close() error {
+	err := ts.source.Close()
+	ts.logf("close offset=%d err=%v", ts.offset, err)
+	return err
+}
+
+func (ts *traceSource) Progress() float64 {
+	return ts.source.Progress()
+}
+
+func (ts *traceSource) Read(buf []byte) (int, error) {
+	offset := ts.offset
+	n, err := ts.source.Read(buf)
+	ts.offset += int64(n)
+	ts.logf("read offset=%d len=%d n=%d err=%v", offset, len(buf), n, err)
+	return n, err
+}
+
+func (ts *traceSource) ReadByte() (byte, error) {
+	offset := ts.offset
+	b, err := ts.source.ReadByte()
+	if err != nil {
+		ts.logf("readbyte offset=%d err=%v", offset, err)
+		return b, err
+	}
+
+	if ts.byteRunCount == 0 {
+		ts.byteRunStart = offset
+	}
+	ts.byteRunCount++
+	ts.offset++
+	return b, nil
+}
@@ -0,0 +1,76 @@
+package tracesource_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/tracesource"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Trace(t *testing.T) {
+	reference := semirandom.Bytes(1024)
+
+	trace := new(bytes.Buffer)
+	ts := tracesource.New(seeksource.FromBytes(reference), trace)
+
+	var checkpoint *savior.SourceCheckpoint
+	ts.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			checkpoint = c
+			return nil
+		},
+	})
+
+	_, err := ts.Resume(nil)
+	must(t, err)
+
+	buf := make([]byte, 256)
+	_, err = io.ReadFull(ts, buf)
+	must(t, err)
+
+	ts.WantSave()
+	for i := 0; i < 3; i++ {
+		_, err = ts.ReadByte()
+		must(t, err)
+	}
+	assert.NotNil(t, checkpoint)
+
+	_, err = ts.Resume(checkpoint)
+	must(t, err)
+
+	for {
+		_, err = ts.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		must(t, err)
+	}
+	ts.Flush()
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	assert.EqualValues(t, []string{
+		"resume checkpoint=nil offset=0 err=<nil>",
+		"read offset=0 len=256 n=256 err=<nil>",
+		"wantsave offset=256",
+		"save checkpoint=256 offset=256",
+		"readbyte offset=256 count=3",
+		"resume checkpoint=256 offset=256 err=<nil>",
+		"read offset=256 len=256 n=256 err=<nil>",
+		"read offset=512 len=256 n=256 err=<nil>",
+		"read offset=768 len=256 n=256 err=<nil>",
+		"read offset=1024 len=256 n=0 err=EOF",
+	}, lines)
+}
+
+func must(t *testing.T, err error) {
+	if err != nil {
+		assert.NoError(t, err)
+		t.FailNow()
+	}
+}
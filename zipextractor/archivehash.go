@@ -0,0 +1,67 @@
+package zipextractor
+
+import (
+	"crypto"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// HashArchive makes Resume compute the `algo` hash of the whole archive
+// once extraction completes, see ArchiveHash. If `sidecarPath` isn't
+// empty, the hash is also written there, in the format of `sha256sum`
+// and friends (the file name is that of SetSourcePath, if set).
+//
+// Since entries are read at random (and not at all when resuming past
+// them), the archive is read again from start to end for this.
+func (ze *ZipExtractor) HashArchive(sidecarPath string, algo crypto.Hash) {
+	ze.archiveHashPath = sidecarPath
+	ze.archiveHashAlgo = algo
+}
+
+// ArchiveHash returns the hash computed after a successful Resume,
+// or nil if HashArchive wasn't called
+func (ze *ZipExtractor) ArchiveHash() []byte {
+	return ze.archiveHash
+}
+
+// hashArchive reads the whole archive to compute its hash,
+// and writes the sidecar, if any
+func (ze *ZipExtractor) hashArchive() error {
+	if !ze.archiveHashAlgo.Available() {
+		return errors.Errorf("zipextractor: archive hash algorithm %d is not available", ze.archiveHashAlgo)
+	}
+
+	h := ze.archiveHashAlgo.New()
+	_, err := io.Copy(h, io.NewSectionReader(ze.reader, 0, ze.readerSize))
+	if err != nil {
+		return errors.Wrap(err, "hashing archive")
+	}
+	ze.archiveHash = h.Sum(nil)
+
+	if ze.archiveHashPath == "" {
+		return nil
+	}
+
+	name := "-"
+	if ze.sourcePath != "" {
+		name = filepath.Base(ze.sourcePath)
+	}
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(ze.archiveHash), name)
+
+	partialPath := ze.archiveHashPath + ".partial"
+	err = ioutil.WriteFile(partialPath, []byte(line), 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = os.Rename(partialPath, ze.archiveHashPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package zipextractor_test
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "data/"},
+		{Name: "data/big.bin", Data: semirandom.Bytes(2 * 1024 * 1024), Method: zip.Deflate},
+		{Name: "data/stored.bin", Data: semirandom.Bytes(1024 * 1024), Method: zip.Store},
+		{Name: "readme.txt", Data: []byte("hello")},
+	})
+	expected := sha256.Sum256(zipBytes)
+
+	tmpDir, err := ioutil.TempDir("", "zipextractor-archivehash")
+	must(t, err)
+	defer os.RemoveAll(tmpDir)
+	sidecarPath := filepath.Join(tmpDir, "game.zip.sha256")
+
+	// resuming doesn't read skipped entries again, the hash still covers them
+	sink := savior.NewMemorySink()
+	var c *savior.ExtractorCheckpoint
+	numResumes := 0
+	for {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetSourcePath("/downloads/game.zip")
+		ex.HashArchive(sidecarPath, crypto.SHA256)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(512*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if c != nil && checkpoint.Progress <= c.Progress {
+				return savior.AfterSaveContinue, nil
+			}
+			c = checkpoint
+			return savior.AfterSaveStop, nil
+		}))
+
+		_, err := ex.Resume(c, sink)
+		if errors.Cause(err) == savior.ErrStop {
+			assert.Nil(ex.ArchiveHash())
+			_, statErr := os.Stat(sidecarPath)
+			assert.True(os.IsNotExist(statErr), "sidecar should only be written once done")
+			numResumes++
+			continue
+		}
+		must(t, err)
+
+		assert.EqualValues(expected[:], ex.ArchiveHash())
+		break
+	}
+	assert.True(numResumes > 0)
+
+	sidecar, err := ioutil.ReadFile(sidecarPath)
+	must(t, err)
+	assert.Equal(hex.EncodeToString(expected[:])+"  game.zip\n", string(sidecar))
+
+	// without a sidecar
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.HashArchive("", crypto.SHA256)
+	_, err = ex.Resume(nil, savior.NewMemorySink())
+	must(t, err)
+	assert.EqualValues(expected[:], ex.ArchiveHash())
+
+	// or without asking for it at all
+	ex = newTestZipExtractor(t, zipBytes)
+	_, err = ex.Resume(nil, savior.NewMemorySink())
+	must(t, err)
+	assert.Nil(ex.ArchiveHash())
+}
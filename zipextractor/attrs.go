@@ -0,0 +1,195 @@
+package zipextractor
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The zip reader skips over the "internal file attributes" field of
+// central directory records, whose bit 0 marks text files, and doesn't
+// expose where local headers are (see prefetch.go), so we read the
+// central directory ourselves to get them.
+
+const (
+	directoryEndSignature              = 0x06054b50
+	directoryEndLen                    = 22
+	directory64LocatorSignature        = 0x07064b50
+	directory64LocatorLen              = 20
+	directory64EndSignature            = 0x06064b50
+	directory64EndLen                  = 56
+	directoryHeaderSignature           = 0x02014b50
+	directoryHeaderLen                 = 46
+	maxCommentLen                      = 0xffff
+	internalAttrsOffset                = 36
+	headerOffsetOffset                 = 42
+	zip64ExtraID                       = 0x0001
+	internalAttrText            uint16 = 1 << 0
+)
+
+// A centralRecord is what we need from a central directory record
+type centralRecord struct {
+	internalAttrs uint16
+	// where the entry's local header starts, accounting for
+	// any prefix (like self-extracting executables have),
+	// -1 if unknown
+	headerOffset int64
+}
+
+// A centralDirectory lists the records of every entry of
+// the archive, in central directory order.
+type centralDirectory struct {
+	records []centralRecord
+	// where the central directory starts
+	start int64
+}
+
+func readCentralDirectory(r io.ReaderAt, size int64) (*centralDirectory, error) {
+	// find the end of central directory record
+	blockLen := int64(directoryEndLen + maxCommentLen)
+	if blockLen > size {
+		blockLen = size
+	}
+	block := make([]byte, blockLen)
+	_, err := r.ReadAt(block, size-blockLen)
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+
+	endPos := int64(-1)
+	for i := len(block) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(block[i:]) != directoryEndSignature {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(block[i+20:]))
+		if i+directoryEndLen+commentLen <= len(block) {
+			endPos = size - blockLen + int64(i)
+			break
+		}
+	}
+	if endPos < 0 {
+		return nil, errors.New("zip: end of central directory not found")
+	}
+	end := block[endPos-(size-blockLen):]
+
+	numRecords := int64(binary.LittleEndian.Uint16(end[10:]))
+	dirSize := int64(binary.LittleEndian.Uint32(end[12:]))
+	dirOffset := int64(binary.LittleEndian.Uint32(end[16:]))
+	// computing the directory's position from the end record's (rather than
+	// using the recorded offset) also works for archives with a prefix,
+	// like self-extracting executables.
+	dirEnd := endPos
+
+	if numRecords == 0xffff || dirSize == 0xffffffff {
+		// zip64: the zip64 end record sits just before its locator,
+		// which sits just before the regular end record
+		locatorPos := endPos - directory64LocatorLen
+		end64Pos := locatorPos - directory64EndLen
+		if end64Pos < 0 {
+			return nil, errors.New("zip: zip64 end of central directory not found")
+		}
+
+		locator := make([]byte, 4)
+		_, err := r.ReadAt(locator, locatorPos)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		end64 := make([]byte, directory64EndLen)
+		_, err = r.ReadAt(end64, end64Pos)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if binary.LittleEndian.Uint32(locator) != directory64LocatorSignature || binary.LittleEndian.Uint32(end64) != directory64EndSignature {
+			return nil, errors.New("zip: invalid zip64 end of central directory")
+		}
+
+		numRecords = int64(binary.LittleEndian.Uint64(end64[32:]))
+		dirSize = int64(binary.LittleEndian.Uint64(end64[40:]))
+		dirOffset = int64(binary.LittleEndian.Uint64(end64[48:]))
+		dirEnd = end64Pos
+	}
+
+	dirStart := dirEnd - dirSize
+	if dirStart < 0 || numRecords > dirSize/directoryHeaderLen {
+		return nil, errors.New("zip: invalid central directory size")
+	}
+
+	// how many bytes come before the archive proper
+	prefixLen := dirStart - dirOffset
+
+	dir := make([]byte, dirSize)
+	_, err = r.ReadAt(dir, dirStart)
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+
+	cd := &centralDirectory{
+		records: make([]centralRecord, 0, numRecords),
+		start:   dirStart,
+	}
+	for len(dir) >= directoryHeaderLen {
+		if binary.LittleEndian.Uint32(dir) != directoryHeaderSignature {
+			return nil, errors.New("zip: invalid central directory header")
+		}
+
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(dir[32:]))
+		recordLen := directoryHeaderLen + nameLen + extraLen + commentLen
+		if recordLen > len(dir) {
+			return nil, errors.New("zip: truncated central directory header")
+		}
+
+		headerOffset := int64(binary.LittleEndian.Uint32(dir[headerOffsetOffset:]))
+		if headerOffset == 0xffffffff {
+			extra := dir[directoryHeaderLen+nameLen : directoryHeaderLen+nameLen+extraLen]
+			headerOffset = zip64HeaderOffset(dir, extra)
+		}
+
+		if headerOffset >= 0 {
+			headerOffset += prefixLen
+		}
+
+		cd.records = append(cd.records, centralRecord{
+			internalAttrs: binary.LittleEndian.Uint16(dir[internalAttrsOffset:]),
+			headerOffset:  headerOffset,
+		})
+		dir = dir[recordLen:]
+	}
+
+	return cd, nil
+}
+
+// zip64HeaderOffset finds the local header offset in the zip64 extra
+// field of a central directory record, or returns -1. The field only
+// contains the values that didn't fit in the record, in a fixed order.
+func zip64HeaderOffset(record []byte, extra []byte) int64 {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		fieldLen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+fieldLen > len(extra) {
+			break
+		}
+		field := extra[4 : 4+fieldLen]
+		extra = extra[4+fieldLen:]
+		if id != zip64ExtraID {
+			continue
+		}
+
+		// skip the sizes if they're in there
+		for _, pos := range []int{24, 20} {
+			if binary.LittleEndian.Uint32(record[pos:]) == 0xffffffff {
+				if len(field) < 8 {
+					return -1
+				}
+				field = field[8:]
+			}
+		}
+		if len(field) < 8 {
+			return -1
+		}
+		return int64(binary.LittleEndian.Uint64(field))
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/stretchr/testify/assert"
+)
+
+// markAsText sets bit 0 of the internal attributes of the central
+// directory record for name, which the zip writer never does.
+func markAsText(t *testing.T, zipBytes []byte, name string) {
+	sig := []byte("PK\x01\x02")
+	for i := 0; i+46 <= len(zipBytes); i++ {
+		if !bytes.Equal(zipBytes[i:i+4], sig) {
+			continue
+		}
+		nameLen := int(binary.LittleEndian.Uint16(zipBytes[i+28:]))
+		if string(zipBytes[i+46:i+46+nameLen]) == name {
+			zipBytes[i+36] |= 1
+			return
+		}
+	}
+	t.Fatalf("no central directory record for %s", name)
+}
+
+func TestTextEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "LICENSE", Data: []byte("line one\r\nline two\r\n")},
+		{Name: "game.exe", Data: []byte("MZ\r\n\x00\x01\r\n")},
+	})
+	markAsText(t, zipBytes, "LICENSE")
+
+	ex := newTestZipExtractor(t, zipBytes)
+	entries := ex.Entries()
+	assert.True(entries[0].IsText)
+	assert.False(entries[1].IsText)
+
+	dir, err := ioutil.TempDir("", "zipextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &savior.FolderSink{
+		Directory:      dir,
+		Consumer:       savior.NopConsumer(),
+		TextLineEnding: savior.LineLF,
+	}
+	_, err = ex.Resume(nil, sink)
+	must(t, err)
+	must(t, sink.Close())
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "LICENSE"))
+	must(t, err)
+	assert.EqualValues("line one\nline two\n", string(bs))
+
+	bs, err = ioutil.ReadFile(filepath.Join(dir, "game.exe"))
+	must(t, err)
+	assert.EqualValues("MZ\r\n\x00\x01\r\n", string(bs))
+}
@@ -0,0 +1,120 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func manySmallFilesZip(t testing.TB, method uint16) ([]byte, int64) {
+	var entries []testZipEntry
+	var total int64
+	for i := 0; i < 2000; i++ {
+		data := semirandom.Bytes(int64(1024 + i%4096))
+		entries = append(entries, testZipEntry{
+			Name:   fmt.Sprintf("dir%d/file%d.dat", i%20, i),
+			Data:   data,
+			Method: method,
+		})
+		total += int64(len(data))
+	}
+	return makeTestZip(t, entries), total
+}
+
+func hugeFileZip(t testing.TB, method uint16) ([]byte, int64) {
+	data := semirandom.Bytes(64 * 1024 * 1024)
+	return makeTestZip(t, []testZipEntry{
+		{Name: "huge.dat", Data: data, Method: method},
+	}), int64(len(data))
+}
+
+// benchmarkExtract extracts to a NopSink, so only the codec and
+// the extractor's own bookkeeping are measured. If saveEvery is
+// non-zero, a checkpoint is taken every saveEvery bytes (and
+// extraction goes on).
+func benchmarkExtract(b *testing.B, zipBytes []byte, totalBytes int64, saveEvery int64) {
+	b.SetBytes(totalBytes)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if saveEvery > 0 {
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(saveEvery, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				return savior.AfterSaveContinue, nil
+			}))
+		}
+
+		_, err = ex.Resume(nil, &savior.NopSink{})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		stats := ex.Stats()
+		if stats.BytesWritten != totalBytes {
+			b.Fatalf("wrote %d bytes, expected %d", stats.BytesWritten, totalBytes)
+		}
+		if saveEvery > 0 && stats.Checkpoints == 0 {
+			b.Fatalf("expected checkpoints to be taken")
+		}
+	}
+}
+
+func BenchmarkExtractManySmallFilesStore(b *testing.B) {
+	zipBytes, total := manySmallFilesZip(b, zip.Store)
+	benchmarkExtract(b, zipBytes, total, 0)
+}
+
+func BenchmarkExtractManySmallFilesDeflate(b *testing.B) {
+	zipBytes, total := manySmallFilesZip(b, zip.Deflate)
+	benchmarkExtract(b, zipBytes, total, 0)
+}
+
+func BenchmarkExtractHugeFileStore(b *testing.B) {
+	zipBytes, total := hugeFileZip(b, zip.Store)
+	benchmarkExtract(b, zipBytes, total, 0)
+}
+
+func BenchmarkExtractHugeFileDeflate(b *testing.B) {
+	zipBytes, total := hugeFileZip(b, zip.Deflate)
+	benchmarkExtract(b, zipBytes, total, 0)
+}
+
+func BenchmarkExtractHugeFileStoreCheckpoints(b *testing.B) {
+	zipBytes, total := hugeFileZip(b, zip.Store)
+	benchmarkExtract(b, zipBytes, total, 256*1024)
+}
+
+func BenchmarkExtractHugeFileDeflateCheckpoints(b *testing.B) {
+	zipBytes, total := hugeFileZip(b, zip.Deflate)
+	benchmarkExtract(b, zipBytes, total, 256*1024)
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes, total := hugeFileZip(t, zip.Deflate)
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		return savior.AfterSaveContinue, nil
+	}))
+	_, err := ex.Resume(nil, &savior.NopSink{})
+	must(t, err)
+
+	stats := ex.Stats()
+	assert.EqualValues(total, stats.BytesWritten)
+	assert.True(stats.BytesRead >= int64(len(zipBytes))-1024, "should have read (about) the whole archive")
+	assert.True(stats.Checkpoints > 0)
+	assert.EqualValues(stats.Checkpoints, stats.Syncs, "should sync once per checkpoint")
+}
@@ -0,0 +1,75 @@
+package zipextractor
+
+import (
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrBudgetExceeded is returned by Resume when extraction used up the
+// step budget set with SetStepBudget.
+var ErrBudgetExceeded = errors.New("zipextractor: step budget exceeded")
+
+// SetStepBudget bounds how much work a single call to Resume does, for
+// archives that can't be trusted: every entry written to the sink is a step,
+// and so is every buffer written to an entry. Once `steps` are used up,
+// Resume returns ErrBudgetExceeded (without emitting a checkpoint). Zero
+// means no budget. Unlike SetDeadline, it doesn't depend on how fast the
+// machine is, so it's suited to tests and fuzzing.
+func (ze *ZipExtractor) SetStepBudget(steps int64) {
+	ze.stepBudget = steps
+}
+
+// budgetSink counts steps, and fails once there are none left
+type budgetSink struct {
+	savior.Sink
+	remaining int64
+}
+
+func (bs *budgetSink) step() error {
+	if bs.remaining <= 0 {
+		return errors.WithStack(ErrBudgetExceeded)
+	}
+	bs.remaining--
+	return nil
+}
+
+func (bs *budgetSink) Mkdir(entry *savior.Entry) error {
+	err := bs.step()
+	if err != nil {
+		return err
+	}
+	return bs.Sink.Mkdir(entry)
+}
+
+func (bs *budgetSink) Symlink(entry *savior.Entry, linkname string) error {
+	err := bs.step()
+	if err != nil {
+		return err
+	}
+	return bs.Sink.Symlink(entry, linkname)
+}
+
+func (bs *budgetSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	err := bs.step()
+	if err != nil {
+		return nil, err
+	}
+	w, err := bs.Sink.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &budgetEntryWriter{EntryWriter: w, bs: bs}, nil
+}
+
+type budgetEntryWriter struct {
+	savior.EntryWriter
+	bs *budgetSink
+}
+
+func (bew *budgetEntryWriter) Write(buf []byte) (int, error) {
+	err := bew.bs.step()
+	if err != nil {
+		return 0, err
+	}
+	return bew.EntryWriter.Write(buf)
+}
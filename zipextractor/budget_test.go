@@ -0,0 +1,31 @@
+package zipextractor_test
+
+import (
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepBudget(t *testing.T) {
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir/big.bin", Data: semirandom.Bytes(4 * 1024 * 1024), Method: zip.Deflate},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetStepBudget(16)
+	_, err := ex.Resume(nil, &savior.NopSink{})
+	assert.Equal(t, zipextractor.ErrBudgetExceeded, errors.Cause(err))
+
+	// the budget is per call to Resume
+	ex.SetStepBudget(1024)
+	for i := 0; i < 2; i++ {
+		_, err = ex.Resume(nil, &savior.NopSink{})
+		must(t, err)
+	}
+}
@@ -0,0 +1,97 @@
+package zipextractor
+
+import (
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// openChecked returns a reader for the decompressed contents of zf (which
+// mustn't be a delta entry), that checks them against the size and CRC-32
+// the central directory records once it reaches the end. Those are the
+// ones that count: entries written in streaming mode (general purpose
+// bit 3) have zeros for them in their local header, and the actual values
+// in a data descriptor after their data, which is never read. Compressed
+// data is read within the bounds the central directory gives, so it can't
+// run into the data descriptor either.
+func (ze *ZipExtractor) openChecked(zf *zip.File) (io.ReadCloser, error) {
+	rc, err := ze.openRaw(zf)
+	if err != nil {
+		return nil, err
+	}
+	return &checkedReader{
+		rc:   rc,
+		zf:   zf,
+		hash: crc32.NewIEEE(),
+	}, nil
+}
+
+// openRaw returns a reader for the decompressed contents of zf,
+// without any checks
+func (ze *ZipExtractor) openRaw(zf *zip.File) (io.ReadCloser, error) {
+	src, err := ze.entrySource(zf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if src != nil {
+		_, err = src.Resume(nil)
+		if err != nil {
+			src.Close()
+			return nil, errors.WithStack(err)
+		}
+		return src, nil
+	}
+
+	dcomp, ok := decompressors[zf.Method]
+	if !ok {
+		return nil, unsupportedMethod(zip.ErrAlgorithm, zf)
+	}
+	dataOff, err := zf.DataOffset()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return dcomp(io.NewSectionReader(ze.reader, dataOff, int64(zf.CompressedSize64)), zf), nil
+}
+
+// checkedReader checks that an entry decompresses to the size and
+// CRC-32 listed in the central directory, see openChecked
+type checkedReader struct {
+	rc    io.ReadCloser
+	zf    *zip.File
+	hash  hash.Hash32
+	nread uint64
+	// sticky error
+	err error
+}
+
+func (cr *checkedReader) Read(buf []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	n, err := cr.rc.Read(buf)
+	cr.hash.Write(buf[:n])
+	cr.nread += uint64(n)
+
+	if cr.nread > cr.zf.UncompressedSize64 {
+		err = errors.Wrapf(savior.ErrSizeMismatch, "%s: decompresses to more than %d bytes", cr.zf.Name, cr.zf.UncompressedSize64)
+	} else if err == io.EOF {
+		if cr.nread < cr.zf.UncompressedSize64 {
+			err = errors.Wrapf(savior.ErrTruncatedEntry, "%s: got %d bytes, expected %d", cr.zf.Name, cr.nread, cr.zf.UncompressedSize64)
+		} else if cr.hash.Sum32() != cr.zf.CRC32 {
+			err = errors.Wrapf(ErrCRCMismatch, "%s: got %08x, expected %08x", cr.zf.Name, cr.hash.Sum32(), cr.zf.CRC32)
+		}
+	}
+	if err != nil {
+		cr.err = err
+	}
+	return n, err
+}
+
+func (cr *checkedReader) Close() error {
+	return cr.rc.Close()
+}
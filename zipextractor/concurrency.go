@@ -0,0 +1,256 @@
+package zipextractor
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+const (
+	// larger entries are extracted the usual way, so they can be
+	// checkpointed in the middle
+	maxConcurrentEntrySize = 4 * 1024 * 1024
+	// how many decompressed bytes can be waiting to be written, at most
+	concurrentBufferSize = 32 * 1024 * 1024
+	// how many entries can be waiting to be written, at most
+	maxConcurrentEntries = 1024
+)
+
+// SetConcurrency makes Resume decompress Store and Deflate file entries
+// (up to a few MiBs each) ahead of time, on up to `n` goroutines. The sink
+// is still only used from the goroutine that called Resume, and entries
+// are written in order, so directories are created before their files.
+//
+// Entries decompressed ahead of time are only checkpointed once they're
+// written, whereas other entries can be checkpointed in the middle. This
+// doesn't apply when comparing to a previous manifest, or when the sink
+// can reflink files.
+func (ze *ZipExtractor) SetConcurrency(n int) {
+	ze.concurrency = n
+}
+
+// decodedEntry is an entry being decompressed by a worker
+type decodedEntry struct {
+	index int64
+	zf    *zip.File
+	data  []byte
+	err   error
+	done  chan struct{}
+}
+
+// concurrentReader decompresses entries on several goroutines, in the
+// order they're walked in, staying at most `concurrentBufferSize`
+// decompressed bytes (and `maxConcurrentEntries` entries) ahead of
+// what's been taken.
+type concurrentReader struct {
+	open     func(zf *zip.File) (io.ReadCloser, error)
+	eligible func(pos int64) bool
+	order    []int64
+	files    []*zip.File
+
+	// next position to consider
+	next int64
+	size int64
+
+	pending map[int64]*decodedEntry
+	jobs    chan *decodedEntry
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// startConcurrentReader returns a concurrentReader for the selected entries
+// that can be decompressed ahead of time, see SetConcurrency, or nil if
+// they can't be
+func (ze *ZipExtractor) startConcurrentReader(order []int64, selected []bool, destSink savior.Sink) *concurrentReader {
+	if ze.concurrency <= 1 || ze.previousManifest != nil {
+		return nil
+	}
+
+	open := ze.openChecked
+	if ze.skipCRC32 {
+		open = ze.openRaw
+	}
+	files := ze.zr.File
+	rs, _ := destSink.(savior.ReflinkSink)
+	return newConcurrentReader(ze.concurrency, order, files, open, func(pos int64) bool {
+		index := order[pos]
+		if !selected[index] {
+			return false
+		}
+		entry := zipFileEntry(files[index])
+		if !concurrentEligible(files[index], entry) {
+			return false
+		}
+		if rs != nil {
+			if _, ok := rs.ReflinkSource(entry); ok {
+				// might not need decompressing
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func newConcurrentReader(workers int, order []int64, files []*zip.File, open func(zf *zip.File) (io.ReadCloser, error), eligible func(pos int64) bool) *concurrentReader {
+	cr := &concurrentReader{
+		open:     open,
+		eligible: eligible,
+		order:    order,
+		files:    files,
+		pending:  make(map[int64]*decodedEntry),
+		// jobs are also pending, so this never blocks
+		jobs: make(chan *decodedEntry, maxConcurrentEntries),
+		quit: make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		cr.wg.Add(1)
+		go cr.work()
+	}
+	return cr
+}
+
+func (cr *concurrentReader) work() {
+	defer cr.wg.Done()
+	for de := range cr.jobs {
+		select {
+		case <-cr.quit:
+			de.err = errors.New("zipextractor: concurrent reader was stopped")
+		default:
+			de.data, de.err = cr.read(de.zf)
+		}
+		close(de.done)
+	}
+}
+
+func (cr *concurrentReader) read(zf *zip.File) ([]byte, error) {
+	rc, err := cr.open(zf)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	buf := new(bytes.Buffer)
+	buf.Grow(int(zf.UncompressedSize64))
+	_, err = io.Copy(buf, rc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fill hands entries from position `from` on to the workers, until
+// enough decompressed bytes are on their way
+func (cr *concurrentReader) fill(from int64) {
+	if cr.next < from {
+		cr.next = from
+	}
+
+	for cr.next < int64(len(cr.order)) {
+		pos := cr.next
+		if !cr.eligible(pos) {
+			cr.next++
+			continue
+		}
+
+		index := cr.order[pos]
+		zf := cr.files[index]
+		size := int64(zf.UncompressedSize64)
+		if len(cr.pending) >= maxConcurrentEntries || cr.size+size > concurrentBufferSize {
+			break
+		}
+
+		de := &decodedEntry{
+			index: index,
+			zf:    zf,
+			done:  make(chan struct{}),
+		}
+		cr.pending[index] = de
+		cr.size += size
+		cr.jobs <- de
+		cr.next++
+	}
+}
+
+// prepare is called before extracting the entry at position `pos`, to
+// keep the workers busy with the entries after it. That one is handed to
+// them too, unless extraction is resuming in the middle of it.
+func (cr *concurrentReader) prepare(pos int64, resuming bool) {
+	if resuming {
+		cr.fill(pos + 1)
+	} else {
+		cr.fill(pos)
+	}
+}
+
+// takeEntry returns the contents of `entry` if it was decompressed ahead
+// of time. Entries resumed in the middle are extracted the usual way.
+func (cr *concurrentReader) takeEntry(index int64, entry *savior.Entry) (data []byte, ok bool, err error) {
+	if entry.WriteOffset != 0 {
+		return nil, false, nil
+	}
+	return cr.take(index)
+}
+
+// take waits for an entry to be decompressed, and returns its contents.
+// ok is false if the entry wasn't handed to the workers.
+func (cr *concurrentReader) take(index int64) (data []byte, ok bool, err error) {
+	de, ok := cr.pending[index]
+	if !ok {
+		return nil, false, nil
+	}
+
+	<-de.done
+	delete(cr.pending, index)
+	cr.size -= int64(de.zf.UncompressedSize64)
+	return de.data, true, de.err
+}
+
+// close makes workers skip entries they haven't started on yet, and
+// waits for them to be done, so nothing reads from the archive after it
+func (cr *concurrentReader) close() {
+	close(cr.quit)
+	close(cr.jobs)
+	cr.wg.Wait()
+	cr.pending = nil
+}
+
+// concurrentEligible returns true if an entry can be decompressed ahead
+// of time: a non-empty Store or Deflate file that fits in memory
+func concurrentEligible(zf *zip.File, entry *savior.Entry) bool {
+	if entry.Kind != savior.EntryKindFile || entry.IsDelta {
+		return false
+	}
+	if zf.Method != zip.Store && zf.Method != zip.Deflate {
+		return false
+	}
+	return entry.UncompressedSize > 0 && entry.UncompressedSize <= maxConcurrentEntrySize
+}
+
+// writeDecoded writes an entry that was decompressed ahead of time to the
+// sink, after checking it against expected hashes, if any. The writer is
+// returned so it can be synced before the entry is checkpointed.
+func writeDecoded(sink savior.Sink, entry *savior.Entry, data []byte, contents *contentChecker) (savior.EntryWriter, error) {
+	savior.Debugf(`%s: writing from concurrent reader`, entry.CanonicalPath)
+	if contents != nil {
+		err := contents.checkBytes(entry, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writer, err := sink.GetWriter(entry)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return writer, nil
+}
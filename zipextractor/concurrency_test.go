@@ -0,0 +1,104 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrency(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(30)
+	zipBytes := checker.MakeZip(t, sink)
+
+	makeZipExtractor := func() savior.Extractor {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetConcurrency(4)
+		return ex
+	}
+
+	log.Printf("Testing concurrent .zip, no resumes")
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return false
+	})
+
+	log.Printf("Testing concurrent .zip, every resume")
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return true
+	})
+
+	log.Printf("Testing concurrent .zip, every other resume")
+	i := 0
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		i++
+		return i%2 == 0
+	})
+}
+
+type mkdirOrderSink struct {
+	orderSink
+}
+
+func (os *mkdirOrderSink) Mkdir(entry *savior.Entry) error {
+	os.order = append(os.order, entry.CanonicalPath)
+	return os.NopSink.Mkdir(entry)
+}
+
+func TestConcurrencyOrder(t *testing.T) {
+	var entries []testZipEntry
+	var expected []string
+	for i := 0; i < 8; i++ {
+		dir := fmt.Sprintf("dir%d/", i)
+		entries = append(entries, testZipEntry{Name: dir})
+		expected = append(expected, dir)
+		for j := 0; j < 16; j++ {
+			name := fmt.Sprintf("dir%d/file%d", i, j)
+			entries = append(entries, testZipEntry{Name: name, Data: []byte(name)})
+			expected = append(expected, name)
+		}
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetConcurrency(8)
+
+	// directories are still created before their files
+	sink := &mkdirOrderSink{}
+	_, err := ex.Resume(nil, sink)
+	must(t, err)
+	assert.EqualValues(t, expected, sink.order)
+}
+
+func TestConcurrencyErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "a.txt", Data: []byte("aaaa")},
+		{Name: "b.txt", Data: []byte("bbbb")},
+		{Name: "c.txt", Data: []byte("cccc")},
+	})
+	// entries are stored, so that's b's data
+	zipBytes[bytes.Index(zipBytes, []byte("bbbb"))] = 'x'
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetConcurrency(4)
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	assert.Error(err)
+	assert.EqualValues(zipextractor.ErrCRCMismatch, errors.Cause(err))
+
+	ex = newTestZipExtractor(t, zipBytes)
+	ex.SetConcurrency(4)
+	ex.SetErrorPolicy(zipextractor.PolicyContinueOnEntryError)
+	sink := &orderSink{}
+	_, err = ex.Resume(nil, sink)
+	assert.Error(err)
+	assert.EqualValues([]string{"a.txt", "c.txt"}, sink.order)
+}
@@ -0,0 +1,74 @@
+package zipextractor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrailingSlashConflicts(t *testing.T) {
+	exists := func(dir string, name string) bool {
+		_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil
+	}
+
+	conflicting := map[string][]testZipEntry{
+		"file then dir": {
+			{Name: "ok.txt", Data: []byte("ok")},
+			{Name: "dir", Data: []byte("a file")},
+			{Name: "dir/"},
+		},
+		"dir then file": {
+			{Name: "ok.txt", Data: []byte("ok")},
+			{Name: "dir/"},
+			{Name: "dir", Data: []byte("a file")},
+		},
+		"implied dir": {
+			{Name: "ok.txt", Data: []byte("ok")},
+			{Name: "dir", Data: []byte("a file")},
+			{Name: "dir/sub/file.txt", Data: []byte("nested")},
+		},
+		"symlink": {
+			{Name: "ok.txt", Data: []byte("ok")},
+			{Name: "dir/file.txt", Data: []byte("nested")},
+			{Name: "dir", Data: []byte("ok.txt"), Mode: os.ModeSymlink | 0644},
+		},
+	}
+
+	for name, entries := range conflicting {
+		ex := newTestZipExtractor(t, makeTestZip(t, entries))
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		assert.Error(t, err, name)
+		assert.True(t, errors.Cause(err) == savior.ErrPathConflict, name)
+		assert.False(t, exists(dir, "ok.txt"), "%s: nothing should be written", name)
+	}
+
+	{
+		// a trailing slash means directory, even with a file or symlink mode
+		zipBytes := makeTestZip(t, []testZipEntry{
+			{Name: "notafile/", Mode: 0644},
+			{Name: "notalink/", Data: []byte("target"), Mode: os.ModeSymlink | 0644},
+			{Name: "notafile/inside.txt", Data: []byte("inside")},
+		})
+		ex := newTestZipExtractor(t, zipBytes)
+		for _, entry := range ex.Entries()[:2] {
+			assert.EqualValues(t, savior.EntryKindDir, entry.Kind, entry.CanonicalPath)
+		}
+
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		must(t, err)
+
+		for _, name := range []string{"notafile", "notalink"} {
+			stats, err := os.Lstat(filepath.Join(dir, name))
+			must(t, err)
+			assert.True(t, stats.IsDir(), name)
+		}
+		assert.True(t, exists(dir, "notafile/inside.txt"))
+	}
+}
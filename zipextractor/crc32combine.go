@@ -0,0 +1,137 @@
+package zipextractor
+
+import (
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// crc32Combine returns the CRC-32 (IEEE) of the concatenation of two
+// byte sequences, given the CRC-32 of each, and the length of the second.
+// This is a port of zlib's crc32_combine.
+func crc32Combine(crc1 uint32, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	even := make([]uint32, 32) // even-power-of-two zeros operator
+	odd := make([]uint32, 32)  // odd-power-of-two zeros operator
+
+	// put operator for one zero bit in odd
+	odd[0] = crc32.IEEE
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+
+	// put operator for two zero bits in even
+	gf2MatrixSquare(even, odd)
+	// put operator for four zero bits in odd
+	gf2MatrixSquare(odd, even)
+
+	// apply len2 zeros to crc1 (first square will put the operator
+	// for one zero byte, eight zero bits, in even)
+	for {
+		gf2MatrixSquare(even, odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(odd, even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat []uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i++ {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+		vec >>= 1
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square []uint32, mat []uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
+
+// parallelCRC32 computes the CRC-32 (IEEE) of `size` bytes read from `r`
+// at `offset`, by hashing chunks of `chunkSize` bytes concurrently on
+// `workers` goroutines, then combining the results.
+func parallelCRC32(r io.ReaderAt, offset int64, size int64, chunkSize int64, workers int) (uint32, error) {
+	if chunkSize <= 0 {
+		return 0, errors.New("parallelCRC32: chunk size must be positive")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	numChunks := int((size + chunkSize - 1) / chunkSize)
+	crcs := make([]uint32, numChunks)
+	errs := make([]error, numChunks)
+
+	chunks := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 32*1024)
+			for chunk := range chunks {
+				start := int64(chunk) * chunkSize
+				length := chunkSize
+				if start+length > size {
+					length = size - start
+				}
+
+				h := crc32.NewIEEE()
+				sr := io.NewSectionReader(r, offset+start, length)
+				n, err := io.CopyBuffer(h, sr, buf)
+				if err == nil && n != length {
+					err = io.ErrUnexpectedEOF
+				}
+				crcs[chunk] = h.Sum32()
+				errs[chunk] = err
+			}
+		}()
+	}
+
+	for chunk := 0; chunk < numChunks; chunk++ {
+		chunks <- chunk
+	}
+	close(chunks)
+	wg.Wait()
+
+	var crc uint32
+	for chunk := 0; chunk < numChunks; chunk++ {
+		if errs[chunk] != nil {
+			return 0, errors.WithStack(errs[chunk])
+		}
+
+		length := chunkSize
+		if rest := size - int64(chunk)*chunkSize; rest < length {
+			length = rest
+		}
+		crc = crc32Combine(crc, crcs[chunk], length)
+	}
+	return crc, nil
+}
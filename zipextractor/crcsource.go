@@ -0,0 +1,155 @@
+package zipextractor
+
+import (
+	"hash/crc32"
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// SetVerifyCRC32 decides whether the CRC-32 of entries is checked against
+// the central directory as they're extracted (it is, by default): Resume
+// then fails with ErrCRCMismatch when an entry doesn't decompress to what
+// was archived. The running CRC-32 of the entry being extracted is part of
+// checkpoints, so entries resumed mid-way are checked too. Turning it off
+// saves a little CPU time, it must have the same value when resuming, or
+// the entry that was in progress is read again from its start. Entries
+// that can't be resumed mid-way (see RegisterDecompressor) are always
+// checked.
+func (ze *ZipExtractor) SetVerifyCRC32(verify bool) {
+	ze.skipCRC32 = !verify
+}
+
+// CRCSourceCheckpoint is the checkpoint data of sources that check
+// the CRC-32 of an entry as it's extracted, see SetVerifyCRC32
+type CRCSourceCheckpoint struct {
+	SourceCheckpoint *savior.SourceCheckpoint
+	// CRC32 is the CRC-32 of the entry's first
+	// SourceCheckpoint.OutputOffset bytes
+	CRC32 uint32
+}
+
+var _ savior.PortableChecker = (*CRCSourceCheckpoint)(nil)
+
+// Portable returns true if the wrapped source checkpoint is portable
+func (csc *CRCSourceCheckpoint) Portable() bool {
+	return csc.SourceCheckpoint.Portable()
+}
+
+// crcSource computes the CRC-32 of what it reads from the source of an
+// entry, and fails with ErrCRCMismatch at the end if it's not the one
+// listed in the central directory
+type crcSource struct {
+	source savior.Source
+	zf     *zip.File
+
+	crc     uint32
+	offset  int64
+	bytebuf []byte
+}
+
+var _ savior.Source = (*crcSource)(nil)
+
+func newCRCSource(source savior.Source, zf *zip.File) *crcSource {
+	return &crcSource{
+		source:  source,
+		zf:      zf,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (cs *crcSource) Features() savior.SourceFeatures {
+	return cs.source.Features()
+}
+
+func (cs *crcSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	cs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			if checkpoint == nil || checkpoint.OutputOffset != cs.offset {
+				// sources save before producing anything else, so that's
+				// not supposed to happen, but we can't vouch for its CRC-32
+				savior.Debugf("%s: can't save crc32 at %d", cs.zf.Name, cs.offset)
+				return ssc.Save(nil)
+			}
+
+			return ssc.Save(&savior.SourceCheckpoint{
+				Offset:       checkpoint.Offset,
+				OutputOffset: checkpoint.OutputOffset,
+				Data: &CRCSourceCheckpoint{
+					SourceCheckpoint: checkpoint,
+					CRC32:            cs.crc,
+				},
+			})
+		},
+	})
+}
+
+func (cs *crcSource) WantSave() {
+	cs.source.WantSave()
+}
+
+func (cs *crcSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*CRCSourceCheckpoint); ok && ourCheckpoint.SourceCheckpoint != nil {
+			offset, err := cs.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+			if offset == ourCheckpoint.SourceCheckpoint.OutputOffset {
+				cs.crc = ourCheckpoint.CRC32
+				cs.offset = offset
+				return offset, nil
+			}
+			savior.Debugf("%s: source resumed at %d instead of %d, starting crc32 over", cs.zf.Name, offset, ourCheckpoint.SourceCheckpoint.OutputOffset)
+		}
+	}
+
+	offset, err := cs.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if offset != 0 {
+		return 0, errors.Errorf("%s: expected source to resume at start but got %d", cs.zf.Name, offset)
+	}
+	cs.crc = 0
+	cs.offset = 0
+	return 0, nil
+}
+
+func (cs *crcSource) Read(buf []byte) (int, error) {
+	n, err := cs.source.Read(buf)
+	cs.crc = crc32.Update(cs.crc, crc32.IEEETable, buf[:n])
+	cs.offset += int64(n)
+
+	// short entries are the copier's to report. New rejects declared
+	// sizes that don't fit in an int64, so every entry gets here.
+	if err == io.EOF && uint64(cs.offset) >= cs.zf.UncompressedSize64 && cs.crc != cs.zf.CRC32 {
+		err = errors.Wrapf(ErrCRCMismatch, "%s: got %08x, expected %08x", cs.zf.Name, cs.crc, cs.zf.CRC32)
+	}
+	return n, err
+}
+
+func (cs *crcSource) ReadByte() (byte, error) {
+	n, err := cs.Read(cs.bytebuf)
+	if n == 0 && err == nil {
+		n, err = cs.Read(cs.bytebuf)
+	}
+	if n == 0 && err == nil {
+		err = io.ErrNoProgress
+	}
+	return cs.bytebuf[0], err
+}
+
+func (cs *crcSource) Progress() float64 {
+	return cs.source.Progress()
+}
+
+func (cs *crcSource) Close() error {
+	return cs.source.Close()
+}
+
+func init() {
+	savior.RegisterCheckpointData("zipextractor.CRCSourceCheckpoint", &CRCSourceCheckpoint{})
+}
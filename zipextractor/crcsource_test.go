@@ -0,0 +1,100 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCRC32(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		data := make([]byte, 2*1024*1024)
+		rand.New(rand.NewSource(int64(method))).Read(data)
+		zipBytes := makeTestZip(t, []testZipEntry{
+			{Name: "small.txt", Data: []byte("before")},
+			{Name: "big.bin", Data: data, Method: method},
+			{Name: "after.txt", Data: []byte("after")},
+		})
+
+		// random data doesn't compress, deflate stores it as-is,
+		// so this is still valid deflate
+		corrupted := append([]byte(nil), zipBytes...)
+		i := bytes.Index(corrupted, data[1000:1100])
+		if !assert.True(i > 0, "method %d", method) {
+			continue
+		}
+		corrupted[i] ^= 0xff
+
+		// stops at every checkpoint, so the corrupted byte
+		// is read well before the end of the entry
+		extract := func(zipBytes []byte, verify bool) (int, error) {
+			sink := savior.NewMemorySink()
+			var c *savior.ExtractorCheckpoint
+			numResumes := 0
+			for {
+				if numResumes > 100 {
+					return numResumes, errors.New("too many resumes")
+				}
+
+				ex := newTestZipExtractor(t, zipBytes)
+				ex.SetVerifyCRC32(verify)
+				ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+					if verify && checkpoint.SourceCheckpoint != nil {
+						_, ok := checkpoint.SourceCheckpoint.Data.(*zipextractor.CRCSourceCheckpoint)
+						assert.True(ok, "source checkpoints should carry the crc32")
+					}
+					bs, err := savior.MarshalCheckpoint(checkpoint)
+					if err != nil {
+						return savior.AfterSaveStop, err
+					}
+					c, err = savior.UnmarshalCheckpoint(bs)
+					return savior.AfterSaveStop, err
+				}))
+
+				_, err := ex.Resume(c, sink)
+				if errors.Cause(err) == savior.ErrStop {
+					numResumes++
+					continue
+				}
+				return numResumes, err
+			}
+		}
+
+		numResumes, err := extract(zipBytes, true)
+		must(t, err)
+		assert.True(numResumes > 4, "method %d: should resume mid-entry", method)
+
+		numResumes, err = extract(corrupted, true)
+		assert.Error(err, "method %d", method)
+		assert.True(errors.Cause(err) == zipextractor.ErrCRCMismatch, "method %d: %+v", method, err)
+		assert.Contains(err.Error(), "big.bin")
+		assert.True(numResumes > 4, "method %d: should resume mid-entry", method)
+
+		// unless asked not to
+		_, err = extract(corrupted, false)
+		must(t, err)
+	}
+}
+
+func TestVerifyCRC32Zip64(t *testing.T) {
+	data := []byte("declared in a zip64 extra field")
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "zip64.txt", Data: data, Extra: zip64SizeExtra},
+	})
+	declareZip64Size(t, zipBytes, uint64(len(data)))
+	zipBytes[bytes.Index(zipBytes, data)] = 'D'
+
+	ex := newTestZipExtractor(t, zipBytes)
+	_, err := ex.Resume(nil, savior.NewMemorySink())
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == zipextractor.ErrCRCMismatch, "%+v", err)
+}
@@ -0,0 +1,80 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeIntoEmptySink(t *testing.T) {
+	entries := []testZipEntry{
+		{Name: "small.bin", Data: semirandom.Bytes(1024), Method: zip.Deflate},
+		{Name: "stored.bin", Data: semirandom.Bytes(4 * 1024 * 1024), Method: zip.Store},
+		{Name: "deflated.bin", Data: semirandom.Bytes(4 * 1024 * 1024), Method: zip.Deflate},
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	for _, stopIn := range []string{"stored.bin", "deflated.bin"} {
+		t.Run(stopIn, func(t *testing.T) {
+			dirA, err := ioutil.TempDir("", "crosssink-a")
+			must(t, err)
+			defer os.RemoveAll(dirA)
+
+			dirB, err := ioutil.TempDir("", "crosssink-b")
+			must(t, err)
+			defer os.RemoveAll(dirB)
+
+			// extract until we're in the middle of an entry, then stop
+			var saved *savior.ExtractorCheckpoint
+			ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			must(t, err)
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(512*1024, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				if c.Entry == nil || c.Entry.CanonicalPath != stopIn || c.Entry.WriteOffset == 0 || c.SourceCheckpoint == nil {
+					return savior.AfterSaveContinue, nil
+				}
+				buf, err := savior.MarshalCheckpoint(c)
+				must(t, err)
+				saved, err = savior.UnmarshalCheckpoint(buf)
+				must(t, err)
+				return savior.AfterSaveStop, nil
+			}))
+
+			fsA := &savior.FolderSink{Directory: dirA, Consumer: savior.NopConsumer()}
+			_, err = ex.Resume(nil, fsA)
+			assert.Equal(t, savior.ErrStop, err)
+			must(t, fsA.Close())
+			if saved == nil {
+				t.Fatalf("never got a checkpoint in the middle of %s", stopIn)
+			}
+
+			// resume into a sink that holds nothing, wrapped so it's another kind of sink
+			ex, err = zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+			must(t, err)
+			fsB := &savior.FolderSink{Directory: dirB, Consumer: savior.NopConsumer()}
+			_, err = ex.Resume(saved, savior.NewStatsSink(fsB))
+			must(t, err)
+			must(t, fsB.Close())
+
+			past := false
+			for _, e := range entries {
+				past = past || e.Name == stopIn
+				actual, err := ioutil.ReadFile(filepath.Join(dirB, e.Name))
+				if !past {
+					assert.True(t, os.IsNotExist(err), "%s was extracted before the checkpoint", e.Name)
+					continue
+				}
+				must(t, err)
+				assert.True(t, bytes.Equal(e.Data, actual), "%s should have the right contents", e.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package zipextractor
+
+import (
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrDeadlineExceeded is returned by Resume when extraction was stopped
+// because the deadline set with SetDeadline passed. The last checkpoint
+// handed to the save consumer can be used to resume extraction.
+var ErrDeadlineExceeded = errors.New("zipextractor: deadline exceeded")
+
+// SetDeadline makes Resume stop at the first checkpoint boundary after `t`:
+// it emits a checkpoint, then returns ErrDeadlineExceeded. The zero time
+// means no deadline.
+//
+// For Store and Deflate entries, boundaries happen every few KiBs (or at the
+// end of the current deflate block), otherwise it's the end of the current entry.
+func (ze *ZipExtractor) SetDeadline(t time.Time) {
+	ze.deadline = t
+}
+
+// deadlineSaveConsumer asks for a checkpoint as soon as the deadline has
+// passed, and asks to stop right after it has been saved.
+type deadlineSaveConsumer struct {
+	inner    savior.SaveConsumer
+	deadline time.Time
+
+	// set when the deadline (not the inner consumer) stopped extraction
+	exceeded bool
+}
+
+var _ savior.SaveConsumer = (*deadlineSaveConsumer)(nil)
+
+func (dsc *deadlineSaveConsumer) past() bool {
+	return !time.Now().Before(dsc.deadline)
+}
+
+func (dsc *deadlineSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	// always let the inner consumer count bytes
+	should := dsc.inner.ShouldSave(copiedBytes)
+	return should || dsc.past()
+}
+
+func (dsc *deadlineSaveConsumer) Save(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	action, err := dsc.inner.Save(checkpoint)
+	if err != nil {
+		return action, err
+	}
+
+	if action == savior.AfterSaveContinue && dsc.past() {
+		dsc.exceeded = true
+		action = savior.AfterSaveStop
+	}
+	return action, nil
+}
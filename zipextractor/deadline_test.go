@@ -0,0 +1,80 @@
+package zipextractor_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+	sink.Reset()
+
+	var c *savior.ExtractorCheckpoint
+	var numSaves int
+	sc := checker.NewTestSaveConsumer(1024*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		numSaves++
+		// the extractor keeps using its checkpoint after stopping
+		buf, err := savior.MarshalCheckpoint(checkpoint)
+		if err != nil {
+			return savior.AfterSaveStop, err
+		}
+		c, err = savior.UnmarshalCheckpoint(buf)
+		return savior.AfterSaveContinue, err
+	})
+
+	// a deadline that has already passed stops at the first checkpoint boundary
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetSaveConsumer(sc)
+	ex.SetDeadline(time.Now())
+	_, err := ex.Resume(nil, sink)
+	assert.Error(err)
+	assert.Equal(zipextractor.ErrDeadlineExceeded, errors.Cause(err))
+	assert.Equal(1, numSaves)
+	if !assert.NotNil(c) {
+		return
+	}
+	assert.True(c.Progress < 1, "extraction should be partial")
+
+	// now extract a few milliseconds at a time
+	numResumes := 0
+	for {
+		if numResumes > 10000 {
+			t.Error("too many resumes")
+			t.FailNow()
+		}
+
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetSaveConsumer(sc)
+		ex.SetDeadline(time.Now().Add(2 * time.Millisecond))
+		_, err := ex.Resume(c, sink)
+		if err != nil {
+			if errors.Cause(err) == zipextractor.ErrDeadlineExceeded {
+				numResumes++
+				continue
+			}
+			must(t, err)
+		}
+		break
+	}
+	t.Logf("%d resumes", numResumes)
+
+	assert.NoError(sink.Validate())
+
+	// no deadline, no stopping
+	sink.Reset()
+	ex = newTestZipExtractor(t, zipBytes)
+	ex.SetSaveConsumer(sc)
+	ex.SetDeadline(time.Time{})
+	_, err = ex.Resume(nil, sink)
+	must(t, err)
+	assert.NoError(sink.Validate())
+}
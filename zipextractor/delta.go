@@ -0,0 +1,62 @@
+package zipextractor
+
+import (
+	"io"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// MethodCopyFromBase is the compression method of delta entries whose
+// body is the path of a file in the base, see `savior.CopyFromBase`.
+const MethodCopyFromBase uint16 = 0xBA5E
+
+var deltaMethods = map[uint16]savior.DeltaFunc{
+	MethodCopyFromBase: savior.CopyFromBase,
+}
+
+// RegisterDeltaMethod makes entries stored with the given compression
+// method be extracted as delta entries, by applying fn against the base.
+// It's not safe to call concurrently with extraction.
+func RegisterDeltaMethod(method uint16, fn savior.DeltaFunc) {
+	deltaMethods[method] = fn
+}
+
+func isDeltaMethod(method uint16) bool {
+	_, ok := deltaMethods[method]
+	return ok
+}
+
+var _ savior.DeltaExtractor = (*ZipExtractor)(nil)
+
+// SetBase sets the base that delta entries are applied against.
+// Delta entries can't be resumed mid-entry, and aren't preallocated,
+// since their size is only known once they've been applied.
+func (ze *ZipExtractor) SetBase(base savior.Base) {
+	ze.base = base
+}
+
+func (ze *ZipExtractor) extractDelta(zf *zip.File, entry *savior.Entry, sink savior.Sink) error {
+	if ze.base == nil {
+		return errors.Wrapf(savior.ErrNoBase, "%s", entry.CanonicalPath)
+	}
+
+	dataOff, err := zf.DataOffset()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	patch := io.NewSectionReader(ze.reader, dataOff, int64(zf.CompressedSize64))
+
+	entry.WriteOffset = 0
+	writer, err := sink.GetWriter(entry)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = deltaMethods[zf.Method](ze.base, entry, patch, writer)
+	if err != nil {
+		return errors.Wrapf(err, "applying delta for %s", entry.CanonicalPath)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package zipextractor_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nwc nopWriteCloser) Close() error {
+	return nil
+}
+
+func init() {
+	// so makeTestZip can write delta entries (their body is stored as-is)
+	zip.RegisterCompressor(zipextractor.MethodCopyFromBase, func(s zip.CompressionSettings, w io.Writer) (io.WriteCloser, error) {
+		return nopWriteCloser{w}, nil
+	})
+}
+
+func TestDeltaCopyFromBase(t *testing.T) {
+	assert := assert.New(t)
+
+	baseDir, err := ioutil.TempDir("", "zipextractor-base")
+	must(t, err)
+	defer os.RemoveAll(baseDir)
+
+	baseData := []byte("contents of the previous version")
+	must(t, os.MkdirAll(filepath.Join(baseDir, "old"), 0755))
+	must(t, ioutil.WriteFile(filepath.Join(baseDir, "old", "data.bin"), baseData, 0644))
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "fresh.txt", Data: []byte("brand new")},
+		{Name: "renamed/data.bin", Data: []byte("old/data.bin"), Method: zipextractor.MethodCopyFromBase},
+	})
+
+	{
+		ex := newTestZipExtractor(t, zipBytes)
+		assert.True(ex.Entries()[1].IsDelta)
+		assert.EqualValues(savior.ResumeSupportEntry, ex.Features().ResumeSupport)
+
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		assert.Error(err)
+		assert.True(errors.Cause(err) == savior.ErrNoBase)
+	}
+
+	{
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetBase(&savior.FolderBase{Directory: baseDir})
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		must(t, err)
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "renamed", "data.bin"))
+		must(t, err)
+		assert.EqualValues(baseData, bs)
+
+		bs, err = ioutil.ReadFile(filepath.Join(dir, "fresh.txt"))
+		must(t, err)
+		assert.EqualValues("brand new", string(bs))
+	}
+
+	{
+		escaping := makeTestZip(t, []testZipEntry{
+			{Name: "evil", Data: []byte("../../etc/passwd"), Method: zipextractor.MethodCopyFromBase},
+		})
+		ex := newTestZipExtractor(t, escaping)
+		ex.SetBase(&savior.FolderBase{Directory: baseDir})
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		assert.Error(err, "base paths shouldn't escape the base folder")
+	}
+}
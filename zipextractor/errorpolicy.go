@@ -0,0 +1,87 @@
+package zipextractor
+
+import (
+	"io"
+	"strings"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/kompress/flate"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/flatesource"
+	"github.com/itchio/savior/implodesource"
+	"github.com/itchio/savior/lzmasource"
+	"github.com/pkg/errors"
+)
+
+// ErrEntriesFailed is returned by Resume, wrapped with the paths of the
+// entries that were skipped, when entries failed to extract with
+// PolicyContinueOnEntryError, see SetErrorPolicy
+var ErrEntriesFailed = errors.New("zipextractor: some entries failed to extract")
+
+// An ErrorPolicy decides what happens when an entry fails to extract
+type ErrorPolicy int
+
+const (
+	// PolicyFailFast stops extraction at the first entry that fails
+	PolicyFailFast ErrorPolicy = iota
+	// PolicyContinueOnEntryError skips entries that fail, see SetErrorPolicy
+	PolicyContinueOnEntryError
+)
+
+// SetErrorPolicy decides what happens when an entry's data can't be
+// decompressed: it doesn't match its CRC-32, it's truncated, it's
+// compressed with an unsupported method, etc. By default (PolicyFailFast),
+// Resume returns the error right away.
+//
+// With PolicyContinueOnEntryError, the error is logged through the
+// consumer, whatever was written for the entry is removed (for sinks that
+// are a savior.EntryRemover), and extraction goes on with the next entry.
+// Once everything else is extracted, Resume returns ErrEntriesFailed,
+// listing the paths of the failed entries, also available from
+// FailedEntries. Failed entries are recorded in checkpoints, so entries
+// that failed before a resume are listed too. Errors writing to the sink
+// still stop extraction.
+func (ze *ZipExtractor) SetErrorPolicy(policy ErrorPolicy) {
+	ze.errorPolicy = policy
+}
+
+// FailedEntries returns the paths of entries that were skipped because
+// they failed to extract, during the last call to Resume, see SetErrorPolicy.
+func (ze *ZipExtractor) FailedEntries() []string {
+	return ze.failed
+}
+
+// isEntryError returns true for errors caused by an entry's data,
+// as opposed to the sink, or the save consumer
+func isEntryError(err error) bool {
+	cause := errors.Cause(err)
+	switch cause {
+	case ErrCRCMismatch, ErrUnsupportedMethod, zip.ErrAlgorithm, zip.ErrFormat,
+		savior.ErrTruncatedEntry, savior.ErrSizeMismatch,
+		flatesource.ErrChecksum, flatesource.ErrDictionary,
+		lzmasource.ErrCorrupt, implodesource.ErrCorrupt, io.ErrUnexpectedEOF:
+		return true
+	}
+	_, ok := cause.(flate.CorruptInputError)
+	return ok
+}
+
+// skipFailedEntry logs why an entry failed, and removes whatever
+// was written for it, see PolicyContinueOnEntryError
+func (ze *ZipExtractor) skipFailedEntry(entry *savior.Entry, sink savior.Sink, entryErr error) error {
+	ze.consumer.Warnf("✗ Skipping %s: %v", entry.CanonicalPath, entryErr)
+	savior.Debugf("%s: %+v", entry.CanonicalPath, entryErr)
+
+	if er, ok := sink.(savior.EntryRemover); ok {
+		err := er.RemoveEntry(entry)
+		if err != nil {
+			return errors.Wrapf(err, "removing failed entry %s", entry.CanonicalPath)
+		}
+	}
+	return nil
+}
+
+// entriesFailedError lists the paths of failed entries
+func entriesFailedError(paths []string) error {
+	return errors.Wrapf(ErrEntriesFailed, "%d entries: %s", len(paths), strings.Join(paths, ", "))
+}
@@ -0,0 +1,107 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []testZipEntry{
+		{Name: "a.bin", Data: semirandom.Bytes(512 * 1024), Method: zip.Deflate},
+		{Name: "b.bin", Data: semirandom.Bytes(768 * 1024), Method: zip.Deflate},
+		{Name: "c.bin", Data: semirandom.Bytes(256 * 1024), Method: zip.Store},
+		{Name: "d.bin", Data: semirandom.Bytes(768 * 1024), Method: zip.Store},
+		{Name: "e.txt", Data: []byte("still here")},
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	// the central directory says b.bin and d.bin are half their actual
+	// compressed size, so they're cut short
+	for _, name := range []string{"b.bin", "d.bin"} {
+		record := bytes.LastIndex(zipBytes, []byte(name)) - 46
+		assert.EqualValues(0x02014b50, binary.LittleEndian.Uint32(zipBytes[record:]))
+		size := binary.LittleEndian.Uint32(zipBytes[record+20:])
+		binary.LittleEndian.PutUint32(zipBytes[record+20:], size/2)
+	}
+
+	// fail fast by default
+	ex := newTestZipExtractor(t, zipBytes)
+	_, err := ex.Resume(nil, savior.NewMemorySink())
+	assert.Error(err)
+	assert.False(errors.Cause(err) == zipextractor.ErrEntriesFailed)
+
+	dir, err := ioutil.TempDir("", "zipextractor-errorpolicy")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	// stop at every checkpoint, failures are remembered across resumes
+	var c *savior.ExtractorCheckpoint
+	numResumes := 0
+	for {
+		if !assert.True(numResumes < 50, "too many resumes") {
+			return
+		}
+
+		ex = newTestZipExtractor(t, zipBytes)
+		ex.SetErrorPolicy(zipextractor.PolicyContinueOnEntryError)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			bs, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(bs)
+			return savior.AfterSaveStop, err
+		}))
+
+		sink := &savior.FolderSink{
+			Directory: dir,
+			Consumer:  savior.NopConsumer(),
+		}
+		_, err = ex.Resume(c, sink)
+		must(t, sink.Close())
+		if errors.Cause(err) == savior.ErrStop {
+			numResumes++
+			continue
+		}
+		break
+	}
+	assert.True(numResumes > 2, "should have resumed a few times")
+
+	assert.Error(err)
+	assert.True(errors.Cause(err) == zipextractor.ErrEntriesFailed)
+	assert.Contains(err.Error(), "b.bin")
+	assert.Contains(err.Error(), "d.bin")
+	assert.EqualValues([]string{"b.bin", "d.bin"}, ex.FailedEntries())
+
+	for _, e := range entries {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, e.Name))
+		switch e.Name {
+		case "b.bin", "d.bin":
+			assert.True(os.IsNotExist(err), "partial %s should be removed", e.Name)
+		default:
+			must(t, err)
+			assert.True(bytes.Equal(e.Data, actual), "%s should be extracted", e.Name)
+		}
+	}
+
+	// sinks that can't remove entries are left as they are
+	ex = newTestZipExtractor(t, zipBytes)
+	ex.SetErrorPolicy(zipextractor.PolicyContinueOnEntryError)
+	_, err = ex.Resume(nil, &savior.NopSink{})
+	assert.True(errors.Cause(err) == zipextractor.ErrEntriesFailed)
+	assert.EqualValues([]string{"b.bin", "d.bin"}, ex.FailedEntries())
+}
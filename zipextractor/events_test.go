@@ -0,0 +1,139 @@
+package zipextractor_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseEvents(t *testing.T, buf *bytes.Buffer) []*savior.Event {
+	var events []*savior.Event
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		ev := &savior.Event{}
+		must(t, json.Unmarshal(scanner.Bytes(), ev))
+		events = append(events, ev)
+	}
+	must(t, scanner.Err())
+	return events
+}
+
+func TestEventWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSinkAdvanced(10)
+	zipBytes := checker.MakeZip(t, sink)
+
+	var c *savior.ExtractorCheckpoint
+	buf := new(bytes.Buffer)
+	var res *savior.ExtractorResult
+	for {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetEventWriter(buf)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if c != nil {
+				// only stop once
+				return savior.AfterSaveContinue, nil
+			}
+			bs, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(bs)
+			return savior.AfterSaveStop, err
+		}))
+
+		var err error
+		res, err = ex.Resume(c, sink)
+		if err == savior.ErrStop {
+			continue
+		}
+		must(t, err)
+		break
+	}
+	must(t, sink.Validate())
+
+	events := parseEvents(t, buf)
+	if !assert.NotEmpty(events) {
+		return
+	}
+
+	var types []string
+	var checkpoints int
+	starts := make(map[string]int)
+	done := make(map[string]int)
+	var current string
+	for i, ev := range events {
+		switch ev.Type {
+		case savior.EventProgress:
+			assert.True(ev.Progress >= 0 && ev.Progress <= 1)
+			continue
+		case savior.EventCheckpoint:
+			checkpoints++
+			continue
+		case savior.EventEntryStart:
+			assert.EqualValues("", current, "entry %s started before %s was done", ev.Path, current)
+			current = ev.Path
+			starts[ev.Path]++
+		case savior.EventEntryDone:
+			assert.EqualValues(current, ev.Path, "event %d", i)
+			current = ""
+			done[ev.Path]++
+		case savior.EventStart:
+			// the entry we stopped in the middle of is started over
+			current = ""
+		}
+		types = append(types, ev.Type)
+	}
+
+	assert.True(checkpoints > 0, "should have checkpoint events")
+	assert.EqualValues(savior.EventStart, types[0])
+	assert.False(events[0].Resumed)
+
+	// stopped once, so there are two runs
+	var runs []*savior.Event
+	for _, ev := range events {
+		if ev.Type == savior.EventStart {
+			runs = append(runs, ev)
+		}
+	}
+	if assert.Len(runs, 2) {
+		assert.True(runs[1].Resumed)
+		assert.True(runs[1].Progress > 0)
+	}
+
+	last := events[len(events)-1]
+	assert.EqualValues(savior.EventDone, last.Type)
+	assert.EqualValues(len(res.Entries), last.Entries)
+	assert.EqualValues(res.Size(), last.Bytes)
+
+	for _, entry := range res.Entries {
+		assert.EqualValues(1, done[entry.CanonicalPath], "%s should be done exactly once", entry.CanonicalPath)
+		assert.True(starts[entry.CanonicalPath] >= 1, "%s should have been started", entry.CanonicalPath)
+	}
+}
+
+func TestEventWriterError(t *testing.T) {
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir", Data: []byte("conflict")},
+	})
+
+	buf := new(bytes.Buffer)
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetEventWriter(buf)
+	_, err := ex.Resume(nil, &savior.NopSink{})
+	assert.Error(t, err)
+
+	events := parseEvents(t, buf)
+	if assert.Len(t, events, 2) {
+		assert.EqualValues(t, savior.EventStart, events[0].Type)
+		assert.EqualValues(t, savior.EventError, events[1].Type)
+		assert.Contains(t, events[1].Error, "dir")
+	}
+}
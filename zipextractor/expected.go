@@ -0,0 +1,252 @@
+package zipextractor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrUnexpectedContent is returned when an entry isn't listed in the
+// expected hashes, or its contents don't match, see `SetExpectedHashes`
+var ErrUnexpectedContent = errors.New("entry contents don't match the expected hash")
+
+// An UnexpectedContentPolicy decides what happens to entries that
+// don't match the expected hashes
+type UnexpectedContentPolicy int
+
+const (
+	// UnexpectedContentFail fails with ErrUnexpectedContent, once
+	// the entry is removed from the sink, if it was written
+	UnexpectedContentFail UnexpectedContentPolicy = iota
+	// UnexpectedContentSkip skips those entries, see `UnexpectedEntries`
+	UnexpectedContentSkip
+)
+
+// SetExpectedHashes restricts extraction to entries whose contents are
+// known in advance, from a (typically signed) manifest: `hashes` maps
+// canonical paths to the hex-encoded SHA-256 of their decompressed
+// contents (for symlinks, of their target). File and symlink entries that
+// aren't listed, or don't match, are not kept: depending on `policy`,
+// extraction fails or they're skipped. Directories aren't checked.
+// Passing nil lifts the restriction.
+//
+// Entries are hashed as they're written, so what's checked is what ends up
+// in the sink. Entries that aren't listed are never written, but files are
+// only known not to match once they're complete: they're then removed, if
+// the sink can (see savior.EntryRemover), and skipping them requires that
+// it can. Files resumed mid-way have their first part read back from the
+// sink if it's a savior.PathSink, and are written over from their start
+// otherwise. Since all contents go through hashing, files aren't reflinked,
+// and aren't compared to a previous manifest.
+//
+// Decisions are recorded in the checkpoint, so resumed extractions skip
+// the same entries. An extraction that was started without expected
+// hashes can't be resumed with them.
+func (ze *ZipExtractor) SetExpectedHashes(hashes map[string]string, policy UnexpectedContentPolicy) {
+	if hashes == nil {
+		ze.expectedHashes = nil
+		return
+	}
+
+	ze.expectedHashes = make(map[string]string, len(hashes))
+	for p, h := range hashes {
+		ze.expectedHashes[p] = strings.ToLower(h)
+	}
+	ze.unexpectedContentPolicy = policy
+}
+
+// UnexpectedEntries returns the paths of entries that were skipped
+// because they didn't match the expected hashes, during the last
+// call to Resume.
+func (ze *ZipExtractor) UnexpectedEntries() []string {
+	return ze.unexpected
+}
+
+// checkListedEntries returns the indices of selected entries that aren't
+// in the expected hashes, or fails on the first one, depending on policy.
+func (ze *ZipExtractor) checkListedEntries(selected []bool) ([]int64, error) {
+	rejected := []int64{}
+
+	for i := range ze.zr.File {
+		if !selected[i] {
+			continue
+		}
+
+		entry := ze.entryAt(int64(i))
+		if entry.Kind == savior.EntryKindDir {
+			continue
+		}
+
+		if _, ok := ze.expectedHashes[entry.CanonicalPath]; !ok {
+			savior.Debugf(`%s: not in expected hashes`, entry.CanonicalPath)
+			if ze.unexpectedContentPolicy == UnexpectedContentFail {
+				return nil, errors.Wrapf(ErrUnexpectedContent, "%s: not in expected hashes", entry.CanonicalPath)
+			}
+			rejected = append(rejected, int64(i))
+		}
+	}
+
+	return rejected, nil
+}
+
+// rejectEntry removes an entry that didn't match the expected hashes
+// from the sink if it was written, then fails with entryErr unless
+// it's to be skipped
+func (ze *ZipExtractor) rejectEntry(entry *savior.Entry, sink savior.Sink, written bool, entryErr error) error {
+	if written {
+		er, ok := sink.(savior.EntryRemover)
+		if !ok {
+			return errors.Wrapf(entryErr, "sink can't remove it")
+		}
+		err := er.RemoveEntry(entry)
+		if err != nil {
+			return errors.Wrapf(err, "removing unexpected entry %s", entry.CanonicalPath)
+		}
+	}
+
+	if ze.unexpectedContentPolicy == UnexpectedContentFail {
+		return entryErr
+	}
+	ze.consumer.Warnf("✗ Skipping %s: %v", entry.CanonicalPath, entryErr)
+	return nil
+}
+
+// contentChecker hashes the contents of entries as they're written,
+// and checks them against the expected hashes once they're complete
+type contentChecker struct {
+	hashes map[string]string
+	// the sink entries are resumed from
+	destSink savior.Sink
+
+	// the entry being written, and what we know of its contents
+	current *savior.Entry
+	h       hash.Hash
+}
+
+// needsRestart returns true for files whose start can't be
+// read back from the sink, so they can't be resumed mid-way
+func (cc *contentChecker) needsRestart(entry *savior.Entry) bool {
+	_, ok := savior.DestPathOf(cc.destSink, entry)
+	return !ok
+}
+
+// begin starts hashing a file, reading back what the sink
+// holds already if it's being resumed
+func (cc *contentChecker) begin(entry *savior.Entry) error {
+	cc.current = entry
+	cc.h = sha256.New()
+	if entry.WriteOffset == 0 {
+		return nil
+	}
+
+	destPath, ok := savior.DestPathOf(cc.destSink, entry)
+	if !ok {
+		return errors.Errorf("zipextractor: can't resume checking %s, the sink can't read it back", entry.CanonicalPath)
+	}
+
+	f, err := os.Open(destPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(cc.h, f, entry.WriteOffset)
+	if err != nil {
+		return errors.Wrapf(err, "hashing the first %d bytes of %s", entry.WriteOffset, entry.CanonicalPath)
+	}
+	return nil
+}
+
+// writing returns true if (part of) the entry was written
+func (cc *contentChecker) writing(entry *savior.Entry) bool {
+	return cc.current == entry
+}
+
+// check compares what was written of the current entry with its
+// expected hash
+func (cc *contentChecker) check(entry *savior.Entry) error {
+	if cc.current != entry {
+		return errors.Errorf("zipextractor: no contents were written for %s, can't check them", entry.CanonicalPath)
+	}
+	return cc.compare(entry, hex.EncodeToString(cc.h.Sum(nil)))
+}
+
+// checkBytes compares the contents of an entry, as they'll be
+// written, with its expected hash
+func (cc *contentChecker) checkBytes(entry *savior.Entry, data []byte) error {
+	sum := sha256.Sum256(data)
+	return cc.compare(entry, hex.EncodeToString(sum[:]))
+}
+
+func (cc *contentChecker) compare(entry *savior.Entry, sum string) error {
+	expected := cc.hashes[entry.CanonicalPath]
+	if sum != expected {
+		return errors.Wrapf(ErrUnexpectedContent, "%s: has hash %s, expected %s", entry.CanonicalPath, sum, expected)
+	}
+	return nil
+}
+
+// checkingSink feeds everything written to it to a contentChecker,
+// and doesn't create symlinks whose target doesn't match
+type checkingSink struct {
+	savior.Sink
+	cc *contentChecker
+}
+
+func (cs *checkingSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	err := cs.cc.begin(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := cs.Sink.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &checkingEntryWriter{EntryWriter: w, h: cs.cc.h}, nil
+}
+
+func (cs *checkingSink) Symlink(entry *savior.Entry, linkname string) error {
+	err := cs.cc.checkBytes(entry, []byte(linkname))
+	if err != nil {
+		return err
+	}
+	return cs.Sink.Symlink(entry, linkname)
+}
+
+type checkingEntryWriter struct {
+	savior.EntryWriter
+	h hash.Hash
+}
+
+func (cew *checkingEntryWriter) Write(buf []byte) (int, error) {
+	n, err := cew.EntryWriter.Write(buf)
+	cew.h.Write(buf[:n])
+	return n, err
+}
+
+// insertIndex adds an entry index to a sorted list, so
+// entries are listed in the order of the archive
+func insertIndex(indices []int64, index int64) []int64 {
+	i := sort.Search(len(indices), func(i int) bool { return indices[i] >= index })
+	indices = append(indices, 0)
+	copy(indices[i+1:], indices[i:])
+	indices[i] = index
+	return indices
+}
+
+// entryPaths returns the canonical paths of the entries at the given indices
+func (ze *ZipExtractor) entryPaths(indices []int64) []string {
+	var res []string
+	for _, index := range indices {
+		res = append(res, ze.entryAt(index).CanonicalPath)
+	}
+	return res
+}
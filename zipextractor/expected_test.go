@@ -0,0 +1,199 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestExpectedHashes(t *testing.T) {
+	assert := assert.New(t)
+
+	good := semirandom.Bytes(2 * 1024 * 1024)
+	evil := semirandom.Bytes(1024 * 1024)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "data/"},
+		{Name: "data/good.bin", Data: good, Method: zip.Deflate},
+		{Name: "data/evil.bin", Data: evil, Method: zip.Store},
+		{Name: "data/extra.txt", Data: []byte("not in the manifest")},
+		{Name: "data/link", Data: []byte("good.bin"), Mode: os.ModeSymlink | 0644},
+	})
+
+	hashes := map[string]string{
+		"data/good.bin": sha256Hex(good),
+		// deliberately wrong
+		"data/evil.bin": sha256Hex(evil[1:]),
+		"data/link":     sha256Hex([]byte("good.bin")),
+	}
+
+	tmpDir, err := ioutil.TempDir("", "zipextractor-expected")
+	must(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// entries that aren't listed fail before anything is written
+	failDest := filepath.Join(tmpDir, "fail")
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetExpectedHashes(hashes, zipextractor.UnexpectedContentFail)
+	_, err = ex.Resume(nil, &savior.FolderSink{Directory: failDest, Consumer: savior.NopConsumer()})
+	assert.Equal(zipextractor.ErrUnexpectedContent, errors.Cause(err))
+	_, err = os.Stat(failDest)
+	assert.True(os.IsNotExist(err), "nothing should be written")
+
+	// entries that don't match are removed before failing
+	listed := map[string]string{"data/extra.txt": sha256Hex([]byte("not in the manifest"))}
+	for p, h := range hashes {
+		listed[p] = h
+	}
+	ex = newTestZipExtractor(t, zipBytes)
+	ex.SetExpectedHashes(listed, zipextractor.UnexpectedContentFail)
+	_, err = ex.Resume(nil, &savior.FolderSink{Directory: failDest, Consumer: savior.NopConsumer()})
+	assert.Equal(zipextractor.ErrUnexpectedContent, errors.Cause(err))
+	assert.Contains(err.Error(), "data/evil.bin")
+	_, err = os.Lstat(filepath.Join(failDest, "data", "evil.bin"))
+	assert.True(os.IsNotExist(err), "evil.bin should be removed")
+
+	for _, withResumes := range []bool{false, true} {
+		dest := filepath.Join(tmpDir, "skip")
+		must(t, os.RemoveAll(dest))
+		sink := &savior.FolderSink{Directory: dest, Consumer: savior.NopConsumer()}
+
+		var c *savior.ExtractorCheckpoint
+		var unexpected []string
+		numResumes := 0
+		for {
+			ex := newTestZipExtractor(t, zipBytes)
+			ex.SetExpectedHashes(hashes, zipextractor.UnexpectedContentSkip)
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				if !withResumes {
+					return savior.AfterSaveContinue, nil
+				}
+				buf, err := savior.MarshalCheckpoint(checkpoint)
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				c, err = savior.UnmarshalCheckpoint(buf)
+				return savior.AfterSaveStop, err
+			}))
+
+			_, err := ex.Resume(c, sink)
+			if err == savior.ErrStop {
+				numResumes++
+				if numResumes > 100 {
+					t.Fatal("too many resumes")
+				}
+				continue
+			}
+			must(t, err)
+			unexpected = ex.UnexpectedEntries()
+			break
+		}
+		must(t, sink.Close())
+		assert.Equal(withResumes, numResumes > 0)
+
+		assert.EqualValues([]string{"data/evil.bin", "data/extra.txt"}, unexpected)
+
+		actual, err := ioutil.ReadFile(filepath.Join(dest, "data", "good.bin"))
+		must(t, err)
+		assert.True(string(good) == string(actual), "good.bin should be extracted")
+
+		for _, name := range []string{"evil.bin", "extra.txt"} {
+			_, err = os.Lstat(filepath.Join(dest, "data", name))
+			assert.True(os.IsNotExist(err), "%s shouldn't be written", name)
+		}
+	}
+
+	// can't start checking halfway through
+	var c *savior.ExtractorCheckpoint
+	ex = newTestZipExtractor(t, zipBytes)
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		c = checkpoint
+		return savior.AfterSaveStop, nil
+	}))
+	_, err = ex.Resume(nil, &savior.FolderSink{Directory: filepath.Join(tmpDir, "late"), Consumer: savior.NopConsumer()})
+	assert.Equal(savior.ErrStop, err)
+
+	ex = newTestZipExtractor(t, zipBytes)
+	ex.SetExpectedHashes(hashes, zipextractor.UnexpectedContentSkip)
+	_, err = ex.Resume(c, &savior.FolderSink{Directory: filepath.Join(tmpDir, "late"), Consumer: savior.NopConsumer()})
+	assert.Error(err)
+}
+
+// swappingReaderAt serves other contents for a region once
+// it's been read through
+type swappingReaderAt struct {
+	r       io.ReaderAt
+	start   int64
+	swapped []byte
+
+	end int64
+}
+
+func (sra *swappingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	regionEnd := sra.start + int64(len(sra.swapped))
+	if sra.end >= regionEnd && off < regionEnd && off+int64(len(buf)) > sra.start {
+		n, err := sra.r.ReadAt(buf, off)
+		for i := 0; i < n; i++ {
+			if pos := off + int64(i) - sra.start; pos >= 0 && pos < int64(len(sra.swapped)) {
+				buf[i] = sra.swapped[pos]
+			}
+		}
+		return n, err
+	}
+
+	if off+int64(len(buf)) > sra.end {
+		sra.end = off + int64(len(buf))
+	}
+	return sra.r.ReadAt(buf, off)
+}
+
+func TestExpectedHashesSwappedContents(t *testing.T) {
+	assert := assert.New(t)
+
+	good := semirandom.Bytes(64 * 1024)
+	evil := bytes.Repeat([]byte("evil"), 16*1024)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "data.bin", Data: good, Method: zip.Store},
+	})
+
+	// contents that are only evil the second time they're read
+	// are either never seen, or caught
+	ra := &swappingReaderAt{
+		r:       bytes.NewReader(zipBytes),
+		start:   int64(bytes.Index(zipBytes, good)),
+		swapped: evil,
+	}
+	ex, err := zipextractor.New(ra, int64(len(zipBytes)))
+	must(t, err)
+	// finding the central directory reads the whole (small) file
+	ra.end = 0
+	ex.SetVerifyCRC32(false)
+	ex.SetExpectedHashes(map[string]string{"data.bin": sha256Hex(good)}, zipextractor.UnexpectedContentFail)
+
+	sink := savior.NewMemorySink()
+	_, err = ex.Resume(nil, sink)
+	if err != nil {
+		assert.Equal(zipextractor.ErrUnexpectedContent, errors.Cause(err))
+		return
+	}
+	data, _, ok := sink.GetEntry("data.bin")
+	assert.True(ok)
+	assert.True(bytes.Equal(good, data), "only good contents should be written")
+}
@@ -0,0 +1,4 @@
+package zipextractor
+
+// exported for tests only
+var ParallelCRC32 = parallelCRC32
@@ -0,0 +1,179 @@
+package zipextractor
+
+import (
+	"path"
+	"strings"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrForbiddenExtension is returned when an entry's extension isn't allowed,
+// and the extension policy is ExtensionPolicyError
+var ErrForbiddenExtension = errors.New("entry has a forbidden extension")
+
+type ExtensionPolicy int
+
+const (
+	// ExtensionPolicySkip silently skips entries with forbidden extensions
+	ExtensionPolicySkip ExtensionPolicy = 0
+	// ExtensionPolicyError refuses to extract archives that contain
+	// entries with forbidden extensions
+	ExtensionPolicyError ExtensionPolicy = 1
+)
+
+// SetAllowedExtensions restricts extraction to files whose name ends with
+// one of the given extensions (case-insensitive, with or without the leading
+// dot, so "json", ".png" and "tar.gz" are all fine). Directories are exempt.
+// Passing nil lifts the restriction.
+func (ze *ZipExtractor) SetAllowedExtensions(extensions []string) {
+	ze.allowedExtensions = normalizeExtensions(extensions)
+}
+
+// SetDeniedExtensions prevents extraction of files whose name ends with one
+// of the given extensions. Denied extensions take precedence over allowed ones.
+func (ze *ZipExtractor) SetDeniedExtensions(extensions []string) {
+	ze.deniedExtensions = normalizeExtensions(extensions)
+}
+
+// SetExtensionPolicy decides what happens to entries with forbidden extensions
+func (ze *ZipExtractor) SetExtensionPolicy(policy ExtensionPolicy) {
+	ze.extensionPolicy = policy
+}
+
+func normalizeExtensions(extensions []string) []string {
+	if extensions == nil {
+		return nil
+	}
+
+	res := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		res = append(res, ext)
+	}
+	return res
+}
+
+func hasExtension(name string, extensions []string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMacOSXMetadata returns true for the AppleDouble files macOS Finder
+// adds to the zips it creates: anything in the `__MACOSX/` tree, and
+// files whose name start with `._`.
+func isMacOSXMetadata(canonicalPath string) bool {
+	p := strings.TrimSuffix(canonicalPath, "/")
+	if p == "__MACOSX" || strings.HasPrefix(p, "__MACOSX/") {
+		return true
+	}
+	return strings.HasPrefix(path.Base(p), "._")
+}
+
+func (ze *ZipExtractor) extensionAllowed(entry *savior.Entry) bool {
+	if entry.Kind == savior.EntryKindDir {
+		return true
+	}
+
+	if ze.deniedExtensions != nil && hasExtension(entry.CanonicalPath, ze.deniedExtensions) {
+		return false
+	}
+	if ze.allowedExtensions != nil && !hasExtension(entry.CanonicalPath, ze.allowedExtensions) {
+		return false
+	}
+	return true
+}
+
+// selectEntries decides which entries of the archive get extracted.
+// It's deterministic, so that resumed extractions make the same choices.
+func (ze *ZipExtractor) selectEntries() ([]bool, error) {
+	selected := make([]bool, len(ze.zr.File))
+	for i, zf := range ze.zr.File {
+		entry := zipFileEntry(zf)
+
+		if savior.IsRootPath(entry.CanonicalPath) {
+			// extracting those would mean touching the destination itself
+			savior.Debugf(`%q: skipping, refers to the root`, entry.CanonicalPath)
+			continue
+		}
+
+		if ze.SkipMacOSXMetadata && isMacOSXMetadata(entry.CanonicalPath) {
+			savior.Debugf(`%s: skipping, macOS metadata`, entry.CanonicalPath)
+			continue
+		}
+
+		if ze.pathFilter != nil && ze.pathFilter.excluded(entry) {
+			savior.Debugf(`%s: skipping, excluded by path filter`, entry.CanonicalPath)
+			continue
+		}
+
+		if ze.entryFilter != nil && !ze.entryFilter(entry) {
+			savior.Debugf(`%s: skipping, excluded by entry filter`, entry.CanonicalPath)
+			continue
+		}
+
+		if !ze.extensionAllowed(entry) {
+			if ze.extensionPolicy == ExtensionPolicyError {
+				return nil, errors.Wrapf(ErrForbiddenExtension, "%s", entry.CanonicalPath)
+			}
+			savior.Debugf(`%s: skipping, extension not allowed`, entry.CanonicalPath)
+			continue
+		}
+
+		selected[i] = true
+	}
+
+	err := ze.checkPathConflicts(selected)
+	if err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+// checkPathConflicts returns ErrPathConflict if a selected file or symlink
+// has the same path as a selected directory, or as the parent of any other
+// selected entry. Since it looks at the whole archive before anything is
+// written, the outcome doesn't depend on the order of entries.
+func (ze *ZipExtractor) checkPathConflicts(selected []bool) error {
+	dirs := make(map[string]string)
+	for i, zf := range ze.zr.File {
+		if !selected[i] {
+			continue
+		}
+		entry := zipFileEntry(zf)
+
+		p := path.Clean(entry.CanonicalPath)
+		if entry.Kind == savior.EntryKindDir {
+			dirs[p] = entry.CanonicalPath
+		}
+		for p = path.Dir(p); p != "." && p != "/"; p = path.Dir(p) {
+			if _, ok := dirs[p]; ok {
+				break
+			}
+			dirs[p] = entry.CanonicalPath
+		}
+	}
+
+	for i, zf := range ze.zr.File {
+		if !selected[i] {
+			continue
+		}
+		entry := zipFileEntry(zf)
+		if entry.Kind == savior.EntryKindDir {
+			continue
+		}
+
+		if other, ok := dirs[path.Clean(entry.CanonicalPath)]; ok {
+			return errors.Wrapf(savior.ErrPathConflict, "%s (directory implied by %s)", entry.CanonicalPath, other)
+		}
+	}
+	return nil
+}
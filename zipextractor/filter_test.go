@@ -0,0 +1,55 @@
+package zipextractor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtensionFilter(t *testing.T) {
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "assets/"},
+		{Name: "assets/data.json", Data: []byte(`{}`)},
+		{Name: "assets/icon.PNG", Data: []byte("png")},
+		{Name: "assets/notes.txt", Data: []byte("notes")},
+		{Name: "game.exe", Data: []byte("MZ")},
+		{Name: "README", Data: []byte("readme")},
+	})
+
+	exists := func(dir string, name string) bool {
+		_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil
+	}
+
+	{
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetAllowedExtensions([]string{"json", ".png", "txt"})
+		ex.SetDeniedExtensions([]string{".txt"})
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		must(t, err)
+
+		assert.True(t, exists(dir, "assets"))
+		assert.True(t, exists(dir, "assets/data.json"))
+		assert.True(t, exists(dir, "assets/icon.PNG"))
+		assert.False(t, exists(dir, "assets/notes.txt"), "denied extensions win over allowed ones")
+		assert.False(t, exists(dir, "game.exe"))
+		assert.False(t, exists(dir, "README"))
+	}
+
+	{
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetDeniedExtensions([]string{"exe"})
+		ex.SetExtensionPolicy(zipextractor.ExtensionPolicyError)
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == zipextractor.ErrForbiddenExtension)
+		assert.Contains(t, err.Error(), "game.exe")
+		assert.False(t, exists(dir, "assets/data.json"), "nothing should be written")
+	}
+}
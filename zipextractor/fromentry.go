@@ -0,0 +1,74 @@
+package zipextractor
+
+import (
+	"path"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ResumeFromEntry extracts the archive starting at entry `index` (as
+// listed in the central directory), as if all previous entries had already
+// been extracted. Unlike resuming from a checkpoint, there is no per-entry
+// state: the entry at `index` is written from the start.
+//
+// Directory entries listed before `index` are still created if they're
+// parents of entries that are extracted. It only supports IterationForward.
+//
+// Everything else starts over like a fresh extraction would: expected
+// hashes, path filters and previous manifests apply to the entries from
+// `index` on, and the manifest written by WriteManifest only lists those.
+func (ze *ZipExtractor) ResumeFromEntry(index int, sink savior.Sink) (*savior.ExtractorResult, error) {
+	if ze.iterationOrder != IterationForward {
+		return nil, errors.New("zipextractor: ResumeFromEntry only supports IterationForward")
+	}
+
+	numEntries := len(ze.zr.File)
+	if index < 0 || index > numEntries {
+		return nil, errors.Errorf("zipextractor: entry index %d out of range (archive has %d entries)", index, numEntries)
+	}
+
+	selected, err := ze.selectEntries()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	parents := make(map[string]bool)
+	for i := index; i < numEntries; i++ {
+		if !selected[i] {
+			continue
+		}
+		entry := zipFileEntry(ze.zr.File[i])
+		for p := path.Dir(path.Clean(entry.CanonicalPath)); p != "." && p != "/"; p = path.Dir(p) {
+			if parents[p] {
+				break
+			}
+			parents[p] = true
+		}
+	}
+
+	for i := 0; i < index; i++ {
+		if !selected[i] {
+			continue
+		}
+		entry := zipFileEntry(ze.zr.File[i])
+		if entry.Kind == savior.EntryKindDir && parents[path.Clean(entry.CanonicalPath)] {
+			err := sink.Mkdir(entry)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+
+	checkpoint := &savior.ExtractorCheckpoint{
+		EntryIndex: int64(index),
+	}
+	ze.events.Start("zip", checkpoint)
+	res, err := ze.resume(checkpoint, sink, true)
+	if err != nil {
+		ze.events.Error(err)
+		return nil, err
+	}
+	ze.events.Done(res)
+	return res, nil
+}
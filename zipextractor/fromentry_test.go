@@ -0,0 +1,106 @@
+package zipextractor_test
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	savior.NopSink
+	dirs  []string
+	files []string
+}
+
+func (rs *recordingSink) Mkdir(entry *savior.Entry) error {
+	rs.dirs = append(rs.dirs, entry.CanonicalPath)
+	return nil
+}
+
+func (rs *recordingSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	rs.files = append(rs.files, entry.CanonicalPath)
+	return rs.NopSink.GetWriter(entry)
+}
+
+func TestResumeFromEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "a/"},
+		{Name: "a/b/"},
+		{Name: "a/1", Data: []byte("a1")},
+		{Name: "other/"},
+		{Name: "a/b/2", Data: []byte("ab2")},
+		{Name: "c/"},
+		{Name: "c/3", Data: []byte("c3")},
+		{Name: "4", Data: []byte("4")},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	sink := &recordingSink{}
+	_, err := ex.ResumeFromEntry(4, sink)
+	must(t, err)
+
+	assert.EqualValues([]string{"a/b/2", "c/3", "4"}, sink.files)
+	// parents of a/b/2 are created, "other/" isn't
+	assert.EqualValues([]string{"a/", "a/b/", "c/"}, sink.dirs)
+
+	{
+		sink := &recordingSink{}
+		_, err := newTestZipExtractor(t, zipBytes).ResumeFromEntry(8, sink)
+		must(t, err)
+		assert.Empty(sink.files)
+	}
+
+	_, err = newTestZipExtractor(t, zipBytes).ResumeFromEntry(9, &recordingSink{})
+	assert.Error(err)
+}
+
+func TestResumeFromEntryOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "a/"},
+		{Name: "a/1", Data: []byte("a1")},
+		{Name: "a/2", Data: []byte("a2")},
+		{Name: "b/3", Data: []byte("b3")},
+		{Name: "4", Data: []byte("4")},
+	})
+
+	tmpDir, err := ioutil.TempDir("", "zipextractor-fromentry")
+	must(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// these need state, which a fresh start from an entry doesn't have
+	ex := newTestZipExtractor(t, zipBytes)
+	must(t, ex.SetPathFilterPatterns([]string{"b/", "/4"}))
+	ex.SetExpectedHashes(map[string]string{
+		"a/1": sha256Hex([]byte("a1")),
+		"a/2": sha256Hex([]byte("a2")),
+	}, zipextractor.UnexpectedContentFail)
+	manifestPath := filepath.Join(tmpDir, "MANIFEST")
+	ex.WriteManifest(manifestPath, crypto.SHA256)
+
+	sink := &savior.FolderSink{
+		Directory: filepath.Join(tmpDir, "dest"),
+		Consumer:  savior.NopConsumer(),
+	}
+	_, err = ex.ResumeFromEntry(2, sink)
+	must(t, err)
+
+	bs, err := ioutil.ReadFile(manifestPath)
+	must(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if assert.Len(lines, 1) {
+		assert.True(strings.HasSuffix(lines[0], " a/2"))
+	}
+	_, err = os.Stat(filepath.Join(tmpDir, "dest", "a", "1"))
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,43 @@
+//go:build go1.18
+// +build go1.18
+
+package zipextractor_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+)
+
+// enough for the seed corpus, not enough to sit on a decompression bomb
+const fuzzStepBudget = 4096
+
+func FuzzZipExtract(f *testing.F) {
+	f.Add(checker.MakeZip(f, checker.MakeTestSinkAdvanced(4)))
+	f.Add(makeTestZip(f, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir/stored.txt", Data: []byte("stored"), Method: zip.Store},
+		{Name: "dir/deflated.txt", Data: bytes.Repeat([]byte("deflated"), 1024), Method: zip.Deflate},
+		{Name: "link", Data: []byte("dir/stored.txt"), Mode: os.ModeSymlink | 0644},
+		{Name: "empty.txt"},
+	}))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ex, err := zipextractor.New(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		ex.SetStepBudget(fuzzStepBudget)
+		ex.SetDeadline(time.Now().Add(10 * time.Second))
+
+		// errors are fine, panics and hangs aren't
+		_, _ = ex.Resume(nil, &savior.NopSink{})
+	})
+}
@@ -0,0 +1,78 @@
+package zipextractor
+
+import (
+	"strings"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrGroupsUnsupported is returned by Resume, with SetCommitGroups,
+// when the sink isn't a savior.GroupCommitter (or wraps one that isn't)
+var ErrGroupsUnsupported = savior.ErrGroupsUnsupported
+
+// A CommitGrouper returns the name of the group an entry belongs to,
+// see SetCommitGroups
+type CommitGrouper func(entry *savior.Entry) string
+
+// GroupByTopLevel groups entries by top-level directory. Entries
+// at the root of the archive are all in the same group.
+func GroupByTopLevel(entry *savior.Entry) string {
+	p := strings.Trim(entry.CanonicalPath, "/")
+	i := strings.Index(p, "/")
+	if i == -1 {
+		if entry.Kind == savior.EntryKindDir {
+			return p
+		}
+		return ""
+	}
+	return p[:i]
+}
+
+// SetCommitGroups makes Resume commit entries group by group, for sinks
+// that can hold them back until then (see savior.GroupCommitter, and
+// savior.FolderSink.GroupCommits): whenever the next entry is in another
+// group than the previous one, and once everything is extracted. Groups
+// should be contiguous in the extraction order (see SetIterationOrder),
+// otherwise they're committed in several parts. Passing nil extracts
+// without groups.
+func (ze *ZipExtractor) SetCommitGroups(grouper CommitGrouper) {
+	ze.commitGrouper = grouper
+}
+
+// commitGroups keeps track of the group being extracted
+type commitGroups struct {
+	grouper   CommitGrouper
+	committer savior.GroupCommitter
+
+	current string
+	started bool
+}
+
+func (ze *ZipExtractor) newCommitGroups(sink savior.Sink) (*commitGroups, error) {
+	if ze.commitGrouper == nil {
+		return nil, nil
+	}
+
+	gc, ok := sink.(savior.GroupCommitter)
+	if !ok {
+		return nil, errors.WithStack(ErrGroupsUnsupported)
+	}
+	return &commitGroups{
+		grouper:   ze.commitGrouper,
+		committer: gc,
+	}, nil
+}
+
+// enter returns true if the entry is in another group than the
+// previous one, which must be committed first
+func (cg *commitGroups) enter(entry *savior.Entry) bool {
+	group := cg.grouper(entry)
+	changed := cg.started && group != cg.current
+	if changed {
+		savior.Debugf("zipextractor: group %q is done, %s starts group %q", cg.current, entry.CanonicalPath, group)
+	}
+	cg.current = group
+	cg.started = true
+	return changed
+}
@@ -0,0 +1,143 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	groups := []string{"a", "b", "c"}
+	files := make(map[string]map[string][]byte)
+	var entries []testZipEntry
+	for _, group := range groups {
+		files[group] = make(map[string][]byte)
+		entries = append(entries, testZipEntry{Name: group + "/"})
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("%s/file-%d.bin", group, i)
+			data := semirandom.Bytes(int64(100*1024 + i*50*1024))
+			files[group][name] = data
+			entries = append(entries, testZipEntry{Name: name, Data: data, Method: zip.Deflate})
+		}
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	dir, err := ioutil.TempDir("", "zipextractor-groups")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	// returns how many groups are visible, and fails the
+	// test if only part of a group is
+	visibleGroups := func() int {
+		visible := 0
+		for _, group := range groups {
+			present := 0
+			for name, data := range files[group] {
+				actual, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					continue
+				}
+				assert.True(bytes.Equal(data, actual), "%s is visible, it should be complete", name)
+				present++
+			}
+			if present > 0 {
+				assert.EqualValues(len(files[group]), present, "group %s is only partly visible", group)
+				visible++
+			}
+		}
+		return visible
+	}
+
+	var c *savior.ExtractorCheckpoint
+	var partlyDone bool
+	checkpoints := 0
+	for runs := 0; ; runs++ {
+		if !assert.True(runs < 2, "should only stop once") {
+			return
+		}
+
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetCommitGroups(zipextractor.GroupByTopLevel)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(64*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			checkpoints++
+			visible := visibleGroups()
+			if visible > 0 && visible < len(groups) {
+				partlyDone = true
+			}
+
+			// stop in the middle of the second group
+			if c == nil && visible == 1 && checkpoint.Entry != nil && checkpoint.Entry.WriteOffset > 0 {
+				bs, err := savior.MarshalCheckpoint(checkpoint)
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				c, err = savior.UnmarshalCheckpoint(bs)
+				return savior.AfterSaveStop, err
+			}
+			return savior.AfterSaveContinue, nil
+		}))
+
+		sink := &savior.FolderSink{
+			Directory:    dir,
+			Consumer:     savior.NopConsumer(),
+			GroupCommits: true,
+		}
+		_, err = ex.Resume(c, sink)
+		must(t, sink.Close())
+		if err == savior.ErrStop {
+			// interrupted: the first group is there, the second one isn't
+			assert.EqualValues(1, visibleGroups())
+			continue
+		}
+		must(t, err)
+		break
+	}
+
+	assert.NotNil(c, "should have stopped mid-group")
+	assert.True(partlyDone, "checkpoints should happen between commits")
+	assert.True(checkpoints > 10)
+	assert.EqualValues(len(groups), visibleGroups())
+
+	_, err = os.Lstat(filepath.Join(dir, ".savior-staging"))
+	assert.True(os.IsNotExist(err), "staging folder should be gone")
+
+	// sinks that can't hold entries back can't be used
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetCommitGroups(zipextractor.GroupByTopLevel)
+	_, err = ex.Resume(nil, savior.NewMemorySink())
+	assert.Error(err)
+	assert.True(errors.Cause(err) == zipextractor.ErrGroupsUnsupported)
+}
+
+func TestGroupByTopLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	for canonicalPath, group := range map[string]string{
+		"readme.txt":        "",
+		"data/":             "data",
+		"data/sub/file.bin": "data",
+		"/abs/file.bin":     "abs",
+	} {
+		var kind savior.EntryKind = savior.EntryKindFile
+		if canonicalPath[len(canonicalPath)-1] == '/' {
+			kind = savior.EntryKindDir
+		}
+		assert.EqualValues(group, zipextractor.GroupByTopLevel(&savior.Entry{
+			CanonicalPath: canonicalPath,
+			Kind:          kind,
+		}), "group of %s", canonicalPath)
+	}
+}
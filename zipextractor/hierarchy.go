@@ -0,0 +1,58 @@
+package zipextractor
+
+import (
+	"path"
+	"strings"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrBadHierarchy is returned by Resume, with SetStrictHierarchy, when an
+// entry's parent is declared as something else than a directory.
+var ErrBadHierarchy = errors.New("zipextractor: entry's parent isn't a directory")
+
+// SetStrictHierarchy makes Resume check, before extracting anything, that
+// no entry is under a path the archive declares as a file, symlink or
+// hardlink (a file `a/b` when `a` is a file, say). Such archives fail with
+// ErrBadHierarchy, instead of whatever error the sink runs into halfway
+// through. Parents that aren't in the archive at all are fine, they're
+// created as needed.
+func (ze *ZipExtractor) SetStrictHierarchy(strict bool) {
+	ze.strictHierarchy = strict
+}
+
+// checkHierarchy returns ErrBadHierarchy if an entry of the archive
+// is under one that's not a directory, see SetStrictHierarchy
+func (ze *ZipExtractor) checkHierarchy() error {
+	if !ze.strictHierarchy {
+		return nil
+	}
+
+	entries := ze.Entries()
+	notDirs := make(map[string]*savior.Entry)
+	for _, entry := range entries {
+		if entry.Kind != savior.EntryKindDir {
+			notDirs[hierarchyPath(entry.CanonicalPath)] = entry
+		}
+	}
+	if len(notDirs) == 0 {
+		return nil
+	}
+
+	for _, entry := range entries {
+		p := hierarchyPath(entry.CanonicalPath)
+		for parent := path.Dir(p); parent != "." && parent != "/"; parent = path.Dir(parent) {
+			if pe, ok := notDirs[parent]; ok {
+				return errors.Wrapf(ErrBadHierarchy, "%s is under %s, which is a %s", entry.CanonicalPath, pe.CanonicalPath, pe.Kind)
+			}
+		}
+	}
+	return nil
+}
+
+// hierarchyPath normalizes a canonical path, so that `a/./b/`
+// and `a/b` are the same
+func hierarchyPath(canonicalPath string) string {
+	return path.Clean(strings.TrimPrefix(canonicalPath, "/"))
+}
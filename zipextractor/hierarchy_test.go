@@ -0,0 +1,68 @@
+package zipextractor_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrictHierarchy(t *testing.T) {
+	bad := map[string][]testZipEntry{
+		"file parent": {
+			{Name: "ok.txt", Data: []byte("ok")},
+			{Name: "a", Data: []byte("a file")},
+			{Name: "a/b", Data: []byte("under a file")},
+		},
+		"file grandparent, declared later": {
+			{Name: "a/b/c.txt", Data: []byte("under a file")},
+			{Name: "a/", Data: nil},
+			{Name: "a/b", Data: []byte("a file")},
+		},
+		"symlink parent": {
+			{Name: "ok.txt", Data: []byte("ok")},
+			{Name: "link", Data: []byte("ok.txt"), Mode: os.ModeSymlink | 0644},
+			{Name: "link/file.txt", Data: []byte("through a symlink")},
+		},
+	}
+
+	for name, entries := range bad {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ex := newTestZipExtractor(t, makeTestZip(t, entries))
+			ex.SetStrictHierarchy(true)
+			sink := savior.NewMemorySink()
+			_, err := ex.Resume(nil, sink)
+			assert.Error(err)
+			assert.True(errors.Cause(err) == zipextractor.ErrBadHierarchy)
+			assert.Empty(sink.Paths(), "nothing should be extracted")
+		})
+	}
+
+	t.Run("good", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// implicit parents, and directories declared after
+		// what's in them, are fine
+		ex := newTestZipExtractor(t, makeTestZip(t, []testZipEntry{
+			{Name: "implicit/file.txt", Data: []byte("implicit parent")},
+			{Name: "dir/sub/file.txt", Data: []byte("declared later")},
+			{Name: "dir/"},
+			{Name: "dir/sub/"},
+			{Name: "dir.txt", Data: []byte("looks like dir, isn't")},
+			{Name: "link", Data: []byte("dir"), Mode: os.ModeSymlink | 0644},
+		}))
+		ex.SetStrictHierarchy(true)
+		sink := savior.NewMemorySink()
+		_, err := ex.Resume(nil, sink)
+		must(t, err)
+
+		data, _, ok := sink.GetEntry("dir/sub/file.txt")
+		assert.True(ok)
+		assert.EqualValues("declared later", string(data))
+	})
+}
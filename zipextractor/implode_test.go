@@ -0,0 +1,122 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// so makeTestZip can write shrunk entries, which are stored
+	// as-is, since they're never decompressed
+	zip.RegisterCompressor(1, func(s zip.CompressionSettings, w io.Writer) (io.WriteCloser, error) {
+		return nopWriteCloser{w}, nil
+	})
+}
+
+func TestImplodeResume(t *testing.T) {
+	assert := assert.New(t)
+
+	// the same text imploded with every combination of dictionary
+	// size and number of trees, see implodesource
+	zipBytes, err := ioutil.ReadFile(filepath.Join("..", "implodesource", "testdata", "imploded.zip"))
+	must(t, err)
+	names := []string{
+		"imploded-4k-2trees.txt",
+		"imploded-8k-2trees.txt",
+		"imploded-4k-3trees.txt",
+		"imploded-8k-3trees.txt",
+	}
+
+	dir, err := ioutil.TempDir("", "zipextractor-implode")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	// stop at every checkpoint: imploded entries are decompressed
+	// again from their start, up to where they were stopped
+	var c *savior.ExtractorCheckpoint
+	numResumes := 0
+	midEntry := 0
+	for {
+		if !assert.True(numResumes < 100, "too many resumes") {
+			return
+		}
+
+		ex := newTestZipExtractor(t, zipBytes)
+		assert.EqualValues(savior.ResumeSupportBlock, ex.Features().ResumeSupport)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(2048, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if checkpoint.SourceCheckpoint != nil && checkpoint.SourceCheckpoint.OutputOffset > 0 {
+				midEntry++
+			}
+			bs, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(bs)
+			return savior.AfterSaveStop, err
+		}))
+
+		sink := &savior.FolderSink{
+			Directory: dir,
+			Consumer:  savior.NopConsumer(),
+		}
+		_, err = ex.Resume(c, sink)
+		must(t, sink.Close())
+		if err == savior.ErrStop {
+			numResumes++
+			continue
+		}
+		must(t, err)
+		break
+	}
+	assert.True(numResumes > 0)
+	assert.EqualValues(numResumes, midEntry, "should have stopped within entries")
+
+	// the extractor checks sizes and CRC-32s, so they're right,
+	// and they should all be the same
+	reference, err := ioutil.ReadFile(filepath.Join(dir, names[0]))
+	must(t, err)
+	assert.True(len(reference) > 8192)
+	for _, name := range names[1:] {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, name))
+		must(t, err)
+		assert.True(bytes.Equal(reference, actual), "%s should have the right contents", name)
+	}
+
+	// entries can also be read on their own
+	ex := newTestZipExtractor(t, zipBytes)
+	buf := new(bytes.Buffer)
+	must(t, ex.ExtractEntry(names[3], buf))
+	assert.True(bytes.Equal(reference, buf.Bytes()))
+
+	bs, err := ex.ReadEntryBytes(1, 16*1024)
+	must(t, err)
+	assert.True(bytes.Equal(reference, bs))
+}
+
+func TestLegacyMethods(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "readme.txt", Data: []byte("read me")},
+		{Name: "shrunk.txt", Data: []byte("not really shrunk"), Method: 1},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	assert.Error(err)
+	assert.EqualValues(zipextractor.ErrUnsupportedMethod, errors.Cause(err))
+	assert.Contains(err.Error(), "shrunk.txt")
+	assert.Contains(err.Error(), "(shrink)")
+}
@@ -0,0 +1,167 @@
+package zipextractor
+
+import (
+	"strings"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// IterationOrder decides in which order entries are extracted
+type IterationOrder int
+
+const (
+	// IterationForward extracts entries in the order of the central directory
+	IterationForward IterationOrder = 0
+	// IterationReverse extracts all directories first (in central directory
+	// order, so parents still come before their children), then all other
+	// entries starting from the end of the central directory.
+	IterationReverse IterationOrder = 1
+	// IterationCustom extracts entries in the order decided by an
+	// EntrySorter, see SetEntrySorter.
+	IterationCustom IterationOrder = 2
+)
+
+// An EntrySorter is given all entries of an archive (as returned by
+// Entries), and returns the same entries, in the order they should be
+// extracted in. It may sort the slice it's given in place.
+type EntrySorter func(entries []*savior.Entry) []*savior.Entry
+
+// SetIterationOrder changes the order entries are extracted in. The
+// `EntryIndex` of checkpoints is a position in that order, so resuming
+// requires the same order, and ResumeFromEntry only supports IterationForward.
+func (ze *ZipExtractor) SetIterationOrder(order IterationOrder) {
+	ze.iterationOrder = order
+}
+
+// SetEntrySorter makes entries be extracted in the order `sorter` returns,
+// for example to extract an executable first, so it can be started before
+// the rest of the archive is there. Directories are moved up as needed, so
+// that they're still created before anything in them.
+//
+// The order is decided when extraction starts, and stored in checkpoints,
+// so resuming doesn't call the sorter again, but still requires an extractor
+// with a sorter (any sorter).
+func (ze *ZipExtractor) SetEntrySorter(sorter EntrySorter) {
+	ze.entrySorter = sorter
+	if sorter == nil {
+		ze.iterationOrder = IterationForward
+	} else {
+		ze.iterationOrder = IterationCustom
+	}
+}
+
+// walkOrder returns the index of the entry to extract at each position
+func (ze *ZipExtractor) walkOrder(checkpoint *savior.ExtractorCheckpoint, isFresh bool) ([]int64, error) {
+	numEntries := len(ze.zr.File)
+	order := make([]int64, 0, numEntries)
+
+	switch ze.iterationOrder {
+	case IterationCustom:
+		if !isFresh {
+			// stick to the order decided when we started
+			state, ok := checkpoint.Data.(*ZipExtractorState)
+			if !ok || len(state.Order) != numEntries {
+				return nil, errors.New("zipextractor: checkpoint doesn't have a valid custom order")
+			}
+			seen := make([]bool, numEntries)
+			for _, i := range state.Order {
+				if i < 0 || i >= int64(numEntries) || seen[i] {
+					return nil, errors.Errorf("zipextractor: invalid entry %d in checkpoint order", i)
+				}
+				seen[i] = true
+			}
+			return state.Order, nil
+		}
+		return ze.sortedOrder()
+	case IterationReverse:
+		isDir := make([]bool, numEntries)
+		for i, zf := range ze.zr.File {
+			isDir[i] = zipFileEntry(zf).Kind == savior.EntryKindDir
+			if isDir[i] {
+				order = append(order, int64(i))
+			}
+		}
+		for i := numEntries - 1; i >= 0; i-- {
+			if !isDir[i] {
+				order = append(order, int64(i))
+			}
+		}
+	default:
+		for i := range ze.zr.File {
+			order = append(order, int64(i))
+		}
+	}
+	return order, nil
+}
+
+// sortedOrder calls the entry sorter, and makes sure directories
+// come before their contents
+func (ze *ZipExtractor) sortedOrder() ([]int64, error) {
+	if ze.entrySorter == nil {
+		return nil, errors.New("zipextractor: custom iteration order needs an entry sorter, see SetEntrySorter")
+	}
+
+	entries := ze.Entries()
+	indices := make(map[*savior.Entry]int64, len(entries))
+	dirs := make(map[string]int64)
+	for i, entry := range entries {
+		indices[entry] = int64(i)
+		if entry.Kind == savior.EntryKindDir {
+			dirs[strings.TrimSuffix(entry.CanonicalPath, "/")] = int64(i)
+		}
+	}
+
+	sorted := ze.entrySorter(append([]*savior.Entry{}, entries...))
+	if len(sorted) != len(entries) {
+		return nil, errors.Errorf("zipextractor: entry sorter returned %d entries, archive has %d", len(sorted), len(entries))
+	}
+
+	order := make([]int64, 0, len(entries))
+	seen := make([]bool, len(entries))
+	placed := make([]bool, len(entries))
+	place := func(i int64) {
+		if !placed[i] {
+			placed[i] = true
+			order = append(order, i)
+		}
+	}
+
+	for _, entry := range sorted {
+		i, ok := indices[entry]
+		if !ok {
+			return nil, errors.Errorf("zipextractor: entry sorter returned an entry that's not from Entries(): %s", entry)
+		}
+		if seen[i] {
+			return nil, errors.Errorf("zipextractor: entry sorter returned %s twice", entry)
+		}
+		seen[i] = true
+
+		// parents first, outermost first
+		tokens := strings.Split(strings.TrimSuffix(entry.CanonicalPath, "/"), "/")
+		for j := 1; j < len(tokens); j++ {
+			if dir, ok := dirs[strings.Join(tokens[:j], "/")]; ok {
+				place(dir)
+			}
+		}
+		place(i)
+	}
+	return order, nil
+}
+
+// checkIterationOrder makes sure a checkpoint is resumed
+// with the order it was taken with.
+func (ze *ZipExtractor) checkIterationOrder(checkpoint *savior.ExtractorCheckpoint, isFresh bool) error {
+	if isFresh {
+		return nil
+	}
+
+	order := IterationForward
+	if state, ok := checkpoint.Data.(*ZipExtractorState); ok {
+		order = state.IterationOrder
+	}
+	if order != ze.iterationOrder {
+		return errors.Errorf("zipextractor: checkpoint was taken with iteration order %d, can't resume with order %d", order, ze.iterationOrder)
+	}
+	return nil
+}
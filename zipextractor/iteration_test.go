@@ -0,0 +1,199 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseIteration(t *testing.T) {
+	assert := assert.New(t)
+
+	{
+		zipBytes := makeTestZip(t, []testZipEntry{
+			{Name: "a/"},
+			{Name: "a/1", Data: []byte("1")},
+			{Name: "b/"},
+			{Name: "b/2", Data: []byte("2")},
+			{Name: "3", Data: []byte("3")},
+		})
+
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetIterationOrder(zipextractor.IterationReverse)
+		sink := &recordingSink{}
+		_, err := ex.Resume(nil, sink)
+		must(t, err)
+
+		assert.EqualValues([]string{"a/", "b/"}, sink.dirs)
+		assert.EqualValues([]string{"3", "b/2", "a/1"}, sink.files)
+
+		_, err = ex.ResumeFromEntry(1, &recordingSink{})
+		assert.Error(err)
+	}
+
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+
+	makeExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetIterationOrder(zipextractor.IterationReverse)
+		return ex
+	}
+
+	checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+		return false
+	})
+	checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+		return true
+	})
+
+	{
+		// checkpoints remember which order they were taken with
+		var c *savior.ExtractorCheckpoint
+		ex := makeExtractor()
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			buf, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(buf)
+			return savior.AfterSaveStop, err
+		}))
+		sink.Reset()
+		_, err := ex.Resume(nil, sink)
+		assert.Equal(savior.ErrStop, err)
+		if !assert.NotNil(c) {
+			return
+		}
+
+		_, err = newTestZipExtractor(t, zipBytes).Resume(c, sink)
+		assert.Error(err, "resuming with another order should fail")
+	}
+}
+
+// sequenceSink records directories and files in the order they're created
+type sequenceSink struct {
+	savior.NopSink
+	paths []string
+}
+
+func (ss *sequenceSink) Mkdir(entry *savior.Entry) error {
+	ss.paths = append(ss.paths, entry.CanonicalPath)
+	return nil
+}
+
+func (ss *sequenceSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	ss.paths = append(ss.paths, entry.CanonicalPath)
+	return ss.NopSink.GetWriter(entry)
+}
+
+// prioritize returns a sorter that moves the entry at `path` first
+func prioritize(path string) zipextractor.EntrySorter {
+	return func(entries []*savior.Entry) []*savior.Entry {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CanonicalPath == path && entries[j].CanonicalPath != path
+		})
+		return entries
+	}
+}
+
+func TestEntrySorter(t *testing.T) {
+	assert := assert.New(t)
+
+	{
+		zipBytes := makeTestZip(t, []testZipEntry{
+			{Name: "a/"},
+			{Name: "a/1", Data: []byte("1")},
+			{Name: "bin/"},
+			{Name: "bin/game.exe", Data: []byte("MZ")},
+			{Name: "b/2", Data: []byte("2")},
+		})
+
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetEntrySorter(prioritize("bin/game.exe"))
+		sink := &sequenceSink{}
+		_, err := ex.Resume(nil, sink)
+		must(t, err)
+
+		// its parent still comes first
+		assert.EqualValues([]string{"bin/", "bin/game.exe", "a/", "a/1", "b/2"}, sink.paths)
+
+		ex = newTestZipExtractor(t, zipBytes)
+		ex.SetEntrySorter(func(entries []*savior.Entry) []*savior.Entry {
+			return entries[1:]
+		})
+		_, err = ex.Resume(nil, &sequenceSink{})
+		assert.Error(err, "sorters must return every entry")
+
+		ex = newTestZipExtractor(t, zipBytes)
+		ex.SetEntrySorter(func(entries []*savior.Entry) []*savior.Entry {
+			return append(entries[1:], entries[1])
+		})
+		_, err = ex.Resume(nil, &sequenceSink{})
+		assert.Error(err, "sorters must return every entry once")
+	}
+
+	sink := checker.MakeTestSinkAdvanced(20)
+	zipBytes := checker.MakeZip(t, sink)
+
+	var priority string
+	for name, item := range sink.Items {
+		if item.Entry.Kind == savior.EntryKindFile && (priority == "" || name > priority) {
+			priority = name
+		}
+	}
+
+	makeExtractor := func() savior.Extractor {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		must(t, err)
+		ex.SetEntrySorter(prioritize(priority))
+		return ex
+	}
+
+	checker.RunExtractorText(t, makeExtractor, sink, func() bool {
+		return true
+	})
+
+	{
+		// the order is in the checkpoint, the sorter isn't called again
+		var c *savior.ExtractorCheckpoint
+		ex := makeExtractor()
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if checkpoint.EntryIndex == 0 {
+				return savior.AfterSaveContinue, nil
+			}
+			buf, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(buf)
+			return savior.AfterSaveStop, err
+		}))
+		sink.Reset()
+		_, err := ex.Resume(nil, sink)
+		assert.Equal(savior.ErrStop, err)
+		if !assert.NotNil(c) {
+			return
+		}
+
+		_, err = newTestZipExtractor(t, zipBytes).Resume(c, sink)
+		assert.Error(err, "resuming without a sorter should fail")
+
+		ex2 := newTestZipExtractor(t, zipBytes)
+		ex2.SetEntrySorter(func(entries []*savior.Entry) []*savior.Entry {
+			t.Error("sorter shouldn't be called when resuming")
+			return entries
+		})
+		sink.Reset()
+		_, err = ex2.Resume(c, sink)
+		must(t, err)
+		di := sink.DoneItems[priority]
+		assert.True(di == nil || di.MaxWrite == 0, "priority entry was written before the checkpoint, shouldn't be written again")
+	}
+}
@@ -0,0 +1,117 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/stretchr/testify/assert"
+)
+
+// lzmaWriter compresses everything written to it on Close, and writes
+// it out the way zip files store LZMA data
+type lzmaWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (lw *lzmaWriter) Write(buf []byte) (int, error) {
+	return lw.buf.Write(buf)
+}
+
+func (lw *lzmaWriter) Close() error {
+	alone, err := checker.LzmaCompress(lw.buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// LZMA SDK version and properties size, then the properties and
+	// the stream, without the .lzma header's decompressed size
+	data := []byte{9, 20, 5, 0}
+	data = append(data, alone[:5]...)
+	data = append(data, alone[13:]...)
+	_, err = lw.w.Write(data)
+	return err
+}
+
+func init() {
+	zip.RegisterCompressor(zip.LZMA, func(s zip.CompressionSettings, w io.Writer) (io.WriteCloser, error) {
+		return &lzmaWriter{w: w}, nil
+	})
+}
+
+func TestLzmaResume(t *testing.T) {
+	assert := assert.New(t)
+
+	files := map[string][]byte{
+		"big.bin":       semirandom.Bytes(2 * 1024 * 1024),
+		"dir/small.txt": []byte("small but compressed with lzma"),
+	}
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "big.bin", Data: files["big.bin"], Method: zip.LZMA},
+		{Name: "dir/"},
+		{Name: "dir/small.txt", Data: files["dir/small.txt"], Method: zip.LZMA},
+	})
+
+	dir, err := ioutil.TempDir("", "zipextractor-lzma")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	var c *savior.ExtractorCheckpoint
+	for runs := 0; ; runs++ {
+		if !assert.True(runs < 2, "should only stop once") {
+			return
+		}
+
+		ex := newTestZipExtractor(t, zipBytes)
+		assert.EqualValues(savior.ResumeSupportBlock, ex.Features().ResumeSupport)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			// stop once, in the middle of the big entry
+			if c == nil && checkpoint.SourceCheckpoint != nil && checkpoint.Entry.WriteOffset > 512*1024 {
+				bs, err := savior.MarshalCheckpoint(checkpoint)
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				c, err = savior.UnmarshalCheckpoint(bs)
+				return savior.AfterSaveStop, err
+			}
+			return savior.AfterSaveContinue, nil
+		}))
+
+		sink := &savior.FolderSink{
+			Directory: dir,
+			Consumer:  savior.NopConsumer(),
+		}
+		_, err = ex.Resume(c, sink)
+		must(t, sink.Close())
+		if err == savior.ErrStop {
+			continue
+		}
+		must(t, err)
+		break
+	}
+	assert.NotNil(c, "should have stopped mid-entry")
+
+	for name, data := range files {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		must(t, err)
+		assert.True(bytes.Equal(data, actual), "%s should have the right contents", name)
+	}
+
+	// entries can also be read on their own
+	ex := newTestZipExtractor(t, zipBytes)
+	buf := new(bytes.Buffer)
+	must(t, ex.ExtractEntry("big.bin", buf))
+	assert.True(bytes.Equal(files["big.bin"], buf.Bytes()))
+
+	bs, err := ex.ReadEntryBytes(2, 1024)
+	must(t, err)
+	assert.EqualValues(files["dir/small.txt"], bs)
+}
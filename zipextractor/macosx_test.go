@@ -0,0 +1,96 @@
+package zipextractor_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipMacOSXMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	// what Finder's "Compress" produces
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "Game/"},
+		{Name: "Game/game.exe", Data: []byte("MZ")},
+		{Name: "__MACOSX/"},
+		{Name: "__MACOSX/Game/"},
+		{Name: "__MACOSX/Game/._game.exe", Data: []byte("appledouble")},
+		{Name: "Game/data/"},
+		{Name: "Game/data/level1.dat", Data: []byte("level1")},
+		{Name: "Game/data/._level1.dat", Data: []byte("appledouble")},
+		{Name: "Game/data/level2.dat", Data: []byte("level2")},
+		{Name: "Game/__MACOSX.txt", Data: []byte("not metadata")},
+	})
+
+	exists := func(dir string, name string) bool {
+		_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil
+	}
+
+	{
+		ex := newTestZipExtractor(t, zipBytes)
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		must(t, err)
+
+		assert.True(exists(dir, "__MACOSX/Game/._game.exe"), "metadata is kept by default")
+		assert.True(exists(dir, "Game/data/._level1.dat"), "metadata is kept by default")
+	}
+
+	dir, err := ioutil.TempDir("", "zipextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+	defer sink.Close()
+
+	// stop after every entry, resume with a fresh extractor
+	var c *savior.ExtractorCheckpoint
+	numResumes := 0
+	for {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SkipMacOSXMetadata = true
+		ex.SetDeadline(time.Now())
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(1, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			buf, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(buf)
+			return savior.AfterSaveContinue, err
+		}))
+
+		res, err := ex.Resume(c, sink)
+		if errors.Cause(err) == zipextractor.ErrDeadlineExceeded {
+			numResumes++
+			assert.True(numResumes < 100, "too many resumes")
+			continue
+		}
+		must(t, err)
+
+		for _, entry := range res.Entries {
+			assert.NotContains(entry.CanonicalPath, "._")
+		}
+		break
+	}
+	assert.True(numResumes > 0, "should have resumed at least once")
+
+	assert.True(exists(dir, "Game/game.exe"))
+	assert.True(exists(dir, "Game/data/level1.dat"))
+	assert.True(exists(dir, "Game/data/level2.dat"))
+	assert.True(exists(dir, "Game/__MACOSX.txt"))
+	assert.False(exists(dir, "__MACOSX"))
+	assert.False(exists(dir, "Game/data/._level1.dat"))
+}
@@ -0,0 +1,253 @@
+package zipextractor
+
+import (
+	"crypto"
+	// the default algorithm for manifests, others need to be
+	// imported by the caller
+	_ "crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// WriteManifest makes Resume write a manifest to `path`, with one line per
+// extracted entry, in the order they're written:
+//
+//	<hash> <size> <mode> <path>
+//
+// where hash is the hex-encoded `algo` hash of the entry's contents (the
+// target for symlinks, "-" for directories), and mode is formatted like
+// os.FileMode does, ie. "-rw-r--r--". The manifest is written to
+// `path + ".partial"` as extraction goes, and renamed to `path` once it's
+// done. Resuming requires a checkpoint taken by an extractor that was
+// writing the same manifest.
+func (ze *ZipExtractor) WriteManifest(path string, algo crypto.Hash) {
+	ze.manifestPath = path
+	ze.manifestAlgo = algo
+}
+
+type manifest struct {
+	path string
+	algo crypto.Hash
+	f    *os.File
+
+	// bytes of the manifest written so far
+	size int64
+
+	// the entry being written, and what we know of its contents
+	current *savior.Entry
+	h       hash.Hash
+	written int64
+}
+
+// openManifest creates the partial manifest, or when resuming,
+// truncates it to the size recorded in the checkpoint.
+func (ze *ZipExtractor) openManifest(checkpoint *savior.ExtractorCheckpoint, isFresh bool) (*manifest, error) {
+	if !ze.manifestAlgo.Available() {
+		return nil, errors.Errorf("zipextractor: manifest hash algorithm %d is not available", ze.manifestAlgo)
+	}
+
+	m := &manifest{
+		path: ze.manifestPath,
+		algo: ze.manifestAlgo,
+	}
+	partialPath := m.path + ".partial"
+
+	if isFresh {
+		f, err := os.Create(partialPath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		m.f = f
+		return m, nil
+	}
+
+	state, ok := checkpoint.Data.(*ZipExtractorState)
+	if !ok || !state.Manifest {
+		return nil, errors.New("zipextractor: can't write manifest, checkpoint was taken without one")
+	}
+
+	f, err := os.OpenFile(partialPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	m.f = f
+
+	stats, err := f.Stat()
+	if err != nil {
+		m.close()
+		return nil, errors.WithStack(err)
+	}
+	if stats.Size() < state.ManifestSize {
+		m.close()
+		return nil, errors.Errorf("zipextractor: manifest %s is shorter than expected (%d < %d bytes)", partialPath, stats.Size(), state.ManifestSize)
+	}
+
+	err = f.Truncate(state.ManifestSize)
+	if err != nil {
+		m.close()
+		return nil, errors.WithStack(err)
+	}
+	_, err = f.Seek(state.ManifestSize, io.SeekStart)
+	if err != nil {
+		m.close()
+		return nil, errors.WithStack(err)
+	}
+	m.size = state.ManifestSize
+
+	return m, nil
+}
+
+// begin starts hashing a file or symlink entry
+func (m *manifest) begin(entry *savior.Entry) {
+	m.current = entry
+	m.h = m.algo.New()
+	m.written = 0
+}
+
+// add appends a line for an entry that was written completely
+func (m *manifest) add(entry *savior.Entry) error {
+	sum := "-"
+	var size int64
+	if entry.Kind != savior.EntryKindDir {
+		if m.current != entry {
+			return errors.Errorf("zipextractor: no contents were written for %s, can't add it to the manifest", entry.CanonicalPath)
+		}
+		sum = hex.EncodeToString(m.h.Sum(nil))
+		size = m.written
+	}
+	m.current = nil
+
+	line := fmt.Sprintf("%s %d %s %s\n", sum, size, entry.Mode, entry.CanonicalPath)
+	n, err := m.f.WriteString(line)
+	m.size += int64(n)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (m *manifest) sync() error {
+	return m.f.Sync()
+}
+
+func (m *manifest) close() error {
+	if m.f == nil {
+		return nil
+	}
+	err := m.f.Close()
+	m.f = nil
+	return err
+}
+
+// finalize renames the partial manifest to its final name
+func (m *manifest) finalize() error {
+	err := m.sync()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = m.close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.Rename(m.path+".partial", m.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// manifestSink hashes everything written to it
+type manifestSink struct {
+	savior.Sink
+	m *manifest
+
+	// openPrefix returns a reader for the contents of an entry, used to
+	// hash the part that was written before resuming
+	openPrefix func(entry *savior.Entry) (io.ReadCloser, error)
+}
+
+func (ms *manifestSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	ms.m.begin(entry)
+
+	if entry.WriteOffset > 0 {
+		rc, err := ms.openPrefix(entry)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer rc.Close()
+
+		n, err := io.CopyN(ms.m.h, rc, entry.WriteOffset)
+		if err != nil {
+			return nil, errors.Wrapf(err, "hashing the first %d bytes of %s", entry.WriteOffset, entry.CanonicalPath)
+		}
+		ms.m.written = n
+	}
+
+	w, err := ms.Sink.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestEntryWriter{EntryWriter: w, m: ms.m}, nil
+}
+
+func (ms *manifestSink) Symlink(entry *savior.Entry, linkname string) error {
+	err := ms.Sink.Symlink(entry, linkname)
+	if err != nil {
+		return err
+	}
+
+	ms.m.begin(entry)
+	n, _ := io.WriteString(ms.m.h, linkname)
+	ms.m.written = int64(n)
+	return nil
+}
+
+type manifestEntryWriter struct {
+	savior.EntryWriter
+	m *manifest
+}
+
+func (mew *manifestEntryWriter) Write(buf []byte) (int, error) {
+	n, err := mew.EntryWriter.Write(buf)
+	mew.m.h.Write(buf[:n])
+	mew.m.written += int64(n)
+	return n, err
+}
+
+// manifestSaveConsumer records how much of the manifest is
+// valid in each checkpoint, and makes sure it's on disk.
+type manifestSaveConsumer struct {
+	inner savior.SaveConsumer
+	m     *manifest
+}
+
+var _ savior.SaveConsumer = (*manifestSaveConsumer)(nil)
+
+func (msc *manifestSaveConsumer) ShouldSave(copiedBytes int64) bool {
+	return msc.inner.ShouldSave(copiedBytes)
+}
+
+func (msc *manifestSaveConsumer) Save(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+	err := msc.m.sync()
+	if err != nil {
+		return savior.AfterSaveStop, errors.WithStack(err)
+	}
+
+	state, ok := checkpoint.Data.(*ZipExtractorState)
+	if !ok {
+		state = &ZipExtractorState{}
+		checkpoint.Data = state
+	}
+	state.Manifest = true
+	state.ManifestSize = msc.m.size
+
+	return msc.inner.Save(checkpoint)
+}
@@ -0,0 +1,105 @@
+package zipextractor_test
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []testZipEntry{
+		{Name: "data/"},
+		{Name: "data/big.bin", Data: semirandom.Bytes(3 * 1024 * 1024), Method: zip.Deflate},
+		{Name: "data/stored.bin", Data: semirandom.Bytes(1024 * 1024), Method: zip.Store},
+		{Name: "data/empty.txt"},
+		{Name: "link", Data: []byte("data/stored.bin"), Mode: os.ModeSymlink | 0755},
+		{Name: "readme.txt", Data: []byte("hello"), Mode: 0600},
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	// compute what the manifest should look like, independently
+	var expected []string
+	for _, e := range entries {
+		entry := newTestZipExtractor(t, makeTestZip(t, []testZipEntry{e})).Entries()[0]
+		sum := "-"
+		if entry.Kind != savior.EntryKindDir {
+			h := sha256.Sum256(e.Data)
+			sum = hex.EncodeToString(h[:])
+		}
+		expected = append(expected, fmt.Sprintf("%s %d %s %s", sum, len(e.Data), entry.Mode, e.Name))
+	}
+
+	tmpDir, err := ioutil.TempDir("", "zipextractor-manifest")
+	must(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, withResumes := range []bool{false, true} {
+		dest := filepath.Join(tmpDir, fmt.Sprintf("dest-%v", withResumes))
+		manifestPath := filepath.Join(tmpDir, fmt.Sprintf("MANIFEST-%v", withResumes))
+
+		sink := &savior.FolderSink{
+			Directory: dest,
+			Consumer:  savior.NopConsumer(),
+		}
+
+		var c *savior.ExtractorCheckpoint
+		numResumes := 0
+		midEntry := false
+		for {
+			ex := newTestZipExtractor(t, zipBytes)
+			ex.WriteManifest(manifestPath, crypto.SHA256)
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				if !withResumes {
+					return savior.AfterSaveContinue, nil
+				}
+				if checkpoint.Entry != nil && checkpoint.Entry.WriteOffset > 0 {
+					midEntry = true
+				}
+				buf, err := savior.MarshalCheckpoint(checkpoint)
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				c, err = savior.UnmarshalCheckpoint(buf)
+				return savior.AfterSaveStop, err
+			}))
+
+			_, err := ex.Resume(c, sink)
+			if err == savior.ErrStop {
+				numResumes++
+				if numResumes > 100 {
+					t.Fatal("too many resumes")
+				}
+
+				_, err := os.Stat(manifestPath)
+				assert.True(os.IsNotExist(err), "manifest should only appear once extraction is done")
+				continue
+			}
+			must(t, err)
+			break
+		}
+		must(t, sink.Close())
+		assert.Equal(withResumes, midEntry, "should resume mid-entry if and only if we stop")
+
+		manifestBytes, err := ioutil.ReadFile(manifestPath)
+		must(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(manifestBytes), "\n"), "\n")
+		assert.EqualValues(expected, lines, "with resumes: %v", withResumes)
+
+		_, err = os.Stat(manifestPath + ".partial")
+		assert.True(os.IsNotExist(err))
+	}
+}
@@ -0,0 +1,83 @@
+package zipextractor
+
+import (
+	"github.com/itchio/arkive/zip"
+	"github.com/pkg/errors"
+)
+
+// MethodPPMd is the compression method of entries compressed with PPMd
+// (variant I, revision 1), as written by 7-Zip and WinZip. No decoder
+// for it is built in: those entries fail with ErrUnsupportedMethod,
+// unless one is registered with RegisterDecompressor.
+const MethodPPMd uint16 = 98
+
+// MethodImplode is the compression method of entries imploded by PKZIP 1.x.
+// A decoder for it is built in, see implodesource: those entries are
+// decompressed again from their start when resuming.
+const MethodImplode uint16 = 6
+
+// methodNames name the methods that aren't built in, in
+// ErrUnsupportedMethod errors: the other methods of PKZIP 1.x, and PPMd
+var methodNames = map[uint16]string{
+	1:          "shrink",
+	2:          "reduce (factor 1)",
+	3:          "reduce (factor 2)",
+	4:          "reduce (factor 3)",
+	5:          "reduce (factor 4)",
+	MethodPPMd: "PPMd",
+}
+
+// ErrUnsupportedMethod is returned when extracting an entry compressed with
+// a method that isn't built in (Store, Deflate, LZMA and implode are) and
+// that no decompressor was registered for. That includes the shrink and
+// reduce methods of PKZIP 1.x (methods 1 to 5).
+var ErrUnsupportedMethod = errors.New("unsupported compression method")
+
+// ErrEncryptedEntry is returned when extracting an entry that's encrypted
+// (general purpose bit 0 is set), with ZipCrypto or WinZip AES: there's
+// no support for decrypting them, and reading them as if they weren't
+// would only fail further down, on corrupted data or a CRC mismatch.
+var ErrEncryptedEntry = errors.New("entry is encrypted")
+
+// flagEncrypted is the general purpose bit set on encrypted entries
+const flagEncrypted = 0x1
+
+// checkEncrypted returns ErrEncryptedEntry for encrypted entries
+func checkEncrypted(zf *zip.File) error {
+	if zf.Flags&flagEncrypted != 0 {
+		return errors.Wrapf(ErrEncryptedEntry, "%s", zf.Name)
+	}
+	return nil
+}
+
+var decompressors = map[uint16]zip.Decompressor{}
+
+// RegisterDecompressor makes entries compressed with the given method
+// extractable (MethodPPMd, for example), by reading them through dcomp.
+// Those entries are decompressed in one go, so they can only be resumed
+// from their start. Store and Deflate are built in and can't be replaced.
+// LZMA and implode are built in too, registering a decompressor for
+// them replaces ours.
+// It's not safe to call concurrently with New.
+func RegisterDecompressor(method uint16, dcomp zip.Decompressor) {
+	decompressors[method] = dcomp
+}
+
+// isRegisteredMethod returns true for methods a decompressor was
+// registered for, with RegisterDecompressor
+func isRegisteredMethod(method uint16) bool {
+	_, ok := decompressors[method]
+	return ok
+}
+
+// unsupportedMethod turns errors about unknown compression methods into
+// ErrUnsupportedMethod, naming the entry and its method
+func unsupportedMethod(err error, zf *zip.File) error {
+	if errors.Cause(err) == zip.ErrAlgorithm {
+		if name, ok := methodNames[zf.Method]; ok {
+			return errors.Wrapf(ErrUnsupportedMethod, "%s: compression method %d (%s)", zf.Name, zf.Method, name)
+		}
+		return errors.Wrapf(ErrUnsupportedMethod, "%s: compression method %d", zf.Name, zf.Method)
+	}
+	return errors.WithStack(err)
+}
@@ -0,0 +1,143 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// methodXor is a made-up compression method, that xors every byte
+const methodXor uint16 = 0xF0F0
+
+type xorReader struct {
+	r io.Reader
+}
+
+func (xr *xorReader) Read(buf []byte) (int, error) {
+	n, err := xr.r.Read(buf)
+	for i := 0; i < n; i++ {
+		buf[i] ^= 0x5A
+	}
+	return n, err
+}
+
+type xorWriter struct {
+	w io.Writer
+}
+
+func (xw *xorWriter) Write(buf []byte) (int, error) {
+	xored := make([]byte, len(buf))
+	for i, b := range buf {
+		xored[i] = b ^ 0x5A
+	}
+	return xw.w.Write(xored)
+}
+
+func (xw *xorWriter) Close() error {
+	return nil
+}
+
+func init() {
+	// so makeTestZip can write entries with those methods. PPMd
+	// entries are stored as-is, since they're never decompressed
+	zip.RegisterCompressor(zipextractor.MethodPPMd, func(s zip.CompressionSettings, w io.Writer) (io.WriteCloser, error) {
+		return nopWriteCloser{w}, nil
+	})
+	zip.RegisterCompressor(methodXor, func(s zip.CompressionSettings, w io.Writer) (io.WriteCloser, error) {
+		return &xorWriter{w}, nil
+	})
+
+	zipextractor.RegisterDecompressor(methodXor, func(r io.Reader, f *zip.File) io.ReadCloser {
+		return ioutil.NopCloser(&xorReader{r})
+	})
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "readme.txt", Data: []byte("read me")},
+		{Name: "ppmd.txt", Data: []byte("not really ppmd"), Method: zipextractor.MethodPPMd},
+	})
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	assert.Error(err)
+	assert.EqualValues(zipextractor.ErrUnsupportedMethod, errors.Cause(err))
+	assert.Contains(err.Error(), "ppmd.txt")
+	assert.Contains(err.Error(), "(PPMd)")
+
+	ex, err = zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	report, err := ex.Scan()
+	must(t, err)
+	assert.EqualValues(1, report.Counts[zipextractor.ScanUnsupportedMethod])
+}
+
+func TestRegisteredMethod(t *testing.T) {
+	assert := assert.New(t)
+
+	data := bytes.Repeat([]byte("xor is not compression "), 1000)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir/xored.txt", Data: data, Method: methodXor},
+		{Name: "dir/stored.txt", Data: []byte("hi")},
+	})
+
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	assert.EqualValues(savior.ResumeSupportEntry, ex.Features().ResumeSupport)
+
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	must(t, err)
+
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "dir", "xored.txt"))
+	must(t, err)
+	assert.EqualValues(data, actual)
+}
+
+func TestEncryptedEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "readme.txt", Data: []byte("read me")},
+		// not actually encrypted, but flagged as such
+		{Name: "secret.txt", Data: []byte("not really encrypted"), Method: zip.Deflate, Flags: 0x1},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	assert.Error(err)
+	assert.EqualValues(zipextractor.ErrEncryptedEntry, errors.Cause(err))
+	assert.Contains(err.Error(), "secret.txt")
+
+	_, err = newTestZipExtractor(t, zipBytes).ReadEntryBytes(1, 1024)
+	assert.EqualValues(zipextractor.ErrEncryptedEntry, errors.Cause(err))
+
+	report, err := newTestZipExtractor(t, zipBytes).Scan()
+	must(t, err)
+	assert.EqualValues(1, report.Counts[zipextractor.ScanEncrypted])
+	assert.EqualValues("encrypted", zipextractor.ScanEncrypted.String())
+
+	// other entries are still extracted when skipping failed ones
+	ex = newTestZipExtractor(t, zipBytes)
+	ex.SetErrorPolicy(zipextractor.PolicyContinueOnEntryError)
+	sink := &orderSink{}
+	_, err = ex.Resume(nil, sink)
+	assert.Error(err)
+	assert.EqualValues([]string{"readme.txt"}, sink.order)
+}
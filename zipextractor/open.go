@@ -0,0 +1,225 @@
+package zipextractor
+
+import (
+	"bytes"
+	"io"
+	"math"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/flatesource"
+	"github.com/itchio/savior/implodesource"
+	"github.com/itchio/savior/lzmasource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/pkg/errors"
+)
+
+var _ savior.EntryOpener = (*ZipExtractor)(nil)
+
+// ErrEntryTooLarge is returned by ReadEntryBytes when an entry
+// decompresses to more than the maximum size
+var ErrEntryTooLarge = errors.New("zipextractor: entry is too large")
+
+// ErrNotAFile is returned by ExtractEntry for directories and symlinks
+var ErrNotAFile = errors.New("zipextractor: entry isn't a file")
+
+// OpenEntry returns a reader for the decompressed contents of an entry,
+// looked up by its CanonicalPath. If the archive lists the same path
+// more than once, the last one wins, as it would when extracting.
+// Delta entries are applied against the base set with SetBase.
+func (ze *ZipExtractor) OpenEntry(entry *savior.Entry) (io.ReadCloser, error) {
+	index := ze.findEntry(entry.CanonicalPath)
+	if index < 0 {
+		return nil, errors.Wrapf(savior.ErrEntryNotFound, "%s", entry.CanonicalPath)
+	}
+
+	found := ze.entryAt(index)
+	if found.Kind == savior.EntryKindDir {
+		return nil, errors.Errorf("zipextractor: %s is a directory", found.CanonicalPath)
+	}
+	return ze.openFile(ze.zr.File[index], found)
+}
+
+// ExtractEntry decompresses a single file entry, looked up by canonical
+// path like OpenEntry, straight to `w`, without a sink or checkpoints, to
+// preview a file without extracting the whole archive. It returns
+// savior.ErrEntryNotFound if there's no such entry, ErrNotAFile if it's a
+// directory or a symlink, and ErrCRCMismatch if what was written to `w`
+// doesn't match the archive's checksum.
+func (ze *ZipExtractor) ExtractEntry(canonicalPath string, w io.Writer) error {
+	index := ze.findEntry(canonicalPath)
+	if index < 0 {
+		return errors.Wrapf(savior.ErrEntryNotFound, "%s", canonicalPath)
+	}
+
+	zf := ze.zr.File[index]
+	entry := ze.entryAt(index)
+	if entry.Kind != savior.EntryKindFile {
+		return errors.Wrapf(ErrNotAFile, "%s is a %s", entry.CanonicalPath, entry.Kind)
+	}
+
+	rc, err := ze.openFile(zf, entry)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		return errors.Wrapf(err, "extracting %s", entry.CanonicalPath)
+	}
+	return nil
+}
+
+// findEntry returns the index of the entry with the given canonical path,
+// the last one if there's more than one, or -1 if there's none
+func (ze *ZipExtractor) findEntry(canonicalPath string) int64 {
+	index := int64(-1)
+	for i, zf := range ze.zr.File {
+		if zipFileEntry(zf).CanonicalPath == canonicalPath {
+			index = int64(i)
+		}
+	}
+	return index
+}
+
+// ReadEntryBytes decompresses the entry at `index` (in the order of Entries())
+// into memory. It returns ErrEntryTooLarge if it's larger than `maxSize` bytes:
+// the declared size is checked first, but the decompressed output is what
+// counts, so lying archives can't make it allocate more than that.
+func (ze *ZipExtractor) ReadEntryBytes(index int, maxSize int64) ([]byte, error) {
+	if index < 0 || index >= len(ze.zr.File) {
+		return nil, errors.Wrapf(savior.ErrEntryNotFound, "index %d", index)
+	}
+
+	entry := ze.entryAt(int64(index))
+	if entry.Kind == savior.EntryKindDir {
+		return nil, errors.Errorf("zipextractor: %s is a directory", entry.CanonicalPath)
+	}
+	if entry.UncompressedSize > maxSize {
+		return nil, errors.Wrapf(ErrEntryTooLarge, "%s: %d bytes (max %d)", entry.CanonicalPath, entry.UncompressedSize, maxSize)
+	}
+
+	rc, err := ze.openFile(ze.zr.File[index], entry)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// the declared size is only a claim, so the buffer grows as needed
+	buf := new(bytes.Buffer)
+	limit := maxSize
+	if limit < math.MaxInt64 {
+		// one more byte, to tell entries that are too large
+		limit++
+	}
+	_, err = io.Copy(buf, io.LimitReader(rc, limit))
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", entry.CanonicalPath)
+	}
+	if int64(buf.Len()) > maxSize {
+		return nil, errors.Wrapf(ErrEntryTooLarge, "%s: more than %d bytes", entry.CanonicalPath, maxSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// entrySource returns a resumable source for the contents of zf, or
+// nil if its compression method doesn't support resuming.
+func (ze *ZipExtractor) entrySource(zf *zip.File) (savior.Source, error) {
+	err := checkEncrypted(zf)
+	if err != nil {
+		return nil, err
+	}
+
+	switch zf.Method {
+	case zip.Store, zip.Deflate:
+		dataOff, err := zf.DataOffset()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		compressedSize := int64(zf.CompressedSize64)
+
+		reader := io.NewSectionReader(ze.reader, dataOff, compressedSize)
+		rawSource := seeksource.NewWithSize(reader, compressedSize)
+
+		if zf.Method == zip.Deflate {
+			return flatesource.New(rawSource), nil
+		}
+		return rawSource, nil
+	case zip.LZMA:
+		if isRegisteredMethod(zf.Method) {
+			// the registered decompressor replaces ours
+			return nil, nil
+		}
+
+		dataOff, err := zf.DataOffset()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		compressedSize := int64(zf.CompressedSize64)
+		reader := io.NewSectionReader(ze.reader, dataOff, compressedSize)
+		return lzmasource.NewZip(seeksource.NewWithSize(reader, compressedSize), int64(zf.UncompressedSize64)), nil
+	case MethodImplode:
+		if isRegisteredMethod(zf.Method) {
+			// the registered decompressor replaces ours
+			return nil, nil
+		}
+
+		dataOff, err := zf.DataOffset()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		compressedSize := int64(zf.CompressedSize64)
+		reader := io.NewSectionReader(ze.reader, dataOff, compressedSize)
+		return implodesource.New(seeksource.NewWithSize(reader, compressedSize), int64(zf.UncompressedSize64), zf.Flags), nil
+	default:
+		return nil, nil
+	}
+}
+
+// openFile returns a reader for the contents of zf, for any compression
+// method, including delta entries. Other entries are checked against
+// the central directory, see openChecked.
+func (ze *ZipExtractor) openFile(zf *zip.File, entry *savior.Entry) (io.ReadCloser, error) {
+	if !entry.IsDelta {
+		return ze.openChecked(zf)
+	}
+
+	err := checkEncrypted(zf)
+	if err != nil {
+		return nil, err
+	}
+	if ze.base == nil {
+		return nil, errors.Wrapf(savior.ErrNoBase, "%s", entry.CanonicalPath)
+	}
+
+	dataOff, err := zf.DataOffset()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	patch := io.NewSectionReader(ze.reader, dataOff, int64(zf.CompressedSize64))
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := deltaMethods[zf.Method](ze.base, entry, patch, &pipeEntryWriter{pw})
+		if err != nil {
+			err = errors.Wrapf(err, "applying delta for %s", entry.CanonicalPath)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// pipeEntryWriter lets delta functions write to an io.Pipe
+type pipeEntryWriter struct {
+	*io.PipeWriter
+}
+
+var _ savior.EntryWriter = (*pipeEntryWriter)(nil)
+
+func (pew *pipeEntryWriter) Sync() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	baseDir, err := ioutil.TempDir("", "zipextractor-base")
+	must(t, err)
+	defer os.RemoveAll(baseDir)
+	must(t, ioutil.WriteFile(filepath.Join(baseDir, "old.txt"), []byte("from the base"), 0644))
+
+	deflated := bytes.Repeat([]byte("compress me please "), 1024)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir/stored.txt", Data: []byte("stored as-is"), Method: zip.Store},
+		{Name: "dir/deflated.txt", Data: deflated, Method: zip.Deflate},
+		{Name: "link", Data: []byte("dir/stored.txt"), Mode: os.ModeSymlink | 0644},
+		{Name: "delta.txt", Data: []byte("old.txt"), Method: zipextractor.MethodCopyFromBase},
+		{Name: "dir/stored.txt", Data: []byte("stored twice"), Method: zip.Store},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetBase(&savior.FolderBase{Directory: baseDir})
+
+	readEntry := func(path string) ([]byte, error) {
+		rc, err := ex.OpenEntry(&savior.Entry{CanonicalPath: path})
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	bs, err := readEntry("dir/deflated.txt")
+	must(t, err)
+	assert.EqualValues(deflated, bs)
+
+	bs, err = readEntry("dir/stored.txt")
+	must(t, err)
+	assert.EqualValues("stored twice", string(bs), "last entry with a given path wins")
+
+	bs, err = readEntry("link")
+	must(t, err)
+	assert.EqualValues("dir/stored.txt", string(bs))
+
+	bs, err = readEntry("delta.txt")
+	must(t, err)
+	assert.EqualValues("from the base", string(bs))
+
+	_, err = readEntry("missing.txt")
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrEntryNotFound)
+
+	_, err = readEntry("dir/")
+	assert.Error(err)
+
+	// entries returned by Entries() work too
+	for _, entry := range ex.Entries() {
+		if entry.Kind != savior.EntryKindFile {
+			continue
+		}
+		rc, err := ex.OpenEntry(entry)
+		must(t, err)
+		_, err = ioutil.ReadAll(rc)
+		assert.NoError(err)
+		assert.NoError(rc.Close())
+	}
+}
+
+func TestExtractEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	baseDir, err := ioutil.TempDir("", "zipextractor-base")
+	must(t, err)
+	defer os.RemoveAll(baseDir)
+	must(t, ioutil.WriteFile(filepath.Join(baseDir, "old.txt"), []byte("from the base"), 0644))
+
+	deflated := bytes.Repeat([]byte("compress me please "), 64*1024)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir/stored.txt", Data: []byte("stored as-is"), Method: zip.Store},
+		{Name: "dir/deflated.txt", Data: deflated, Method: zip.Deflate},
+		{Name: "link", Data: []byte("dir/stored.txt"), Mode: os.ModeSymlink | 0644},
+		{Name: "delta.txt", Data: []byte("old.txt"), Method: zipextractor.MethodCopyFromBase},
+		{Name: "dir/stored.txt", Data: []byte("stored twice"), Method: zip.Store},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetBase(&savior.FolderBase{Directory: baseDir})
+
+	extract := func(path string) ([]byte, error) {
+		buf := new(bytes.Buffer)
+		err := ex.ExtractEntry(path, buf)
+		return buf.Bytes(), err
+	}
+
+	bs, err := extract("dir/deflated.txt")
+	must(t, err)
+	assert.True(bytes.Equal(deflated, bs))
+
+	bs, err = extract("dir/stored.txt")
+	must(t, err)
+	assert.EqualValues("stored twice", string(bs), "last entry with a given path wins")
+
+	bs, err = extract("delta.txt")
+	must(t, err)
+	assert.EqualValues("from the base", string(bs))
+
+	_, err = extract("missing.txt")
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrEntryNotFound)
+
+	for _, path := range []string{"dir/", "link"} {
+		_, err = extract(path)
+		assert.Error(err)
+		assert.True(errors.Cause(err) == zipextractor.ErrNotAFile, "%s isn't a file", path)
+	}
+
+	// corrupted contents are caught
+	corrupted := append([]byte(nil), zipBytes...)
+	i := bytes.Index(corrupted, []byte("stored twice"))
+	corrupted[i] = 'S'
+	ex = newTestZipExtractor(t, corrupted)
+	err = ex.ExtractEntry("dir/stored.txt", ioutil.Discard)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == zipextractor.ErrCRCMismatch)
+}
+
+func TestReadEntryBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	baseDir, err := ioutil.TempDir("", "zipextractor-base")
+	must(t, err)
+	defer os.RemoveAll(baseDir)
+	must(t, ioutil.WriteFile(filepath.Join(baseDir, "big.bin"), bytes.Repeat([]byte{0x42}, 4096), 0644))
+
+	deflated := bytes.Repeat([]byte("compress me please "), 1024)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "config.ini", Data: []byte("[settings]\nfoo=bar\n"), Method: zip.Deflate},
+		{Name: "large.txt", Data: deflated, Method: zip.Deflate},
+		{Name: "dir/"},
+		// the declared size is that of the patch, not of what it decompresses to
+		{Name: "delta.bin", Data: []byte("big.bin"), Method: zipextractor.MethodCopyFromBase},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetBase(&savior.FolderBase{Directory: baseDir})
+
+	bs, err := ex.ReadEntryBytes(0, 1024)
+	must(t, err)
+	assert.EqualValues("[settings]\nfoo=bar\n", string(bs))
+
+	_, err = ex.ReadEntryBytes(1, 1024)
+	assert.Equal(zipextractor.ErrEntryTooLarge, errors.Cause(err))
+
+	bs, err = ex.ReadEntryBytes(1, int64(len(deflated)))
+	must(t, err)
+	assert.EqualValues(deflated, bs)
+
+	_, err = ex.ReadEntryBytes(2, 1024)
+	assert.Error(err)
+
+	_, err = ex.ReadEntryBytes(3, 1024)
+	assert.Equal(zipextractor.ErrEntryTooLarge, errors.Cause(err), "should check the actual output")
+
+	_, err = ex.ReadEntryBytes(4, 1024)
+	assert.Equal(savior.ErrEntryNotFound, errors.Cause(err))
+}
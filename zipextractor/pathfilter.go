@@ -0,0 +1,225 @@
+package zipextractor
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// pathPattern is a single gitignore-style pattern, compiled
+type pathPattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// pathFilter excludes entries matching gitignore-style patterns
+type pathFilter struct {
+	// as passed to SetPathFilterPatterns, saved in checkpoints
+	patterns []string
+	compiled []*pathPattern
+}
+
+// SetPathFilterPatterns skips entries that match the given patterns, which
+// follow .gitignore semantics:
+//
+//   - blank lines and lines starting with `#` are ignored
+//   - `*` and `?` match anything but a slash, `[a-z]` matches a character class
+//   - `**/` matches in any directory, `/**` everything inside, `/**/` zero or
+//     more directories
+//   - a pattern with a slash at the start or in the middle is relative to the
+//     root of the archive, otherwise it matches at any depth
+//   - a trailing slash only matches directories
+//   - a leading `!` re-includes entries excluded by previous patterns (the
+//     last pattern that matches wins), except for entries under an excluded
+//     directory, which can't be re-included
+//   - `\` escapes the next character, like a leading `#` or `!`
+//
+// The patterns are saved in checkpoints, so that resumed extractions stick
+// to them. Passing nil lifts the filter.
+func (ze *ZipExtractor) SetPathFilterPatterns(patterns []string) error {
+	if patterns == nil {
+		ze.pathFilter = nil
+		return nil
+	}
+
+	pf, err := compilePathFilter(patterns)
+	if err != nil {
+		return err
+	}
+	ze.pathFilter = pf
+	return nil
+}
+
+// ReadPathFilterPatterns reads patterns from a .gitignore-style file,
+// one per line, for SetPathFilterPatterns
+func ReadPathFilterPatterns(r io.Reader) ([]string, error) {
+	patterns := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		patterns = append(patterns, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return patterns, nil
+}
+
+func compilePathFilter(patterns []string) (*pathFilter, error) {
+	pf := &pathFilter{
+		patterns: patterns,
+	}
+	for _, line := range patterns {
+		pp, err := compilePathPattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if pp != nil {
+			pf.compiled = append(pf.compiled, pp)
+		}
+	}
+	return pf, nil
+}
+
+// compilePathPattern turns a line of a .gitignore into a regular
+// expression. It returns nil for blank lines and comments.
+func compilePathPattern(line string) (*pathPattern, error) {
+	p := trimTrailingSpaces(line)
+	if p == "" || strings.HasPrefix(p, "#") {
+		return nil, nil
+	}
+
+	pp := &pathPattern{}
+	if strings.HasPrefix(p, "!") {
+		pp.negate = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		pp.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if p == "" {
+		return nil, nil
+	}
+
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !anchored {
+		sb.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '*' && strings.HasPrefix(p[i:], "**") && (i == 0 || p[i-1] == '/') && (i+2 == len(p) || p[i+2] == '/'):
+			switch {
+			case i+2 == len(p):
+				// `/**` at the end: everything inside
+				sb.WriteString(".*")
+			default:
+				// `**/` at the start, or `/**/`: zero or more directories
+				sb.WriteString("(?:.*/)?")
+				i++
+			}
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			// a `]` right after the opening bracket (or `!`) is part of the class
+			j := i + 1
+			if j < len(p) && p[j] == '!' {
+				j++
+			}
+			if j < len(p) && p[j] == ']' {
+				j++
+			}
+			end := strings.IndexByte(p[j:], ']')
+			if end == -1 {
+				return nil, errors.Errorf("zipextractor: invalid pattern %q: unterminated character class", line)
+			}
+			class := p[i+1 : j+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[")
+			sb.WriteString(strings.Replace(class, `\`, `\\`, -1))
+			sb.WriteString("]")
+			i = j + end
+		case c == '\\' && i+1 < len(p):
+			i++
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "zipextractor: invalid pattern %q", line)
+	}
+	pp.re = re
+	return pp, nil
+}
+
+// trimTrailingSpaces removes trailing spaces, unless they're escaped
+func trimTrailingSpaces(s string) string {
+	for strings.HasSuffix(s, " ") && !strings.HasSuffix(s, `\ `) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// excluded returns true if the entry is excluded by the patterns,
+// itself or through one of its parent directories
+func (pf *pathFilter) excluded(entry *savior.Entry) bool {
+	p := strings.Trim(entry.CanonicalPath, "/")
+	parts := strings.Split(p, "/")
+	for i := 1; i < len(parts); i++ {
+		if pf.matches(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return pf.matches(p, entry.Kind == savior.EntryKindDir)
+}
+
+// matches returns the decision of the last pattern that matches `p`
+func (pf *pathFilter) matches(p string, isDir bool) bool {
+	excluded := false
+	for _, pp := range pf.compiled {
+		if pp.dirOnly && !isDir {
+			continue
+		}
+		if pp.re.MatchString(p) {
+			excluded = !pp.negate
+		}
+	}
+	return excluded
+}
+
+// resumePathFilter sticks to the patterns the checkpoint was taken with
+func (ze *ZipExtractor) resumePathFilter(checkpoint *savior.ExtractorCheckpoint, isFresh bool) error {
+	if state, ok := checkpoint.Data.(*ZipExtractorState); ok && state.PathFilter {
+		pf, err := compilePathFilter(state.PathFilterPatterns)
+		if err != nil {
+			return err
+		}
+		ze.pathFilter = pf
+		return nil
+	}
+
+	if ze.pathFilter != nil && !isFresh {
+		return errors.New("zipextractor: can't filter paths, checkpoint was taken without patterns")
+	}
+	return nil
+}
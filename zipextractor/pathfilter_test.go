@@ -0,0 +1,159 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPatterns = `# docs are built elsewhere
+*.md
+!README.md
+
+# nothing in build/ can be re-included
+build/
+!build/keep.txt
+
+*_test.go
+/src/vendor/
+logs/*.log
+!logs/important.log
+**/tmp/
+assets/**/*.psd
+\#notes
+cache/**
+`
+
+var testPatternEntries = []string{
+	"README.md",
+	"CHANGELOG.md",
+	"docs/README.md",
+	"docs/guide.md",
+	"build/out.bin",
+	"build/keep.txt",
+	"src/main.go",
+	"src/main_test.go",
+	"src/vendor/lib.go",
+	"src/pkg/vendor/lib.go",
+	"logs/a.log",
+	"logs/important.log",
+	"logs/nested/b.log",
+	"tmp/x",
+	"src/tmp/y",
+	"tmp.txt",
+	"assets/logo.psd",
+	"assets/icons/small/logo.psd",
+	"assets/logo.png",
+	"#notes",
+	"cache/a/b",
+	"cache.txt",
+}
+
+func TestPathFilterPatterns(t *testing.T) {
+	assert := assert.New(t)
+
+	var entries []testZipEntry
+	for _, name := range testPatternEntries {
+		entries = append(entries, testZipEntry{Name: name, Data: []byte(name)})
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	patterns, err := zipextractor.ReadPathFilterPatterns(strings.NewReader(testPatterns))
+	must(t, err)
+
+	ex := newTestZipExtractor(t, zipBytes)
+	must(t, ex.SetPathFilterPatterns(patterns))
+	sink := &recordingSink{}
+	_, err = ex.Resume(nil, sink)
+	must(t, err)
+
+	sort.Strings(sink.files)
+	assert.EqualValues([]string{
+		"README.md",
+		"assets/logo.png",
+		"cache.txt",
+		"docs/README.md",
+		"logs/important.log",
+		"logs/nested/b.log",
+		"src/main.go",
+		"src/pkg/vendor/lib.go",
+		"tmp.txt",
+	}, sink.files)
+
+	ex = newTestZipExtractor(t, zipBytes)
+	assert.Error(ex.SetPathFilterPatterns([]string{"[oops"}))
+}
+
+func TestPathFilterPatternsResume(t *testing.T) {
+	assert := assert.New(t)
+
+	var entries []testZipEntry
+	var expected []string
+	for i, name := range []string{"a.bin", "b.txt", "c.bin", "d.txt", "e.bin"} {
+		data := bytes.Repeat([]byte{byte(i)}, 300*1024)
+		entries = append(entries, testZipEntry{Name: name, Data: data})
+		if strings.HasSuffix(name, ".txt") {
+			expected = append(expected, name)
+		}
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	var c *savior.ExtractorCheckpoint
+	sc := checker.NewTestSaveConsumer(128*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		if c != nil && checkpoint.Progress <= c.Progress {
+			return savior.AfterSaveContinue, nil
+		}
+		buf, err := savior.MarshalCheckpoint(checkpoint)
+		if err != nil {
+			return savior.AfterSaveContinue, err
+		}
+		c, err = savior.UnmarshalCheckpoint(buf)
+		return savior.AfterSaveStop, err
+	})
+
+	sink := savior.NewMemorySink()
+	numResumes := 0
+	for {
+		if numResumes > 64 {
+			t.Fatal("too many resumes, something must be wrong")
+		}
+
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetSaveConsumer(sc)
+		if c == nil {
+			must(t, ex.SetPathFilterPatterns([]string{"*", "!*.txt"}))
+		} else {
+			// resumes stick to the patterns extraction started with
+			must(t, ex.SetPathFilterPatterns([]string{"*.txt"}))
+		}
+
+		_, err := ex.Resume(c, sink)
+		if errors.Cause(err) == savior.ErrStop {
+			numResumes++
+			continue
+		}
+		must(t, err)
+		break
+	}
+	assert.True(numResumes > 0)
+	assert.EqualValues(expected, sink.Paths())
+
+	// checkpoints taken without patterns can't be resumed with some
+	ex := newTestZipExtractor(t, zipBytes)
+	c = nil
+	ex.SetSaveConsumer(sc)
+	_, err := ex.Resume(nil, savior.NewMemorySink())
+	assert.EqualValues(savior.ErrStop, errors.Cause(err))
+
+	ex = newTestZipExtractor(t, zipBytes)
+	must(t, ex.SetPathFilterPatterns([]string{"*.bin"}))
+	_, err = ex.Resume(c, savior.NewMemorySink())
+	assert.Error(err)
+}
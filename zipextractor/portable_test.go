@@ -0,0 +1,126 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortableCheckpoint(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(10)
+	zipBytes := checker.MakeZip(t, sink)
+
+	dirA, err := ioutil.TempDir("", "portable-a")
+	must(t, err)
+	defer os.RemoveAll(dirA)
+
+	dirB, err := ioutil.TempDir("", "portable-b")
+	must(t, err)
+	defer os.RemoveAll(dirB)
+
+	// first machine: extract until the first checkpoint, then stop
+	var saved []byte
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	ex.SetSaveConsumer(checker.NewTestSaveConsumer(512*1024, func(c *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+		saved, err = savior.MarshalCheckpoint(c)
+		if err != nil {
+			return savior.AfterSaveContinue, err
+		}
+		return savior.AfterSaveStop, nil
+	}))
+
+	fsA := &savior.FolderSink{Directory: dirA, Consumer: savior.NopConsumer()}
+	_, err = ex.Resume(nil, fsA)
+	assert.Equal(t, savior.ErrStop, err)
+	must(t, fsA.Close())
+	assert.NotEmpty(t, saved)
+
+	// the shared output volume is mounted elsewhere on the second machine
+	must(t, copyTree(dirA, dirB))
+
+	c, err := savior.UnmarshalCheckpoint(saved)
+	must(t, err)
+
+	ex, err = zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	fsB := &savior.FolderSink{Directory: dirB, Consumer: savior.NopConsumer()}
+	_, err = ex.Resume(c, fsB)
+	must(t, err)
+	must(t, fsB.Close())
+
+	for _, item := range sink.Items {
+		if item.Entry.Kind != savior.EntryKindFile {
+			continue
+		}
+		actual, err := ioutil.ReadFile(filepath.Join(dirB, item.Entry.CanonicalPath))
+		must(t, err)
+		assert.True(t, bytes.Equal(item.Data, actual), "%s should have the right contents", item.Entry.CanonicalPath)
+	}
+}
+
+func TestNonPortableState(t *testing.T) {
+	states := map[string]*zipextractor.ZipExtractorState{
+		"negative pending entry": {PendingEntries: []int64{3, -1}},
+		"negative order index":   {Order: []int64{0, -2, 1}},
+		"duplicate order index":  {Order: []int64{0, 1, 1}},
+		"negative failed entry":  {Failed: []int64{-1}},
+		"negative manifest size": {Manifest: true, ManifestSize: -5},
+	}
+	for name, state := range states {
+		c := &savior.ExtractorCheckpoint{Data: state}
+		_, err := savior.MarshalCheckpoint(c)
+		assert.Equal(t, savior.ErrNonPortableCheckpoint, errors.Cause(err), name)
+	}
+
+	c := &savior.ExtractorCheckpoint{
+		Data: &zipextractor.ZipExtractorState{
+			PendingEntries: []int64{4},
+			Order:          []int64{2, 0, 1},
+		},
+	}
+	_, err := savior.MarshalCheckpoint(c)
+	must(t, err)
+}
+
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+
+		r, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		w, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+
+		_, err = io.Copy(w, r)
+		return err
+	})
+}
@@ -0,0 +1,51 @@
+package zipextractor
+
+import (
+	"sort"
+
+	"github.com/itchio/savior"
+)
+
+// prefetch tells the reader passed to New, if it's a savior.Prefetcher,
+// which parts of the archive we're about to read: every selected entry
+// from position `start` on, in the order they're walked in. An entry
+// spans from its local header to the next one (or the central directory),
+// which also covers data descriptors.
+func (ze *ZipExtractor) prefetch(order []int64, start int64, selected []bool) {
+	if ze.prefetcher == nil || ze.central == nil {
+		return
+	}
+
+	var boundaries []int64
+	for _, record := range ze.central.records {
+		if record.headerOffset >= 0 {
+			boundaries = append(boundaries, record.headerOffset)
+		}
+	}
+	boundaries = append(boundaries, ze.central.start)
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i] < boundaries[j] })
+
+	var ranges []savior.ByteRange
+	for pos := start; pos < int64(len(order)); pos++ {
+		index := order[pos]
+		if !selected[index] || zipFileEntry(ze.zr.File[index]).Kind == savior.EntryKindDir {
+			continue
+		}
+
+		offset := ze.central.records[index].headerOffset
+		if offset < 0 {
+			continue
+		}
+		next := sort.Search(len(boundaries), func(i int) bool { return boundaries[i] > offset })
+		if next == len(boundaries) {
+			continue
+		}
+		ranges = append(ranges, savior.ByteRange{
+			Offset: offset,
+			Size:   boundaries[next] - offset,
+		})
+	}
+
+	savior.Debugf("zipextractor: prefetching %d entries", len(ranges))
+	ze.prefetcher.Prefetch(ranges)
+}
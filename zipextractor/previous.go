@@ -0,0 +1,214 @@
+package zipextractor
+
+import (
+	"bufio"
+	"crypto"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// A Manifest describes the entries of a previous extraction,
+// as written by WriteManifest.
+type Manifest struct {
+	// Algo is the hash algorithm the manifest was written with
+	Algo crypto.Hash
+	// Entries maps canonical paths to what was written there
+	Entries map[string]*ManifestEntry
+}
+
+// A ManifestEntry is a single line of a manifest
+type ManifestEntry struct {
+	// Hash is hex-encoded, "-" for directories
+	Hash string
+	Size int64
+	// Mode is formatted like os.FileMode does
+	Mode string
+}
+
+// ReadManifest parses a manifest written by WriteManifest with `algo`
+func ReadManifest(r io.Reader, algo crypto.Hash) (Manifest, error) {
+	m := Manifest{
+		Algo:    algo,
+		Entries: make(map[string]*ManifestEntry),
+	}
+
+	s := bufio.NewScanner(r)
+	lineNumber := 0
+	for s.Scan() {
+		lineNumber++
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		// paths may contain spaces, so they come last
+		tokens := strings.SplitN(line, " ", 4)
+		if len(tokens) != 4 {
+			return m, errors.Errorf("zipextractor: invalid manifest line %d: %q", lineNumber, line)
+		}
+		size, err := strconv.ParseInt(tokens[1], 10, 64)
+		if err != nil {
+			return m, errors.Wrapf(err, "zipextractor: invalid size on manifest line %d", lineNumber)
+		}
+
+		m.Entries[tokens[3]] = &ManifestEntry{
+			Hash: tokens[0],
+			Size: size,
+			Mode: tokens[2],
+		}
+	}
+	if err := s.Err(); err != nil {
+		return m, errors.WithStack(err)
+	}
+
+	return m, nil
+}
+
+// SetPreviousManifest enables incremental extraction: file entries that
+// have the same path, size, mode and contents as in the previous manifest
+// aren't written, as long as the file on disk still matches the manifest.
+// This only works with sinks that implement savior.PathSink, like
+// savior.FolderSink.
+//
+// Deciding whether an entry changed means decompressing it, so this trades
+// reads (and CPU) for writes. Once extraction is done, Changes() lists
+// what was unchanged, updated, added and removed. Resuming requires a
+// checkpoint taken by an extractor that had a previous manifest.
+func (ze *ZipExtractor) SetPreviousManifest(m Manifest) {
+	ze.previousManifest = &m
+}
+
+// ManifestChanges compares an extraction to a previous manifest,
+// see SetPreviousManifest. Directories aren't listed.
+type ManifestChanges struct {
+	// Unchanged entries were in the previous manifest, and weren't written
+	Unchanged []string
+	// Updated entries were in the previous manifest, and were written again
+	Updated []string
+	// Added entries weren't in the previous manifest
+	Added []string
+	// Removed entries are in the previous manifest, but not in the archive.
+	// They're left alone.
+	Removed []string
+}
+
+// Changes returns how the last successful extraction compares to the
+// previous manifest, or nil if no previous manifest was set.
+func (ze *ZipExtractor) Changes() *ManifestChanges {
+	return ze.changes
+}
+
+// isUnchanged returns true if the entry doesn't need to be written: its
+// contents match the previous manifest, and so does the file on disk.
+// If mf is non-nil, it's fed the entry's contents along the way.
+func (ze *ZipExtractor) isUnchanged(zf *zip.File, entry *savior.Entry, sink savior.Sink, mf *manifest) (bool, error) {
+	pm := ze.previousManifest
+	prev, ok := pm.Entries[entry.CanonicalPath]
+	if !ok || prev.Size != entry.UncompressedSize || prev.Mode != entry.Mode.String() {
+		return false, nil
+	}
+
+	destPath, ok := savior.DestPathOf(sink, entry)
+	if !ok {
+		return false, nil
+	}
+
+	// checking the disk first: it's cheaper than decompressing
+	f, err := os.Open(destPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	diskSum, diskSize, err := hashReader(pm.Algo.New(), f)
+	if err != nil {
+		return false, errors.Wrapf(err, "hashing existing %s", entry.CanonicalPath)
+	}
+	if diskSize != prev.Size || diskSum != prev.Hash {
+		savior.Debugf("%s: modified on disk since the previous extraction", entry.CanonicalPath)
+		return false, nil
+	}
+
+	rc, err := ze.openFile(zf, entry)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer rc.Close()
+
+	h := pm.Algo.New()
+	var w io.Writer = h
+	if mf != nil {
+		mf.begin(entry)
+		w = io.MultiWriter(h, mf.h)
+	}
+	n, err := io.Copy(w, rc)
+	if err != nil {
+		return false, errors.Wrapf(err, "hashing %s", entry.CanonicalPath)
+	}
+	if mf != nil {
+		mf.written = n
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == prev.Hash, nil
+}
+
+func hashReader(h hash.Hash, r io.Reader) (string, int64, error) {
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// computeChanges compares the selected entries to the previous manifest
+func (ze *ZipExtractor) computeChanges(selected []bool, unchanged []int64) *ManifestChanges {
+	changes := &ManifestChanges{}
+
+	isUnchanged := make(map[int64]bool)
+	for _, index := range unchanged {
+		isUnchanged[index] = true
+	}
+
+	seen := make(map[string]bool)
+	for i := range ze.zr.File {
+		if !selected[i] {
+			continue
+		}
+		entry := ze.entryAt(int64(i))
+		seen[entry.CanonicalPath] = true
+		if entry.Kind == savior.EntryKindDir {
+			continue
+		}
+
+		switch {
+		case isUnchanged[int64(i)]:
+			changes.Unchanged = append(changes.Unchanged, entry.CanonicalPath)
+		case ze.previousManifest.Entries[entry.CanonicalPath] != nil:
+			changes.Updated = append(changes.Updated, entry.CanonicalPath)
+		default:
+			changes.Added = append(changes.Added, entry.CanonicalPath)
+		}
+	}
+
+	for path, prev := range ze.previousManifest.Entries {
+		if prev.Hash == "-" || seen[path] {
+			continue
+		}
+		changes.Removed = append(changes.Removed, path)
+	}
+	sort.Strings(changes.Removed)
+
+	return changes
+}
@@ -0,0 +1,171 @@
+package zipextractor_test
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCountingSink remembers which entries it was asked to write
+type writeCountingSink struct {
+	*savior.FolderSink
+	written map[string]bool
+}
+
+func (wcs *writeCountingSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	wcs.written[entry.CanonicalPath] = true
+	return wcs.FolderSink.GetWriter(entry)
+}
+
+func TestPreviousManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "zipextractor-previous")
+	must(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	dest := filepath.Join(tmpDir, "dest")
+	manifestPath := filepath.Join(tmpDir, "MANIFEST")
+
+	same := semirandom.Bytes(2 * 1024 * 1024)
+	tampered := semirandom.Bytes(512 * 1024)
+
+	v1 := makeTestZip(t, []testZipEntry{
+		{Name: "data/"},
+		{Name: "data/same.bin", Data: same, Method: zip.Deflate},
+		{Name: "data/changed.bin", Data: []byte("version 1"), Method: zip.Deflate},
+		{Name: "data/tampered.bin", Data: tampered, Method: zip.Store},
+		{Name: "data/chmod.bin", Data: []byte("mode changes")},
+		{Name: "old.txt", Data: []byte("going away")},
+	})
+	ex := newTestZipExtractor(t, v1)
+	ex.WriteManifest(manifestPath, crypto.SHA256)
+	_, err = ex.Resume(nil, &savior.FolderSink{Directory: dest, Consumer: savior.NopConsumer()})
+	must(t, err)
+	assert.Nil(ex.Changes())
+
+	readPrevious := func() zipextractor.Manifest {
+		f, err := os.Open(manifestPath)
+		must(t, err)
+		defer f.Close()
+		m, err := zipextractor.ReadManifest(f, crypto.SHA256)
+		must(t, err)
+		return m
+	}
+	previous := readPrevious()
+	assert.Len(previous.Entries, 6)
+
+	v2 := makeTestZip(t, []testZipEntry{
+		{Name: "data/"},
+		{Name: "data/same.bin", Data: same, Method: zip.Deflate},
+		{Name: "data/changed.bin", Data: []byte("version 2"), Method: zip.Deflate},
+		{Name: "data/tampered.bin", Data: tampered, Method: zip.Store},
+		{Name: "data/chmod.bin", Data: []byte("mode changes"), Mode: 0755},
+		{Name: "new.txt", Data: []byte("brand new")},
+	})
+
+	for _, withResumes := range []bool{false, true} {
+		// modified after the fact, must be written again
+		must(t, ioutil.WriteFile(filepath.Join(dest, "data", "tampered.bin"), semirandom.Bytes(512*1024 + 1)[1:], 0644))
+
+		sink := &writeCountingSink{
+			FolderSink: &savior.FolderSink{Directory: dest, Consumer: savior.NopConsumer()},
+			written:    make(map[string]bool),
+		}
+
+		var c *savior.ExtractorCheckpoint
+		var changes *zipextractor.ManifestChanges
+		numResumes := 0
+		for {
+			ex := newTestZipExtractor(t, v2)
+			ex.SetPreviousManifest(previous)
+			ex.WriteManifest(manifestPath+".v2", crypto.SHA256)
+			ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+				if !withResumes {
+					return savior.AfterSaveContinue, nil
+				}
+				buf, err := savior.MarshalCheckpoint(checkpoint)
+				if err != nil {
+					return savior.AfterSaveStop, err
+				}
+				c, err = savior.UnmarshalCheckpoint(buf)
+				return savior.AfterSaveStop, err
+			}))
+
+			_, err := ex.Resume(c, sink)
+			if err == savior.ErrStop {
+				numResumes++
+				if numResumes > 100 {
+					t.Fatal("too many resumes")
+				}
+				continue
+			}
+			must(t, err)
+			changes = ex.Changes()
+			break
+		}
+		must(t, sink.Close())
+		assert.Equal(withResumes, numResumes > 0)
+
+		assert.False(sink.written["data/same.bin"], "unchanged entry shouldn't be written")
+		assert.True(sink.written["data/changed.bin"])
+		assert.True(sink.written["data/tampered.bin"])
+		assert.True(sink.written["data/chmod.bin"])
+		assert.True(sink.written["new.txt"])
+
+		if assert.NotNil(changes) {
+			assert.EqualValues([]string{"data/same.bin"}, changes.Unchanged)
+			assert.EqualValues([]string{"data/changed.bin", "data/tampered.bin", "data/chmod.bin"}, changes.Updated)
+			assert.EqualValues([]string{"new.txt"}, changes.Added)
+			assert.EqualValues([]string{"old.txt"}, changes.Removed)
+		}
+
+		for name, data := range map[string][]byte{
+			"data/same.bin":     same,
+			"data/changed.bin":  []byte("version 2"),
+			"data/tampered.bin": tampered,
+		} {
+			actual, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+			must(t, err)
+			assert.True(len(actual) == len(data) && string(actual) == string(data), "%s should have the new contents", name)
+		}
+
+		// unchanged entries still make it to the new manifest
+		v2Manifest, err := os.Open(manifestPath + ".v2")
+		must(t, err)
+		m, err := zipextractor.ReadManifest(v2Manifest, crypto.SHA256)
+		v2Manifest.Close()
+		must(t, err)
+		assert.Equal(previous.Entries["data/same.bin"], m.Entries["data/same.bin"])
+
+		// second round: everything written by v2 is now up-to-date
+		previous2 := m
+		ex := newTestZipExtractor(t, v2)
+		ex.SetPreviousManifest(previous2)
+		sink.written = make(map[string]bool)
+		_, err = ex.Resume(nil, sink)
+		must(t, err)
+		assert.Empty(sink.written)
+		assert.Len(ex.Changes().Unchanged, 5)
+	}
+}
+
+func TestPreviousManifestCheckpointMismatch(t *testing.T) {
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "a.bin", Data: semirandom.Bytes(1024 * 1024), Method: zip.Deflate},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetPreviousManifest(zipextractor.Manifest{Algo: crypto.SHA256})
+	_, err := ex.Resume(&savior.ExtractorCheckpoint{}, checker.NewSink())
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package zipextractor_test
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/itchio/headway/state"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/stretchr/testify/assert"
+)
+
+func progressConsumer(values *[]float64) *state.Consumer {
+	consumer := savior.NopConsumer()
+	consumer.OnProgress = func(progress float64) {
+		*values = append(*values, progress)
+	}
+	return consumer
+}
+
+func TestProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSinkAdvanced(10)
+	zipBytes := checker.MakeZip(t, sink)
+
+	var c *savior.ExtractorCheckpoint
+	// Resume updates the checkpoint it's given, keep this aside
+	var stoppedAt float64
+	var runs [][]float64
+	for {
+		var values []float64
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetConsumer(progressConsumer(&values))
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if c != nil || checkpoint.Progress == 0 {
+				// only stop once, somewhere in the middle
+				return savior.AfterSaveContinue, nil
+			}
+			bs, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(bs)
+			stoppedAt = checkpoint.Progress
+			return savior.AfterSaveStop, err
+		}))
+
+		_, err := ex.Resume(c, sink)
+		runs = append(runs, values)
+		if err == savior.ErrStop {
+			continue
+		}
+		must(t, err)
+		break
+	}
+	must(t, sink.Validate())
+
+	if !assert.Len(runs, 2) {
+		return
+	}
+	resumed := runs[1]
+	if !assert.NotEmpty(resumed) {
+		return
+	}
+
+	// picks up where the first run left off
+	assert.InDelta(stoppedAt, resumed[0], 1e-9)
+	assert.True(resumed[0] > 0)
+	for i := 1; i < len(resumed); i++ {
+		assert.True(resumed[i] >= resumed[i-1], "progress shouldn't go backwards (%f then %f)", resumed[i-1], resumed[i])
+	}
+	assert.EqualValues(1, resumed[len(resumed)-1])
+}
+
+func TestProgressNoBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir/empty.txt"},
+	})
+
+	var values []float64
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetConsumer(progressConsumer(&values))
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	must(t, err)
+
+	if assert.NotEmpty(values) {
+		for _, v := range values {
+			assert.False(math.IsNaN(v))
+		}
+		assert.EqualValues(1, values[len(values)-1])
+	}
+}
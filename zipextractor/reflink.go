@@ -0,0 +1,69 @@
+package zipextractor
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// reflink writes the entry as a reflink of an identical file, if the sink
+// can (see savior.ReflinkSink). Files are compared by size and CRC-32, which
+// the archive records for every entry, so nothing gets decompressed. If mf
+// is non-nil, it's fed the file's contents along the way.
+func (ze *ZipExtractor) reflink(zf *zip.File, entry *savior.Entry, sink savior.Sink, mf *manifest) (bool, error) {
+	rs, ok := sink.(savior.ReflinkSink)
+	if !ok || entry.UncompressedSize == 0 {
+		return false, nil
+	}
+
+	source, ok := rs.ReflinkSource(entry)
+	if !ok {
+		return false, nil
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	stats, err := f.Stat()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if stats.Size() != entry.UncompressedSize {
+		return false, nil
+	}
+
+	h := crc32.NewIEEE()
+	var w io.Writer = h
+	if mf != nil {
+		mf.begin(entry)
+		w = io.MultiWriter(h, mf.h)
+	}
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return false, errors.Wrapf(err, "hashing %s", source)
+	}
+	if mf != nil {
+		mf.written = n
+	}
+	if n != entry.UncompressedSize || h.Sum32() != zf.CRC32 {
+		savior.Debugf("%s: differs from %s, not reflinking", entry.CanonicalPath, source)
+		return false, nil
+	}
+
+	reflinked, err := rs.Reflink(entry, source)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if reflinked {
+		atomic.AddInt64(&ze.stats.Reflinked, 1)
+	}
+	return reflinked, nil
+}
@@ -0,0 +1,104 @@
+package zipextractor_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	_FICLONE              = 0x40049409
+	_FS_IOC_FIEMAP        = 0xc020660b
+	_FIEMAP_FLAG_SYNC     = 0x1
+	_FIEMAP_EXTENT_SHARED = 0x2000
+)
+
+// fiemap mirrors struct fiemap from linux/fiemap.h,
+// with room for a few extents
+type fiemap struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	reserved      uint32
+	extents       [32]fiemapExtent
+}
+
+type fiemapExtent struct {
+	logical    uint64
+	physical   uint64
+	length     uint64
+	reserved64 [2]uint64
+	flags      uint32
+	reserved   [3]uint32
+}
+
+// sharesExtents returns true if all of a file's extents are
+// shared with another file
+func sharesExtents(t *testing.T, p string) bool {
+	f, err := os.Open(p)
+	must(t, err)
+	defer f.Close()
+
+	fm := &fiemap{
+		length:      ^uint64(0),
+		flags:       _FIEMAP_FLAG_SYNC,
+		extentCount: uint32(len(fiemap{}.extents)),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), _FS_IOC_FIEMAP, uintptr(unsafe.Pointer(fm)))
+	if errno != 0 {
+		t.Fatalf("fiemap %s: %s", p, errno)
+	}
+	if fm.mappedExtents == 0 {
+		return false
+	}
+	for _, extent := range fm.extents[:fm.mappedExtents] {
+		if extent.flags&_FIEMAP_EXTENT_SHARED == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// supportsReflinks tries to clone a file in `dir`
+func supportsReflinks(t *testing.T, dir string) bool {
+	src, err := ioutil.TempFile(dir, "reflink-probe")
+	must(t, err)
+	defer os.Remove(src.Name())
+	defer src.Close()
+	_, err = src.Write([]byte("probe"))
+	must(t, err)
+
+	dst, err := ioutil.TempFile(dir, "reflink-probe")
+	must(t, err)
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), _FICLONE, src.Fd())
+	return errno == 0
+}
+
+// TestReflinkSharesExtents needs a filesystem with reflinks (btrfs, XFS):
+// set SAVIOR_REFLINK_DIR to a folder on one, if the temporary folder isn't.
+func TestReflinkSharesExtents(t *testing.T) {
+	parent := os.Getenv("SAVIOR_REFLINK_DIR")
+	dir, err := ioutil.TempDir(parent, "zipextractor-reflink")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	if !supportsReflinks(t, dir) {
+		t.Skipf("%s doesn't support reflinks", dir)
+	}
+
+	dest, reflinked := extractWithReflinks(t, dir)
+	assert.EqualValues(t, 1, reflinked)
+	assert.True(t, sharesExtents(t, filepath.Join(dest, "same.bin")), "identical file should share extents")
+	assert.False(t, sharesExtents(t, filepath.Join(dest, "dir", "changed.bin")), "changed file shouldn't")
+	assert.False(t, sharesExtents(t, filepath.Join(dest, "added.bin")), "added file shouldn't")
+}
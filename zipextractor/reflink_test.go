@@ -0,0 +1,98 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/semirandom"
+	"github.com/stretchr/testify/assert"
+)
+
+// extractWithReflinks extracts an archive to `parent`/dest with reflinks
+// from `parent`/reference, which holds a file identical to one of the
+// archive's, one that has the same size but not the same contents, and
+// one that has another size. It returns the destination, and how many
+// entries were reflinked.
+func extractWithReflinks(t *testing.T, parent string) (string, int64) {
+	assert := assert.New(t)
+
+	same := semirandom.Bytes(256 * 1024)
+	changed := semirandom.Bytes(128 * 1024)
+	resized := semirandom.Bytes(64 * 1024)
+	added := semirandom.Bytes(32 * 1024)
+
+	reference := filepath.Join(parent, "reference")
+	for name, data := range map[string][]byte{
+		"same.bin":        same,
+		"dir/changed.bin": append([]byte("different"), changed[9:]...),
+		"resized.bin":     resized[:1024],
+	} {
+		p := filepath.Join(reference, filepath.FromSlash(name))
+		must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		must(t, ioutil.WriteFile(p, data, 0644))
+	}
+
+	files := map[string][]byte{
+		"same.bin":        same,
+		"dir/changed.bin": changed,
+		"resized.bin":     resized,
+		"added.bin":       added,
+	}
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "same.bin", Data: same, Method: zip.Deflate},
+		{Name: "dir/"},
+		{Name: "dir/changed.bin", Data: changed, Method: zip.Deflate},
+		{Name: "resized.bin", Data: resized},
+		{Name: "added.bin", Data: added, Method: zip.Deflate},
+	})
+
+	dest := filepath.Join(parent, "dest")
+	sink := &savior.FolderSink{
+		Directory:        dest,
+		Consumer:         savior.NopConsumer(),
+		UseReflinks:      true,
+		ReflinkReference: reference,
+	}
+	defer sink.Close()
+
+	ex := newTestZipExtractor(t, zipBytes)
+	_, err := ex.Resume(nil, sink)
+	must(t, err)
+
+	for name, data := range files {
+		actual, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		must(t, err)
+		assert.True(bytes.Equal(data, actual), "%s should have the archive's contents", name)
+	}
+
+	return dest, ex.Stats().Reflinked
+}
+
+func TestReflinkFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zipextractor-reflink")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	// whether the temporary folder supports reflinks or not,
+	// only the identical file may be reflinked
+	_, reflinked := extractWithReflinks(t, dir)
+	assert.True(t, reflinked <= 1)
+
+	// without a reference, nothing is reflinked
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "file.txt", Data: []byte("hello")},
+	})
+	ex := newTestZipExtractor(t, zipBytes)
+	_, err = ex.Resume(nil, &savior.FolderSink{
+		Directory:   filepath.Join(dir, "noref"),
+		Consumer:    savior.NopConsumer(),
+		UseReflinks: true,
+	})
+	must(t, err)
+	assert.EqualValues(t, 0, ex.Stats().Reflinked)
+}
@@ -0,0 +1,131 @@
+package zipextractor
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// SetReorderBuffer enables reordering of writes: entries are still read in
+// the order they're stored in, but file entries up to `size` bytes are
+// decompressed into a buffer (of at most `size` bytes), which is written
+// to the sink grouped by directory whenever it fills up.
+//
+// This helps when reading from the archive and writing to the sink have
+// very different costs. When reordering, checkpoints are only emitted on
+// entry boundaries for buffered entries.
+func (ze *ZipExtractor) SetReorderBuffer(size int64) {
+	ze.reorderBufferSize = size
+}
+
+type pendingEntry struct {
+	index int64
+	entry *savior.Entry
+	data  []byte
+}
+
+type reorderBuffer struct {
+	capacity int64
+	size     int64
+	pending  []*pendingEntry
+
+	// open returns a reader for the decompressed contents of an entry
+	open func(zf *zip.File) (io.ReadCloser, error)
+}
+
+func newReorderBuffer(capacity int64, open func(zf *zip.File) (io.ReadCloser, error)) *reorderBuffer {
+	return &reorderBuffer{
+		capacity: capacity,
+		open:     open,
+	}
+}
+
+// accepts returns true if an entry can ever fit in the buffer
+func (rb *reorderBuffer) accepts(entry *savior.Entry) bool {
+	return entry.UncompressedSize <= rb.capacity
+}
+
+// fits returns true if an entry fits in the buffer right now
+func (rb *reorderBuffer) fits(entry *savior.Entry) bool {
+	return rb.size+entry.UncompressedSize <= rb.capacity
+}
+
+// load decompresses a whole entry, for it to be added later
+func (rb *reorderBuffer) load(zf *zip.File) ([]byte, error) {
+	rc, err := rb.open(zf)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	buf := new(bytes.Buffer)
+	buf.Grow(int(zf.UncompressedSize64))
+	_, err = io.Copy(buf, rc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// add puts an entry that's already decompressed into the buffer
+func (rb *reorderBuffer) add(index int64, entry *savior.Entry, data []byte) {
+	rb.pending = append(rb.pending, &pendingEntry{
+		index: index,
+		entry: entry,
+		data:  data,
+	})
+	rb.size += int64(len(data))
+}
+
+// flush writes all pending entries to the sink, grouped by directory,
+// calling onWritten after each of them.
+func (rb *reorderBuffer) flush(sink savior.Sink, onWritten func(pe *pendingEntry)) error {
+	sort.SliceStable(rb.pending, func(i, j int) bool {
+		a := rb.pending[i].entry.CanonicalPath
+		b := rb.pending[j].entry.CanonicalPath
+		adir, bdir := path.Dir(a), path.Dir(b)
+		if adir != bdir {
+			return adir < bdir
+		}
+		return a < b
+	})
+
+	for len(rb.pending) > 0 {
+		pe := rb.pending[0]
+		savior.Debugf(`%s: writing from reorder buffer`, pe.entry.CanonicalPath)
+
+		pe.entry.WriteOffset = 0
+		w, err := sink.GetWriter(pe.entry)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = w.Write(pe.data)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		rb.pending = rb.pending[1:]
+		rb.size -= int64(len(pe.data))
+		onWritten(pe)
+	}
+	rb.pending = nil
+	return nil
+}
+
+func (rb *reorderBuffer) state() *ZipExtractorState {
+	if rb == nil || len(rb.pending) == 0 {
+		return nil
+	}
+
+	state := &ZipExtractorState{}
+	for _, pe := range rb.pending {
+		state.PendingEntries = append(state.PendingEntries, pe.index)
+	}
+	return state
+}
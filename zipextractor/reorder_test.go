@@ -0,0 +1,71 @@
+package zipextractor_test
+
+import (
+	"log"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	sink := checker.MakeTestSinkAdvanced(30)
+	zipBytes := checker.MakeZip(t, sink)
+
+	makeZipExtractor := func() savior.Extractor {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetReorderBuffer(6 * 1024 * 1024)
+		return ex
+	}
+
+	log.Printf("Testing reordered .zip, no resumes")
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return false
+	})
+
+	log.Printf("Testing reordered .zip, every resume")
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		return true
+	})
+
+	log.Printf("Testing reordered .zip, every other resume")
+	i := 0
+	checker.RunExtractorText(t, makeZipExtractor, sink, func() bool {
+		i++
+		return i%2 == 0
+	})
+}
+
+type orderSink struct {
+	savior.NopSink
+	order []string
+}
+
+func (os *orderSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	os.order = append(os.order, entry.CanonicalPath)
+	return os.NopSink.GetWriter(entry)
+}
+
+func TestReorderBufferOrder(t *testing.T) {
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "b/1", Data: []byte("b1")},
+		{Name: "a/1", Data: []byte("a1")},
+		{Name: "big", Data: make([]byte, 64)},
+		{Name: "b/2", Data: []byte("b2")},
+		{Name: "a/2", Data: []byte("a2")},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	ex.SetReorderBuffer(16)
+
+	sink := &orderSink{}
+	_, err := ex.Resume(nil, sink)
+	must(t, err)
+
+	assert.EqualValues(t, []string{
+		// too large to be buffered, written right away
+		"big",
+		"a/1", "a/2", "b/1", "b/2",
+	}, sink.order)
+}
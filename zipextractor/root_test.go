@@ -0,0 +1,32 @@
+package zipextractor_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRootEntries(t *testing.T) {
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "./", Mode: os.ModeDir | 0777},
+		{Name: ".", Mode: 0644, Data: []byte("not a file")},
+		{Name: "file.txt", Data: []byte("hello")},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	must(t, err)
+
+	stats, err := os.Stat(dir)
+	must(t, err)
+	assert.True(t, stats.IsDir(), "root should still be a directory")
+	assert.EqualValues(t, 0700, stats.Mode()&os.ModePerm, "root should not be chmod'd")
+
+	files, err := ioutil.ReadDir(dir)
+	must(t, err)
+	assert.EqualValues(t, 1, len(files))
+	assert.EqualValues(t, "file.txt", files[0].Name())
+}
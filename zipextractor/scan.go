@@ -0,0 +1,107 @@
+package zipextractor
+
+import (
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ScanStatus describes whether an entry could be decoded, see Scan()
+type ScanStatus int
+
+const (
+	// ScanOK is for entries that decode fine and match their CRC-32
+	ScanOK ScanStatus = iota
+	// ScanCRCMismatch is for entries that decode, but to the wrong contents
+	ScanCRCMismatch
+	// ScanDecodeError is for entries whose compressed data is corrupted
+	// or truncated
+	ScanDecodeError
+	// ScanUnsupportedMethod is for entries compressed with a method
+	// we don't know how to decode
+	ScanUnsupportedMethod
+	// ScanEncrypted is for entries that are encrypted, see ErrEncryptedEntry
+	ScanEncrypted
+)
+
+func (ss ScanStatus) String() string {
+	switch ss {
+	case ScanOK:
+		return "ok"
+	case ScanCRCMismatch:
+		return "crc-mismatch"
+	case ScanDecodeError:
+		return "decode-error"
+	case ScanUnsupportedMethod:
+		return "unsupported-method"
+	case ScanEncrypted:
+		return "encrypted"
+	default:
+		return "<unknown scan status>"
+	}
+}
+
+// A ScannedEntry is the outcome of decoding a single entry
+type ScannedEntry struct {
+	Entry  *savior.Entry
+	Status ScanStatus
+	// Err is what went wrong, nil if Status is ScanOK
+	Err error
+}
+
+// A ScanReport lists the outcome of decoding every entry
+// of an archive, see Scan()
+type ScanReport struct {
+	// Entries contains every entry that was scanned, in archive order
+	Entries []*ScannedEntry
+	// Bad contains the entries whose status isn't ScanOK
+	Bad []*ScannedEntry
+	// Counts is the number of entries for each status
+	Counts map[ScanStatus]int
+}
+
+// OK returns true if every entry decoded fine
+func (sr *ScanReport) OK() bool {
+	return len(sr.Bad) == 0
+}
+
+// Scan decompresses every entry of the archive (without writing them
+// anywhere), like Verify(), but doesn't stop at the first error: it
+// reports the status of each entry instead. Directories and delta entries
+// (which can only be checked against their base) are not scanned.
+func (ze *ZipExtractor) Scan() (*ScanReport, error) {
+	report := &ScanReport{
+		Counts: make(map[ScanStatus]int),
+	}
+
+	for _, zf := range ze.zr.File {
+		entry := zipFileEntry(zf)
+		if entry.Kind == savior.EntryKindDir || entry.IsDelta {
+			continue
+		}
+
+		se := &ScannedEntry{
+			Entry: entry,
+		}
+		err := ze.verifyEntry(zf)
+		if err != nil {
+			se.Err = errors.Wrapf(err, "%s", entry.CanonicalPath)
+			switch errors.Cause(err) {
+			case ErrCRCMismatch:
+				se.Status = ScanCRCMismatch
+			case zip.ErrAlgorithm, ErrUnsupportedMethod:
+				se.Status = ScanUnsupportedMethod
+			case ErrEncryptedEntry:
+				se.Status = ScanEncrypted
+			default:
+				se.Status = ScanDecodeError
+			}
+			report.Bad = append(report.Bad, se)
+		}
+
+		report.Entries = append(report.Entries, se)
+		report.Counts[se.Status]++
+	}
+
+	return report, nil
+}
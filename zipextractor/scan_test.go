@@ -0,0 +1,107 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// setCentralMethod changes the compression method the central
+// directory records for an entry.
+func setCentralMethod(t *testing.T, zipBytes []byte, name string, method uint16) {
+	centralDirSignature := []byte{0x50, 0x4b, 0x01, 0x02}
+	off := 0
+	for {
+		idx := bytes.Index(zipBytes[off:], centralDirSignature)
+		if idx < 0 {
+			t.Fatalf("no central directory header found for %s", name)
+		}
+		off += idx
+
+		nameLen := int(binary.LittleEndian.Uint16(zipBytes[off+28:]))
+		if string(zipBytes[off+46:off+46+nameLen]) == name {
+			binary.LittleEndian.PutUint16(zipBytes[off+10:], method)
+			return
+		}
+		off += 4
+	}
+}
+
+func dataOffset(t *testing.T, zipBytes []byte, name string) int64 {
+	zr, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	for _, zf := range zr.File {
+		if zf.Name == name {
+			off, err := zf.DataOffset()
+			must(t, err)
+			return off
+		}
+	}
+	t.Fatalf("no entry named %s", name)
+	return 0
+}
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/"},
+		{Name: "dir/good.bin", Data: semirandom.Bytes(64 * 1024), Method: zip.Store},
+		{Name: "dir/crc.bin", Data: semirandom.Bytes(64 * 1024), Method: zip.Store},
+		{Name: "corrupt.txt", Data: bytes.Repeat([]byte("deflate me "), 1024), Method: zip.Deflate},
+		{Name: "exotic.bin", Data: []byte("who knows"), Method: zip.Store},
+		{Name: "good.txt", Data: bytes.Repeat([]byte("deflate me too "), 1024), Method: zip.Deflate},
+	})
+
+	{
+		ex := newTestZipExtractor(t, zipBytes)
+		report, err := ex.Scan()
+		must(t, err)
+		assert.True(report.OK())
+		assert.Len(report.Entries, 5)
+		assert.EqualValues(5, report.Counts[zipextractor.ScanOK])
+	}
+
+	zipBytes[dataOffset(t, zipBytes, "dir/crc.bin")+1234] ^= 0xff
+	// final block, with the reserved (invalid) block type
+	zipBytes[dataOffset(t, zipBytes, "corrupt.txt")] = 0x07
+	setCentralMethod(t, zipBytes, "exotic.bin", 99)
+
+	ex := newTestZipExtractor(t, zipBytes)
+	assert.Error(ex.Verify(), "verify should stop at the first error")
+
+	report, err := ex.Scan()
+	must(t, err)
+	assert.False(report.OK())
+
+	var names []string
+	var statuses []zipextractor.ScanStatus
+	for _, se := range report.Entries {
+		names = append(names, se.Entry.CanonicalPath)
+		statuses = append(statuses, se.Status)
+	}
+	assert.EqualValues([]string{"dir/good.bin", "dir/crc.bin", "corrupt.txt", "exotic.bin", "good.txt"}, names)
+	assert.EqualValues([]zipextractor.ScanStatus{
+		zipextractor.ScanOK,
+		zipextractor.ScanCRCMismatch,
+		zipextractor.ScanDecodeError,
+		zipextractor.ScanUnsupportedMethod,
+		zipextractor.ScanOK,
+	}, statuses)
+
+	assert.Len(report.Bad, 3)
+	for _, se := range report.Bad {
+		assert.Error(se.Err)
+		assert.Contains(se.Err.Error(), se.Entry.CanonicalPath)
+	}
+	assert.EqualValues(2, report.Counts[zipextractor.ScanOK])
+	assert.EqualValues(1, report.Counts[zipextractor.ScanCRCMismatch])
+	assert.EqualValues(1, report.Counts[zipextractor.ScanDecodeError])
+	assert.EqualValues(1, report.Counts[zipextractor.ScanUnsupportedMethod])
+	assert.EqualValues("unsupported-method", zipextractor.ScanUnsupportedMethod.String())
+}
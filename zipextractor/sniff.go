@@ -0,0 +1,97 @@
+package zipextractor
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// sniffLen is how much of an entry http.DetectContentType looks at
+const sniffLen = 512
+
+// SetAllowedContentTypes restricts extraction to file entries whose content
+// type, as detected by `http.DetectContentType` from their first 512 bytes,
+// is one of the given media types. Wildcards like "image/*" are allowed.
+// Other entries are skipped without being written. Directories and symlinks
+// (and delta entries) are not sniffed. Passing nil lifts the restriction.
+//
+// Sniffing happens once, before extraction starts, and its results are
+// recorded in the checkpoint, so resumed extractions skip the same entries.
+func (ze *ZipExtractor) SetAllowedContentTypes(contentTypes []string) {
+	if contentTypes == nil {
+		ze.allowedContentTypes = nil
+		return
+	}
+
+	ze.allowedContentTypes = make([]string, 0, len(contentTypes))
+	for _, ct := range contentTypes {
+		ze.allowedContentTypes = append(ze.allowedContentTypes, strings.ToLower(strings.TrimSpace(ct)))
+	}
+}
+
+func (ze *ZipExtractor) contentTypeAllowed(contentType string) bool {
+	// "text/plain; charset=utf-8" => "text/plain"
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	for _, allowed := range ze.allowedContentTypes {
+		if strings.HasSuffix(allowed, "/*") {
+			if strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		} else if contentType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffEntries returns the indices of selected file entries whose
+// content type isn't allowed.
+func (ze *ZipExtractor) sniffEntries(selected []bool) ([]int64, error) {
+	rejected := []int64{}
+	buf := make([]byte, sniffLen)
+
+	for i, zf := range ze.zr.File {
+		if !selected[i] {
+			continue
+		}
+
+		entry := zipFileEntry(zf)
+		if entry.Kind != savior.EntryKindFile || entry.IsDelta {
+			// deltas can't be sniffed before they're applied
+			continue
+		}
+
+		n, err := func() (int, error) {
+			rc, err := ze.openChecked(zf)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+			defer rc.Close()
+
+			n, err := io.ReadFull(rc, buf)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// short entries are fine
+				err = nil
+			}
+			return n, err
+		}()
+		if err != nil {
+			return nil, errors.Wrapf(err, "sniffing %s", entry.CanonicalPath)
+		}
+
+		contentType := http.DetectContentType(buf[:n])
+		if !ze.contentTypeAllowed(contentType) {
+			savior.Debugf(`%s: skipping, content type %s not allowed`, entry.CanonicalPath, contentType)
+			rejected = append(rejected, int64(i))
+		}
+	}
+
+	return rejected, nil
+}
@@ -0,0 +1,91 @@
+package zipextractor_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentTypeSniffing(t *testing.T) {
+	assert := assert.New(t)
+
+	pngHeader := []byte("\x89PNG\r\n\x1a\n")
+	bigPNG := append(append([]byte{}, pngHeader...), semirandom.Bytes(3*1024*1024)...)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "images/"},
+		{Name: "images/a.png", Data: bigPNG, Method: zip.Deflate},
+		{Name: "images/b.gif", Data: []byte("GIF89a\x01\x00\x01\x00")},
+		{Name: "images/c.jpg", Data: []byte("\xff\xd8\xff\xe0 jfif")},
+		{Name: "images/not-really.png", Data: []byte("<html><body>gotcha</body></html>")},
+		{Name: "notes.txt", Data: semirandom.Bytes(2 * 1024 * 1024), Method: zip.Deflate},
+		{Name: "empty.png"},
+	})
+
+	dir, err := ioutil.TempDir("", "zipextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+
+	// stop at every checkpoint. only the first extractor is told to sniff,
+	// the others must stick to what was recorded in the checkpoint.
+	var c *savior.ExtractorCheckpoint
+	numResumes := 0
+	for {
+		ex := newTestZipExtractor(t, zipBytes)
+		if c == nil {
+			ex.SetAllowedContentTypes([]string{"image/*"})
+		}
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(512*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			state, ok := checkpoint.Data.(*zipextractor.ZipExtractorState)
+			assert.True(ok && state.Sniffed, "sniff decisions should be in the checkpoint")
+
+			buf, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(buf)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			return savior.AfterSaveStop, nil
+		}))
+
+		_, err = ex.Resume(c, sink)
+		if err == savior.ErrStop {
+			numResumes++
+			continue
+		}
+		must(t, err)
+		break
+	}
+	must(t, sink.Close())
+	assert.True(numResumes > 0)
+
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil
+	}
+	assert.True(exists("images/a.png"))
+	assert.True(exists("images/b.gif"))
+	assert.True(exists("images/c.jpg"))
+	assert.False(exists("images/not-really.png"), "html isn't an image, whatever its extension")
+	assert.False(exists("notes.txt"))
+	assert.False(exists("empty.png"))
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "images", "a.png"))
+	must(t, err)
+	assert.EqualValues(bigPNG, bs)
+}
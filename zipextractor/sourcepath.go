@@ -0,0 +1,71 @@
+package zipextractor
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrSourceCollision is returned by Resume when an entry would be written
+// over the archive being extracted, see SetSourcePath.
+var ErrSourceCollision = errors.New("entry would overwrite the archive being extracted")
+
+// SetSourcePath tells the extractor which file it's reading from. When
+// extracting to a sink that writes to disk (a savior.PathSink, like
+// FolderSink), Resume refuses to start if any entry would be written
+// over that file, which would corrupt the archive mid-read.
+func (ze *ZipExtractor) SetSourcePath(path string) {
+	ze.sourcePath = path
+}
+
+// checkSourceCollision returns ErrSourceCollision if a selected
+// entry's destination is the source file.
+func (ze *ZipExtractor) checkSourceCollision(selected []bool, sink savior.Sink) error {
+	if ze.sourcePath == "" {
+		return nil
+	}
+
+	if _, ok := sink.(savior.PathSink); !ok {
+		savior.Debugf("zipextractor: sink can't tell destination paths, not checking for source collisions")
+		return nil
+	}
+
+	sourcePath, err := filepath.Abs(ze.sourcePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for i, zf := range ze.zr.File {
+		if !selected[i] {
+			continue
+		}
+		entry := zipFileEntry(zf)
+
+		destPath, ok := savior.DestPathOf(sink, entry)
+		if !ok {
+			continue
+		}
+		dest, err := filepath.Abs(destPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		collides := dest == sourcePath
+		if !collides {
+			// catches case-insensitive filesystems, hard links and symlinks
+			if destInfo, err := os.Stat(dest); err == nil && os.SameFile(sourceInfo, destInfo) {
+				collides = true
+			}
+		}
+		if collides {
+			return errors.Wrapf(ErrSourceCollision, "%s (%s)", entry.CanonicalPath, dest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceCollision(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "zipextractor-source")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "readme.txt", Data: []byte("hi")},
+		{Name: "foo.zip", Data: []byte("not a zip anymore")},
+	})
+	sourcePath := filepath.Join(dir, "foo.zip")
+	must(t, ioutil.WriteFile(sourcePath, zipBytes, 0644))
+
+	extract := func() error {
+		f, err := os.Open(sourcePath)
+		must(t, err)
+		defer f.Close()
+
+		ex, err := zipextractor.New(f, int64(len(zipBytes)))
+		must(t, err)
+		ex.SetSourcePath(sourcePath)
+
+		sink := &savior.FolderSink{
+			Directory: dir,
+			Consumer:  savior.NopConsumer(),
+		}
+		defer sink.Close()
+
+		_, err = ex.Resume(nil, sink)
+		return err
+	}
+
+	err = extract()
+	assert.Error(err)
+	assert.True(errors.Cause(err) == zipextractor.ErrSourceCollision)
+	assert.Contains(err.Error(), "foo.zip")
+
+	_, err = os.Stat(filepath.Join(dir, "readme.txt"))
+	assert.True(os.IsNotExist(err), "nothing should be written")
+	bs, err := ioutil.ReadFile(sourcePath)
+	must(t, err)
+	assert.True(bytes.Equal(zipBytes, bs), "source should be left untouched")
+
+	// extracting elsewhere is fine
+	{
+		f, err := os.Open(sourcePath)
+		must(t, err)
+		defer f.Close()
+
+		ex, err := zipextractor.New(f, int64(len(zipBytes)))
+		must(t, err)
+		ex.SetSourcePath(sourcePath)
+
+		dest := filepath.Join(dir, "out")
+		sink := &savior.FolderSink{
+			Directory: dest,
+			Consumer:  savior.NopConsumer(),
+		}
+		defer sink.Close()
+
+		_, err = ex.Resume(nil, sink)
+		must(t, err)
+	}
+}
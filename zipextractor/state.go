@@ -0,0 +1,107 @@
+package zipextractor
+
+import "github.com/itchio/savior"
+
+// ZipExtractorState is stored in the `Data` field of extractor checkpoints
+type ZipExtractorState struct {
+	// PendingEntries lists the indices of entries that were read into
+	// the reorder buffer, but not written to the sink yet. Since zip
+	// is random access, they're simply read again on resume.
+	PendingEntries []int64
+
+	// Sniffed is true if entries were filtered by content type,
+	// see `SetAllowedContentTypes`.
+	Sniffed bool
+	// SniffRejected lists the indices of entries that were skipped
+	// because of their content type.
+	SniffRejected []int64
+
+	// Manifest is true if a manifest was being written, see `WriteManifest`.
+	Manifest bool
+	// ManifestSize is how many bytes of the manifest were valid
+	// when the checkpoint was taken.
+	ManifestSize int64
+
+	// IterationOrder is the order entries are extracted in, which
+	// `EntryIndex` is relative to, see `SetIterationOrder`.
+	IterationOrder IterationOrder
+	// Order lists the indices of entries in the order they're extracted
+	// in, for IterationCustom, as decided when extraction started.
+	Order []int64
+
+	// PreviousManifest is true if entries were compared to a previous
+	// manifest, see `SetPreviousManifest`.
+	PreviousManifest bool
+	// Unchanged lists the indices of entries that weren't written
+	// because they matched the previous manifest.
+	Unchanged []int64
+
+	// ExpectedHashes is true if entries were checked against expected
+	// hashes, see `SetExpectedHashes`.
+	ExpectedHashes bool
+	// Unexpected lists the indices of entries that were skipped
+	// because they didn't match the expected hashes.
+	Unexpected []int64
+
+	// PathFilter is true if entries were filtered by path, see
+	// `SetPathFilterPatterns`.
+	PathFilter bool
+	// PathFilterPatterns are the patterns extraction started with.
+	PathFilterPatterns []string
+
+	// Failed lists the indices of entries that were skipped because
+	// they failed to extract, see `SetErrorPolicy`.
+	Failed []int64
+}
+
+var _ savior.PortableChecker = (*ZipExtractorState)(nil)
+
+// Portable returns true if all entry indices are valid, and Order
+// doesn't list any entry twice. Indices are relative to the archive's
+// central directory, so they mean the same thing on any machine, but
+// a negative one can only come from a corrupted checkpoint.
+func (zes *ZipExtractorState) Portable() bool {
+	if zes == nil {
+		return true
+	}
+
+	if zes.ManifestSize < 0 {
+		return false
+	}
+
+	lists := [][]int64{
+		zes.PendingEntries,
+		zes.SniffRejected,
+		zes.Unchanged,
+		zes.Unexpected,
+		zes.Failed,
+		zes.Order,
+	}
+	for _, list := range lists {
+		if !validIndices(list) {
+			return false
+		}
+	}
+
+	seen := make(map[int64]bool, len(zes.Order))
+	for _, index := range zes.Order {
+		if seen[index] {
+			return false
+		}
+		seen[index] = true
+	}
+	return true
+}
+
+func validIndices(indices []int64) bool {
+	for _, index := range indices {
+		if index < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	savior.RegisterCheckpointData("zipextractor.ZipExtractorState", &ZipExtractorState{})
+}
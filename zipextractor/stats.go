@@ -0,0 +1,83 @@
+package zipextractor
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/itchio/savior"
+)
+
+// Stats contains counters about the work a ZipExtractor has done so far,
+// across all calls to Resume (and Verify, Scan).
+type Stats struct {
+	// BytesRead is the number of bytes read from the archive
+	BytesRead int64
+	// BytesWritten is the number of bytes written to the sink
+	BytesWritten int64
+	// Checkpoints is the number of checkpoints passed to the save consumer
+	Checkpoints int64
+	// Syncs is the number of times an entry writer was synced
+	Syncs int64
+	// Reflinked is the number of entries written as reflinks of an
+	// identical file, see savior.FolderSink.UseReflinks
+	Reflinked int64
+}
+
+// Stats returns a snapshot of the extractor's counters
+func (ze *ZipExtractor) Stats() Stats {
+	return Stats{
+		BytesRead:    atomic.LoadInt64(&ze.stats.BytesRead),
+		BytesWritten: atomic.LoadInt64(&ze.stats.BytesWritten),
+		Checkpoints:  atomic.LoadInt64(&ze.stats.Checkpoints),
+		Syncs:        atomic.LoadInt64(&ze.stats.Syncs),
+		Reflinked:    atomic.LoadInt64(&ze.stats.Reflinked),
+	}
+}
+
+// countingReaderAt counts bytes read from the archive. Verify reads
+// from several goroutines, hence the atomics.
+type countingReaderAt struct {
+	r     io.ReaderAt
+	stats *Stats
+}
+
+func (cra *countingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	n, err := cra.r.ReadAt(buf, off)
+	atomic.AddInt64(&cra.stats.BytesRead, int64(n))
+	return n, err
+}
+
+// countingSink counts bytes written and syncs, everything
+// else is passed through
+type countingSink struct {
+	savior.Sink
+	stats *Stats
+}
+
+func (cs *countingSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	w, err := cs.Sink.GetWriter(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &countingEntryWriter{w: w, stats: cs.stats}, nil
+}
+
+type countingEntryWriter struct {
+	w     savior.EntryWriter
+	stats *Stats
+}
+
+func (cew *countingEntryWriter) Write(buf []byte) (int, error) {
+	n, err := cew.w.Write(buf)
+	atomic.AddInt64(&cew.stats.BytesWritten, int64(n))
+	return n, err
+}
+
+func (cew *countingEntryWriter) Close() error {
+	return cew.w.Close()
+}
+
+func (cew *countingEntryWriter) Sync() error {
+	atomic.AddInt64(&cew.stats.Syncs, 1)
+	return cew.w.Sync()
+}
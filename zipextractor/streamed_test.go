@@ -0,0 +1,93 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamedEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []testZipEntry{
+		{Name: "first.txt", Data: []byte("stored, then streamed"), Method: zip.Store},
+		{Name: "second.txt", Data: bytes.Repeat([]byte("deflated and streamed "), 512), Method: zip.Deflate},
+		{Name: "third.txt", Data: []byte("right after a data descriptor"), Method: zip.Store},
+	}
+	zipBytes := makeTestZip(t, entries)
+
+	zr, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	for i, zf := range zr.File {
+		assert.True(zf.Flags&0x8 != 0, "%s should be streamed", zf.Name)
+		assert.EqualValues(len(entries[i].Data), zf.UncompressedSize64)
+
+		// the local header has zeroes for the CRC-32 and sizes
+		local := bytes.Index(zipBytes, []byte(zf.Name)) - 30
+		assert.EqualValues(0x04034b50, binary.LittleEndian.Uint32(zipBytes[local:]))
+		assert.True(bytes.Equal(make([]byte, 12), zipBytes[local+14:local+26]), "%s: local CRC-32 and sizes", zf.Name)
+	}
+
+	// corrupts the data descriptor or the central directory
+	// record of an entry
+	corrupt := func(zipBytes []byte, name string, central bool) []byte {
+		corrupted := append([]byte(nil), zipBytes...)
+		if central {
+			record := bytes.LastIndex(corrupted, []byte(name)) - 46
+			assert.EqualValues(0x02014b50, binary.LittleEndian.Uint32(corrupted[record:]))
+			corrupted[record+16] ^= 0xff
+		} else {
+			data := entries[0].Data
+			descriptor := bytes.Index(corrupted, data) + len(data)
+			assert.EqualValues(0x08074b50, binary.LittleEndian.Uint32(corrupted[descriptor:]))
+			corrupted[descriptor+4] ^= 0xff
+		}
+		return corrupted
+	}
+
+	// the data descriptor is ignored
+	ex := newTestZipExtractor(t, corrupt(zipBytes, "first.txt", false))
+	must(t, ex.Verify())
+
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	must(t, err)
+	for _, e := range entries {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, e.Name))
+		must(t, err)
+		assert.True(bytes.Equal(e.Data, actual), "%s should extract exactly", e.Name)
+
+		buf := new(bytes.Buffer)
+		must(t, ex.ExtractEntry(e.Name, buf))
+		assert.True(bytes.Equal(e.Data, buf.Bytes()), "%s should extract exactly on its own", e.Name)
+	}
+
+	// the central directory is what entries are checked against
+	for _, name := range []string{"first.txt", "second.txt"} {
+		ex = newTestZipExtractor(t, corrupt(zipBytes, name, true))
+
+		err = ex.Verify()
+		assert.Error(err)
+		assert.True(errors.Cause(err) == zipextractor.ErrCRCMismatch, "%s: Verify", name)
+
+		err = ex.ExtractEntry(name, ioutil.Discard)
+		assert.Error(err)
+		assert.True(errors.Cause(err) == zipextractor.ErrCRCMismatch, "%s: ExtractEntry", name)
+
+		index := 0
+		if name == "second.txt" {
+			index = 1
+		}
+		_, err = ex.ReadEntryBytes(index, 1024*1024)
+		assert.Error(err)
+		assert.True(errors.Cause(err) == zipextractor.ErrCRCMismatch, "%s: ReadEntryBytes", name)
+	}
+}
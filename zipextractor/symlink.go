@@ -0,0 +1,34 @@
+package zipextractor
+
+import (
+	"path"
+
+	"github.com/itchio/savior"
+)
+
+// linksToDir returns true if the target of a symlink is a directory of
+// the archive, whether it has an entry of its own, or is only implied by
+// the paths of other entries.
+func (ze *ZipExtractor) linksToDir(entry *savior.Entry, linkname string) bool {
+	if linkname == "" || path.IsAbs(linkname) {
+		return false
+	}
+
+	if ze.archiveDirs == nil {
+		ze.archiveDirs = make(map[string]bool)
+		for _, zf := range ze.zr.File {
+			entry := zipFileEntry(zf)
+
+			p := path.Clean(entry.CanonicalPath)
+			if entry.Kind == savior.EntryKindDir {
+				ze.archiveDirs[p] = true
+			}
+			for p = path.Dir(p); p != "." && p != "/"; p = path.Dir(p) {
+				ze.archiveDirs[p] = true
+			}
+		}
+	}
+
+	target := path.Join(path.Dir(entry.CanonicalPath), linkname)
+	return ze.archiveDirs[target]
+}
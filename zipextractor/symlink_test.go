@@ -0,0 +1,51 @@
+package zipextractor_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/stretchr/testify/assert"
+)
+
+type symlinkRecordingSink struct {
+	savior.NopSink
+	linksToDir map[string]bool
+}
+
+func (srs *symlinkRecordingSink) Symlink(entry *savior.Entry, linkname string) error {
+	srs.linksToDir[entry.CanonicalPath] = entry.LinkToDir
+	return nil
+}
+
+func TestSymlinkLinksToDir(t *testing.T) {
+	link := func(name, target string) testZipEntry {
+		return testZipEntry{Name: name, Data: []byte(target), Mode: os.ModeSymlink | 0755}
+	}
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		link("current", "versions/1.0"),
+		link("versions/latest", "1.0/"),
+		link("bin/tool", "../versions/1.0/tool"),
+		link("lib", "implied"),
+		link("up", ".."),
+		link("abs", "/usr/lib"),
+		{Name: "versions/1.0/"},
+		{Name: "versions/1.0/tool", Data: []byte("tool")},
+		{Name: "implied/lib.so", Data: []byte("lib")},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	sink := &symlinkRecordingSink{linksToDir: make(map[string]bool)}
+	_, err := ex.Resume(nil, sink)
+	must(t, err)
+
+	assert.EqualValues(t, map[string]bool{
+		"current":         true,
+		"versions/latest": true,
+		"bin/tool":        false,
+		"lib":             true,
+		"up":              false,
+		"abs":             false,
+	}, sink.linksToDir)
+}
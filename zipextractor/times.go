@@ -0,0 +1,153 @@
+package zipextractor
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+)
+
+// The zip reader only keeps the modification time of entries, from
+// whichever extra field has the most precise one. Access times are
+// in the same fields: the NTFS one, and the old Unix ones have them in
+// the central directory. The extended timestamp one only has them in
+// local headers, although some writers put them in both.
+
+const (
+	ntfsExtraID        = 0x000a
+	unixExtraID        = 0x000d
+	infoZipUnixExtraID = 0x5855
+	extTimeExtraID     = 0x5455
+
+	extTimeMod    = 1 << 0
+	extTimeAccess = 1 << 1
+
+	localHeaderSignature = 0x04034b50
+	localHeaderLen       = 30
+)
+
+// accessTime returns when the index-th file of the archive was last
+// accessed, or the zero time if the archive doesn't say
+func (ze *ZipExtractor) accessTime(index int64) time.Time {
+	zf := ze.zr.File[index]
+	atime, inLocal := extraAccessTime(zf.Extra, false)
+	if !inLocal || ze.central == nil {
+		return atime
+	}
+
+	ze.localTimesMu.Lock()
+	defer ze.localTimesMu.Unlock()
+	if t, ok := ze.localAccessTimes[index]; ok {
+		return t
+	}
+
+	headerOffset := ze.central.records[index].headerOffset
+	if headerOffset >= 0 {
+		extra, err := ze.localExtra(headerOffset)
+		if err == nil {
+			atime, _ = extraAccessTime(extra, true)
+		}
+		// otherwise, it'll fail louder when the entry is opened
+	}
+
+	if ze.localAccessTimes == nil {
+		ze.localAccessTimes = make(map[int64]time.Time)
+	}
+	ze.localAccessTimes[index] = atime
+	return atime
+}
+
+// localExtra reads the extra field of the local header at `offset`
+func (ze *ZipExtractor) localExtra(offset int64) ([]byte, error) {
+	header := make([]byte, localHeaderLen)
+	_, err := ze.reader.ReadAt(header, offset)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header) != localHeaderSignature {
+		return nil, zip.ErrFormat
+	}
+
+	nameLen := int64(binary.LittleEndian.Uint16(header[26:]))
+	extraLen := int64(binary.LittleEndian.Uint16(header[28:]))
+	extra := make([]byte, extraLen)
+	_, err = ze.reader.ReadAt(extra, offset+localHeaderLen+nameLen)
+	if err != nil {
+		return nil, err
+	}
+	return extra, nil
+}
+
+// extraAccessTime looks for an access time in an extra field. It also
+// returns true if there's none, but the local header has one.
+func extraAccessTime(extra []byte, local bool) (time.Time, bool) {
+	var atime time.Time
+	inLocal := false
+
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		fieldLen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+fieldLen > len(extra) {
+			break
+		}
+		field := extra[4 : 4+fieldLen]
+		extra = extra[4+fieldLen:]
+
+		switch id {
+		case ntfsExtraID:
+			if len(field) < 4 {
+				continue
+			}
+			field = field[4:] // reserved
+			for len(field) >= 4 {
+				tag := binary.LittleEndian.Uint16(field)
+				size := int(binary.LittleEndian.Uint16(field[2:]))
+				if 4+size > len(field) {
+					break
+				}
+				attr := field[4 : 4+size]
+				field = field[4+size:]
+				if tag == 1 && size == 24 {
+					// modification, access, and creation times
+					return ntfsTime(binary.LittleEndian.Uint64(attr[8:])), false
+				}
+			}
+		case unixExtraID, infoZipUnixExtraID:
+			if len(field) < 8 {
+				continue
+			}
+			atime = time.Unix(int64(binary.LittleEndian.Uint32(field)), 0)
+		case extTimeExtraID:
+			if len(field) < 1 {
+				continue
+			}
+			flags := field[0]
+			field = field[1:]
+			if flags&extTimeMod != 0 {
+				if len(field) < 4 {
+					continue
+				}
+				field = field[4:]
+			}
+			if flags&extTimeAccess == 0 {
+				continue
+			}
+			if len(field) < 4 {
+				// the central directory only has the modification time
+				inLocal = !local
+				continue
+			}
+			return time.Unix(int64(binary.LittleEndian.Uint32(field)), 0), false
+		}
+	}
+	return atime, inLocal && atime.IsZero()
+}
+
+// ntfsTime converts a number of 100ns ticks since 1601 to a time
+func ntfsTime(ticks uint64) time.Time {
+	const ticksPerSecond = 1e7
+	epoch := time.Date(1601, time.January, 1, 0, 0, 0, 0, time.UTC)
+	secs := int64(ticks / ticksPerSecond)
+	nsecs := int64(ticks%ticksPerSecond) * (1e9 / ticksPerSecond)
+	return time.Unix(epoch.Unix()+secs, nsecs)
+}
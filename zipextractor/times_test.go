@@ -0,0 +1,87 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// extTimeExtra makes an extended timestamp extra field (0x5455)
+// with both a modification and an access time
+func extTimeExtra(mtime time.Time, atime time.Time) []byte {
+	b := make([]byte, 13)
+	binary.LittleEndian.PutUint16(b, 0x5455)
+	binary.LittleEndian.PutUint16(b[2:], 9)
+	b[4] = 1 | 2
+	binary.LittleEndian.PutUint32(b[5:], uint32(mtime.Unix()))
+	binary.LittleEndian.PutUint32(b[9:], uint32(atime.Unix()))
+	return b
+}
+
+// ntfsExtra makes an NTFS extra field (0x000a), whose
+// times have a 100ns resolution
+func ntfsExtra(mtime time.Time, atime time.Time) []byte {
+	ticks := func(t time.Time) uint64 {
+		epoch := time.Date(1601, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return uint64(t.Unix()-epoch.Unix())*1e7 + uint64(t.Nanosecond()/100)
+	}
+
+	b := make([]byte, 4+4+4+24)
+	binary.LittleEndian.PutUint16(b, 0x000a)
+	binary.LittleEndian.PutUint16(b[2:], 32)
+	binary.LittleEndian.PutUint16(b[8:], 1)
+	binary.LittleEndian.PutUint16(b[10:], 24)
+	binary.LittleEndian.PutUint64(b[12:], ticks(mtime))
+	binary.LittleEndian.PutUint64(b[20:], ticks(atime))
+	binary.LittleEndian.PutUint64(b[28:], ticks(mtime))
+	return b
+}
+
+func TestZipAccessTime(t *testing.T) {
+	assert := assert.New(t)
+
+	mtime := time.Date(2019, time.March, 4, 5, 6, 7, 0, time.UTC)
+	atime := time.Date(2020, time.June, 18, 12, 30, 0, 0, time.UTC)
+	fineMtime := mtime.Add(123456700 * time.Nanosecond)
+	fineAtime := atime.Add(987654300 * time.Nanosecond)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "ext.txt", Data: []byte("ext"), Extra: extTimeExtra(mtime, atime)},
+		{Name: "local.txt", Data: []byte("local"), Extra: extTimeExtra(mtime, atime)},
+		{Name: "ntfs.txt", Data: []byte("ntfs"), Extra: ntfsExtra(fineMtime, fineAtime)},
+		{Name: "none.txt", Data: []byte("none"), Modified: mtime},
+	})
+
+	// like most writers do, only leave the modification time in the
+	// central directory copy of local.txt's field, and pad with an empty
+	// field so that nothing moves
+	central := bytes.LastIndex(zipBytes, []byte("local.txt"))
+	field := bytes.Index(zipBytes[central:], []byte{0x55, 0x54, 9, 0}) + central
+	binary.LittleEndian.PutUint16(zipBytes[field+2:], 5)
+	copy(zipBytes[field+9:], []byte{0xfe, 0xca, 0, 0})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	entries := ex.Entries()
+	for _, entry := range entries[:2] {
+		assert.True(mtime.Equal(entry.ModTime), "%s: mtime %s", entry.CanonicalPath, entry.ModTime)
+		assert.True(atime.Equal(entry.AccessTime), "%s: atime %s", entry.CanonicalPath, entry.AccessTime)
+	}
+	assert.True(fineMtime.Equal(entries[2].ModTime), "mtime %s", entries[2].ModTime)
+	assert.True(fineAtime.Equal(entries[2].AccessTime), "atime %s", entries[2].AccessTime)
+	assert.True(entries[3].AccessTime.IsZero())
+
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	must(t, err)
+
+	stats, err := os.Stat(filepath.Join(dir, "ntfs.txt"))
+	must(t, err)
+	// filesystems may not have a 100ns resolution
+	assert.False(stats.ModTime().After(fineMtime))
+	assert.True(fineMtime.Sub(stats.ModTime()) < 2*time.Second)
+}
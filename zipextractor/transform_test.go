@@ -0,0 +1,94 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/stretchr/testify/assert"
+)
+
+type upperCaser struct {
+	w io.Writer
+}
+
+func (uc *upperCaser) Write(buf []byte) (int, error) {
+	return uc.w.Write(bytes.ToUpper(buf))
+}
+
+func (uc *upperCaser) Close() error {
+	return nil
+}
+
+func TestTransformContentResume(t *testing.T) {
+	assert := assert.New(t)
+
+	text := bytes.Repeat([]byte("all work and no play makes jack a dull boy\n"), 64*1024)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "small.txt", Data: []byte("tiny")},
+		{Name: "big.txt", Data: text, Method: zip.Deflate},
+	})
+
+	dir, err := ioutil.TempDir("", "zipextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+		TransformContent: func(entry *savior.Entry, w io.Writer) (io.WriteCloser, error) {
+			return &upperCaser{w}, nil
+		},
+	}
+	defer sink.Close()
+
+	var c *savior.ExtractorCheckpoint
+	numResumes := 0
+	midEntry := false
+	for {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetSaveConsumer(checker.NewTestSaveConsumer(256*1024, func(checkpoint *savior.ExtractorCheckpoint) (savior.AfterSaveAction, error) {
+			if midEntry {
+				// restarted entries would never finish if we kept stopping
+				return savior.AfterSaveContinue, nil
+			}
+			if checkpoint.Entry != nil && checkpoint.Entry.WriteOffset > 0 {
+				midEntry = true
+			}
+			buf, err := savior.MarshalCheckpoint(checkpoint)
+			if err != nil {
+				return savior.AfterSaveStop, err
+			}
+			c, err = savior.UnmarshalCheckpoint(buf)
+			return savior.AfterSaveStop, err
+		}))
+
+		_, err := ex.Resume(c, sink)
+		if err == savior.ErrStop {
+			numResumes++
+			if numResumes > 100 {
+				t.Fatal("too many resumes")
+			}
+			continue
+		}
+		must(t, err)
+		break
+	}
+	assert.True(midEntry, "should have stopped in the middle of an entry")
+
+	must(t, sink.Close())
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "big.txt"))
+	must(t, err)
+	assert.True(bytes.Equal(bytes.ToUpper(text), bs), "entries should be restarted, not resumed")
+
+	bs, err = ioutil.ReadFile(filepath.Join(dir, "small.txt"))
+	must(t, err)
+	assert.EqualValues("TINY", string(bs))
+}
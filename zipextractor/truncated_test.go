@@ -0,0 +1,100 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// inflateDeclaredSize makes the central directory claim the
+// first entry is `extra` bytes larger than it really is.
+func inflateDeclaredSize(t *testing.T, zipBytes []byte, extra uint32) {
+	centralDirSignature := []byte{0x50, 0x4b, 0x01, 0x02}
+	off := bytes.Index(zipBytes, centralDirSignature)
+	if off < 0 {
+		t.Fatal("no central directory header found")
+	}
+
+	sizeField := zipBytes[off+24 : off+28]
+	binary.LittleEndian.PutUint32(sizeField, binary.LittleEndian.Uint32(sizeField)+extra)
+}
+
+// zip64SizeExtra is a zip64 extra field to pass to makeTestZip, so
+// that declareZip64Size can then patch the entry's declared size in
+var zip64SizeExtra = []byte{0x01, 0x00, 0x08, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// declareZip64Size makes the central directory claim the first
+// entry, created with zip64SizeExtra, is `size` bytes large.
+func declareZip64Size(t *testing.T, zipBytes []byte, size uint64) {
+	centralDirSignature := []byte{0x50, 0x4b, 0x01, 0x02}
+	off := bytes.Index(zipBytes, centralDirSignature)
+	if off < 0 {
+		t.Fatal("no central directory header found")
+	}
+
+	binary.LittleEndian.PutUint32(zipBytes[off+24:off+28], 0xffffffff)
+	nameLen := int(binary.LittleEndian.Uint16(zipBytes[off+28 : off+30]))
+	extra := zipBytes[off+46+nameLen:]
+	if !bytes.HasPrefix(extra, zip64SizeExtra[:4]) {
+		t.Fatal("no zip64 extra field found")
+	}
+	binary.LittleEndian.PutUint64(extra[4:12], size)
+}
+
+func TestOversizedEntry(t *testing.T) {
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "huge.txt", Data: []byte("not that huge"), Extra: zip64SizeExtra},
+	})
+
+	declareZip64Size(t, zipBytes, 1<<40)
+	ex := newTestZipExtractor(t, zipBytes)
+	assert.EqualValues(t, 1<<40, ex.Entries()[0].UncompressedSize)
+
+	// nothing is allocated based on the declared size
+	_, err := ex.ReadEntryBytes(0, math.MaxInt64)
+	assert.True(t, errors.Cause(err) == savior.ErrTruncatedEntry)
+
+	// sizes that don't fit in an int64 are rejected upfront
+	declareZip64Size(t, zipBytes, 1<<63)
+	_, err = zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == zip.ErrFormat)
+	assert.Contains(t, err.Error(), "huge.txt")
+}
+
+func TestTruncatedEntry(t *testing.T) {
+	data := bytes.Repeat([]byte("short and sweet "), 4096)
+
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		zipBytes := makeTestZip(t, []testZipEntry{
+			{Name: "short.txt", Data: data, Method: method},
+		})
+
+		{
+			ex := newTestZipExtractor(t, zipBytes)
+			dir, err := extractTestZip(t, ex)
+			defer os.RemoveAll(dir)
+			must(t, err)
+		}
+
+		inflateDeclaredSize(t, zipBytes, 1234)
+
+		ex := newTestZipExtractor(t, zipBytes)
+		assert.EqualValues(t, len(data)+1234, ex.Entries()[0].UncompressedSize)
+
+		dir, err := extractTestZip(t, ex)
+		defer os.RemoveAll(dir)
+		assert.Error(t, err, "method %d", method)
+		assert.True(t, errors.Cause(err) == savior.ErrTruncatedEntry, "method %d", method)
+		assert.Contains(t, err.Error(), "short.txt")
+		assert.Contains(t, err.Error(), "1234 short")
+	}
+}
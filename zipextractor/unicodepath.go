@@ -0,0 +1,84 @@
+package zipextractor
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"unicode/utf8"
+
+	"github.com/itchio/arkive/zip"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// infoZipUnicodePathExtraID is the tag of the Info-ZIP Unicode Path
+// extra field, which stores the UTF-8 name of an entry alongside a
+// CRC-32 of the (legacy-encoded) name found in the header.
+const infoZipUnicodePathExtraID = 0x7075
+
+// Encodings the zip reader may have transcoded legacy names from.
+// We need them to get back the original header bytes, since that's
+// what the Unicode Path CRC-32 is computed over.
+var legacyNameEncodings = []encoding.Encoding{
+	charmap.CodePage437,
+	japanese.ShiftJIS,
+}
+
+// unicodePathName returns the name stored in the Info-ZIP Unicode Path
+// extra field of zf, if there is one, and if its CRC-32 matches the
+// legacy name. Otherwise, it returns false.
+func unicodePathName(zf *zip.File) (string, bool) {
+	extra := zf.Extra
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[4:]
+		if len(extra) < size {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+
+		if tag != infoZipUnicodePathExtraID {
+			continue
+		}
+
+		// version (1 byte), name CRC-32 (4 bytes), UTF-8 name
+		if len(field) < 5 || field[0] != 1 {
+			continue
+		}
+		nameCRC := binary.LittleEndian.Uint32(field[1:5])
+		name := string(field[5:])
+		if name == "" || !utf8.ValidString(name) {
+			continue
+		}
+
+		if legacyNameMatches(zf.Name, nameCRC) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// legacyNameMatches returns true if the CRC-32 of the header name
+// is nameCRC. Since the zip reader might already have converted the
+// name to UTF-8, it also tries re-encoding it with the usual legacy
+// encodings.
+func legacyNameMatches(name string, nameCRC uint32) bool {
+	if crc32.ChecksumIEEE([]byte(name)) == nameCRC {
+		return true
+	}
+
+	for _, enc := range legacyNameEncodings {
+		raw, err := enc.NewEncoder().String(name)
+		if err != nil {
+			continue
+		}
+		if crc32.ChecksumIEEE([]byte(raw)) == nameCRC {
+			return true
+		}
+	}
+
+	return false
+}
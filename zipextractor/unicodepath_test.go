@@ -0,0 +1,72 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func unicodePathExtra(legacyName string, unicodeName string) []byte {
+	field := new(bytes.Buffer)
+	field.WriteByte(1)
+	binary.Write(field, binary.LittleEndian, crc32.ChecksumIEEE([]byte(legacyName)))
+	field.WriteString(unicodeName)
+
+	extra := new(bytes.Buffer)
+	binary.Write(extra, binary.LittleEndian, uint16(0x7075))
+	binary.Write(extra, binary.LittleEndian, uint16(field.Len()))
+	extra.Write(field.Bytes())
+	return extra.Bytes()
+}
+
+func TestUnicodePathExtra(t *testing.T) {
+	type testFile struct {
+		name  string
+		extra []byte
+	}
+
+	files := []testFile{
+		// valid unicode path field, should be used
+		{name: "legacy.txt", extra: unicodePathExtra("legacy.txt", "日本語.txt")},
+		// CRC-32 is for another name, should be ignored
+		{name: "stale.txt", extra: unicodePathExtra("something-else.txt", "ignored.txt")},
+		// CP-437 name (0x82 is 'é'), transcoded by the zip reader
+		{name: "caf\x82.txt", extra: unicodePathExtra("caf\x82.txt", "naïve-café.txt")},
+		// no extra field at all
+		{name: "plain.txt"},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   f.name,
+			Method: zip.Store,
+			Extra:  f.extra,
+		})
+		must(t, err)
+		_, err = w.Write([]byte(f.name))
+		must(t, err)
+	}
+	must(t, zw.Close())
+
+	zipBytes := buf.Bytes()
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+
+	var names []string
+	for _, entry := range ex.Entries() {
+		names = append(names, entry.CanonicalPath)
+	}
+	assert.EqualValues(t, []string{
+		"日本語.txt",
+		"stale.txt",
+		"naïve-café.txt",
+		"plain.txt",
+	}, names)
+}
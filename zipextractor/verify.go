@@ -0,0 +1,76 @@
+package zipextractor
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// ErrCRCMismatch is returned when the decompressed contents of an
+// entry don't match the CRC-32 recorded in the archive
+var ErrCRCMismatch = errors.New("crc32 mismatch")
+
+const (
+	defaultVerifyChunkSize = 4 * 1024 * 1024
+	// entries smaller than that are not worth verifying in parallel
+	minParallelVerifySize = 2 * defaultVerifyChunkSize
+)
+
+// SetVerifyConcurrency lets Verify() check the CRC-32 of large Store
+// (uncompressed) entries in parallel chunks, on up to `workers` goroutines.
+// Compressed entries are always verified sequentially.
+func (ze *ZipExtractor) SetVerifyConcurrency(workers int) {
+	ze.verifyConcurrency = workers
+}
+
+// Verify decompresses every entry of the archive (without writing them
+// anywhere) and checks their CRC-32. It stops at the first error.
+func (ze *ZipExtractor) Verify() error {
+	for _, zf := range ze.zr.File {
+		entry := zipFileEntry(zf)
+		if entry.Kind == savior.EntryKindDir || entry.IsDelta {
+			// delta entries can only be checked against their base
+			continue
+		}
+
+		err := ze.verifyEntry(zf)
+		if err != nil {
+			return errors.Wrapf(err, "%s", entry.CanonicalPath)
+		}
+	}
+	return nil
+}
+
+func (ze *ZipExtractor) verifyEntry(zf *zip.File) error {
+	size := int64(zf.UncompressedSize64)
+	if zf.Method == zip.Store && ze.verifyConcurrency > 1 && size >= minParallelVerifySize {
+		dataOff, err := zf.DataOffset()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		crc, err := parallelCRC32(ze.reader, dataOff, size, defaultVerifyChunkSize, ze.verifyConcurrency)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if crc != zf.CRC32 {
+			return errors.WithStack(ErrCRCMismatch)
+		}
+		return nil
+	}
+
+	rc, err := ze.openChecked(zf)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(ioutil.Discard, rc)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package zipextractor_test
+
+import (
+	"bytes"
+	"hash/crc32"
+	"testing"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelCRC32(t *testing.T) {
+	large := semirandom.Bytes(3*1024*1024 + 17)
+	small := large[:1000]
+
+	check := func(data []byte, chunkSizes []int64) {
+		for _, offset := range []int64{0, 1, 100} {
+			size := int64(len(data)) - offset
+			expected := crc32.ChecksumIEEE(data[offset:])
+
+			chunkSizes = append(chunkSizes, size, 2*size)
+			for _, chunkSize := range chunkSizes {
+				for _, workers := range []int{1, 3, 8} {
+					actual, err := zipextractor.ParallelCRC32(bytes.NewReader(data), offset, size, chunkSize, workers)
+					must(t, err)
+					assert.EqualValues(t, expected, actual, "offset %d, chunk size %d, %d workers", offset, chunkSize, workers)
+				}
+			}
+		}
+	}
+
+	check(small, []int64{1, 7, 128})
+	check(large, []int64{64 * 1024, 1024 * 1024})
+}
+
+func makeStoreZip(t testing.TB, size int64) ([]byte, []byte) {
+	data := semirandom.Bytes(size)
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   "big.bin",
+		Method: zip.Store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), data
+}
+
+func TestVerifyParallel(t *testing.T) {
+	zipBytes, data := makeStoreZip(t, 12*1024*1024)
+
+	for _, workers := range []int{0, 4} {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetVerifyConcurrency(workers)
+		must(t, ex.Verify())
+	}
+
+	// flip a byte in the middle of the entry
+	idx := bytes.Index(zipBytes, data[:64])
+	assert.True(t, idx > 0)
+	zipBytes[idx+len(data)/2] ^= 0xff
+
+	for _, workers := range []int{0, 4} {
+		ex := newTestZipExtractor(t, zipBytes)
+		ex.SetVerifyConcurrency(workers)
+		err := ex.Verify()
+		assert.Error(t, err)
+		assert.True(t, errors.Cause(err) == zipextractor.ErrCRCMismatch, "with %d workers", workers)
+	}
+}
+
+func benchmarkVerify(b *testing.B, workers int) {
+	zipBytes, _ := makeStoreZip(b, 64*1024*1024)
+	b.SetBytes(int64(len(zipBytes)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		ex.SetVerifyConcurrency(workers)
+		err = ex.Verify()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifySerial(b *testing.B) {
+	benchmarkVerify(b, 0)
+}
+
+func BenchmarkVerifyParallel(b *testing.B) {
+	benchmarkVerify(b, 8)
+}
@@ -1,51 +1,169 @@
 package zipextractor
 
 import (
+	"crypto"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/itchio/savior/flatesource"
-	"github.com/itchio/savior/seeksource"
-	"github.com/itchio/headway/united"
 	"github.com/itchio/headway/state"
+	"github.com/itchio/headway/united"
 
 	"github.com/itchio/arkive/zip"
 	"github.com/itchio/savior"
+	"github.com/itchio/savior/implodesource"
+	"github.com/itchio/savior/lzmasource"
 	"github.com/pkg/errors"
 )
 
 const defaultFlateThreshold = 1 * 1024 * 1024
 
+// errBuffered is used internally to signal that an entry went into the
+// reorder buffer instead of being written
+var errBuffered = errors.New("entry was buffered")
+
 type ZipExtractor struct {
+	// SkipMacOSXMetadata skips the `__MACOSX/` tree and `._*` files
+	// (AppleDouble metadata) that macOS Finder adds to the zips it
+	// creates. It must have the same value when resuming.
+	SkipMacOSXMetadata bool
+
 	zr *zip.Reader
 
-	reader io.ReaderAt
+	reader     io.ReaderAt
+	readerSize int64
 
-	saveConsumer savior.SaveConsumer
-	consumer     *state.Consumer
+	saveConsumer        savior.SaveConsumer
+	checkpointFilter    savior.CheckpointFilter
+	memoryLowWatermark  int64
+	memoryHighWatermark int64
+	consumer            *state.Consumer
 
 	flateThreshold int64
 	resumeSupport  savior.ResumeSupport
+
+	allowedExtensions []string
+	deniedExtensions  []string
+	extensionPolicy   ExtensionPolicy
+
+	pathFilter  *pathFilter
+	entryFilter savior.EntryFilter
+
+	commitGrouper CommitGrouper
+
+	reorderBufferSize int64
+
+	verifyConcurrency int
+
+	concurrency int
+
+	allowedContentTypes []string
+
+	base savior.Base
+
+	deadline time.Time
+
+	stepBudget int64
+
+	sourcePath string
+
+	strictHierarchy bool
+
+	manifestPath string
+	manifestAlgo crypto.Hash
+
+	archiveHashPath string
+	archiveHashAlgo crypto.Hash
+	archiveHash     []byte
+
+	previousManifest *Manifest
+	changes          *ManifestChanges
+
+	expectedHashes          map[string]string
+	unexpectedContentPolicy UnexpectedContentPolicy
+	unexpected              []string
+
+	errorPolicy ErrorPolicy
+	failed      []string
+
+	skipCRC32 bool
+
+	iterationOrder IterationOrder
+	entrySorter    EntrySorter
+
+	// central directory records of each entry, nil if they couldn't be read
+	central *centralDirectory
+
+	// set if the reader passed to New can prefetch
+	prefetcher savior.Prefetcher
+
+	events *savior.EventWriter
+
+	// access times only found in local headers, by entry index
+	localAccessTimes map[int64]time.Time
+	localTimesMu     sync.Mutex
+
+	// every directory of the archive, built the first time a symlink
+	// needs it, see linksToDir
+	archiveDirs map[string]bool
+
+	stats *Stats
 }
 
 var _ savior.Extractor = (*ZipExtractor)(nil)
 
 func New(reader io.ReaderAt, readerSize int64) (*ZipExtractor, error) {
+	stats := &Stats{}
+	prefetcher, _ := reader.(savior.Prefetcher)
+	reader = &countingReaderAt{r: reader, stats: stats}
+
 	zr, err := zip.NewReader(reader, readerSize)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	for _, f := range zr.File {
+		// sizes are int64 everywhere else, zip64 fields larger than
+		// that can only be lies
+		if f.UncompressedSize64 > math.MaxInt64 || f.CompressedSize64 > math.MaxInt64 {
+			return nil, errors.Wrapf(zip.ErrFormat, "%s: declared size is too large", f.Name)
+		}
+	}
 
 	ex := &ZipExtractor{
-		reader: reader,
-		zr:     zr,
+		reader:     reader,
+		readerSize: readerSize,
+		zr:         zr,
+		stats:      stats,
 
 		saveConsumer:  savior.NopSaveConsumer(),
 		consumer:      savior.NopConsumer(),
 		resumeSupport: savior.ResumeSupportBlock,
+		prefetcher:    prefetcher,
+	}
+
+	zr.RegisterDecompressor(zip.LZMA, func(r io.Reader, f *zip.File) io.ReadCloser {
+		return lzmasource.NewZipReader(r, int64(f.UncompressedSize64))
+	})
+	zr.RegisterDecompressor(MethodImplode, func(r io.Reader, f *zip.File) io.ReadCloser {
+		return implodesource.NewReader(r, int64(f.UncompressedSize64), f.Flags)
+	})
+	for method, dcomp := range decompressors {
+		if method == zip.Store || method == zip.Deflate {
+			continue
+		}
+		zr.RegisterDecompressor(method, dcomp)
+	}
+
+	cd, err := readCentralDirectory(reader, readerSize)
+	if err == nil && len(cd.records) == len(zr.File) {
+		ex.central = cd
+	} else {
+		savior.Debugf("zipextractor: not using internal attributes (%v)", err)
 	}
 
 	for _, f := range zr.File {
@@ -53,8 +171,16 @@ func New(reader io.ReaderAt, readerSize int64) (*ZipExtractor, error) {
 		case zip.Store, zip.Deflate:
 			// all good
 		case zip.LZMA:
-			// no block resume for you
-			ex.resumeSupport = savior.ResumeSupportEntry
+			if isRegisteredMethod(f.Method) {
+				// registered decompressors copy entries in one go
+				ex.resumeSupport = savior.ResumeSupportEntry
+			}
+		default:
+			if isDeltaMethod(f.Method) || isRegisteredMethod(f.Method) {
+				// delta entries are applied in one go, and entries
+				// compressed with registered methods are copied in one go
+				ex.resumeSupport = savior.ResumeSupportEntry
+			}
 		}
 	}
 
@@ -80,39 +206,332 @@ func (ze *ZipExtractor) FlateThreshold() int64 {
 	return defaultFlateThreshold
 }
 
+func (ze *ZipExtractor) SetEventWriter(w io.Writer) {
+	ze.events = savior.NewEventWriter(w)
+}
+
+func (ze *ZipExtractor) SetCheckpointFilter(filter savior.CheckpointFilter) {
+	ze.checkpointFilter = filter
+}
+
+func (ze *ZipExtractor) SetMemoryGovernor(lowWatermark int64, highWatermark int64) {
+	ze.memoryLowWatermark = lowWatermark
+	ze.memoryHighWatermark = highWatermark
+}
+
+func (ze *ZipExtractor) SetEntryFilter(filter savior.EntryFilter) {
+	ze.entryFilter = filter
+}
+
 func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink) (*savior.ExtractorResult, error) {
-	zr := ze.zr
+	ze.events.Start("zip", checkpoint)
+	res, err := ze.resume(checkpoint, sink, checkpoint == nil)
+	if err != nil {
+		ze.events.Error(err)
+		return nil, err
+	}
+	ze.events.Done(res)
+	return res, nil
+}
 
-	isFresh := false
+// resume extracts from checkpoint on. isFresh is true when there's no state
+// to stick to, ie. when starting over, or from an entry (see ResumeFromEntry).
+func (ze *ZipExtractor) resume(checkpoint *savior.ExtractorCheckpoint, sink savior.Sink, isFresh bool) (*savior.ExtractorResult, error) {
+	zr := ze.zr
+	destSink := sink
+	sink = &countingSink{Sink: sink, stats: ze.stats}
+	if ze.stepBudget > 0 {
+		sink = &budgetSink{Sink: sink, remaining: ze.stepBudget}
+	}
 
 	if checkpoint == nil {
-		isFresh = true
 		ze.consumer.Infof("→ Starting fresh extraction")
 		checkpoint = &savior.ExtractorCheckpoint{
 			EntryIndex: 0,
 		}
+	} else if isFresh {
+		ze.consumer.Infof("→ Starting fresh extraction @ entry %d", checkpoint.EntryIndex)
 	} else {
 		ze.consumer.Infof("↻ Resuming @ %.1f%%", checkpoint.Progress*100)
 	}
 
 	numEntries := int64(len(zr.File))
 
+	err := ze.resumePathFilter(checkpoint, isFresh)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = ze.checkHierarchy()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	selected, err := ze.selectEntries()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = ze.checkSourceCollision(selected, destSink)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var sniffed bool
+	var sniffRejected []int64
+	if state, ok := checkpoint.Data.(*ZipExtractorState); ok && state.Sniffed {
+		// stick to the decisions made when we started
+		sniffed = true
+		sniffRejected = state.SniffRejected
+	} else if ze.allowedContentTypes != nil {
+		sniffed = true
+		sniffRejected, err = ze.sniffEntries(selected)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	for _, index := range sniffRejected {
+		if index < 0 || index >= numEntries {
+			return nil, errors.Errorf("zipextractor: invalid sniffed entry %d in checkpoint", index)
+		}
+		selected[index] = false
+	}
+
+	var checkedHashes bool
+	var unexpected []int64
+	if state, ok := checkpoint.Data.(*ZipExtractorState); ok && state.ExpectedHashes {
+		// stick to the decisions made when we started
+		checkedHashes = true
+		unexpected = state.Unexpected
+	} else if ze.expectedHashes != nil {
+		if !isFresh {
+			return nil, errors.New("zipextractor: can't check expected hashes, checkpoint was taken without them")
+		}
+		checkedHashes = true
+		unexpected, err = ze.checkListedEntries(selected)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	for _, index := range unexpected {
+		if index < 0 || index >= numEntries {
+			return nil, errors.Errorf("zipextractor: invalid unexpected entry %d in checkpoint", index)
+		}
+		selected[index] = false
+	}
+	ze.unexpected = ze.entryPaths(unexpected)
+
+	var contents *contentChecker
+	if checkedHashes {
+		contents = &contentChecker{
+			hashes:   ze.expectedHashes,
+			destSink: destSink,
+		}
+		sink = &checkingSink{Sink: sink, cc: contents}
+	}
+
+	var failed []int64
+	if state, ok := checkpoint.Data.(*ZipExtractorState); ok {
+		for _, index := range state.Failed {
+			if index < 0 || index >= numEntries {
+				return nil, errors.Errorf("zipextractor: invalid failed entry %d in checkpoint", index)
+			}
+		}
+		failed = state.Failed
+	}
+	ze.failed = ze.entryPaths(failed)
+
+	// entries are walked in that order, checkpoint.EntryIndex is a position in it
+	err = ze.checkIterationOrder(checkpoint, isFresh)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	order, err := ze.walkOrder(checkpoint, isFresh)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	var doneBytes int64
 	var totalBytes int64
-	for i, zf := range zr.File {
-		size := int64(zf.UncompressedSize64)
+	for pos, i := range order {
+		if !selected[i] {
+			continue
+		}
+		size := int64(zr.File[i].UncompressedSize64)
 		totalBytes += size
-		if int64(i) < checkpoint.EntryIndex {
+		if int64(pos) < checkpoint.EntryIndex {
 			doneBytes += size
 		}
 	}
 
+	var reorder *reorderBuffer
+	if ze.reorderBufferSize > 0 {
+		reorder = newReorderBuffer(ze.reorderBufferSize, ze.openChecked)
+		if state, ok := checkpoint.Data.(*ZipExtractorState); ok {
+			for _, index := range state.PendingEntries {
+				if index < 0 || index >= numEntries {
+					return nil, errors.Errorf("zipextractor: invalid pending entry %d in checkpoint", index)
+				}
+				zf := zr.File[index]
+				entry := ze.entryAt(index)
+				data, err := reorder.load(zf)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				doneBytes -= int64(zf.UncompressedSize64)
+				if contents != nil {
+					err := contents.checkBytes(entry, data)
+					if err != nil {
+						err = ze.rejectEntry(entry, destSink, false, err)
+						if err != nil {
+							return nil, errors.WithStack(err)
+						}
+						unexpected = insertIndex(unexpected, index)
+						ze.unexpected = ze.entryPaths(unexpected)
+						continue
+					}
+				}
+				reorder.add(index, entry, data)
+			}
+		}
+	}
+
+	// fraction of the uncompressed bytes of selected entries that are
+	// written, counting what was before resuming
+	progress := func(done int64) float64 {
+		if totalBytes <= 0 {
+			return 0
+		}
+		return float64(done) / float64(totalBytes)
+	}
+	reportProgress := func(p float64) {
+		ze.consumer.Progress(p)
+		ze.events.Progress(p)
+	}
+	if checkpoint.Entry != nil {
+		// stopped in the middle of an entry
+		reportProgress(progress(doneBytes + checkpoint.Entry.WriteOffset))
+	} else {
+		reportProgress(progress(doneBytes))
+	}
+
+	var unchanged []int64
+	if ze.previousManifest != nil {
+		ze.changes = nil
+		if !isFresh {
+			state, ok := checkpoint.Data.(*ZipExtractorState)
+			if !ok || !state.PreviousManifest {
+				return nil, errors.New("zipextractor: can't compare to previous manifest, checkpoint was taken without one")
+			}
+			unchanged = state.Unchanged
+		}
+	}
+
+	// before updateState() replaces the checkpoint's state
+	var mf *manifest
+	if ze.manifestPath != "" {
+		mf, err = ze.openManifest(checkpoint, isFresh)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer mf.close()
+
+		sink = &manifestSink{
+			Sink: sink,
+			m:    mf,
+			openPrefix: func(entry *savior.Entry) (io.ReadCloser, error) {
+				// only the entry at EntryIndex can be resumed mid-way
+				return ze.openFile(zr.File[order[checkpoint.EntryIndex]], entry)
+			},
+		}
+	}
+
+	updateState := func() {
+		state := reorder.state()
+		if sniffed {
+			if state == nil {
+				state = &ZipExtractorState{}
+			}
+			state.Sniffed = true
+			state.SniffRejected = sniffRejected
+		}
+
+		if ze.iterationOrder != IterationForward {
+			if state == nil {
+				state = &ZipExtractorState{}
+			}
+			state.IterationOrder = ze.iterationOrder
+			if ze.iterationOrder == IterationCustom {
+				state.Order = order
+			}
+		}
+
+		if ze.previousManifest != nil {
+			if state == nil {
+				state = &ZipExtractorState{}
+			}
+			state.PreviousManifest = true
+			state.Unchanged = unchanged
+		}
+
+		if checkedHashes {
+			if state == nil {
+				state = &ZipExtractorState{}
+			}
+			state.ExpectedHashes = true
+			state.Unexpected = unexpected
+		}
+
+		if ze.pathFilter != nil {
+			if state == nil {
+				state = &ZipExtractorState{}
+			}
+			state.PathFilter = true
+			state.PathFilterPatterns = ze.pathFilter.patterns
+		}
+
+		if len(failed) > 0 {
+			if state == nil {
+				state = &ZipExtractorState{}
+			}
+			state.Failed = failed
+		}
+
+		if state != nil {
+			checkpoint.Data = state
+		} else {
+			checkpoint.Data = nil
+		}
+	}
+	updateState()
+
+	flushReorderBuffer := func() error {
+		var addErr error
+		err := reorder.flush(sink, func(pe *pendingEntry) {
+			doneBytes += pe.entry.UncompressedSize
+			if mf != nil && addErr == nil {
+				addErr = mf.add(pe.entry)
+			}
+			ze.events.EntryDone(pe.entry)
+			updateState()
+			reportProgress(progress(doneBytes))
+		})
+		if err != nil {
+			return err
+		}
+		return addErr
+	}
+
 	if isFresh {
 		ze.consumer.Infof("⇓ Pre-allocating %s on disk", united.FormatBytes(totalBytes))
 		preallocateStart := time.Now()
-		for _, zf := range zr.File {
-			entry := zipFileEntry(zf)
-			if entry.Kind == savior.EntryKindFile {
+		// entries before EntryIndex are already there
+		for _, i := range order[checkpoint.EntryIndex:] {
+			if !selected[i] {
+				continue
+			}
+			entry := zipFileEntry(zr.File[i])
+			if entry.Kind == savior.EntryKindFile && !entry.IsDelta {
 				err := sink.Preallocate(entry)
 				if err != nil {
 					return nil, errors.WithStack(err)
@@ -123,24 +542,88 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 		ze.consumer.Infof("⇒ Pre-allocated in %s, nothing can stop us now", preallocateDuration)
 	}
 
+	ze.prefetch(order, checkpoint.EntryIndex, selected)
+
+	groups, err := ze.newCommitGroups(destSink)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if groups != nil {
+		// entries before the checkpoint may not be committed yet
+		for pos := checkpoint.EntryIndex - 1; pos >= 0; pos-- {
+			if selected[order[pos]] {
+				groups.enter(zipFileEntry(zr.File[order[pos]]))
+				break
+			}
+		}
+	}
+
 	var stopError error
 
+	saveConsumer := savior.FilterSaveConsumer(ze.events.WrapSaveConsumer(ze.saveConsumer), ze.checkpointFilter)
+	saveConsumer = savior.GovernSaveConsumer(saveConsumer, ze.memoryLowWatermark, ze.memoryHighWatermark)
+	if mf != nil {
+		saveConsumer = &manifestSaveConsumer{
+			inner: saveConsumer,
+			m:     mf,
+		}
+	}
+	var deadline *deadlineSaveConsumer
+	if !ze.deadline.IsZero() {
+		deadline = &deadlineSaveConsumer{
+			inner:    saveConsumer,
+			deadline: ze.deadline,
+		}
+		saveConsumer = deadline
+	}
+
 	// allocate a copy buffer once
-	copier := savior.NewCopier(ze.saveConsumer)
+	copier := savior.NewCopier(saveConsumer)
 
-	for entryIndex := checkpoint.EntryIndex; entryIndex < numEntries && stopError == nil; entryIndex++ {
-		savior.Debugf(`doing entryIndex %d`, entryIndex)
+	concurrent := ze.startConcurrentReader(order, selected, destSink)
+	if concurrent != nil {
+		defer concurrent.close()
+	}
+
+	for pos := checkpoint.EntryIndex; pos < numEntries && stopError == nil; pos++ {
+		entryIndex := order[pos]
+		savior.Debugf(`doing entryIndex %d (position %d)`, entryIndex, pos)
 		zf := zr.File[entryIndex]
+		if !selected[entryIndex] {
+			continue
+		}
+
+		if concurrent != nil {
+			concurrent.prepare(pos, checkpoint.Entry != nil)
+		}
+
+		if groups != nil && groups.enter(zipFileEntry(zf)) {
+			if reorder != nil {
+				err := flushReorderBuffer()
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
+			err := groups.committer.CommitGroup()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		// set when the entry was decompressed ahead of time, it can
+		// only be checkpointed once it's written
+		var decoded savior.EntryWriter
 
 		err := func() error {
-			checkpoint.EntryIndex = entryIndex
+			checkpoint.EntryIndex = pos
 
 			if checkpoint.Entry == nil {
-				checkpoint.Entry = zipFileEntry(zf)
+				checkpoint.Entry = ze.entryAt(entryIndex)
 			}
 			entry := checkpoint.Entry
 
 			ze.consumer.Debugf("→ %s", entry)
+			ze.events.EntryStart(entry)
 
 			switch entry.Kind {
 			case savior.EntryKindDir:
@@ -149,7 +632,7 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					return errors.WithStack(err)
 				}
 			case savior.EntryKindSymlink:
-				rc, err := zf.Open()
+				rc, err := ze.openChecked(zf)
 				if err != nil {
 					return errors.WithStack(err)
 				}
@@ -161,41 +644,126 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					return errors.WithStack(err)
 				}
 
+				entry.LinkToDir = ze.linksToDir(entry, string(linkname))
 				err = sink.Symlink(entry, string(linkname))
 				if err != nil {
 					return errors.WithStack(err)
 				}
 			case savior.EntryKindFile:
-				var src savior.Source
+				if entry.IsDelta {
+					err := ze.extractDelta(zf, entry, sink)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					break
+				}
 
-				switch zf.Method {
-				case zip.Store, zip.Deflate:
-					dataOff, err := zf.DataOffset()
+				if ze.previousManifest != nil && contents == nil && entry.WriteOffset == 0 {
+					isUnchanged, err := ze.isUnchanged(zf, entry, destSink, mf)
 					if err != nil {
 						return errors.WithStack(err)
 					}
+					if isUnchanged {
+						ze.consumer.Debugf("= %s is unchanged", entry.CanonicalPath)
+						unchanged = append(unchanged, entryIndex)
+						updateState()
+						break
+					}
+				}
 
-					compressedSize := int64(zf.CompressedSize64)
+				if contents == nil && entry.WriteOffset == 0 {
+					reflinked, err := ze.reflink(zf, entry, destSink, mf)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					if reflinked {
+						ze.consumer.Debugf("⇉ %s is reflinked", entry.CanonicalPath)
+						break
+					}
+				}
 
-					reader := io.NewSectionReader(ze.reader, dataOff, compressedSize)
-					rawSource := seeksource.NewWithSize(reader, compressedSize)
+				var data []byte
+				var haveData bool
+				if concurrent != nil {
+					var err error
+					data, haveData, err = concurrent.takeEntry(entryIndex, entry)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+				}
 
-					switch zf.Method {
-					case zip.Store:
-						src = rawSource
-					case zip.Deflate:
-						src = flatesource.New(rawSource)
+				if reorder != nil && entry.WriteOffset == 0 && reorder.accepts(entry) {
+					if !reorder.fits(entry) {
+						err := flushReorderBuffer()
+						if err != nil {
+							return errors.WithStack(err)
+						}
+					}
+
+					if !haveData {
+						var err error
+						data, err = reorder.load(zf)
+						if err != nil {
+							return errors.WithStack(err)
+						}
+					}
+					if contents != nil {
+						// nothing's written yet, better check now
+						err := contents.checkBytes(entry, data)
+						if err != nil {
+							return err
+						}
+					}
+					reorder.add(entryIndex, entry, data)
+					updateState()
+
+					// we can only save on entry boundaries here
+					if saveConsumer.ShouldSave(entry.UncompressedSize) {
+						checkpoint.EntryIndex = pos + 1
+						checkpoint.Entry = nil
+						checkpoint.SourceCheckpoint = nil
+						checkpoint.Progress = progress(doneBytes)
+
+						atomic.AddInt64(&ze.stats.Checkpoints, 1)
+						action, err := saveConsumer.Save(checkpoint)
+						if err != nil {
+							return errors.WithStack(err)
+						}
+						if action == savior.AfterSaveStop {
+							stopError = savior.ErrStop
+						}
+					}
+
+					// will be counted when written
+					return errBuffered
+				}
+
+				if haveData {
+					var err error
+					decoded, err = writeDecoded(sink, entry, data, contents)
+					if err != nil {
+						return err
+					}
+					break
+				}
+
+				src, err := ze.entrySource(zf)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				if src != nil {
+					defer src.Close()
+					if !ze.skipCRC32 {
+						src = newCRCSource(src, zf)
 					}
-				default:
-					// will have to copy
 				}
 
 				if src == nil {
 					// save/resume not supported for this storage format
-					// (probably LZMA), doing a simple copy
+					// (delta entries, registered methods), doing a simple copy
 					entry.WriteOffset = 0
 
-					rc, err := zf.Open()
+					rc, err := ze.openFile(zf, entry)
 					if err != nil {
 						return errors.WithStack(err)
 					}
@@ -212,6 +780,28 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 						return errors.WithStack(err)
 					}
 				} else {
+					if entry.WriteOffset > 0 {
+						if er, ok := destSink.(savior.EntryRestarter); ok && er.NeedsRestart(entry) {
+							savior.Debugf(`%s: sink can't resume this entry, starting it over`, entry.CanonicalPath)
+							checkpoint.SourceCheckpoint = nil
+							entry.WriteOffset = 0
+						} else if contents != nil && contents.needsRestart(entry) {
+							savior.Debugf(`%s: can't check this entry from the middle, starting it over`, entry.CanonicalPath)
+							checkpoint.SourceCheckpoint = nil
+							entry.WriteOffset = 0
+						}
+					}
+
+					lowered, err := savior.ReconcileWriteOffset(destSink, entry)
+					if err != nil {
+						return errors.WithStack(err)
+					}
+					if lowered && checkpoint.SourceCheckpoint != nil && checkpoint.SourceCheckpoint.OutputOffset > entry.WriteOffset {
+						// the source would resume past what the sink holds,
+						// start reading the entry over and discard up to it
+						checkpoint.SourceCheckpoint = nil
+					}
+
 					offset, err := src.Resume(checkpoint.SourceCheckpoint)
 					if err != nil {
 						return errors.WithStack(err)
@@ -234,8 +824,7 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					}
 
 					computeProgress := func() float64 {
-						actualDoneBytes := doneBytes + entry.WriteOffset
-						return float64(actualDoneBytes) / float64(totalBytes)
+						return progress(doneBytes + entry.WriteOffset)
 					}
 
 					src.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
@@ -253,7 +842,8 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 
 							checkpoint.Progress = computeProgress()
 
-							action, err := ze.saveConsumer.Save(checkpoint)
+							atomic.AddInt64(&ze.stats.Checkpoints, 1)
+							action, err := saveConsumer.Save(checkpoint)
 							if err != nil {
 								return errors.WithStack(err)
 							}
@@ -274,7 +864,7 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 						Savable: src,
 
 						EmitProgress: func() {
-							ze.consumer.Progress(computeProgress())
+							reportProgress(computeProgress())
 						},
 					})
 					if err != nil {
@@ -282,25 +872,138 @@ func (ze *ZipExtractor) Resume(checkpoint *savior.ExtractorCheckpoint, sink savi
 					}
 				}
 			}
+			if contents != nil && entry.Kind == savior.EntryKindFile && stopError == nil {
+				err := contents.check(entry)
+				if err != nil {
+					return err
+				}
+			}
 			doneBytes += int64(zf.UncompressedSize64)
 
 			return nil
 		}()
+		entryDone := err == nil && stopError == nil
+		if err == errBuffered {
+			err = nil
+		}
+		if err != nil && contents != nil && errors.Cause(err) == ErrUnexpectedContent {
+			err = ze.rejectEntry(checkpoint.Entry, destSink, contents.writing(checkpoint.Entry), err)
+			if err == nil {
+				unexpected = insertIndex(unexpected, entryIndex)
+				ze.unexpected = ze.entryPaths(unexpected)
+				doneBytes += int64(zf.UncompressedSize64)
+				updateState()
+			}
+		}
+		if err != nil && ze.errorPolicy == PolicyContinueOnEntryError && isEntryError(err) {
+			err = ze.skipFailedEntry(checkpoint.Entry, destSink, err)
+			if err == nil {
+				failed = append(failed, entryIndex)
+				ze.failed = append(ze.failed, checkpoint.Entry.CanonicalPath)
+				doneBytes += int64(zf.UncompressedSize64)
+				updateState()
+			}
+		}
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 
+		if entryDone {
+			ze.events.EntryDone(checkpoint.Entry)
+			// entries that aren't copied (directories, symlinks, unchanged
+			// files...) don't report progress otherwise
+			reportProgress(progress(doneBytes))
+			if mf != nil {
+				err := mf.add(checkpoint.Entry)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
+		}
+
 		checkpoint.SourceCheckpoint = nil
 		checkpoint.Entry = nil
+
+		atBoundary := deadline != nil && deadline.past()
+		if decoded != nil && entryDone && saveConsumer.ShouldSave(int64(zf.UncompressedSize64)) {
+			atBoundary = true
+		}
+		if stopError == nil && pos+1 < numEntries && atBoundary {
+			// entry boundary, the only place we can stop for directories,
+			// symlinks, entries that can't be block-resumed and entries
+			// decompressed ahead of time
+			if decoded != nil {
+				err := decoded.Sync()
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
+
+			checkpoint.EntryIndex = pos + 1
+			checkpoint.Progress = progress(doneBytes)
+
+			atomic.AddInt64(&ze.stats.Checkpoints, 1)
+			action, err := saveConsumer.Save(checkpoint)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if action == savior.AfterSaveStop {
+				stopError = savior.ErrStop
+			}
+		}
 	}
 
 	if stopError != nil {
+		if deadline != nil && deadline.exceeded {
+			return nil, errors.WithStack(ErrDeadlineExceeded)
+		}
 		return nil, savior.ErrStop
 	}
 
+	if reorder != nil {
+		err := flushReorderBuffer()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if groups != nil {
+		err := groups.committer.CommitGroup()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	reportProgress(1)
+
+	if mf != nil {
+		err := mf.finalize()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if ze.previousManifest != nil {
+		ze.changes = ze.computeChanges(selected, unchanged)
+	}
+
+	if ze.archiveHashAlgo != 0 {
+		err := ze.hashArchive()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return nil, entriesFailedError(ze.failed)
+	}
+
 	res := &savior.ExtractorResult{}
-	for _, zf := range zr.File {
-		res.Entries = append(res.Entries, zipFileEntry(zf))
+	for i := range zr.File {
+		if !selected[i] {
+			continue
+		}
+		res.Entries = append(res.Entries, ze.entryAt(int64(i)))
 	}
 
 	return res, nil
@@ -317,25 +1020,54 @@ func (ze *ZipExtractor) Features() savior.ExtractorFeatures {
 	}
 }
 
+// Entries lists the entries of the archive from its central directory,
+// without reading any of their contents, so symlinks don't have a Linkname.
+// Filters (see SetPathFilterPatterns, SetAllowedExtensions) aren't applied.
 func (ze *ZipExtractor) Entries() []*savior.Entry {
 	var entries []*savior.Entry
-	for _, zf := range ze.zr.File {
-		entries = append(entries, zipFileEntry(zf))
+	for i := range ze.zr.File {
+		entries = append(entries, ze.entryAt(int64(i)))
 	}
 	return entries
 }
 
+// ListEntries is Entries, to implement savior.Extractor. It never fails.
+func (ze *ZipExtractor) ListEntries() ([]*savior.Entry, error) {
+	return ze.Entries(), nil
+}
+
+// entryAt returns the entry for the index-th file of the archive,
+// including information only found in the central directory
+func (ze *ZipExtractor) entryAt(index int64) *savior.Entry {
+	entry := zipFileEntry(ze.zr.File[index])
+	if ze.central != nil && entry.Kind == savior.EntryKindFile {
+		entry.IsText = ze.central.records[index].internalAttrs&internalAttrText != 0
+	}
+	entry.AccessTime = ze.accessTime(index)
+	return entry
+}
+
 func zipFileEntry(zf *zip.File) *savior.Entry {
+	name := zf.Name
+	if unicodeName, ok := unicodePathName(zf); ok {
+		// prefer the UTF-8 name, when the archive has one
+		name = unicodeName
+	}
+
 	entry := &savior.Entry{
-		CanonicalPath:    filepath.ToSlash(zf.Name),
+		CanonicalPath:    filepath.ToSlash(name),
 		CompressedSize:   int64(zf.CompressedSize64),
 		UncompressedSize: int64(zf.UncompressedSize64),
 		Mode:             zf.Mode(),
+		IsDelta:          isDeltaMethod(zf.Method),
+		ModTime:          zf.Modified,
 	}
 
 	info := zf.FileInfo()
 
-	if info.IsDir() {
+	if info.IsDir() || savior.IsDirectoryPath(entry.CanonicalPath) {
+		// a trailing slash always means directory
+		entry.Mode = entry.Mode&^os.ModeSymlink | os.ModeDir
 		entry.Kind = savior.EntryKindDir
 	} else if entry.Mode&os.ModeSymlink > 0 {
 		entry.Kind = savior.EntryKindSymlink
@@ -2,17 +2,24 @@ package zipextractor_test
 
 import (
 	"bytes"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/itchio/arkive/zip"
 	"github.com/itchio/headway/united"
 	"github.com/itchio/savior"
 	"github.com/itchio/savior/checker"
 	"github.com/itchio/savior/zipextractor"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
-func must(t *testing.T, err error) {
+func must(t testing.TB, err error) {
 	assert.NoError(t, err)
 	if err != nil {
 		t.FailNow()
@@ -48,3 +55,181 @@ func TestZip(t *testing.T) {
 		return i%2 == 0
 	})
 }
+
+type testZipEntry struct {
+	Name     string
+	Data     []byte
+	Mode     os.FileMode
+	Method   uint16
+	Modified time.Time
+	Extra    []byte
+	Flags    uint16
+}
+
+// makeTestZip builds a zip in memory out of a list of entries. Entries
+// with a trailing slash are directories.
+func makeTestZip(t testing.TB, entries []testZipEntry) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, e := range entries {
+		fh := &zip.FileHeader{
+			Name:     e.Name,
+			Method:   e.Method,
+			Modified: e.Modified,
+			Extra:    e.Extra,
+			Flags:    e.Flags,
+		}
+
+		mode := e.Mode
+		if mode == 0 {
+			if strings.HasSuffix(e.Name, "/") {
+				mode = os.ModeDir | 0755
+			} else {
+				mode = 0644
+			}
+		}
+		fh.SetMode(mode)
+
+		w, err := zw.CreateHeader(fh)
+		must(t, err)
+		_, err = w.Write(e.Data)
+		must(t, err)
+	}
+	must(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+// extractTestZip extracts a zip to a fresh temporary folder
+// and returns its path.
+func extractTestZip(t *testing.T, ex *zipextractor.ZipExtractor) (string, error) {
+	dir, err := ioutil.TempDir("", "zipextractor-test")
+	must(t, err)
+
+	sink := &savior.FolderSink{
+		Directory: dir,
+		Consumer:  savior.NopConsumer(),
+	}
+	defer sink.Close()
+
+	_, err = ex.Resume(nil, sink)
+	return dir, err
+}
+
+func newTestZipExtractor(t *testing.T, zipBytes []byte) *zipextractor.ZipExtractor {
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+	return ex
+}
+
+func TestZipEmptySymlink(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "before.txt", Data: []byte("before")},
+		{Name: "empty-link", Mode: os.ModeSymlink | 0644},
+		{Name: "after.txt", Data: []byte("after")},
+	})
+
+	dir, err := extractTestZip(t, newTestZipExtractor(t, zipBytes))
+	defer os.RemoveAll(dir)
+	assert.Error(err)
+	assert.True(errors.Cause(err) == savior.ErrEmptySymlinkTarget)
+
+	dir2, err := ioutil.TempDir("", "zipextractor-test")
+	must(t, err)
+	defer os.RemoveAll(dir2)
+
+	sink := &savior.FolderSink{
+		Directory:         dir2,
+		Consumer:          savior.NopConsumer(),
+		SkipEmptySymlinks: true,
+	}
+	_, err = newTestZipExtractor(t, zipBytes).Resume(nil, sink)
+	must(t, err)
+	must(t, sink.Close())
+
+	_, err = os.Lstat(filepath.Join(dir2, "empty-link"))
+	assert.True(os.IsNotExist(err))
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir2, "after.txt"))
+	must(t, err)
+	assert.EqualValues("after", string(bs))
+}
+
+func TestZipSlip(t *testing.T) {
+	for _, entries := range [][]testZipEntry{
+		{
+			{Name: "fine.txt", Data: []byte("fine")},
+			{Name: "../evil.txt", Data: []byte("evil")},
+		},
+		{
+			{Name: "link", Data: []byte("/tmp"), Mode: os.ModeSymlink | 0644},
+		},
+		{
+			{Name: "dir/../../evil.txt", Data: []byte("evil")},
+		},
+		{
+			{Name: "dir/link", Data: []byte("../.."), Mode: os.ModeSymlink | 0644},
+		},
+	} {
+		dir, err := extractTestZip(t, newTestZipExtractor(t, makeTestZip(t, entries)))
+		os.RemoveAll(dir)
+		assert.Error(t, err)
+		assert.EqualValues(t, savior.ErrUnsafePath, errors.Cause(err))
+	}
+}
+
+func TestZipSpecialFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dev/sda", Mode: os.ModeDevice | 0660},
+		{Name: "fifo", Mode: os.ModeNamedPipe | 0644},
+		{Name: "after.txt", Data: []byte("after")},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	for _, entry := range ex.Entries() {
+		if entry.CanonicalPath != "after.txt" {
+			assert.True(entry.Mode&(os.ModeDevice|os.ModeNamedPipe) != 0, "%s should keep its type bits", entry.CanonicalPath)
+		}
+	}
+
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	must(t, err)
+
+	for _, name := range []string{"dev/sda", "fifo"} {
+		_, err = os.Lstat(filepath.Join(dir, name))
+		assert.True(os.IsNotExist(err), "%s should be skipped", name)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "after.txt"))
+	must(t, err)
+	assert.EqualValues("after", string(bs))
+}
+
+func TestZipModTime(t *testing.T) {
+	assert := assert.New(t)
+
+	modTime := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+	zipBytes := makeTestZip(t, []testZipEntry{
+		{Name: "dir/", Modified: modTime},
+		{Name: "dir/file.txt", Data: []byte("old news"), Modified: modTime},
+	})
+
+	ex := newTestZipExtractor(t, zipBytes)
+	for _, entry := range ex.Entries() {
+		assert.True(modTime.Equal(entry.ModTime), "%s should have a ModTime", entry.CanonicalPath)
+	}
+
+	dir, err := extractTestZip(t, ex)
+	defer os.RemoveAll(dir)
+	must(t, err)
+
+	stats, err := os.Stat(filepath.Join(dir, "dir", "file.txt"))
+	must(t, err)
+	assert.True(modTime.Equal(stats.ModTime()))
+}
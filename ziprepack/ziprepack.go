@@ -0,0 +1,127 @@
+// Package ziprepack extracts archives and writes them back out as zip
+// files that only depend on their contents: entries are sorted, timestamps
+// are fixed, and no extra fields are written, so the same contents always
+// give the same bytes.
+package ziprepack
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// Settings controls how entries are written
+type Settings struct {
+	// Method is the compression method of files: zip.Store (the zero value)
+	// or zip.Deflate. Directories and symlinks are always stored.
+	Method uint16
+	// Compression is used for Deflate, zip.DefaultCompressionSettings()
+	// if nil. Output only stays the same for the same settings.
+	Compression *zip.CompressionSettings
+	// ModTime is the modification time of every entry, in UTC, at the
+	// two-second resolution zip has. Times before 1980 (the zero value
+	// included) are stored as 1980-01-01, the earliest zip can store.
+	ModTime time.Time
+}
+
+// Repack extracts everything `ex` has (from the start) in memory, and
+// writes it to `w` as a zip. Entries are sorted by path, hardlinks are
+// written as regular files, and only permission bits are kept from modes.
+func Repack(ex savior.Extractor, w io.Writer, settings Settings) error {
+	if settings.Method != zip.Store && settings.Method != zip.Deflate {
+		return errors.Errorf("ziprepack: unsupported compression method %d", settings.Method)
+	}
+
+	sink := savior.NewMemorySink()
+	_, err := ex.Resume(nil, sink)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	zw := zip.NewWriter(w)
+	if settings.Compression != nil {
+		err = zw.SetCompressionSettings(*settings.Compression)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// sort by the names entries are written with: whether directories
+	// had a trailing slash in the input mustn't change the order
+	names := make(map[string]string)
+	var sorted []string
+	for _, p := range sink.Paths() {
+		_, entry, _ := sink.GetEntry(p)
+		name := strings.TrimSuffix(p, "/")
+		if entry.Kind == savior.EntryKindDir {
+			name += "/"
+		}
+		names[name] = p
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	modDate, modTime := msDosTime(settings.ModTime)
+	for _, name := range sorted {
+		data, entry, _ := sink.GetEntry(names[name])
+
+		fh := &zip.FileHeader{
+			Name:         name,
+			ModifiedDate: modDate,
+			ModifiedTime: modTime,
+		}
+		perm := entry.Mode.Perm()
+
+		switch entry.Kind {
+		case savior.EntryKindDir:
+			if perm == 0 {
+				perm = 0755
+			}
+			fh.SetMode(os.ModeDir | perm)
+		case savior.EntryKindSymlink:
+			fh.SetMode(os.ModeSymlink | 0777)
+		default:
+			if perm == 0 {
+				perm = 0644
+			}
+			fh.SetMode(perm)
+			fh.Method = settings.Method
+		}
+
+		ew, err := zw.CreateHeader(fh)
+		if err != nil {
+			return errors.Wrapf(err, "ziprepack: adding %s", fh.Name)
+		}
+		_, err = ew.Write(data)
+		if err != nil {
+			return errors.Wrapf(err, "ziprepack: writing %s", fh.Name)
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// msDosTime returns the MS-DOS date and time zip headers store, for t
+// in UTC, clamped to the range they can represent
+func msDosTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if t.Year() > 2107 {
+		t = time.Date(2107, 12, 31, 23, 59, 58, 0, time.UTC)
+	}
+
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
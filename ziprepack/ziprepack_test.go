@@ -0,0 +1,120 @@
+package ziprepack_test
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/savior/zipextractor"
+	"github.com/itchio/savior/ziprepack"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t testing.TB, err error) {
+	if err != nil {
+		t.Helper()
+		t.Fatalf("%+v", err)
+	}
+}
+
+func repackZip(t *testing.T, zipBytes []byte, settings ziprepack.Settings) []byte {
+	ex, err := zipextractor.New(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	must(t, err)
+
+	buf := new(bytes.Buffer)
+	must(t, ziprepack.Repack(ex, buf, settings))
+	return buf.Bytes()
+}
+
+func Test_Repack(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	settings := ziprepack.Settings{
+		Method:  zip.Deflate,
+		ModTime: time.Date(2020, 6, 18, 12, 30, 0, 0, time.UTC),
+	}
+	repacked := repackZip(t, zipBytes, settings)
+	assert.EqualValues(repacked, repackZip(t, zipBytes, settings))
+
+	// the same contents, from a tar this time
+	tarBytes := checker.MakeTar(t, sink)
+	buf := new(bytes.Buffer)
+	ex := tarextractor.New(seeksource.FromBytes(tarBytes))
+	must(t, ziprepack.Repack(ex, buf, settings))
+	assert.EqualValues(repacked, buf.Bytes())
+
+	// repacking is idempotent
+	assert.EqualValues(repacked, repackZip(t, repacked, settings))
+
+	// and the repacked zip has everything in it, sorted
+	zr, err := zip.NewReader(bytes.NewReader(repacked), int64(len(repacked)))
+	must(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		assert.Empty(f.Extra)
+		assert.EqualValues(settings.ModTime, f.ModTime())
+	}
+	assert.True(sort.StringsAreSorted(names))
+
+	checkContents(t, sink, repacked)
+}
+
+func Test_RepackStore(t *testing.T) {
+	assert := assert.New(t)
+
+	sink := checker.MakeTestSink()
+	zipBytes := checker.MakeZip(t, sink)
+
+	repacked := repackZip(t, zipBytes, ziprepack.Settings{})
+
+	zr, err := zip.NewReader(bytes.NewReader(repacked), int64(len(repacked)))
+	must(t, err)
+	for _, f := range zr.File {
+		assert.EqualValues(zip.Store, f.Method)
+		assert.EqualValues(1980, f.ModTime().Year())
+	}
+
+	checkContents(t, sink, repacked)
+}
+
+// checkContents extracts a repacked zip, and checks it has
+// everything the test sink has
+func checkContents(t *testing.T, sink *checker.Sink, repacked []byte) {
+	assert := assert.New(t)
+
+	ex, err := zipextractor.New(bytes.NewReader(repacked), int64(len(repacked)))
+	must(t, err)
+	ms := savior.NewMemorySink()
+	_, err = ex.Resume(nil, ms)
+	must(t, err)
+
+	for _, item := range sink.Items {
+		switch item.Entry.Kind {
+		case savior.EntryKindDir:
+			// directories are written with a trailing slash
+			_, entry, ok := ms.GetEntry(item.Entry.CanonicalPath + "/")
+			assert.True(ok, item.Entry.CanonicalPath)
+			assert.EqualValues(savior.EntryKindDir, entry.Kind)
+		case savior.EntryKindSymlink:
+			data, entry, ok := ms.GetEntry(item.Entry.CanonicalPath)
+			assert.True(ok, item.Entry.CanonicalPath)
+			assert.EqualValues(savior.EntryKindSymlink, entry.Kind)
+			assert.EqualValues(item.Entry.Linkname, string(data))
+		default:
+			data, _, ok := ms.GetEntry(item.Entry.CanonicalPath)
+			assert.True(ok, item.Entry.CanonicalPath)
+			assert.True(bytes.Equal(item.Data, data), item.Entry.CanonicalPath)
+		}
+	}
+	assert.EqualValues(len(sink.Items), len(ms.Paths()))
+}
@@ -0,0 +1,171 @@
+package zstdsource
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	frameMagic = 0xFD2FB528
+
+	// skippable frames have magics 0x184D2A50 to 0x184D2A5F
+	skippableMagicMask = 0xFFFFFFF0
+	skippableMagic     = 0x184D2A50
+
+	blockTypeRLE      = 1
+	blockTypeReserved = 3
+)
+
+// frameHeaderSize returns the size of the frame header that follows
+// the magic and descriptor, given the descriptor
+func frameHeaderSize(descriptor byte) int {
+	fcsFlag := descriptor >> 6
+	singleSegment := descriptor&0x20 != 0
+	dictIDFlag := descriptor & 0x3
+
+	size := 0
+	if !singleSegment {
+		// window descriptor
+		size++
+	}
+	size += []int{0, 1, 2, 4}[dictIDFlag]
+	switch fcsFlag {
+	case 0:
+		if singleSegment {
+			size++
+		}
+	case 1:
+		size += 2
+	case 2:
+		size += 4
+	case 3:
+		size += 8
+	}
+	return size
+}
+
+// frameReader passes a single zstd frame through, and returns io.EOF at
+// its end, without reading anything past it. It parses just enough of
+// the frame (block headers) to know where it ends.
+type frameReader struct {
+	r io.Reader
+
+	// bytes already read from r, not yet returned
+	buf []byte
+	// bytes left to pass through from r after buf
+	remaining int64
+
+	// set once the last block's header was read
+	lastBlock bool
+	checksum  bool
+
+	blockHeader [3]byte
+}
+
+// newFrameReader returns a reader for the frame whose magic and header
+// were read already (they're returned first).
+func newFrameReader(r io.Reader, header []byte) *frameReader {
+	return &frameReader{
+		r:        r,
+		buf:      header,
+		checksum: header[4]&0x4 != 0,
+	}
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for {
+		if len(fr.buf) > 0 {
+			n := copy(p, fr.buf)
+			fr.buf = fr.buf[n:]
+			return n, nil
+		}
+
+		if fr.remaining > 0 {
+			if int64(len(p)) > fr.remaining {
+				p = p[:fr.remaining]
+			}
+			n, err := fr.r.Read(p)
+			fr.remaining -= int64(n)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+
+		if fr.lastBlock {
+			if fr.checksum {
+				fr.checksum = false
+				fr.remaining = 4
+				continue
+			}
+			return 0, io.EOF
+		}
+
+		_, err := io.ReadFull(fr.r, fr.blockHeader[:])
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, errors.WithStack(err)
+		}
+		header := uint32(fr.blockHeader[0]) | uint32(fr.blockHeader[1])<<8 | uint32(fr.blockHeader[2])<<16
+
+		fr.lastBlock = header&1 != 0
+		switch (header >> 1) & 0x3 {
+		case blockTypeRLE:
+			fr.remaining = 1
+		case blockTypeReserved:
+			return 0, errors.New("zstdsource: reserved block type (corrupted stream?)")
+		default:
+			fr.remaining = int64(header >> 3)
+		}
+		fr.buf = fr.blockHeader[:]
+	}
+}
+
+// readFrameHeader reads the magic and header of the next frame, skipping
+// skippable frames. It returns io.EOF if there are no more frames.
+func readFrameHeader(r io.Reader) ([]byte, error) {
+	for {
+		magic := make([]byte, 5)
+		_, err := io.ReadFull(r, magic[:4])
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		m := binary.LittleEndian.Uint32(magic)
+		if m&skippableMagicMask == skippableMagic {
+			var size [4]byte
+			_, err = io.ReadFull(r, size[:])
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			_, err = io.CopyN(ioutil.Discard, r, int64(binary.LittleEndian.Uint32(size[:])))
+			if err != nil {
+				return nil, errors.Wrap(err, "skipping skippable frame")
+			}
+			continue
+		}
+		if m != frameMagic {
+			return nil, errors.Errorf("zstdsource: invalid frame magic %x (not a zstd stream?)", m)
+		}
+
+		_, err = io.ReadFull(r, magic[4:])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		header := make([]byte, 5+frameHeaderSize(magic[4]))
+		copy(header, magic)
+		_, err = io.ReadFull(r, header[5:])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return header, nil
+	}
+}
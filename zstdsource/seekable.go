@@ -0,0 +1,239 @@
+package zstdsource
+
+import (
+	"io"
+	"sync"
+
+	"github.com/itchio/savior"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// SeekableSource reads a stream in the zstd seekable format: a series of
+// independent zstd frames, followed by a seek table. Since it knows where
+// each frame starts, it can resume at any offset by decoding a single
+// frame, instead of decoding the stream from the start.
+type SeekableSource struct {
+	r     io.ReaderAt
+	table *SeekTable
+
+	// output offset of the next Read
+	offset int64
+
+	ssc      savior.SourceSaveConsumer
+	wantSave bool
+	started  bool
+
+	// guards the decoder and the frame cache, since ReadAt
+	// can be called concurrently
+	mu      sync.Mutex
+	decoder *zstd.Decoder
+	// index of the frame in `frame`, -1 if none
+	frameIndex int
+	frame      []byte
+	compressed []byte
+}
+
+var _ savior.Source = (*SeekableSource)(nil)
+var _ io.ReaderAt = (*SeekableSource)(nil)
+
+// NewSeekable returns a source that reads a stream in the zstd seekable format
+// from an io.ReaderAt of `size` bytes. It returns ErrNoSeekTable if the stream
+// has no seek table. The reader belongs to the caller, and isn't closed by Close.
+func NewSeekable(r io.ReaderAt, size int64) (*SeekableSource, error) {
+	table, err := ReadSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SeekableSource{
+		r:          r,
+		table:      table,
+		frameIndex: -1,
+	}, nil
+}
+
+// SeekTable returns the frames of the stream
+func (ss *SeekableSource) SeekTable() *SeekTable {
+	return ss.table
+}
+
+// Size returns the size of the decompressed stream
+func (ss *SeekableSource) Size() int64 {
+	return ss.table.DecompressedSize()
+}
+
+func (ss *SeekableSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "zstd-seekable",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (ss *SeekableSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	ss.ssc = ssc
+}
+
+func (ss *SeekableSource) WantSave() {
+	ss.wantSave = true
+}
+
+// Resume jumps to the checkpoint's output offset. Nothing is decoded
+// until the next Read, and only the frame that contains it is.
+func (ss *SeekableSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	ss.started = true
+	ss.wantSave = false
+
+	if checkpoint == nil {
+		ss.offset = 0
+		return 0, nil
+	}
+
+	if checkpoint.OutputOffset < 0 || checkpoint.OutputOffset > ss.Size() {
+		return 0, errors.Errorf("zstdsource: can't resume at %d, stream is %d bytes (corrupted checkpoint?)", checkpoint.OutputOffset, ss.Size())
+	}
+	ss.offset = checkpoint.OutputOffset
+	savior.Debugf("zstdsource: resuming at %d, in frame %d", ss.offset, ss.table.FrameAt(ss.offset))
+	return ss.offset, nil
+}
+
+func (ss *SeekableSource) Read(buf []byte) (int, error) {
+	if !ss.started {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	if ss.wantSave && ss.ssc != nil {
+		ss.wantSave = false
+		err := ss.save()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := ss.ReadAt(buf, ss.offset)
+	ss.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (ss *SeekableSource) ReadByte() (byte, error) {
+	var buf [1]byte
+	for {
+		n, err := ss.Read(buf[:])
+		if n == 1 {
+			return buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// save can happen anywhere, resuming decodes the frame again
+func (ss *SeekableSource) save() error {
+	var compressedOffset int64
+	if i := ss.table.FrameAt(ss.offset); i < len(ss.table.Frames) {
+		compressedOffset = ss.table.Frames[i].CompressedOffset
+	} else {
+		compressedOffset = ss.table.CompressedSize()
+	}
+
+	checkpoint := &savior.SourceCheckpoint{
+		Offset:       compressedOffset,
+		OutputOffset: ss.offset,
+	}
+	savior.Debugf("zstdsource: saving at %d", ss.offset)
+	return ss.ssc.Save(checkpoint)
+}
+
+// ReadAt reads decompressed data at `off`, decoding only the frames
+// it spans. It doesn't change the position of the source.
+func (ss *SeekableSource) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("zstdsource: negative offset")
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	n := 0
+	for n < len(buf) {
+		i := ss.table.FrameAt(off + int64(n))
+		if i >= len(ss.table.Frames) {
+			return n, io.EOF
+		}
+
+		err := ss.decodeFrame(i)
+		if err != nil {
+			return n, err
+		}
+
+		f := ss.table.Frames[i]
+		n += copy(buf[n:], ss.frame[off+int64(n)-f.DecompressedOffset:])
+	}
+	return n, nil
+}
+
+// decodeFrame decodes frame i into ss.frame, unless it's already there
+func (ss *SeekableSource) decodeFrame(i int) error {
+	if ss.frameIndex == i {
+		return nil
+	}
+	ss.frameIndex = -1
+
+	if ss.decoder == nil {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		ss.decoder = decoder
+	}
+
+	f := ss.table.Frames[i]
+	if int64(cap(ss.compressed)) < f.CompressedSize {
+		ss.compressed = make([]byte, f.CompressedSize)
+	}
+	compressed := ss.compressed[:f.CompressedSize]
+	_, err := ss.r.ReadAt(compressed, f.CompressedOffset)
+	if err != nil {
+		return errors.Wrapf(err, "reading frame %d", i)
+	}
+
+	ss.frame, err = ss.decoder.DecodeAll(compressed, ss.frame[:0])
+	if err != nil {
+		return errors.Wrapf(err, "decoding frame %d", i)
+	}
+	if int64(len(ss.frame)) != f.DecompressedSize {
+		return errors.Errorf("zstdsource: frame %d decoded to %d bytes, seek table says %d", i, len(ss.frame), f.DecompressedSize)
+	}
+
+	savior.Debugf("zstdsource: decoded frame %d (%d => %d bytes)", i, f.CompressedSize, f.DecompressedSize)
+	ss.frameIndex = i
+	return nil
+}
+
+func (ss *SeekableSource) Progress() float64 {
+	size := ss.Size()
+	if size == 0 {
+		return 1
+	}
+	return float64(ss.offset) / float64(size)
+}
+
+// Close releases the decoder and the decoded frame. The source can
+// still be resumed afterwards.
+func (ss *SeekableSource) Close() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.decoder != nil {
+		ss.decoder.Close()
+		ss.decoder = nil
+	}
+	ss.frame = nil
+	ss.compressed = nil
+	ss.frameIndex = -1
+	return nil
+}
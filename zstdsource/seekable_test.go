@@ -0,0 +1,179 @@
+package zstdsource_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zstdsource"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func must(t *testing.T, err error) {
+	assert.NoError(t, err)
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+// makeSeekable compresses data as a stream in the zstd seekable format,
+// with one frame per `frameSize` bytes, and an empty skippable frame
+// in the middle for good measure.
+func makeSeekable(t *testing.T, data []byte, frameSize int) []byte {
+	enc, err := zstd.NewWriter(nil)
+	must(t, err)
+	defer enc.Close()
+
+	out := new(bytes.Buffer)
+	table := new(bytes.Buffer)
+	numFrames := 0
+	writeEntry := func(compressedSize int, decompressedSize int) {
+		binary.Write(table, binary.LittleEndian, uint32(compressedSize))
+		binary.Write(table, binary.LittleEndian, uint32(decompressedSize))
+		numFrames++
+	}
+
+	for i := 0; i < len(data); i += frameSize {
+		end := i + frameSize
+		if end > len(data) {
+			end = len(data)
+		}
+		frame := enc.EncodeAll(data[i:end], nil)
+		out.Write(frame)
+		writeEntry(len(frame), end-i)
+
+		if numFrames == 2 {
+			skippable := []byte{0x50, 0x2a, 0x4d, 0x18, 4, 0, 0, 0, 'o', 'o', 'p', 's'}
+			out.Write(skippable)
+			writeEntry(len(skippable), 0)
+		}
+	}
+
+	binary.Write(table, binary.LittleEndian, uint32(numFrames))
+	table.WriteByte(0)
+	binary.Write(table, binary.LittleEndian, uint32(0x8F92EAB1))
+
+	binary.Write(out, binary.LittleEndian, uint32(0x184D2A5E))
+	binary.Write(out, binary.LittleEndian, uint32(table.Len()))
+	out.Write(table.Bytes())
+	return out.Bytes()
+}
+
+// countingReaderAt counts how many bytes were read from it
+type countingReaderAt struct {
+	r     io.ReaderAt
+	count int64
+}
+
+func (cra *countingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	n, err := cra.r.ReadAt(buf, off)
+	atomic.AddInt64(&cra.count, int64(n))
+	return n, err
+}
+
+func TestSeekable(t *testing.T) {
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+	compressed := makeSeekable(t, reference, 128*1024)
+
+	source, err := zstdsource.NewSeekable(bytes.NewReader(compressed), int64(len(compressed)))
+	must(t, err)
+	assert.EqualValues(t, len(reference), source.Size())
+	assert.Len(t, source.SeekTable().Frames, 33)
+
+	checker.RunSourceTest(t, source, reference)
+}
+
+func TestSeekableResume(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+	compressed := makeSeekable(t, reference, 128*1024)
+
+	cra := &countingReaderAt{r: bytes.NewReader(compressed)}
+	source, err := zstdsource.NewSeekable(cra, int64(len(compressed)))
+	must(t, err)
+	table := source.SeekTable()
+
+	// read up to somewhere in the middle of a frame near the end, and save there
+	var checkpoint *savior.SourceCheckpoint
+	source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			checkpoint = c
+			return nil
+		},
+	})
+	_, err = source.Resume(nil)
+	must(t, err)
+	stopAt := int64(len(reference)) - 200*1024
+	must(t, savior.DiscardByRead(source, stopAt))
+	source.WantSave()
+	buf := make([]byte, 1024)
+	_, err = source.Read(buf)
+	must(t, err)
+	if !assert.NotNil(checkpoint) {
+		return
+	}
+	assert.EqualValues(stopAt, checkpoint.OutputOffset)
+	must(t, source.Close())
+
+	// resuming in a fresh source only reads the frame we're in
+	cra = &countingReaderAt{r: bytes.NewReader(compressed)}
+	source, err = zstdsource.NewSeekable(cra, int64(len(compressed)))
+	must(t, err)
+	tableBytes := cra.count
+
+	offset, err := source.Resume(checkpoint)
+	must(t, err)
+	assert.EqualValues(stopAt, offset)
+
+	n, err := io.ReadFull(source, buf)
+	must(t, err)
+	assert.True(bytes.Equal(reference[stopAt:stopAt+int64(n)], buf[:n]))
+
+	frame := table.Frames[table.FrameAt(stopAt)]
+	assert.EqualValues(frame.CompressedSize, cra.count-tableBytes, "should only read the frame we resumed in")
+
+	// and the rest reads fine
+	rest := new(bytes.Buffer)
+	_, err = io.Copy(rest, source)
+	must(t, err)
+	assert.True(bytes.Equal(reference[stopAt+int64(n):], rest.Bytes()))
+}
+
+func TestSeekableReadAt(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(1024 * 1024)
+	compressed := makeSeekable(t, reference, 64*1024)
+
+	source, err := zstdsource.NewSeekable(bytes.NewReader(compressed), int64(len(compressed)))
+	must(t, err)
+
+	// spans a few frames, and the empty skippable one
+	buf := make([]byte, 200*1024)
+	n, err := source.ReadAt(buf, 100*1024)
+	must(t, err)
+	assert.Equal(len(buf), n)
+	assert.True(bytes.Equal(reference[100*1024:300*1024], buf))
+
+	n, err = source.ReadAt(buf, int64(len(reference))-1024)
+	assert.Equal(io.EOF, err)
+	assert.Equal(1024, n)
+}
+
+func TestNoSeekTable(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	must(t, err)
+	compressed := enc.EncodeAll(semirandom.Bytes(64*1024), nil)
+	enc.Close()
+
+	_, err = zstdsource.NewSeekable(bytes.NewReader(compressed), int64(len(compressed)))
+	assert.Equal(t, zstdsource.ErrNoSeekTable, errors.Cause(err))
+}
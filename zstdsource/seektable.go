@@ -0,0 +1,155 @@
+package zstdsource
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoSeekTable is returned when a zstd stream doesn't end with
+// a seek table, ie. it's not in the zstd seekable format
+var ErrNoSeekTable = errors.New("zstd stream has no seek table")
+
+const (
+	// the seek table is stored in a skippable frame with this magic number
+	seekTableFrameMagic = 0x184D2A5E
+	// and ends with this one
+	seekableMagic = 0x8F92EAB1
+
+	skippableHeaderSize = 8
+	seekTableFooterSize = 9
+
+	// bit 7 of the seek table descriptor: entries have a checksum
+	checksumFlag = 1 << 7
+	// bits 2-6 must be zero
+	reservedMask = 0x7c
+)
+
+// A Frame is an independently-decodable zstd frame of a seekable stream
+type Frame struct {
+	CompressedOffset   int64
+	CompressedSize     int64
+	DecompressedOffset int64
+	DecompressedSize   int64
+
+	// Checksum is the lower 32 bits of the XXH64 of the decompressed
+	// data, if the seek table has checksums (see `SeekTable.HasChecksums`)
+	Checksum uint32
+}
+
+// A SeekTable lists the frames of a stream in the zstd seekable format,
+// see https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+type SeekTable struct {
+	Frames       []Frame
+	HasChecksums bool
+
+	// Size is the size of the seek table's skippable frame, at the
+	// end of the stream
+	Size int64
+}
+
+// ReadSeekTable parses the seek table at the end of a zstd stream
+// of `size` bytes. It returns ErrNoSeekTable if there isn't one.
+func ReadSeekTable(r io.ReaderAt, size int64) (*SeekTable, error) {
+	if size < skippableHeaderSize+seekTableFooterSize {
+		return nil, errors.WithStack(ErrNoSeekTable)
+	}
+
+	footer := make([]byte, seekTableFooterSize)
+	_, err := r.ReadAt(footer, size-seekTableFooterSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading seek table footer")
+	}
+
+	if binary.LittleEndian.Uint32(footer[5:9]) != seekableMagic {
+		return nil, errors.WithStack(ErrNoSeekTable)
+	}
+
+	numFrames := int64(binary.LittleEndian.Uint32(footer[0:4]))
+	descriptor := footer[4]
+	if descriptor&reservedMask != 0 {
+		return nil, errors.Errorf("zstdsource: invalid seek table descriptor %#x", descriptor)
+	}
+
+	st := &SeekTable{
+		HasChecksums: descriptor&checksumFlag != 0,
+	}
+	entrySize := int64(8)
+	if st.HasChecksums {
+		entrySize = 12
+	}
+
+	tableSize := numFrames*entrySize + seekTableFooterSize
+	st.Size = skippableHeaderSize + tableSize
+	if st.Size > size {
+		return nil, errors.Errorf("zstdsource: seek table for %d frames doesn't fit in %d bytes", numFrames, size)
+	}
+
+	table := make([]byte, st.Size-seekTableFooterSize)
+	_, err = r.ReadAt(table, size-st.Size)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading seek table")
+	}
+
+	if binary.LittleEndian.Uint32(table[0:4]) != seekTableFrameMagic {
+		return nil, errors.New("zstdsource: seek table isn't in a skippable frame")
+	}
+	if int64(binary.LittleEndian.Uint32(table[4:8])) != tableSize {
+		return nil, errors.New("zstdsource: seek table size doesn't match its skippable frame")
+	}
+
+	var compressedOffset int64
+	var decompressedOffset int64
+	entries := table[skippableHeaderSize:]
+	for i := int64(0); i < numFrames; i++ {
+		entry := entries[i*entrySize:]
+		f := Frame{
+			CompressedOffset:   compressedOffset,
+			CompressedSize:     int64(binary.LittleEndian.Uint32(entry[0:4])),
+			DecompressedOffset: decompressedOffset,
+			DecompressedSize:   int64(binary.LittleEndian.Uint32(entry[4:8])),
+		}
+		if st.HasChecksums {
+			f.Checksum = binary.LittleEndian.Uint32(entry[8:12])
+		}
+		st.Frames = append(st.Frames, f)
+
+		compressedOffset += f.CompressedSize
+		decompressedOffset += f.DecompressedSize
+	}
+
+	if compressedOffset != size-st.Size {
+		return nil, errors.Errorf("zstdsource: seek table lists %d bytes of frames, stream has %d", compressedOffset, size-st.Size)
+	}
+
+	return st, nil
+}
+
+// CompressedSize returns the size of all frames, not counting the seek table
+func (st *SeekTable) CompressedSize() int64 {
+	if len(st.Frames) == 0 {
+		return 0
+	}
+	last := st.Frames[len(st.Frames)-1]
+	return last.CompressedOffset + last.CompressedSize
+}
+
+// DecompressedSize returns the size of the decompressed stream
+func (st *SeekTable) DecompressedSize() int64 {
+	if len(st.Frames) == 0 {
+		return 0
+	}
+	last := st.Frames[len(st.Frames)-1]
+	return last.DecompressedOffset + last.DecompressedSize
+}
+
+// FrameAt returns the index of the frame that contains the byte at
+// `offset` in the decompressed stream, or len(Frames) if it's past the end.
+func (st *SeekTable) FrameAt(offset int64) int {
+	return sort.Search(len(st.Frames), func(i int) bool {
+		f := st.Frames[i]
+		return f.DecompressedOffset+f.DecompressedSize > offset
+	})
+}
@@ -0,0 +1,282 @@
+package zstdsource
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/itchio/savior"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+type zstdSource struct {
+	// input
+	source savior.Source
+
+	// internal
+	decoder *zstd.Decoder
+	// frame being decoded, nil between frames
+	frame *frameReader
+	// set once the decoder was reset with frame
+	frameStarted bool
+	offset       int64
+	// how much was read from source
+	roffset     int64
+	initialized bool
+	wantSave    bool
+	bytebuf     []byte
+
+	ssc              savior.SourceSaveConsumer
+	sourceCheckpoint *savior.SourceCheckpoint
+}
+
+type ZstdSourceCheckpoint struct {
+	// where the frame to resume at starts in the source
+	FrameOffset      int64
+	SourceCheckpoint *savior.SourceCheckpoint
+}
+
+var _ savior.PortableChecker = (*ZstdSourceCheckpoint)(nil)
+
+// Portable returns true if the wrapped source checkpoint is portable
+func (zsc *ZstdSourceCheckpoint) Portable() bool {
+	return zsc.SourceCheckpoint.Portable()
+}
+
+var _ savior.Source = (*zstdSource)(nil)
+
+// New returns a source that decompresses a zstd stream. Since the
+// decoder's state (its window) can't be saved, checkpoints are only
+// made between frames, which are independent: a stream made of a
+// single frame can only be resumed from the start. Streams written
+// in many frames (with zstd's --block-size, or pzstd) resume nicely.
+// Dictionaries aren't supported.
+func New(source savior.Source) savior.Source {
+	return &zstdSource{
+		source:  source,
+		bytebuf: []byte{0x00},
+	}
+}
+
+func (zs *zstdSource) Features() savior.SourceFeatures {
+	return savior.SourceFeatures{
+		Name:          "zstd",
+		ResumeSupport: savior.ResumeSupportBlock,
+	}
+}
+
+func (zs *zstdSource) SetSourceSaveConsumer(ssc savior.SourceSaveConsumer) {
+	zs.ssc = ssc
+	zs.source.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(checkpoint *savior.SourceCheckpoint) error {
+			zs.sourceCheckpoint = checkpoint
+			return nil
+		},
+	})
+}
+
+// WantSave makes the source save at the start of the next frame
+func (zs *zstdSource) WantSave() {
+	zs.wantSave = true
+}
+
+func (zs *zstdSource) Resume(checkpoint *savior.SourceCheckpoint) (int64, error) {
+	// the decoder reads the source from its own goroutine,
+	// stop it before the source moves
+	zs.closeDecoder()
+	zs.frame = nil
+	zs.wantSave = false
+	zs.sourceCheckpoint = nil
+
+	if checkpoint != nil {
+		if ourCheckpoint, ok := checkpoint.Data.(*ZstdSourceCheckpoint); ok {
+			sourceOffset, err := zs.source.Resume(ourCheckpoint.SourceCheckpoint)
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+
+			if sourceOffset < ourCheckpoint.FrameOffset {
+				delta := ourCheckpoint.FrameOffset - sourceOffset
+				savior.Debugf(`zstdsource: discarding %d bytes to align source with frame`, delta)
+				err = savior.DiscardByRead(zs.source, delta)
+				if err != nil {
+					return 0, errors.WithStack(err)
+				}
+				sourceOffset += delta
+			}
+
+			if sourceOffset == ourCheckpoint.FrameOffset {
+				zs.roffset = sourceOffset
+				zs.offset = checkpoint.OutputOffset
+				zs.initialized = true
+				return zs.offset, nil
+			}
+			savior.Debugf(`zstdsource: expected source to resume at %d but got %d`, ourCheckpoint.FrameOffset, sourceOffset)
+		}
+	}
+
+	// start from beginning
+	sourceOffset, err := zs.source.Resume(nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if sourceOffset != 0 {
+		msg := fmt.Sprintf("zstdsource: expected source to resume at start but got %d", sourceOffset)
+		return 0, errors.New(msg)
+	}
+
+	zs.roffset = 0
+	zs.offset = 0
+	zs.initialized = true
+	return 0, nil
+}
+
+func (zs *zstdSource) Read(buf []byte) (int, error) {
+	if !zs.initialized {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	for {
+		if zs.frame == nil {
+			frameOffset := zs.roffset
+			if zs.wantSave {
+				// so that the source saves right at the frame, if it can
+				zs.source.WantSave()
+			}
+
+			header, err := readFrameHeader(&countingReader{zs})
+			if err != nil {
+				return 0, err
+			}
+			zs.frame = newFrameReader(&countingReader{zs}, header)
+			zs.frameStarted = false
+
+			if zs.wantSave {
+				saved, err := zs.save(frameOffset)
+				if saved || err != nil {
+					// the checkpoint may have been resumed from,
+					// nothing is left to do
+					return 0, err
+				}
+			}
+		}
+
+		if !zs.frameStarted {
+			err := zs.startFrame()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := zs.decoder.Read(buf)
+		zs.offset += int64(n)
+		if err == io.EOF {
+			zs.frame = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if err != nil {
+			return n, errors.WithStack(err)
+		}
+		return n, nil
+	}
+}
+
+// save emits a checkpoint at the start of the frame at `frameOffset`, if
+// the source saved at or before it. It returns whether it did.
+func (zs *zstdSource) save(frameOffset int64) (bool, error) {
+	if zs.ssc == nil {
+		savior.Debugf("zstdsource: can't save, ssc is nil!")
+		return false, nil
+	}
+	if zs.sourceCheckpoint == nil || zs.sourceCheckpoint.Offset > frameOffset {
+		// we'll get another chance at the next frame
+		return false, nil
+	}
+
+	checkpoint := &savior.SourceCheckpoint{
+		Offset:       frameOffset,
+		OutputOffset: zs.offset,
+		Data: &ZstdSourceCheckpoint{
+			FrameOffset:      frameOffset,
+			SourceCheckpoint: zs.sourceCheckpoint,
+		},
+	}
+	zs.sourceCheckpoint = nil
+	zs.wantSave = false
+
+	savior.Debugf("zstdsource: saving at frame starting at %d, output byte %d", frameOffset, zs.offset)
+	return true, zs.ssc.Save(checkpoint)
+}
+
+func (zs *zstdSource) startFrame() error {
+	if zs.decoder == nil {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		zs.decoder = decoder
+	}
+
+	err := zs.decoder.Reset(zs.frame)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	zs.frameStarted = true
+	return nil
+}
+
+func (zs *zstdSource) closeDecoder() {
+	if zs.decoder != nil {
+		zs.decoder.Close()
+		zs.decoder = nil
+	}
+}
+
+func (zs *zstdSource) ReadByte() (byte, error) {
+	if !zs.initialized {
+		return 0, errors.WithStack(savior.ErrUninitializedSource)
+	}
+
+	for {
+		// Read returns nothing when it saves, read again
+		n, err := zs.Read(zs.bytebuf)
+		if n == 1 {
+			return zs.bytebuf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (zs *zstdSource) Progress() float64 {
+	// We can't tell how large the uncompressed stream is until we finish
+	// decompressing it. The underlying's source progress is a good enough
+	// approximation.
+	return zs.source.Progress()
+}
+
+// Close releases the decoder and closes the underlying source
+func (zs *zstdSource) Close() error {
+	zs.closeDecoder()
+	return zs.source.Close()
+}
+
+// countingReader reads from the source, keeping track of roffset
+type countingReader struct {
+	zs *zstdSource
+}
+
+func (cr *countingReader) Read(buf []byte) (int, error) {
+	n, err := cr.zs.source.Read(buf)
+	cr.zs.roffset += int64(n)
+	return n, err
+}
+
+func init() {
+	savior.RegisterCheckpointData("zstdsource.ZstdSourceCheckpoint", &ZstdSourceCheckpoint{})
+}
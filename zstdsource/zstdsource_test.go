@@ -0,0 +1,122 @@
+package zstdsource_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/checker"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/savior/semirandom"
+	"github.com/itchio/savior/zstdsource"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeFrames compresses data as one zstd frame per `frameSize` bytes,
+// with a skippable frame after the first one
+func makeFrames(t *testing.T, data []byte, frameSize int) []byte {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderCRC(true))
+	must(t, err)
+	defer enc.Close()
+
+	var out []byte
+	for i := 0; i < len(data); i += frameSize {
+		end := i + frameSize
+		if end > len(data) {
+			end = len(data)
+		}
+		out = enc.EncodeAll(data[i:end], out)
+
+		if i == 0 {
+			out = append(out, 0x5f, 0x2a, 0x4d, 0x18, 4, 0, 0, 0, 'o', 'o', 'p', 's')
+		}
+	}
+	return out
+}
+
+func Test_Uninitialized(t *testing.T) {
+	ss := seeksource.FromBytes(nil)
+	_, err := ss.Resume(nil)
+	assert.NoError(t, err)
+
+	zs := zstdsource.New(ss)
+	_, err = zs.Read([]byte{})
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+
+	_, err = zs.ReadByte()
+	assert.Error(t, err)
+	assert.True(t, errors.Cause(err) == savior.ErrUninitializedSource)
+}
+
+func Test_Checkpoints(t *testing.T) {
+	reference := semirandom.Bytes(8 * 1024 * 1024)
+	// long runs of zeroes make for RLE blocks
+	for i := 0; i < len(reference); i += 1024 * 1024 {
+		copy(reference[i:i+256*1024], make([]byte, 256*1024))
+	}
+	compressed := makeFrames(t, reference, 512*1024)
+
+	zs := zstdsource.New(seeksource.FromBytes(compressed))
+	checker.RunSourceTest(t, zs, reference)
+}
+
+func Test_SingleFrame(t *testing.T) {
+	assert := assert.New(t)
+
+	reference := semirandom.Bytes(4 * 1024 * 1024)
+	copy(reference[1024*1024:], make([]byte, 1024*1024))
+
+	compressed := new(bytes.Buffer)
+	enc, err := zstd.NewWriter(compressed, zstd.WithEncoderCRC(true))
+	must(t, err)
+	_, err = enc.Write(reference)
+	must(t, err)
+	must(t, enc.Close())
+
+	zs := zstdsource.New(seeksource.FromBytes(compressed.Bytes()))
+	var checkpoints []*savior.SourceCheckpoint
+	zs.SetSourceSaveConsumer(&savior.CallbackSourceSaveConsumer{
+		OnSave: func(c *savior.SourceCheckpoint) error {
+			checkpoints = append(checkpoints, c)
+			return nil
+		},
+	})
+	_, err = zs.Resume(nil)
+	must(t, err)
+
+	// there are no frame boundaries to save at, past the first one
+	zs.WantSave()
+	_, err = zs.ReadByte()
+	must(t, err)
+	zs.WantSave()
+
+	data, err := ioutil.ReadAll(zs)
+	must(t, err)
+	assert.True(bytes.Equal(reference[1:], data))
+	assert.Len(checkpoints, 1)
+	assert.EqualValues(0, checkpoints[0].OutputOffset)
+	must(t, zs.Close())
+}
+
+func Test_Corrupted(t *testing.T) {
+	reference := semirandom.Bytes(1024 * 1024)
+	compressed := makeFrames(t, reference, 256*1024)
+
+	// truncated in the middle of a frame
+	zs := zstdsource.New(seeksource.FromBytes(compressed[:len(compressed)/2]))
+	_, err := zs.Resume(nil)
+	must(t, err)
+	_, err = ioutil.ReadAll(zs)
+	assert.Error(t, err)
+
+	// not zstd at all
+	zs = zstdsource.New(seeksource.FromBytes(reference))
+	_, err = zs.Resume(nil)
+	must(t, err)
+	_, err = ioutil.ReadAll(zs)
+	assert.Error(t, err)
+}